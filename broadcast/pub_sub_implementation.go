@@ -1,14 +1,18 @@
-package session
+package broadcast
 
 import (
 	"fmt"
 	"sync"
+
+	"github.com/MarcusOuelletus/demo/modules/logger"
 )
 
 // since go does not have a standard library option for pub/sub, only single listener channels, this is my implementation for a project of mine.
 
 type ResponseBroadcaster struct {
 	sync.Mutex
+	// Logger gets the notifications nobody took at debug level, nil logs nothing.
+	Logger    logger.Logger
 	listeners map[uint16]*ListenerNode
 }
 
@@ -25,6 +29,7 @@ func NewResponseBroadcaster() *ResponseBroadcaster {
 func (r *ResponseBroadcaster) AddListener(id uint16, ch chan uint16) error {
 	r.Lock()
 	if _, ok := r.listeners[id]; ok {
+		r.Unlock()
 		return fmt.Errorf("packet id %d already has a listener", id)
 	}
 
@@ -55,3 +60,38 @@ func (r *ResponseBroadcaster) RemoveAndCloseListener(id uint16, ch chan uint16)
 		node.Unlock()
 	}
 }
+
+// Len is the number of packet ids with a listener.
+func (r *ResponseBroadcaster) Len() int {
+	r.Lock()
+	defer r.Unlock()
+
+	return len(r.listeners)
+}
+
+// Notify sends value to the listener of id without blocking, it returns false when there is no open listener or its
+// channel is full.
+func (r *ResponseBroadcaster) Notify(id uint16, value uint16) bool {
+	node, ok := r.GetListener(id)
+
+	if !ok {
+		logger.Or(r.Logger).Debug("broadcaster: no listener", logger.Fields{logger.PacketIDField: id})
+		return false
+	}
+
+	node.Lock()
+	defer node.Unlock()
+
+	if node.closed {
+		logger.Or(r.Logger).Debug("broadcaster: listener closed", logger.Fields{logger.PacketIDField: id})
+		return false
+	}
+
+	select {
+	case node.ch <- value:
+		return true
+	default:
+		logger.Or(r.Logger).Debug("broadcaster: listener is full", logger.Fields{logger.PacketIDField: id})
+		return false
+	}
+}
@@ -0,0 +1,93 @@
+package broadcast
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+/*
+The benchmarks follow a packet id through the broadcaster the way a client flow does: a listener is added before the
+packet goes out, the read loop notifies it once the acknowledgement comes in, and the listener is removed and closed
+when the flow ends.
+*/
+
+func BenchmarkAddRemoveListener(b *testing.B) {
+	var r = NewResponseBroadcaster()
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		var id = uint16(i)
+		var ch = make(chan uint16, 1)
+
+		if err := r.AddListener(id, ch); err != nil {
+			b.Fatal(err)
+		}
+
+		r.RemoveAndCloseListener(id, ch)
+	}
+}
+
+func BenchmarkNotify(b *testing.B) {
+	var r = NewResponseBroadcaster()
+	var ch = make(chan uint16, 1)
+
+	if err := r.AddListener(1, ch); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if !r.Notify(1, uint16(i)) {
+			b.Fatal("notify was not taken")
+		}
+
+		<-ch
+	}
+}
+
+// BenchmarkNotifyNoListener is the read loop acknowledging an id nobody waits for anymore, a late or duplicate ack.
+func BenchmarkNotifyNoListener(b *testing.B) {
+	var r = NewResponseBroadcaster()
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		r.Notify(1, 1)
+	}
+}
+
+// BenchmarkFlowParallel runs whole flows (add, notify, receive, remove) from many goroutines on one broadcaster, each
+// goroutine on ids of its own, so they only contend on the broadcaster's lock.
+func BenchmarkFlowParallel(b *testing.B) {
+	var r = NewResponseBroadcaster()
+	var goroutines atomic.Uint32
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		var base = uint16(goroutines.Add(1)) << 8
+		var ch = make(chan uint16, 1)
+		var i uint16
+
+		for pb.Next() {
+			var id = base | i&0xff
+
+			i++
+
+			if err := r.AddListener(id, ch); err != nil {
+				b.Error(err)
+				return
+			}
+
+			r.Notify(id, id)
+			<-ch
+
+			// -- RemoveAndCloseListener closes the channel, the next flow needs a new one
+			r.RemoveAndCloseListener(id, ch)
+			ch = make(chan uint16, 1)
+			// --
+		}
+	})
+}
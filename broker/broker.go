@@ -0,0 +1,530 @@
+package broker
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/clock"
+	"github.com/MarcusOuelletus/demo/modules/logger"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/reasoncodes"
+	"github.com/MarcusOuelletus/demo/session"
+	"github.com/MarcusOuelletus/demo/topictrie"
+)
+
+/*
+Broker is a minimal embeddable MQTT broker for 3.1.1 and 5 clients built from the same pieces the client uses:
+
+ - The topic trie holds every subscription, keyed by filter with the client id as the user, routing a PUBLISH is one
+   MatchEach over it. A client with several matching filters gets the message once, with the highest granted QoS.
+ - Shared subscriptions ($share/{group}/{filter}) hand every message to one member of the group, picked by its
+   ShareStrategy.
+ - The SessionRegistry keeps one live connection per client id, a second CONNECT with the same id takes the session
+   over and the first connection is dropped (with Session taken over on MQTT 5).
+ - Sessions outlive their connection for the Session Expiry Interval (CleanSession = 0 on 3.1.1 keeps them forever),
+   the SessionLifecycle runs that timer and the Will Delay Interval. While a session is offline its QoS 1 and 2
+   messages are queued, within the limits of the session and the broker's QueueMemory, QoS 0 messages are dropped. A SessionStore in Options.Sessions
+   keeps their subscriptions across restarts and brokers.
+ - QoS 1 and 2 messages that wait in a queue are dropped once their Message Expiry Interval elapsed, the ones that
+   go out carry the interval they have left.
+ - PacketIDs, OutboundQoS2Flow and InboundQoS2Flow run the QoS 1 and 2 flows per session, unacknowledged messages
+   are sent again with DUP when the session reconnects. An inbound QoS 2 message is routed when its PUBLISH arrives
+   and acknowledged again, not routed again, until its PUBREL.
+ - The messages of a publisher to a topic reach every subscriber in the order they were published, across QoS levels,
+   see brokerSession.
+
+Messages to one session are handed to its connection's outbound queue under the session's lock, a writer goroutine per
+connection puts them on the wire, so a subscriber that stops reading never holds up the goroutine that routes to it.
+*/
+
+var ErrBrokerClosed = errors.New("broker closed")
+
+var (
+	DefaultConnectTimeout      = 10 * time.Second
+	DefaultMaxQueuedMessages   = 1000
+	DefaultReceiveMaximum      = uint16(100)
+	DefaultExpiryCheckInterval = time.Second
+	DefaultOutboundQueue       = 1024
+	DefaultTopicAliasMaximum   = uint16(16)
+)
+
+type Options struct {
+	// ConnectTimeout is how long a new connection has to send its CONNECT.
+	ConnectTimeout time.Duration
+	// MaxQueuedMessages caps the messages queued for one session unless its ClientLimits have their own cap.
+	MaxQueuedMessages int
+	// QueueMemory caps the bytes queued by every session together, 0 is no limit.
+	QueueMemory int64
+	// ReceiveMaximum is the number of unacknowledged QoS 1 and 2 messages a client may send, it is sent in CONNACK.
+	ReceiveMaximum uint16
+	// MaxPacketSize rejects larger packets from clients, 0 allows any size.
+	MaxPacketSize int
+	// OutboundQueue is the number of packets queued for the writer of one connection, 0 is DefaultOutboundQueue.
+	// SlowConsumer says what happens to a message for a connection whose queue is full.
+	OutboundQueue int
+	SlowConsumer  SlowConsumerPolicy
+	// MaximumQoS is the highest QoS clients may publish with and are granted, nil supports all three. It is sent in the
+	// CONNACK, a client that publishes above it is disconnected with QoS not supported.
+	MaximumQoS *byte
+	// TopicAliasMaximum is the number of topic aliases an MQTT 5 client may set up on its connection, it is sent in the
+	// CONNACK. nil is DefaultTopicAliasMaximum, 0 accepts none. A client that uses an alias it did not set up, or one
+	// above the maximum, is disconnected with Topic Alias invalid.
+	TopicAliasMaximum *uint16
+	// ServerKeepAlive replaces the Keep Alive of every MQTT 5 client, it is sent in CONNACK. 0 keeps the client's.
+	ServerKeepAlive time.Duration
+	// Retained keeps the retained messages, nil keeps them in memory. The broker does not close it.
+	Retained *RetainedStore
+	// Sessions keeps the subscriptions of the sessions that outlive their connection, nil keeps them in memory only.
+	Sessions session.SessionStore
+	// Authenticator checks the credentials of every CONNECT, nil lets every client in.
+	Authenticator Authenticator
+	// Authorizer checks every publish, subscribe and delivery, nil allows all of them.
+	Authorizer Authorizer
+	// SysInterval is how often the $SYS topics are published, 0 publishes none.
+	SysInterval time.Duration
+	// Limits applies to every client, LimitsFor (when set) picks the limits of each client instead.
+	Limits    ClientLimits
+	LimitsFor func(client ClientInfo) ClientLimits
+	// ShareStrategy picks the member of a shared subscription group that gets a message, ShareStrategyFor (when set)
+	// picks the strategy of each group instead.
+	ShareStrategy    ShareStrategy
+	ShareStrategyFor func(group, filter string) ShareStrategy
+	// Hooks are told about every connection, subscription and message, in order.
+	Hooks []Hook
+	// ExpiryCheckInterval is how often messages whose Message Expiry Interval elapsed are dropped from the session
+	// queues and the retained store.
+	ExpiryCheckInterval time.Duration
+	// Clock is the time of the session and message expiry, the Will Delay, the rate limits and $SYS, nil is
+	// clock.Real. A Retained store without a Clock of its own gets this one.
+	Clock clock.Clock
+	// Logger gets the connections, the refused ones and the failures of the SessionStore, nil logs nothing. The broker
+	// logs as logger.Broker, its tries as logger.Trie and the packets of its connections as logger.Codec.
+	Logger logger.Logger
+}
+
+type Broker struct {
+	options  Options
+	registry *session.SessionRegistry
+
+	mu            sync.RWMutex
+	sessions      map[string]*brokerSession
+	subscriptions *topictrie.Trie[subscription]
+	// shared holds the shared subscription groups, keyed by filter with the group name as the user.
+	shared *topictrie.Trie[shareGroup]
+	// filters counts the subscriptions of every filter, a cluster replicates the filters in it.
+	filters   map[string]int
+	cluster   *Cluster
+	listeners map[net.Listener]struct{}
+	conns     map[*conn]struct{}
+	banned    map[string]struct{}
+	// traced holds the TraceOptions of TraceClient, traceMu guards it and the tracers of the connections.
+	traced  map[string]mqttcodec.TraceOptions
+	traceMu sync.Mutex
+	memory  queueMemory
+	log     logger.Logger
+	closed  bool
+	done    chan struct{}
+
+	stats brokerStats
+	// sys publishes the $SYS topics, nil without Options.SysInterval.
+	sys *sysPublisher
+}
+
+// subscription is what the trie holds for a client id under a filter.
+type subscription struct {
+	session *brokerSession
+	session.Subscription
+}
+
+// noLocal is the No Local option of sub, route leaves the publisher's own subscriptions with it out.
+func noLocal(sub *subscription) bool {
+	return sub.NoLocal
+}
+
+// delivery is the QoS and the RETAIN flag p is forwarded with to sub. The QoS is capped at the one granted, RETAIN is
+// kept only for a subscription with Retain As Published, to the others a message routed as it is published is not a
+// retained one. The retained messages sent on subscribe always have it, see retained.
+func (sub *subscription) delivery(p *mqttcodec.Publish) (qos byte, retain bool) {
+	return min(p.QoS, sub.GrantedQoS), p.Retain && sub.RetainAsPublished
+}
+
+func New(options Options) *Broker {
+	if options.ConnectTimeout <= 0 {
+		options.ConnectTimeout = DefaultConnectTimeout
+	}
+
+	if options.MaxQueuedMessages <= 0 {
+		options.MaxQueuedMessages = DefaultMaxQueuedMessages
+	}
+
+	if options.ReceiveMaximum == 0 {
+		options.ReceiveMaximum = DefaultReceiveMaximum
+	}
+
+	if options.Retained == nil {
+		options.Retained = NewRetainedStore()
+	}
+
+	if options.OutboundQueue <= 0 {
+		options.OutboundQueue = DefaultOutboundQueue
+	}
+
+	if options.ExpiryCheckInterval <= 0 {
+		options.ExpiryCheckInterval = DefaultExpiryCheckInterval
+	}
+
+	options.Clock = clock.Or(options.Clock)
+
+	if options.Retained.Clock == nil {
+		options.Retained.Clock = options.Clock
+	}
+
+	var b = &Broker{
+		options:       options,
+		registry:      session.NewSessionRegistry(),
+		sessions:      make(map[string]*brokerSession),
+		subscriptions: topictrie.New[subscription](),
+		shared:        topictrie.New[shareGroup](),
+		filters:       make(map[string]int),
+		listeners:     make(map[net.Listener]struct{}),
+		conns:         make(map[*conn]struct{}),
+		banned:        make(map[string]struct{}),
+		traced:        make(map[string]mqttcodec.TraceOptions),
+		done:          make(chan struct{}),
+	}
+
+	b.memory.limit = options.QueueMemory
+
+	b.log = logger.Or(logger.For(options.Logger, logger.Broker))
+	b.subscriptions.Logger = logger.For(options.Logger, logger.Trie)
+	b.shared.Logger = b.subscriptions.Logger
+
+	go b.runExpiry(options.ExpiryCheckInterval, b.done)
+
+	if options.SysInterval > 0 {
+		b.sys = newSysPublisher(b, options.SysInterval)
+		go b.runSys(b.done)
+	}
+
+	return b
+}
+
+// ListenAndServe listens on the TCP address and serves it until Close.
+func (b *Broker) ListenAndServe(address string) error {
+	l, err := net.Listen("tcp", address)
+
+	if err != nil {
+		return err
+	}
+
+	return b.Serve(l)
+}
+
+// Close stops the listeners and drops every connection, sessions are not kept beyond the process, see Shutdown.
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	if !b.closed {
+		close(b.done)
+	}
+	b.closed = true
+	var listeners, conns = b.listeners, b.conns
+	b.listeners, b.conns = make(map[net.Listener]struct{}), make(map[*conn]struct{})
+	b.mu.Unlock()
+
+	for l := range listeners {
+		l.Close()
+	}
+
+	for c := range conns {
+		c.kick(byte(reasoncodes.ServerShuttingDown))
+	}
+
+	return nil
+}
+
+// Publish routes a message from the embedding application to the subscribers of topic.
+func (b *Broker) Publish(topic string, payload []byte, qos byte, retain bool) error {
+	if qos > 2 {
+		return fmt.Errorf("invalid qos %d", qos)
+	}
+
+	// -- the message only gets a packet id per subscriber, it is built as QoS 0 to pass validation
+	p, err := mqttcodec.NewPublishBuilder(topic).Payload(payload).Retain(retain).Build()
+
+	if err != nil {
+		return err
+	}
+
+	p.QoS = qos
+	// --
+
+	return b.route(p, "")
+}
+
+// maximumQoS is the highest QoS the broker supports.
+func (b *Broker) maximumQoS() byte {
+	if b.options.MaximumQoS == nil || *b.options.MaximumQoS > 2 {
+		return 2
+	}
+
+	return *b.options.MaximumQoS
+}
+
+func (b *Broker) topicAliasMaximum() uint16 {
+	if b.options.TopicAliasMaximum == nil {
+		return DefaultTopicAliasMaximum
+	}
+
+	return *b.options.TopicAliasMaximum
+}
+
+// route hands p to every session subscribed to its topic, from is the client id of the publisher. A retained message
+// is routed even when the store failed to keep it, the error is returned anyway.
+func (b *Broker) route(p *mqttcodec.Publish, from string) error {
+	var err error
+
+	if p.Retain {
+		err = b.options.Retained.Set(p)
+	}
+
+	type target struct {
+		session *brokerSession
+		qos     byte
+		retain  bool
+	}
+
+	var targets = make(map[string]*target)
+	var groups []*shareGroup
+
+	b.mu.RLock()
+	var cluster = b.cluster
+	b.subscriptions.MatchEachExcluding(p.TopicName, from, noLocal, func(clientID string, sub *subscription) {
+		var qos, retain = sub.delivery(p)
+
+		// -- overlapping subscriptions of one client get the message once, with the highest QoS
+		if t, ok := targets[clientID]; ok {
+			t.qos = max(t.qos, qos)
+			t.retain = t.retain || retain
+			return
+		}
+		// --
+
+		targets[clientID] = &target{session: sub.session, qos: qos, retain: retain}
+	})
+	b.shared.MatchEach(p.TopicName, func(_ string, g *shareGroup) {
+		groups = append(groups, g)
+	})
+	b.mu.RUnlock()
+
+	var deliveries = make([]*target, 0, len(targets)+len(groups))
+
+	for _, t := range targets {
+		if t.session.local == nil && !b.canReceive(t.session.info(), p.TopicName) {
+			continue
+		}
+
+		deliveries = append(deliveries, t)
+	}
+
+	// -- a group's member was allowed to receive the message when it was picked
+	for _, g := range groups {
+		var sub = g.pick(b, p.TopicName)
+
+		if sub == nil {
+			continue
+		}
+
+		var qos, retain = sub.delivery(p)
+
+		deliveries = append(deliveries, &target{session: sub.session, qos: qos, retain: retain})
+	}
+	// --
+
+	for _, t := range deliveries {
+
+		var out = *p
+		out.QoS, out.Retain, out.Dup, out.PacketID = t.qos, t.retain, false, 0
+		out.Properties = publishProperties(p.Properties)
+
+		if t.session.local == nil {
+			b.delivered(t.session.info(), &out)
+		}
+
+		t.session.send(&out)
+	}
+
+	if cluster != nil && from != clusterClientID && from != sysClientID {
+		cluster.forward(p)
+	}
+
+	return err
+}
+
+// publishProperties are the properties of an inbound PUBLISH that are forwarded, a topic alias only means something
+// on the connection it came in on.
+func publishProperties(p *mqttcodec.Properties) *mqttcodec.Properties {
+	if p == nil {
+		return nil
+	}
+
+	var out = *p
+	out.TopicAlias = nil
+	out.SubscriptionIdentifiers = nil
+
+	return &out
+}
+
+// subscribe adds or replaces the subscription of s to sub.Filter, it returns whether it replaced one.
+func (b *Broker) subscribe(s *brokerSession, sub session.Subscription) bool {
+	b.mu.Lock()
+	b.addSubscription(s, sub)
+	b.mu.Unlock()
+
+	s.Lock()
+	_, existed := s.subscriptions[sub.Filter]
+	s.subscriptions[sub.Filter] = sub
+	s.Unlock()
+
+	return existed
+}
+
+// addSubscription puts sub into the trie, or into its group for a shared subscription, b.mu is held. The filters of
+// shared subscriptions are counted without their $share prefix.
+func (b *Broker) addSubscription(s *brokerSession, sub session.Subscription) {
+	var group, filter, shared = splitShared(sub.Filter)
+	var added bool
+
+	if shared {
+		added = b.joinShared(group, filter, &subscription{session: s, Subscription: sub})
+	} else {
+		added = b.subscriptions.Get(filter)[s.clientID] == nil
+		b.subscriptions.Add(filter, s.clientID, &subscription{session: s, Subscription: sub})
+	}
+
+	if !added {
+		return
+	}
+
+	b.filters[filter]++
+
+	if b.filters[filter] == 1 && b.cluster != nil {
+		b.cluster.filterAdded(filter)
+	}
+}
+
+// removeSubscription takes the subscription of clientID to full out of the trie or its group, b.mu is held.
+func (b *Broker) removeSubscription(full, clientID string) {
+	var group, filter, shared = splitShared(full)
+	var removed bool
+
+	if shared {
+		removed = b.leaveShared(group, filter, clientID)
+	} else {
+		removed = b.subscriptions.Remove(filter, clientID) != nil
+	}
+
+	if !removed {
+		return
+	}
+
+	if b.filters[filter]--; b.filters[filter] > 0 {
+		return
+	}
+
+	delete(b.filters, filter)
+
+	if b.cluster != nil {
+		b.cluster.filterRemoved(filter)
+	}
+}
+
+// subscriptionOf returns the subscription of clientID to full, b.mu is held.
+func (b *Broker) subscriptionOf(full, clientID string) *subscription {
+	if group, filter, shared := splitShared(full); shared {
+		return b.sharedMember(group, filter, clientID)
+	}
+
+	return b.subscriptions.Get(full)[clientID]
+}
+
+// subscribeLocal subscribes fn to sub.Filter for clientID, a client inside the process. fn runs on the goroutine of the
+// publisher, removeSession of the returned session ends the subscription.
+func (b *Broker) subscribeLocal(clientID string, sub session.Subscription, fn func(p *mqttcodec.Publish)) *brokerSession {
+	var s = newBrokerSession(clientID, 0, b.options.Clock)
+	s.local = fn
+
+	b.subscribe(s, sub)
+
+	for _, p := range b.retained(sub, false) {
+		fn(p)
+	}
+
+	return s
+}
+
+// retained returns the retained messages sub gets when it is made, existed tells whether it replaced a subscription.
+func (b *Broker) retained(sub session.Subscription, existed bool) []*mqttcodec.Publish {
+	if _, _, shared := splitShared(sub.Filter); shared || sub.RetainHandling == 2 || (sub.RetainHandling == 1 && existed) {
+		return nil
+	}
+
+	var messages = b.options.Retained.Match(sub.Filter)
+
+	for i, p := range messages {
+		var out = *p
+		out.Properties = publishProperties(p.Properties)
+
+		if sub.GrantedQoS < out.QoS {
+			out.QoS = sub.GrantedQoS
+		}
+
+		messages[i] = &out
+	}
+
+	return messages
+}
+
+// unsubscribe removes the subscription of s to filter and returns whether there was one.
+func (b *Broker) unsubscribe(s *brokerSession, filter string) bool {
+	s.Lock()
+	_, ok := s.subscriptions[filter]
+	delete(s.subscriptions, filter)
+	s.Unlock()
+
+	if ok {
+		b.mu.Lock()
+		b.removeSubscription(filter, s.clientID)
+		b.mu.Unlock()
+	}
+
+	return ok
+}
+
+// removeSession drops s and its subscriptions, unless another session took its place in the meantime.
+func (b *Broker) removeSession(s *brokerSession) {
+	s.Lock()
+	var filters = make([]string, 0, len(s.subscriptions))
+
+	for filter := range s.subscriptions {
+		filters = append(filters, filter)
+	}
+	s.subscriptions = make(map[string]session.Subscription)
+	s.clearQueue()
+	s.Unlock()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, filter := range filters {
+		if sub := b.subscriptionOf(filter, s.clientID); sub != nil && sub.session == s {
+			b.removeSubscription(filter, s.clientID)
+		}
+	}
+
+	if b.sessions[s.clientID] == s {
+		delete(b.sessions, s.clientID)
+	}
+}
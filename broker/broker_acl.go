@@ -0,0 +1,263 @@
+package broker
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/MarcusOuelletus/demo/topictrie"
+)
+
+/*
+ACL is an Authorizer reading mosquitto's ACL file format:
+
+	# comment
+	topic read public/#
+	user alice
+	topic readwrite alice/#
+	topic deny alice/private
+	pattern write devices/%c/status
+
+A topic line grants read, write, readwrite (the default when the access is left out) or deny on a filter to the user
+of the user line above it, topic lines before the first user line are for clients without a username. Pattern lines
+apply to every client, %c is replaced by its client id and %u by its username (a pattern is skipped for a client whose
+id or username has a wildcard in it). Deny wins over any grant.
+
+The topic lines live in a trie keyed by filter with the username as the user, so checking a topic is one MatchEach like
+routing a PUBLISH. A SUBSCRIBE is allowed when a read rule covers every topic the filter can match and no deny rule
+does, a deny rule for a part of the filter is enforced on every delivery with CanReceive.
+*/
+
+type aclAccess byte
+
+const (
+	aclRead aclAccess = 1 << iota
+	aclWrite
+	aclDeny
+)
+
+type ACL struct {
+	sync.RWMutex
+	path     string
+	rules    *topictrie.Trie[aclRule]
+	patterns []aclPattern
+}
+
+type aclRule struct {
+	filter string
+	access aclAccess
+}
+
+type aclPattern struct {
+	filter string
+	access aclAccess
+}
+
+// NewACL reads the ACL file at path.
+func NewACL(path string) (*ACL, error) {
+	var a = &ACL{path: path}
+
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// Reload reads the file again, a malformed file leaves the rules that were loaded before.
+func (a *ACL) Reload() error {
+	file, err := os.Open(a.path)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	var rules = topictrie.New[aclRule]()
+	var patterns []aclPattern
+	var username string
+	var scanner = bufio.NewScanner(file)
+
+	for line := 1; scanner.Scan(); line++ {
+		var text = strings.TrimSpace(scanner.Text())
+
+		if text == "" || text[0] == '#' {
+			continue
+		}
+
+		keyword, rest, _ := strings.Cut(text, " ")
+		rest = strings.TrimSpace(rest)
+
+		switch keyword {
+		case "user":
+			if rest == "" {
+				return fmt.Errorf("%s:%d: user without a username", a.path, line)
+			}
+			username = rest
+		case "topic", "pattern":
+			access, filter, err := parseACLRule(rest)
+
+			if err != nil {
+				return fmt.Errorf("%s:%d: %w", a.path, line, err)
+			}
+
+			if keyword == "pattern" {
+				patterns = append(patterns, aclPattern{filter: filter, access: access})
+				continue
+			}
+
+			if existing := rules.Get(filter)[username]; existing != nil {
+				existing.access |= access
+				continue
+			}
+
+			rules.Add(filter, username, &aclRule{filter: filter, access: access})
+		default:
+			return fmt.Errorf("%s:%d: unknown keyword %q", a.path, line, keyword)
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+
+	a.Lock()
+	a.rules, a.patterns = rules, patterns
+	a.Unlock()
+
+	return nil
+}
+
+func parseACLRule(rest string) (aclAccess, string, error) {
+	var access = aclRead | aclWrite
+
+	if word, filter, ok := strings.Cut(rest, " "); ok {
+		switch word {
+		case "read":
+			access = aclRead
+		case "write":
+			access = aclWrite
+		case "readwrite":
+		case "deny":
+			access = aclDeny
+		default:
+			return 0, "", fmt.Errorf("unknown access %q", word)
+		}
+
+		rest = strings.TrimSpace(filter)
+	}
+
+	if rest == "" {
+		return 0, "", fmt.Errorf("rule without a topic")
+	}
+
+	return access, rest, nil
+}
+
+func (a *ACL) CanPublish(client ClientInfo, topic string) bool {
+	return a.allowed(client, topic, aclWrite)
+}
+
+func (a *ACL) CanReceive(client ClientInfo, topic string) bool {
+	return a.allowed(client, topic, aclRead)
+}
+
+func (a *ACL) CanSubscribe(client ClientInfo, filter string) bool {
+	var granted, denied bool
+
+	a.each(client, filter, func(ruleFilter string, access aclAccess) {
+		if !filterCovers(ruleFilter, filter) {
+			return
+		}
+
+		denied = denied || access&aclDeny != 0
+		granted = granted || access&aclRead != 0
+	})
+
+	return granted && !denied
+}
+
+// allowed reports whether a rule matching topic grants want and none denies it.
+func (a *ACL) allowed(client ClientInfo, topic string, want aclAccess) bool {
+	var access aclAccess
+
+	a.each(client, topic, func(ruleFilter string, ruleAccess aclAccess) {
+		if topicMatches(ruleFilter, topic) {
+			access |= ruleAccess
+		}
+	})
+
+	return access&aclDeny == 0 && access&want != 0
+}
+
+// each calls fn with the rules of client that may apply to name, fn still has to check them against name.
+func (a *ACL) each(client ClientInfo, name string, fn func(filter string, access aclAccess)) {
+	a.RLock()
+	defer a.RUnlock()
+
+	a.rules.MatchEach(name, func(username string, rule *aclRule) {
+		if username == client.Username {
+			fn(rule.filter, rule.access)
+		}
+	})
+
+	if strings.ContainsAny(client.ClientID, "+#") || strings.ContainsAny(client.Username, "+#") {
+		return
+	}
+
+	var replacer = strings.NewReplacer("%c", client.ClientID, "%u", client.Username)
+
+	for _, p := range a.patterns {
+		fn(replacer.Replace(p.filter), p.access)
+	}
+}
+
+// topicMatches reports whether the topic name matches filter.
+func topicMatches(filter, topic string) bool {
+	if filter != "" && (filter[0] == '+' || filter[0] == '#') && strings.HasPrefix(topic, "$") {
+		return false
+	}
+
+	var filters, topics = strings.Split(filter, "/"), strings.Split(topic, "/")
+
+	for i, f := range filters {
+		switch {
+		case f == "#":
+			return true
+		case i == len(topics):
+			// "sport/#" also matches "sport".
+			return i == len(filters)-2 && filters[i+1] == "#"
+		case f != "+" && f != topics[i]:
+			return false
+		}
+	}
+
+	return len(filters) == len(topics)
+}
+
+// filterCovers reports whether every topic matching filter also matches rule.
+func filterCovers(rule, filter string) bool {
+	if rule != "" && (rule[0] == '+' || rule[0] == '#') && strings.HasPrefix(filter, "$") {
+		return false
+	}
+
+	var rules, filters = strings.Split(rule, "/"), strings.Split(filter, "/")
+
+	for i, r := range rules {
+		switch {
+		case r == "#":
+			return true
+		case i == len(filters):
+			return i == len(rules)-2 && rules[i+1] == "#"
+		case filters[i] == "#":
+			return false
+		case r != "+" && (filters[i] == "+" || r != filters[i]):
+			return false
+		}
+	}
+
+	return len(rules) == len(filters)
+}
@@ -0,0 +1,319 @@
+package broker
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/reasoncodes"
+	"github.com/MarcusOuelletus/demo/session"
+)
+
+/*
+The admin API is what an operator needs to see into an embedded broker and act on it:
+
+ - Clients lists every session with its connection, offline ones included, Client returns one of them and
+   Subscriptions the filters of one, from the session's own record of them.
+ - Kick ends the connection of a client with Administrative action, its session stays as after any other lost
+   connection. Ban kicks the client and refuses every later CONNECT with its client id with Banned (Not authorized on
+   3.1.1) until Unban.
+ - Publish injects a message as if the application published it.
+
+AdminHandler serves the same over HTTP with JSON responses, it is not mounted anywhere by itself and has no
+authentication of its own, so it belongs behind whatever guards the embedding application's other admin endpoints:
+
+	GET    /clients                       the ClientStats of every client
+	GET    /clients/{id}                  the ClientStats and the subscriptions of one client
+	POST   /clients/{id}/kick             Kick
+	PUT    /clients/{id}/ban              Ban
+	DELETE /clients/{id}/ban              Unban
+	GET    /bans                          the banned client ids
+	POST   /publish?topic=&qos=&retain=   Publish with the request body as payload
+
+Kick and Ban wait until the connection is cleaned up, they must not be called from a Hook of the same connection.
+*/
+
+// ClientStats describe one session and its connection, the connection fields are zero while it is offline.
+type ClientStats struct {
+	ClientID   string
+	Username   string
+	RemoteAddr string
+	Listener   string
+	Connected  bool
+	// ProtocolVersion, ConnectedAt and KeepAlive are those of the current connection.
+	ProtocolVersion mqttcodec.ProtocolVersion
+	ConnectedAt     time.Time
+	KeepAlive       time.Duration
+	Subscriptions   int
+	// Inflight messages wait for their acknowledgement, Queued ones (QueuedBytes of them) for the client or its
+	// receive quota. Dropped is the sum of Drops.
+	Inflight    int
+	Queued      int
+	QueuedBytes int
+	Dropped     uint64
+	Drops       QueueDrops
+	// MessagesReceived and MessagesSent count the PUBLISH packets of the current connection.
+	MessagesReceived uint64
+	MessagesSent     uint64
+}
+
+// Clients returns the stats of every session, sorted by client id.
+func (b *Broker) Clients() []ClientStats {
+	var stats = make([]ClientStats, 0)
+
+	for _, s := range b.sessionList() {
+		stats = append(stats, s.stats())
+	}
+
+	sort.Slice(stats, func(i, j int) bool { return stats[i].ClientID < stats[j].ClientID })
+
+	return stats
+}
+
+// Client returns the stats of the session of clientID, false when there is none.
+func (b *Broker) Client(clientID string) (ClientStats, bool) {
+	var s = b.sessionOf(clientID)
+
+	if s == nil {
+		return ClientStats{}, false
+	}
+
+	return s.stats(), true
+}
+
+// Subscriptions returns the subscriptions of the session of clientID sorted by filter, false when there is none.
+func (b *Broker) Subscriptions(clientID string) ([]session.Subscription, bool) {
+	var s = b.sessionOf(clientID)
+
+	if s == nil {
+		return nil, false
+	}
+
+	return s.subscriptionList(), true
+}
+
+// Kick ends the connection of clientID and returns whether it had one.
+func (b *Broker) Kick(clientID string) bool {
+	var s = b.sessionOf(clientID)
+
+	if s == nil {
+		return false
+	}
+
+	s.Lock()
+	var c = s.conn
+	s.Unlock()
+
+	if c == nil {
+		return false
+	}
+
+	c.kick(byte(reasoncodes.AdministrativeAction))
+
+	return true
+}
+
+// Ban refuses every later CONNECT of clientID and kicks its current connection.
+func (b *Broker) Ban(clientID string) {
+	b.mu.Lock()
+	b.banned[clientID] = struct{}{}
+	b.mu.Unlock()
+
+	b.Kick(clientID)
+}
+
+// Unban lets clientID connect again, it returns whether it was banned.
+func (b *Broker) Unban(clientID string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	_, ok := b.banned[clientID]
+	delete(b.banned, clientID)
+
+	return ok
+}
+
+// Banned returns the banned client ids, sorted.
+func (b *Broker) Banned() []string {
+	b.mu.RLock()
+	var ids = make([]string, 0, len(b.banned))
+
+	for id := range b.banned {
+		ids = append(ids, id)
+	}
+	b.mu.RUnlock()
+
+	sort.Strings(ids)
+
+	return ids
+}
+
+func (b *Broker) isBanned(clientID string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	_, ok := b.banned[clientID]
+
+	return ok
+}
+
+func (b *Broker) sessionOf(clientID string) *brokerSession {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return b.sessions[clientID]
+}
+
+func (b *Broker) sessionList() []*brokerSession {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	var sessions = make([]*brokerSession, 0, len(b.sessions))
+
+	for _, s := range b.sessions {
+		sessions = append(sessions, s)
+	}
+
+	return sessions
+}
+
+// subscriptionList returns the subscriptions of s sorted by filter.
+func (s *brokerSession) subscriptionList() []session.Subscription {
+	s.Lock()
+	var subs = make([]session.Subscription, 0, len(s.subscriptions))
+
+	for _, sub := range s.subscriptions {
+		subs = append(subs, sub)
+	}
+	s.Unlock()
+
+	sort.Slice(subs, func(i, j int) bool { return subs[i].Filter < subs[j].Filter })
+
+	return subs
+}
+
+func (s *brokerSession) stats() ClientStats {
+	s.Lock()
+	defer s.Unlock()
+
+	var stats = ClientStats{
+		ClientID:      s.clientID,
+		Username:      s.client.Username,
+		Listener:      s.client.Listener,
+		Subscriptions: len(s.subscriptions),
+		Inflight:      s.inflight.Len(),
+		Queued:        len(s.queue),
+		QueuedBytes:   s.queuedBytes,
+		Dropped:       s.drops.total(),
+		Drops:         s.drops,
+	}
+
+	if s.client.RemoteAddr != nil {
+		stats.RemoteAddr = s.client.RemoteAddr.String()
+	}
+
+	if c := s.conn; c != nil {
+		stats.Connected = true
+		stats.ProtocolVersion = c.version
+		stats.ConnectedAt = c.connectedAt
+		stats.KeepAlive = c.keepAlive
+		stats.MessagesReceived = c.messagesReceived.Load()
+		stats.MessagesSent = c.messagesSent.Load()
+	}
+
+	return stats
+}
+
+// AdminHandler serves the admin API over HTTP.
+func (b *Broker) AdminHandler() http.Handler {
+	var mux = http.NewServeMux()
+
+	mux.HandleFunc("GET /clients", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, b.Clients())
+	})
+
+	mux.HandleFunc("GET /clients/{id}", func(w http.ResponseWriter, r *http.Request) {
+		var id = r.PathValue("id")
+
+		stats, ok := b.Client(id)
+
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		subs, _ := b.Subscriptions(id)
+
+		writeJSON(w, struct {
+			ClientStats
+			SubscriptionList []session.Subscription
+		}{stats, subs})
+	})
+
+	mux.HandleFunc("POST /clients/{id}/kick", func(w http.ResponseWriter, r *http.Request) {
+		if !b.Kick(r.PathValue("id")) {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("PUT /clients/{id}/ban", func(w http.ResponseWriter, r *http.Request) {
+		b.Ban(r.PathValue("id"))
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("DELETE /clients/{id}/ban", func(w http.ResponseWriter, r *http.Request) {
+		if !b.Unban(r.PathValue("id")) {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("GET /bans", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, b.Banned())
+	})
+
+	mux.HandleFunc("POST /publish", func(w http.ResponseWriter, r *http.Request) {
+		var query = r.URL.Query()
+		var qos uint64
+		var err error
+
+		if q := query.Get("qos"); q != "" {
+			if qos, err = strconv.ParseUint(q, 10, 8); err != nil {
+				http.Error(w, "invalid qos "+q, http.StatusBadRequest)
+				return
+			}
+		}
+
+		payload, err := io.ReadAll(r.Body)
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		retain, _ := strconv.ParseBool(query.Get("retain"))
+
+		if err = b.Publish(query.Get("topic"), payload, byte(qos), retain); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,240 @@
+package broker
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/reasoncodes"
+)
+
+/*
+The broker asks two pluggable checks before it lets anything through, both are optional and a nil one allows all:
+
+ - The Authenticator checks the username and password of every CONNECT, an error refuses the client with Bad User
+   Name or Password, or with Not authorized when it matches ErrNotAuthorized. A CONNECT with an Authentication Method
+   (MQTT 5 enhanced authentication) needs an Authenticator that is also an EnhancedAuthenticator, the exchange of AUTH
+   packets then replaces the password check. A client may re-authenticate the same way on an established connection,
+   a failed re-authentication ends it.
+ - The Authorizer is asked for every PUBLISH (and the will, at CONNECT) with CanPublish, for every SUBSCRIBE filter
+   with CanSubscribe and for every message the broker is about to deliver with CanReceive, so a rule that denies part
+   of a wildcard subscription holds. A refused PUBLISH is acknowledged with Not authorized on MQTT 5 and silently
+   dropped on 3.1.1, a refused filter gets a failure in the SUBACK. A shared subscription is checked without its
+   $share/{group}/ prefix.
+
+PasswordFile and ACL are the bundled implementations.
+*/
+
+var (
+	ErrBadCredentials = errors.New("bad username or password")
+	ErrNotAuthorized  = errors.New("not authorized")
+	// ErrAuthMethod is returned by StartAuth for an Authentication Method it does not support.
+	ErrAuthMethod = errors.New("unsupported authentication method")
+)
+
+// ClientInfo identifies the client an authentication or authorization check is about.
+type ClientInfo struct {
+	ClientID   string
+	Username   string
+	RemoteAddr net.Addr
+	// Listener is the Name of the ListenerConfig the client connected through.
+	Listener string
+}
+
+type Authenticator interface {
+	// Authenticate checks the password of a CONNECT, client.Username is empty when the CONNECT has none.
+	Authenticate(client ClientInfo, password []byte) error
+}
+
+// EnhancedAuthenticator is optionally implemented by an Authenticator that supports MQTT 5 enhanced authentication.
+type EnhancedAuthenticator interface {
+	// StartAuth begins an exchange for method, ErrAuthMethod when it is not supported.
+	StartAuth(client ClientInfo, method string) (AuthExchange, error)
+}
+
+// AuthExchange is the broker side of one enhanced authentication exchange, SCRAM for example.
+type AuthExchange interface {
+	// Next takes the client's Authentication Data and returns the data to answer with, done is true once the client is
+	// authenticated. An error refuses the client.
+	Next(data []byte) (response []byte, done bool, err error)
+}
+
+type Authorizer interface {
+	CanPublish(client ClientInfo, topic string) bool
+	CanSubscribe(client ClientInfo, filter string) bool
+	CanReceive(client ClientInfo, topic string) bool
+}
+
+// authenticate runs the Authenticator for connect and fills in the authentication properties of connack, it returns the
+// reason code the CONNACK carries.
+func (c *conn) authenticate(connect *mqttcodec.Connect, connack *mqttcodec.Connack) (reasoncodes.Code, error) {
+	var authenticator = c.broker.options.Authenticator
+	var method string
+
+	if connect.Properties != nil {
+		method = connect.Properties.AuthenticationMethod
+	}
+
+	if method == "" {
+		if authenticator == nil {
+			return reasoncodes.Success, nil
+		}
+
+		return authReasonCode(authenticator.Authenticate(c.client, connect.Password)), nil
+	}
+
+	exchange, code := c.startAuth(method)
+
+	if exchange == nil {
+		return code, nil
+	}
+
+	var data = connect.Properties.AuthenticationData
+
+	for {
+		response, done, err := exchange.Next(data)
+
+		if err != nil {
+			return authReasonCode(err), nil
+		}
+
+		if done {
+			connack.Properties.AuthenticationMethod = method
+			connack.Properties.AuthenticationData = response
+			return reasoncodes.Success, nil
+		}
+
+		if err = c.writer.WritePacket(authPacket(mqttcodec.AuthContinueAuthentication, method, response)); err != nil {
+			return 0, err
+		}
+
+		p, err := c.reader.ReadPacket()
+
+		if err != nil {
+			return 0, err
+		}
+
+		auth, ok := p.(*mqttcodec.Auth)
+
+		if !ok || auth.ReasonCode != mqttcodec.AuthContinueAuthentication || auth.Properties == nil ||
+			auth.Properties.AuthenticationMethod != method {
+			return reasoncodes.ProtocolError, nil
+		}
+
+		data = auth.Properties.AuthenticationData
+	}
+}
+
+// startAuth begins an enhanced authentication exchange, a nil exchange comes with the reason code to refuse with.
+func (c *conn) startAuth(method string) (AuthExchange, reasoncodes.Code) {
+	enhanced, ok := c.broker.options.Authenticator.(EnhancedAuthenticator)
+
+	if !ok {
+		return nil, reasoncodes.BadAuthenticationMethod
+	}
+
+	exchange, err := enhanced.StartAuth(c.client, method)
+
+	if errors.Is(err, ErrAuthMethod) {
+		return nil, reasoncodes.BadAuthenticationMethod
+	}
+
+	if err != nil {
+		return nil, authReasonCode(err)
+	}
+
+	return exchange, reasoncodes.Success
+}
+
+// handleAuth runs a re-authentication, it starts with Re-authenticate and continues on the read loop until done.
+func (c *conn) handleAuth(auth *mqttcodec.Auth) error {
+	var method string
+	var data []byte
+
+	if auth.Properties != nil {
+		method, data = auth.Properties.AuthenticationMethod, auth.Properties.AuthenticationData
+	}
+
+	if method == "" || method != c.authMethod {
+		return fmt.Errorf("re-authentication with method %q on a connection authenticated with %q", method, c.authMethod)
+	}
+
+	switch {
+	case auth.ReasonCode == mqttcodec.AuthReAuthenticate && c.reauth == nil:
+		exchange, code := c.startAuth(method)
+
+		if exchange == nil {
+			return &reasonError{code: reauthReasonCode(code), err: fmt.Errorf("re-authentication refused")}
+		}
+
+		c.reauth = exchange
+	case auth.ReasonCode != mqttcodec.AuthContinueAuthentication || c.reauth == nil:
+		return fmt.Errorf("AUTH with reason code 0x%02X out of sequence", auth.ReasonCode)
+	}
+
+	response, done, err := c.reauth.Next(data)
+
+	if err != nil {
+		c.reauth = nil
+		return &reasonError{code: reauthReasonCode(authReasonCode(err)), err: err}
+	}
+
+	if !done {
+		return c.write(authPacket(mqttcodec.AuthContinueAuthentication, method, response))
+	}
+
+	c.reauth = nil
+
+	return c.write(authPacket(mqttcodec.AuthSuccess, method, response))
+}
+
+func authPacket(reasonCode byte, method string, data []byte) *mqttcodec.Auth {
+	return &mqttcodec.Auth{
+		ReasonCode: reasonCode,
+		Properties: &mqttcodec.Properties{AuthenticationMethod: method, AuthenticationData: data},
+	}
+}
+
+// authReasonCode is the CONNACK reason code for the error of an authentication check.
+func authReasonCode(err error) reasoncodes.Code {
+	switch {
+	case err == nil:
+		return reasoncodes.Success
+	case errors.Is(err, ErrNotAuthorized):
+		return reasoncodes.NotAuthorized
+	}
+
+	return reasoncodes.BadUserNameOrPassword
+}
+
+// reauthReasonCode is the DISCONNECT reason code for a failed re-authentication, which has no Bad User Name or Password.
+func reauthReasonCode(code reasoncodes.Code) reasoncodes.Code {
+	if code == reasoncodes.BadUserNameOrPassword {
+		return reasoncodes.NotAuthorized
+	}
+
+	return code
+}
+
+// reasonError is an error that ends the connection with a DISCONNECT carrying its reason code on MQTT 5.
+type reasonError struct {
+	code reasoncodes.Code
+	err  error
+}
+
+func (e *reasonError) Error() string { return e.err.Error() }
+
+func (e *reasonError) Unwrap() error { return e.err }
+
+func (b *Broker) canPublish(client ClientInfo, topic string) bool {
+	return b.options.Authorizer == nil || b.options.Authorizer.CanPublish(client, topic)
+}
+
+func (b *Broker) canSubscribe(client ClientInfo, filter string) bool {
+	return b.options.Authorizer == nil || b.options.Authorizer.CanSubscribe(client, filter)
+}
+
+func (b *Broker) canReceive(client ClientInfo, topic string) bool {
+	return b.options.Authorizer == nil || b.options.Authorizer.CanReceive(client, topic)
+}
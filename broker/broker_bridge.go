@@ -0,0 +1,326 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/client"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/session"
+)
+
+/*
+A Bridge connects the broker to a remote broker with a Client and forwards topic spaces between them, like a mosquitto
+bridge connection. Every BridgeTopic names a Filter, the direction it is forwarded in and the highest QoS it is forwarded
+with, the topic is LocalPrefix + Filter on this broker and RemotePrefix + Filter on the remote one, so forwarding swaps
+the prefix of the topic. Retain flags are kept both ways.
+
+Outbound messages go through a queue of QueueSize, a message arriving while the queue is full (because the remote is
+unreachable, for example) is dropped and counted. AutoReconnect in the client options keeps the bridge up, the client
+subscribes the inbound filters again on every reconnect.
+
+A message the bridge brought in is never forwarded back out, the bridge subscribes locally with No Local. On an MQTT 5
+remote the remote subscriptions have No Local too, an MQTT 3.1.1 broker has nothing like it and echoes what the bridge
+publishes to a topic it also subscribes to, so an inbound message equal (topic and payload) to one forwarded out within
+EchoWindow is dropped once.
+*/
+
+var ErrBridgeStarted = errors.New("bridge already started")
+
+var (
+	DefaultBridgeQueue      = 1000
+	DefaultBridgeEchoWindow = 5 * time.Second
+)
+
+type BridgeDirection byte
+
+const (
+	BridgeOut BridgeDirection = 1 << iota
+	BridgeIn
+	BridgeBoth = BridgeOut | BridgeIn
+)
+
+type BridgeTopic struct {
+	Filter    string
+	Direction BridgeDirection
+	// QoS caps the QoS messages are forwarded with, it is also the QoS of the subscriptions.
+	QoS          byte
+	LocalPrefix  string
+	RemotePrefix string
+}
+
+type BridgeOptions struct {
+	// Name identifies the bridge on this broker, its local subscriptions belong to the client id "$bridge/<Name>".
+	Name string
+	// Client connects to the remote broker.
+	Client client.ClientOptions
+	Topics []BridgeTopic
+	// QueueSize is the number of outbound messages waiting for the remote, 0 is DefaultBridgeQueue.
+	QueueSize int
+	// EchoWindow is how long a forwarded message is remembered to drop its echo from a 3.1.1 remote, 0 is
+	// DefaultBridgeEchoWindow.
+	EchoWindow time.Duration
+}
+
+type Bridge struct {
+	broker  *Broker
+	options BridgeOptions
+	localID string
+	client  *client.Client
+	queue   chan bridged
+	dropped atomic.Uint64
+
+	mu      sync.Mutex
+	started bool
+	local   []*brokerSession
+	done    chan struct{}
+	stopped chan struct{}
+
+	// echoMu is separate from mu, the client's read loop takes it while Start waits for a SUBACK under mu.
+	echoMu sync.Mutex
+	echoes map[uint64]time.Time
+}
+
+// bridged is an outbound message with the topic it has on the remote.
+type bridged struct {
+	topic   string
+	publish *mqttcodec.Publish
+}
+
+func (b *Broker) NewBridge(options BridgeOptions) *Bridge {
+	if options.QueueSize <= 0 {
+		options.QueueSize = DefaultBridgeQueue
+	}
+
+	if options.EchoWindow <= 0 {
+		options.EchoWindow = DefaultBridgeEchoWindow
+	}
+
+	if options.Client.Clock == nil {
+		options.Client.Clock = b.options.Clock
+	}
+
+	return &Bridge{
+		broker:  b,
+		options: options,
+		localID: "$bridge/" + options.Name,
+		echoes:  make(map[uint64]time.Time),
+	}
+}
+
+// Start connects to the remote broker and subscribes the bridged topics on both sides.
+func (br *Bridge) Start() error {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+
+	if br.started {
+		return ErrBridgeStarted
+	}
+
+	br.client = client.New(br.options.Client)
+
+	if err := br.client.Connect(); err != nil {
+		return err
+	}
+
+	br.queue = make(chan bridged, br.options.QueueSize)
+	br.done, br.stopped = make(chan struct{}), make(chan struct{})
+
+	go br.forward()
+
+	for _, t := range br.options.Topics {
+		var t = t
+
+		if t.Direction&BridgeIn != 0 {
+			var opts = client.SubscribeOptions{QoS: t.QoS, NoLocal: br.v5(), RetainAsPublished: true}
+
+			err := br.client.Subscribe(context.Background(), t.RemotePrefix+t.Filter, opts, func(msg client.Message) {
+				br.receive(t, msg)
+			})
+
+			if err != nil {
+				br.stop()
+				return err
+			}
+		}
+
+		if t.Direction&BridgeOut != 0 {
+			var sub = session.Subscription{
+				Filter:            t.LocalPrefix + t.Filter,
+				QoS:               t.QoS,
+				GrantedQoS:        t.QoS,
+				NoLocal:           true,
+				RetainAsPublished: true,
+			}
+
+			br.local = append(br.local, br.broker.subscribeLocal(br.localID, sub, func(p *mqttcodec.Publish) {
+				br.enqueue(t, p)
+			}))
+		}
+	}
+
+	br.started = true
+
+	return nil
+}
+
+// Stop removes the local subscriptions and disconnects from the remote broker, queued messages are dropped.
+func (br *Bridge) Stop() error {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+
+	if !br.started {
+		return nil
+	}
+
+	br.started = false
+
+	return br.stop()
+}
+
+// Dropped returns the number of outbound messages dropped because the queue was full.
+func (br *Bridge) Dropped() uint64 {
+	return br.dropped.Load()
+}
+
+// stop undoes Start, br.mu is held.
+func (br *Bridge) stop() error {
+	for _, s := range br.local {
+		br.broker.removeSession(s)
+	}
+
+	br.local = nil
+
+	close(br.done)
+	<-br.stopped
+
+	return br.client.Disconnect()
+}
+
+func (br *Bridge) enqueue(t BridgeTopic, p *mqttcodec.Publish) {
+	var remote = t.RemotePrefix + strings.TrimPrefix(p.TopicName, t.LocalPrefix)
+
+	select {
+	case br.queue <- bridged{topic: remote, publish: p}:
+	default:
+		br.dropped.Add(1)
+	}
+}
+
+// forward publishes the queue to the remote broker until Stop.
+func (br *Bridge) forward() {
+	defer close(br.stopped)
+
+	var ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		<-br.done
+		cancel()
+	}()
+
+	for {
+		select {
+		case m := <-br.queue:
+			if !br.v5() {
+				br.remember(m.topic, m.publish.Payload)
+			}
+
+			br.client.Publish(ctx, m.topic, m.publish.Payload, publishOptions(m.publish))
+		case <-br.done:
+			return
+		}
+	}
+}
+
+// receive routes a message from the remote broker on this one.
+func (br *Bridge) receive(t BridgeTopic, msg client.Message) {
+	if !br.v5() && br.echo(msg.Topic, msg.Payload) {
+		return
+	}
+
+	var p = &mqttcodec.Publish{
+		TopicName:  t.LocalPrefix + strings.TrimPrefix(msg.Topic, t.RemotePrefix),
+		Payload:    msg.Payload,
+		QoS:        msg.QoS,
+		Retain:     msg.Retain,
+		Properties: publishProperties(msg.Properties),
+	}
+
+	if p.QoS > t.QoS {
+		p.QoS = t.QoS
+	}
+
+	br.broker.route(p, br.localID)
+}
+
+func (br *Bridge) v5() bool {
+	return br.options.Client.ProtocolVersion == mqttcodec.Version5
+}
+
+// remember records a message forwarded to a 3.1.1 remote, expired records are pruned on the way.
+func (br *Bridge) remember(topic string, payload []byte) {
+	var now = br.broker.options.Clock.Now()
+
+	br.echoMu.Lock()
+	defer br.echoMu.Unlock()
+
+	for key, at := range br.echoes {
+		if now.Sub(at) > br.options.EchoWindow {
+			delete(br.echoes, key)
+		}
+	}
+
+	br.echoes[echoKey(topic, payload)] = now
+}
+
+// echo reports whether the message is the echo of one the bridge forwarded, and forgets that one.
+func (br *Bridge) echo(topic string, payload []byte) bool {
+	var key = echoKey(topic, payload)
+
+	br.echoMu.Lock()
+	defer br.echoMu.Unlock()
+
+	at, ok := br.echoes[key]
+
+	if !ok {
+		return false
+	}
+
+	delete(br.echoes, key)
+
+	return br.broker.options.Clock.Now().Sub(at) <= br.options.EchoWindow
+}
+
+func echoKey(topic string, payload []byte) uint64 {
+	var h = fnv.New64a()
+
+	h.Write([]byte(topic))
+	h.Write([]byte{0})
+	h.Write(payload)
+
+	return h.Sum64()
+}
+
+// publishOptions are the client's PublishOptions for a message the bridge forwards.
+func publishOptions(p *mqttcodec.Publish) client.PublishOptions {
+	var opts = client.PublishOptions{QoS: p.QoS, Retain: p.Retain}
+
+	if props := p.Properties; props != nil {
+		opts.ContentType = props.ContentType
+		opts.ResponseTopic = props.ResponseTopic
+		opts.CorrelationData = props.CorrelationData
+		opts.UserProperties = props.UserProperties
+
+		if props.MessageExpiryInterval != nil {
+			opts.MessageExpiry = time.Duration(*props.MessageExpiryInterval) * time.Second
+		}
+	}
+
+	return opts
+}
@@ -0,0 +1,113 @@
+package broker_test
+
+import (
+	"testing"
+
+	"github.com/MarcusOuelletus/demo/broker"
+	"github.com/MarcusOuelletus/demo/brokertest"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+)
+
+func TestBridge(t *testing.T) {
+	for _, version := range []mqttcodec.ProtocolVersion{mqttcodec.Version311, mqttcodec.Version5} {
+		var edge, cloud = brokertest.Start(t, broker.Options{}), brokertest.Start(t, broker.Options{})
+		var options = cloud.Options("edge1")
+
+		options.ProtocolVersion = version
+
+		var bridge = edge.Broker.NewBridge(broker.BridgeOptions{
+			Name:   "cloud",
+			Client: options,
+			Topics: []broker.BridgeTopic{
+				{Filter: "sensors/#", Direction: broker.BridgeOut, QoS: 1, RemotePrefix: "edge1/"},
+				{Filter: "metrics/#", Direction: broker.BridgeOut, QoS: 0, RemotePrefix: "edge1/"},
+				{Filter: "cmd/#", Direction: broker.BridgeIn, QoS: 1, LocalPrefix: "local/", RemotePrefix: "edge1/"},
+				{Filter: "chat/#", Direction: broker.BridgeBoth, QoS: 1},
+			},
+		})
+
+		var local = edge.Client("local", nil)
+
+		local.PublishRetained("sensors/old", "retained", 1)
+
+		if err := bridge.Start(); err != nil {
+			t.Fatalf("%v: %v", version, err)
+		}
+
+		var remote = cloud.Client("remote", nil)
+
+		remote.Subscribe("edge1/#", 1)
+		remote.Subscribe("chat/#", 1)
+		local.Subscribe("local/cmd/#", 1)
+		local.Subscribe("chat/#", 1)
+
+		// -- out: the retained message goes along with its flag, the prefix is swapped and the QoS capped
+		if m := remote.Expect("edge1/sensors/old", "retained"); !m.Retain {
+			t.Fatalf("%v: the retained message lost its flag", version)
+		}
+
+		local.Publish("sensors/temperature", "21", 1)
+		remote.Expect("edge1/sensors/temperature", "21")
+
+		local.Publish("metrics/load", "0.5", 1)
+
+		if m := remote.Expect("edge1/metrics/load", "0.5"); m.QoS != 0 {
+			t.Fatalf("%v: forwarded with qos %d above the cap", version, m.QoS)
+		}
+		// --
+
+		// -- in: the remote prefix becomes the local one
+		remote.Publish("edge1/cmd/reboot", "now", 1)
+		remote.Expect("edge1/cmd/reboot", "now")
+		local.Expect("local/cmd/reboot", "now")
+		// --
+
+		// -- both ways: one copy on each side, nothing comes back around
+		local.Publish("chat/edge", "hi", 1)
+		local.Expect("chat/edge", "hi")
+		remote.Expect("chat/edge", "hi")
+
+		remote.Publish("chat/cloud", "hello", 1)
+		remote.Expect("chat/cloud", "hello")
+		local.Expect("chat/cloud", "hello")
+
+		local.ExpectNone()
+		remote.ExpectNone()
+		// --
+
+		if err := bridge.Stop(); err != nil {
+			t.Fatalf("%v: %v", version, err)
+		}
+
+		// -- a stopped bridge forwards nothing
+		local.Publish("sensors/temperature", "22", 1)
+		remote.ExpectNone()
+		// --
+	}
+}
+
+func TestBridgeStarted(t *testing.T) {
+	var edge, cloud = brokertest.Start(t, broker.Options{}), brokertest.Start(t, broker.Options{})
+
+	var bridge = edge.Broker.NewBridge(broker.BridgeOptions{
+		Name:   "cloud",
+		Client: cloud.Options("edge1"),
+		Topics: []broker.BridgeTopic{{Filter: "a", Direction: broker.BridgeOut, QoS: 1}},
+	})
+
+	if err := bridge.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	defer bridge.Stop()
+
+	if err := bridge.Start(); err != broker.ErrBridgeStarted {
+		t.Fatalf("second Start: %v", err)
+	}
+
+	// -- the bridge is a client of the remote with the id of its options
+	if _, ok := cloud.Broker.Client("edge1"); !ok {
+		t.Fatalf("the remote has no client edge1: %+v", cloud.Broker.Clients())
+	}
+	// --
+}
@@ -0,0 +1,488 @@
+package broker
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/topictrie"
+)
+
+/*
+A Cluster joins brokers so a message published on one node reaches the subscribers connected to any other. Every node
+listens for its peers and dials every peer in ClusterOptions.Peers, the cluster is a full mesh and every node lists all
+the others.
+
+A connection a node dialed is its link: over it the node tells the peer which filters its clients are subscribed to, and
+the peer sends back the messages that match them. Subscriptions are replicated as a changeset: on connect the link
+carries a snapshot of the node's filters, then one change every time a filter gets its first subscriber or loses its
+last one, so a thousand clients on the same filter cost one entry on every peer. The peer keeps the filters of all its
+links in a trie keyed by filter with the node name as the user, forwarding a message is one MatchEach over it, and a
+node gets a message once however many of its filters match. A node that loses a link drops from its peers' trie and
+sends the snapshot again when it is back.
+
+Messages from a peer are routed to the node's own subscribers only, never forwarded again. With ReplicateRetained every
+retained message goes to every peer, subscribed or not, so all retained stores hold the same messages.
+
+The protocol is JSON lines over TCP and delivery between nodes is best effort: a message for a peer whose send queue is
+full is dropped, a link whose queue is full is closed and resynchronized. QoS 1 and 2 are end to end per node only.
+*/
+
+var ErrClusterClosed = errors.New("cluster closed")
+
+var (
+	DefaultClusterRetryInterval = time.Second
+	DefaultClusterQueue         = 1024
+)
+
+// clusterClientID is the publisher of messages from a peer, route does not forward them again.
+const clusterClientID = "$cluster"
+
+type ClusterOptions struct {
+	// Name identifies the node, it has to be unique in the cluster.
+	Name string
+	// Peers are the cluster addresses of the other nodes.
+	Peers []string
+	// ReplicateRetained sends every retained message to every peer.
+	ReplicateRetained bool
+	// RetryInterval is the delay before a lost link is dialed again, 0 is DefaultClusterRetryInterval.
+	RetryInterval time.Duration
+	// QueueSize is the number of messages waiting to be written to one peer, 0 is DefaultClusterQueue.
+	QueueSize int
+}
+
+type Cluster struct {
+	broker  *Broker
+	options ClusterOptions
+	dropped atomic.Uint64
+
+	mu        sync.Mutex
+	links     map[*clusterConn]struct{}
+	peers     map[string]*clusterConn
+	accepted  map[*clusterConn]struct{}
+	remote    *topictrie.Trie[struct{}]
+	listeners map[net.Listener]struct{}
+	closed    bool
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+type clusterConn struct {
+	conn   net.Conn
+	out    chan clusterMessage
+	once   sync.Once
+	closed chan struct{}
+	// filters are the filters a peer sent, the Cluster's lock guards them.
+	filters map[string]struct{}
+	// pending is written before the queue, the snapshot of a link.
+	pending []clusterMessage
+}
+
+type clusterMessage struct {
+	// Op is hello (with Node), sub, unsub (with Filter) or publish (with Publish).
+	Op      string
+	Node    string             `json:",omitempty"`
+	Filter  string             `json:",omitempty"`
+	Publish *mqttcodec.Publish `json:",omitempty"`
+}
+
+// NewCluster makes the broker a node of a cluster and starts dialing the peers, Serve accepts them.
+func (b *Broker) NewCluster(options ClusterOptions) *Cluster {
+	if options.RetryInterval <= 0 {
+		options.RetryInterval = DefaultClusterRetryInterval
+	}
+
+	if options.QueueSize <= 0 {
+		options.QueueSize = DefaultClusterQueue
+	}
+
+	var c = &Cluster{
+		broker:    b,
+		options:   options,
+		links:     make(map[*clusterConn]struct{}),
+		peers:     make(map[string]*clusterConn),
+		accepted:  make(map[*clusterConn]struct{}),
+		remote:    topictrie.New[struct{}](),
+		listeners: make(map[net.Listener]struct{}),
+		done:      make(chan struct{}),
+	}
+
+	b.mu.Lock()
+	b.cluster = c
+	b.mu.Unlock()
+
+	for _, address := range options.Peers {
+		c.wg.Add(1)
+		go c.dial(address)
+	}
+
+	return c
+}
+
+// ListenAndServe listens for peers on the TCP address and serves them until Close.
+func (c *Cluster) ListenAndServe(address string) error {
+	l, err := net.Listen("tcp", address)
+
+	if err != nil {
+		return err
+	}
+
+	return c.Serve(l)
+}
+
+// Serve accepts peers on l until Close, it always returns a non-nil error.
+func (c *Cluster) Serve(l net.Listener) error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		l.Close()
+		return ErrClusterClosed
+	}
+	c.listeners[l] = struct{}{}
+	c.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+
+		c.mu.Lock()
+		var closed = c.closed
+
+		// -- Add happens under the lock, so Close never waits while a peer is still being added
+		if err == nil && !closed {
+			c.wg.Add(1)
+			go c.servePeer(conn)
+		}
+		c.mu.Unlock()
+		// --
+
+		switch {
+		case closed:
+			if conn != nil {
+				conn.Close()
+			}
+			return ErrClusterClosed
+		case err != nil:
+			return err
+		}
+	}
+}
+
+// Close leaves the cluster, the broker goes on as a single node.
+func (c *Cluster) Close() error {
+	c.broker.mu.Lock()
+	if c.broker.cluster == c {
+		c.broker.cluster = nil
+	}
+	c.broker.mu.Unlock()
+
+	c.mu.Lock()
+	c.closed = true
+	close(c.done)
+
+	for l := range c.listeners {
+		l.Close()
+	}
+
+	for n := range c.links {
+		n.close()
+	}
+
+	for n := range c.accepted {
+		n.close()
+	}
+	c.mu.Unlock()
+
+	c.wg.Wait()
+
+	return nil
+}
+
+// Dropped returns the number of messages that were not forwarded because a peer's queue was full.
+func (c *Cluster) Dropped() uint64 {
+	return c.dropped.Load()
+}
+
+// -- link side: the filters of this node go out, the messages for them come in
+
+// dial keeps a link to the peer at address until Close.
+func (c *Cluster) dial(address string) {
+	defer c.wg.Done()
+
+	for {
+		if conn, err := net.DialTimeout("tcp", address, c.options.RetryInterval); err == nil {
+			c.link(conn)
+		}
+
+		var retry = c.broker.options.Clock.NewTimer(c.options.RetryInterval)
+
+		select {
+		case <-c.done:
+			retry.Stop()
+			return
+		case <-retry.C():
+		}
+	}
+}
+
+func (c *Cluster) link(conn net.Conn) {
+	var n = c.newConn(conn)
+
+	// -- the snapshot is taken under the broker's lock, so no change slips in between it and the registration, and it
+	// goes out before any change queued afterwards
+	c.broker.mu.RLock()
+	n.pending = append(n.pending, clusterMessage{Op: "hello", Node: c.options.Name})
+
+	for filter := range c.broker.filters {
+		n.pending = append(n.pending, clusterMessage{Op: "sub", Filter: filter})
+	}
+
+	c.mu.Lock()
+	var closed = c.closed
+	if !closed {
+		c.links[n] = struct{}{}
+	}
+	c.mu.Unlock()
+	c.broker.mu.RUnlock()
+	// --
+
+	if closed {
+		conn.Close()
+		return
+	}
+
+	go n.write()
+
+	n.read(func(m clusterMessage) error {
+		if m.Op != "publish" || m.Publish == nil {
+			return errors.New("unexpected cluster message " + m.Op)
+		}
+
+		// a retained message the store failed to keep was still routed, the link goes on
+		c.broker.route(m.Publish, clusterClientID)
+
+		return nil
+	})
+
+	c.mu.Lock()
+	delete(c.links, n)
+	c.mu.Unlock()
+
+	n.close()
+}
+
+// filterAdded and filterRemoved send a change to every link, the broker's lock is held.
+func (c *Cluster) filterAdded(filter string) {
+	c.broadcast(clusterMessage{Op: "sub", Filter: filter})
+}
+
+func (c *Cluster) filterRemoved(filter string) {
+	c.broadcast(clusterMessage{Op: "unsub", Filter: filter})
+}
+
+// broadcast sends m to every link, a link that cannot take it is closed and resynchronizes when it is dialed again.
+func (c *Cluster) broadcast(m clusterMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for n := range c.links {
+		if !n.send(m) {
+			n.close()
+		}
+	}
+}
+
+// -- peer side: the filters of a peer come in, the messages for them go out
+
+func (c *Cluster) servePeer(conn net.Conn) {
+	defer c.wg.Done()
+
+	var n = c.newConn(conn)
+	var name string
+
+	// -- a peer that never says hello is only known here, Close reaches it through accepted
+	c.mu.Lock()
+	var closed = c.closed
+	if !closed {
+		c.accepted[n] = struct{}{}
+	}
+	c.mu.Unlock()
+	// --
+
+	if closed {
+		conn.Close()
+		return
+	}
+
+	go n.write()
+
+	n.read(func(m clusterMessage) error {
+		switch {
+		case m.Op == "hello" && name == "" && m.Node != "":
+			name = m.Node
+
+			c.mu.Lock()
+			defer c.mu.Unlock()
+
+			if c.closed {
+				return ErrClusterClosed
+			}
+
+			if old := c.peers[name]; old != nil {
+				old.close()
+			}
+
+			c.peers[name] = n
+		case (m.Op == "sub" || m.Op == "unsub") && name != "" && m.Filter != "":
+			c.mu.Lock()
+			defer c.mu.Unlock()
+
+			if c.peers[name] != n {
+				return errors.New("peer " + name + " was replaced")
+			}
+
+			if m.Op == "sub" {
+				c.remote.Add(m.Filter, name, &struct{}{})
+				n.filters[m.Filter] = struct{}{}
+			} else {
+				c.remote.Remove(m.Filter, name)
+				delete(n.filters, m.Filter)
+			}
+		default:
+			return errors.New("unexpected cluster message " + m.Op)
+		}
+
+		return nil
+	})
+
+	n.close()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.accepted, n)
+
+	if name == "" || c.peers[name] != n {
+		return
+	}
+
+	delete(c.peers, name)
+
+	for filter := range n.filters {
+		c.remote.Remove(filter, name)
+	}
+}
+
+// forward sends p to every peer with a matching filter, and with ReplicateRetained a retained p to all of them.
+func (c *Cluster) forward(p *mqttcodec.Publish) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var targets = make(map[string]*clusterConn)
+
+	if p.Retain && c.options.ReplicateRetained {
+		for name, n := range c.peers {
+			targets[name] = n
+		}
+	} else {
+		c.remote.MatchEach(p.TopicName, func(name string, _ *struct{}) {
+			targets[name] = c.peers[name]
+		})
+	}
+
+	var out = *p
+	out.Dup, out.PacketID = false, 0
+
+	for _, n := range targets {
+		if n != nil && !n.send(clusterMessage{Op: "publish", Publish: &out}) {
+			c.dropped.Add(1)
+		}
+	}
+}
+
+// -- connections
+
+func (c *Cluster) newConn(conn net.Conn) *clusterConn {
+	return &clusterConn{
+		conn:    conn,
+		out:     make(chan clusterMessage, c.options.QueueSize),
+		closed:  make(chan struct{}),
+		filters: make(map[string]struct{}),
+	}
+}
+
+// send queues m without blocking, it returns false when the queue is full.
+func (n *clusterConn) send(m clusterMessage) bool {
+	select {
+	case n.out <- m:
+		return true
+	default:
+		return false
+	}
+}
+
+func (n *clusterConn) write() {
+	var w = bufio.NewWriter(n.conn)
+	var encoder = json.NewEncoder(w)
+
+	for _, m := range n.pending {
+		if err := encoder.Encode(m); err != nil {
+			n.close()
+			return
+		}
+	}
+
+	n.pending = nil
+
+	if w.Flush() != nil {
+		n.close()
+		return
+	}
+
+	for {
+		select {
+		case m := <-n.out:
+			if err := encoder.Encode(m); err != nil {
+				n.close()
+				return
+			}
+
+			// -- a burst goes out in one write, the buffer is flushed once the queue ran empty
+			if len(n.out) == 0 && w.Flush() != nil {
+				n.close()
+				return
+			}
+			// --
+		case <-n.closed:
+			return
+		}
+	}
+}
+
+// read hands every message to handle until the connection or handle fails.
+func (n *clusterConn) read(handle func(m clusterMessage) error) {
+	var decoder = json.NewDecoder(bufio.NewReader(n.conn))
+
+	for {
+		var m clusterMessage
+
+		if err := decoder.Decode(&m); err != nil {
+			return
+		}
+
+		if err := handle(m); err != nil {
+			return
+		}
+	}
+}
+
+func (n *clusterConn) close() {
+	n.once.Do(func() {
+		close(n.closed)
+		n.conn.Close()
+	})
+}
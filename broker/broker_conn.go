@@ -0,0 +1,713 @@
+package broker
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/internal/ratelimit"
+	"github.com/MarcusOuelletus/demo/modules/logger"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/reasoncodes"
+	"github.com/MarcusOuelletus/demo/session"
+)
+
+/*
+A conn is one network connection from CONNECT to close. The first packet has to be a CONNECT, it picks the protocol
+version the reader and writer use from then on and attaches the connection to its session, after the CONNACK the
+session's unacknowledged messages are sent again. The read deadline is one and a half times the Keep Alive, the one the
+client asked for or, on MQTT 5 with Options.ServerKeepAlive set, the Server Keep Alive the CONNACK tells it to use
+instead. A connection that stays silent past it is closed, with Keep Alive timeout on MQTT 5.
+
+A connection that ends without a DISCONNECT, or with Disconnect with Will Message, leaves its will to the session's
+lifecycle, which the session.WillConfig is attached to, a normal DISCONNECT drops it. A CONNECT whose will does not
+pass WillConfig.Validate (a will topic with wildcards...) is refused with Protocol Error.
+*/
+
+type conn struct {
+	broker         *Broker
+	netConn        net.Conn
+	reader         *mqttcodec.PacketReader
+	writer         *mqttcodec.PacketWriter
+	version        mqttcodec.ProtocolVersion
+	keepAlive      time.Duration
+	receiveMaximum int
+	// aliases are the topic aliases the client set up, nil when the connection accepts none.
+	aliases *mqttcodec.TopicAliasMap
+	// listener is the config of the listener the connection came in on, maxPacketSize the limit it has there.
+	listener      *ListenerConfig
+	maxPacketSize int
+	client        ClientInfo
+	session       *brokerSession
+	registered    *session.RegisteredSession
+	// authMethod is the Authentication Method the connection was authenticated with, reauth a re-authentication
+	// in progress.
+	authMethod string
+	reauth     AuthExchange
+	limits     ClientLimits
+	// bucket is the publish rate limit, nil without one.
+	bucket *ratelimit.Bucket
+	// connectedAt is when the CONNACK went out, the counters are the PUBLISH packets since.
+	connectedAt      time.Time
+	messagesReceived atomic.Uint64
+	messagesSent     atomic.Uint64
+	// outbound is drained by the writeLoop, stalled is set once a PUBLISH found it full, writing once the writeLoop
+	// started.
+	outbound chan mqttcodec.Packet
+	closing  chan struct{}
+	stalled  atomic.Bool
+	writing  atomic.Bool
+	// tracer logs the packets of the connection once it is set up, the broker's traceMu guards it.
+	tracer *mqttcodec.Tracer
+
+	// normalDisconnect is set by a DISCONNECT without Disconnect with Will Message.
+	normalDisconnect bool
+	once             sync.Once
+	finished         chan struct{}
+}
+
+func (b *Broker) serveConn(netConn net.Conn, listener *ListenerConfig) {
+	netConn = &countingConn{Conn: netConn, stats: &b.stats}
+
+	var c = &conn{
+		broker:        b,
+		netConn:       netConn,
+		reader:        mqttcodec.NewPacketReader(netConn),
+		writer:        mqttcodec.NewPacketWriter(netConn),
+		listener:      listener,
+		maxPacketSize: b.options.MaxPacketSize,
+		outbound:      make(chan mqttcodec.Packet, b.options.OutboundQueue),
+		closing:       make(chan struct{}),
+		finished:      make(chan struct{}),
+	}
+
+	if listener.MaxPacketSize > 0 {
+		c.maxPacketSize = listener.MaxPacketSize
+	}
+
+	c.reader.MaxPacketSize = c.maxPacketSize
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		netConn.Close()
+		return
+	}
+	b.conns[c] = struct{}{}
+	b.mu.Unlock()
+
+	defer close(c.finished)
+	defer func() {
+		b.mu.Lock()
+		delete(b.conns, c)
+		b.mu.Unlock()
+	}()
+
+	if err := c.handshake(); err != nil {
+		b.log.Info("broker: connection refused", logger.Fields{
+			logger.ClientIDField: c.client.ClientID,
+			"remote":             netConn.RemoteAddr(),
+			"error":              err,
+		})
+		c.close()
+		return
+	}
+
+	b.trace(c)
+
+	var cause error
+
+	for {
+		if c.keepAlive > 0 {
+			netConn.SetReadDeadline(time.Now().Add(c.keepAlive * 3 / 2))
+		}
+
+		p, err := c.reader.ReadPacket()
+
+		if err != nil {
+			var timeout net.Error
+
+			if errors.As(err, &timeout) && timeout.Timeout() {
+				c.disconnect(&reasonError{code: reasoncodes.KeepAliveTimeout, err: fmt.Errorf("no packet for %s", c.keepAlive*3/2)})
+			}
+
+			cause = err
+			break
+		}
+
+		if err = c.handle(p); err != nil {
+			c.disconnect(err)
+			cause = err
+			break
+		}
+
+		if c.normalDisconnect {
+			break
+		}
+	}
+
+	c.close()
+	c.end()
+	b.disconnected(c.client, cause)
+}
+
+// handshake reads the CONNECT, sets up the session and answers with the CONNACK.
+func (c *conn) handshake() error {
+	c.netConn.SetReadDeadline(time.Now().Add(c.broker.options.ConnectTimeout))
+
+	p, err := c.reader.ReadPacket()
+
+	if err != nil {
+		return err
+	}
+
+	connect, ok := p.(*mqttcodec.Connect)
+
+	if !ok {
+		return fmt.Errorf("expected CONNECT, got %s", p.Type())
+	}
+
+	c.version = mqttcodec.VersionOf(connect)
+	c.reader.Version, c.writer.Version = c.version, c.version
+	c.keepAlive = time.Duration(connect.KeepAlive) * time.Second
+	c.receiveMaximum = int(^uint16(0))
+
+	var connack = &mqttcodec.Connack{}
+
+	if c.version == mqttcodec.Version5 {
+		connack.Properties = &mqttcodec.Properties{ReceiveMaximum: mqttcodec.Uint16(c.broker.options.ReceiveMaximum)}
+
+		if c.maxPacketSize > 0 {
+			connack.Properties.MaximumPacketSize = mqttcodec.Uint32(uint32(c.maxPacketSize))
+		}
+
+		if max := c.broker.maximumQoS(); max < 2 {
+			connack.Properties.MaximumQoS = mqttcodec.Byte(max)
+		}
+
+		if max := c.broker.topicAliasMaximum(); max > 0 {
+			connack.Properties.TopicAliasMaximum = mqttcodec.Uint16(max)
+			c.aliases = mqttcodec.NewTopicAliasMap(0, max)
+		}
+
+		if keepAlive := serverKeepAlive(c.broker.options.ServerKeepAlive); keepAlive > 0 {
+			connack.Properties.ServerKeepAlive = mqttcodec.Uint16(keepAlive)
+			c.keepAlive = time.Duration(keepAlive) * time.Second
+		}
+
+		if props := connect.Properties; props != nil {
+			if props.ReceiveMaximum != nil {
+				c.receiveMaximum = int(*props.ReceiveMaximum)
+			}
+
+			if props.MaximumPacketSize != nil {
+				c.writer.MaxPacketSize = int(*props.MaximumPacketSize)
+			}
+		}
+	}
+
+	var clientID = connect.ClientID
+
+	if clientID == "" {
+		if !connect.CleanSession && c.version != mqttcodec.Version5 {
+			connack.ReturnCode = mqttcodec.ConnackIdentifierRejected
+			return c.refuse(connack)
+		}
+
+		clientID = generateClientID()
+
+		if connack.Properties != nil {
+			connack.Properties.AssignedClientIdentifier = clientID
+		}
+	}
+
+	c.client = ClientInfo{ClientID: clientID, Username: connect.Username, RemoteAddr: c.netConn.RemoteAddr(), Listener: c.listener.Name}
+
+	if c.broker.isBanned(clientID) {
+		connack.ReturnCode = byte(reasoncodes.Banned)
+		return c.refuse(connack)
+	}
+
+	if will := session.WillOf(connect); will != nil && will.Validate() != nil {
+		connack.ReturnCode = byte(reasoncodes.ProtocolError)
+		return c.refuse(connack)
+	}
+
+	if connect.WillFlag && connect.WillQoS > c.broker.maximumQoS() {
+		connack.ReturnCode = byte(reasoncodes.QoSNotSupported)
+		return c.refuse(connack)
+	}
+
+	code, err := c.authenticate(connect, connack)
+
+	if err != nil {
+		return err
+	}
+
+	if code == reasoncodes.Success && connect.WillFlag && !c.broker.canPublish(c.client, connect.WillTopic) {
+		code = reasoncodes.NotAuthorized
+	}
+
+	if code != reasoncodes.Success {
+		connack.ReturnCode = byte(code)
+		return c.refuse(connack)
+	}
+
+	c.applyLimits(connack)
+
+	if connack.Properties != nil {
+		c.authMethod = connack.Properties.AuthenticationMethod
+	}
+
+	c.netConn.SetReadDeadline(time.Time{})
+
+	c.connectedAt = c.broker.options.Clock.Now()
+	connack.SessionPresent = c.broker.attach(c, clientID, connect)
+
+	if err = c.writer.WritePacket(connack); err != nil {
+		return err
+	}
+
+	c.writing.Store(true)
+	go c.writeLoop()
+
+	c.session.attach(c)
+	c.broker.stats.connected(c.broker.registry.Len())
+	c.broker.connected(c.client, connack.SessionPresent)
+
+	return nil
+}
+
+// serverKeepAlive is d in whole seconds as the Server Keep Alive property takes it, rounded up.
+func serverKeepAlive(d time.Duration) uint16 {
+	var seconds = (d + time.Second - 1) / time.Second
+
+	if seconds > math.MaxUint16 {
+		return math.MaxUint16
+	}
+
+	return uint16(seconds)
+}
+
+// refuse sends a CONNACK with a failure return code, the connection is then closed.
+func (c *conn) refuse(connack *mqttcodec.Connack) error {
+	c.writer.WritePacket(connack)
+	c.writer.Close()
+
+	return fmt.Errorf("connection refused with return code 0x%02X", connack.ReturnCode)
+}
+
+// attach registers c for clientID and gives it the session it resumes or a new one, it returns Session Present.
+func (b *Broker) attach(c *conn, clientID string, connect *mqttcodec.Connect) bool {
+	// -- Register drops the connection that had the client id until now and waits until it ended
+	c.registered = &session.RegisteredSession{ClientID: clientID, Disconnect: c.kick}
+	b.registry.Register(c.registered, true)
+	// --
+
+	b.mu.Lock()
+	var s = b.sessions[clientID]
+	b.mu.Unlock()
+
+	var present = s != nil
+
+	if present && connect.CleanSession {
+		b.end(s)
+		present = false
+	} else if present && !s.lifecycle.Reconnected() {
+		present = false
+	}
+
+	if connect.CleanSession {
+		b.forget(clientID)
+	}
+
+	if !present {
+		s = newBrokerSession(clientID, b.options.MaxQueuedMessages, b.options.Clock)
+		s.lifecycle = session.NewSessionLifecycle(sessionExpiry(c.version, connect), 0, b.options.Clock)
+		s.lifecycle.OnExpire = func() {
+			b.removeSession(s)
+			b.expired(s)
+		}
+
+		b.mu.Lock()
+		b.sessions[clientID] = s
+		b.mu.Unlock()
+	} else {
+		s.lifecycle.Lock()
+		s.lifecycle.SessionExpiry = sessionExpiry(c.version, connect)
+		s.lifecycle.Unlock()
+	}
+
+	var will = session.WillOf(connect)
+
+	s.Lock()
+	s.will = will
+	s.client = c.client
+	s.memory = &b.memory
+	s.maxBytes, s.queuePolicy = c.limits.MaxQueuedBytes, c.limits.QueuePolicy
+
+	if s.maxQueued = b.options.MaxQueuedMessages; c.limits.MaxQueuedMessages > 0 {
+		s.maxQueued = c.limits.MaxQueuedMessages
+	}
+	s.Unlock()
+
+	if !present && !connect.CleanSession {
+		present = b.restore(s)
+	}
+
+	// -- the will of this connection replaces the one before, a connection without one has no Will Delay either
+	s.lifecycle.Lock()
+	s.lifecycle.WillDelay = 0
+	s.lifecycle.Unlock()
+
+	if will != nil {
+		will.Attach(s.lifecycle, func(*session.WillConfig) { b.publishWill(s) })
+	}
+	// --
+
+	b.reconnected(s)
+	c.session = s
+
+	return present
+}
+
+// end ends s right away, publishing the will it still has, because a clean start replaces it.
+func (b *Broker) end(s *brokerSession) {
+	s.lifecycle.Reconnected()
+	b.publishWill(s)
+	b.removeSession(s)
+}
+
+func (b *Broker) publishWill(s *brokerSession) {
+	if will := s.takeWill(); will != nil {
+		b.route(will.Publish(), s.clientID)
+	}
+}
+
+// sessionExpiry is the Session Expiry Interval of a CONNECT, 3.1.1 keeps a session without CleanSession forever.
+func sessionExpiry(version mqttcodec.ProtocolVersion, connect *mqttcodec.Connect) uint32 {
+	if version != mqttcodec.Version5 {
+		if connect.CleanSession {
+			return 0
+		}
+		return session.SessionNeverExpires
+	}
+
+	if connect.Properties != nil && connect.Properties.SessionExpiryInterval != nil {
+		return *connect.Properties.SessionExpiryInterval
+	}
+
+	return 0
+}
+
+func generateClientID() string {
+	var b = make([]byte, 8)
+
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return "auto-" + hex.EncodeToString(b)
+}
+
+func (c *conn) handle(p mqttcodec.Packet) error {
+	var s = c.session
+
+	switch p := p.(type) {
+	case *mqttcodec.Publish:
+		return c.handlePublish(p)
+	case *mqttcodec.Ack:
+		switch p.PacketType {
+		case mqttcodec.PUBACK:
+			s.complete(p.PacketID)
+		case mqttcodec.PUBREC:
+			if reasoncodes.Code(p.ReasonCode).IsFailure() {
+				s.complete(p.PacketID)
+			} else if s.pubrec(p.PacketID) {
+				return c.write(mqttcodec.NewPubrel(p.PacketID))
+			}
+		case mqttcodec.PUBREL:
+			var ack = mqttcodec.NewPubcomp(p.PacketID)
+
+			if _, err := s.inboundQoS2.HandlePubrel(p.PacketID); err != nil {
+				ack.ReasonCode = byte(reasoncodes.PacketIdentifierNotFound)
+			}
+
+			return c.write(ack)
+		case mqttcodec.PUBCOMP:
+			s.pubcomp(p.PacketID)
+		}
+	case *mqttcodec.Subscribe:
+		return c.handleSubscribe(p)
+	case *mqttcodec.Unsubscribe:
+		return c.handleUnsubscribe(p)
+	case *mqttcodec.Auth:
+		return c.handleAuth(p)
+	case *mqttcodec.Empty:
+		switch p.PacketType {
+		case mqttcodec.PINGREQ:
+			return c.write(mqttcodec.Pingresp)
+		case mqttcodec.DISCONNECT:
+			c.normalDisconnect = true
+			s.takeWill()
+		default:
+			return fmt.Errorf("unexpected %s from the client", p.PacketType)
+		}
+	case *mqttcodec.Disconnection:
+		if p.Properties != nil && p.Properties.SessionExpiryInterval != nil {
+			var expiry = *p.Properties.SessionExpiryInterval
+
+			s.lifecycle.Lock()
+			var refused = s.lifecycle.SessionExpiry == 0 && expiry != 0
+			if !refused {
+				s.lifecycle.SessionExpiry = expiry
+			}
+			s.lifecycle.Unlock()
+
+			// -- a session that was to end with the connection cannot be given an expiry now, the will still goes out
+			if refused {
+				return &reasonError{code: reasoncodes.ProtocolError, err: fmt.Errorf("DISCONNECT with session expiry %d after CONNECT with 0", expiry)}
+			}
+			// --
+		}
+
+		c.normalDisconnect = true
+
+		if p.ReasonCode != byte(reasoncodes.DisconnectWithWillMessage) {
+			s.takeWill()
+		}
+	default:
+		return fmt.Errorf("unexpected %s from the client", p.Type())
+	}
+
+	return nil
+}
+
+func (c *conn) handlePublish(p *mqttcodec.Publish) error {
+	if err := c.resolveAlias(p); err != nil {
+		return err
+	}
+
+	if max := c.broker.maximumQoS(); p.QoS > max {
+		return &reasonError{code: reasoncodes.QoSNotSupported, err: fmt.Errorf("PUBLISH with QoS %d, the maximum is %d", p.QoS, max)}
+	}
+
+	c.broker.stats.messagesReceived.Add(1)
+	c.messagesReceived.Add(1)
+
+	if strings.HasPrefix(p.TopicName, sysPrefix) || !c.broker.canPublish(c.client, p.TopicName) {
+		return c.refusePublish(p, reasoncodes.NotAuthorized)
+	}
+
+	code, err := c.checkPublish(p)
+
+	if err != nil {
+		return err
+	}
+
+	if code != reasoncodes.Success {
+		return c.refusePublish(p, code)
+	}
+
+	// -- a QoS 2 message that waits for its PUBREL went through the hooks already
+	if p.QoS < 2 || c.session.inboundQoS2.State(p.PacketID) == session.QoS2Idle {
+		if code = c.broker.published(c.client, p); code != reasoncodes.Success {
+			return c.refusePublish(p, code)
+		}
+	}
+	// --
+
+	switch p.QoS {
+	case 0:
+		c.broker.route(p, c.session.clientID)
+	case 1:
+		c.broker.route(p, c.session.clientID)
+		return c.write(mqttcodec.NewPuback(p.PacketID))
+	case 2:
+		if deliver, _ := c.session.inboundQoS2.HandlePublish(p.PacketID); deliver {
+			c.broker.route(p, c.session.clientID)
+		}
+		return c.write(mqttcodec.NewPubrec(p.PacketID))
+	}
+
+	return nil
+}
+
+// resolveAlias fills in the topic name of p from its topic alias, or sets the alias up when p carries both, and takes
+// the alias off p: everything after this only sees topic names, an alias means nothing past this connection.
+func (c *conn) resolveAlias(p *mqttcodec.Publish) error {
+	if p.Properties == nil || p.Properties.TopicAlias == nil {
+		return nil
+	}
+
+	if c.aliases == nil {
+		return &reasonError{code: reasoncodes.TopicAliasInvalid, err: fmt.Errorf("topic alias %d, the broker allows none", *p.Properties.TopicAlias)}
+	}
+
+	if err := c.aliases.Inbound(p); err != nil {
+		return &reasonError{code: reasoncodes.TopicAliasInvalid, err: err}
+	}
+
+	p.Properties.TopicAlias = nil
+
+	return nil
+}
+
+// refusePublish acknowledges p without routing it, with code on MQTT 5.
+func (c *conn) refusePublish(p *mqttcodec.Publish, code reasoncodes.Code) error {
+	var ack *mqttcodec.Ack
+
+	switch p.QoS {
+	case 1:
+		ack = mqttcodec.NewPuback(p.PacketID)
+	case 2:
+		ack = mqttcodec.NewPubrec(p.PacketID)
+	default:
+		return nil
+	}
+
+	ack.ReasonCode = byte(code)
+
+	return c.write(ack)
+}
+
+func (c *conn) handleSubscribe(p *mqttcodec.Subscribe) error {
+	var codes = make([]byte, len(p.Subscriptions))
+	var retained []*mqttcodec.Publish
+
+	for i, f := range p.Subscriptions {
+		if err := mqttcodec.ValidateFilter(f.Filter); err != nil || !validShared(f.Filter) {
+			codes[i] = byte(reasoncodes.TopicFilterInvalid)
+			continue
+		}
+
+		var _, filter, shared = splitShared(f.Filter)
+
+		if shared && f.NoLocal {
+			return &reasonError{code: reasoncodes.ProtocolError, err: fmt.Errorf("no local on the shared subscription %q", f.Filter)}
+		}
+
+		if !c.broker.canSubscribe(c.client, filter) {
+			codes[i] = byte(reasoncodes.NotAuthorized)
+			continue
+		}
+
+		if !c.session.subscriptionAllowed(f.Filter, c.limits.MaxSubscriptions) {
+			codes[i] = byte(reasoncodes.QuotaExceeded)
+			continue
+		}
+
+		var granted = f.QoS
+
+		if max := c.broker.maximumQoS(); granted > max {
+			granted = max
+		}
+
+		// before the subscription exists, the refreshed values only go out as its retained messages
+		c.broker.refreshSys(f.Filter)
+
+		var sub = session.Subscription{
+			Filter:            f.Filter,
+			QoS:               f.QoS,
+			GrantedQoS:        granted,
+			NoLocal:           f.NoLocal,
+			RetainAsPublished: f.RetainAsPublished,
+			RetainHandling:    f.RetainHandling,
+		}
+
+		retained = append(retained, c.broker.retained(sub, c.broker.subscribe(c.session, sub))...)
+		codes[i] = granted
+		c.broker.subscribed(c.client, sub)
+	}
+
+	c.broker.persist(c.session)
+
+	if err := c.write(&mqttcodec.Suback{PacketID: p.PacketID, ReturnCodes: codes}); err != nil {
+		return err
+	}
+
+	for _, m := range retained {
+		if c.broker.canReceive(c.client, m.TopicName) {
+			c.session.send(m)
+		}
+	}
+
+	return nil
+}
+
+func (c *conn) handleUnsubscribe(p *mqttcodec.Unsubscribe) error {
+	var codes = make([]byte, len(p.Filters))
+
+	for i, filter := range p.Filters {
+		if !c.broker.unsubscribe(c.session, filter) {
+			codes[i] = byte(reasoncodes.NoSubscriptionExisted)
+			continue
+		}
+
+		c.broker.unsubscribed(c.client, filter)
+	}
+
+	c.broker.persist(c.session)
+
+	return c.write(&mqttcodec.Unsuback{PacketID: p.PacketID, ReasonCodes: codes})
+}
+
+// disconnect tells an MQTT 5 client why the broker ends the connection.
+func (c *conn) disconnect(err error) {
+	if c.version != mqttcodec.Version5 {
+		return
+	}
+
+	var code = reasoncodes.ProtocolError
+	var reason *reasonError
+
+	if errors.As(err, &reason) {
+		code = reason.code
+	}
+
+	c.writer.WritePacket(&mqttcodec.Disconnection{
+		ReasonCode: byte(code),
+		Properties: &mqttcodec.Properties{ReasonString: err.Error()},
+	})
+}
+
+// kick ends the connection from the outside, with reason on MQTT 5, and waits until it is cleaned up.
+func (c *conn) kick(reason byte) {
+	if c.version == mqttcodec.Version5 {
+		c.writer.WritePacket(&mqttcodec.Disconnection{ReasonCode: reason})
+	}
+
+	c.close()
+	<-c.finished
+}
+
+func (c *conn) close() {
+	c.once.Do(func() {
+		close(c.closing)
+		c.writer.Close()
+		c.netConn.Close()
+	})
+}
+
+// end detaches the connection from its session and starts the session's lifecycle timers.
+func (c *conn) end() {
+	if c.registered != nil {
+		c.broker.registry.Remove(c.registered)
+	}
+
+	var s = c.session
+
+	if s == nil || !s.detach(c) {
+		return
+	}
+
+	c.broker.lost(s)
+	s.lifecycle.Disconnected(s.hasWill())
+}
@@ -0,0 +1,105 @@
+package broker
+
+import (
+	"time"
+
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+)
+
+/*
+An MQTT 5 PUBLISH may carry a Message Expiry Interval, the number of seconds it is worth delivering. The broker routes
+a message right away, so it only has to keep the interval where a message waits:
+
+ - A message queued for a session (offline, or without receive quota) remembers when it expires. It is dropped from the
+   queue once that passed, and goes out with the seconds it has left otherwise.
+ - A retained message goes out with the seconds it has left, see the RetainedStore.
+
+Every ExpiryCheckInterval the broker drops the expired messages from every queue and from the retained store, so they
+do not take up room until a client happens to come across them. A message already sent and waiting for its
+acknowledgement is sent again on a reconnect whether it expired or not, its onward delivery started.
+*/
+
+// queuedMessage is a message in the queue of a session.
+type queuedMessage struct {
+	publish *mqttcodec.Publish
+	// size is what the message counts against the queue limits.
+	size int
+	// expires is when the Message Expiry Interval of publish elapses, zero without one.
+	expires time.Time
+}
+
+func newQueuedMessage(p *mqttcodec.Publish, now time.Time) *queuedMessage {
+	var q = &queuedMessage{publish: p, size: len(p.TopicName) + len(p.Payload)}
+
+	if p.Properties != nil && p.Properties.MessageExpiryInterval != nil {
+		q.expires = now.Add(time.Duration(*p.Properties.MessageExpiryInterval) * time.Second)
+	}
+
+	return q
+}
+
+func (q *queuedMessage) expired(now time.Time) bool {
+	return !q.expires.IsZero() && !now.Before(q.expires)
+}
+
+// remaining returns the message with the Message Expiry Interval it has left, nil once it expired. The message is the
+// session's own copy, only its interval is replaced.
+func (q *queuedMessage) remaining(now time.Time) *mqttcodec.Publish {
+	if q.expires.IsZero() {
+		return q.publish
+	}
+
+	if q.expired(now) {
+		return nil
+	}
+
+	var left = q.expires.Sub(now)
+	q.publish.Properties.MessageExpiryInterval = mqttcodec.Uint32(uint32((left + time.Second - 1) / time.Second))
+
+	return q.publish
+}
+
+func (b *Broker) runExpiry(interval time.Duration, done chan struct{}) {
+	var ticker = b.options.Clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C():
+			b.expire(now)
+		case <-done:
+			return
+		}
+	}
+}
+
+// expire drops the messages that expired by now from the queue of every session and from the retained store.
+func (b *Broker) expire(now time.Time) {
+	for _, s := range b.sessionList() {
+		s.expire(now)
+	}
+
+	b.options.Retained.expire(now)
+}
+
+// expire drops the queued messages of s that expired by now.
+func (s *brokerSession) expire(now time.Time) {
+	s.Lock()
+	defer s.Unlock()
+
+	var kept = s.queue[:0]
+
+	for _, q := range s.queue {
+		if !q.expired(now) {
+			kept = append(kept, q)
+		} else {
+			s.unqueued(q)
+		}
+	}
+
+	for i := len(kept); i < len(s.queue); i++ {
+		s.queue[i] = nil
+	}
+
+	s.queue = kept
+}
@@ -0,0 +1,106 @@
+package broker
+
+import (
+	"errors"
+
+	"github.com/MarcusOuelletus/demo/modules/logger"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/reasoncodes"
+	"github.com/MarcusOuelletus/demo/session"
+)
+
+/*
+Hooks let an embedding application follow what the broker does and step in, for auditing, transforming messages or
+routing them somewhere else, without changing the broker. Options.Hooks run in order, every method of every Hook is
+called for every event, on the goroutine of the connection the event happened on (the publisher's for OnDeliver):
+
+ - OnConnect once the CONNACK went out, OnDisconnect when the connection is gone: err is nil after a DISCONNECT from
+   the client and says why otherwise.
+ - OnSubscribe for every filter the SUBACK grants, OnUnsubscribe for every filter that had a subscription.
+ - OnPublish for every PUBLISH of a client that passed the Authorizer and the limits, before it is routed (a QoS 2
+   message once, not for its redeliveries). The hook may change the message, its topic, payload, retain flag and
+   properties, not its QoS or packet id. An error refuses the message in the way the Authorizer does, with Not
+   authorized for ErrNotAuthorized and Implementation specific error for any other, and the hooks after it are skipped.
+ - OnDeliver for every client a message is routed to, online or queued, the message is the one that client gets.
+
+Hooks have to be safe for concurrent use and must not keep the messages they are given. Embed NopHook to implement
+only some of the methods.
+*/
+
+type Hook interface {
+	OnConnect(client ClientInfo, sessionPresent bool)
+	OnDisconnect(client ClientInfo, err error)
+	OnSubscribe(client ClientInfo, sub session.Subscription)
+	OnUnsubscribe(client ClientInfo, filter string)
+	OnPublish(client ClientInfo, p *mqttcodec.Publish) error
+	OnDeliver(client ClientInfo, p *mqttcodec.Publish)
+}
+
+// NopHook does nothing for every event.
+type NopHook struct{}
+
+func (NopHook) OnConnect(ClientInfo, bool)                     {}
+func (NopHook) OnDisconnect(ClientInfo, error)                 {}
+func (NopHook) OnSubscribe(ClientInfo, session.Subscription)   {}
+func (NopHook) OnUnsubscribe(ClientInfo, string)               {}
+func (NopHook) OnPublish(ClientInfo, *mqttcodec.Publish) error { return nil }
+func (NopHook) OnDeliver(ClientInfo, *mqttcodec.Publish)       {}
+
+func (b *Broker) connected(client ClientInfo, sessionPresent bool) {
+	b.log.Info("broker: client connected", logger.Fields{
+		logger.ClientIDField: client.ClientID,
+		"remote":             client.RemoteAddr,
+		"session_present":    sessionPresent,
+	})
+
+	for _, h := range b.options.Hooks {
+		h.OnConnect(client, sessionPresent)
+	}
+}
+
+func (b *Broker) disconnected(client ClientInfo, err error) {
+	b.log.Info("broker: client disconnected", logger.Fields{logger.ClientIDField: client.ClientID, "error": err})
+
+	for _, h := range b.options.Hooks {
+		h.OnDisconnect(client, err)
+	}
+}
+
+func (b *Broker) subscribed(client ClientInfo, sub session.Subscription) {
+	for _, h := range b.options.Hooks {
+		h.OnSubscribe(client, sub)
+	}
+}
+
+func (b *Broker) unsubscribed(client ClientInfo, filter string) {
+	for _, h := range b.options.Hooks {
+		h.OnUnsubscribe(client, filter)
+	}
+}
+
+// published runs the OnPublish hooks on p and returns the reason code p is refused with, Success when it is routed.
+func (b *Broker) published(client ClientInfo, p *mqttcodec.Publish) reasoncodes.Code {
+	var qos, id = p.QoS, p.PacketID
+
+	defer func() {
+		p.QoS, p.PacketID = qos, id
+	}()
+
+	for _, h := range b.options.Hooks {
+		if err := h.OnPublish(client, p); err != nil {
+			if errors.Is(err, ErrNotAuthorized) {
+				return reasoncodes.NotAuthorized
+			}
+
+			return reasoncodes.ImplementationSpecificError
+		}
+	}
+
+	return reasoncodes.Success
+}
+
+func (b *Broker) delivered(client ClientInfo, p *mqttcodec.Publish) {
+	for _, h := range b.options.Hooks {
+		h.OnDeliver(client, p)
+	}
+}
@@ -0,0 +1,99 @@
+package broker
+
+import (
+	"fmt"
+
+	"github.com/MarcusOuelletus/demo/internal/ratelimit"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/reasoncodes"
+	"github.com/MarcusOuelletus/demo/session"
+)
+
+/*
+ClientLimits keep one client from starving the others. Options.Limits applies to every client, Options.LimitsFor picks
+the limits of a client from its ClientInfo once it is authenticated. Every zero field is no limit:
+
+ - PublishRate is a token bucket of PublishBurst messages refilled at PublishRate a second, it starts full with every
+   connection. A QoS 1 or 2 PUBLISH without a token is acknowledged with Quota exceeded and not routed, a QoS 0 one is
+   dropped.
+ - MaxPayloadSize ends the connection with Packet too large when a PUBLISH carries a larger payload.
+ - MaxSubscriptions refuses a SUBSCRIBE filter with Quota exceeded once the session has that many subscriptions,
+   replacing one of them is always allowed.
+ - MaxInflight is sent as the Receive Maximum of the CONNACK when it is lower than Options.ReceiveMaximum, a client that
+   has more QoS 2 messages waiting for their PUBREL is disconnected with Receive Maximum exceeded.
+ - MaxQueuedMessages and MaxQueuedBytes cap the queue of the session, see broker_queue.go.
+
+3.1.1 has no reason codes, a refused PUBLISH is acknowledged as if it was routed and a refused filter gets 0x80.
+*/
+
+type ClientLimits struct {
+	PublishRate      float64
+	PublishBurst     int
+	MaxPayloadSize   int
+	MaxSubscriptions int
+	MaxInflight      int
+	// MaxQueuedMessages (Options.MaxQueuedMessages when 0), MaxQueuedBytes and QueuePolicy apply to the queue of the
+	// client's session, see QueuePolicy.
+	MaxQueuedMessages int
+	MaxQueuedBytes    int
+	QueuePolicy       QueuePolicy
+}
+
+func (b *Broker) limitsFor(client ClientInfo) ClientLimits {
+	if b.options.LimitsFor != nil {
+		return b.options.LimitsFor(client)
+	}
+
+	return b.options.Limits
+}
+
+// applyLimits sets the limits of the authenticated client on c and lowers the Receive Maximum of connack to them.
+func (c *conn) applyLimits(connack *mqttcodec.Connack) {
+	c.limits = c.broker.limitsFor(c.client)
+
+	if c.limits.PublishRate > 0 {
+		c.bucket = ratelimit.New(c.limits.PublishRate, c.limits.PublishBurst, c.broker.options.Clock)
+	}
+
+	var max = c.limits.MaxInflight
+
+	if connack.Properties != nil && max > 0 && max < int(c.broker.options.ReceiveMaximum) {
+		connack.Properties.ReceiveMaximum = mqttcodec.Uint16(uint16(max))
+	}
+}
+
+// checkPublish returns the reason code p is refused with, Success when it may be routed, and an error for a violation
+// that ends the connection.
+func (c *conn) checkPublish(p *mqttcodec.Publish) (reasoncodes.Code, error) {
+	if max := c.limits.MaxPayloadSize; max > 0 && len(p.Payload) > max {
+		return 0, &reasonError{code: reasoncodes.PacketTooLarge, err: fmt.Errorf("payload of %d bytes, the limit is %d", len(p.Payload), max)}
+	}
+
+	// -- a redelivery of a message that is waiting already does not count again
+	var inbound = c.session.inboundQoS2
+
+	if max := c.limits.MaxInflight; max > 0 && p.QoS == 2 && inbound.Len() >= max && inbound.State(p.PacketID) == session.QoS2Idle {
+		return 0, &reasonError{code: reasoncodes.ReceiveMaximumExceeded, err: fmt.Errorf("more than %d QoS 2 messages waiting for PUBREL", max)}
+	}
+	// --
+
+	if c.bucket != nil && !c.bucket.Take() {
+		return reasoncodes.QuotaExceeded, nil
+	}
+
+	return reasoncodes.Success, nil
+}
+
+// subscriptionAllowed reports whether s may subscribe to filter under max subscriptions, 0 is no limit.
+func (s *brokerSession) subscriptionAllowed(filter string, max int) bool {
+	if max <= 0 {
+		return true
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	_, existed := s.subscriptions[filter]
+
+	return existed || len(s.subscriptions) < max
+}
@@ -0,0 +1,145 @@
+package broker
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/modules/logger"
+)
+
+/*
+One broker can serve any number of listeners at once, every one of them feeds the same sessions, subscriptions and
+retained messages, so a device on plain TCP and a browser on wss:// talk to each other. A ListenerConfig says what a
+listener speaks: TLS with its own certificates when TLSConfig is set, MQTT over WebSocket when WebSocket is set (wss://
+with both), plain TCP otherwise. Serve is ServeListener with the zero ListenerConfig.
+
+ListenAndServeAll opens every listener before serving any, so a bad address fails the call without a half started
+broker, and serves them until Close.
+*/
+
+type ListenerConfig struct {
+	// Name is handed to the Authenticator and Authorizer as ClientInfo.Listener.
+	Name string
+	// Address is the TCP address ListenAndServeAll listens on.
+	Address string
+	// TLSConfig serves TLS with this configuration.
+	TLSConfig *tls.Config
+	// WebSocket serves MQTT over WebSocket on Path, "" accepts every path.
+	WebSocket bool
+	Path      string
+	// MaxPacketSize replaces Options.MaxPacketSize on this listener, 0 keeps it.
+	MaxPacketSize int
+}
+
+// ListenAndServeAll listens on the address of every config and serves them until Close, it always returns a non-nil
+// error: the first one a listener failed with, ErrBrokerClosed after Close.
+func (b *Broker) ListenAndServeAll(configs ...ListenerConfig) error {
+	var listeners = make([]net.Listener, 0, len(configs))
+
+	for _, config := range configs {
+		l, err := net.Listen("tcp", config.Address)
+
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return err
+		}
+
+		listeners = append(listeners, l)
+	}
+
+	var wg sync.WaitGroup
+	var errs = make(chan error, len(configs))
+
+	for i, l := range listeners {
+		wg.Add(1)
+		go func(l net.Listener, config ListenerConfig) {
+			defer wg.Done()
+			errs <- b.ServeListener(l, config)
+		}(l, configs[i])
+	}
+
+	wg.Wait()
+	close(errs)
+
+	var first error
+
+	for err := range errs {
+		if first == nil || errors.Is(first, ErrBrokerClosed) {
+			first = err
+		}
+	}
+
+	return first
+}
+
+// Serve accepts connections on l until Close, it always returns a non-nil error.
+func (b *Broker) Serve(l net.Listener) error {
+	return b.ServeListener(l, ListenerConfig{})
+}
+
+// ServeListener accepts connections on l as config says until Close, it always returns a non-nil error.
+func (b *Broker) ServeListener(l net.Listener, config ListenerConfig) error {
+	if config.TLSConfig != nil {
+		l = tls.NewListener(l, config.TLSConfig)
+	}
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		l.Close()
+		return ErrBrokerClosed
+	}
+	b.listeners[l] = struct{}{}
+	b.mu.Unlock()
+
+	defer func() {
+		b.mu.Lock()
+		delete(b.listeners, l)
+		b.mu.Unlock()
+	}()
+
+	if config.WebSocket {
+		var err = (&http.Server{Handler: b.webSocketHandler(&config)}).Serve(l)
+
+		if b.isClosed() {
+			return ErrBrokerClosed
+		}
+
+		return err
+	}
+
+	for {
+		netConn, err := l.Accept()
+
+		if err != nil {
+			if b.isClosed() {
+				return ErrBrokerClosed
+			}
+
+			var temporary interface{ Temporary() bool }
+
+			if errors.As(err, &temporary) && temporary.Temporary() {
+				b.log.Warn("broker: accept failed", logger.Fields{"error": err})
+				time.Sleep(5 * time.Millisecond)
+				continue
+			}
+
+			return err
+		}
+
+		go b.serveConn(netConn, &config)
+	}
+}
+
+func (b *Broker) isClosed() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return b.closed
+}
@@ -0,0 +1,134 @@
+package broker
+
+import (
+	"errors"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/reasoncodes"
+)
+
+/*
+Every connection has a bounded outbound queue and a writer goroutine that drains it into the PacketWriter, flushing
+whenever the queue runs empty, so packets queued together leave in one write and a slow socket only ever holds up its
+own writer. Routing a message to a client never waits for it:
+
+ - A QoS 0 message for a full queue is dropped (and counted in ClientStats.Drops), unless it waits in the session's
+   queue behind messages to its topic.
+ - A QoS 1 or 2 message for a full queue stays in the session's queue, within its limits, and is sent once the writer
+   has caught up.
+ - With SlowConsumerDisconnect a full queue ends the connection with Quota exceeded instead, the session keeps its
+   unacknowledged messages for the next connection as after any other lost connection.
+
+The packets a connection answers its own client with (acknowledgements, SUBACK, PINGRESP...) wait for room in the queue,
+a client that does not read slows down only its own connection.
+*/
+
+type SlowConsumerPolicy int
+
+const (
+	// SlowConsumerQueue drops QoS 0 messages and queues QoS 1 and 2 ones in the session.
+	SlowConsumerQueue SlowConsumerPolicy = iota
+	// SlowConsumerDisconnect disconnects the client.
+	SlowConsumerDisconnect
+)
+
+var errOutboundFull = errors.New("outbound queue full")
+
+// slowConsumerTimeout bounds the write of the DISCONNECT to a slow consumer.
+var slowConsumerTimeout = time.Second
+
+// write queues p on the connection and waits for room if the queue is full. A PUBLISH above the client's Maximum
+// Packet Size is dropped as MQTT 5 asks.
+func (c *conn) write(p mqttcodec.Packet) error {
+	select {
+	case c.outbound <- p:
+		return nil
+	case <-c.closing:
+		return mqttcodec.ErrWriterClosed
+	}
+}
+
+// deliver queues a PUBLISH routed to the client without waiting. It returns ErrPacketTooLarge for a PUBLISH the client
+// does not take and errOutboundFull for a full queue under SlowConsumerQueue. A closed connection takes p as if it was
+// sent, the session sends it again on the next one.
+func (c *conn) deliver(p *mqttcodec.Publish) error {
+	if max := c.writer.MaxPacketSize; max > 0 {
+		adapted, err := c.writer.Version.Adapt(p)
+
+		if err != nil {
+			return err
+		}
+
+		if _, err = mqttcodec.EncodeLimited(adapted, max); err != nil {
+			return err
+		}
+	}
+
+	select {
+	case c.outbound <- p:
+		c.broker.stats.messagesSent.Add(1)
+		c.messagesSent.Add(1)
+		return nil
+	case <-c.closing:
+		return nil
+	default:
+	}
+
+	if c.broker.options.SlowConsumer != SlowConsumerDisconnect {
+		c.stalled.Store(true)
+		return errOutboundFull
+	}
+
+	if c.stalled.CompareAndSwap(false, true) {
+		c.netConn.SetWriteDeadline(time.Now().Add(slowConsumerTimeout))
+		go c.kick(byte(reasoncodes.QuotaExceeded))
+	}
+
+	return nil
+}
+
+// writeLoop writes the queued packets until the connection closes.
+func (c *conn) writeLoop() {
+	for {
+		var p mqttcodec.Packet
+
+		select {
+		case p = <-c.outbound:
+		case <-c.closing:
+			return
+		}
+
+		// -- a DISCONNECT only comes from shutdown, it is the last packet of the connection
+		if d, ok := p.(*mqttcodec.Disconnection); ok {
+			if c.version == mqttcodec.Version5 {
+				c.writer.WritePacket(d)
+			}
+
+			c.writer.Flush()
+			c.close()
+			return
+		}
+		// --
+
+		if err := c.writer.WritePacket(p); err != nil && !errors.Is(err, mqttcodec.ErrPacketTooLarge) {
+			c.close()
+			return
+		}
+
+		if len(c.outbound) > 0 {
+			continue
+		}
+
+		if c.writer.Flush() != nil {
+			c.close()
+			return
+		}
+
+		// -- the session may be locked by a goroutine waiting for room in the queue, it is resumed from another one
+		if c.broker.options.SlowConsumer != SlowConsumerDisconnect && c.stalled.CompareAndSwap(true, false) {
+			go c.session.resume(c)
+		}
+		// --
+	}
+}
@@ -0,0 +1,197 @@
+package broker
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+PasswordFile is an Authenticator reading the password files mosquitto_passwd writes, one username:hash line per user,
+blank lines and lines starting with '#' are skipped. Two hash formats are understood:
+
+ - $7$<iterations>$<salt>$<hash> is PBKDF2 with HMAC-SHA512, the default of mosquitto 2 and what HashPassword writes.
+ - $6$<salt>$<hash> is SHA512 over the password followed by the salt, what older versions wrote.
+
+Salt and hash are base64. Reload reads the file again, a malformed file leaves the users that were loaded before.
+*/
+
+// DefaultPasswordIterations is the PBKDF2 iteration count of HashPassword, the one mosquitto_passwd uses.
+var DefaultPasswordIterations = 101
+
+type PasswordFile struct {
+	sync.RWMutex
+	// AllowAnonymous lets clients without a username in, they are refused otherwise.
+	AllowAnonymous bool
+	path           string
+	users          map[string]passwordHash
+}
+
+type passwordHash struct {
+	// iterations is 0 for a $6$ hash.
+	iterations int
+	salt       []byte
+	hash       []byte
+}
+
+// NewPasswordFile reads the password file at path.
+func NewPasswordFile(path string) (*PasswordFile, error) {
+	var f = &PasswordFile{path: path}
+
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (f *PasswordFile) Reload() error {
+	file, err := os.Open(f.path)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	var users = make(map[string]passwordHash)
+	var scanner = bufio.NewScanner(file)
+
+	for line := 1; scanner.Scan(); line++ {
+		var text = strings.TrimSpace(scanner.Text())
+
+		if text == "" || text[0] == '#' {
+			continue
+		}
+
+		username, encoded, ok := strings.Cut(text, ":")
+
+		if !ok {
+			return fmt.Errorf("%s:%d: no ':' between username and password hash", f.path, line)
+		}
+
+		hash, err := parsePasswordHash(encoded)
+
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", f.path, line, err)
+		}
+
+		users[username] = hash
+	}
+
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+
+	f.Lock()
+	f.users = users
+	f.Unlock()
+
+	return nil
+}
+
+func (f *PasswordFile) Authenticate(client ClientInfo, password []byte) error {
+	if client.Username == "" {
+		if f.AllowAnonymous {
+			return nil
+		}
+		return ErrNotAuthorized
+	}
+
+	f.RLock()
+	hash, ok := f.users[client.Username]
+	f.RUnlock()
+
+	if !ok || !hash.matches(password) {
+		return ErrBadCredentials
+	}
+
+	return nil
+}
+
+// HashPassword returns the $7$ hash of password with a random salt, the part of a password file line after the ':'.
+func HashPassword(password []byte) (string, error) {
+	var salt = make([]byte, 12)
+
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	var hash = pbkdf2SHA512(password, salt, DefaultPasswordIterations)
+
+	return fmt.Sprintf("$7$%d$%s$%s", DefaultPasswordIterations, base64.StdEncoding.EncodeToString(salt),
+		base64.StdEncoding.EncodeToString(hash)), nil
+}
+
+func parsePasswordHash(encoded string) (passwordHash, error) {
+	var fields = strings.Split(encoded, "$")
+	var h passwordHash
+	var err error
+
+	switch {
+	case len(fields) == 5 && fields[0] == "" && fields[1] == "7":
+		if h.iterations, err = strconv.Atoi(fields[2]); err != nil || h.iterations <= 0 {
+			return h, fmt.Errorf("invalid iteration count %q", fields[2])
+		}
+		fields = fields[3:]
+	case len(fields) == 4 && fields[0] == "" && fields[1] == "6":
+		fields = fields[2:]
+	default:
+		return h, fmt.Errorf("unsupported password hash format")
+	}
+
+	if h.salt, err = base64.StdEncoding.DecodeString(fields[0]); err != nil {
+		return h, fmt.Errorf("invalid salt: %w", err)
+	}
+
+	if h.hash, err = base64.StdEncoding.DecodeString(fields[1]); err != nil {
+		return h, fmt.Errorf("invalid hash: %w", err)
+	}
+
+	return h, nil
+}
+
+func (h passwordHash) matches(password []byte) bool {
+	var hash []byte
+
+	if h.iterations == 0 {
+		var sum = sha512.Sum512(append(append([]byte(nil), password...), h.salt...))
+		hash = sum[:]
+	} else {
+		hash = pbkdf2SHA512(password, h.salt, h.iterations)
+	}
+
+	return subtle.ConstantTimeCompare(hash, h.hash) == 1
+}
+
+// pbkdf2SHA512 is PBKDF2 (RFC 8018) with HMAC-SHA512 and a key of one block, 64 bytes.
+func pbkdf2SHA512(password, salt []byte, iterations int) []byte {
+	var prf = hmac.New(sha512.New, password)
+
+	prf.Write(salt)
+	prf.Write(binary.BigEndian.AppendUint32(nil, 1))
+
+	var u = prf.Sum(nil)
+	var key = append([]byte(nil), u...)
+
+	for i := 1; i < iterations; i++ {
+		prf.Reset()
+		prf.Write(u)
+		u = prf.Sum(u[:0])
+
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+
+	return key
+}
@@ -0,0 +1,139 @@
+package broker
+
+import (
+	"sync/atomic"
+
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+)
+
+/*
+The queue of a session holds the messages that wait for the client to come back, for receive quota or behind messages
+to their topic. Three limits keep one absent client from taking all the memory of the broker:
+
+ - ClientLimits.MaxQueuedMessages (Options.MaxQueuedMessages when 0) and ClientLimits.MaxQueuedBytes cap the queue of
+   one session, taken from the limits of its latest connection.
+ - Options.QueueMemory caps the bytes queued by every session together.
+
+A message counts as the length of its topic and payload. When a message does not fit, the QueuePolicy of the session
+says which one goes: QueueDropNewest discards the arriving message, QueueDropOldest drops messages from the head of
+the queue until it fits (or the queue is empty and the message is dropped after all). Every drop is counted in the
+session's QueueDrops by the limit that caused it.
+*/
+
+type QueuePolicy int
+
+const (
+	// QueueDropNewest discards the arriving message and keeps the queue as it is.
+	QueueDropNewest QueuePolicy = iota
+	// QueueDropOldest drops the oldest queued messages to make room.
+	QueueDropOldest
+)
+
+// QueueDrops count the messages a session dropped.
+type QueueDrops struct {
+	// Messages, Bytes and Memory count the messages dropped for MaxQueuedMessages, MaxQueuedBytes and
+	// Options.QueueMemory, whether they were the new ones or the oldest.
+	Messages uint64
+	Bytes    uint64
+	Memory   uint64
+	// Outbound counts the QoS 0 messages that found the outbound queue of the connection full.
+	Outbound uint64
+}
+
+func (d QueueDrops) total() uint64 {
+	return d.Messages + d.Bytes + d.Memory + d.Outbound
+}
+
+// queueMemory is the budget of Options.QueueMemory, shared by every session of a broker.
+type queueMemory struct {
+	limit int64
+	used  atomic.Int64
+}
+
+// reserve takes n bytes from the budget, it returns false when they are not left.
+func (m *queueMemory) reserve(n int) bool {
+	for {
+		var used = m.used.Load()
+
+		if m.limit > 0 && used+int64(n) > m.limit {
+			return false
+		}
+
+		if m.used.CompareAndSwap(used, used+int64(n)) {
+			return true
+		}
+	}
+}
+
+func (m *queueMemory) release(n int) {
+	m.used.Add(-int64(n))
+}
+
+// enqueue appends p to the queue, s is locked. A p that does not fit is dropped, or the oldest messages are with
+// QueueDropOldest.
+func (s *brokerSession) enqueue(p *mqttcodec.Publish) {
+	var q = newQueuedMessage(p, s.clock.Now())
+
+	for {
+		var drop = s.overflow(q)
+
+		if drop == nil {
+			break
+		}
+
+		*drop++
+
+		if s.queuePolicy != QueueDropOldest || len(s.queue) == 0 {
+			return
+		}
+
+		s.dequeue()
+	}
+
+	s.queue = append(s.queue, q)
+	s.queued[p.TopicName]++
+	s.queuedBytes += q.size
+}
+
+// overflow returns the drop counter of the limit q exceeds, nil when it fits and its bytes are reserved, s is locked.
+func (s *brokerSession) overflow(q *queuedMessage) *uint64 {
+	switch {
+	case len(s.queue) >= s.maxQueued:
+		return &s.drops.Messages
+	case s.maxBytes > 0 && s.queuedBytes+q.size > s.maxBytes:
+		return &s.drops.Bytes
+	case !s.memory.reserve(q.size):
+		return &s.drops.Memory
+	}
+
+	return nil
+}
+
+// dequeue drops the first message of the queue, s is locked.
+func (s *brokerSession) dequeue() {
+	s.unqueued(s.queue[0])
+	s.queue[0] = nil
+	s.queue = s.queue[1:]
+}
+
+// unqueued takes q out of the counts of the queue and gives its bytes back to the budget, s is locked.
+func (s *brokerSession) unqueued(q *queuedMessage) {
+	if s.queued[q.publish.TopicName]--; s.queued[q.publish.TopicName] <= 0 {
+		delete(s.queued, q.publish.TopicName)
+	}
+
+	s.queuedBytes -= q.size
+	s.memory.release(q.size)
+}
+
+// clearQueue drops every queued message once s ended, s is locked.
+func (s *brokerSession) clearQueue() {
+	for len(s.queue) > 0 {
+		s.dequeue()
+	}
+}
+
+// QueuedBytes returns the bytes queued by every session, Options.QueueMemory caps it.
+func (b *Broker) QueuedBytes() int64 {
+	return b.memory.used.Load()
+}
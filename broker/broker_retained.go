@@ -0,0 +1,368 @@
+package broker
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/clock"
+	"github.com/MarcusOuelletus/demo/internal/appendlog"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/redis"
+	"github.com/MarcusOuelletus/demo/topictrie"
+)
+
+/*
+The RetainedStore keeps the last retained message of every topic. A retained PUBLISH replaces the message of its topic,
+one with an empty payload clears it. A new subscription gets the retained messages its filter matches, looked up with
+the trie's MatchFilterEach, depending on its Retain Handling:
+
+ - 0 sends them on every SUBSCRIBE.
+ - 1 sends them only when the subscription did not exist yet.
+ - 2 never sends them.
+
+They are sent after the SUBACK with Retain set, at the lower of their QoS and the granted one. A message with a Message
+Expiry Interval goes out with the time it has left, once it elapsed the message is removed from the trie (and cleared in
+the log) by the broker's periodic expiry check, or before that by the first Match or replay that comes across it.
+
+NewRetainedStore keeps the messages in memory, NewFileRetainedStore also writes them to an appendlog.Log like the
+client's FileStore: every set or clear is one line synced before it returns, the log is replayed when it is opened (a
+torn last line is cut off) and rewritten once it holds more dead records than live ones.
+
+NewRedisRetainedStore keeps every message as JSON under <prefix>retained:<topic> in Redis, for brokers that share the
+retained messages without a Cluster between them. A message with a Message Expiry Interval is set with that TTL, so
+Redis drops it by itself. Every Match reads all the retained keys again to pick up what other brokers set, the memory
+copy answers when Redis cannot and between two Matches (Len, the expiry check).
+
+The Message Expiry Intervals are measured on Clock, nil is clock.Real, the broker sets its own on a store without one.
+*/
+
+// DefaultCompactThreshold is the number of records a retained log has to reach before it is compacted at all.
+var DefaultCompactThreshold = appendlog.DefaultCompactThreshold
+
+type RetainedStore struct {
+	sync.Mutex
+	CompactThreshold int
+	Clock            clock.Clock
+	messages         *topictrie.Trie[retainedMessage]
+	topics           map[string]struct{}
+	log              *appendlog.Log
+	redis            *redis.Client
+	prefix           string
+}
+
+type retainedMessage struct {
+	Publish *mqttcodec.Publish
+	Stored  time.Time
+}
+
+// retainedRecord is one line of the log, a nil Message clears the topic.
+type retainedRecord struct {
+	Topic   string
+	Message *retainedMessage `json:",omitempty"`
+}
+
+func NewRetainedStore() *RetainedStore {
+	return &RetainedStore{
+		CompactThreshold: DefaultCompactThreshold,
+		messages:         topictrie.New[retainedMessage](),
+		topics:           make(map[string]struct{}),
+	}
+}
+
+// NewFileRetainedStore opens the log at path, creating it if needed, and replays it.
+func NewFileRetainedStore(path string) (*RetainedStore, error) {
+	var r = NewRetainedStore()
+	var now = r.now()
+
+	log, err := appendlog.Open(path, func(line []byte) error { return r.replay(line, now) })
+
+	if err != nil {
+		return nil, err
+	}
+
+	r.log = log
+
+	return r, nil
+}
+
+// NewRedisRetainedStore keeps the messages in the Redis of client under keys that start with prefix, which must not
+// hold the glob characters of SCAN MATCH. The broker does not close client.
+func NewRedisRetainedStore(client *redis.Client, prefix string) (*RetainedStore, error) {
+	var r = NewRetainedStore()
+	r.redis, r.prefix = client, prefix
+
+	r.Lock()
+	defer r.Unlock()
+
+	if err := r.load(r.now()); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *RetainedStore) now() time.Time {
+	return clock.Or(r.Clock).Now()
+}
+
+// Set retains p for its topic, an empty payload clears the topic instead.
+func (r *RetainedStore) Set(p *mqttcodec.Publish) error {
+	var m *retainedMessage
+
+	if len(p.Payload) > 0 {
+		var c = *p
+		c.Dup, c.PacketID, c.Retain = false, 0, true
+		m = &retainedMessage{Publish: &c, Stored: r.now()}
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	if m == nil && r.redis == nil {
+		if _, ok := r.topics[p.TopicName]; !ok {
+			return nil
+		}
+	}
+
+	if r.log != nil {
+		if err := r.log.Append(retainedRecord{Topic: p.TopicName, Message: m}); err != nil {
+			return err
+		}
+	}
+
+	if r.redis != nil {
+		if err := r.store(p.TopicName, m); err != nil {
+			return err
+		}
+	}
+
+	r.set(p.TopicName, m)
+
+	return r.compact()
+}
+
+// Match returns the retained messages whose topic matches filter, sorted by topic. The expired ones it finds are
+// removed, a failed write to the log only leaves them there until a later Match or replay.
+func (r *RetainedStore) Match(filter string) []*mqttcodec.Publish {
+	var matches []*mqttcodec.Publish
+	var expired []string
+	var now = r.now()
+
+	r.Lock()
+
+	if r.redis != nil {
+		r.load(now)
+	}
+
+	r.messages.MatchFilterEach(filter, func(_ string, m *retainedMessage) {
+		if p := m.remaining(now); p != nil {
+			matches = append(matches, p)
+		} else {
+			expired = append(expired, m.Publish.TopicName)
+		}
+	})
+
+	r.clear(expired)
+	r.Unlock()
+
+	sort.Slice(matches, func(i, j int) bool { return matches[i].TopicName < matches[j].TopicName })
+
+	return matches
+}
+
+// expire removes every message whose Message Expiry Interval elapsed by now and returns how many there were.
+func (r *RetainedStore) expire(now time.Time) int {
+	var expired []string
+
+	r.Lock()
+	defer r.Unlock()
+
+	for topic := range r.topics {
+		if m := r.messages.Get(topic)[""]; m != nil && m.remaining(now) == nil {
+			expired = append(expired, topic)
+		}
+	}
+
+	r.clear(expired)
+
+	return len(expired)
+}
+
+// clear removes the messages of topics, r is locked. A topic the log could not clear stays until the next try, Redis
+// expires its keys by itself.
+func (r *RetainedStore) clear(topics []string) {
+	for _, topic := range topics {
+		if r.log != nil && r.log.Append(retainedRecord{Topic: topic}) != nil {
+			continue
+		}
+
+		r.set(topic, nil)
+	}
+
+	if len(topics) > 0 {
+		r.compact()
+	}
+}
+
+func (r *RetainedStore) Len() int {
+	r.Lock()
+	defer r.Unlock()
+
+	return len(r.topics)
+}
+
+// Close closes the log, the store is not usable afterwards.
+func (r *RetainedStore) Close() error {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.log == nil {
+		return nil
+	}
+
+	return r.log.Close()
+}
+
+func (r *RetainedStore) set(topic string, m *retainedMessage) {
+	if m == nil {
+		r.messages.Remove(topic, "")
+		delete(r.topics, topic)
+		return
+	}
+
+	r.messages.Add(topic, "", m)
+	r.topics[topic] = struct{}{}
+}
+
+// remaining returns the message with the Message Expiry Interval it has left, nil once it expired.
+func (m *retainedMessage) remaining(now time.Time) *mqttcodec.Publish {
+	if m.Publish.Properties == nil || m.Publish.Properties.MessageExpiryInterval == nil {
+		return m.Publish
+	}
+
+	var left = time.Duration(*m.Publish.Properties.MessageExpiryInterval)*time.Second - now.Sub(m.Stored)
+
+	if left <= 0 {
+		return nil
+	}
+
+	var p = *m.Publish
+	var properties = *p.Properties
+	properties.MessageExpiryInterval = mqttcodec.Uint32(uint32((left + time.Second - 1) / time.Second))
+	p.Properties = &properties
+
+	return &p
+}
+
+// store sets the key of topic to m with the TTL m has left, or deletes it for a nil m, r is locked.
+func (r *RetainedStore) store(topic string, m *retainedMessage) error {
+	var key = r.prefix + "retained:" + topic
+
+	if m == nil {
+		_, err := r.redis.Do("DEL", key)
+		return err
+	}
+
+	data, err := json.Marshal(m)
+
+	if err != nil {
+		return err
+	}
+
+	var args = []string{"SET", key, string(data)}
+
+	if m.Publish.Properties != nil && m.Publish.Properties.MessageExpiryInterval != nil {
+		args = append(args, "PX", redis.Milliseconds(time.Duration(*m.Publish.Properties.MessageExpiryInterval)*time.Second))
+	}
+
+	_, err = r.redis.Do(args...)
+
+	return err
+}
+
+// load replaces the messages in memory with the ones in Redis, r is locked. The memory copy stays as it was when Redis
+// fails.
+func (r *RetainedStore) load(now time.Time) error {
+	keys, err := r.redis.Scan(r.prefix + "retained:*")
+
+	if err != nil {
+		return err
+	}
+
+	var messages = make(map[string]*retainedMessage, len(keys))
+
+	for len(keys) > 0 {
+		var batch = keys[:min(len(keys), 100)]
+		keys = keys[len(batch):]
+
+		reply, err := r.redis.Do(append([]string{"MGET"}, batch...)...)
+
+		if err != nil {
+			return err
+		}
+
+		values, _ := reply.([]any)
+
+		for _, value := range values {
+			var m retainedMessage
+
+			// -- a key deleted since the SCAN comes back nil, an unreadable one is skipped
+			if data, ok := value.([]byte); !ok || json.Unmarshal(data, &m) != nil || m.Publish == nil {
+				continue
+			}
+			// --
+
+			if m.remaining(now) != nil {
+				messages[m.Publish.TopicName] = &m
+			}
+		}
+	}
+
+	for topic := range r.topics {
+		if messages[topic] == nil {
+			r.set(topic, nil)
+		}
+	}
+
+	for topic, m := range messages {
+		r.set(topic, m)
+	}
+
+	return nil
+}
+
+// replay applies one record of the log, a message that expired by now is not restored.
+func (r *RetainedStore) replay(line []byte, now time.Time) error {
+	var record retainedRecord
+
+	if err := json.Unmarshal(line, &record); err != nil {
+		return err
+	}
+
+	if record.Message != nil && record.Message.remaining(now) == nil {
+		record.Message = nil
+	}
+
+	r.set(record.Topic, record.Message)
+
+	return nil
+}
+
+// compact rewrites the log with only the live messages once most of its records are dead.
+func (r *RetainedStore) compact() error {
+	if r.log == nil {
+		return nil
+	}
+
+	return r.log.Compact(r.CompactThreshold, len(r.topics), func(write func(record any) error) error {
+		for topic := range r.topics {
+			if err := write(retainedRecord{Topic: topic, Message: r.messages.Get(topic)[""]}); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
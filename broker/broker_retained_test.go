@@ -0,0 +1,169 @@
+package broker_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/broker"
+	"github.com/MarcusOuelletus/demo/brokertest"
+	"github.com/MarcusOuelletus/demo/client"
+	"github.com/MarcusOuelletus/demo/clock"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+)
+
+func TestRetained(t *testing.T) {
+	var s = brokertest.Start(t, broker.Options{})
+	var pub = s.Client("pub", nil)
+
+	pub.PublishRetained("a/2", "two", 1)
+	pub.PublishRetained("a/1", "one", 0)
+	pub.PublishRetained("a/1", "first", 1)
+	pub.PublishRetained("b", "elsewhere", 1)
+
+	// -- a new subscription gets the last retained message of every topic it matches, in topic order, with Retain set
+	var sub = s.Client("sub", nil)
+
+	sub.Subscribe("a/+", 1)
+
+	for _, want := range []struct{ topic, payload string }{{"a/1", "first"}, {"a/2", "two"}} {
+		if m := sub.Expect(want.topic, want.payload); !m.Retain {
+			t.Fatalf("%s arrived without Retain", m.Topic)
+		}
+	}
+	// --
+
+	// -- a live message is not a retained one on 3.1.1, even when it was published retained
+	pub.PublishRetained("a/3", "live", 1)
+
+	if m := sub.Expect("a/3", "live"); m.Retain {
+		t.Fatal("a live message arrived with Retain")
+	}
+	// --
+
+	// -- an empty payload clears the retained message, it is not retained itself
+	pub.PublishRetained("a/2", "", 1)
+	sub.Expect("a/2", "")
+
+	var late = s.Client("late", nil)
+
+	late.Subscribe("a/#", 1)
+	late.Expect("a/1", "first")
+	late.Expect("a/3", "live")
+	late.ExpectNone()
+	// --
+}
+
+func TestRetainHandling(t *testing.T) {
+	var s = brokertest.Start(t, broker.Options{})
+	var version5 = func(o *client.ClientOptions) { o.ProtocolVersion = mqttcodec.Version5 }
+
+	s.Client("pub", nil).PublishRetained("a", "retained", 1)
+
+	var tests = []struct {
+		name           string
+		retainHandling byte
+		// first and again are whether the first SUBSCRIBE and the same one sent again get the retained message.
+		first, again bool
+	}{
+		{"send on subscribe", 0, true, true},
+		{"send on a new subscription", 1, true, false},
+		{"never send", 2, false, false},
+	}
+
+	for _, test := range tests {
+		var sub = s.Client("sub", version5)
+
+		for _, want := range []bool{test.first, test.again} {
+			sub.SubscribeWith("a", client.SubscribeOptions{QoS: 1, RetainHandling: test.retainHandling})
+
+			if want {
+				sub.Expect("a", "retained")
+			} else {
+				sub.ExpectNone()
+			}
+		}
+
+		sub.Disconnect()
+	}
+
+	// -- Retain As Published keeps the flag of a live message
+	var sub = s.Client("sub", version5)
+
+	sub.SubscribeWith("b", client.SubscribeOptions{QoS: 1, RetainAsPublished: true})
+	s.Client("pub", nil).PublishRetained("b", "live", 1)
+
+	if m := sub.Expect("b", "live"); !m.Retain {
+		t.Fatal("Retain As Published dropped the flag")
+	}
+	// --
+}
+
+func TestRetainedExpiry(t *testing.T) {
+	var fake = clock.NewFake(time.Unix(0, 0))
+	var retained = broker.NewRetainedStore()
+	var s = brokertest.Start(t, broker.Options{Clock: fake, Retained: retained})
+	var pub = s.Client("pub", func(o *client.ClientOptions) { o.ProtocolVersion = mqttcodec.Version5 })
+
+	if err := pub.Client.Publish(timeout(t), "a", []byte("expiring"), client.PublishOptions{QoS: 1, Retain: true, MessageExpiry: 10 * time.Second}); err != nil {
+		t.Fatal(err)
+	}
+
+	// -- a retained message goes out with the Message Expiry Interval it has left, and not at all once it elapsed
+	fake.Advance(4 * time.Second)
+
+	var sub = s.Client("sub", func(o *client.ClientOptions) { o.ProtocolVersion = mqttcodec.Version5 })
+
+	sub.Subscribe("a", 1)
+
+	if m := sub.Expect("a", "expiring"); m.Properties == nil || *m.Properties.MessageExpiryInterval != 6 {
+		t.Fatalf("arrived with %+v", m.Properties)
+	}
+
+	fake.Advance(6 * time.Second)
+
+	var late = s.Client("late", nil)
+
+	late.Subscribe("a", 1)
+	late.ExpectNone()
+
+	if n := retained.Len(); n != 0 {
+		t.Fatalf("%d retained messages after the expiry", n)
+	}
+	// --
+}
+
+func TestFileRetainedStore(t *testing.T) {
+	var path = filepath.Join(t.TempDir(), "retained.log")
+
+	retained, err := broker.NewFileRetainedStore(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var s = brokertest.Start(t, broker.Options{Retained: retained})
+	var pub = s.Client("pub", nil)
+
+	pub.PublishRetained("a", "kept", 1)
+	pub.PublishRetained("b", "cleared", 1)
+	pub.PublishRetained("b", "", 1)
+
+	if err := retained.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// -- a broker on the reopened log has the retained messages of the last one
+	if retained, err = broker.NewFileRetainedStore(path); err != nil {
+		t.Fatal(err)
+	}
+
+	defer retained.Close()
+
+	var sub = brokertest.Start(t, broker.Options{Retained: retained}).Client("sub", nil)
+
+	sub.Subscribe("+", 1)
+	sub.Expect("a", "kept")
+	sub.ExpectNone()
+	// --
+}
@@ -0,0 +1,285 @@
+package broker
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/MarcusOuelletus/demo/clock"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/packetids"
+	"github.com/MarcusOuelletus/demo/session"
+)
+
+/*
+A brokerSession is the broker's state for one client id, it lives on while its connection changes: the subscriptions,
+the outbound QoS 1 and 2 messages that wait for their acknowledgement (inflight) or for room under the client's
+Receive Maximum (queue), and the inbound QoS 2 packet ids that wait for their PUBREL.
+
+The inflight messages are a session.InflightStore, which keeps them in the order they were first sent in, a reconnect
+sends them again in that order before anything from the queue. The broker never retransmits on a timer, MQTT only
+allows it on a new connection.
+
+Messages to one topic reach the client in the order they were routed to the session, whatever their QoS: the queue is
+first in first out, and a QoS 0 message (which needs no receive quota) goes straight to the connection only while
+nothing to its topic is queued, otherwise it waits in the queue behind the others. A connection routes the PUBLISH
+packets of its client one after the other, so the messages of one publisher to one topic keep their publication order
+up to every subscriber.
+*/
+
+type brokerSession struct {
+	sync.Mutex
+	clientID      string
+	client        ClientInfo
+	conn          *conn
+	subscriptions map[string]session.Subscription
+	ids           *packetids.PacketIDs
+	inflight      *session.InflightStore
+	queue         []*queuedMessage
+	// queued counts the messages in the queue by topic, queuedBytes their size.
+	queued       map[string]int
+	queuedBytes  int
+	drops        QueueDrops
+	outboundQoS2 *session.OutboundQoS2Flow
+	inboundQoS2  *session.InboundQoS2Flow
+	lifecycle    *session.SessionLifecycle
+	will         *session.WillConfig
+	maxQueued    int
+	maxBytes     int
+	queuePolicy  QueuePolicy
+	clock        clock.Clock
+	// memory is the broker's budget for every queue, nil for a session inside the process.
+	memory *queueMemory
+	// local receives the messages of a session inside the process, which has no connection.
+	local func(p *mqttcodec.Publish)
+}
+
+func newBrokerSession(clientID string, maxQueued int, clk clock.Clock) *brokerSession {
+	var s = &brokerSession{
+		clientID:      clientID,
+		subscriptions: make(map[string]session.Subscription),
+		ids:           packetids.New(),
+		queued:        make(map[string]int),
+		outboundQoS2:  session.NewOutboundQoS2Flow(),
+		inboundQoS2:   session.NewInboundQoS2Flow(),
+		maxQueued:     maxQueued,
+		clock:         clock.Or(clk),
+	}
+
+	s.inflight = session.NewInflightStore(s.ids, nil, s.clock)
+
+	return s
+}
+
+// send delivers p to the client, or queues it while the client is offline or has no receive quota left.
+func (s *brokerSession) send(p *mqttcodec.Publish) {
+	if s.local != nil {
+		s.local(p)
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if p.QoS == 0 {
+		switch {
+		case s.conn == nil:
+		case s.queued[p.TopicName] > 0:
+			s.enqueue(p)
+			s.next()
+		case errors.Is(s.conn.deliver(p), errOutboundFull):
+			s.drops.Outbound++
+		}
+		return
+	}
+
+	if s.conn == nil || s.inflight.Len() >= s.conn.receiveMaximum || len(s.queue) > 0 {
+		s.enqueue(p)
+		s.next()
+		return
+	}
+
+	if !s.transmit(p) {
+		s.enqueue(p)
+	}
+}
+
+// transmit gives p a packet id and hands it to the connection, s is locked and the client has receive quota. A p above
+// the client's Maximum Packet Size is dropped without ever being in flight, its packet id is free again. transmit
+// returns false when the outbound queue of the connection is full, p is not in flight then and has to wait.
+func (s *brokerSession) transmit(p *mqttcodec.Publish) bool {
+	var id = s.ids.Reserve()
+
+	p.PacketID = id.Value
+	s.inflight.Add(inflightMessage(p))
+
+	if p.QoS == 2 {
+		s.outboundQoS2.Start(id.Value)
+	}
+
+	var err = s.conn.deliver(p)
+
+	if err == nil {
+		return true
+	}
+
+	s.inflight.Complete(id.Value)
+	s.outboundQoS2.Abort(id.Value)
+
+	return !errors.Is(err, errOutboundFull)
+}
+
+// next sends queued messages in order while the client has receive quota for them, s is locked. Expired messages are
+// dropped on the way, the others go out with the Message Expiry Interval they have left. It stops at a full outbound
+// queue, the connection's writer calls it again once the queue ran empty.
+func (s *brokerSession) next() {
+	var now = s.clock.Now()
+
+	for s.conn != nil && len(s.queue) > 0 {
+		var q = s.queue[0]
+
+		if q.publish.QoS > 0 && s.inflight.Len() >= s.conn.receiveMaximum {
+			return
+		}
+
+		if p := q.remaining(now); p != nil && !s.sendQueued(p) {
+			return
+		}
+
+		s.dequeue()
+	}
+}
+
+// sendQueued hands a message from the queue to the connection, it returns false when the outbound queue is full.
+func (s *brokerSession) sendQueued(p *mqttcodec.Publish) bool {
+	if p.QoS > 0 {
+		return s.transmit(p)
+	}
+
+	return !errors.Is(s.conn.deliver(p), errOutboundFull)
+}
+
+// resume moves the queue on after the outbound queue of c ran empty, unless c is no longer the connection of s.
+func (s *brokerSession) resume(c *conn) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.conn == c {
+		s.next()
+	}
+}
+
+// complete ends the flow of id once its PUBACK or PUBCOMP arrived and moves the queue on.
+func (s *brokerSession) complete(id uint16) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.inflight.Complete(id) {
+		return false
+	}
+
+	s.outboundQoS2.Abort(id)
+	s.next()
+
+	return true
+}
+
+// pubrec moves the QoS 2 flow of id on to PUBREL, it returns false for an unknown packet id.
+func (s *brokerSession) pubrec(id uint16) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.inflight.Get(id); !ok {
+		return false
+	}
+
+	if _, err := s.outboundQoS2.HandlePubrec(id); err != nil {
+		return false
+	}
+
+	return s.inflight.MarkReleased(id)
+}
+
+// pubcomp completes the QoS 2 flow of id.
+func (s *brokerSession) pubcomp(id uint16) bool {
+	if err := s.outboundQoS2.HandlePubcomp(id); err != nil {
+		return false
+	}
+
+	return s.complete(id)
+}
+
+// attach makes c the connection of s and sends the unacknowledged messages again, then what is queued.
+func (s *brokerSession) attach(c *conn) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.conn = c
+
+	for _, m := range s.inflight.Drain() {
+		if m.Pubrel {
+			c.write(mqttcodec.NewPubrel(m.PacketID))
+			continue
+		}
+
+		c.write(publishOf(m))
+	}
+
+	s.next()
+}
+
+// detach clears the connection of s if it is still c.
+func (s *brokerSession) detach(c *conn) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.conn != c {
+		return false
+	}
+
+	s.conn = nil
+
+	return true
+}
+
+// takeWill returns the will of the connection and clears it, so it is published at most once.
+func (s *brokerSession) takeWill() *session.WillConfig {
+	s.Lock()
+	defer s.Unlock()
+
+	var will = s.will
+	s.will = nil
+
+	return will
+}
+
+// hasWill reports whether the will is still there, a normal DISCONNECT takes it.
+func (s *brokerSession) hasWill() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.will != nil
+}
+
+// info is the ClientInfo of the latest connection of s.
+func (s *brokerSession) info() ClientInfo {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.client
+}
+
+// connected reports whether s has a connection, or is a session inside the process.
+func (s *brokerSession) connected() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.local != nil || s.conn != nil
+}
+
+// load is the number of messages s has inflight or queued.
+func (s *brokerSession) load() int {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.inflight.Len() + len(s.queue)
+}
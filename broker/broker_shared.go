@@ -0,0 +1,319 @@
+package broker
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/*
+A filter of the form $share/{group}/{filter} is a shared subscription (MQTT 5, most 3.1.1 brokers take it as well):
+the clients subscribed to it with the same group name form a group, and every message matching filter goes to one of
+them instead of to all. The groups sit in their own trie keyed by filter with the group name as the user, routing a
+PUBLISH does a second MatchEach over it and picks one member of every group that matches.
+
+The member is picked among the ones allowed to receive the topic, connected ones are preferred, and only when none is
+connected does the message go to the queue of an offline one. How it is picked is the group's ShareStrategy, taken
+from Options.ShareStrategy (or ShareStrategyFor) when the group gets its first member:
+
+ - ShareRoundRobin takes the members in turn.
+ - ShareRandom takes any of them.
+ - ShareStickyByTopic sends every message of one topic to the same member, as long as the member stays. It is
+   rendezvous hashing, a member joining or leaving only moves the topics it gets or had.
+ - ShareLeastInflight takes the member with the fewest messages inflight or queued, in turn on a tie.
+
+A shared subscription gets no retained messages and can not have No Local set. Every node of a cluster balances among
+its own members, a message published on one node is delivered once per node with members of the group.
+*/
+
+type ShareStrategy int
+
+const (
+	ShareRoundRobin ShareStrategy = iota
+	ShareRandom
+	ShareStickyByTopic
+	ShareLeastInflight
+)
+
+const sharePrefix = "$share/"
+
+var shareStrategyNames = map[ShareStrategy]string{
+	ShareRoundRobin:    "round-robin",
+	ShareRandom:        "random",
+	ShareStickyByTopic: "sticky-by-topic",
+	ShareLeastInflight: "least-inflight",
+}
+
+// SharedGroupStats are the delivery metrics of one shared subscription group.
+type SharedGroupStats struct {
+	Group    string
+	Filter   string
+	Strategy ShareStrategy
+	// Members are the client ids of the group, in the order they joined.
+	Members []string
+	// Delivered counts the messages every client got from the group, members that left included.
+	Delivered map[string]uint64
+	// Dropped counts the messages that matched while no member was allowed to receive them.
+	Dropped uint64
+}
+
+type shareGroup struct {
+	sync.Mutex
+	name      string
+	filter    string
+	strategy  ShareStrategy
+	members   []*subscription
+	next      int
+	delivered map[string]uint64
+	dropped   uint64
+}
+
+func (s ShareStrategy) String() string {
+	if name, ok := shareStrategyNames[s]; ok {
+		return name
+	}
+
+	return "unknown"
+}
+
+// splitShared splits $share/{group}/{filter} into its group and filter, shared is false (and filter the whole of it)
+// for any other filter.
+func splitShared(full string) (group, filter string, shared bool) {
+	if !strings.HasPrefix(full, sharePrefix) {
+		return "", full, false
+	}
+
+	group, filter, _ = strings.Cut(full[len(sharePrefix):], "/")
+
+	return group, filter, true
+}
+
+// validShared reports whether a valid filter is a well formed shared subscription, or not a shared one at all.
+func validShared(full string) bool {
+	var group, filter, shared = splitShared(full)
+
+	return !shared || (group != "" && filter != "" && !strings.ContainsAny(group, "+#"))
+}
+
+func (b *Broker) shareStrategy(group, filter string) ShareStrategy {
+	if b.options.ShareStrategyFor != nil {
+		return b.options.ShareStrategyFor(group, filter)
+	}
+
+	return b.options.ShareStrategy
+}
+
+// joinShared adds sub to the group under filter, or replaces the member it had for the same client, b.mu is held. It
+// returns whether the client is a new member.
+func (b *Broker) joinShared(group, filter string, sub *subscription) bool {
+	var g = b.shared.Get(filter)[group]
+
+	if g == nil {
+		g = &shareGroup{name: group, filter: filter, strategy: b.shareStrategy(group, filter), delivered: make(map[string]uint64)}
+		b.shared.Add(filter, group, g)
+	}
+
+	g.Lock()
+	defer g.Unlock()
+
+	for i, m := range g.members {
+		if m.session.clientID == sub.session.clientID {
+			g.members[i] = sub
+			return false
+		}
+	}
+
+	g.members = append(g.members, sub)
+
+	return true
+}
+
+// leaveShared takes clientID out of the group under filter, a group without members is gone. b.mu is held.
+func (b *Broker) leaveShared(group, filter, clientID string) bool {
+	var g = b.shared.Get(filter)[group]
+
+	if g == nil {
+		return false
+	}
+
+	g.Lock()
+	defer g.Unlock()
+
+	for i, m := range g.members {
+		if m.session.clientID != clientID {
+			continue
+		}
+
+		g.members = append(g.members[:i], g.members[i+1:]...)
+
+		if len(g.members) == 0 {
+			b.shared.Remove(filter, group)
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// sharedMember returns the subscription of clientID in the group under filter, b.mu is held.
+func (b *Broker) sharedMember(group, filter, clientID string) *subscription {
+	var g = b.shared.Get(filter)[group]
+
+	if g == nil {
+		return nil
+	}
+
+	g.Lock()
+	defer g.Unlock()
+
+	for _, m := range g.members {
+		if m.session.clientID == clientID {
+			return m
+		}
+	}
+
+	return nil
+}
+
+// SharedGroups returns the metrics of every shared subscription group, sorted by filter and group.
+func (b *Broker) SharedGroups() []SharedGroupStats {
+	var groups []*shareGroup
+
+	b.mu.RLock()
+	for filter := range b.filters {
+		for _, g := range b.shared.Get(filter) {
+			groups = append(groups, g)
+		}
+	}
+	b.mu.RUnlock()
+
+	var stats = make([]SharedGroupStats, 0, len(groups))
+
+	for _, g := range groups {
+		stats = append(stats, g.stats())
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Filter != stats[j].Filter {
+			return stats[i].Filter < stats[j].Filter
+		}
+		return stats[i].Group < stats[j].Group
+	})
+
+	return stats
+}
+
+func (g *shareGroup) stats() SharedGroupStats {
+	g.Lock()
+	defer g.Unlock()
+
+	var s = SharedGroupStats{
+		Group:     g.name,
+		Filter:    g.filter,
+		Strategy:  g.strategy,
+		Members:   make([]string, 0, len(g.members)),
+		Delivered: make(map[string]uint64, len(g.delivered)),
+		Dropped:   g.dropped,
+	}
+
+	for _, m := range g.members {
+		s.Members = append(s.Members, m.session.clientID)
+	}
+
+	for clientID, n := range g.delivered {
+		s.Delivered[clientID] = n
+	}
+
+	return s
+}
+
+// pick returns the member that gets a message on topic, nil when no member may receive it.
+func (g *shareGroup) pick(b *Broker, topic string) *subscription {
+	g.Lock()
+	var members = append([]*subscription(nil), g.members...)
+	g.Unlock()
+
+	// -- the Authorizer runs without the group's lock
+	var candidates, connected []*subscription
+
+	for _, m := range members {
+		if m.session.local == nil && !b.canReceive(m.session.info(), topic) {
+			continue
+		}
+
+		candidates = append(candidates, m)
+
+		if m.session.connected() {
+			connected = append(connected, m)
+		}
+	}
+
+	if len(connected) > 0 {
+		candidates = connected
+	}
+	// --
+
+	g.Lock()
+	defer g.Unlock()
+
+	if len(candidates) == 0 {
+		g.dropped++
+		return nil
+	}
+
+	var chosen = g.choose(candidates, topic)
+	g.delivered[chosen.session.clientID]++
+
+	return chosen
+}
+
+// choose applies the strategy of g to candidates, g is locked.
+func (g *shareGroup) choose(candidates []*subscription, topic string) *subscription {
+	var n = len(candidates)
+
+	switch g.strategy {
+	case ShareRandom:
+		return candidates[rand.Intn(n)]
+
+	case ShareStickyByTopic:
+		var chosen *subscription
+		var best uint64
+
+		for _, m := range candidates {
+			var h = fnv.New64a()
+			h.Write([]byte(m.session.clientID))
+			h.Write([]byte{0})
+			h.Write([]byte(topic))
+
+			if weight := h.Sum64(); chosen == nil || weight > best {
+				chosen, best = m, weight
+			}
+		}
+
+		return chosen
+
+	case ShareLeastInflight:
+		var chosen *subscription
+		var least int
+
+		for i := 0; i < n; i++ {
+			var m = candidates[(g.next+i)%n]
+
+			if load := m.session.load(); chosen == nil || load < least {
+				chosen, least = m, load
+			}
+		}
+
+		g.next++
+
+		return chosen
+	}
+
+	var chosen = candidates[g.next%n]
+	g.next++
+
+	return chosen
+}
@@ -0,0 +1,142 @@
+package broker_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/MarcusOuelletus/demo/broker"
+	"github.com/MarcusOuelletus/demo/brokertest"
+	"github.com/MarcusOuelletus/demo/client"
+)
+
+// member joins the group workers on jobs/# as clientID, the topics it gets go to the returned channel.
+func member(t *testing.T, s *brokertest.Server, clientID string) (*brokertest.Client, chan string) {
+	t.Helper()
+
+	var c = s.Client(clientID, nil)
+	var topics = make(chan string, 64)
+
+	if err := c.Client.Subscribe(timeout(t), "$share/workers/jobs/#", client.SubscribeOptions{QoS: 1}, func(m client.Message) { topics <- m.Topic }); err != nil {
+		t.Fatal(err)
+	}
+
+	return c, topics
+}
+
+func TestSharedSubscription(t *testing.T) {
+	for _, strategy := range []broker.ShareStrategy{broker.ShareRoundRobin, broker.ShareRandom, broker.ShareStickyByTopic, broker.ShareLeastInflight} {
+		var s = brokertest.Start(t, broker.Options{ShareStrategy: strategy})
+		var members = make(map[string]*brokertest.Client)
+		var channels = make(map[string]chan string)
+
+		for i := 0; i < 3; i++ {
+			var id = fmt.Sprintf("worker%d", i)
+			members[id], channels[id] = member(t, s, id)
+		}
+
+		var plain = s.Client("plain", nil)
+
+		plain.Subscribe("jobs/#", 1)
+
+		// -- every message goes to one member of the group, and to every plain subscription as usual
+		var pub = s.Client("pub", nil)
+
+		for i := 0; i < 30; i++ {
+			pub.Publish(fmt.Sprintf("jobs/%d", i%3), "job", 1)
+		}
+
+		var got = make(map[string][]string)
+		var total int
+
+		for id, c := range members {
+			c.Sync()
+
+			for len(channels[id]) > 0 {
+				got[id] = append(got[id], <-channels[id])
+			}
+
+			total += len(got[id])
+		}
+
+		if total != 30 {
+			t.Fatalf("%v: the group got %d of 30 messages: %v", strategy, total, got)
+		}
+
+		for i := 0; i < 30; i++ {
+			plain.Next()
+		}
+		// --
+
+		// -- round-robin takes the members in turn, sticky-by-topic keeps every topic with one member
+		switch strategy {
+		case broker.ShareRoundRobin:
+			for id, topics := range got {
+				if len(topics) != 10 {
+					t.Fatalf("%v: %s got %d messages", strategy, id, len(topics))
+				}
+			}
+		case broker.ShareStickyByTopic:
+			var owners = make(map[string]string)
+
+			for id, topics := range got {
+				for _, topic := range topics {
+					if owner, ok := owners[topic]; ok && owner != id {
+						t.Fatalf("%v: %s went to %s and %s", strategy, topic, owner, id)
+					}
+
+					owners[topic] = id
+				}
+			}
+		}
+		// --
+
+		// -- the group's stats count what every member got
+		var stats = s.Broker.SharedGroups()
+
+		if len(stats) != 1 || stats[0].Group != "workers" || stats[0].Filter != "jobs/#" || stats[0].Strategy != strategy || len(stats[0].Members) != 3 {
+			t.Fatalf("%v: stats %+v", strategy, stats)
+		}
+
+		for id, topics := range got {
+			if n := stats[0].Delivered[id]; n != uint64(len(topics)) {
+				t.Fatalf("%v: %s delivered %d, got %d", strategy, id, n, len(topics))
+			}
+		}
+		// --
+	}
+}
+
+func TestSharedSubscriptionFilters(t *testing.T) {
+	var s = brokertest.Start(t, broker.Options{})
+	var c = s.Client("c", nil)
+
+	// -- a share name has to be there and cannot have wildcards, the filter has to be there too
+	for _, filter := range []string{"$share/+/jobs", "$share/workers", "$share//jobs"} {
+		if err := c.Client.Subscribe(timeout(t), filter, client.SubscribeOptions{QoS: 1}, func(client.Message) {}); err == nil {
+			t.Errorf("subscribed to %s", filter)
+		}
+	}
+	// --
+
+	// -- a shared subscription gets no retained messages
+	s.Client("pub", nil).PublishRetained("jobs/retained", "retained", 1)
+
+	var worker, jobs = member(t, s, "worker")
+
+	worker.Sync()
+
+	if len(jobs) != 0 {
+		t.Fatalf("the group got %s", <-jobs)
+	}
+	// --
+
+	// -- the group is gone with its last member
+	if err := worker.Unsubscribe(timeout(t), "$share/workers/jobs/#"); err != nil {
+		t.Fatal(err)
+	}
+
+	if groups := s.Broker.SharedGroups(); len(groups) != 0 {
+		t.Fatalf("groups %+v", groups)
+	}
+	// --
+}
@@ -0,0 +1,104 @@
+package broker
+
+import (
+	"context"
+	"net"
+
+	"github.com/MarcusOuelletus/demo/modules/logger"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/reasoncodes"
+)
+
+/*
+Shutdown takes the broker down without losing what its sessions hold, where Close just drops every connection:
+
+ 1. The listeners close, no new connection is accepted.
+ 2. The wills are settled as if the process ended every session it cannot hand over. A session without a SessionStore
+    (or with a Session Expiry Interval of 0) ends with the broker, so its will is published now, whatever its Will
+    Delay Interval. A session kept by the store outlives the broker: its will is published now only without a delay,
+    a delayed one is dropped, the client has the delay to reconnect to the broker that resumes the session and no
+    broker is left to time it.
+ 3. Every connection gets what is queued for it and then a DISCONNECT with Server shutting down (3.1.1 has none, the
+    connection is just closed), the wills of step 2 go out before it to the subscribers that are still connected.
+ 4. Once every connection ended, or ctx is done and the remaining ones are dropped, the session timers stop and every
+    session the store keeps is saved with its subscriptions, its unacknowledged and queued QoS 1 and 2 messages, its
+    packet ids and the QoS 2 packet ids that wait for their PUBREL, so a broker that loads it resumes every flow.
+
+Like Close, Shutdown does not close the stores.
+*/
+
+// Shutdown stops the broker gracefully, it returns ctx.Err() when connections had to be dropped.
+func (b *Broker) Shutdown(ctx context.Context) error {
+	b.mu.Lock()
+	b.closed = true
+	var listeners = b.listeners
+	var conns = make([]*conn, 0, len(b.conns))
+
+	for c := range b.conns {
+		conns = append(conns, c)
+	}
+	b.listeners = make(map[net.Listener]struct{})
+	b.mu.Unlock()
+
+	for l := range listeners {
+		l.Close()
+	}
+
+	var sessions = b.sessionList()
+
+	for _, s := range sessions {
+		b.settleWill(s)
+	}
+
+	for _, c := range conns {
+		c.shutdown()
+	}
+
+	var err error
+
+wait:
+	for _, c := range conns {
+		select {
+		case <-c.finished:
+		case <-ctx.Done():
+			err = ctx.Err()
+			b.log.Warn("broker: shutdown dropped connections", logger.Fields{"error": err})
+			break wait
+		}
+	}
+
+	b.Close()
+
+	for _, s := range sessions {
+		s.lifecycle.Stop()
+		b.save(s)
+	}
+
+	return err
+}
+
+// settleWill takes the will of s and publishes it unless s outlives the broker and its will is delayed.
+func (b *Broker) settleWill(s *brokerSession) {
+	var will = s.takeWill()
+
+	if will == nil {
+		return
+	}
+
+	if b.options.Sessions != nil && s.expiry() != 0 && will.Delay() > 0 {
+		return
+	}
+
+	b.route(will.Publish(), s.clientID)
+}
+
+// shutdown queues a DISCONNECT with Server shutting down behind what is queued for the client, the writeLoop closes the
+// connection once it wrote it. A connection still in its handshake is closed right away.
+func (c *conn) shutdown() {
+	if !c.writing.Load() {
+		c.close()
+		return
+	}
+
+	go c.write(&mqttcodec.Disconnection{ReasonCode: byte(reasoncodes.ServerShuttingDown)})
+}
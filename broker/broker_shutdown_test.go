@@ -0,0 +1,118 @@
+package broker_test
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/broker"
+	"github.com/MarcusOuelletus/demo/brokertest"
+	"github.com/MarcusOuelletus/demo/client"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/reasoncodes"
+	"github.com/MarcusOuelletus/demo/session"
+)
+
+func TestShutdown(t *testing.T) {
+	var store = session.NewMemoryStore()
+	var first = brokertest.Start(t, broker.Options{Sessions: store})
+
+	// -- sub dials whichever broker target is, so the same client moves to the broker that resumes its session
+	var target atomic.Pointer[brokertest.Server]
+
+	target.Store(first)
+
+	var options = first.Options("sub")
+
+	options.ProtocolVersion = mqttcodec.Version5
+	persistent(&options)
+	options.Dial = func(address string, timeout time.Duration) (net.Conn, error) {
+		return target.Load().Dial(address, timeout)
+	}
+
+	var sub = client.New(options)
+
+	if err := sub.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { sub.Disconnect() })
+
+	var messages = make(chan client.Message, 8)
+
+	if err := sub.Subscribe(timeout(t), "a", client.SubscribeOptions{QoS: 2}, func(m client.Message) { messages <- m }); err != nil {
+		t.Fatal(err)
+	}
+
+	sub.Disconnect()
+
+	for _, payload := range []string{"1", "2", "3"} {
+		first.Broker.Publish("a", []byte(payload), 2, false)
+	}
+	// --
+
+	// -- the connected clients: one watching the wills, one with a will and a session that ends with the broker, one
+	// whose session the store keeps with a will without delay, and one with a delayed will
+	var lost = make(chan error, 1)
+
+	var watcher = first.Client("watcher", func(o *client.ClientOptions) {
+		o.ProtocolVersion = mqttcodec.Version5
+		o.OnConnectionLost = func(err error) { lost <- err }
+	})
+
+	// at QoS 0, a PUBACK on its way while the broker closes the pipe would end the connection before the DISCONNECT is read
+	watcher.Subscribe("will/+", 0)
+
+	first.Client("ends", will("ends", 0, 0))
+	first.Client("kept", will("kept", 0, 60))
+	first.Client("delayed", will("delayed", 10, 60))
+	// --
+
+	if err := first.Broker.Shutdown(timeout(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	// -- the wills due now reach the watcher before its DISCONNECT with Server shutting down, the delayed one is dropped
+	var wills = map[string]bool{watcher.Next().Topic: true, watcher.Next().Topic: true}
+
+	if !wills["will/ends"] || !wills["will/kept"] {
+		t.Fatalf("wills %v", wills)
+	}
+
+	var disconnected *client.DisconnectError
+
+	if err := next(t, lost); !errors.As(err, &disconnected) || disconnected.ReasonCode != reasoncodes.ServerShuttingDown {
+		t.Fatalf("lost with %v", err)
+	}
+	// --
+
+	// -- the store has the session with its subscription and the three QoS 2 messages, the clean one is gone
+	state, err := store.Load("sub")
+
+	if err != nil || len(state.Subscriptions) != 1 || len(state.Inflight) != 3 {
+		t.Fatalf("stored %+v: %v", state, err)
+	}
+
+	if _, err := store.Load("ends"); err == nil {
+		t.Fatal("the store kept the session of a clean client")
+	}
+	// --
+
+	// -- a broker on the same store resumes the session and every flow of it, in order
+	var second = brokertest.Start(t, broker.Options{Sessions: store})
+
+	target.Store(second)
+
+	if err := sub.Connect(); err != nil || !sub.SessionPresent() {
+		t.Fatalf("reconnect: %v, session present %v", err, sub.SessionPresent())
+	}
+
+	for _, want := range []string{"1", "2", "3"} {
+		if m := next(t, messages); string(m.Payload) != want || m.QoS != 2 {
+			t.Fatalf("got %q with qos %d, want %q", m.Payload, m.QoS, want)
+		}
+	}
+	// --
+}
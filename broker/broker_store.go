@@ -0,0 +1,211 @@
+package broker
+
+import (
+	"time"
+
+	"github.com/MarcusOuelletus/demo/modules/logger"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/packetids"
+	"github.com/MarcusOuelletus/demo/session"
+)
+
+/*
+With Options.Sessions the subscriptions of every session that outlives its connection are kept in a
+session.SessionStore as well, so a broker that restarts, or another broker that shares the store (a RedisStore for
+example), resumes them with Session Present when the client connects again:
+
+ - A CONNECT without Clean Start that finds no session in memory loads the subscriptions from the store, one with
+   Clean Start deletes what the store has.
+ - Every SUBSCRIBE and UNSUBSCRIBE of a session with a Session Expiry Interval saves its subscriptions.
+ - A session that expires is deleted. An ExpiringStore is told the Session Expiry Interval when the connection ends
+   instead, and to keep the state when the client comes back, so the state expires with the session even when the
+   broker holding it is gone.
+
+While the broker runs only the subscriptions are saved, the queued and unacknowledged messages stay in its memory until
+Shutdown saves them with the rest of the session. A failing store does not fail the connection, the broker goes on with
+what it has in memory, the failure is logged.
+*/
+
+// restore gives s what the store has for its client id and returns whether there was a session to resume. The
+// messages come back in flight or queued as they were saved, their Message Expiry Interval starts again.
+func (b *Broker) restore(s *brokerSession) bool {
+	if b.options.Sessions == nil {
+		return false
+	}
+
+	state, err := b.options.Sessions.Load(s.clientID)
+
+	if err != nil {
+		if err != session.ErrSessionNotFound {
+			b.storeFailed("load", s.clientID, err)
+		}
+		return false
+	}
+
+	for _, sub := range state.Subscriptions {
+		b.subscribe(s, sub)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.ids = packetids.NewFromSnapshot(state.PacketIDs)
+	s.inflight = session.NewInflightStore(s.ids, nil, s.clock)
+	s.inboundQoS2.Restore(state.PendingQoS2)
+
+	for _, m := range state.Inflight {
+		if m.PacketID == 0 {
+			s.enqueue(publishOf(m))
+			continue
+		}
+
+		s.inflight.Add(m)
+
+		if m.QoS == 2 {
+			s.outboundQoS2.Start(m.PacketID)
+		}
+
+		if m.Pubrel {
+			s.outboundQoS2.HandlePubrec(m.PacketID)
+			s.inflight.MarkReleased(m.PacketID)
+		}
+	}
+
+	// -- the messages are the session's now, a later crash must not bring them back a second time
+	b.storeFailed("update", s.clientID, b.options.Sessions.Update(s.clientID, func(state *session.SessionState) {
+		state.Inflight, state.PacketIDs, state.PendingQoS2 = nil, packetids.Snapshot{}, nil
+	}))
+	// --
+
+	return true
+}
+
+// forget deletes what the store has for clientID, a clean start replaces it.
+func (b *Broker) forget(clientID string) {
+	if b.options.Sessions != nil {
+		b.storeFailed("delete", clientID, b.options.Sessions.Delete(clientID))
+	}
+}
+
+// persist saves the subscriptions of s, unless the session ends with its connection.
+func (b *Broker) persist(s *brokerSession) {
+	if b.options.Sessions == nil || s.expiry() == 0 {
+		return
+	}
+
+	var subs = s.subscriptionList()
+
+	b.storeFailed("update", s.clientID, b.options.Sessions.Update(s.clientID, func(state *session.SessionState) {
+		state.Subscriptions = subs
+	}))
+}
+
+// reconnected keeps the state of s in an ExpiringStore while it has a connection.
+func (b *Broker) reconnected(s *brokerSession) {
+	if store, ok := b.options.Sessions.(session.ExpiringStore); ok && s.expiry() != 0 {
+		b.storeFailed("persist", s.clientID, store.Persist(s.clientID))
+	}
+}
+
+// lost hands the Session Expiry Interval of s to an ExpiringStore once its connection ended.
+func (b *Broker) lost(s *brokerSession) {
+	var store, ok = b.options.Sessions.(session.ExpiringStore)
+	var expiry = s.expiry()
+
+	if !ok || expiry == 0 || expiry == session.SessionNeverExpires {
+		return
+	}
+
+	b.storeFailed("expire", s.clientID, store.Expire(s.clientID, time.Duration(expiry)*time.Second))
+}
+
+// expired deletes the state of s once it expired, an ExpiringStore does that by itself unless s ended with its
+// connection.
+func (b *Broker) expired(s *brokerSession) {
+	if b.options.Sessions == nil {
+		return
+	}
+
+	if _, ok := b.options.Sessions.(session.ExpiringStore); ok && s.expiry() != 0 {
+		return
+	}
+
+	b.storeFailed("delete", s.clientID, b.options.Sessions.Delete(s.clientID))
+}
+
+// save writes all of s to the store, unless the session ends with its connection.
+func (b *Broker) save(s *brokerSession) {
+	if b.options.Sessions == nil || s.expiry() == 0 {
+		return
+	}
+
+	b.storeFailed("save", s.clientID, b.options.Sessions.Save(s.state()))
+}
+
+// storeFailed logs err of the store operation op, if there is one.
+func (b *Broker) storeFailed(op, clientID string, err error) {
+	if err != nil {
+		b.log.Warn("broker: session store failed", logger.Fields{"op": op, logger.ClientIDField: clientID, "error": err})
+	}
+}
+
+// state is the SessionState of s: the inflight messages in the order they were sent with their packet ids, then the
+// queued QoS 1 and 2 ones without.
+func (s *brokerSession) state() *session.SessionState {
+	var subs = s.subscriptionList()
+
+	s.Lock()
+	defer s.Unlock()
+
+	var state = &session.SessionState{
+		ClientID:      s.clientID,
+		Subscriptions: subs,
+		PacketIDs:     s.ids.Snapshot(),
+		PendingQoS2:   s.inboundQoS2.Pending(),
+	}
+
+	state.Inflight = s.inflight.Messages()
+
+	for _, q := range s.queue {
+		if q.publish.QoS > 0 {
+			var m = inflightMessage(q.publish)
+			m.PacketID = 0
+			state.Inflight = append(state.Inflight, m)
+		}
+	}
+
+	return state
+}
+
+// inflightMessage is what the InflightStore and the SessionStore keep of p.
+func inflightMessage(p *mqttcodec.Publish) *session.InflightMessage {
+	return &session.InflightMessage{
+		PacketID:   p.PacketID,
+		Topic:      p.TopicName,
+		Payload:    p.Payload,
+		QoS:        p.QoS,
+		Retain:     p.Retain,
+		Properties: p.Properties,
+	}
+}
+
+// publishOf is the PUBLISH of m, with DUP set when m was sent before.
+func publishOf(m *session.InflightMessage) *mqttcodec.Publish {
+	return &mqttcodec.Publish{
+		TopicName:  m.Topic,
+		Payload:    m.Payload,
+		QoS:        m.QoS,
+		Retain:     m.Retain,
+		Dup:        m.Dup,
+		PacketID:   m.PacketID,
+		Properties: m.Properties,
+	}
+}
+
+// expiry is the Session Expiry Interval of s.
+func (s *brokerSession) expiry() uint32 {
+	s.lifecycle.Lock()
+	defer s.lifecycle.Unlock()
+
+	return s.lifecycle.SessionExpiry
+}
@@ -0,0 +1,237 @@
+package broker
+
+import (
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+)
+
+/*
+With Options.SysInterval set the broker publishes its own state under $SYS/broker/ every interval, as retained messages
+so a monitoring client that subscribes to $SYS/# gets the current values right away. The topics follow the ones most
+brokers publish:
+
+ - version, uptime (in seconds)
+ - clients/connected, clients/disconnected (sessions without a connection), clients/total, clients/maximum
+ - messages/received, messages/sent (PUBLISH packets), bytes/received, bytes/sent
+ - subscriptions/count, retained messages/count, queue/bytes (queued by every session, see Options.QueueMemory)
+ - load/messages/received/1min (5min, 15min), the same for messages/sent, bytes/received and bytes/sent: exponentially
+   weighted moving averages of the rate per minute, recomputed every interval
+
+A value is only published again when it changed. A SUBSCRIBE to a $SYS filter publishes the current values before the
+subscription gets the retained ones, so a monitoring client does not start from the values of the last interval, the
+load averages are only recomputed every interval. $SYS topics are reserved for the broker: a client publishing to one
+is refused with Not authorized, and the messages are never forwarded to the peers of a cluster.
+*/
+
+// Version is published as $SYS/broker/version.
+var Version = "demo-broker 1.0"
+
+const (
+	sysPrefix = "$SYS/"
+	// sysClientID is the publisher of the $SYS messages.
+	sysClientID = "$sys"
+)
+
+// brokerStats are the counters behind $SYS, all of them only ever grow.
+type brokerStats struct {
+	messagesReceived atomic.Uint64
+	messagesSent     atomic.Uint64
+	bytesReceived    atomic.Uint64
+	bytesSent        atomic.Uint64
+	maxClients       atomic.Int64
+}
+
+// sysPublisher publishes the $SYS topics of one broker until its done channel closes, mu serializes the interval and
+// the refreshes of SUBSCRIBE.
+type sysPublisher struct {
+	mu       sync.Mutex
+	broker   *Broker
+	started  time.Time
+	interval time.Duration
+	last     map[string]string
+	loads    map[string]*loadAverage
+}
+
+// countingConn counts the bytes read from and written to the connection it wraps.
+type countingConn struct {
+	net.Conn
+	stats *brokerStats
+}
+
+// loadAverage is the 1, 5 and 15 minute moving average of the rate of one counter.
+type loadAverage struct {
+	last    uint64
+	started bool
+	average [3]float64
+}
+
+var loadWindows = [3]struct {
+	name   string
+	window time.Duration
+}{{"1min", time.Minute}, {"5min", 5 * time.Minute}, {"15min", 15 * time.Minute}}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.stats.bytesReceived.Add(uint64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.stats.bytesSent.Add(uint64(n))
+	return n, err
+}
+
+// connected records that one more client is connected, for clients/maximum.
+func (s *brokerStats) connected(n int) {
+	for {
+		var max = s.maxClients.Load()
+
+		if int64(n) <= max || s.maxClients.CompareAndSwap(max, int64(n)) {
+			return
+		}
+	}
+}
+
+func newSysPublisher(b *Broker, interval time.Duration) *sysPublisher {
+	return &sysPublisher{
+		broker:   b,
+		started:  b.options.Clock.Now(),
+		interval: interval,
+		last:     make(map[string]string),
+		loads:    make(map[string]*loadAverage),
+	}
+}
+
+func (b *Broker) runSys(done chan struct{}) {
+	var ticker = b.options.Clock.NewTicker(b.sys.interval)
+	defer ticker.Stop()
+
+	b.sys.publish(true)
+
+	for {
+		select {
+		case <-ticker.C():
+			b.sys.publish(true)
+		case <-done:
+			return
+		}
+	}
+}
+
+// refreshSys publishes the current $SYS values when filter is a $SYS one.
+func (b *Broker) refreshSys(filter string) {
+	if b.sys != nil && strings.HasPrefix(filter, sysPrefix) {
+		b.sys.publish(false)
+	}
+}
+
+// publish publishes the values that changed, interval is set once an interval passed and the load averages are due.
+func (p *sysPublisher) publish(interval bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var b = p.broker
+	var stats = &b.stats
+
+	b.mu.RLock()
+	var sessions = len(b.sessions)
+	var subscriptions int
+
+	for _, n := range b.filters {
+		subscriptions += n
+	}
+	b.mu.RUnlock()
+
+	var connected = b.registry.Len()
+	var disconnected = sessions - connected
+
+	if disconnected < 0 {
+		disconnected = 0
+	}
+
+	var counters = []struct {
+		topic string
+		value uint64
+	}{
+		{"messages/received", stats.messagesReceived.Load()},
+		{"messages/sent", stats.messagesSent.Load()},
+		{"bytes/received", stats.bytesReceived.Load()},
+		{"bytes/sent", stats.bytesSent.Load()},
+	}
+
+	var values = map[string]string{
+		"version":                 Version,
+		"uptime":                  strconv.FormatInt(int64(b.options.Clock.Now().Sub(p.started)/time.Second), 10) + " seconds",
+		"clients/connected":       strconv.Itoa(connected),
+		"clients/disconnected":    strconv.Itoa(disconnected),
+		"clients/total":           strconv.Itoa(sessions),
+		"clients/maximum":         strconv.FormatInt(stats.maxClients.Load(), 10),
+		"subscriptions/count":     strconv.Itoa(subscriptions),
+		"retained messages/count": strconv.Itoa(b.options.Retained.Len()),
+		"queue/bytes":             strconv.FormatInt(b.QueuedBytes(), 10),
+	}
+
+	for _, c := range counters {
+		values[c.topic] = strconv.FormatUint(c.value, 10)
+
+		if !interval {
+			continue
+		}
+
+		var load = p.loads[c.topic]
+
+		if load == nil {
+			load = &loadAverage{}
+			p.loads[c.topic] = load
+		}
+
+		for i, average := range load.update(c.value, p.interval) {
+			values["load/"+c.topic+"/"+loadWindows[i].name] = strconv.FormatFloat(average, 'f', 2, 64)
+		}
+	}
+
+	for topic, value := range values {
+		if p.last[topic] == value {
+			continue
+		}
+
+		p.last[topic] = value
+
+		m, err := mqttcodec.NewPublishBuilder(sysPrefix + "broker/" + topic).Payload([]byte(value)).Retain(true).Build()
+
+		if err == nil {
+			b.route(m, sysClientID)
+		}
+	}
+}
+
+// update takes the counter's value after one more interval and returns the three averages, as a rate per minute.
+func (l *loadAverage) update(value uint64, interval time.Duration) [3]float64 {
+	var rate float64
+
+	if l.started {
+		rate = float64(value-l.last) / interval.Minutes()
+	}
+
+	for i, w := range loadWindows {
+		var decay = math.Exp(-interval.Seconds() / w.window.Seconds())
+
+		if !l.started {
+			decay = 1
+		}
+
+		l.average[i] = l.average[i]*decay + rate*(1-decay)
+	}
+
+	l.last, l.started = value, true
+
+	return l.average
+}
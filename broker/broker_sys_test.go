@@ -0,0 +1,76 @@
+package broker_test
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/broker"
+	"github.com/MarcusOuelletus/demo/brokertest"
+	"github.com/MarcusOuelletus/demo/client"
+)
+
+func sysValue(t *testing.T, m client.Message) uint64 {
+	t.Helper()
+
+	v, err := strconv.ParseUint(string(m.Payload), 10, 64)
+
+	if err != nil {
+		t.Fatalf("%s is %q: %v", m.Topic, m.Payload, err)
+	}
+
+	return v
+}
+
+func TestSysCountsTraffic(t *testing.T) {
+	var s = brokertest.Start(t, broker.Options{SysInterval: 10 * time.Millisecond})
+	var monitor = s.Client("monitor", nil)
+
+	monitor.Subscribe("$SYS/broker/+/received", 0)
+
+	// -- the retained values are refreshed by the SUBSCRIBE, in topic order, the monitor's CONNECT is counted already
+	var values = make(map[string]uint64)
+
+	for _, topic := range []string{"$SYS/broker/bytes/received", "$SYS/broker/messages/received"} {
+		var m = monitor.Next()
+
+		if m.Topic != topic || !m.Retain {
+			t.Fatalf("got %s (retain %v), want the retained %s", m.Topic, m.Retain, topic)
+		}
+
+		values[topic] = sysValue(t, m)
+	}
+
+	if values["$SYS/broker/bytes/received"] == 0 {
+		t.Fatal("bytes/received is 0 after a CONNECT and a SUBSCRIBE")
+	}
+	// --
+
+	// -- 10 PUBLISH packets of 1000 bytes each show up in the next intervals
+	var pub = s.Client("pub", nil)
+
+	for i := 0; i < 10; i++ {
+		pub.Publish("a/b", strings.Repeat("x", 1000), 1)
+	}
+
+	var want = map[string]uint64{
+		"$SYS/broker/bytes/received":    values["$SYS/broker/bytes/received"] + 10*1000,
+		"$SYS/broker/messages/received": values["$SYS/broker/messages/received"] + 10,
+	}
+
+	for len(want) > 0 {
+		var m = monitor.Next()
+
+		var v = sysValue(t, m)
+
+		if v < values[m.Topic] {
+			t.Fatalf("%s went back from %d to %d", m.Topic, values[m.Topic], v)
+		}
+
+		if values[m.Topic] = v; v >= want[m.Topic] {
+			delete(want, m.Topic)
+		}
+	}
+	// --
+}
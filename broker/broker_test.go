@@ -0,0 +1,176 @@
+package broker_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/broker"
+	"github.com/MarcusOuelletus/demo/brokertest"
+	"github.com/MarcusOuelletus/demo/client"
+	"github.com/MarcusOuelletus/demo/clock"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+)
+
+// timeout is a context for one call of a client, done after brokertest.DefaultTimeout.
+func timeout(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), brokertest.DefaultTimeout)
+	t.Cleanup(cancel)
+
+	return ctx
+}
+
+// next takes the next value of ch, it fails the test after brokertest.DefaultTimeout.
+func next[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+
+	var v T
+
+	select {
+	case v = <-ch:
+	case <-time.After(brokertest.DefaultTimeout):
+		t.Fatalf("no %T within %v", v, brokertest.DefaultTimeout)
+	}
+
+	return v
+}
+
+// persistent makes a client whose session outlives its connection, on 3.1.1 and on MQTT 5.
+func persistent(o *client.ClientOptions) {
+	o.CleanSession = false
+
+	if o.ProtocolVersion == mqttcodec.Version5 {
+		o.ConnectProperties = &mqttcodec.Properties{SessionExpiryInterval: mqttcodec.Uint32(60)}
+	}
+}
+
+func TestRouting(t *testing.T) {
+	for _, version := range []mqttcodec.ProtocolVersion{mqttcodec.Version311, mqttcodec.Version5} {
+		var s = brokertest.Start(t, broker.Options{})
+		var options = func(o *client.ClientOptions) { o.ProtocolVersion = version }
+		var sub, pub = s.Client("sub", options), s.Client("pub", options)
+
+		sub.Subscribe("a/+/c", 2)
+		sub.Subscribe("b/#", 0)
+
+		// -- a message goes out at the lower of the QoS it was published with and the one granted
+		for qos := byte(0); qos <= 2; qos++ {
+			pub.Publish("a/b/c", "a", qos)
+
+			if m := sub.Expect("a/b/c", "a"); m.QoS != qos {
+				t.Fatalf("%v: qos %d arrived with qos %d", version, qos, m.QoS)
+			}
+
+			pub.Publish("b/c", "b", qos)
+
+			if m := sub.Expect("b/c", "b"); m.QoS != 0 {
+				t.Fatalf("%v: qos %d arrived with qos %d on a qos 0 subscription", version, qos, m.QoS)
+			}
+		}
+		// --
+
+		// -- nothing for a topic no filter matches
+		pub.Publish("a/b/d", "none", 1)
+		sub.ExpectNone()
+		// --
+	}
+}
+
+func TestPersistentSession(t *testing.T) {
+	for _, version := range []mqttcodec.ProtocolVersion{mqttcodec.Version311, mqttcodec.Version5} {
+		var s = brokertest.Start(t, broker.Options{})
+
+		var sub = s.Client("sub", func(o *client.ClientOptions) {
+			o.ProtocolVersion = version
+			persistent(o)
+		})
+
+		var pub = s.Client("pub", nil)
+
+		sub.Subscribe("a", 1)
+
+		if err := sub.Disconnect(); err != nil {
+			t.Fatal(err)
+		}
+
+		// -- the QoS 1 messages of the offline session are queued and delivered in order on the next connect
+		for _, payload := range []string{"1", "2", "3"} {
+			pub.Publish("a", payload, 1)
+		}
+
+		if err := sub.Connect(); err != nil {
+			t.Fatal(err)
+		}
+
+		if !sub.SessionPresent() {
+			t.Fatalf("%v: no session present", version)
+		}
+
+		for _, payload := range []string{"1", "2", "3"} {
+			sub.Expect("a", payload)
+		}
+		// --
+	}
+}
+
+func TestCleanSession(t *testing.T) {
+	var s = brokertest.Start(t, broker.Options{})
+	var sub = s.Client("sub", persistent)
+	var pub = s.Client("pub", nil)
+
+	sub.Subscribe("a", 1)
+	sub.Disconnect()
+
+	// -- a clean session starts over, the subscription and the queued message are gone
+	pub.Publish("a", "queued", 1)
+
+	var clean = s.Client("sub", nil)
+
+	if clean.SessionPresent() {
+		t.Fatal("session present on a clean session")
+	}
+
+	pub.Publish("a", "after", 1)
+	clean.ExpectNone()
+	// --
+
+	// -- and the next persistent session does not find the old one either
+	clean.Disconnect()
+
+	if again := s.Client("sub", persistent); again.SessionPresent() {
+		t.Fatal("session present after a clean session")
+	}
+	// --
+}
+
+func TestSessionExpiry(t *testing.T) {
+	var fake = clock.NewFake(time.Unix(0, 0))
+	var s = brokertest.Start(t, broker.Options{Clock: fake})
+
+	var sub = s.Client("sub", func(o *client.ClientOptions) {
+		o.ProtocolVersion = mqttcodec.Version5
+		persistent(o)
+	})
+
+	sub.Subscribe("a", 1)
+
+	// -- the session outlives the connection by its Session Expiry Interval on the broker's clock
+	var pending = fake.Pending()
+
+	sub.Disconnect()
+	fake.BlockUntil(pending + 1)
+	fake.Advance(59 * time.Second)
+
+	if err := sub.Connect(); err != nil || !sub.SessionPresent() {
+		t.Fatalf("reconnect after 59s: %v, session present %v", err, sub.SessionPresent())
+	}
+
+	sub.Disconnect()
+	fake.BlockUntil(pending + 1)
+	fake.Advance(60 * time.Second)
+
+	if err := sub.Connect(); err != nil || sub.SessionPresent() {
+		t.Fatalf("reconnect after 60s: %v, session present %v", err, sub.SessionPresent())
+	}
+	// --
+}
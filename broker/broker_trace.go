@@ -0,0 +1,79 @@
+package broker
+
+import (
+	"github.com/MarcusOuelletus/demo/modules/logger"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+)
+
+/*
+With Options.Logger every connection has a mqttcodec.Tracer once its CONNECT was accepted: its packets are logged as
+logger.Codec at debug level with the client id, limited to DefaultTraceRate per second. TraceClient turns tracing on
+for one client id instead, its packets go out at info level so the client can be followed without debug output from
+everything else, on the connection it has now and on every later one until StopTrace. Without Options.Logger nothing
+is traced.
+*/
+
+// TraceClient logs every packet of clientID at info level as o says.
+func (b *Broker) TraceClient(clientID string, o mqttcodec.TraceOptions) {
+	b.traceMu.Lock()
+	defer b.traceMu.Unlock()
+
+	b.traced[clientID] = o
+
+	if t := b.tracerOf(clientID); t != nil {
+		t.Enable(o)
+	}
+}
+
+// StopTrace ends the tracing of clientID, its packets are logged at debug level again.
+func (b *Broker) StopTrace(clientID string) {
+	b.traceMu.Lock()
+	defer b.traceMu.Unlock()
+
+	delete(b.traced, clientID)
+
+	if t := b.tracerOf(clientID); t != nil {
+		t.Disable()
+	}
+}
+
+// tracerOf returns the Tracer of the connection clientID has, traceMu is locked.
+func (b *Broker) tracerOf(clientID string) *mqttcodec.Tracer {
+	var s = b.sessionOf(clientID)
+
+	if s == nil {
+		return nil
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if s.conn == nil {
+		return nil
+	}
+
+	return s.conn.tracer
+}
+
+// trace sets up the Tracer of c once its client id is known, the writer may be in use already.
+func (b *Broker) trace(c *conn) {
+	if b.options.Logger == nil {
+		return
+	}
+
+	var t = mqttcodec.NewTracer(logger.With(logger.For(b.options.Logger, logger.Codec), logger.Fields{logger.ClientIDField: c.client.ClientID}))
+	t.Rate = mqttcodec.DefaultTraceRate
+
+	b.traceMu.Lock()
+	c.tracer = t
+
+	if o, ok := b.traced[c.client.ClientID]; ok {
+		t.Enable(o)
+	}
+	b.traceMu.Unlock()
+
+	c.reader.Trace = t
+	c.writer.Lock()
+	c.writer.Trace = t
+	c.writer.Unlock()
+}
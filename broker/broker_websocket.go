@@ -0,0 +1,76 @@
+package broker
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/MarcusOuelletus/demo/internal/websocket"
+)
+
+/*
+The server side of MQTT over WebSocket (RFC 6455), the counterpart of the client's: the upgrade has to ask for the
+"mqtt" subprotocol, the connection is then hijacked from net/http and served like any other as the server side of a
+websocket.Conn, which frames it.
+*/
+
+const webSocketProtocol = "mqtt"
+
+// webSocketHandler upgrades the requests of a WebSocket listener and serves them on the handler's goroutine.
+func (b *Broker) webSocketHandler(config *ListenerConfig) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config.Path != "" && r.URL.Path != config.Path {
+			http.NotFound(w, r)
+			return
+		}
+
+		var key = r.Header.Get("Sec-WebSocket-Key")
+
+		if !headerHas(r.Header, "Connection", "upgrade") || !headerHas(r.Header, "Upgrade", "websocket") || key == "" ||
+			r.Header.Get("Sec-WebSocket-Version") != "13" {
+			http.Error(w, "expected a WebSocket upgrade", http.StatusBadRequest)
+			return
+		}
+
+		if !headerHas(r.Header, "Sec-WebSocket-Protocol", webSocketProtocol) {
+			http.Error(w, "expected the mqtt subprotocol", http.StatusBadRequest)
+			return
+		}
+
+		hijacker, ok := w.(http.Hijacker)
+
+		if !ok {
+			http.Error(w, "connection cannot be upgraded", http.StatusInternalServerError)
+			return
+		}
+
+		netConn, rw, err := hijacker.Hijack()
+
+		if err != nil {
+			return
+		}
+
+		fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+			"Sec-WebSocket-Accept: %s\r\nSec-WebSocket-Protocol: %s\r\n\r\n", websocket.Accept(key), webSocketProtocol)
+
+		if rw.Flush() != nil {
+			netConn.Close()
+			return
+		}
+
+		b.serveConn(websocket.Server(netConn, rw.Reader), config)
+	})
+}
+
+// headerHas reports whether one of the comma separated values of the header name is value, ignoring case.
+func headerHas(h http.Header, name, value string) bool {
+	for _, line := range h.Values(name) {
+		for _, v := range strings.Split(line, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), value) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,93 @@
+package broker_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/broker"
+	"github.com/MarcusOuelletus/demo/brokertest"
+	"github.com/MarcusOuelletus/demo/client"
+	"github.com/MarcusOuelletus/demo/clock"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+)
+
+// will gives a client the will "gone" on will/<clientID>, on MQTT 5 with a Will Delay Interval of delay seconds and a
+// Session Expiry Interval of expiry seconds.
+func will(clientID string, delay, expiry uint32) func(o *client.ClientOptions) {
+	return func(o *client.ClientOptions) {
+		o.Will = &client.WillOptions{Topic: "will/" + clientID, Payload: []byte("gone"), QoS: 1}
+
+		if delay > 0 || expiry > 0 {
+			o.ProtocolVersion = mqttcodec.Version5
+			o.CleanSession = false
+			o.ConnectProperties = &mqttcodec.Properties{SessionExpiryInterval: mqttcodec.Uint32(expiry)}
+			o.Will.Properties = &mqttcodec.Properties{WillDelayInterval: mqttcodec.Uint32(delay)}
+		}
+	}
+}
+
+func TestWill(t *testing.T) {
+	var s = brokertest.Start(t, broker.Options{})
+	var watcher = s.Client("watcher", nil)
+
+	watcher.Subscribe("will/+", 1)
+
+	// -- a DISCONNECT deletes the will, a connection that ends without one publishes it
+	var polite = s.Client("polite", will("polite", 0, 0))
+
+	if err := polite.Disconnect(); err != nil {
+		t.Fatal(err)
+	}
+
+	watcher.ExpectNone()
+
+	s.Client("killed", will("killed", 0, 0)).Kill()
+	watcher.Expect("will/killed", "gone")
+	// --
+}
+
+func TestWillDelay(t *testing.T) {
+	var fake = clock.NewFake(time.Unix(0, 0))
+	var s = brokertest.Start(t, broker.Options{Clock: fake})
+	var watcher = s.Client("watcher", nil)
+
+	watcher.Subscribe("will/+", 1)
+
+	// -- the will waits for its Will Delay Interval on the broker's clock
+	var pending = fake.Pending()
+
+	s.Client("delayed", will("delayed", 10, 60)).Kill()
+	fake.BlockUntil(pending + 2)
+
+	fake.Advance(9 * time.Second)
+	watcher.ExpectNone()
+
+	fake.Advance(time.Second)
+	watcher.Expect("will/delayed", "gone")
+	// --
+
+	// -- a reconnect within the delay cancels the will
+	var back = s.Client("back", will("back", 10, 60))
+
+	pending = fake.Pending()
+	back.Kill()
+	fake.BlockUntil(pending + 2)
+
+	if err := back.Connect(); err != nil || !back.SessionPresent() {
+		t.Fatalf("reconnect: %v, session present %v", err, back.SessionPresent())
+	}
+
+	fake.Advance(10 * time.Second)
+	watcher.ExpectNone()
+	// --
+
+	// -- a session that expires before the delay publishes the will when it ends
+	pending = fake.Pending()
+
+	s.Client("expiring", will("expiring", 30, 5)).Kill()
+	fake.BlockUntil(pending + 1)
+
+	fake.Advance(5 * time.Second)
+	watcher.Expect("will/expiring", "gone")
+	// --
+}
@@ -0,0 +1,316 @@
+package brokertest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/broker"
+	"github.com/MarcusOuelletus/demo/client"
+)
+
+/*
+brokertest runs a broker for integration tests without sockets: Start serves a broker.Broker on an in-memory listener,
+every connection is one side of a net.Pipe, and its clients dial it through ClientOptions.Dial. Everything is torn down
+by the test's Cleanup.
+
+A Client collects the messages of its subscriptions in order and asserts on them. Sync is what makes the tests
+deterministic without sleeps: it publishes a marker to the client itself and waits for it, a connection delivers in
+order, so every message routed to the client before the marker is there once Sync returns. Publish waits for the
+acknowledgement of a QoS 1 or 2 message, the broker routed it by then:
+
+	var s = brokertest.Start(t, broker.Options{})
+	var sub, pub = s.Client("sub", nil), s.Client("pub", nil)
+
+	sub.Subscribe("a/+", 1)
+	pub.Publish("a/b", "hello", 1)
+	sub.Expect("a/b", "hello")
+
+	pub.Publish("b/c", "elsewhere", 1)
+	sub.ExpectNone()
+
+Every assertion fails the test with t.Fatal, so they belong on the test's own goroutine.
+*/
+
+// DefaultTimeout bounds every wait of a Client, Expect waiting for a message that is not coming fails after it.
+var DefaultTimeout = 5 * time.Second
+
+// Address is the broker address of the in-memory transport, any other dials it too.
+const Address = "tcp://brokertest:1883"
+
+// syncTopic is where Sync publishes its markers, followed by the client id.
+const syncTopic = "brokertest/sync/"
+
+type Server struct {
+	Broker   *broker.Broker
+	t        testing.TB
+	listener *pipeListener
+	clients  atomic.Int64
+}
+
+// Start serves a broker with options until the test ends.
+func Start(t testing.TB, options broker.Options) *Server {
+	t.Helper()
+
+	var s = &Server{
+		Broker:   broker.New(options),
+		t:        t,
+		listener: newPipeListener(),
+	}
+
+	go s.Broker.Serve(s.listener)
+
+	t.Cleanup(func() {
+		s.listener.Close()
+		s.Broker.Close()
+	})
+
+	return s
+}
+
+// Dial opens a connection to the broker, it is the ClientOptions.Dial of the server's clients.
+func (s *Server) Dial(address string, timeout time.Duration) (net.Conn, error) {
+	return s.listener.dial(timeout)
+}
+
+// Options returns the options of a 3.1.1 client with a clean session that connects to the server. An empty clientID
+// gets a unique one.
+func (s *Server) Options(clientID string) client.ClientOptions {
+	if clientID == "" {
+		clientID = "brokertest-" + strconv.FormatInt(s.clients.Add(1), 10)
+	}
+
+	return client.ClientOptions{
+		Broker:         Address,
+		Dial:           s.Dial,
+		ClientID:       clientID,
+		CleanSession:   true,
+		ConnectTimeout: DefaultTimeout,
+	}
+}
+
+// Client connects a client with the Options of clientID, changed by modify when it is not nil. It is disconnected when
+// the test ends.
+func (s *Server) Client(clientID string, modify func(o *client.ClientOptions)) *Client {
+	s.t.Helper()
+
+	var options = s.Options(clientID)
+
+	if modify != nil {
+		modify(&options)
+	}
+
+	var c = &Client{
+		Client:   client.New(options),
+		t:        s.t,
+		id:       options.ClientID,
+		messages: make(chan client.Message, 1024),
+	}
+
+	if err := c.Connect(); err != nil {
+		s.t.Fatalf("brokertest: connecting %s: %v", c.id, err)
+	}
+
+	s.t.Cleanup(func() { c.Disconnect() })
+
+	return c
+}
+
+// Client is a connected client.Client with assertions on the messages of its subscriptions.
+type Client struct {
+	*client.Client
+	t  testing.TB
+	id string
+	// messages holds the messages of every subscription made with Subscribe, in the order they arrived.
+	messages chan client.Message
+	syncOnce sync.Once
+	syncs    chan string
+	sequence int
+}
+
+// Subscribe subscribes to filter with qos and waits for the SUBACK, the messages go to Expect.
+func (c *Client) Subscribe(filter string, qos byte) {
+	c.t.Helper()
+
+	if err := c.subscribe(filter, qos, c.receive); err != nil {
+		c.t.Fatalf("brokertest: %s subscribing to %s: %v", c.id, filter, err)
+	}
+}
+
+// SubscribeWith is Subscribe with the subscription options of an MQTT 5 client, No Local or Retain As Published.
+func (c *Client) SubscribeWith(filter string, opts client.SubscribeOptions) {
+	c.t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+
+	if err := c.Client.Subscribe(ctx, filter, opts, c.receive); err != nil {
+		c.t.Fatalf("brokertest: %s subscribing to %s: %v", c.id, filter, err)
+	}
+}
+
+// Publish publishes payload to topic with qos, it waits for the acknowledgement of QoS 1 and 2.
+func (c *Client) Publish(topic, payload string, qos byte) {
+	c.t.Helper()
+	c.publish(topic, payload, client.PublishOptions{QoS: qos})
+}
+
+// PublishRetained publishes a retained message, an empty payload clears the retained message of topic.
+func (c *Client) PublishRetained(topic, payload string, qos byte) {
+	c.t.Helper()
+	c.publish(topic, payload, client.PublishOptions{QoS: qos, Retain: true})
+}
+
+// Next returns the next message of the client's subscriptions, it fails the test after DefaultTimeout.
+func (c *Client) Next() client.Message {
+	c.t.Helper()
+
+	select {
+	case m := <-c.messages:
+		return m
+	case <-time.After(DefaultTimeout):
+		c.t.Fatalf("brokertest: %s got no message within %v", c.id, DefaultTimeout)
+		return client.Message{}
+	}
+}
+
+// Expect takes the next message and fails the test unless it has topic and payload.
+func (c *Client) Expect(topic, payload string) client.Message {
+	c.t.Helper()
+
+	var m = c.Next()
+
+	if m.Topic != topic || string(m.Payload) != payload {
+		c.t.Fatalf("brokertest: %s got %q on %s, want %q on %s", c.id, m.Payload, m.Topic, payload, topic)
+	}
+
+	return m
+}
+
+// ExpectNone runs a Sync and fails the test if a message arrived before it.
+func (c *Client) ExpectNone() {
+	c.t.Helper()
+	c.Sync()
+
+	select {
+	case m := <-c.messages:
+		c.t.Fatalf("brokertest: %s got %q on %s, want none", c.id, m.Payload, m.Topic)
+	default:
+	}
+}
+
+// Sync waits until every message routed to the client so far arrived.
+func (c *Client) Sync() {
+	c.t.Helper()
+
+	var filter = syncTopic + c.id
+	var err error
+
+	c.syncOnce.Do(func() {
+		c.syncs = make(chan string, 1)
+		err = c.subscribe(filter, 1, func(m client.Message) { c.syncs <- string(m.Payload) })
+	})
+
+	if err != nil {
+		c.t.Fatalf("brokertest: %s subscribing to %s: %v", c.id, filter, err)
+	}
+
+	c.sequence++
+	var marker = strconv.Itoa(c.sequence)
+
+	c.publish(filter, marker, client.PublishOptions{QoS: 1})
+
+	for {
+		select {
+		case got := <-c.syncs:
+			if got == marker {
+				return
+			}
+		case <-time.After(DefaultTimeout):
+			c.t.Fatalf("brokertest: %s did not sync within %v", c.id, DefaultTimeout)
+		}
+	}
+}
+
+func (c *Client) subscribe(filter string, qos byte, handler client.MessageHandler) error {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+
+	return c.Client.Subscribe(ctx, filter, client.SubscribeOptions{QoS: qos}, handler)
+}
+
+func (c *Client) publish(topic, payload string, opts client.PublishOptions) {
+	c.t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+
+	if err := c.Client.Publish(ctx, topic, []byte(payload), opts); err != nil {
+		c.t.Fatalf("brokertest: %s publishing to %s: %v", c.id, topic, err)
+	}
+}
+
+// receive is the handler of Subscribe, it runs on the client's read loop. A message that finds the buffer full is
+// dropped and fails the test.
+func (c *Client) receive(m client.Message) {
+	select {
+	case c.messages <- m:
+	default:
+		c.t.Errorf("brokertest: %s has more than %d messages nobody took, dropped %q on %s", c.id, cap(c.messages), m.Payload, m.Topic)
+	}
+}
+
+// pipeListener hands the server side of a net.Pipe to Accept for every dial.
+type pipeListener struct {
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+func (l *pipeListener) dial(timeout time.Duration) (net.Conn, error) {
+	var server, local = net.Pipe()
+
+	select {
+	case l.conns <- server:
+		return local, nil
+	case <-l.closed:
+	case <-time.After(timeout):
+	}
+
+	server.Close()
+	local.Close()
+
+	return nil, fmt.Errorf("brokertest: broker is not accepting connections")
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "brokertest" }
@@ -0,0 +1,116 @@
+package bytespool
+
+import (
+	"math/bits"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+bytespool is a pool of byte buffers in size classes, one sync.Pool per power of two from MinSize to MaxSize, so a
+buffer for a 100 byte packet does not pin a 64 KiB one and a large one is not handed out where a few bytes do. The
+PacketReader reads every packet body into one of them, the PUBLISH encoder builds its body in one before the fixed
+header goes in front, and the websocket transports build their frames in them:
+
+	var buf = bytespool.Get(n)
+	defer bytespool.Put(buf)
+
+	var b = *buf // n bytes, cap rounded up to the size class
+
+Get(n) takes a buffer from the smallest class that holds n bytes, a request larger than MaxSize is allocated on its own
+and never pooled. Put files a buffer under the largest class its capacity fills, so any buffer can go back, not only one
+that came from Get. Once Put, a buffer belongs to the pool: neither it nor a slice of it may be used again.
+
+ReadStats counts what the pools did since the process started, Hits over Hits+Misses is how often a Get found a buffer
+to reuse.
+*/
+
+const (
+	MinSize = 1 << minShift
+	MaxSize = 1 << maxShift
+
+	minShift = 6
+	maxShift = 20
+)
+
+var pools [maxShift - minShift + 1]sync.Pool
+
+var hits, misses, oversize, puts, discards atomic.Uint64
+
+type Stats struct {
+	// Hits counts the Gets that reused a pooled buffer, Misses the ones that allocated a buffer of their class.
+	Hits   uint64
+	Misses uint64
+	// Oversize counts the Gets larger than MaxSize.
+	Oversize uint64
+	// Puts counts the buffers given back to a pool, Discards the ones too small or too large to pool.
+	Puts     uint64
+	Discards uint64
+}
+
+// HitRate is the share of the pooled Gets that reused a buffer, 0 before the first one.
+func (s Stats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// Get returns a buffer of n bytes, its content is whatever the previous user left in it.
+func Get(n int) *[]byte {
+	if n > MaxSize {
+		oversize.Add(1)
+		var b = make([]byte, n)
+		return &b
+	}
+
+	var class = getClass(n)
+
+	if buf, ok := pools[class].Get().(*[]byte); ok {
+		hits.Add(1)
+		*buf = (*buf)[:n]
+		return buf
+	}
+
+	misses.Add(1)
+	var b = make([]byte, n, MinSize<<class)
+
+	return &b
+}
+
+// Put gives buf back to its pool.
+func Put(buf *[]byte) {
+	if buf == nil || cap(*buf) < MinSize || cap(*buf) > MaxSize {
+		discards.Add(1)
+		return
+	}
+
+	puts.Add(1)
+	*buf = (*buf)[:0]
+	pools[putClass(cap(*buf))].Put(buf)
+}
+
+func ReadStats() Stats {
+	return Stats{
+		Hits:     hits.Load(),
+		Misses:   misses.Load(),
+		Oversize: oversize.Load(),
+		Puts:     puts.Load(),
+		Discards: discards.Load(),
+	}
+}
+
+// getClass is the smallest class with room for n bytes.
+func getClass(n int) int {
+	if n <= MinSize {
+		return 0
+	}
+
+	return bits.Len(uint(n-1)) - minShift
+}
+
+// putClass is the largest class a capacity of c fills.
+func putClass(c int) int {
+	return bits.Len(uint(c)) - 1 - minShift
+}
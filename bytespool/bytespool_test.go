@@ -0,0 +1,99 @@
+package bytespool
+
+import "testing"
+
+func TestGetSizeClasses(t *testing.T) {
+	var tests = []struct {
+		n, cap int
+	}{
+		{0, MinSize},
+		{1, MinSize},
+		{MinSize, MinSize},
+		{MinSize + 1, 2 * MinSize},
+		{1000, 1024},
+		{4096, 4096},
+		{MaxSize, MaxSize},
+		{MaxSize + 1, MaxSize + 1},
+	}
+
+	for _, test := range tests {
+		var buf = Get(test.n)
+
+		if len(*buf) != test.n || cap(*buf) < test.n {
+			t.Errorf("Get(%d) has len %d cap %d", test.n, len(*buf), cap(*buf))
+		}
+
+		if test.n <= MaxSize && cap(*buf) != test.cap {
+			t.Errorf("Get(%d) has cap %d, want %d", test.n, cap(*buf), test.cap)
+		}
+
+		Put(buf)
+	}
+}
+
+func TestPutClass(t *testing.T) {
+	var tests = []struct {
+		cap, class int
+	}{
+		{MinSize, 0},
+		{MinSize*2 - 1, 0},
+		{MinSize * 2, 1},
+		{5000, 6},
+		{MaxSize, maxShift - minShift},
+	}
+
+	for _, test := range tests {
+		if class := putClass(test.cap); class != test.class {
+			t.Errorf("putClass(%d) = %d, want %d", test.cap, class, test.class)
+		}
+
+		if MinSize<<putClass(test.cap) > test.cap {
+			t.Errorf("putClass(%d) files it under a class it does not fill", test.cap)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	var before = ReadStats()
+
+	for i := 0; i < 10; i++ {
+		Put(Get(300))
+	}
+
+	Put(Get(MaxSize + 1))
+
+	var small = make([]byte, 10)
+	Put(&small)
+
+	var after = ReadStats()
+
+	// -- sync.Pool may drop what it was given, so only the sum of hits and misses is known
+	if got := after.Hits + after.Misses - before.Hits - before.Misses; got != 10 {
+		t.Errorf("%d pooled Gets counted, want 10", got)
+	}
+	// --
+
+	if got := after.Oversize - before.Oversize; got != 1 {
+		t.Errorf("%d oversize Gets counted, want 1", got)
+	}
+
+	if got := after.Puts - before.Puts; got != 10 {
+		t.Errorf("%d Puts counted, want 10", got)
+	}
+
+	if got := after.Discards - before.Discards; got != 2 {
+		t.Errorf("%d discards counted, want 2", got)
+	}
+
+	if rate := after.HitRate(); rate < 0 || rate > 1 {
+		t.Errorf("HitRate() = %v", rate)
+	}
+}
+
+func BenchmarkGetPut(b *testing.B) {
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		Put(Get(1500))
+	}
+}
@@ -0,0 +1,898 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/broadcast"
+	"github.com/MarcusOuelletus/demo/modules/logger"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/packetids"
+	"github.com/MarcusOuelletus/demo/reasoncodes"
+	"github.com/MarcusOuelletus/demo/session"
+)
+
+/*
+Client is the MQTT client applications use, it wires the session pieces and the codec together:
+
+ - PacketIDs hands out the packet ids, the FlowController keeps outbound QoS 1 and 2 publishes under Receive Maximum,
+   the ReceiveQuota of each MQTT 5 connection holds the broker to the Receive Maximum the client advertised.
+ - The OutboundQoS2Flow and InboundQoS2Flow track the QoS 2 handshakes in both directions.
+ - The ResponseBroadcaster delivers acknowledgements from the read loop to the goroutine waiting for them, the
+   acknowledgement itself is parked in acks under its packet id until that goroutine picks it up.
+ - The SubscriptionManager routes inbound PUBLISH packets through the topic trie, every filter matching the topic
+   (wildcards included) gets the message on its own handler.
+ - Keepalive sends PINGREQ and closes a connection that went quiet, with the Server Keep Alive of the CONNACK when the
+   broker sent one.
+ - The Store holds every QoS 1 and 2 message until its flow ended, see client_resume.go for a restart.
+ - EnhancedAuth runs the MQTT 5 enhanced authentication of a connection with Authentication, see client_auth.go.
+
+Every network connection gets its own connection value, a goroutine waiting on one only ever sees that connection
+close, never a later one.
+*/
+
+var (
+	ErrNotConnected     = errors.New("client is not connected")
+	ErrConnectionLost   = errors.New("connection lost")
+	ErrKeepaliveTimeout = errors.New("keepalive timeout")
+	ErrClientClosed     = errors.New("client closed")
+)
+
+// reasonError is a protocol error of the broker, the connection is closed with a DISCONNECT carrying code.
+type reasonError struct {
+	code reasoncodes.Code
+	err  error
+}
+
+func (e *reasonError) Error() string { return e.err.Error() }
+
+func (e *reasonError) Unwrap() error { return e.err }
+
+// KeyValue is one MQTT 5 User Property. The properties go over the wire in the order of their slice, a key may come
+// more than once, and arrive the same way, nothing sorts or merges them.
+type KeyValue = mqttcodec.UserProperty
+
+// Message is an inbound PUBLISH.
+type Message struct {
+	Topic    string
+	Payload  []byte
+	QoS      byte
+	Retain   bool
+	Dup      bool
+	PacketID uint16
+	// PayloadFormat and ContentType say how to decode the payload, both are only ever set by an MQTT 5 publisher.
+	PayloadFormat PayloadFormat
+	ContentType   string
+	// UserProperties are the User Properties of the PUBLISH in the order they came in, the same as those of Properties.
+	UserProperties []KeyValue
+	// Properties are the MQTT 5 properties (Response Topic, Correlation Data...), nil on 3.1.1.
+	Properties *mqttcodec.Properties
+}
+
+type MessageHandler func(msg Message)
+
+// SubscribeOptions are the subscription options of one topic filter, all but QoS only reach an MQTT 5 broker.
+type SubscribeOptions struct {
+	QoS               byte
+	NoLocal           bool
+	RetainAsPublished bool
+	RetainHandling    byte
+	// UserProperties are sent with the SUBSCRIBE, a resubscribe after a lost session sends none.
+	UserProperties []KeyValue
+}
+
+// ConnackError is returned by Connect when the server refuses the connection.
+type ConnackError struct {
+	ReturnCode byte
+	// Properties are the MQTT 5 properties of the CONNACK (Reason String, Server Reference...), nil on 3.1.1.
+	Properties *mqttcodec.Properties
+}
+
+func (e *ConnackError) Error() string {
+	return fmt.Sprintf("connection refused with return code 0x%02X", e.ReturnCode)
+}
+
+type Client struct {
+	options       ClientOptions
+	ids           *packetids.PacketIDs
+	broadcaster   *broadcast.ResponseBroadcaster
+	flow          *session.FlowController
+	subscriptions *session.SubscriptionManager
+	outboundQoS2  *session.OutboundQoS2Flow
+	inboundQoS2   *session.QoS2Dedup
+	store         Store
+	queued        uint64
+	workers       *workerPool
+	metrics       *clientMetrics
+	requests      *requester
+	log           logger.Logger
+
+	mu             sync.Mutex
+	conn           *connection
+	acks           map[uint16]mqttcodec.Packet
+	channels       map[string]*messageChan
+	sessionPresent bool
+	stopReconnect  chan struct{}
+	state          ConnectionState
+	watchers       map[*stateWatcher]struct{}
+	// clientID is the client id of the CONNECT, ClientID or the one the broker assigned to an empty one.
+	clientID string
+	// responseInformation is the Response Information of the last CONNACK.
+	responseInformation string
+	// redirect is the broker a followed Server Reference pointed to, it replaces Broker.
+	redirect string
+	// downgraded is set once FallbackTo311 fell back to 3.1.1.
+	downgraded bool
+	// closed is set by Close, the client takes no new publishes or connects from then on.
+	closed bool
+	// middleware and interceptors are the chains of UseInbound and UseOutbound, replaced and never changed in place.
+	middleware   []InboundMiddleware
+	interceptors []OutboundInterceptor
+	// codecs are the PayloadCodecs by media type.
+	codecs map[string]PayloadCodec
+
+	restored        bool
+	pendingInflight []string
+	pendingQueued   []string
+}
+
+type connection struct {
+	conn      net.Conn
+	writer    *mqttcodec.PacketWriter
+	keepalive *session.Keepalive
+	inbound   *inboundLimiter
+	// quota holds the inbound QoS 1 and 2 messages not acknowledged yet against our Receive Maximum, nil on 3.1.1.
+	quota *session.ReceiveQuota
+	// auth is the enhanced authentication of the connection, nil without Authentication. reauth gets the outcome of
+	// the Reauthenticate in progress, it is guarded by the client's mu.
+	auth   *session.EnhancedAuth
+	reauth chan error
+	// loops are the read loop and the inbound limiter, the goroutines that hand messages to the handlers.
+	loops sync.WaitGroup
+	done  chan struct{}
+	once  sync.Once
+	err   error
+}
+
+func New(options ClientOptions) *Client {
+	var ids = packetids.New()
+	var broadcaster = broadcast.NewResponseBroadcaster()
+
+	ids.Logger = logger.For(options.Logger, logger.PacketIDs)
+	broadcaster.Logger = logger.For(options.Logger, logger.Session)
+	var store = options.Store
+
+	if store == nil {
+		store = NewMemoryStore()
+	}
+
+	// -- without a store NewQoS2Dedup cannot fail, restore replaces it with one persisting to the Store
+	inboundQoS2, _ := session.NewQoS2Dedup(options.ClientID, nil)
+	// --
+
+	var c = &Client{
+		options:       options,
+		clientID:      options.ClientID,
+		ids:           ids,
+		broadcaster:   broadcaster,
+		flow:          session.NewFlowController(0, ids, broadcaster),
+		subscriptions: session.NewSubscriptionManager(options.ClientID, nil),
+		outboundQoS2:  session.NewOutboundQoS2Flow(),
+		inboundQoS2:   inboundQoS2,
+		store:         store,
+		acks:          make(map[uint16]mqttcodec.Packet),
+		channels:      make(map[string]*messageChan),
+		watchers:      make(map[*stateWatcher]struct{}),
+		codecs:        map[string]PayloadCodec{"application/json": JSONCodec{}},
+		metrics:       &clientMetrics{},
+		requests:      newRequester(),
+		log:           logger.With(logger.Or(logger.For(options.Logger, logger.Client)), logger.Fields{logger.ClientIDField: options.ClientID}),
+	}
+
+	if options.HandlerWorkers > 0 {
+		c.workers = newWorkerPool(options.HandlerWorkers, options.HandlerQueue)
+	}
+
+	return c
+}
+
+// Connect dials the broker, sends CONNECT and waits for the CONNACK, every CONNACK resumes what is left in the Store.
+func (c *Client) Connect() error {
+	if err := c.options.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.restore(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrClientClosed
+	}
+	var stop = make(chan struct{})
+	c.stopReconnect = stop
+	c.transition(StateConnecting, nil)
+	c.mu.Unlock()
+
+	var err = c.connect(stop)
+
+	if err != nil {
+		c.mu.Lock()
+		if c.stopReconnect == stop {
+			c.transition(StateDisconnected, err)
+		}
+		c.mu.Unlock()
+	}
+
+	return err
+}
+
+// open opens a new network connection, it is dropped again when stop was closed by a Disconnect in the meantime.
+func (c *Client) open(stop chan struct{}) error {
+	var timeout = c.options.ConnectTimeout
+
+	if timeout <= 0 {
+		timeout = DefaultConnectTimeout
+	}
+
+	var broker = c.broker()
+	e, err := parseBroker(broker)
+
+	if err != nil {
+		return err
+	}
+
+	var version = c.version()
+	connect, err := c.options.connectPacket(e, c.ClientID(), version)
+
+	if err != nil {
+		return err
+	}
+
+	auth, err := c.startAuth(connect, version)
+
+	if err != nil {
+		return err
+	}
+
+	conn, err := c.dial(e, timeout)
+
+	if err != nil {
+		return err
+	}
+
+	if c.options.WrapConn != nil {
+		conn = c.options.WrapConn(conn)
+	}
+
+	conn = c.metrics.count(conn)
+
+	// -- the CONNACK is read here, the read loop only starts on an accepted connection
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	var reader = mqttcodec.NewPacketReader(conn)
+	var trace = c.tracer()
+	reader.Version = version
+	reader.Trace = trace
+
+	connack, err := handshake(conn, reader, connect, auth)
+
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	conn.SetDeadline(time.Time{})
+	// --
+
+	var keepAlive = time.Duration(c.options.KeepAlive) * time.Second
+
+	if connack.Properties != nil && connack.Properties.ServerKeepAlive != nil {
+		keepAlive = time.Duration(*connack.Properties.ServerKeepAlive) * time.Second
+	}
+
+	var n = &connection{
+		conn:      conn,
+		writer:    mqttcodec.NewPacketWriter(conn),
+		keepalive: session.NewKeepalive(keepAlive, c.options.clock()),
+		auth:      auth,
+		done:      make(chan struct{}),
+	}
+
+	n.writer.FlushDelay = 0
+	n.writer.Version = version
+	n.writer.Trace = trace
+	n.writer.Aliases = topicAliases(connect, connack)
+	reader.Aliases = n.writer.Aliases
+	n.keepalive.SendPing = func() { n.write(mqttcodec.Pingreq) }
+	n.keepalive.OnDead = func() { n.close(ErrKeepaliveTimeout) }
+	n.inbound = c.newInboundLimiter(n)
+
+	if n.writer.Version == mqttcodec.Version5 {
+		n.quota = session.NewReceiveQuota(c.options.receiveMaximum())
+	}
+
+	c.mu.Lock()
+	select {
+	case <-stop:
+		c.mu.Unlock()
+		conn.Close()
+		return ErrNotConnected
+	default:
+	}
+	c.conn = n
+	c.transition(StateConnected, nil)
+	c.sessionPresent = connack.SessionPresent
+	c.responseInformation = ""
+	var assigned string
+	if connack.Properties != nil {
+		c.responseInformation = connack.Properties.ResponseInformation
+		assigned = connack.Properties.AssignedClientIdentifier
+	}
+	c.mu.Unlock()
+
+	if assigned != "" {
+		c.assignClientID(assigned)
+	}
+
+	atomic.AddUint64(&c.metrics.connects, 1)
+	c.log.Info("client: connected", logger.Fields{"broker": broker, "session_present": connack.SessionPresent})
+
+	if keepAlive > 0 {
+		n.keepalive.Start(time.Second)
+	}
+
+	// -- the server has no PUBREL left to send for a session it does not have, the ids are free before anything is read
+	if !connack.SessionPresent {
+		if err = c.inboundQoS2.Reset(); err != nil {
+			n.close(err)
+			return err
+		}
+	}
+	// --
+
+	n.loops.Add(1)
+	go c.readLoop(n, reader)
+
+	if !connack.SessionPresent {
+		c.resubscribe(n)
+	}
+
+	c.resume()
+
+	return nil
+}
+
+// tracer logs the packets of a connection as logger.Codec, nil without a Logger.
+func (c *Client) tracer() *mqttcodec.Tracer {
+	if c.options.Logger == nil {
+		return nil
+	}
+
+	var t = mqttcodec.NewTracer(logger.With(logger.For(c.options.Logger, logger.Codec), logger.Fields{logger.ClientIDField: c.ClientID()}))
+	t.Rate = mqttcodec.DefaultTraceRate
+
+	if c.options.Trace != nil {
+		t.Enable(*c.options.Trace)
+	}
+
+	return t
+}
+
+// handshake sends connect and reads up to the CONNACK, answering the AUTH packets of auth's exchange on the way.
+func handshake(conn net.Conn, reader *mqttcodec.PacketReader, connect *mqttcodec.Connect, auth *session.EnhancedAuth) (*mqttcodec.Connack, error) {
+	if err := mqttcodec.WritePacket(conn, connect); err != nil {
+		return nil, err
+	}
+
+	for {
+		p, err := reader.ReadPacket()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if a, ok := p.(*mqttcodec.Auth); ok && auth != nil {
+			reply, err := auth.HandleAuth(a)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if err = mqttcodec.WritePacket(conn, reply); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		connack, ok := p.(*mqttcodec.Connack)
+
+		if !ok {
+			return nil, fmt.Errorf("expected CONNACK, got %s", p.Type())
+		}
+
+		if connack.ReturnCode != mqttcodec.ConnackAccepted {
+			return nil, &ConnackError{ReturnCode: connack.ReturnCode, Properties: connack.Properties}
+		}
+
+		if auth != nil {
+			if err = auth.HandleConnack(connack.ReturnCode, connack.Properties); err != nil {
+				return nil, err
+			}
+		}
+
+		return connack, nil
+	}
+}
+
+// ClientID returns the client id the client connects with, the Assigned Client Identifier of the broker once an
+// MQTT 5 client without a ClientID got one.
+func (c *Client) ClientID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.clientID
+}
+
+// assignClientID takes the client id the broker assigned, it is kept in the Store so a restart with the same Store
+// connects with it and finds its session again.
+func (c *Client) assignClientID(id string) {
+	c.mu.Lock()
+	var changed = c.clientID != id
+	c.clientID = id
+	c.mu.Unlock()
+
+	if !changed {
+		return
+	}
+
+	c.log.Info("client: broker assigned the client id", logger.Fields{"assigned_client_id": id})
+
+	if err := c.store.Put(clientIDKey, &StoredMessage{Payload: []byte(id)}); err != nil {
+		c.log.Warn("client: cannot store the assigned client id", logger.Fields{"error": err})
+	}
+}
+
+// SessionPresent returns the Session Present flag of the last CONNACK.
+func (c *Client) SessionPresent() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.sessionPresent
+}
+
+func (c *Client) IsConnected() bool {
+	_, err := c.current()
+	return err == nil
+}
+
+// Subscribe subscribes to filter and routes matching messages to handler, a refused subscription is an error.
+func (c *Client) Subscribe(ctx context.Context, filter string, opts SubscribeOptions, handler MessageHandler) error {
+	return c.subscribe(ctx, filter, opts, handler, nil)
+}
+
+// subscribe is Subscribe for a handler that feeds channel, channel is closed with the subscription.
+func (c *Client) subscribe(ctx context.Context, filter string, opts SubscribeOptions, handler MessageHandler, channel *messageChan) error {
+	results, err := c.subscribeMultiple(ctx, []FilterOptions{{Filter: filter, Options: opts, Handler: handler, channel: channel}})
+
+	if err != nil {
+		return err
+	}
+
+	return results[0].Err
+}
+
+// Unsubscribe unsubscribes from filters with one UNSUBSCRIBE and waits for its UNSUBACK until ctx is done.
+func (c *Client) Unsubscribe(ctx context.Context, filters ...string) error {
+	n, err := c.current()
+
+	if err != nil {
+		return err
+	}
+
+	var ch = make(chan uint16, 1)
+
+	id, err := c.reserve(ch)
+
+	if err != nil {
+		return err
+	}
+
+	defer c.release(id, ch)
+
+	if err = n.write(&mqttcodec.Unsubscribe{PacketID: id.Value, Filters: filters}); err != nil {
+		return err
+	}
+
+	if _, err = c.await(ctx, n, id.Value, ch, mqttcodec.UNSUBACK); err != nil {
+		return err
+	}
+
+	for _, filter := range filters {
+		if err = c.removeSubscription(filter); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) removeSubscription(filter string) error {
+	c.setChannel(filter, nil)
+	return c.subscriptions.Remove(filter)
+}
+
+// Disconnect sends DISCONNECT and closes the connection, it also stops a reconnect in progress.
+func (c *Client) Disconnect() error {
+	var n = c.detach()
+
+	if n == nil {
+		return ErrNotConnected
+	}
+
+	select {
+	case <-n.done:
+		return nil
+	default:
+	}
+
+	var err = n.write(mqttcodec.Disconnect)
+	n.close(nil)
+
+	return err
+}
+
+// detach takes the connection away from the client and stops a reconnect, a lost connection is then not redialed.
+func (c *Client) detach() *connection {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var n = c.conn
+	c.conn = nil
+
+	if n != nil || c.stopReconnect != nil {
+		c.transition(StateTerminated, nil)
+	}
+
+	if c.stopReconnect != nil {
+		close(c.stopReconnect)
+		c.stopReconnect = nil
+	}
+
+	return n
+}
+
+func (c *Client) current() (*connection, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		return nil, ErrNotConnected
+	}
+
+	select {
+	case <-c.conn.done:
+		return nil, c.conn.closedErr()
+	default:
+		return c.conn, nil
+	}
+}
+
+// reserve takes a packet id for SUBSCRIBE or UNSUBSCRIBE, those do not count against Receive Maximum.
+func (c *Client) reserve(ch chan uint16) (*packetids.PacketID, error) {
+	var id = c.ids.Reserve()
+
+	if err := c.broadcaster.AddListener(id.Value, ch); err != nil {
+		c.ids.Release(id.GetBytes())
+		return nil, err
+	}
+
+	return id, nil
+}
+
+func (c *Client) release(id *packetids.PacketID, ch chan uint16) {
+	c.broadcaster.RemoveAndCloseListener(id.Value, ch)
+	c.ids.Release(id.GetBytes())
+}
+
+// await waits for the acknowledgement of type t for packet id, for n to close or for ctx to be done.
+func (c *Client) await(ctx context.Context, n *connection, id uint16, ch chan uint16, t mqttcodec.PacketType) (mqttcodec.Packet, error) {
+	select {
+	case v := <-ch:
+		c.mu.Lock()
+		var ack = c.acks[id]
+		delete(c.acks, id)
+		c.mu.Unlock()
+
+		if mqttcodec.PacketType(v) != t {
+			return nil, fmt.Errorf("expected %s for packet id %d, got %s", t, id, mqttcodec.PacketType(v))
+		}
+
+		return ack, nil
+	case <-n.done:
+		return nil, n.closedErr()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// acknowledge hands an acknowledgement to the goroutine waiting on its packet id.
+func (c *Client) acknowledge(id uint16, ack mqttcodec.Packet) {
+	c.mu.Lock()
+	c.acks[id] = ack
+	c.mu.Unlock()
+
+	if !c.broadcaster.Notify(id, uint16(ack.Type())) {
+		c.mu.Lock()
+		delete(c.acks, id)
+		c.mu.Unlock()
+	}
+}
+
+func (c *Client) readLoop(n *connection, reader *mqttcodec.PacketReader) {
+	defer n.loops.Done()
+
+	for {
+		p, err := reader.ReadPacket()
+
+		if err != nil {
+			n.close(err)
+			c.connectionLost(n)
+			return
+		}
+
+		n.keepalive.Received()
+
+		if err = c.handle(n, p); err != nil {
+			n.disconnect(err)
+			c.connectionLost(n)
+			return
+		}
+	}
+}
+
+func (c *Client) handle(n *connection, p mqttcodec.Packet) error {
+	switch p := p.(type) {
+	case *mqttcodec.Publish:
+		return c.deliver(n, p)
+	case *mqttcodec.Ack:
+		if p.PacketType == mqttcodec.PUBREL {
+			return c.pubrel(n, p.PacketID)
+		}
+		if p.PacketType == mqttcodec.PUBREC && !reasoncodes.Code(p.ReasonCode).IsFailure() {
+			switch c.outboundQoS2.State(p.PacketID) {
+			case session.QoS2AwaitingPubcomp:
+				// -- a second PUBREC means our PUBREL was lost, the waiting publish only hears about the first
+				return n.write(mqttcodec.NewPubrel(p.PacketID))
+			case session.QoS2AwaitingPubrec:
+				c.outboundQoS2.HandlePubrec(p.PacketID)
+			}
+		}
+		c.acknowledge(p.PacketID, p)
+	case *mqttcodec.Suback:
+		c.acknowledge(p.PacketID, p)
+	case *mqttcodec.Unsuback:
+		c.acknowledge(p.PacketID, p)
+	case *mqttcodec.Disconnection:
+		return c.disconnected(p)
+	case *mqttcodec.Auth:
+		return c.handleAuth(n, p)
+	case *mqttcodec.Empty:
+		if p.PacketType != mqttcodec.PINGRESP {
+			return fmt.Errorf("unexpected %s from the server", p.PacketType)
+		}
+		n.keepalive.PingResponse()
+	default:
+		return fmt.Errorf("unexpected %s from the server", p.Type())
+	}
+
+	return nil
+}
+
+// deliver routes an inbound PUBLISH, through the inbound limiter if there is one.
+func (c *Client) deliver(n *connection, p *mqttcodec.Publish) error {
+	c.metrics.receive(p.QoS)
+
+	if p.QoS > 0 && n.quota != nil {
+		if err := n.quota.Acquire(p.PacketID); err != nil {
+			return &reasonError{code: reasoncodes.ReceiveMaximumExceeded, err: err}
+		}
+	}
+
+	if n.inbound != nil {
+		if !n.inbound.enqueue(n, p) {
+			c.metrics.drop()
+		}
+		return nil
+	}
+
+	return c.route(n, p)
+}
+
+// route hands an inbound PUBLISH to the subscriptions and acknowledges it, a QoS 2 message is only routed the first
+// time it arrives.
+func (c *Client) route(n *connection, p *mqttcodec.Publish) error {
+	var msg = &session.InboundMessage{
+		Topic:      p.TopicName,
+		Payload:    p.Payload,
+		QoS:        p.QoS,
+		Retain:     p.Retain,
+		Dup:        p.Dup,
+		PacketID:   p.PacketID,
+		Properties: p.Properties,
+	}
+
+	if !c.validPayload(p) {
+		return c.refusePayload(n, p)
+	}
+
+	var routeCounted = func() {
+		if msg, ok := c.intercept(msg); !ok || c.subscriptions.Route(msg) == 0 {
+			c.metrics.drop()
+		}
+	}
+
+	switch p.QoS {
+	case 0:
+		routeCounted()
+	case 1:
+		routeCounted()
+		var err = n.write(mqttcodec.NewPuback(p.PacketID))
+		n.release(p.PacketID)
+		return err
+	case 2:
+		deliver, err := c.inboundQoS2.Publish(p.PacketID)
+
+		if err != nil {
+			return err
+		}
+
+		if deliver {
+			routeCounted()
+		}
+		return n.write(mqttcodec.NewPubrec(p.PacketID))
+	}
+
+	return nil
+}
+
+// refusePayload drops an inbound PUBLISH whose payload is not the UTF-8 it claims, a QoS 1 or 2 one is acknowledged
+// with Payload format invalid.
+func (c *Client) refusePayload(n *connection, p *mqttcodec.Publish) error {
+	c.metrics.drop()
+	c.log.Warn("client: dropping a message that is not UTF-8 as its payload format says", logger.Fields{"topic": p.TopicName})
+
+	var ack *mqttcodec.Ack
+
+	switch p.QoS {
+	case 1:
+		ack = mqttcodec.NewPuback(p.PacketID)
+	case 2:
+		ack = mqttcodec.NewPubrec(p.PacketID)
+	default:
+		return nil
+	}
+
+	ack.ReasonCode = byte(reasoncodes.PayloadFormatInvalid)
+
+	var err = n.write(ack)
+	n.release(p.PacketID)
+
+	return err
+}
+
+// pubrel answers a PUBREL, one for a packet id that is not waiting for it gets Packet Identifier not found on MQTT 5
+// and a plain PUBCOMP on 3.1.1 all the same.
+func (c *Client) pubrel(n *connection, id uint16) error {
+	var ack = mqttcodec.NewPubcomp(id)
+	var err = c.inboundQoS2.Pubrel(id)
+
+	switch {
+	case errors.Is(err, session.ErrPacketIDNotFound):
+		if n.writer.Version == mqttcodec.Version5 {
+			ack.ReasonCode = byte(reasoncodes.PacketIdentifierNotFound)
+		}
+	case err != nil:
+		return err
+	}
+
+	err = n.write(ack)
+	n.release(id)
+
+	return err
+}
+
+func wrapHandler(handler MessageHandler) session.MessageHandler {
+	if handler == nil {
+		return nil
+	}
+
+	return func(msg *session.InboundMessage) {
+		handler(messageOf(msg))
+	}
+}
+
+func messageOf(msg *session.InboundMessage) Message {
+	var m = Message{
+		Topic:      msg.Topic,
+		Payload:    msg.Payload,
+		QoS:        msg.QoS,
+		Retain:     msg.Retain,
+		Dup:        msg.Dup,
+		PacketID:   msg.PacketID,
+		Properties: msg.Properties,
+	}
+
+	if p := msg.Properties; p != nil {
+		m.UserProperties, m.ContentType = p.UserProperties, p.ContentType
+
+		if p.PayloadFormatIndicator != nil {
+			m.PayloadFormat = PayloadFormat(*p.PayloadFormatIndicator)
+		}
+	}
+
+	return m
+}
+
+func (n *connection) write(p mqttcodec.Packet) error {
+	if err := n.writer.WritePacket(p); err != nil {
+		n.close(err)
+		return err
+	}
+
+	n.keepalive.Sent()
+
+	return nil
+}
+
+func (n *connection) close(err error) {
+	n.once.Do(func() {
+		n.err = err
+		n.keepalive.Stop()
+		n.conn.Close()
+		close(n.done)
+	})
+}
+
+// disconnect closes the connection for a protocol error, telling an MQTT 5 broker the reason first.
+func (n *connection) disconnect(err error) {
+	var e *reasonError
+
+	if errors.As(err, &e) && n.writer.Version == mqttcodec.Version5 && !n.closed() {
+		n.writer.WritePacket(&mqttcodec.Disconnection{ReasonCode: byte(e.code)})
+	}
+
+	n.close(err)
+}
+
+// release gives back the receive quota of an inbound flow that ended with the acknowledgement we just sent.
+func (n *connection) release(id uint16) {
+	if n.quota != nil {
+		n.quota.Release(id)
+	}
+}
+
+func (n *connection) closed() bool {
+	select {
+	case <-n.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (n *connection) closedErr() error {
+	if n.err == nil {
+		return ErrConnectionLost
+	}
+
+	return n.err
+}
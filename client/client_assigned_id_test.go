@@ -0,0 +1,86 @@
+package client_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/broker"
+	"github.com/MarcusOuelletus/demo/brokertest"
+	"github.com/MarcusOuelletus/demo/client"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/mqtttest"
+)
+
+func TestAssignedClientID(t *testing.T) {
+	var s = brokertest.Start(t, broker.Options{})
+	var store = client.NewMemoryStore()
+
+	var modify = func(o *client.ClientOptions) {
+		o.ClientID = ""
+		o.CleanSession = false
+		o.ProtocolVersion = mqttcodec.Version5
+		o.Store = store
+		o.ConnectProperties = &mqttcodec.Properties{SessionExpiryInterval: mqttcodec.Uint32(60)}
+	}
+
+	var first = s.Client("", modify)
+	var id = first.ClientID()
+
+	if id == "" {
+		t.Fatal("no Assigned Client Identifier")
+	}
+
+	first.Subscribe("a", 1)
+	first.Disconnect()
+
+	// -- a restart with the same Store connects with the assigned id and finds its session
+	var restarted = s.Client("", modify)
+
+	if restarted.ClientID() != id || !restarted.SessionPresent() {
+		t.Fatalf("restarted as %q with session present %v, was %q", restarted.ClientID(), restarted.SessionPresent(), id)
+	}
+	// --
+
+	// -- 3.1.1 has no assigned id, an empty one needs a clean session
+	var options = client.ClientOptions{Broker: "tcp://127.0.0.1:1883"}
+
+	if err := options.Validate(); err == nil {
+		t.Fatal("validated an empty ClientID without CleanSession on 3.1.1")
+	}
+
+	options.ProtocolVersion = mqttcodec.Version5
+
+	if err := options.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	// --
+}
+
+func TestAssignedClientIDReconnect(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+	var reconnected = make(chan bool, 1)
+
+	m.Handle(mqttcodec.CONNECT, mqtttest.Reply(&mqttcodec.Connack{Properties: &mqttcodec.Properties{AssignedClientIdentifier: "assigned"}}))
+
+	var c = connect(t, m, func(o *client.ClientOptions) {
+		o.ClientID = ""
+		o.ProtocolVersion = mqttcodec.Version5
+		o.AutoReconnect = true
+		o.InitialReconnectDelay = 10 * time.Millisecond
+		o.OnReconnected = func(sessionPresent bool) { reconnected <- sessionPresent }
+	})
+
+	if c.ClientID() != "assigned" {
+		t.Fatalf("client id %q", c.ClientID())
+	}
+
+	// -- the reconnect asks for the session of the assigned id
+	m.Expect(mqttcodec.CONNECT)
+	m.NextConn().Close()
+	next(t, reconnected)
+
+	if id := m.Expect(mqttcodec.CONNECT).Packet.(*mqttcodec.Connect).ClientID; id != "assigned" {
+		t.Fatalf("reconnected as %q", id)
+	}
+	// --
+}
@@ -0,0 +1,121 @@
+package client
+
+import (
+	"context"
+	"errors"
+
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/reasoncodes"
+	"github.com/MarcusOuelletus/demo/session"
+)
+
+/*
+With Authentication set every MQTT 5 CONNECT runs enhanced authentication through a session.EnhancedAuth of its own:
+the mechanism's name and initial data go out as the Authentication Method and Data, every AUTH with Continue
+authentication the broker sends before the CONNACK is answered with the mechanism's next response, and the CONNACK
+completes the exchange (a SASLVerifier checks its Authentication Data). A failed exchange fails the connect like a
+refusing CONNACK.
+
+Reauthenticate runs the exchange again on the established connection, the read loop answers the broker's AUTH packets
+and Reauthenticate returns once the broker sent Success. A broker that refuses the re-authentication disconnects,
+Reauthenticate returns the *DisconnectError then. A client that fell back to 3.1.1 has no enhanced authentication.
+*/
+
+var ErrNoEnhancedAuth = errors.New("no enhanced authentication on the connection")
+
+// startAuth puts the authentication properties of Authentication on connect, nil when there is nothing to run.
+func (c *Client) startAuth(connect *mqttcodec.Connect, version mqttcodec.ProtocolVersion) (*session.EnhancedAuth, error) {
+	if c.options.Authentication == nil || version != mqttcodec.Version5 {
+		return nil, nil
+	}
+
+	var auth = session.NewEnhancedAuth(c.options.Authentication)
+
+	// -- on a copy, connect may carry the ConnectProperties of the options themselves
+	var props mqttcodec.Properties
+
+	if connect.Properties != nil {
+		props = *connect.Properties
+	}
+	// --
+
+	var err error
+
+	if connect.Properties, err = auth.ConnectProperties(&props); err != nil {
+		return nil, err
+	}
+
+	return auth, nil
+}
+
+// Reauthenticate runs the enhanced authentication of the connection again and waits until it completed or ctx is done.
+func (c *Client) Reauthenticate(ctx context.Context) error {
+	n, err := c.current()
+
+	if err != nil {
+		return err
+	}
+
+	if n.auth == nil {
+		return ErrNoEnhancedAuth
+	}
+
+	auth, err := n.auth.Reauthenticate()
+
+	if err != nil {
+		return err
+	}
+
+	var done = make(chan error, 1)
+
+	c.mu.Lock()
+	n.reauth = done
+	c.mu.Unlock()
+
+	if err = n.write(auth); err != nil {
+		return err
+	}
+
+	select {
+	case err = <-done:
+		return err
+	case <-n.done:
+		return n.closedErr()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// handleAuth answers an AUTH of the broker on the read loop, a failed exchange closes the connection.
+func (c *Client) handleAuth(n *connection, p *mqttcodec.Auth) error {
+	if n.auth == nil {
+		return &reasonError{code: reasoncodes.ProtocolError, err: errors.New("AUTH from the server without enhanced authentication")}
+	}
+
+	reply, err := n.auth.HandleAuth(p)
+
+	if err != nil {
+		c.reauthenticated(n, err)
+		return &reasonError{code: reasoncodes.NotAuthorized, err: err}
+	}
+
+	if reply != nil {
+		return n.write(reply)
+	}
+
+	c.reauthenticated(n, nil)
+
+	return nil
+}
+
+// reauthenticated hands the outcome of a re-authentication to the Reauthenticate waiting for it.
+func (c *Client) reauthenticated(n *connection, err error) {
+	c.mu.Lock()
+	var done = n.reauth
+	n.reauth = nil
+	c.mu.Unlock()
+
+	if done != nil {
+		done <- err
+	}
+}
@@ -0,0 +1,145 @@
+package client_test
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/MarcusOuelletus/demo/client"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/mqtttest"
+	"github.com/MarcusOuelletus/demo/reasoncodes"
+)
+
+// mechanism answers every challenge with its secret and verifies that the server's final data is "ok".
+type mechanism struct{ secret atomic.Value }
+
+func newMechanism(secret string) *mechanism {
+	var m = &mechanism{}
+	m.secret.Store(secret)
+
+	return m
+}
+
+func (m *mechanism) Name() string { return "TEST" }
+
+func (m *mechanism) Start() ([]byte, error) { return []byte("hello"), nil }
+
+func (m *mechanism) Next(challenge []byte) ([]byte, error) {
+	return []byte(m.secret.Load().(string)), nil
+}
+
+func (m *mechanism) Finish(data []byte) error {
+	if string(data) != "ok" {
+		return errors.New("the server is not who it claims")
+	}
+
+	return nil
+}
+
+// authenticating is a broker for mechanism that wants the secret "secret", on connect and on re-authentication.
+func authenticating(m *mqtttest.MockBroker) {
+	var mu sync.Mutex
+	var reauthenticating = make(map[*mqtttest.Conn]bool)
+
+	var auth = func(code reasoncodes.Code, data string) *mqttcodec.Auth {
+		return &mqttcodec.Auth{ReasonCode: byte(code), Properties: &mqttcodec.Properties{AuthenticationMethod: "TEST", AuthenticationData: []byte(data)}}
+	}
+
+	m.Handle(mqttcodec.CONNECT, func(c *mqtttest.Conn, p mqttcodec.Packet) mqtttest.Response {
+		return mqtttest.Response{Packets: []mqttcodec.Packet{auth(reasoncodes.ContinueAuthentication, "challenge")}}
+	})
+
+	m.Handle(mqttcodec.AUTH, func(c *mqtttest.Conn, p mqttcodec.Packet) mqtttest.Response {
+		var a = p.(*mqttcodec.Auth)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if a.ReasonCode == byte(reasoncodes.ReAuthenticate) {
+			reauthenticating[c] = true
+			return mqtttest.Response{Packets: []mqttcodec.Packet{auth(reasoncodes.ContinueAuthentication, "challenge")}}
+		}
+
+		var ok = string(a.Properties.AuthenticationData) == "secret"
+
+		switch {
+		case reauthenticating[c] && ok:
+			return mqtttest.Response{Packets: []mqttcodec.Packet{auth(reasoncodes.Success, "ok")}}
+		case reauthenticating[c]:
+			return mqtttest.Response{Packets: []mqttcodec.Packet{&mqttcodec.Disconnection{ReasonCode: byte(reasoncodes.NotAuthorized)}}, Close: true}
+		case ok:
+			return mqtttest.Response{Packets: []mqttcodec.Packet{&mqttcodec.Connack{Properties: auth(reasoncodes.Success, "ok").Properties}}}
+		}
+
+		return mqtttest.Response{Packets: []mqttcodec.Packet{&mqttcodec.Connack{ReturnCode: byte(reasoncodes.NotAuthorized)}}, Close: true}
+	})
+}
+
+func TestEnhancedAuth(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+	var secret = newMechanism("secret")
+
+	authenticating(m)
+
+	var c = connect(t, m, func(o *client.ClientOptions) {
+		o.ProtocolVersion = mqttcodec.Version5
+		o.Authentication = secret
+	})
+
+	// -- the CONNECT carries the method and the initial data, the challenge is answered with an AUTH
+	if p := m.Expect(mqttcodec.CONNECT).Packet.(*mqttcodec.Connect).Properties; p.AuthenticationMethod != "TEST" || string(p.AuthenticationData) != "hello" {
+		t.Fatalf("CONNECT properties %+v", p)
+	}
+
+	if a := m.Expect(mqttcodec.AUTH).Packet.(*mqttcodec.Auth); string(a.Properties.AuthenticationData) != "secret" {
+		t.Fatalf("answered the challenge with %q", a.Properties.AuthenticationData)
+	}
+	// --
+
+	// -- Reauthenticate runs the exchange again on the connection
+	if err := c.Reauthenticate(timeout(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	if a := m.Expect(mqttcodec.AUTH).Packet.(*mqttcodec.Auth); a.ReasonCode != byte(reasoncodes.ReAuthenticate) {
+		t.Fatalf("re-authenticated with reason code %#x", a.ReasonCode)
+	}
+	// --
+
+	// -- a refused re-authentication is the broker's DISCONNECT
+	secret.secret.Store("wrong")
+
+	var disconnected *client.DisconnectError
+
+	if err := c.Reauthenticate(timeout(t)); !errors.As(err, &disconnected) || disconnected.ReasonCode != reasoncodes.NotAuthorized {
+		t.Fatalf("re-authenticate: %v", err)
+	}
+	// --
+}
+
+func TestEnhancedAuthRefused(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+
+	authenticating(m)
+
+	var options = m.Options("")
+
+	options.ProtocolVersion = mqttcodec.Version5
+	options.Authentication = newMechanism("wrong")
+
+	var refused *client.ConnackError
+
+	if err := client.New(options).Connect(); !errors.As(err, &refused) || refused.ReturnCode != byte(reasoncodes.NotAuthorized) {
+		t.Fatalf("connect: %v", err)
+	}
+
+	// -- without Authentication there is nothing to run again
+	var c = connect(t, mqtttest.NewMockBroker(t), func(o *client.ClientOptions) { o.ProtocolVersion = mqttcodec.Version5 })
+
+	if err := c.Reauthenticate(timeout(t)); !errors.Is(err, client.ErrNoEnhancedAuth) {
+		t.Fatalf("re-authenticate: %v", err)
+	}
+	// --
+}
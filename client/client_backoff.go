@@ -0,0 +1,54 @@
+package client
+
+import "github.com/MarcusOuelletus/demo/session"
+
+/*
+A Backoff decides how long the client waits before trying again, the reconnect loop asks ReconnectBackoff before every
+dial and the QoS 1 and 2 flows ask RetryBackoff before every resend of a PUBLISH or PUBREL. The attempt counts from 0
+for every lost connection and for every flow, Reset is called once a reconnect succeeded or an acknowledgement
+arrived after a resend, a Backoff that keeps state of its own starts over there. The flows share RetryBackoff, so its
+NextDelay has to be safe to call from several goroutines at once.
+
+Without a ReconnectBackoff the client uses an ExponentialBackoff from InitialReconnectDelay to MaxReconnectDelay with
+half of each delay jittered, without a RetryBackoff a ConstantBackoff of RetryInterval. A retry delay of 0 or less
+stops the resends of that flow, it then waits for the acknowledgement alone, a reconnect delay of 0 dials at once.
+*/
+
+// The backoffs are session's, the InflightStore retransmits with them too.
+type (
+	Backoff            = session.Backoff
+	ExponentialBackoff = session.ExponentialBackoff
+	ConstantBackoff    = session.ConstantBackoff
+)
+
+// reconnectBackoff is ReconnectBackoff or the exponential backoff of the reconnect delays.
+func (o *ClientOptions) reconnectBackoff() Backoff {
+	if o.ReconnectBackoff != nil {
+		return o.ReconnectBackoff
+	}
+
+	var b = ExponentialBackoff{Initial: o.InitialReconnectDelay, Max: o.MaxReconnectDelay, Jitter: 0.5}
+
+	if b.Initial <= 0 {
+		b.Initial = DefaultInitialReconnectDelay
+	}
+
+	if b.Max <= 0 {
+		b.Max = DefaultMaxReconnectDelay
+	}
+
+	return b
+}
+
+// retryBackoff is RetryBackoff or the constant RetryInterval, nil when the client never resends.
+func (o *ClientOptions) retryBackoff() Backoff {
+	if o.RetryBackoff != nil {
+		return o.RetryBackoff
+	}
+
+	if o.RetryInterval <= 0 {
+		return nil
+	}
+
+	return ConstantBackoff{Delay: o.RetryInterval}
+}
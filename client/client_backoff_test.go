@@ -0,0 +1,124 @@
+package client_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/client"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/mqtttest"
+)
+
+// recordingBackoff waits delay before every attempt below stop (0 is no stop) and records the attempts and resets.
+type recordingBackoff struct {
+	mu       sync.Mutex
+	delay    time.Duration
+	stop     int
+	attempts []int
+	resets   int
+}
+
+func (b *recordingBackoff) NextDelay(attempt int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.attempts = append(b.attempts, attempt)
+
+	if b.stop > 0 && attempt >= b.stop {
+		return 0
+	}
+
+	return b.delay
+}
+
+func (b *recordingBackoff) Reset() {
+	b.mu.Lock()
+	b.resets++
+	b.mu.Unlock()
+}
+
+func (b *recordingBackoff) recorded() ([]int, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]int(nil), b.attempts...), b.resets
+}
+
+func TestRetryBackoff(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+	var backoff = &recordingBackoff{delay: 20 * time.Millisecond}
+
+	m.Handle(mqttcodec.PUBLISH, mqtttest.Script(mqtttest.Drop, mqtttest.Drop, mqtttest.Default))
+
+	var c = connect(t, m, func(o *client.ClientOptions) { o.RetryBackoff = backoff })
+
+	// -- the flow asks before every resend, from attempt 0, and resets once the PUBACK came
+	if _, err := c.PublishQoS1(timeout(t), "a", nil, client.PublishOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if attempts, resets := backoff.recorded(); len(attempts) != 3 || attempts[0] != 0 || attempts[2] != 2 || resets != 1 {
+		t.Fatalf("attempts %v, resets %d", attempts, resets)
+	}
+	// --
+
+	// -- a delay of 0 stops the resends, the flow waits for the acknowledgement alone
+	var stopping = &recordingBackoff{delay: 10 * time.Millisecond, stop: 2}
+
+	m.Handle(mqttcodec.PUBLISH, mqtttest.Drop)
+
+	c = connect(t, m, func(o *client.ClientOptions) { o.RetryBackoff = stopping })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	c.PublishQoS1(ctx, "b", nil, client.PublishOptions{})
+
+	var publishes int
+
+	for _, r := range m.Received() {
+		if p, ok := r.Packet.(*mqttcodec.Publish); ok && p.TopicName == "b" {
+			publishes++
+		}
+	}
+
+	if publishes != 3 {
+		t.Fatalf("%d PUBLISHes", publishes)
+	}
+	// --
+}
+
+func TestReconnectBackoff(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+	var backoff = &recordingBackoff{delay: 5 * time.Millisecond}
+	var reconnected = make(chan bool, 1)
+
+	connect(t, m, func(o *client.ClientOptions) {
+		o.AutoReconnect = true
+		o.ReconnectBackoff = backoff
+		o.OnReconnected = func(sessionPresent bool) { reconnected <- sessionPresent }
+	})
+
+	// -- the loop asks before every dial and resets once it is connected again
+	m.Handle(mqttcodec.CONNECT, mqtttest.Script(mqtttest.Hangup, mqtttest.Hangup, mqtttest.Default))
+	m.NextConn().Close()
+	next(t, reconnected)
+
+	if attempts, resets := backoff.recorded(); len(attempts) != 3 || attempts[2] != 2 || resets != 1 {
+		t.Fatalf("attempts %v, resets %d", attempts, resets)
+	}
+	// --
+
+	// -- ReconnectBackoff replaces the reconnect delays, both at once are ambiguous
+	var options = m.Options("")
+
+	options.ReconnectBackoff = backoff
+	options.MaxReconnectDelay = time.Second
+
+	if err := options.Validate(); err == nil {
+		t.Fatal("validated ReconnectBackoff with MaxReconnectDelay")
+	}
+	// --
+}
@@ -0,0 +1,261 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/reasoncodes"
+	"github.com/MarcusOuelletus/demo/session"
+)
+
+/*
+SubscribeMultiple sends one SUBSCRIBE for many filters and UnsubscribeMultiple one UNSUBSCRIBE, a client with hundreds
+of filters pays one round trip for all of them instead of one each. The SUBACK (and the MQTT 5 UNSUBACK) carries a
+reason code per filter in the order of the request, the read loop parks the whole acknowledgement under its packet id
+like every other one, so each filter gets a GrantResult of its own: a refused filter has its Err set and is dropped,
+the others are subscribed all the same. The returned error is for the packet as a whole (not connected, the connection
+lost, ctx done, an acknowledgement with the wrong number of codes), none of the filters took effect then.
+
+A filter that is not valid (a/#/b, a QoS above 2...) never reaches the broker: its GrantResult has Err set and
+ReasonCode TopicFilterInvalid, and the SUBSCRIBE goes out with the other filters, or not at all when none is left.
+
+The filters of one SUBSCRIBE have one set of properties, the UserProperties of every FilterOptions go out together in
+the order of the filters. A filter that comes twice in one call is an error before anything is sent, the broker would
+only keep the second. A 3.1.1 UNSUBACK has no reason codes, every filter of it is a success.
+*/
+
+// FilterOptions is one filter of SubscribeMultiple with its options and handler.
+type FilterOptions struct {
+	Filter  string
+	Options SubscribeOptions
+	Handler MessageHandler
+	// channel is the messageChan of SubscribeChan, closed with the subscription.
+	channel *messageChan
+}
+
+// GrantResult is the outcome for one filter of SubscribeMultiple or UnsubscribeMultiple. GrantedQoS is only set for
+// a subscription, Err for a failure reason code.
+type GrantResult struct {
+	Filter     string
+	ReasonCode reasoncodes.Code
+	GrantedQoS byte
+	Err        error
+}
+
+// SubscribeMultiple subscribes to every filter with one SUBSCRIBE and returns their results in the same order.
+func (c *Client) SubscribeMultiple(ctx context.Context, filters []FilterOptions) ([]GrantResult, error) {
+	return c.subscribeMultiple(ctx, filters)
+}
+
+func (c *Client) subscribeMultiple(ctx context.Context, filters []FilterOptions) ([]GrantResult, error) {
+	if len(filters) == 0 {
+		return nil, nil
+	}
+
+	if err := distinct(len(filters), func(i int) string { return filters[i].Filter }); err != nil {
+		return nil, err
+	}
+
+	// -- the invalid filters get their result now, valid holds the index of every filter that goes out
+	var results = make([]GrantResult, len(filters))
+	var valid []int
+
+	for i, f := range filters {
+		results[i] = GrantResult{Filter: f.Filter}
+
+		if results[i].Err = validFilter(f); results[i].Err != nil {
+			results[i].ReasonCode = reasoncodes.TopicFilterInvalid
+			continue
+		}
+
+		valid = append(valid, i)
+	}
+
+	if len(valid) == 0 {
+		return results, nil
+	}
+	// --
+
+	n, err := c.current()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var ch = make(chan uint16, 1)
+
+	id, err := c.reserve(ch)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer c.release(id, ch)
+
+	var s = &mqttcodec.Subscribe{PacketID: id.Value}
+	var userProperties []KeyValue
+
+	for _, i := range valid {
+		var f = filters[i]
+		var sub = session.Subscription{
+			Filter:            f.Filter,
+			QoS:               f.Options.QoS,
+			NoLocal:           f.Options.NoLocal,
+			RetainAsPublished: f.Options.RetainAsPublished,
+			RetainHandling:    f.Options.RetainHandling,
+		}
+
+		s.Subscriptions = append(s.Subscriptions, mqttcodec.SubscribeFilter{
+			Filter:            sub.Filter,
+			QoS:               sub.QoS,
+			NoLocal:           sub.NoLocal,
+			RetainAsPublished: sub.RetainAsPublished,
+			RetainHandling:    sub.RetainHandling,
+		})
+
+		userProperties = append(userProperties, f.Options.UserProperties...)
+
+		c.subscriptions.Requested(sub, wrapHandler(c.dispatch(f.Filter, f.Handler)))
+		c.setChannel(f.Filter, f.channel)
+	}
+
+	if userProperties != nil {
+		s.Properties = &mqttcodec.Properties{UserProperties: userProperties}
+	}
+
+	// -- none of the filters took effect when the packet as a whole failed
+	var abort = func(err error) ([]GrantResult, error) {
+		for _, i := range valid {
+			c.removeSubscription(filters[i].Filter)
+		}
+
+		return nil, err
+	}
+	// --
+
+	if err = n.write(s); err != nil {
+		return abort(err)
+	}
+
+	ack, err := c.await(ctx, n, id.Value, ch, mqttcodec.SUBACK)
+
+	if err != nil {
+		return abort(err)
+	}
+
+	var codes = ack.(*mqttcodec.Suback).ReturnCodes
+
+	if len(codes) != len(valid) {
+		return abort(fmt.Errorf("SUBACK has %d return codes for %d topic filters", len(codes), len(valid)))
+	}
+
+	for j, i := range valid {
+		var filter = filters[i].Filter
+		results[i].ReasonCode = reasoncodes.Code(codes[j])
+
+		if results[i].Err = c.subscriptions.Granted(filter, codes[j]); results[i].Err != nil {
+			c.setChannel(filter, nil)
+			continue
+		}
+
+		results[i].GrantedQoS = codes[j]
+	}
+
+	return results, nil
+}
+
+// validFilter checks f the way the SUBSCRIBE encoder would, so one bad filter does not fail the others.
+func validFilter(f FilterOptions) error {
+	if err := mqttcodec.ValidateFilter(f.Filter); err != nil {
+		return err
+	}
+
+	if f.Options.QoS > 2 {
+		return fmt.Errorf("topic filter %q with qos %d", f.Filter, f.Options.QoS)
+	}
+
+	if f.Options.RetainHandling > 2 {
+		return fmt.Errorf("topic filter %q with retain handling %d", f.Filter, f.Options.RetainHandling)
+	}
+
+	return nil
+}
+
+// UnsubscribeMultiple unsubscribes every filter with one UNSUBSCRIBE and returns their results in the same order, a
+// filter the broker refused to unsubscribe keeps its handler.
+func (c *Client) UnsubscribeMultiple(ctx context.Context, filters []string) ([]GrantResult, error) {
+	if len(filters) == 0 {
+		return nil, nil
+	}
+
+	if err := distinct(len(filters), func(i int) string { return filters[i] }); err != nil {
+		return nil, err
+	}
+
+	n, err := c.current()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var ch = make(chan uint16, 1)
+
+	id, err := c.reserve(ch)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer c.release(id, ch)
+
+	if err = n.write(&mqttcodec.Unsubscribe{PacketID: id.Value, Filters: filters}); err != nil {
+		return nil, err
+	}
+
+	ack, err := c.await(ctx, n, id.Value, ch, mqttcodec.UNSUBACK)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var codes = make([]byte, len(filters))
+
+	if unsuback, ok := ack.(*mqttcodec.Unsuback); ok {
+		if len(unsuback.ReasonCodes) != len(filters) {
+			return nil, fmt.Errorf("UNSUBACK has %d reason codes for %d topic filters", len(unsuback.ReasonCodes), len(filters))
+		}
+
+		codes = unsuback.ReasonCodes
+	}
+
+	var results = make([]GrantResult, len(filters))
+
+	for i, filter := range filters {
+		results[i] = GrantResult{Filter: filter, ReasonCode: reasoncodes.Code(codes[i])}
+
+		if results[i].ReasonCode.IsFailure() {
+			results[i].Err = fmt.Errorf("unsubscribe from %q refused with reason code 0x%02X", filter, codes[i])
+			continue
+		}
+
+		results[i].Err = c.removeSubscription(filter)
+	}
+
+	return results, nil
+}
+
+// distinct fails when two of the n filters are the same.
+func distinct(n int, filter func(i int) string) error {
+	var seen = make(map[string]struct{}, n)
+
+	for i := 0; i < n; i++ {
+		if _, ok := seen[filter(i)]; ok {
+			return fmt.Errorf("topic filter %q more than once", filter(i))
+		}
+
+		seen[filter(i)] = struct{}{}
+	}
+
+	return nil
+}
@@ -0,0 +1,178 @@
+package client_test
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/MarcusOuelletus/demo/broker"
+	"github.com/MarcusOuelletus/demo/brokertest"
+	"github.com/MarcusOuelletus/demo/client"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/mqtttest"
+	"github.com/MarcusOuelletus/demo/reasoncodes"
+)
+
+// subscriptions is every filter m got in a SUBSCRIBE, and the number of SUBSCRIBEs.
+func subscriptions(m *mqtttest.MockBroker) ([]string, int) {
+	var filters []string
+	var packets int
+
+	for _, r := range m.Received() {
+		if s, ok := r.Packet.(*mqttcodec.Subscribe); ok {
+			packets++
+
+			for _, f := range s.Subscriptions {
+				filters = append(filters, f.Filter)
+			}
+		}
+	}
+
+	return filters, packets
+}
+
+func TestSubscribeMultiple(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+
+	// -- the broker grants what was asked for and refuses "refused" with Not authorized
+	m.Handle(mqttcodec.SUBSCRIBE, func(c *mqtttest.Conn, p mqttcodec.Packet) mqtttest.Response {
+		var s = p.(*mqttcodec.Subscribe)
+		var codes = make([]byte, len(s.Subscriptions))
+
+		for i, f := range s.Subscriptions {
+			codes[i] = f.QoS
+
+			if f.Filter == "refused" {
+				codes[i] = byte(reasoncodes.NotAuthorized)
+			}
+		}
+
+		return mqtttest.Response{Packets: []mqttcodec.Packet{&mqttcodec.Suback{PacketID: s.PacketID, ReturnCodes: codes}}}
+	})
+	// --
+
+	var c = connect(t, m, func(o *client.ClientOptions) { o.ProtocolVersion = mqttcodec.Version5 })
+	var filters []client.FilterOptions
+
+	for i := 0; i < 200; i++ {
+		filters = append(filters, client.FilterOptions{Filter: fmt.Sprintf("f/%d", i), Options: client.SubscribeOptions{QoS: byte(i % 3)}})
+	}
+
+	filters = append(filters, client.FilterOptions{Filter: "refused"})
+
+	// -- one SUBSCRIBE, one result per filter in the order of the call
+	results, err := c.SubscribeMultiple(timeout(t), filters)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 201 || results[5].Filter != "f/5" || results[5].GrantedQoS != 2 || results[5].Err != nil {
+		t.Fatalf("%d results, the sixth %+v", len(results), results[5])
+	}
+
+	if r := results[200]; r.Err == nil || r.ReasonCode != reasoncodes.NotAuthorized {
+		t.Fatalf("the refused filter %+v", r)
+	}
+
+	if _, packets := subscriptions(m); packets != 1 {
+		t.Fatalf("%d SUBSCRIBEs", packets)
+	}
+	// --
+
+	// -- a filter twice in one call is an error before anything is sent
+	if _, err := c.SubscribeMultiple(timeout(t), []client.FilterOptions{{Filter: "x"}, {Filter: "x"}}); err == nil {
+		t.Fatal("subscribed to x twice in one SUBSCRIBE")
+	}
+
+	if _, packets := subscriptions(m); packets != 1 {
+		t.Fatalf("%d SUBSCRIBEs", packets)
+	}
+	// --
+
+	// -- the UNSUBACK's reason codes are per filter too
+	m.Handle(mqttcodec.UNSUBSCRIBE, func(c *mqtttest.Conn, p mqttcodec.Packet) mqtttest.Response {
+		var u = p.(*mqttcodec.Unsubscribe)
+		var codes = make([]byte, len(u.Filters))
+
+		codes[0] = byte(reasoncodes.UnspecifiedError)
+
+		return mqtttest.Response{Packets: []mqttcodec.Packet{&mqttcodec.Unsuback{PacketID: u.PacketID, ReasonCodes: codes}}}
+	})
+
+	unsubscribed, err := c.UnsubscribeMultiple(timeout(t), []string{"f/0", "f/1"})
+
+	if err != nil || len(unsubscribed) != 2 || unsubscribed[0].Err == nil || unsubscribed[1].Err != nil {
+		t.Fatalf("unsubscribe %+v: %v", unsubscribed, err)
+	}
+	// --
+}
+
+func TestSubscribeMultipleInvalidFilter(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+	var c = connect(t, m, nil)
+
+	// -- the invalid filters fail on their own, the others go out in one SUBSCRIBE
+	results, err := c.SubscribeMultiple(timeout(t), []client.FilterOptions{
+		{Filter: "a"},
+		{Filter: "a/#/b"},
+		{Filter: "b", Options: client.SubscribeOptions{QoS: 3}},
+		{Filter: "c"},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if results[0].Err != nil || results[3].Err != nil || results[1].Err == nil || results[1].ReasonCode != reasoncodes.TopicFilterInvalid || results[2].Err == nil {
+		t.Fatalf("results %+v", results)
+	}
+
+	if filters, _ := subscriptions(m); !reflect.DeepEqual(filters, []string{"a", "c"}) {
+		t.Fatalf("subscribed to %v", filters)
+	}
+	// --
+
+	// -- a 3.1.1 UNSUBACK has no reason codes, every filter is a success
+	unsubscribed, err := c.UnsubscribeMultiple(timeout(t), []string{"a", "c"})
+
+	if err != nil || len(unsubscribed) != 2 || unsubscribed[0].Err != nil || unsubscribed[1].Err != nil {
+		t.Fatalf("unsubscribe %+v: %v", unsubscribed, err)
+	}
+	// --
+}
+
+func TestSubscribeMultipleHandlers(t *testing.T) {
+	var s = brokertest.Start(t, broker.Options{})
+	var c = client.New(s.Options("c"))
+
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Disconnect()
+
+	var a, b = make(chan client.Message, 1), make(chan client.Message, 1)
+
+	// -- every filter of the batch gets its own handler
+	if _, err := c.SubscribeMultiple(timeout(t), []client.FilterOptions{
+		{Filter: "a", Options: client.SubscribeOptions{QoS: 1}, Handler: func(m client.Message) { a <- m }},
+		{Filter: "b", Options: client.SubscribeOptions{QoS: 1}, Handler: func(m client.Message) { b <- m }},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var pub = s.Client("pub", nil)
+
+	pub.Publish("b", "to b", 1)
+	pub.Publish("a", "to a", 1)
+
+	if m := next(t, a); string(m.Payload) != "to a" {
+		t.Fatalf("a got %q", m.Payload)
+	}
+
+	if m := next(t, b); string(m.Payload) != "to b" {
+		t.Fatalf("b got %q", m.Payload)
+	}
+	// --
+}
@@ -0,0 +1,84 @@
+package client
+
+import (
+	"context"
+	"sync"
+)
+
+/*
+SubscribeChan is Subscribe for applications that would rather range over a channel than write a callback.
+
+The subscription's handler puts each message on the channel. When the buffer is full it waits until the application
+takes a message, so a consumer that falls behind first fills the buffer and then holds up whatever delivers the
+message: the read loop, which stops reading from the broker (and with it acknowledging), or with HandlerWorkers the
+filter's worker. Nothing is dropped. The channel is closed once the subscription ends, by Unsubscribe, by a later
+Subscribe or SubscribeChan for the same filter, or by the broker refusing it, a message in flight at that moment is
+dropped instead of blocking forever. It stays open across reconnects.
+*/
+
+// messageChan is a channel that can be closed while a send may be waiting on it.
+type messageChan struct {
+	sync.Mutex
+	ch   chan Message
+	done chan struct{}
+	once sync.Once
+}
+
+// SubscribeChan subscribes to filter with QoS 1 and returns the channel its messages arrive on.
+func (c *Client) SubscribeChan(filter string, buffer int) (<-chan Message, error) {
+	if buffer < 0 {
+		buffer = 0
+	}
+
+	var m = &messageChan{ch: make(chan Message, buffer), done: make(chan struct{})}
+
+	if err := c.subscribe(context.Background(), filter, SubscribeOptions{QoS: 1}, m.send, m); err != nil {
+		m.close()
+		return nil, err
+	}
+
+	return m.ch, nil
+}
+
+// setChannel records the channel fed by the subscription to filter and closes the one it replaces.
+func (c *Client) setChannel(filter string, m *messageChan) {
+	c.mu.Lock()
+	var previous = c.channels[filter]
+
+	if m == nil {
+		delete(c.channels, filter)
+	} else {
+		c.channels[filter] = m
+	}
+	c.mu.Unlock()
+
+	if previous != nil && previous != m {
+		previous.close()
+	}
+}
+
+func (m *messageChan) send(msg Message) {
+	m.Lock()
+	defer m.Unlock()
+
+	select {
+	case <-m.done:
+		return
+	default:
+	}
+
+	select {
+	case m.ch <- msg:
+	case <-m.done:
+	}
+}
+
+// close releases a waiting send before closing the channel, so no send can ever hit the closed channel.
+func (m *messageChan) close() {
+	m.once.Do(func() {
+		close(m.done)
+		m.Lock()
+		close(m.ch)
+		m.Unlock()
+	})
+}
@@ -0,0 +1,65 @@
+package client_test
+
+import (
+	"testing"
+
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/mqtttest"
+)
+
+func TestSubscribeChan(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+	var c = connect(t, m, nil)
+
+	messages, err := c.SubscribeChan("a/#", 1)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s := m.Expect(mqttcodec.SUBSCRIBE).Packet.(*mqttcodec.Subscribe); s.Subscriptions[0].QoS != 1 {
+		t.Fatalf("subscribed with qos %d", s.Subscriptions[0].QoS)
+	}
+
+	var conn = m.NextConn()
+
+	for i := uint16(1); i <= 3; i++ {
+		conn.Send(&mqttcodec.Publish{TopicName: "a/b", Payload: []byte{byte(i)}, QoS: 1, PacketID: i})
+	}
+
+	for i := byte(1); i <= 3; i++ {
+		if msg := next(t, messages); msg.Payload[0] != i {
+			t.Fatalf("message %d has payload %d", i, msg.Payload[0])
+		}
+	}
+
+	// -- a full buffer blocks the delivery, Unsubscribe still closes the channel
+	conn.Send(&mqttcodec.Publish{TopicName: "a/b", Payload: []byte{4}}, &mqttcodec.Publish{TopicName: "a/b", Payload: []byte{5}})
+
+	var unsubscribed = make(chan error, 1)
+
+	go func() { unsubscribed <- c.Unsubscribe(timeout(t), "a/#") }()
+
+	for range messages {
+	}
+
+	if err := next(t, unsubscribed); err != nil {
+		t.Fatal(err)
+	}
+	// --
+
+	// -- a second SubscribeChan for the same filter closes the channel of the first
+	first, _ := c.SubscribeChan("b", 0)
+	second, _ := c.SubscribeChan("b", 0)
+
+	if _, open := <-first; open {
+		t.Fatal("the first channel got a message")
+	}
+
+	conn.Send(&mqttcodec.Publish{TopicName: "b", Payload: []byte{6}})
+
+	if msg := next(t, second); msg.Payload[0] != 6 {
+		t.Fatalf("second channel got payload %d", msg.Payload[0])
+	}
+	// --
+}
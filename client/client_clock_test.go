@@ -0,0 +1,88 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/client"
+	"github.com/MarcusOuelletus/demo/clock"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/mqtttest"
+)
+
+func TestClockRetry(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+	var fake = clock.NewFake(time.Unix(0, 0))
+
+	m.Handle(mqttcodec.PUBLISH, mqtttest.Script(mqtttest.Drop, mqtttest.Default))
+
+	var c = connect(t, m, func(o *client.ClientOptions) {
+		o.Clock = fake
+		o.RetryInterval = time.Hour
+	})
+
+	var pending = fake.Pending()
+	var done = make(chan error, 1)
+
+	go func() {
+		_, err := c.PublishQoS1(context.Background(), "a", nil, client.PublishOptions{})
+		done <- err
+	}()
+
+	// -- the resend waits for the hour on the client's clock, not on the wall clock
+	fake.BlockUntil(pending + 1)
+	m.Expect(mqttcodec.PUBLISH)
+
+	select {
+	case err := <-done:
+		t.Fatalf("acknowledged before the resend: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	fake.Advance(time.Hour)
+
+	if err := next(t, done); err != nil {
+		t.Fatal(err)
+	}
+	// --
+}
+
+func TestClockKeepalive(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+	var fake = clock.NewFake(time.Unix(0, 0))
+	var lost = make(chan error, 1)
+
+	m.Handle(mqttcodec.PINGREQ, mqtttest.Drop)
+
+	connect(t, m, func(o *client.ClientOptions) {
+		o.Clock = fake
+		o.KeepAlive = 10
+		o.OnConnectionLost = func(err error) { lost <- err }
+	})
+
+	fake.BlockUntil(1)
+
+	// -- a broker that does not answer the PINGREQ is found out once the client's clock has moved on far enough
+	for second := 0; second < 60; second++ {
+		fake.Advance(time.Second)
+
+		select {
+		case err := <-lost:
+			if !errors.Is(err, client.ErrKeepaliveTimeout) {
+				t.Fatalf("lost with %v", err)
+			}
+
+			if second < 10 {
+				t.Fatalf("lost after %ds of a 10s keepalive", second+1)
+			}
+
+			return
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+
+	t.Fatal("still connected after 60s without a PINGRESP")
+	// --
+}
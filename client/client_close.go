@@ -0,0 +1,140 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+)
+
+/*
+Close ends the client for good and takes its pieces down in the one order that loses nothing:
+
+ 1. Publish, PublishQoS1 and PublishQoS2 fail with ErrClientClosed from now on, so does Connect. Publishes already
+    accepted go on, also those still waiting for send quota.
+ 2. The PacketWriter flushes what it has buffered.
+ 3. Close waits until the FlowController has no QoS 1 or 2 flow in flight, the acknowledgements keep coming in on the
+    read loop meanwhile. A flow that is still open when ctx is done stays in the Store like after a lost connection,
+    a client made with the same Store resumes it.
+ 4. DISCONNECT goes out (also once ctx is done, the broker must not publish the will) and is flushed.
+ 5. The connection is closed, a reconnect in progress is stopped, and Close waits for the read loop and the inbound
+    limiter to return, nothing hands messages to the handlers after that.
+ 6. The HandlerWorkers run the messages queued for them and stop, then the ResponseBroadcaster is waited on until the
+    last publish or subscribe gave its listener back.
+
+Every wait ends when ctx does, Close then still finishes the steps that do not wait (DISCONNECT, closing the
+connection, stopping the workers) and returns ctx's error. Disconnect is step 4 and 5 alone, use it for a client that
+is going to Connect again.
+*/
+
+// closePoll is how often Close looks at the flows in flight and the listeners left.
+var closePoll = 10 * time.Millisecond
+
+// Close shuts the client down as described above, a second Close returns ErrClientClosed.
+func (c *Client) Close(ctx context.Context) error {
+	// -- 1. no new publishes
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrClientClosed
+	}
+	c.closed = true
+	var n = c.conn
+	c.mu.Unlock()
+	// --
+
+	var err error
+
+	if n != nil && !n.closed() {
+		// -- 2. and 3. flush, then let the flows in flight finish
+		n.writer.Flush()
+
+		err = c.waitUntil(ctx, func() bool { return c.flow.InFlight() == 0 || n.closed() })
+		// --
+	}
+
+	// -- 4. and 5. DISCONNECT on the connection that is current by now, a reconnect may have replaced n
+	var stopped error
+
+	if n = c.detach(); n != nil {
+		if !n.closed() {
+			n.write(mqttcodec.Disconnect)
+			n.writer.Close()
+		}
+		n.close(nil)
+
+		stopped = wait(ctx, n.loops.Wait)
+		err = firstError(err, stopped)
+	}
+	// --
+
+	// -- 6. the handlers and the waiting acknowledgements, a read loop that did not return yet may still submit to the
+	// workers, they are left running then
+	if c.workers != nil && stopped == nil {
+		err = firstError(err, c.workers.drain(ctx))
+	}
+
+	err = firstError(err, c.waitUntil(ctx, func() bool { return c.broadcaster.Len() == 0 }))
+	// --
+
+	return err
+}
+
+// publishing is current for a new publish, Close refuses those.
+func (c *Client) publishing() (*connection, error) {
+	c.mu.Lock()
+	var closed = c.closed
+	c.mu.Unlock()
+
+	if closed {
+		return nil, ErrClientClosed
+	}
+
+	return c.current()
+}
+
+// waitUntil polls done on the client's clock until it is true or ctx is done.
+func (c *Client) waitUntil(ctx context.Context, done func() bool) error {
+	if done() {
+		return nil
+	}
+
+	var ticker = c.options.clock().NewTicker(closePoll)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			if done() {
+				return nil
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// wait runs f on a goroutine of its own and waits for it to return or for ctx to be done.
+func wait(ctx context.Context, f func()) error {
+	var done = make(chan struct{})
+
+	go func() {
+		f()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func firstError(err, next error) error {
+	if err != nil {
+		return err
+	}
+
+	return next
+}
@@ -0,0 +1,112 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/client"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/mqtttest"
+)
+
+func TestClose(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+
+	m.Handle(mqttcodec.PUBLISH, mqtttest.Delay(100*time.Millisecond, mqtttest.Default))
+
+	var c = connect(t, m, nil)
+	var done = make(chan error, 1)
+
+	go func() {
+		_, err := c.PublishQoS1(context.Background(), "a", nil, client.PublishOptions{})
+		done <- err
+	}()
+
+	m.Expect(mqttcodec.PUBLISH)
+
+	// -- Close waits for the PUBACK of the flow in flight before the DISCONNECT
+	if err := c.Close(timeout(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := next(t, done); err != nil {
+		t.Fatalf("the publish in flight: %v", err)
+	}
+
+	m.Expect(mqttcodec.DISCONNECT)
+	// --
+
+	// -- a closed client is Terminated for good
+	if _, err := c.PublishQoS1(timeout(t), "a", nil, client.PublishOptions{}); !errors.Is(err, client.ErrClientClosed) {
+		t.Fatalf("publish after Close: %v", err)
+	}
+
+	if err := c.Connect(); !errors.Is(err, client.ErrClientClosed) {
+		t.Fatalf("connect after Close: %v", err)
+	}
+
+	if err := c.Close(timeout(t)); !errors.Is(err, client.ErrClientClosed) {
+		t.Fatalf("second Close: %v", err)
+	}
+
+	if c.State() != client.StateTerminated {
+		t.Fatalf("%v after Close", c.State())
+	}
+	// --
+}
+
+func TestCloseDeadline(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+
+	m.Handle(mqttcodec.PUBLISH, mqtttest.Drop)
+
+	var c = connect(t, m, nil)
+
+	go c.PublishQoS1(context.Background(), "a", nil, client.PublishOptions{})
+
+	m.Expect(mqttcodec.PUBLISH)
+
+	// -- a flow that is never acknowledged holds Close up until ctx, the DISCONNECT still goes out
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if err := c.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("close: %v", err)
+	}
+
+	m.Expect(mqttcodec.DISCONNECT)
+	// --
+}
+
+func TestCloseDrainsWorkers(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+	var c = connect(t, m, func(o *client.ClientOptions) { o.HandlerWorkers = 1 })
+	var handled atomic.Int32
+
+	if err := c.Subscribe(timeout(t), "a", client.SubscribeOptions{QoS: 1}, func(client.Message) {
+		time.Sleep(30 * time.Millisecond)
+		handled.Add(1)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// -- the messages queued for the worker are all handled before Close returns
+	var conn = m.NextConn()
+
+	for id := uint16(1); id <= 3; id++ {
+		conn.Send(&mqttcodec.Publish{TopicName: "a", QoS: 1, PacketID: id})
+		m.Expect(mqttcodec.PUBACK)
+	}
+
+	if err := c.Close(timeout(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := handled.Load(); n != 3 {
+		t.Fatalf("%d messages handled", n)
+	}
+	// --
+}
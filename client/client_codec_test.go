@@ -0,0 +1,110 @@
+package client_test
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"testing"
+
+	"github.com/MarcusOuelletus/demo/client"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/mqtttest"
+)
+
+type item struct {
+	ID  int    `json:"id" xml:"id"`
+	SKU string `json:"sku" xml:"sku"`
+}
+
+type xmlCodec struct{}
+
+func (xmlCodec) Marshal(v any) ([]byte, error) { return xml.Marshal(v) }
+
+func (xmlCodec) Unmarshal(data []byte, v any) error { return xml.Unmarshal(data, v) }
+
+func TestPublishObject(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+	var c = connect(t, m, func(o *client.ClientOptions) { o.ProtocolVersion = mqttcodec.Version5 })
+
+	// -- JSON is there from the start and is the default
+	if err := c.PublishObject(timeout(t), "orders", item{1, "a"}, client.PublishOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if p := m.Expect(mqttcodec.PUBLISH).Packet.(*mqttcodec.Publish); string(p.Payload) != `{"id":1,"sku":"a"}` || p.Properties.ContentType != "application/json" {
+		t.Fatalf("published %s with %+v", p.Payload, p.Properties)
+	}
+	// --
+
+	// -- a content type without a codec fails, a registered one is found by its media type, nil removes it again
+	if err := c.PublishObject(timeout(t), "orders", item{}, client.PublishOptions{ContentType: "text/xml"}); !errors.Is(err, client.ErrNoPayloadCodec) {
+		t.Fatalf("publish: %v", err)
+	}
+
+	c.RegisterPayloadCodec("Text/XML", xmlCodec{})
+
+	if err := c.PublishObject(timeout(t), "orders", item{2, "b"}, client.PublishOptions{ContentType: "text/xml; charset=utf-8"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if p := m.Expect(mqttcodec.PUBLISH).Packet.(*mqttcodec.Publish); string(p.Payload) != "<item><id>2</id><sku>b</sku></item>" {
+		t.Fatalf("published %s", p.Payload)
+	}
+
+	c.RegisterPayloadCodec("text/xml", nil)
+
+	if err := c.PublishObject(timeout(t), "orders", item{}, client.PublishOptions{ContentType: "text/xml"}); !errors.Is(err, client.ErrNoPayloadCodec) {
+		t.Fatalf("publish after removing the codec: %v", err)
+	}
+	// --
+}
+
+func TestObjectHandler(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+	var c = connect(t, m, func(o *client.ClientOptions) { o.ProtocolVersion = mqttcodec.Version5 })
+	var items, topics = make(chan item, 4), make(chan string, 4)
+
+	c.RegisterPayloadCodec("text/xml", xmlCodec{})
+
+	if err := c.Subscribe(timeout(t), "orders/#", client.SubscribeOptions{}, client.ObjectHandler(c, func(ctx context.Context, o item) {
+		msg, _ := client.MessageFromContext(ctx)
+		topics <- msg.Topic
+		items <- o
+	})); err != nil {
+		t.Fatal(err)
+	}
+
+	var send = func(payload, contentType string) *mqttcodec.Publish {
+		return &mqttcodec.Publish{TopicName: "orders/new", Payload: []byte(payload), Properties: &mqttcodec.Properties{ContentType: contentType}}
+	}
+
+	// -- a payload that does not decode is dropped, one without a Content Type is JSON
+	m.NextConn().Send(
+		send("not json", ""),
+		send("<item><id>2</id><sku>b</sku></item>", "text/xml; charset=utf-8"),
+		send(`{"id":3}`, ""),
+	)
+
+	for _, want := range []int{2, 3} {
+		if o := next(t, items); o.ID != want || next(t, topics) != "orders/new" {
+			t.Fatalf("got item %d, want %d", o.ID, want)
+		}
+	}
+
+	if dropped := c.Metrics().Dropped; dropped != 1 {
+		t.Fatalf("%d dropped", dropped)
+	}
+	// --
+
+	// -- DecodePayload is the same decoding for a plain handler
+	var o item
+
+	if err := c.DecodePayload(client.Message{Payload: []byte(`{"id":4}`)}, &o); err != nil || o.ID != 4 {
+		t.Fatalf("decoded %+v: %v", o, err)
+	}
+
+	if err := c.DecodePayload(client.Message{ContentType: "application/cbor"}, &o); !errors.Is(err, client.ErrNoPayloadCodec) {
+		t.Fatalf("decode: %v", err)
+	}
+	// --
+}
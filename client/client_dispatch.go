@@ -0,0 +1,79 @@
+package client
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+)
+
+/*
+By default message handlers run on the read loop, which is simple and keeps every message in order, but a slow handler
+holds up PINGRESP and acknowledgement processing with it. With HandlerWorkers set the handlers run on a pool instead.
+
+Each worker has its own bounded queue and a subscription's messages always go to the same worker, picked by hashing
+its filter, so one filter's handler still sees its messages one at a time and in order while different filters run in
+parallel. When a worker's queue is full the read loop waits for room, a handler that is slow for long enough therefore
+still pushes back on the broker instead of letting the queue grow without bound. The pool lives as long as the Client,
+Close drains it once no connection can submit to it anymore.
+*/
+
+var DefaultHandlerQueue = 64
+
+type workerPool struct {
+	queues []chan func()
+	wg     sync.WaitGroup
+}
+
+func newWorkerPool(workers, queue int) *workerPool {
+	if queue <= 0 {
+		queue = DefaultHandlerQueue
+	}
+
+	var p = &workerPool{queues: make([]chan func(), workers)}
+
+	for i := range p.queues {
+		p.queues[i] = make(chan func(), queue)
+		p.wg.Add(1)
+		go p.work(p.queues[i])
+	}
+
+	return p
+}
+
+func (p *workerPool) work(queue chan func()) {
+	defer p.wg.Done()
+
+	for f := range queue {
+		f()
+	}
+}
+
+// submit queues f on the worker that owns key, it blocks while that worker's queue is full.
+func (p *workerPool) submit(key string, f func()) {
+	var h = fnv.New32a()
+	h.Write([]byte(key))
+
+	p.queues[h.Sum32()%uint32(len(p.queues))] <- f
+}
+
+// drain stops the workers once they ran what is queued, it waits for them until ctx is done.
+func (p *workerPool) drain(ctx context.Context) error {
+	for _, queue := range p.queues {
+		close(queue)
+	}
+
+	return wait(ctx, p.wg.Wait)
+}
+
+// dispatch wraps the handler of filter so it runs on the pool, it is the handler itself without one.
+func (c *Client) dispatch(filter string, handler MessageHandler) MessageHandler {
+	if handler == nil || c.workers == nil {
+		return handler
+	}
+
+	var pool = c.workers
+
+	return func(msg Message) {
+		pool.submit(filter, func() { handler(msg) })
+	}
+}
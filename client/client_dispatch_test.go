@@ -0,0 +1,46 @@
+package client_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/MarcusOuelletus/demo/client"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/mqtttest"
+)
+
+func TestHandlerWorkers(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+	var c = connect(t, m, func(o *client.ClientOptions) { o.HandlerWorkers = 2 })
+
+	// -- slow and fast/+ hash to different workers, the slow handler blocks until the end of the test
+	var block = make(chan struct{})
+	defer close(block)
+
+	if err := c.Subscribe(timeout(t), "slow", client.SubscribeOptions{QoS: 1}, func(client.Message) { <-block }); err != nil {
+		t.Fatal(err)
+	}
+
+	var fast = subscribe(t, c, "fast/+", client.SubscribeOptions{QoS: 1})
+	// --
+
+	var conn = m.NextConn()
+
+	conn.Send(&mqttcodec.Publish{TopicName: "slow", QoS: 1, PacketID: 1})
+
+	for i := 0; i < 20; i++ {
+		conn.Send(&mqttcodec.Publish{TopicName: fmt.Sprintf("fast/%02d", i), QoS: 1, PacketID: uint16(i + 2)})
+	}
+
+	// -- the other filter's messages are handled in order meanwhile, and the read loop goes on acknowledging
+	for i := 0; i < 20; i++ {
+		if msg := next(t, fast); msg.Topic != fmt.Sprintf("fast/%02d", i) {
+			t.Fatalf("message %d is %s", i, msg.Topic)
+		}
+	}
+
+	for i := 0; i < 21; i++ {
+		m.Expect(mqttcodec.PUBACK)
+	}
+	// --
+}
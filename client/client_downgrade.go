@@ -0,0 +1,51 @@
+package client
+
+import (
+	"errors"
+
+	"github.com/MarcusOuelletus/demo/modules/logger"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+)
+
+/*
+With FallbackTo311 an MQTT 5 client also connects to a broker that only speaks 3.1.1. Such a broker refuses the MQTT 5
+CONNECT with Unacceptable protocol version (0x01, or Unsupported Protocol Version 0x84 from an MQTT 5 broker that has
+the version turned off), mqttcodec.ShouldDowngrade tells, and the client sends the CONNECT again at once in 3.1.1. It
+stays on 3.1.1 from then on, every reconnect uses it too.
+
+A downgraded connection sends none of what only MQTT 5 has: the CONNECT and will properties (the Will Delay
+Interval...), the User Properties and the Receive Maximum are left out, and Request fails with ErrRequestNeedsMQTT5.
+*/
+
+// version is the protocol version of the next CONNECT, 3.1.1 once the client fell back to it.
+func (c *Client) version() mqttcodec.ProtocolVersion {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.downgraded {
+		return mqttcodec.Version311
+	}
+
+	return c.options.version()
+}
+
+// downgrade switches the client to 3.1.1 when err is a CONNACK refusing its MQTT 5 CONNECT for the protocol version.
+func (c *Client) downgrade(err error) bool {
+	var e *ConnackError
+
+	if !c.options.FallbackTo311 || !errors.As(err, &e) || !mqttcodec.ShouldDowngrade(&mqttcodec.Connack{ReturnCode: e.ReturnCode}) {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.downgraded {
+		return false
+	}
+
+	c.downgraded = true
+	c.log.Info("client: the broker refused MQTT 5, falling back to 3.1.1", logger.Fields{"return_code": e.ReturnCode})
+
+	return true
+}
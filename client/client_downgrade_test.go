@@ -0,0 +1,81 @@
+package client_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/client"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/mqtttest"
+	"github.com/MarcusOuelletus/demo/reasoncodes"
+)
+
+// only311 refuses MQTT 5 CONNECTs with Unacceptable protocol version, like a broker from before MQTT 5.
+func only311(c *mqtttest.Conn, p mqttcodec.Packet) mqtttest.Response {
+	if c.Version == mqttcodec.Version5 {
+		return mqtttest.Refuse(reasoncodes.Code(mqttcodec.ConnackUnacceptableProtocol))(c, p)
+	}
+
+	return mqtttest.Default(c, p)
+}
+
+func TestFallbackTo311(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+	var reconnected = make(chan bool, 1)
+
+	m.Handle(mqttcodec.CONNECT, only311)
+
+	var c = connect(t, m, func(o *client.ClientOptions) {
+		o.ProtocolVersion = mqttcodec.Version5
+		o.FallbackTo311 = true
+		o.UserProperties = []client.KeyValue{{Key: "a", Value: "b"}}
+		o.AutoReconnect = true
+		o.InitialReconnectDelay = 10 * time.Millisecond
+		o.OnReconnected = func(sessionPresent bool) { reconnected <- sessionPresent }
+	})
+
+	// -- the refused MQTT 5 CONNECT is sent again at once in 3.1.1, without the properties
+	var first = m.Expect(mqttcodec.CONNECT).Packet.(*mqttcodec.Connect)
+	var second = m.Expect(mqttcodec.CONNECT).Packet.(*mqttcodec.Connect)
+
+	if first.ProtocolLevel != 5 || second.ProtocolLevel != 4 || second.Properties != nil {
+		t.Fatalf("CONNECT at level %d, then %d with %+v", first.ProtocolLevel, second.ProtocolLevel, second.Properties)
+	}
+
+	if _, err := c.Request(timeout(t), "service", nil, client.RequestOptions{}); !errors.Is(err, client.ErrRequestNeedsMQTT5) {
+		t.Fatalf("request after the fallback: %v", err)
+	}
+	// --
+
+	// -- the reconnect stays on 3.1.1, the first connection is the refused one
+	m.NextConn()
+	m.NextConn().Close()
+	next(t, reconnected)
+
+	if p := m.Expect(mqttcodec.CONNECT).Packet.(*mqttcodec.Connect); p.ProtocolLevel != 4 {
+		t.Fatalf("reconnected at level %d", p.ProtocolLevel)
+	}
+	// --
+}
+
+func TestNoFallbackTo311(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+
+	m.Handle(mqttcodec.CONNECT, only311)
+
+	// -- without FallbackTo311 the refusal stands, an MQTT 5 client has it as Unsupported Protocol Version
+	var options = m.Options("")
+
+	options.ProtocolVersion = mqttcodec.Version5
+
+	var refused *client.ConnackError
+
+	if err := client.New(options).Connect(); !errors.As(err, &refused) || refused.ReturnCode != byte(reasoncodes.UnsupportedProtocolVersion) {
+		t.Fatalf("connect: %v", err)
+	}
+
+	m.Expect(mqttcodec.CONNECT)
+	m.ExpectNone(mqttcodec.CONNECT, 100*time.Millisecond)
+	// --
+}
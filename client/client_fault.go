@@ -0,0 +1,176 @@
+package client
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+/*
+Kill and the FaultInjector are there for integration tests of what happens when a connection dies badly, the will
+message and session expiry in particular, without having to kill a process or unplug a cable.
+
+Kill ends the connection like a crashing process would: no DISCONNECT, the TCP socket is reset, so the broker treats
+it as a network failure and publishes the will. Like Disconnect it stops AutoReconnect.
+
+A FaultInjector is set as ClientOptions.WrapConn and wraps every connection the client dials, its methods act on the
+latest one. Reset aborts the socket the same way Kill does but leaves the client to notice on its own, so
+OnConnectionLost and AutoReconnect run. HalfClose shuts the write side only: the broker reads EOF while the client
+still reads, which is how a NAT timeout or a dying peer often looks. DelayReads and DelayWrites hold every read from and
+write to the connection back by a fixed time (the client writes each packet in one write), enough to make a keepalive
+or an acknowledgement miss its deadline. The faults reach the socket below TLS and WebSocket.
+*/
+
+var (
+	ErrKilled           = errors.New("connection killed")
+	ErrNoFaultConn      = errors.New("fault injector has no connection")
+	ErrFaultUnsupported = errors.New("connection does not support the fault")
+)
+
+type FaultInjector struct {
+	sync.Mutex
+	conn       *faultConn
+	readDelay  time.Duration
+	writeDelay time.Duration
+}
+
+type faultConn struct {
+	net.Conn
+	injector *FaultInjector
+}
+
+// Kill resets the connection without sending DISCONNECT and stops a reconnect in progress.
+func (c *Client) Kill() error {
+	var n = c.detach()
+
+	if n == nil {
+		return ErrNotConnected
+	}
+
+	abort(n.conn)
+	n.close(ErrKilled)
+
+	return nil
+}
+
+// abort makes closing conn send a TCP reset instead of a FIN.
+func abort(conn net.Conn) {
+	if tcp, ok := underlying(conn).(*net.TCPConn); ok {
+		tcp.SetLinger(0)
+	}
+}
+
+// underlying returns the network connection below the client's own wrappers, TLS and WebSocket.
+func underlying(conn net.Conn) net.Conn {
+	for {
+		switch n := conn.(type) {
+		case *countingConn:
+			conn = n.Conn
+		case *faultConn:
+			conn = n.Conn
+		case *proxyConn:
+			conn = n.Conn
+		case interface{ NetConn() net.Conn }:
+			conn = n.NetConn()
+		default:
+			return conn
+		}
+	}
+}
+
+func NewFaultInjector() *FaultInjector {
+	return &FaultInjector{}
+}
+
+// Wrap is the ClientOptions.WrapConn of the injector.
+func (f *FaultInjector) Wrap(conn net.Conn) net.Conn {
+	var n = &faultConn{Conn: conn, injector: f}
+
+	f.Lock()
+	f.conn = n
+	f.Unlock()
+
+	return n
+}
+
+// Reset aborts the latest connection with a TCP reset.
+func (f *FaultInjector) Reset() error {
+	conn, err := f.current()
+
+	if err != nil {
+		return err
+	}
+
+	abort(conn)
+
+	return conn.Close()
+}
+
+// HalfClose shuts down the write side of the latest connection.
+func (f *FaultInjector) HalfClose() error {
+	conn, err := f.current()
+
+	if err != nil {
+		return err
+	}
+
+	closer, ok := underlying(conn).(interface{ CloseWrite() error })
+
+	if !ok {
+		return ErrFaultUnsupported
+	}
+
+	return closer.CloseWrite()
+}
+
+// DelayReads holds back every read by d, 0 stops delaying.
+func (f *FaultInjector) DelayReads(d time.Duration) {
+	f.Lock()
+	f.readDelay = d
+	f.Unlock()
+}
+
+// DelayWrites holds back every write by d, 0 stops delaying.
+func (f *FaultInjector) DelayWrites(d time.Duration) {
+	f.Lock()
+	f.writeDelay = d
+	f.Unlock()
+}
+
+func (f *FaultInjector) current() (*faultConn, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.conn == nil {
+		return nil, ErrNoFaultConn
+	}
+
+	return f.conn, nil
+}
+
+func (f *FaultInjector) delays() (time.Duration, time.Duration) {
+	f.Lock()
+	defer f.Unlock()
+
+	return f.readDelay, f.writeDelay
+}
+
+// Read delays the bytes once they arrived, so they are held back rather than the wait for them made longer.
+func (n *faultConn) Read(b []byte) (int, error) {
+	read, err := n.Conn.Read(b)
+
+	if delay, _ := n.injector.delays(); delay > 0 {
+		time.Sleep(delay)
+	}
+
+	return read, err
+}
+
+func (n *faultConn) Write(b []byte) (int, error) {
+	if _, delay := n.injector.delays(); delay > 0 {
+		time.Sleep(delay)
+	}
+
+	return n.Conn.Write(b)
+}
@@ -0,0 +1,106 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/broker"
+	"github.com/MarcusOuelletus/demo/client"
+	"github.com/MarcusOuelletus/demo/mqtttest"
+)
+
+func TestKillPublishesWill(t *testing.T) {
+	var address = listen(t, "tcp", "127.0.0.1:0", broker.ListenerConfig{})
+
+	var watcher = client.New(client.ClientOptions{Broker: address, CleanSession: true})
+
+	if err := watcher.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	defer watcher.Disconnect()
+
+	var wills = subscribe(t, watcher, "wills/+", client.SubscribeOptions{QoS: 1})
+
+	var victim = func(id string) *client.Client {
+		var c = client.New(client.ClientOptions{
+			Broker:       address,
+			ClientID:     id,
+			CleanSession: true,
+			Will:         &client.WillOptions{Topic: "wills/" + id, Payload: []byte("gone"), QoS: 1},
+		})
+
+		if err := c.Connect(); err != nil {
+			t.Fatal(err)
+		}
+
+		return c
+	}
+
+	// -- Disconnect ends the connection normally, the will is only published for the killed one
+	if err := victim("disconnected").Disconnect(); err != nil {
+		t.Fatal(err)
+	}
+
+	var killed = victim("killed")
+
+	if err := killed.Kill(); err != nil {
+		t.Fatal(err)
+	}
+
+	if m := next(t, wills); m.Topic != "wills/killed" || string(m.Payload) != "gone" {
+		t.Fatalf("got %q on %s", m.Payload, m.Topic)
+	}
+
+	if killed.IsConnected() {
+		t.Fatal("connected after Kill")
+	}
+	// --
+}
+
+func TestFaultInjector(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+	var faults = client.NewFaultInjector()
+	var lost, reconnected = make(chan error, 1), make(chan bool, 1)
+
+	var c = connect(t, m, func(o *client.ClientOptions) {
+		o.WrapConn = faults.Wrap
+		o.AutoReconnect = true
+		o.InitialReconnectDelay = 10 * time.Millisecond
+		o.OnConnectionLost = func(err error) { lost <- err }
+		o.OnReconnected = func(bool) { reconnected <- true }
+	})
+
+	// -- a reset is noticed by the client itself, it reconnects
+	if err := faults.Reset(); err != nil {
+		t.Fatal(err)
+	}
+
+	next(t, lost)
+	next(t, reconnected)
+	// --
+
+	// -- delayed writes make the PUBACK miss its deadline
+	faults.DelayWrites(200 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := c.PublishQoS1(ctx, "a", nil, client.PublishOptions{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("publish with delayed writes: %v", err)
+	}
+
+	faults.DelayWrites(0)
+	// --
+
+	// -- after a half close the broker reads EOF and drops the connection
+	if err := faults.HalfClose(); err != nil {
+		t.Fatal(err)
+	}
+
+	next(t, lost)
+	next(t, reconnected)
+	// --
+}
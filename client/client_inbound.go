@@ -0,0 +1,104 @@
+package client
+
+import (
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/session"
+)
+
+/*
+UseInbound puts a middleware in front of every handler: each inbound PUBLISH goes through the middleware once, in the
+order they were added, before it is routed to the subscriptions it matches. A middleware returns the message to pass
+on, changed or not, the next one and then the handlers see what it returned, so it can decompress or decrypt the
+payload, count or log what arrives, or return false to drop the message. A dropped message is still acknowledged, the
+broker does not send it again, and counts as dropped in the Metrics like one no subscription matched.
+
+The message is routed by the Topic the last middleware returned. Its PayloadFormat, ContentType and UserProperties
+replace those of Properties on the way to the handlers, Properties itself is copied first, never changed in place.
+The chain runs on the read loop (or the inbound limiter with InboundRate), before HandlerWorkers takes over, so a slow
+middleware holds up the connection like a slow handler without workers does. A QoS 2 message sent again by the
+broker is not routed a second time and does not go through the chain again either.
+*/
+
+// InboundMiddleware sees every inbound message before the handlers, false drops it.
+type InboundMiddleware func(msg Message) (Message, bool)
+
+// UseInbound adds middleware to the end of the chain, it applies from the next message on.
+func (c *Client) UseInbound(middleware InboundMiddleware) {
+	if middleware == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// -- the chain is run after the lock is released, a new slice keeps a running one from seeing a half appended one
+	var chain = make([]InboundMiddleware, len(c.middleware), len(c.middleware)+1)
+	copy(chain, c.middleware)
+	c.middleware = append(chain, middleware)
+	// --
+}
+
+// intercept runs msg through the middleware, it returns the message to route and false when one dropped it.
+func (c *Client) intercept(msg *session.InboundMessage) (*session.InboundMessage, bool) {
+	c.mu.Lock()
+	var chain = c.middleware
+	c.mu.Unlock()
+
+	if len(chain) == 0 {
+		return msg, true
+	}
+
+	var m = messageOf(msg)
+	var ok bool
+
+	for _, middleware := range chain {
+		if m, ok = middleware(m); !ok {
+			return nil, false
+		}
+	}
+
+	return inboundMessage(m), true
+}
+
+// inboundMessage is messageOf the other way around, the fields of m that are properties are put into a copy of them.
+func inboundMessage(m Message) *session.InboundMessage {
+	var properties = m.Properties
+
+	if properties != nil || m.PayloadFormat != PayloadUnspecified || m.ContentType != "" || len(m.UserProperties) > 0 {
+		var p mqttcodec.Properties
+
+		if properties != nil {
+			p = *properties
+		}
+
+		p.ContentType, p.UserProperties = m.ContentType, m.UserProperties
+
+		// -- an indicator of 0 that came over the wire stays, Message cannot tell it from none
+		var format = PayloadUnspecified
+
+		if p.PayloadFormatIndicator != nil {
+			format = PayloadFormat(*p.PayloadFormatIndicator)
+		}
+
+		if format != m.PayloadFormat {
+			p.PayloadFormatIndicator = nil
+
+			if m.PayloadFormat != PayloadUnspecified {
+				p.PayloadFormatIndicator = mqttcodec.Byte(byte(m.PayloadFormat))
+			}
+		}
+		// --
+
+		properties = &p
+	}
+
+	return &session.InboundMessage{
+		Topic:      m.Topic,
+		Payload:    m.Payload,
+		QoS:        m.QoS,
+		Retain:     m.Retain,
+		Dup:        m.Dup,
+		PacketID:   m.PacketID,
+		Properties: properties,
+	}
+}
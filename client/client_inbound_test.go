@@ -0,0 +1,64 @@
+package client_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/MarcusOuelletus/demo/client"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/mqtttest"
+)
+
+func TestUseInbound(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+	var c = connect(t, m, func(o *client.ClientOptions) { o.ProtocolVersion = mqttcodec.Version5 })
+	var order []string
+
+	c.UseInbound(func(msg client.Message) (client.Message, bool) {
+		order = append(order, "first")
+
+		if string(msg.Payload) == "drop" {
+			return msg, false
+		}
+
+		msg.Payload = bytes.ToUpper(msg.Payload)
+		msg.ContentType = "text/plain"
+		msg.UserProperties = append(msg.UserProperties, client.KeyValue{Key: "k", Value: "v"})
+
+		return msg, true
+	})
+
+	c.UseInbound(func(msg client.Message) (client.Message, bool) {
+		order = append(order, "second")
+		msg.Topic = "b"
+
+		return msg, true
+	})
+
+	var messages = subscribe(t, c, "b", client.SubscribeOptions{QoS: 1})
+	var conn = m.NextConn()
+
+	// -- a dropped message is acknowledged, it skips the rest of the chain and counts as dropped
+	conn.Send(&mqttcodec.Publish{TopicName: "a", Payload: []byte("drop"), QoS: 1, PacketID: 1})
+	m.Expect(mqttcodec.PUBACK)
+	// --
+
+	// -- the handlers get what the last middleware returned, routed by its Topic
+	conn.Send(&mqttcodec.Publish{TopicName: "a", Payload: []byte("hi"), QoS: 1, PacketID: 2})
+
+	var msg = next(t, messages)
+
+	if string(msg.Payload) != "HI" || msg.Topic != "b" || msg.ContentType != "text/plain" || msg.Properties.ContentType != "text/plain" || len(msg.UserProperties) != 1 {
+		t.Fatalf("got %q on %s with %+v", msg.Payload, msg.Topic, msg.Properties)
+	}
+
+	if !reflect.DeepEqual(order, []string{"first", "first", "second"}) {
+		t.Fatalf("ran %v", order)
+	}
+
+	if dropped := c.Metrics().Dropped; dropped != 1 {
+		t.Fatalf("%d dropped", dropped)
+	}
+	// --
+}
@@ -0,0 +1,160 @@
+package client
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+Metrics is a snapshot of the client's counters for whatever monitoring the application uses, the client imports none:
+an expvar.Func returning c.Metrics() or a Prometheus collector reading it on scrape is all the wiring needed. The
+counters only ever grow and start at 0 with New, the gauges (InFlight, InboundQueued) are read when the snapshot is
+taken.
+
+Bytes are counted on the network connection, below TLS and WebSocket framing they are what went over the wire. A
+message is counted as published when its PUBLISH was first written, resends with DUP are not counted again. The ack
+latencies run from writing the packet that asks for the acknowledgement to the read loop receiving it, for a QoS 2
+flow PUBREC is timed from the PUBLISH and PUBCOMP from the PUBREL. Dropped counts inbound messages no handler saw: no
+subscription matched or, with InboundRate, a QoS 0 message found the inbound queue full.
+*/
+
+type Metrics struct {
+	Connects        uint64
+	Reconnects      uint64
+	ConnectionsLost uint64
+	// Published and Received are indexed by QoS.
+	Published     [3]uint64
+	Received      [3]uint64
+	Dropped       uint64
+	BytesIn       uint64
+	BytesOut      uint64
+	InFlight      int
+	InboundQueued int
+
+	PubackLatency  LatencyStats
+	PubrecLatency  LatencyStats
+	PubcompLatency LatencyStats
+}
+
+// LatencyStats summarizes the latencies of one kind of acknowledgement.
+type LatencyStats struct {
+	Count uint64
+	Total time.Duration
+	Min   time.Duration
+	Max   time.Duration
+}
+
+// clientMetrics keeps the counters, it is allocated on its own with the uint64 fields first so the atomics find them
+// 64-bit aligned.
+type clientMetrics struct {
+	connects        uint64
+	reconnects      uint64
+	connectionsLost uint64
+	published       [3]uint64
+	received        [3]uint64
+	dropped         uint64
+	bytesIn         uint64
+	bytesOut        uint64
+
+	mu      sync.Mutex
+	puback  LatencyStats
+	pubrec  LatencyStats
+	pubcomp LatencyStats
+}
+
+// countingConn counts the bytes read from and written to the connection it wraps.
+type countingConn struct {
+	net.Conn
+	metrics *clientMetrics
+}
+
+// Mean is the average latency, 0 before the first acknowledgement.
+func (s LatencyStats) Mean() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+
+	return s.Total / time.Duration(s.Count)
+}
+
+func (s *LatencyStats) observe(d time.Duration) {
+	if s.Count == 0 || d < s.Min {
+		s.Min = d
+	}
+
+	if d > s.Max {
+		s.Max = d
+	}
+
+	s.Count++
+	s.Total += d
+}
+
+// Metrics returns a snapshot of the client's counters.
+func (c *Client) Metrics() Metrics {
+	var m = c.metrics
+
+	var snapshot = Metrics{
+		Connects:        atomic.LoadUint64(&m.connects),
+		Reconnects:      atomic.LoadUint64(&m.reconnects),
+		ConnectionsLost: atomic.LoadUint64(&m.connectionsLost),
+		Dropped:         atomic.LoadUint64(&m.dropped),
+		BytesIn:         atomic.LoadUint64(&m.bytesIn),
+		BytesOut:        atomic.LoadUint64(&m.bytesOut),
+		InFlight:        int(c.flow.InFlight()),
+	}
+
+	for qos := range snapshot.Published {
+		snapshot.Published[qos] = atomic.LoadUint64(&m.published[qos])
+		snapshot.Received[qos] = atomic.LoadUint64(&m.received[qos])
+	}
+
+	m.mu.Lock()
+	snapshot.PubackLatency, snapshot.PubrecLatency, snapshot.PubcompLatency = m.puback, m.pubrec, m.pubcomp
+	m.mu.Unlock()
+
+	if n, err := c.current(); err == nil && n.inbound != nil {
+		snapshot.InboundQueued = len(n.inbound.queue)
+	}
+
+	return snapshot
+}
+
+func (m *clientMetrics) count(conn net.Conn) net.Conn {
+	return &countingConn{Conn: conn, metrics: m}
+}
+
+func (m *clientMetrics) publish(qos byte) {
+	atomic.AddUint64(&m.published[qos], 1)
+}
+
+func (m *clientMetrics) receive(qos byte) {
+	atomic.AddUint64(&m.received[qos], 1)
+}
+
+func (m *clientMetrics) drop() {
+	atomic.AddUint64(&m.dropped, 1)
+}
+
+// acknowledged records d, the latency of an acknowledgement.
+func (m *clientMetrics) acknowledged(stats *LatencyStats, d time.Duration) {
+	m.mu.Lock()
+	stats.observe(d)
+	m.mu.Unlock()
+}
+
+func (n *countingConn) Read(b []byte) (int, error) {
+	read, err := n.Conn.Read(b)
+	atomic.AddUint64(&n.metrics.bytesIn, uint64(read))
+
+	return read, err
+}
+
+func (n *countingConn) Write(b []byte) (int, error) {
+	written, err := n.Conn.Write(b)
+	atomic.AddUint64(&n.metrics.bytesOut, uint64(written))
+
+	return written, err
+}
@@ -0,0 +1,70 @@
+package client_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/client"
+	"github.com/MarcusOuelletus/demo/clock"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/mqtttest"
+)
+
+func TestMetrics(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+	var fake = clock.NewFake(time.Unix(0, 0))
+	var c = connect(t, m, func(o *client.ClientOptions) { o.Clock = fake })
+
+	var messages = subscribe(t, c, "a", client.SubscribeOptions{QoS: 2})
+	var conn = m.NextConn()
+
+	// -- the PUBACK comes 250ms after the PUBLISH on the client's clock
+	m.Handle(mqttcodec.PUBLISH, mqtttest.Script(mqtttest.Drop))
+
+	var published = make(chan error, 1)
+
+	go func() {
+		_, err := c.PublishQoS1(timeout(t), "b", nil, client.PublishOptions{})
+		published <- err
+	}()
+
+	var p = m.Expect(mqttcodec.PUBLISH).Packet.(*mqttcodec.Publish)
+
+	fake.Advance(250 * time.Millisecond)
+	conn.Send(mqttcodec.NewPuback(p.PacketID))
+
+	if err := next(t, published); err != nil {
+		t.Fatal(err)
+	}
+	// --
+
+	if err := c.Publish(timeout(t), "b", nil, client.PublishOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.PublishQoS2(timeout(t), "b", nil, client.PublishOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.Send(&mqttcodec.Publish{TopicName: "a"}, &mqttcodec.Publish{TopicName: "a", QoS: 2, PacketID: 1})
+	next(t, messages)
+	next(t, messages)
+
+	var metrics = c.Metrics()
+
+	if metrics.Connects != 1 || metrics.Published != [3]uint64{1, 1, 1} || metrics.Received != [3]uint64{1, 0, 1} {
+		t.Errorf("connects %d, published %v, received %v", metrics.Connects, metrics.Published, metrics.Received)
+	}
+
+	if l := metrics.PubackLatency; l.Count != 1 || l.Min != 250*time.Millisecond || l.Mean() != 250*time.Millisecond {
+		t.Errorf("PUBACK latency %+v", l)
+	}
+
+	if metrics.PubrecLatency.Count != 1 || metrics.PubcompLatency.Count != 1 {
+		t.Errorf("PUBREC latency %+v, PUBCOMP latency %+v", metrics.PubrecLatency, metrics.PubcompLatency)
+	}
+
+	if metrics.BytesIn == 0 || metrics.BytesOut == 0 || metrics.InFlight != 0 {
+		t.Errorf("%d bytes in, %d bytes out, %d in flight", metrics.BytesIn, metrics.BytesOut, metrics.InFlight)
+	}
+}
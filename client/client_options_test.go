@@ -0,0 +1,89 @@
+package client_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/client"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/mqtttest"
+)
+
+func TestValidate(t *testing.T) {
+	var tests = []struct {
+		name    string
+		options client.ClientOptions
+		valid   bool
+	}{
+		{"defaults", client.NewClientOptions("tcp://example.com"), true},
+		{"bare host and port", client.ClientOptions{Broker: "example.com:1883", ClientID: "c"}, true},
+		{"no broker", client.ClientOptions{ClientID: "c"}, false},
+		{"unknown scheme", client.ClientOptions{Broker: "foo://example.com", ClientID: "c"}, false},
+		{"TLS on tcp", client.ClientOptions{Broker: "tcp://example.com", ClientID: "c", TLS: &client.TLSOptions{}}, false},
+		{"TLS on ws", client.ClientOptions{Broker: "ws://example.com/mqtt", ClientID: "c", TLS: &client.TLSOptions{}}, false},
+		{"WebSocket on tls", client.ClientOptions{Broker: "tls://example.com", ClientID: "c", WebSocket: &client.WebSocketOptions{}}, false},
+		{"empty client id", client.ClientOptions{Broker: "example.com:1883"}, false},
+		{"empty client id, clean session", client.ClientOptions{Broker: "example.com:1883", CleanSession: true}, true},
+		{"credentials twice", client.ClientOptions{Broker: "tcp://u:p@example.com", ClientID: "c", Username: "v"}, false},
+		{"password without username", client.ClientOptions{Broker: "example.com:1883", ClientID: "c", Password: []byte("p")}, false},
+		{"password without username on MQTT 5", client.ClientOptions{Broker: "example.com:1883", ClientID: "c", Password: []byte("p"), ProtocolVersion: mqttcodec.Version5}, true},
+		{"protocol version 3", client.ClientOptions{Broker: "example.com:1883", ClientID: "c", ProtocolVersion: 3}, false},
+		{"connect properties on 3.1.1", client.ClientOptions{Broker: "example.com:1883", ClientID: "c", ConnectProperties: &mqttcodec.Properties{}}, false},
+		{"will with a wildcard", client.ClientOptions{Broker: "example.com:1883", ClientID: "c", Will: &client.WillOptions{Topic: "a/#"}}, false},
+		{"will with qos 3", client.ClientOptions{Broker: "example.com:1883", ClientID: "c", Will: &client.WillOptions{Topic: "a", QoS: 3}}, false},
+		{"negative timeout", client.ClientOptions{Broker: "example.com:1883", ClientID: "c", ConnectTimeout: -time.Second}, false},
+		{"initial delay above the max", client.ClientOptions{Broker: "example.com:1883", ClientID: "c", InitialReconnectDelay: time.Minute, MaxReconnectDelay: time.Second}, false},
+	}
+
+	for _, test := range tests {
+		var err = test.options.Validate()
+
+		if test.valid && err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+
+		if !test.valid && !errors.Is(err, client.ErrInvalidOptions) {
+			t.Errorf("%s: validated with %v", test.name, err)
+		}
+	}
+}
+
+func TestConnectOptions(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+
+	// -- the credentials of the broker URL, the connect properties and the will go out with the CONNECT
+	var options = client.NewClientOptions("tcp://user:secret@" + strings.TrimPrefix(m.Address(), "tcp://"))
+
+	options.ProtocolVersion = mqttcodec.Version5
+	options.ConnectProperties = &mqttcodec.Properties{SessionExpiryInterval: mqttcodec.Uint32(10)}
+	options.Will = &client.WillOptions{Topic: "will", Payload: []byte("bye"), QoS: 1, Properties: &mqttcodec.Properties{WillDelayInterval: mqttcodec.Uint32(3)}}
+
+	var c = client.New(options)
+
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Disconnect()
+
+	var connect = m.NextConn().Connect
+
+	if connect.Username != "user" || string(connect.Password) != "secret" || connect.ClientID != options.ClientID {
+		t.Errorf("connected as %s:%s with client id %s", connect.Username, connect.Password, connect.ClientID)
+	}
+
+	if p := connect.Properties; p == nil || p.SessionExpiryInterval == nil || *p.SessionExpiryInterval != 10 {
+		t.Errorf("connect properties %+v", p)
+	}
+
+	if !connect.WillFlag || connect.WillTopic != "will" || string(connect.WillMessage) != "bye" || connect.WillQoS != 1 {
+		t.Errorf("will %s %q with qos %d", connect.WillTopic, connect.WillMessage, connect.WillQoS)
+	}
+
+	if p := connect.WillProperties; p == nil || p.WillDelayInterval == nil || *p.WillDelayInterval != 3 {
+		t.Errorf("will properties %+v", p)
+	}
+	// --
+}
@@ -0,0 +1,66 @@
+package client
+
+import (
+	"context"
+)
+
+/*
+UseOutbound is UseInbound for the publishes the application makes: every Publish, PublishQoS1, PublishQoS2 and Request
+goes through the interceptors in the order they were added, before anything is checked, stored or encoded. An
+interceptor returns the message to send, changed or not, the next one sees what it returned, so it can stamp a trace id
+from ctx into the User Properties, compress the payload or rewrite the topic. An interceptor that returns an error
+vetoes the publish, the caller gets that error as it is and nothing is sent. ValidatePayloadFormat checks the payload
+the last interceptor returned.
+
+QoS is what the message goes out with: the one of the options for Publish, 1 and 2 for PublishQoS1 and PublishQoS2,
+which ignore a change of it. A QoS 1 or 2 message that is resumed from the Store after a reconnect or a restart went
+through the chain when it was published and does not again.
+*/
+
+// OutboundMessage is a publish on its way out.
+type OutboundMessage struct {
+	Topic   string
+	Payload []byte
+	PublishOptions
+}
+
+// OutboundInterceptor sees every publish before it is sent, an error vetoes it.
+type OutboundInterceptor func(ctx context.Context, msg OutboundMessage) (OutboundMessage, error)
+
+// UseOutbound adds interceptor to the end of the chain, it applies from the next publish on.
+func (c *Client) UseOutbound(interceptor OutboundInterceptor) {
+	if interceptor == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// -- a new slice, like the inbound chain
+	var chain = make([]OutboundInterceptor, len(c.interceptors), len(c.interceptors)+1)
+	copy(chain, c.interceptors)
+	c.interceptors = append(chain, interceptor)
+	// --
+}
+
+// outbound runs a publish through the interceptors, then checks the payload it ended up with.
+func (c *Client) outbound(ctx context.Context, topic string, payload []byte, opts PublishOptions) (string, []byte, PublishOptions, error) {
+	c.mu.Lock()
+	var chain = c.interceptors
+	c.mu.Unlock()
+
+	var msg = OutboundMessage{Topic: topic, Payload: payload, PublishOptions: opts}
+	var err error
+
+	for _, interceptor := range chain {
+		if msg, err = interceptor(ctx, msg); err != nil {
+			return "", nil, PublishOptions{}, err
+		}
+	}
+
+	if err = c.checkPayload(msg.PayloadFormat, msg.Payload); err != nil {
+		return "", nil, PublishOptions{}, err
+	}
+
+	return msg.Topic, msg.Payload, msg.PublishOptions, nil
+}
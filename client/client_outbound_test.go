@@ -0,0 +1,81 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/MarcusOuelletus/demo/client"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/mqtttest"
+)
+
+type traceKey struct{}
+
+func TestUseOutbound(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+
+	var c = connect(t, m, func(o *client.ClientOptions) {
+		o.ProtocolVersion = mqttcodec.Version5
+		o.ValidatePayloadFormat = true
+	})
+
+	var vetoed = errors.New("forbidden topic")
+	var qos []byte
+
+	c.UseOutbound(func(ctx context.Context, msg client.OutboundMessage) (client.OutboundMessage, error) {
+		qos = append(qos, msg.QoS)
+
+		if msg.Topic == "forbidden" {
+			return msg, vetoed
+		}
+
+		if id, ok := ctx.Value(traceKey{}).(string); ok {
+			msg.UserProperties = append(msg.UserProperties, client.KeyValue{Key: "trace", Value: id})
+		}
+
+		msg.Topic = "app/" + msg.Topic
+		msg.QoS = 0
+
+		return msg, nil
+	})
+
+	c.UseOutbound(func(ctx context.Context, msg client.OutboundMessage) (client.OutboundMessage, error) {
+		if string(msg.Payload) == "binary" {
+			msg.Payload, msg.PayloadFormat = []byte{0xff}, client.PayloadUTF8
+		}
+
+		return msg, nil
+	})
+
+	var ctx = context.WithValue(timeout(t), traceKey{}, "t1")
+
+	// -- a veto is the publish's error as it is
+	if err := c.Publish(ctx, "forbidden", nil, client.PublishOptions{}); err != vetoed {
+		t.Fatalf("publish: %v", err)
+	}
+	// --
+
+	// -- the PUBLISH is what the chain returned, at the QoS of PublishQoS1 all the same
+	if _, err := c.PublishQoS1(ctx, "a", []byte("x"), client.PublishOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var p = m.Expect(mqttcodec.PUBLISH).Packet.(*mqttcodec.Publish)
+
+	if p.TopicName != "app/a" || p.QoS != 1 || !reflect.DeepEqual(p.Properties.UserProperties, []mqttcodec.UserProperty{{Key: "trace", Value: "t1"}}) {
+		t.Fatalf("published %s with qos %d and %+v", p.TopicName, p.QoS, p.Properties)
+	}
+	// --
+
+	// -- ValidatePayloadFormat checks the payload the last interceptor returned
+	if err := c.Publish(ctx, "b", []byte("binary"), client.PublishOptions{QoS: 2}); !errors.Is(err, client.ErrPayloadFormatInvalid) {
+		t.Fatalf("publish: %v", err)
+	}
+	// --
+
+	if !reflect.DeepEqual(qos, []byte{0, 1, 2}) {
+		t.Fatalf("the chain saw qos %v", qos)
+	}
+}
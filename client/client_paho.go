@@ -0,0 +1,241 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/clock"
+)
+
+/*
+PahoClient has the method set of the Client interface of github.com/eclipse/paho.mqtt.golang, NewPahoClient implements
+it on top of Client so an application written against paho moves over by swapping the import and the construction of
+its client: mqtt.NewClient(opts) becomes client.NewPahoClient(options), and mqtt.Token, mqtt.Message and
+mqtt.MessageHandler become Token, PahoMessage and PahoMessageHandler. Nothing from paho is imported.
+
+The differences that remain:
+
+ - Every call that returns a Token runs in its own goroutine, Wait blocks until it is done like with paho.
+ - Message.Ack does nothing, messages are acknowledged once their handler was handed the message.
+ - AddRoute only routes messages of a filter that is (or later gets) subscribed through the adapter, a message for a
+   subscription the broker kept from a previous session is not routed until it is subscribed again.
+ - OptionsReader returns a copy of the ClientOptions rather than paho's ClientOptionsReader.
+ - Disconnect(quiesce) waits up to quiesce milliseconds for the outbound QoS 1 and 2 flows in flight to end.
+*/
+
+// PahoClient is the method set of paho's Client interface.
+type PahoClient interface {
+	IsConnected() bool
+	IsConnectionOpen() bool
+	Connect() Token
+	Disconnect(quiesce uint)
+	Publish(topic string, qos byte, retained bool, payload interface{}) Token
+	Subscribe(topic string, qos byte, callback PahoMessageHandler) Token
+	SubscribeMultiple(filters map[string]byte, callback PahoMessageHandler) Token
+	Unsubscribe(topics ...string) Token
+	AddRoute(topic string, callback PahoMessageHandler)
+	OptionsReader() ClientOptions
+}
+
+// Token is paho's Token, the outcome of an operation that completes in the background.
+type Token interface {
+	Wait() bool
+	WaitTimeout(time.Duration) bool
+	Done() <-chan struct{}
+	Error() error
+}
+
+// PahoMessage is paho's Message.
+type PahoMessage interface {
+	Duplicate() bool
+	Qos() byte
+	Retained() bool
+	Topic() string
+	MessageID() uint16
+	Payload() []byte
+	Ack()
+}
+
+type PahoMessageHandler func(PahoClient, PahoMessage)
+
+type pahoClient struct {
+	client *Client
+
+	mu     sync.Mutex
+	routes map[string]PahoMessageHandler
+}
+
+type token struct {
+	clock clock.Clock
+	done  chan struct{}
+	err   error
+}
+
+type pahoMessage struct {
+	msg Message
+}
+
+// NewPahoClient returns a PahoClient backed by a Client with options.
+func NewPahoClient(options ClientOptions) PahoClient {
+	return &pahoClient{client: New(options), routes: make(map[string]PahoMessageHandler)}
+}
+
+// newToken runs f in the background, the token is done when f returned.
+func (p *pahoClient) newToken(f func() error) *token {
+	var t = &token{clock: p.client.options.clock(), done: make(chan struct{})}
+
+	go func() {
+		t.err = f()
+		close(t.done)
+	}()
+
+	return t
+}
+
+func (t *token) Wait() bool {
+	<-t.done
+	return true
+}
+
+func (t *token) WaitTimeout(d time.Duration) bool {
+	var timer = t.clock.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-t.done:
+		return true
+	case <-timer.C():
+		return false
+	}
+}
+
+func (t *token) Done() <-chan struct{} {
+	return t.done
+}
+
+// Error is nil until the token is done.
+func (t *token) Error() error {
+	select {
+	case <-t.done:
+		return t.err
+	default:
+		return nil
+	}
+}
+
+func (m pahoMessage) Duplicate() bool   { return m.msg.Dup }
+func (m pahoMessage) Qos() byte         { return m.msg.QoS }
+func (m pahoMessage) Retained() bool    { return m.msg.Retain }
+func (m pahoMessage) Topic() string     { return m.msg.Topic }
+func (m pahoMessage) MessageID() uint16 { return m.msg.PacketID }
+func (m pahoMessage) Payload() []byte   { return m.msg.Payload }
+func (m pahoMessage) Ack()              {}
+
+// IsConnected is also true while AutoReconnect is redialing a lost connection, like paho's.
+func (p *pahoClient) IsConnected() bool {
+	if p.client.IsConnected() {
+		return true
+	}
+
+	return p.client.State() == StateReconnecting
+}
+
+func (p *pahoClient) IsConnectionOpen() bool {
+	return p.client.IsConnected()
+}
+
+func (p *pahoClient) Connect() Token {
+	return p.newToken(p.client.Connect)
+}
+
+func (p *pahoClient) Disconnect(quiesce uint) {
+	var clk = p.client.options.clock()
+	var deadline = clk.Now().Add(time.Duration(quiesce) * time.Millisecond)
+
+	for p.client.flow.InFlight() > 0 && clk.Now().Before(deadline) {
+		<-clk.NewTimer(closePoll).C()
+	}
+
+	p.client.Disconnect()
+}
+
+// Publish takes a string, []byte, bytes.Buffer or *bytes.Buffer payload like paho does.
+func (p *pahoClient) Publish(topic string, qos byte, retained bool, payload interface{}) Token {
+	var data []byte
+
+	switch v := payload.(type) {
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	case bytes.Buffer:
+		data = v.Bytes()
+	case *bytes.Buffer:
+		data = v.Bytes()
+	default:
+		return p.newToken(func() error { return fmt.Errorf("unknown payload type %T", payload) })
+	}
+
+	return p.newToken(func() error {
+		return p.client.Publish(context.Background(), topic, data, PublishOptions{QoS: qos, Retain: retained})
+	})
+}
+
+// Subscribe uses the handler given to AddRoute for topic when callback is nil.
+func (p *pahoClient) Subscribe(topic string, qos byte, callback PahoMessageHandler) Token {
+	return p.newToken(func() error {
+		return p.client.Subscribe(context.Background(), topic, SubscribeOptions{QoS: qos}, p.handler(topic, callback))
+	})
+}
+
+// SubscribeMultiple subscribes the filters one after the other and stops at the first that fails.
+func (p *pahoClient) SubscribeMultiple(filters map[string]byte, callback PahoMessageHandler) Token {
+	return p.newToken(func() error {
+		for topic, qos := range filters {
+			if err := p.client.Subscribe(context.Background(), topic, SubscribeOptions{QoS: qos}, p.handler(topic, callback)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (p *pahoClient) Unsubscribe(topics ...string) Token {
+	return p.newToken(func() error { return p.client.Unsubscribe(context.Background(), topics...) })
+}
+
+// AddRoute sets the handler of topic, for the subscription to it if there is one and for a later Subscribe with a
+// nil callback.
+func (p *pahoClient) AddRoute(topic string, callback PahoMessageHandler) {
+	p.mu.Lock()
+	p.routes[topic] = callback
+	p.mu.Unlock()
+
+	p.client.subscriptions.SetHandler(topic, wrapHandler(p.client.dispatch(topic, p.wrap(callback))))
+}
+
+func (p *pahoClient) OptionsReader() ClientOptions {
+	return p.client.options
+}
+
+func (p *pahoClient) handler(topic string, callback PahoMessageHandler) MessageHandler {
+	if callback == nil {
+		p.mu.Lock()
+		callback = p.routes[topic]
+		p.mu.Unlock()
+	}
+
+	return p.wrap(callback)
+}
+
+func (p *pahoClient) wrap(callback PahoMessageHandler) MessageHandler {
+	if callback == nil {
+		return nil
+	}
+
+	return func(msg Message) { callback(p, pahoMessage{msg: msg}) }
+}
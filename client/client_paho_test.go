@@ -0,0 +1,70 @@
+package client_test
+
+import (
+	"testing"
+
+	"github.com/MarcusOuelletus/demo/broker"
+	"github.com/MarcusOuelletus/demo/brokertest"
+	"github.com/MarcusOuelletus/demo/client"
+)
+
+// done waits for token and fails the test with its error.
+func done(t *testing.T, token client.Token) {
+	t.Helper()
+
+	if !token.WaitTimeout(brokertest.DefaultTimeout) {
+		t.Fatal("token not done")
+	}
+
+	if err := token.Error(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPahoClient(t *testing.T) {
+	var s = brokertest.Start(t, broker.Options{})
+	var p = client.NewPahoClient(s.Options("paho"))
+
+	done(t, p.Connect())
+
+	if !p.IsConnected() || !p.IsConnectionOpen() {
+		t.Fatal("not connected after Connect")
+	}
+
+	// -- a route gets the messages of a subscription without a callback, a callback gets its own
+	var routed, called = make(chan client.PahoMessage, 1), make(chan client.PahoMessage, 2)
+
+	p.AddRoute("a/+", func(_ client.PahoClient, m client.PahoMessage) { routed <- m })
+
+	done(t, p.Subscribe("a/+", 1, nil))
+	done(t, p.SubscribeMultiple(map[string]byte{"b": 1, "c": 0}, func(_ client.PahoClient, m client.PahoMessage) { called <- m }))
+
+	done(t, p.Publish("a/b", 1, false, "routed"))
+	done(t, p.Publish("b", 1, false, []byte("called")))
+	done(t, p.Publish("c", 0, false, []byte("also called")))
+
+	if m := next(t, routed); m.Topic() != "a/b" || string(m.Payload()) != "routed" || m.Qos() != 1 || m.MessageID() == 0 {
+		t.Fatalf("route got %q on %s with qos %d, packet id %d", m.Payload(), m.Topic(), m.Qos(), m.MessageID())
+	}
+
+	for _, want := range []string{"called", "also called"} {
+		if m := next(t, called); string(m.Payload()) != want {
+			t.Fatalf("callback got %q, want %q", m.Payload(), want)
+		}
+	}
+	// --
+
+	// -- paho takes a string, a []byte or a bytes.Buffer payload, anything else fails the token
+	if token := p.Publish("a/b", 0, false, 5); token.WaitTimeout(brokertest.DefaultTimeout) && token.Error() == nil {
+		t.Fatal("published an int payload")
+	}
+	// --
+
+	done(t, p.Unsubscribe("a/+", "b", "c"))
+
+	p.Disconnect(100)
+
+	if p.IsConnected() {
+		t.Fatal("connected after Disconnect")
+	}
+}
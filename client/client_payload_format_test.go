@@ -0,0 +1,87 @@
+package client_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/MarcusOuelletus/demo/broker"
+	"github.com/MarcusOuelletus/demo/brokertest"
+	"github.com/MarcusOuelletus/demo/client"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/mqtttest"
+	"github.com/MarcusOuelletus/demo/reasoncodes"
+)
+
+func TestPayloadFormat(t *testing.T) {
+	var s = brokertest.Start(t, broker.Options{})
+	var version5 = func(o *client.ClientOptions) { o.ProtocolVersion = mqttcodec.Version5 }
+	var sub, pub = s.Client("sub", version5), s.Client("pub", version5)
+
+	sub.Subscribe("a", 1)
+
+	// -- the Payload Format Indicator and the Content Type reach the subscriber as sent
+	if err := pub.Client.Publish(timeout(t), "a", []byte("{}"), client.PublishOptions{QoS: 1, PayloadFormat: client.PayloadUTF8, ContentType: "application/json"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if m := sub.Expect("a", "{}"); m.PayloadFormat != client.PayloadUTF8 || m.ContentType != "application/json" {
+		t.Fatalf("format %d, content type %q", m.PayloadFormat, m.ContentType)
+	}
+	// --
+
+	// -- without ValidatePayloadFormat the indicator is not checked
+	if err := pub.Client.Publish(timeout(t), "a", []byte{0xff}, client.PublishOptions{QoS: 1, PayloadFormat: client.PayloadUTF8}); err != nil {
+		t.Fatal(err)
+	}
+
+	sub.Expect("a", "\xff")
+	// --
+}
+
+func TestValidatePayloadFormat(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+
+	var c = connect(t, m, func(o *client.ClientOptions) {
+		o.ProtocolVersion = mqttcodec.Version5
+		o.ValidatePayloadFormat = true
+	})
+
+	var messages = subscribe(t, c, "#", client.SubscribeOptions{QoS: 2})
+
+	// -- a publish claiming UTF-8 that is not fails before it is sent
+	if err := c.Publish(timeout(t), "a", []byte{0xff}, client.PublishOptions{QoS: 1, PayloadFormat: client.PayloadUTF8}); !errors.Is(err, client.ErrPayloadFormatInvalid) {
+		t.Fatalf("publish: %v", err)
+	}
+
+	if err := c.Publish(timeout(t), "a", []byte("text"), client.PublishOptions{PayloadFormat: client.PayloadUTF8, ContentType: "text/plain"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if p := m.Expect(mqttcodec.PUBLISH).Packet.(*mqttcodec.Publish); string(p.Payload) != "text" || *p.Properties.PayloadFormatIndicator != 1 || p.Properties.ContentType != "text/plain" {
+		t.Fatalf("published %q with %+v", p.Payload, p.Properties)
+	}
+	// --
+
+	// -- an inbound message that is not UTF-8 is acknowledged with Payload format invalid and not delivered
+	var conn = m.NextConn()
+	var utf8 = &mqttcodec.Properties{PayloadFormatIndicator: mqttcodec.Byte(1)}
+
+	conn.Send(&mqttcodec.Publish{TopicName: "b", QoS: 1, PacketID: 7, Payload: []byte{0xc3}, Properties: utf8})
+
+	if a := m.Expect(mqttcodec.PUBACK).Packet.(*mqttcodec.Ack); a.PacketID != 7 || a.ReasonCode != byte(reasoncodes.PayloadFormatInvalid) {
+		t.Fatalf("PUBACK %d with reason code %#x", a.PacketID, a.ReasonCode)
+	}
+
+	conn.Send(&mqttcodec.Publish{TopicName: "b", QoS: 2, PacketID: 8, Payload: []byte{0xc3}, Properties: utf8})
+
+	if a := m.Expect(mqttcodec.PUBREC).Packet.(*mqttcodec.Ack); a.PacketID != 8 || a.ReasonCode != byte(reasoncodes.PayloadFormatInvalid) {
+		t.Fatalf("PUBREC %d with reason code %#x", a.PacketID, a.ReasonCode)
+	}
+
+	conn.Send(&mqttcodec.Publish{TopicName: "c", QoS: 1, PacketID: 9, Payload: []byte("é"), Properties: utf8})
+
+	if msg := next(t, messages); msg.Topic != "c" || msg.PayloadFormat != client.PayloadUTF8 {
+		t.Fatalf("got %q on %s with format %d", msg.Payload, msg.Topic, msg.PayloadFormat)
+	}
+	// --
+}
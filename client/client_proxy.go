@@ -0,0 +1,307 @@
+package client
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+/*
+Every transport over TCP can go through a proxy, the TLS and WebSocket handshakes then run through the tunnel it opens.
+
+Proxy is the proxy's URL: socks5://host:port (socks5h:// is the same, the broker's host name is always resolved by the
+proxy) speaks SOCKS5 with user name and password authentication when the URL has credentials, http://host:port opens
+the tunnel with an HTTP CONNECT request and sends the credentials as a Basic Proxy-Authorization. With
+ProxyFromEnvironment the proxy comes from HTTPS_PROXY (or https_proxy) and NO_PROXY as net/http reads them, for every
+scheme since MQTT always needs a tunnel. net/http never proxies localhost, neither does the client then.
+*/
+
+var ErrProxy = errors.New("proxy refused the connection")
+
+// proxyConn is a tunneled connection whose first bytes the proxy handshake already read.
+type proxyConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (n *proxyConn) Read(b []byte) (int, error) {
+	return n.r.Read(b)
+}
+
+// proxy returns the proxy for e, nil for a direct connection.
+func (o *ClientOptions) proxy(e *endpoint) (*url.URL, error) {
+	if o.Proxy != "" {
+		return parseProxy(o.Proxy)
+	}
+
+	if !o.ProxyFromEnvironment {
+		return nil, nil
+	}
+
+	req, err := http.NewRequest("CONNECT", "https://"+e.address, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return http.ProxyFromEnvironment(req)
+}
+
+func parseProxy(proxy string) (*url.URL, error) {
+	u, err := url.Parse(proxy)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "socks5", "socks5h", "http":
+	default:
+		return nil, fmt.Errorf("proxy scheme %q is not supported", u.Scheme)
+	}
+
+	if u.Host == "" {
+		return nil, fmt.Errorf("proxy %q has no host", proxy)
+	}
+
+	return u, nil
+}
+
+// dialProxy opens a tunnel to address through proxy.
+func dialProxy(proxy *url.URL, address string, timeout time.Duration) (net.Conn, error) {
+	var port = "1080"
+
+	if proxy.Scheme == "http" {
+		port = "80"
+	}
+
+	var host = proxy.Host
+
+	if proxy.Port() == "" {
+		host = net.JoinHostPort(proxy.Hostname(), port)
+	}
+
+	conn, err := net.DialTimeout("tcp", host, timeout)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+
+	var tunnel net.Conn
+
+	if proxy.Scheme == "http" {
+		tunnel, err = httpConnect(conn, proxy, address)
+	} else {
+		tunnel, err = socks5Connect(conn, proxy, address)
+	}
+
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	return tunnel, nil
+}
+
+func httpConnect(conn net.Conn, proxy *url.URL, address string) (net.Conn, error) {
+	var req = &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+
+	if proxy.User != nil {
+		var password, _ = proxy.User.Password()
+		var credentials = base64.StdEncoding.EncodeToString([]byte(proxy.User.Username() + ":" + password))
+
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+
+	var r = bufio.NewReader(conn)
+
+	resp, err := http.ReadResponse(r, req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: CONNECT %s answered %s", ErrProxy, address, resp.Status)
+	}
+
+	if r.Buffered() > 0 {
+		return &proxyConn{Conn: conn, r: r}, nil
+	}
+
+	return conn, nil
+}
+
+// -- SOCKS5 (RFC 1928) with user name and password authentication (RFC 1929)
+const (
+	socksVersion          = 5
+	socksNoAuth           = 0
+	socksPassword         = 2
+	socksConnect          = 1
+	socksIPv4             = 1
+	socksDomain           = 3
+	socksIPv6             = 4
+	socksPasswordVersion  = 1
+	socksSucceeded        = 0
+	socksMaxFieldLength   = 255
+	socksReplyHeaderBytes = 4
+)
+
+func socks5Connect(conn net.Conn, proxy *url.URL, address string) (net.Conn, error) {
+	host, portString, err := net.SplitHostPort(address)
+
+	if err != nil {
+		return nil, err
+	}
+
+	port, err := strconv.ParseUint(portString, 10, 16)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var methods = []byte{socksNoAuth}
+
+	if proxy.User != nil {
+		methods = []byte{socksNoAuth, socksPassword}
+	}
+
+	if _, err = conn.Write(append([]byte{socksVersion, byte(len(methods))}, methods...)); err != nil {
+		return nil, err
+	}
+
+	var reply = make([]byte, 2)
+
+	if _, err = io.ReadFull(conn, reply); err != nil {
+		return nil, err
+	}
+
+	if reply[0] != socksVersion {
+		return nil, fmt.Errorf("%w: SOCKS version %d", ErrProxy, reply[0])
+	}
+
+	switch reply[1] {
+	case socksNoAuth:
+	case socksPassword:
+		if proxy.User == nil {
+			return nil, fmt.Errorf("%w: SOCKS proxy asks for credentials", ErrProxy)
+		}
+
+		if err = socks5Authenticate(conn, proxy.User); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: no acceptable SOCKS authentication method", ErrProxy)
+	}
+
+	var request = []byte{socksVersion, socksConnect, 0}
+
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > socksMaxFieldLength {
+			return nil, fmt.Errorf("host name %q is too long for SOCKS", host)
+		}
+
+		request = append(append(request, socksDomain, byte(len(host))), host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		request = append(append(request, socksIPv4), ip4...)
+	} else {
+		request = append(append(request, socksIPv6), ip.To16()...)
+	}
+
+	request = binary.BigEndian.AppendUint16(request, uint16(port))
+
+	if _, err = conn.Write(request); err != nil {
+		return nil, err
+	}
+
+	var header = make([]byte, socksReplyHeaderBytes)
+
+	if _, err = io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+
+	if header[1] != socksSucceeded {
+		return nil, fmt.Errorf("%w: SOCKS reply %d for %s", ErrProxy, header[1], address)
+	}
+
+	// -- the bound address that follows is of no use, it only has to be read past
+	var skip int
+
+	switch header[3] {
+	case socksIPv4:
+		skip = net.IPv4len
+	case socksIPv6:
+		skip = net.IPv6len
+	case socksDomain:
+		var length = make([]byte, 1)
+
+		if _, err = io.ReadFull(conn, length); err != nil {
+			return nil, err
+		}
+
+		skip = int(length[0])
+	default:
+		return nil, fmt.Errorf("%w: SOCKS reply address type %d", ErrProxy, header[3])
+	}
+
+	if _, err = io.ReadFull(conn, make([]byte, skip+2)); err != nil {
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+func socks5Authenticate(conn net.Conn, user *url.Userinfo) error {
+	var username = user.Username()
+	var password, _ = user.Password()
+
+	if len(username) > socksMaxFieldLength || len(password) > socksMaxFieldLength {
+		return fmt.Errorf("SOCKS user name or password longer than %d bytes", socksMaxFieldLength)
+	}
+
+	var request = append([]byte{socksPasswordVersion, byte(len(username))}, username...)
+	request = append(append(request, byte(len(password))), password...)
+
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	var reply = make([]byte, 2)
+
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+
+	if reply[1] != socksSucceeded {
+		return fmt.Errorf("%w: SOCKS authentication failed", ErrProxy)
+	}
+
+	return nil
+}
+
+// --
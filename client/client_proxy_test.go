@@ -0,0 +1,167 @@
+package client_test
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/MarcusOuelletus/demo/broker"
+	"github.com/MarcusOuelletus/demo/client"
+)
+
+// proxy accepts connections until the test ends, tunnel opens the tunnel of each one and returns the address it was
+// asked for, "" refuses the connection.
+func proxy(t *testing.T, tunnel func(conn net.Conn, r *bufio.Reader) string) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				var r = bufio.NewReader(conn)
+				var address = tunnel(conn, r)
+
+				if address == "" {
+					return
+				}
+
+				upstream, err := net.Dial("tcp", address)
+
+				if err != nil {
+					return
+				}
+
+				defer upstream.Close()
+
+				go io.Copy(upstream, r)
+				io.Copy(conn, upstream)
+			}()
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+// socks5 is a SOCKS5 proxy that wants the user u with the password p.
+func socks5(t *testing.T) string {
+	return proxy(t, func(conn net.Conn, r *bufio.Reader) string {
+		// -- the greeting, then the user name and password sub-negotiation of RFC 1929
+		var read = func(n int) []byte {
+			var b = make([]byte, n)
+			io.ReadFull(r, b)
+			return b
+		}
+
+		read(int(read(2)[1]))
+		conn.Write([]byte{5, 2})
+
+		var user = string(read(int(read(2)[1])))
+		var password = string(read(int(read(1)[0])))
+
+		if user != "u" || password != "p" {
+			conn.Write([]byte{1, 1})
+			return ""
+		}
+
+		conn.Write([]byte{1, 0})
+		// --
+
+		// -- the CONNECT request, the broker's host comes as a domain name or an IPv4 address
+		var host string
+
+		switch read(4)[3] {
+		case 1:
+			host = net.IP(read(4)).String()
+		case 3:
+			host = string(read(int(read(1)[0])))
+		default:
+			return ""
+		}
+
+		var port = binary.BigEndian.Uint16(read(2))
+
+		conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+		// --
+
+		return net.JoinHostPort(host, strconv.Itoa(int(port)))
+	})
+}
+
+// httpConnect is an HTTP CONNECT proxy that wants the user u with the password p.
+func httpConnect(t *testing.T) string {
+	return proxy(t, func(conn net.Conn, r *bufio.Reader) string {
+		req, err := http.ReadRequest(r)
+
+		if err != nil || req.Method != http.MethodConnect {
+			return ""
+		}
+
+		if user, password, ok := (&http.Request{Header: http.Header{"Authorization": req.Header["Proxy-Authorization"]}}).BasicAuth(); !ok || user != "u" || password != "p" {
+			conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n"))
+			return ""
+		}
+
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+
+		return req.Host
+	})
+}
+
+func TestProxy(t *testing.T) {
+	var address = listen(t, "tcp", "127.0.0.1:0", broker.ListenerConfig{})
+
+	var tests = []struct {
+		name  string
+		proxy string
+	}{
+		{"socks5", "socks5://u:p@" + socks5(t)},
+		{"socks5h", "socks5h://u:p@" + socks5(t)},
+		{"http", "http://u:p@" + httpConnect(t)},
+	}
+
+	for _, test := range tests {
+		var c = client.New(client.ClientOptions{Broker: "tcp://" + address, CleanSession: true, Proxy: test.proxy})
+
+		if err := c.Connect(); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if _, err := c.PublishQoS1(timeout(t), "a", nil, client.PublishOptions{}); err != nil {
+			t.Errorf("%s: publish: %v", test.name, err)
+		}
+
+		c.Disconnect()
+	}
+
+	// -- wrong credentials are refused by both kinds of proxy
+	for _, proxy := range []string{"socks5://u:wrong@" + socks5(t), "http://u:wrong@" + httpConnect(t)} {
+		var c = client.New(client.ClientOptions{Broker: "tcp://" + address, CleanSession: true, Proxy: proxy})
+
+		if err := c.Connect(); !errors.Is(err, client.ErrProxy) {
+			c.Disconnect()
+			t.Errorf("%s: connected with %v", proxy, err)
+		}
+	}
+	// --
+}
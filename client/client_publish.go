@@ -0,0 +1,411 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/MarcusOuelletus/demo/modules/logger"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/reasoncodes"
+)
+
+/*
+PublishQoS1 runs one QoS 1 flow end to end: the FlowController takes send quota, reserves the packet id from PacketIDs
+and registers the listener on the ResponseBroadcaster, the PUBLISH goes out and the PUBACK the read loop hands over is
+returned as an Ack. The packet id is released once the flow got its final acknowledgement, also when ctx ends the wait,
+so a deadline on ctx is the acknowledgement timeout, a ctx that is done already fails the publish before anything is
+stored or sent. The message sits in the Store under a queued key while it waits for quota and under its packet id while
+it is in flight. A flow whose connection is lost returns the connection's error but stays in the Store with its packet
+id taken, it is resumed on the next connection like a restored one.
+
+PublishQoS2 drives PUBLISH -> PUBREC -> PUBREL -> PUBCOMP through the OutboundQoS2Flow, the read loop moves the flow
+on when the PUBREC arrives and answers any later PUBREC for it with the PUBREL itself. With RetryInterval (or a
+RetryBackoff) set the PUBLISH is resent with DUP until the PUBACK or PUBREC arrives and the PUBREL until the PUBCOMP
+arrives. A PUBREC with a failure reason code ends the flow right there, no PUBREL is sent and the packet id is free
+again.
+
+An acknowledgement with a failure reason code (0x80 and up, MQTT 5 only) is returned together with a PublishError, the
+Ack is still filled in so the caller can look at the Reason String.
+
+PayloadFormat and ContentType tell the receiver how to decode the payload, PayloadUTF8 is a promise that it is UTF-8
+text. With ValidatePayloadFormat set the client holds both sides to it: a publish claiming UTF-8 that is not fails
+with ErrPayloadFormatInvalid before anything is sent, an inbound one is not delivered, a QoS 1 or 2 message is
+acknowledged with Payload format invalid (0x99) so the broker does not send it again.
+*/
+
+var ErrPayloadFormatInvalid = errors.New("payload is not valid UTF-8")
+
+// PayloadFormat is the MQTT 5 Payload Format Indicator.
+type PayloadFormat byte
+
+const (
+	PayloadUnspecified PayloadFormat = 0
+	PayloadUTF8        PayloadFormat = 1
+)
+
+type PublishOptions struct {
+	// QoS is only read by Publish, PublishQoS1 and PublishQoS2 imply their own.
+	QoS    byte
+	Retain bool
+
+	// The rest are MQTT 5 properties, a 3.1.1 connection drops them. MessageExpiry is sent in whole seconds.
+	MessageExpiry   time.Duration
+	PayloadFormat   PayloadFormat
+	ContentType     string
+	ResponseTopic   string
+	CorrelationData []byte
+	UserProperties  []KeyValue
+}
+
+// Ack is the broker's acknowledgement of a publish, Properties is nil on MQTT 3.1.1.
+type Ack struct {
+	PacketID   uint16
+	ReasonCode reasoncodes.Code
+	Properties *mqttcodec.Properties
+}
+
+// PublishError is returned when the broker acknowledged a publish with a failure reason code.
+type PublishError struct {
+	PacketType mqttcodec.PacketType
+	Ack        Ack
+}
+
+func (e *PublishError) Error() string {
+	return fmt.Sprintf("%s for packet id %d failed with reason code 0x%02X %s", e.PacketType, e.Ack.PacketID, byte(e.Ack.ReasonCode), e.Ack.ReasonCode.Name(byte(e.PacketType)))
+}
+
+// Publish sends a message and, for QoS 1 and 2, waits until the broker acknowledged it or ctx is done.
+func (c *Client) Publish(ctx context.Context, topic string, payload []byte, opts PublishOptions) error {
+	topic, payload, opts, err := c.outbound(ctx, topic, payload, opts)
+
+	if err != nil {
+		return err
+	}
+
+	switch opts.QoS {
+	case 1, 2:
+		_, err = c.publish(ctx, &StoredMessage{Topic: topic, Payload: payload, QoS: opts.QoS, Retain: opts.Retain, Properties: opts.properties()})
+		return err
+	}
+
+	n, err := c.publishing()
+
+	if err != nil {
+		return err
+	}
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	p, err := mqttcodec.NewPublishBuilder(topic).Payload(payload).Retain(opts.Retain).QoS(opts.QoS, 0).Properties(opts.properties()).Build()
+
+	if err != nil {
+		return err
+	}
+
+	c.logPublish(ctx, p)
+
+	if err = n.write(p); err != nil {
+		return err
+	}
+
+	c.metrics.publish(0)
+
+	return nil
+}
+
+// PublishQoS1 sends a QoS 1 message and waits for its PUBACK, or for ctx to be done.
+func (c *Client) PublishQoS1(ctx context.Context, topic string, payload []byte, opts PublishOptions) (Ack, error) {
+	opts.QoS = 1
+
+	topic, payload, opts, err := c.outbound(ctx, topic, payload, opts)
+
+	if err != nil {
+		return Ack{}, err
+	}
+
+	return c.publish(ctx, &StoredMessage{Topic: topic, Payload: payload, QoS: 1, Retain: opts.Retain, Properties: opts.properties()})
+}
+
+// PublishQoS2 sends a QoS 2 message and runs the handshake until the PUBCOMP, or until ctx is done.
+func (c *Client) PublishQoS2(ctx context.Context, topic string, payload []byte, opts PublishOptions) (Ack, error) {
+	opts.QoS = 2
+
+	topic, payload, opts, err := c.outbound(ctx, topic, payload, opts)
+
+	if err != nil {
+		return Ack{}, err
+	}
+
+	return c.publish(ctx, &StoredMessage{Topic: topic, Payload: payload, QoS: 2, Retain: opts.Retain, Properties: opts.properties()})
+}
+
+// publish takes send quota and a packet id for msg and runs its flow, msg stays in the Store until the flow ended.
+func (c *Client) publish(ctx context.Context, msg *StoredMessage) (Ack, error) {
+	n, err := c.publishing()
+
+	if err != nil {
+		return Ack{}, err
+	}
+
+	if err = ctx.Err(); err != nil {
+		return Ack{}, err
+	}
+
+	var queued = c.queuedKey()
+
+	if err = c.store.Put(queued, msg); err != nil {
+		return Ack{}, err
+	}
+
+	return c.publishStored(ctx, n, queued, msg)
+}
+
+// publishStored runs the flow of msg that is in the Store under the queued key.
+func (c *Client) publishStored(ctx context.Context, n *connection, queued string, msg *StoredMessage) (Ack, error) {
+	var ch = make(chan uint16, 1)
+
+	id, err := c.flow.AcquireContext(ctx, ch)
+
+	if err != nil {
+		c.store.Delete(queued)
+		return Ack{}, err
+	}
+
+	// -- the message moves from the queued to the inflight key, Put first so a crash in between never loses it
+	var key = inflightKey(id.Value)
+	msg.PacketID = id.Value
+
+	if err = c.store.Put(key, msg); err != nil {
+		c.flow.Release(id.Value, ch)
+		c.store.Delete(queued)
+		return Ack{}, err
+	}
+
+	if err = c.store.Delete(queued); err != nil {
+		c.flow.Release(id.Value, ch)
+		c.store.Delete(key)
+		return Ack{}, err
+	}
+	// --
+
+	ack, err := c.run(ctx, n, ch, key, msg, false)
+
+	if c.settle(n, key, err) {
+		c.flow.Detach(id.Value, ch)
+	} else {
+		c.flow.Release(id.Value, ch)
+	}
+
+	return ack, err
+}
+
+// run drives the flow of a stored message whose packet id and listener are already set up, a resumed flow sends its
+// first PUBLISH with DUP as well.
+func (c *Client) run(ctx context.Context, n *connection, ch chan uint16, key string, msg *StoredMessage, resumed bool) (Ack, error) {
+	p, err := mqttcodec.NewPublishBuilder(msg.Topic).
+		Payload(msg.Payload).
+		Retain(msg.Retain).
+		QoS(msg.QoS, msg.PacketID).
+		Properties(msg.Properties).
+		Build()
+
+	if err != nil {
+		return Ack{}, err
+	}
+
+	var dup = *p
+	dup.Dup = true
+
+	if resumed {
+		p = &dup
+	}
+
+	c.logPublish(ctx, p)
+
+	if msg.QoS == 1 {
+		if err = n.write(p); err != nil {
+			return Ack{}, err
+		}
+
+		var sent = c.options.clock().Now()
+		c.metrics.publish(1)
+
+		puback, err := c.awaitRetrying(ctx, n, p.PacketID, ch, mqttcodec.PUBACK, func() error { return n.write(&dup) })
+
+		if err != nil {
+			return Ack{}, err
+		}
+
+		c.metrics.acknowledged(&c.metrics.puback, c.options.clock().Now().Sub(sent))
+
+		return ackOf(puback.(*mqttcodec.Ack))
+	}
+
+	if err = c.outboundQoS2.Start(p.PacketID); err != nil {
+		return Ack{}, err
+	}
+
+	// -- a flow that did not reach PUBCOMP must not block the packet id once it is released
+	defer c.outboundQoS2.Abort(p.PacketID)
+
+	if msg.Pubrel {
+		c.outboundQoS2.HandlePubrec(p.PacketID)
+	} else {
+		if err = n.write(p); err != nil {
+			return Ack{}, err
+		}
+
+		var sent = c.options.clock().Now()
+		c.metrics.publish(2)
+
+		pubrec, err := c.awaitRetrying(ctx, n, p.PacketID, ch, mqttcodec.PUBREC, func() error { return n.write(&dup) })
+
+		if err != nil {
+			return Ack{}, err
+		}
+
+		c.metrics.acknowledged(&c.metrics.pubrec, c.options.clock().Now().Sub(sent))
+
+		if ack, err := ackOf(pubrec.(*mqttcodec.Ack)); err != nil {
+			return ack, err
+		}
+
+		msg.Pubrel = true
+
+		if err = c.store.Put(key, msg); err != nil {
+			return Ack{}, err
+		}
+	}
+
+	var pubrel = mqttcodec.NewPubrel(p.PacketID)
+
+	if err = n.write(pubrel); err != nil {
+		return Ack{}, err
+	}
+
+	var sent = c.options.clock().Now()
+
+	pubcomp, err := c.awaitRetrying(ctx, n, p.PacketID, ch, mqttcodec.PUBCOMP, func() error { return n.write(pubrel) })
+
+	if err != nil {
+		return Ack{}, err
+	}
+
+	c.metrics.acknowledged(&c.metrics.pubcomp, c.options.clock().Now().Sub(sent))
+
+	if err = c.outboundQoS2.HandlePubcomp(p.PacketID); err != nil {
+		return Ack{}, err
+	}
+
+	return ackOf(pubcomp.(*mqttcodec.Ack))
+}
+
+// errRetryDue cancels the wait of awaitRetrying once the delay before a resend ran out.
+var errRetryDue = errors.New("retry due")
+
+// awaitRetrying is await that calls resend whenever the retry backoff ran out without an acknowledgement.
+func (c *Client) awaitRetrying(ctx context.Context, n *connection, id uint16, ch chan uint16, t mqttcodec.PacketType, resend func() error) (mqttcodec.Packet, error) {
+	var backoff = c.options.retryBackoff()
+
+	if backoff == nil {
+		return c.await(ctx, n, id, ch, t)
+	}
+
+	for attempt := 0; ; attempt++ {
+		var delay = backoff.NextDelay(attempt)
+
+		if delay <= 0 {
+			return c.await(ctx, n, id, ch, t)
+		}
+
+		var retry, cancel = context.WithCancelCause(ctx)
+		var timer = c.options.clock().AfterFunc(delay, func() { cancel(errRetryDue) })
+
+		p, err := c.await(retry, n, id, ch, t)
+		timer.Stop()
+		cancel(nil)
+
+		if err != context.Canceled || ctx.Err() != nil || context.Cause(retry) != errRetryDue {
+			if err == nil && attempt > 0 {
+				backoff.Reset()
+			}
+			return p, err
+		}
+
+		if err = resend(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// checkPayload fails a payload that is not the UTF-8 its format claims, only with ValidatePayloadFormat.
+func (c *Client) checkPayload(format PayloadFormat, payload []byte) error {
+	if format == PayloadUTF8 && c.options.ValidatePayloadFormat && !utf8.Valid(payload) {
+		return ErrPayloadFormatInvalid
+	}
+
+	return nil
+}
+
+// validPayload is checkPayload for an inbound PUBLISH.
+func (c *Client) validPayload(p *mqttcodec.Publish) bool {
+	if p.Properties == nil || p.Properties.PayloadFormatIndicator == nil {
+		return true
+	}
+
+	return c.checkPayload(PayloadFormat(*p.Properties.PayloadFormatIndicator), p.Payload) == nil
+}
+
+// properties returns the MQTT 5 properties of o, nil when it has none.
+func (o PublishOptions) properties() *mqttcodec.Properties {
+	var p = &mqttcodec.Properties{
+		ContentType:     o.ContentType,
+		ResponseTopic:   o.ResponseTopic,
+		CorrelationData: o.CorrelationData,
+		UserProperties:  o.UserProperties,
+	}
+
+	if o.MessageExpiry > 0 {
+		p.MessageExpiryInterval = mqttcodec.Uint32(uint32((o.MessageExpiry + time.Second - 1) / time.Second))
+	}
+
+	if o.PayloadFormat != PayloadUnspecified {
+		var format = byte(o.PayloadFormat)
+		p.PayloadFormatIndicator = &format
+	}
+
+	if p.ContentType == "" && p.ResponseTopic == "" && p.CorrelationData == nil && p.UserProperties == nil && p.MessageExpiryInterval == nil && p.PayloadFormatIndicator == nil {
+		return nil
+	}
+
+	return p
+}
+
+// ackOf turns a PUBACK, PUBREC or PUBCOMP into an Ack, with a PublishError for a failure reason code.
+func ackOf(p *mqttcodec.Ack) (Ack, error) {
+	var ack = Ack{
+		PacketID:   p.PacketID,
+		ReasonCode: reasoncodes.Code(p.ReasonCode),
+		Properties: p.Properties,
+	}
+
+	if ack.ReasonCode.IsFailure() {
+		return ack, &PublishError{PacketType: p.PacketType, Ack: ack}
+	}
+
+	return ack, nil
+}
+
+// logPublish logs p at debug level with the correlation fields of ctx.
+func (c *Client) logPublish(ctx context.Context, p *mqttcodec.Publish) {
+	var log = logger.FromContext(ctx, c.log)
+
+	if logger.Enabled(log, logger.DebugLevel) {
+		log.Debug("client: publishing", logger.Fields{logger.TopicField: p.TopicName, logger.PacketIDField: p.PacketID, "qos": p.QoS, "dup": p.Dup})
+	}
+}
@@ -0,0 +1,240 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/client"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/mqtttest"
+	"github.com/MarcusOuelletus/demo/reasoncodes"
+)
+
+// refuse acknowledges every QoS 1 or 2 PUBLISH with code, an MQTT 5 failure reason code.
+func refuse(code reasoncodes.Code) mqtttest.Handler {
+	return func(c *mqtttest.Conn, p mqttcodec.Packet) mqtttest.Response {
+		var publish = p.(*mqttcodec.Publish)
+		var ack = &mqttcodec.Ack{PacketType: mqttcodec.PUBACK, PacketID: publish.PacketID, ReasonCode: byte(code)}
+
+		if publish.QoS == 2 {
+			ack.PacketType = mqttcodec.PUBREC
+		}
+
+		return mqtttest.Response{Packets: []mqttcodec.Packet{ack}}
+	}
+}
+
+func TestPublishQoS1(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+	var c = connect(t, m, nil)
+
+	ack, err := c.PublishQoS1(timeout(t), "a", []byte("x"), client.PublishOptions{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p := m.Expect(mqttcodec.PUBLISH).Packet.(*mqttcodec.Publish); p.QoS != 1 || p.PacketID != ack.PacketID || ack.ReasonCode != 0 {
+		t.Fatalf("PUBLISH %d with qos %d acknowledged as %d with %#x", p.PacketID, p.QoS, ack.PacketID, ack.ReasonCode)
+	}
+
+	// -- ctx bounds the wait for the PUBACK, the packet id is released when it ends
+	m.Handle(mqttcodec.PUBLISH, mqtttest.Drop)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if _, err := c.PublishQoS1(ctx, "a", nil, client.PublishOptions{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("publish without PUBACK: %v", err)
+	}
+
+	if n := c.Metrics().InFlight; n != 0 {
+		t.Fatalf("%d in flight after the deadline", n)
+	}
+	// --
+}
+
+func TestPublishQoS1Retry(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+
+	m.Handle(mqttcodec.PUBLISH, mqtttest.Script(mqtttest.Drop))
+
+	var c = connect(t, m, func(o *client.ClientOptions) { o.RetryInterval = 50 * time.Millisecond })
+
+	if _, err := c.PublishQoS1(timeout(t), "a", nil, client.PublishOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var first = m.Expect(mqttcodec.PUBLISH).Packet.(*mqttcodec.Publish)
+
+	if resent := m.Expect(mqttcodec.PUBLISH).Packet.(*mqttcodec.Publish); !resent.Dup || resent.PacketID != first.PacketID {
+		t.Fatalf("resent %d with dup %v, first was %d", resent.PacketID, resent.Dup, first.PacketID)
+	}
+}
+
+func TestPublishQoS1Refused(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+
+	m.Handle(mqttcodec.PUBLISH, refuse(reasoncodes.NotAuthorized))
+
+	var c = connect(t, m, func(o *client.ClientOptions) { o.ProtocolVersion = mqttcodec.Version5 })
+
+	ack, err := c.PublishQoS1(timeout(t), "a", nil, client.PublishOptions{})
+
+	var refused *client.PublishError
+
+	if !errors.As(err, &refused) || refused.PacketType != mqttcodec.PUBACK || ack.ReasonCode != reasoncodes.NotAuthorized {
+		t.Fatalf("publish: %v, ack %#x", err, ack.ReasonCode)
+	}
+}
+
+func TestPublishQoS2(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+	var c = connect(t, m, nil)
+
+	ack, err := c.PublishQoS2(timeout(t), "a", []byte("x"), client.PublishOptions{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// -- PUBLISH, PUBREC, PUBREL, PUBCOMP on one packet id
+	if p := m.Expect(mqttcodec.PUBLISH).Packet.(*mqttcodec.Publish); p.QoS != 2 || p.PacketID != ack.PacketID {
+		t.Fatalf("PUBLISH %d with qos %d, acknowledged as %d", p.PacketID, p.QoS, ack.PacketID)
+	}
+
+	if a := m.Expect(mqttcodec.PUBREL).Packet.(*mqttcodec.Ack); a.PacketID != ack.PacketID {
+		t.Fatalf("PUBREL %d, acknowledged as %d", a.PacketID, ack.PacketID)
+	}
+	// --
+
+	if n := c.Metrics().InFlight; n != 0 {
+		t.Fatalf("%d in flight after PUBCOMP", n)
+	}
+}
+
+func TestPublishQoS2Retry(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+
+	// -- the first PUBREC and the first PUBCOMP are lost, the PUBLISH and the PUBREL are resent
+	m.Handle(mqttcodec.PUBLISH, mqtttest.Script(mqtttest.Drop))
+	m.Handle(mqttcodec.PUBREL, mqtttest.Script(mqtttest.Drop))
+	// --
+
+	var c = connect(t, m, func(o *client.ClientOptions) { o.RetryInterval = 50 * time.Millisecond })
+
+	if _, err := c.PublishQoS2(timeout(t), "a", nil, client.PublishOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	m.Expect(mqttcodec.PUBLISH)
+
+	if p := m.Expect(mqttcodec.PUBLISH).Packet.(*mqttcodec.Publish); !p.Dup {
+		t.Fatal("PUBLISH resent without dup")
+	}
+
+	m.Expect(mqttcodec.PUBREL)
+	m.Expect(mqttcodec.PUBREL)
+}
+
+func TestPublishQoS2Refused(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+
+	m.Handle(mqttcodec.PUBLISH, refuse(reasoncodes.QuotaExceeded))
+
+	var c = connect(t, m, func(o *client.ClientOptions) { o.ProtocolVersion = mqttcodec.Version5 })
+
+	// -- a failing PUBREC ends the flow, no PUBREL follows
+	_, err := c.PublishQoS2(timeout(t), "a", nil, client.PublishOptions{})
+
+	var refused *client.PublishError
+
+	if !errors.As(err, &refused) || refused.PacketType != mqttcodec.PUBREC || refused.Ack.ReasonCode != reasoncodes.QuotaExceeded {
+		t.Fatalf("publish: %v", err)
+	}
+
+	m.ExpectNone(mqttcodec.PUBREL, 100*time.Millisecond)
+	// --
+
+	// -- a flow whose ctx ends before the PUBREC leaves nothing in flight
+	m.Handle(mqttcodec.PUBLISH, mqtttest.Drop)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if _, err := c.PublishQoS2(ctx, "a", nil, client.PublishOptions{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("publish without PUBREC: %v", err)
+	}
+
+	if n := c.Metrics().InFlight; n != 0 {
+		t.Fatalf("%d in flight after the deadline", n)
+	}
+	// --
+}
+
+func TestPublishOptions(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+	var c = connect(t, m, func(o *client.ClientOptions) { o.ProtocolVersion = mqttcodec.Version5 })
+
+	var opts = client.PublishOptions{
+		QoS:             1,
+		Retain:          true,
+		MessageExpiry:   1500 * time.Millisecond,
+		ContentType:     "text/plain",
+		ResponseTopic:   "responses/c",
+		CorrelationData: []byte{1, 2},
+		UserProperties:  []client.KeyValue{{Key: "k", Value: "v"}},
+	}
+
+	if err := c.Publish(timeout(t), "a", []byte("x"), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// -- the options are the flags and properties of the PUBLISH, the expiry rounded up to whole seconds
+	var p = m.Expect(mqttcodec.PUBLISH).Packet.(*mqttcodec.Publish)
+
+	if p.QoS != 1 || !p.Retain || p.Properties == nil {
+		t.Fatalf("PUBLISH with qos %d, retain %v and properties %+v", p.QoS, p.Retain, p.Properties)
+	}
+
+	if e := p.Properties.MessageExpiryInterval; e == nil || *e != 2 {
+		t.Errorf("message expiry %v", e)
+	}
+
+	if p.Properties.ContentType != "text/plain" || p.Properties.ResponseTopic != "responses/c" || string(p.Properties.CorrelationData) != "\x01\x02" {
+		t.Errorf("properties %+v", p.Properties)
+	}
+
+	if u := p.Properties.UserProperties; len(u) != 1 || u[0] != (client.KeyValue{Key: "k", Value: "v"}) {
+		t.Errorf("user properties %v", u)
+	}
+	// --
+
+	// -- a done ctx fails the publish before anything is sent
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := c.Publish(ctx, "a", nil, client.PublishOptions{QoS: 1}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("publish with a done ctx: %v", err)
+	}
+
+	m.ExpectNone(mqttcodec.PUBLISH, 50*time.Millisecond)
+	// --
+}
+
+func TestPublishOptions311(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+	var c = connect(t, m, nil)
+
+	// -- a 3.1.1 PUBLISH has no properties, they are dropped
+	if err := c.Publish(timeout(t), "a", nil, client.PublishOptions{QoS: 1, ContentType: "text/plain"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if p := m.Expect(mqttcodec.PUBLISH).Packet.(*mqttcodec.Publish); p.Properties != nil {
+		t.Fatalf("3.1.1 PUBLISH with properties %+v", p.Properties)
+	}
+	// --
+}
@@ -0,0 +1,91 @@
+package client
+
+import (
+	"github.com/MarcusOuelletus/demo/internal/ratelimit"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+)
+
+/*
+With InboundRate set, inbound PUBLISH packets no longer go from the read loop straight to the handlers. The read loop
+resolves their topic alias and puts them on the connection's inbound queue, a goroutine takes them off at no more than
+InboundRate a second (token bucket, InboundBurst at once) and only then routes and acknowledges them. The read loop
+itself is never slowed down, PINGRESP and the acknowledgements of our publishes keep flowing.
+
+Holding back the PUBACK and PUBREC is the backpressure: an MQTT 5 broker stops sending QoS 1 and 2 messages once
+Receive Maximum of them are unacknowledged, and Connect advertises a Receive Maximum of InboundQueue unless
+ReceiveMaximum or ConnectProperties set one, so the queue fills up to it and no further. A QoS 0 message that finds the
+queue full is dropped, there is nobody to push back on. On 3.1.1, which has no receive quota, a full queue makes the
+read loop wait for room like a full handler queue does. The bucket starts full with every connection, messages queued when a
+connection is lost are neither routed nor acknowledged and the broker sends them again.
+*/
+
+var DefaultInboundQueue = 64
+
+type inboundLimiter struct {
+	bucket *ratelimit.Bucket
+	queue  chan *mqttcodec.Publish
+}
+
+func (o *ClientOptions) inboundQueue() int {
+	if o.InboundQueue > 0 {
+		return o.InboundQueue
+	}
+
+	return DefaultInboundQueue
+}
+
+// newInboundLimiter starts the goroutine that routes the inbound messages of n, nil without InboundRate.
+func (c *Client) newInboundLimiter(n *connection) *inboundLimiter {
+	if c.options.InboundRate <= 0 {
+		return nil
+	}
+
+	var l = &inboundLimiter{
+		bucket: ratelimit.New(c.options.InboundRate, c.options.InboundBurst, c.options.clock()),
+		queue:  make(chan *mqttcodec.Publish, c.options.inboundQueue()),
+	}
+
+	n.loops.Add(1)
+
+	go func() {
+		defer n.loops.Done()
+
+		for {
+			select {
+			case p := <-l.queue:
+				if !l.bucket.Wait(n.done) {
+					return
+				}
+
+				if err := c.route(n, p); err != nil {
+					n.close(err)
+					return
+				}
+			case <-n.done:
+				return
+			}
+		}
+	}()
+
+	return l
+}
+
+// enqueue queues p for the limiter, it returns false for a QoS 0 message that did not fit and was dropped.
+func (l *inboundLimiter) enqueue(n *connection, p *mqttcodec.Publish) bool {
+	select {
+	case l.queue <- p:
+		return true
+	default:
+	}
+
+	if p.QoS == 0 {
+		return false
+	}
+
+	select {
+	case l.queue <- p:
+	case <-n.done:
+	}
+
+	return true
+}
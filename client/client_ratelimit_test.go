@@ -0,0 +1,99 @@
+package client_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/client"
+	"github.com/MarcusOuelletus/demo/clock"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/mqtttest"
+)
+
+func TestInboundRate(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+	var fake = clock.NewFake(time.Unix(0, 0))
+
+	var c = connect(t, m, func(o *client.ClientOptions) {
+		o.ProtocolVersion = mqttcodec.Version5
+		o.InboundRate = 1
+		o.InboundBurst = 2
+		o.InboundQueue = 8
+		o.Clock = fake
+	})
+
+	var conn = m.NextConn()
+
+	// -- the queue length is the Receive Maximum the broker is told
+	if r := conn.Connect.Properties.ReceiveMaximum; r == nil || *r != 8 {
+		t.Fatalf("Receive Maximum %v", r)
+	}
+	// --
+
+	var messages = subscribe(t, c, "a", client.SubscribeOptions{QoS: 1})
+
+	for i := uint16(1); i <= 3; i++ {
+		conn.Send(&mqttcodec.Publish{TopicName: "a", QoS: 1, PacketID: i})
+	}
+
+	// -- the burst goes through, the third message and its PUBACK wait for the next token
+	for i := 0; i < 2; i++ {
+		next(t, messages)
+		m.Expect(mqttcodec.PUBACK)
+	}
+
+	fake.BlockUntil(1)
+	m.ExpectNone(mqttcodec.PUBACK, 50*time.Millisecond)
+
+	fake.Advance(time.Second)
+
+	if msg := next(t, messages); msg.PacketID != 3 {
+		t.Fatalf("got packet id %d", msg.PacketID)
+	}
+
+	m.Expect(mqttcodec.PUBACK)
+	// --
+}
+
+func TestInboundRateDropsQoS0(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+	var fake = clock.NewFake(time.Unix(0, 0))
+
+	var c = connect(t, m, func(o *client.ClientOptions) {
+		o.InboundRate = 1
+		o.InboundBurst = 1
+		o.InboundQueue = 1
+		o.Clock = fake
+	})
+
+	var messages = subscribe(t, c, "a", client.SubscribeOptions{})
+	var conn = m.NextConn()
+
+	// -- the first message takes the token, the second waits for the next one
+	conn.Send(&mqttcodec.Publish{TopicName: "a", Payload: []byte("1")}, &mqttcodec.Publish{TopicName: "a", Payload: []byte("2")})
+	next(t, messages)
+	fake.BlockUntil(1)
+	// --
+
+	// -- the third fills the queue, the fourth and fifth are dropped, the PUBCOMP says the read loop got that far
+	conn.Send(
+		&mqttcodec.Publish{TopicName: "a", Payload: []byte("3")},
+		&mqttcodec.Publish{TopicName: "a", Payload: []byte("4")},
+		&mqttcodec.Publish{TopicName: "a", Payload: []byte("5")},
+		mqttcodec.NewPubrel(9),
+	)
+	m.Expect(mqttcodec.PUBCOMP)
+
+	if dropped := c.Metrics().Dropped; dropped != 2 {
+		t.Fatalf("%d dropped", dropped)
+	}
+	// --
+
+	for _, payload := range []string{"2", "3"} {
+		fake.Advance(time.Second)
+
+		if msg := next(t, messages); string(msg.Payload) != payload {
+			t.Fatalf("got %q, want %s", msg.Payload, payload)
+		}
+	}
+}
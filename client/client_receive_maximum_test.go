@@ -0,0 +1,78 @@
+package client_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/client"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/mqtttest"
+	"github.com/MarcusOuelletus/demo/reasoncodes"
+	"github.com/MarcusOuelletus/demo/session"
+)
+
+func TestReceiveMaximum(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+	var lost = make(chan error, 1)
+
+	connect(t, m, func(o *client.ClientOptions) {
+		o.ProtocolVersion = mqttcodec.Version5
+		o.ReceiveMaximum = 2
+		o.OnConnectionLost = func(err error) { lost <- err }
+	})
+
+	var r = m.Expect(mqttcodec.CONNECT)
+
+	if p := r.Packet.(*mqttcodec.Connect).Properties; p == nil || p.ReceiveMaximum == nil || *p.ReceiveMaximum != 2 {
+		t.Fatalf("CONNECT properties %+v", p)
+	}
+
+	// -- a PUBLISH acknowledged with PUBACK frees its slot, a QoS 2 one holds it until the PUBCOMP
+	r.Conn.Send(&mqttcodec.Publish{TopicName: "a", QoS: 2, PacketID: 1}, &mqttcodec.Publish{TopicName: "a", QoS: 1, PacketID: 2})
+	m.Expect(mqttcodec.PUBREC)
+	m.Expect(mqttcodec.PUBACK)
+
+	// a redelivery of packet id 3 takes no second slot
+	r.Conn.Send(&mqttcodec.Publish{TopicName: "a", QoS: 2, PacketID: 3}, &mqttcodec.Publish{TopicName: "a", QoS: 2, PacketID: 3, Dup: true})
+	m.Expect(mqttcodec.PUBREC)
+	m.Expect(mqttcodec.PUBREC)
+	// --
+
+	// -- a third unacknowledged message breaks the protocol
+	r.Conn.Send(&mqttcodec.Publish{TopicName: "a", QoS: 1, PacketID: 4})
+
+	if d := m.Expect(mqttcodec.DISCONNECT).Packet.(*mqttcodec.Disconnection); d.ReasonCode != byte(reasoncodes.ReceiveMaximumExceeded) {
+		t.Fatalf("DISCONNECT with reason code %#x", d.ReasonCode)
+	}
+
+	if err := next(t, lost); !errors.Is(err, session.ErrReceiveMaximumExceeded) {
+		t.Fatalf("lost with %v", err)
+	}
+	// --
+}
+
+func TestReceiveMaximumReleased(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+
+	connect(t, m, func(o *client.ClientOptions) {
+		o.ProtocolVersion = mqttcodec.Version5
+		o.ReceiveMaximum = 1
+	})
+
+	var conn = m.NextConn()
+
+	// -- a finished flow gives its slot back, one at a time never runs out
+	for id := uint16(1); id <= 5; id++ {
+		conn.Send(&mqttcodec.Publish{TopicName: "a", QoS: 2, PacketID: id})
+		m.Expect(mqttcodec.PUBREC)
+		conn.Send(mqttcodec.NewPubrel(id))
+		m.Expect(mqttcodec.PUBCOMP)
+
+		conn.Send(&mqttcodec.Publish{TopicName: "a", QoS: 1, PacketID: 100 + id})
+		m.Expect(mqttcodec.PUBACK)
+	}
+
+	m.ExpectNone(mqttcodec.DISCONNECT, 100*time.Millisecond)
+	// --
+}
@@ -0,0 +1,197 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/modules/logger"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+)
+
+/*
+With AutoReconnect set, a connection that ends without Disconnect being called is redialed in the background.
+
+The delay before attempt n comes from ReconnectBackoff, by default InitialReconnectDelay * 2^n capped at
+MaxReconnectDelay, with jitter taking it anywhere between half and all of that so a broker restart is not hit by every
+client at the same moment. MaxReconnectAttempts of 0 retries forever. The reconnect uses the same options and client
+id, so with CleanSession off the broker resumes the session, OnReconnected reports whether it did. Operations that were waiting on the lost connection fail with its
+error, they are not carried over.
+
+Whenever a CONNACK comes back with Session Present = 0, after a reconnect to a broker that lost the session or a
+failover to one that never had it, every subscription the SubscriptionManager holds is sent again in one SUBSCRIBE
+before Connect returns or OnReconnected runs, with its handler left in place. OnResubscribed gets the outcome per
+filter, a refused filter is dropped like a refused Subscribe.
+*/
+
+var (
+	DefaultInitialReconnectDelay = time.Second
+	DefaultMaxReconnectDelay     = 2 * time.Minute
+)
+
+// connectionLost runs once the read loop of n ended, nothing happens if n was closed by Disconnect.
+func (c *Client) connectionLost(n *connection) {
+	c.mu.Lock()
+	var stop = c.stopReconnect
+	var current = c.conn == n
+	c.mu.Unlock()
+
+	if !current {
+		return
+	}
+
+	atomic.AddUint64(&c.metrics.connectionsLost, 1)
+	c.log.Warn("client: connection lost", logger.Fields{"error": n.closedErr()})
+
+	var redirected = c.disconnectEvent(n.closedErr())
+
+	var redial = (c.options.AutoReconnect || redirected) && stop != nil
+
+	c.mu.Lock()
+	if c.conn == n && c.stopReconnect == stop {
+		if redial {
+			c.transition(StateReconnecting, n.closedErr())
+		} else {
+			c.transition(StateDisconnected, n.closedErr())
+		}
+	}
+	c.mu.Unlock()
+
+	if c.options.OnConnectionLost != nil {
+		c.options.OnConnectionLost(n.closedErr())
+	}
+
+	if redial {
+		go c.reconnect(stop, redirected)
+	}
+}
+
+// reconnect redials until it succeeds, a redirected client dials the referenced server without waiting first and
+// only once without AutoReconnect.
+func (c *Client) reconnect(stop chan struct{}, redirected bool) {
+	var backoff = c.options.reconnectBackoff()
+	var err error
+
+	for attempt := 0; c.options.MaxReconnectAttempts == 0 || attempt < c.options.MaxReconnectAttempts; attempt++ {
+		if attempt > 0 && !c.options.AutoReconnect {
+			break
+		}
+
+		var delay = backoff.NextDelay(attempt)
+
+		if redirected && attempt == 0 {
+			delay = 0
+		}
+
+		var timer = c.options.clock().NewTimer(delay)
+
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+
+		err = c.connect(stop)
+
+		if err == nil {
+			backoff.Reset()
+			atomic.AddUint64(&c.metrics.reconnects, 1)
+
+			if c.options.OnReconnected != nil {
+				c.options.OnReconnected(c.SessionPresent())
+			}
+			return
+		}
+
+		c.log.Warn("client: reconnect failed", logger.Fields{"attempt": attempt + 1, "error": err})
+	}
+
+	c.log.Error("client: giving up reconnecting", nil)
+
+	c.mu.Lock()
+	if c.stopReconnect == stop {
+		c.transition(StateDisconnected, err)
+	}
+	c.mu.Unlock()
+}
+
+// ResubscribeResult is the outcome for one filter sent again after a connection without a session.
+type ResubscribeResult struct {
+	Filter     string
+	GrantedQoS byte
+	Err        error
+}
+
+// resubscribe replays the SubscriptionManager's subscriptions on n.
+func (c *Client) resubscribe(n *connection) {
+	var subs = c.subscriptions.Replay()
+
+	if len(subs) == 0 {
+		return
+	}
+
+	var results = make([]ResubscribeResult, len(subs))
+	var s = &mqttcodec.Subscribe{}
+
+	for i, sub := range subs {
+		results[i].Filter = sub.Filter
+		s.Subscriptions = append(s.Subscriptions, mqttcodec.SubscribeFilter{
+			Filter:            sub.Filter,
+			QoS:               sub.QoS,
+			NoLocal:           sub.NoLocal,
+			RetainAsPublished: sub.RetainAsPublished,
+			RetainHandling:    sub.RetainHandling,
+		})
+	}
+
+	var codes, err = c.replay(n, s)
+
+	if err == nil && len(codes) != len(subs) {
+		err = fmt.Errorf("SUBACK has %d return codes for %d topic filters", len(codes), len(subs))
+	}
+
+	for i := range results {
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+
+		results[i].GrantedQoS = codes[i]
+
+		if results[i].Err = c.subscriptions.Granted(results[i].Filter, codes[i]); results[i].Err != nil {
+			c.setChannel(results[i].Filter, nil)
+		}
+	}
+
+	if c.options.OnResubscribed != nil {
+		c.options.OnResubscribed(results)
+	}
+}
+
+func (c *Client) replay(n *connection, s *mqttcodec.Subscribe) ([]byte, error) {
+	var ch = make(chan uint16, 1)
+
+	id, err := c.reserve(ch)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer c.release(id, ch)
+
+	s.PacketID = id.Value
+
+	if err = n.write(s); err != nil {
+		return nil, err
+	}
+
+	ack, err := c.await(context.Background(), n, id.Value, ch, mqttcodec.SUBACK)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ack.(*mqttcodec.Suback).ReturnCodes, nil
+}
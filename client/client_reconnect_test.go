@@ -0,0 +1,132 @@
+package client_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/client"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/mqtttest"
+)
+
+func TestReconnect(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+	var lost, reconnected = make(chan error, 1), make(chan bool, 1)
+
+	var c = connect(t, m, func(o *client.ClientOptions) {
+		o.AutoReconnect = true
+		o.InitialReconnectDelay = 10 * time.Millisecond
+		o.MaxReconnectDelay = 50 * time.Millisecond
+		o.OnConnectionLost = func(err error) { lost <- err }
+		o.OnReconnected = func(sessionPresent bool) { reconnected <- sessionPresent }
+	})
+
+	var first = m.NextConn()
+
+	first.Close()
+	next(t, lost)
+
+	if sessionPresent := next(t, reconnected); sessionPresent {
+		t.Fatal("session present on a clean session")
+	}
+
+	// -- the next connection has the same client id and carries the publishes
+	if conn := m.NextConn(); conn.ClientID() != first.ClientID() {
+		t.Fatalf("reconnected as %s, was %s", conn.ClientID(), first.ClientID())
+	}
+
+	if _, err := c.PublishQoS1(timeout(t), "a", nil, client.PublishOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	// --
+
+	// -- Disconnect is not a lost connection, nothing redials it
+	m.Expect(mqttcodec.CONNECT)
+	m.Expect(mqttcodec.CONNECT)
+
+	c.Disconnect()
+
+	m.ExpectNone(mqttcodec.CONNECT, 100*time.Millisecond)
+
+	select {
+	case err := <-lost:
+		t.Fatalf("lost after Disconnect: %v", err)
+	default:
+	}
+	// --
+}
+
+func TestReconnectAttempts(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+
+	connect(t, m, func(o *client.ClientOptions) {
+		o.AutoReconnect = true
+		o.MaxReconnectAttempts = 2
+		o.InitialReconnectDelay = 10 * time.Millisecond
+		o.MaxReconnectDelay = 10 * time.Millisecond
+	})
+
+	m.Expect(mqttcodec.CONNECT)
+	m.Handle(mqttcodec.CONNECT, mqtttest.Hangup)
+	m.NextConn().Close()
+
+	// -- two attempts, then the client gives up
+	m.Expect(mqttcodec.CONNECT)
+	m.Expect(mqttcodec.CONNECT)
+
+	m.ExpectNone(mqttcodec.CONNECT, 200*time.Millisecond)
+	// --
+}
+
+func TestResubscribe(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+	var resubscribed = make(chan []client.ResubscribeResult, 1)
+
+	var c = connect(t, m, func(o *client.ClientOptions) {
+		o.AutoReconnect = true
+		o.InitialReconnectDelay = 10 * time.Millisecond
+		o.OnResubscribed = func(results []client.ResubscribeResult) { resubscribed <- results }
+	})
+
+	var messages = subscribe(t, c, "a/+", client.SubscribeOptions{QoS: 1})
+	var refused = subscribe(t, c, "b", client.SubscribeOptions{QoS: 2})
+
+	m.Expect(mqttcodec.SUBSCRIBE)
+	m.Expect(mqttcodec.SUBSCRIBE)
+
+	// -- the broker lost the session, both filters go out again in one SUBSCRIBE and it refuses the second
+	m.Handle(mqttcodec.SUBSCRIBE, func(c *mqtttest.Conn, p mqttcodec.Packet) mqtttest.Response {
+		return mqtttest.Response{Packets: []mqttcodec.Packet{&mqttcodec.Suback{PacketID: p.(*mqttcodec.Subscribe).PacketID, ReturnCodes: []byte{1, 0x80}}}}
+	})
+
+	m.NextConn().Close()
+
+	var s = m.Expect(mqttcodec.SUBSCRIBE).Packet.(*mqttcodec.Subscribe)
+
+	if len(s.Subscriptions) != 2 || s.Subscriptions[0].Filter != "a/+" || s.Subscriptions[0].QoS != 1 || s.Subscriptions[1].QoS != 2 {
+		t.Fatalf("resubscribed to %+v", s.Subscriptions)
+	}
+
+	var results = next(t, resubscribed)
+
+	if len(results) != 2 || results[0].Err != nil || results[0].GrantedQoS != 1 || results[1].Filter != "b" || results[1].Err == nil {
+		t.Fatalf("results %+v", results)
+	}
+	// --
+
+	// -- the granted filter keeps its handler, the refused one is dropped
+	var conn = m.NextConn()
+
+	conn.Send(&mqttcodec.Publish{TopicName: "b", Payload: []byte("refused")}, &mqttcodec.Publish{TopicName: "a/b", Payload: []byte("granted")})
+
+	if msg := next(t, messages); string(msg.Payload) != "granted" {
+		t.Fatalf("a/+ got %q", msg.Payload)
+	}
+
+	select {
+	case msg := <-refused:
+		t.Fatalf("the refused filter got %q", msg.Payload)
+	default:
+	}
+	// --
+}
@@ -0,0 +1,206 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/MarcusOuelletus/demo/modules/logger"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/reasoncodes"
+)
+
+/*
+A DISCONNECT from the server ends the connection with a *DisconnectError instead of a plain EOF, it carries the
+reason code and the properties the broker sent: Reason String, Server Reference, User Properties. OnDisconnect gets
+the same as a DisconnectEvent before OnConnectionLost runs, it is not called for a connection that just dropped or
+was closed by Disconnect.
+
+With FollowServerReference set, the client goes where a server that sheds load points it to. A CONNACK refusing the
+connection with Use another server (0x9C) or Server moved (0x9D) and a Server Reference is answered by connecting to
+the referenced server right away, up to MaxRedirects (0 is DefaultMaxRedirects) times in a row before Connect or the
+reconnect gives up with the last ConnackError. A DISCONNECT with either code makes the client redial the referenced
+server straight away, without AutoReconnect too. Either way the referenced server is used for every later reconnect,
+and nothing of the session is dropped: the client id, the subscriptions and the Store go along to the new server as
+they would on a reconnect, a new server without the session gets the subscriptions sent again.
+
+The reference is a space separated list of host or host:port, the first one is taken and keeps the scheme, path and
+credentials of Broker, a reference with a scheme of its own replaces Broker entirely. A unix:// broker has no host to
+replace and is never redirected.
+*/
+
+var DefaultMaxRedirects = 5
+
+// DisconnectEvent is a DISCONNECT the server sent.
+type DisconnectEvent struct {
+	ReasonCode      reasoncodes.Code
+	ReasonString    string
+	ServerReference string
+	// Properties are all the DISCONNECT properties, nil when the broker sent none.
+	Properties *mqttcodec.Properties
+	// Redirect is the broker the client redials with FollowServerReference, empty when it does not.
+	Redirect string
+}
+
+type DisconnectError struct {
+	DisconnectEvent
+}
+
+func (e *DisconnectError) Error() string {
+	var s = fmt.Sprintf("broker disconnected with reason code 0x%02X %s", byte(e.ReasonCode), e.ReasonCode.Name(byte(mqttcodec.DISCONNECT)))
+
+	if e.ReasonString != "" {
+		s += ": " + e.ReasonString
+	}
+
+	return s
+}
+
+// disconnected turns a DISCONNECT from the server into the error that closes the connection.
+func (c *Client) disconnected(p *mqttcodec.Disconnection) *DisconnectError {
+	var e = &DisconnectError{DisconnectEvent{ReasonCode: reasoncodes.Code(p.ReasonCode), Properties: p.Properties}}
+
+	if p.Properties != nil {
+		e.ReasonString = p.Properties.ReasonString
+		e.ServerReference = p.Properties.ServerReference
+	}
+
+	redirect, ok := c.followReference(e.ReasonCode, e.ServerReference)
+
+	if !ok {
+		return e
+	}
+
+	e.Redirect = redirect
+
+	return e
+}
+
+// connect opens a connection, following the Server Reference of a CONNACK that sends the client elsewhere.
+func (c *Client) connect(stop chan struct{}) error {
+	var max = c.options.MaxRedirects
+
+	if max <= 0 {
+		max = DefaultMaxRedirects
+	}
+
+	for redirects := 0; ; redirects++ {
+		var err = c.open(stop)
+		var e *ConnackError
+
+		// -- a broker that only speaks 3.1.1 gets the CONNECT again in 3.1.1, once
+		if c.downgrade(err) {
+			err = c.open(stop)
+		}
+		// --
+
+		if redirects == max || !errors.As(err, &e) || e.Properties == nil {
+			return err
+		}
+
+		redirect, ok := c.followReference(reasoncodes.Code(e.ReturnCode), e.Properties.ServerReference)
+
+		if !ok {
+			return err
+		}
+
+		c.mu.Lock()
+		c.redirect = redirect
+		c.mu.Unlock()
+
+		c.log.Info("client: following the server reference", logger.Fields{"broker": redirect, "reason_code": e.ReturnCode})
+	}
+}
+
+// followReference is the broker to go to for a Server Reference sent with code, ok is false when there is none.
+func (c *Client) followReference(code reasoncodes.Code, reference string) (string, bool) {
+	if !c.options.FollowServerReference || reference == "" {
+		return "", false
+	}
+
+	if code != reasoncodes.UseAnotherServer && code != reasoncodes.ServerMoved {
+		return "", false
+	}
+
+	redirect, err := serverReference(c.broker(), reference)
+
+	if err != nil {
+		c.log.Warn("client: cannot follow the server reference", logger.Fields{"server_reference": reference, "error": err})
+		return "", false
+	}
+
+	return redirect, true
+}
+
+// disconnectEvent reports a DISCONNECT from the server to OnDisconnect and switches to the server it redirected to,
+// it tells whether the client should redial at once.
+func (c *Client) disconnectEvent(err error) bool {
+	var e *DisconnectError
+
+	if !errors.As(err, &e) {
+		return false
+	}
+
+	if e.Redirect != "" {
+		c.mu.Lock()
+		c.redirect = e.Redirect
+		c.mu.Unlock()
+
+		c.log.Info("client: following the server reference", logger.Fields{"broker": e.Redirect, "reason_code": byte(e.ReasonCode)})
+	}
+
+	if c.options.OnDisconnect != nil {
+		c.options.OnDisconnect(e.DisconnectEvent)
+	}
+
+	return e.Redirect != ""
+}
+
+// broker is the broker connect dials, Broker unless a server reference was followed.
+func (c *Client) broker() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.redirect != "" {
+		return c.redirect
+	}
+
+	return c.options.Broker
+}
+
+// serverReference is the broker address the first reference of a Server Reference stands for, relative to broker.
+func serverReference(broker, reference string) (string, error) {
+	var fields = strings.Fields(reference)
+
+	if len(fields) == 0 {
+		return "", fmt.Errorf("empty server reference %q", reference)
+	}
+
+	var ref = fields[0]
+
+	if strings.Contains(ref, "://") {
+		if _, err := parseBroker(ref); err != nil {
+			return "", err
+		}
+		return ref, nil
+	}
+
+	if !strings.Contains(broker, "://") {
+		return ref, nil
+	}
+
+	u, err := url.Parse(broker)
+
+	if err != nil {
+		return "", err
+	}
+
+	if strings.ToLower(u.Scheme) == "unix" {
+		return "", fmt.Errorf("broker %q is a Unix socket", broker)
+	}
+
+	u.Host = ref
+
+	return u.String(), nil
+}
@@ -0,0 +1,153 @@
+package client_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/MarcusOuelletus/demo/client"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/mqtttest"
+	"github.com/MarcusOuelletus/demo/reasoncodes"
+)
+
+func TestServerDisconnect(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+	var events, lost = make(chan client.DisconnectEvent, 1), make(chan error, 1)
+
+	var c = connect(t, m, func(o *client.ClientOptions) {
+		o.ProtocolVersion = mqttcodec.Version5
+		o.OnDisconnect = func(e client.DisconnectEvent) { events <- e }
+		o.OnConnectionLost = func(err error) { lost <- err }
+	})
+
+	// -- the DISCONNECT reaches OnDisconnect, then OnConnectionLost as a *DisconnectError
+	m.NextConn().Send(&mqttcodec.Disconnection{
+		ReasonCode: byte(reasoncodes.ServerShuttingDown),
+		Properties: &mqttcodec.Properties{ReasonString: "maintenance", UserProperties: []mqttcodec.UserProperty{{Key: "k", Value: "v"}}},
+	})
+
+	var e = next(t, events)
+
+	if e.ReasonCode != reasoncodes.ServerShuttingDown || e.ReasonString != "maintenance" || len(e.Properties.UserProperties) != 1 || e.Redirect != "" {
+		t.Fatalf("event %+v", e)
+	}
+
+	var disconnected *client.DisconnectError
+
+	if err := next(t, lost); !errors.As(err, &disconnected) || disconnected.ReasonCode != reasoncodes.ServerShuttingDown {
+		t.Fatalf("lost with %v", err)
+	}
+	// --
+
+	// -- a connection that drops or is closed by Disconnect is no DisconnectEvent
+	c.Disconnect()
+
+	var dropped = connect(t, m, func(o *client.ClientOptions) {
+		o.OnDisconnect = func(e client.DisconnectEvent) { events <- e }
+		o.OnConnectionLost = func(err error) { lost <- err }
+	})
+
+	m.NextConn().Close()
+
+	if err := next(t, lost); errors.As(err, &disconnected) {
+		t.Fatalf("dropped with %v", err)
+	}
+
+	dropped.Disconnect()
+
+	select {
+	case e := <-events:
+		t.Fatalf("event %+v", e)
+	default:
+	}
+	// --
+}
+
+func TestServerReference(t *testing.T) {
+	var m, other = mqtttest.NewMockBroker(t), mqtttest.NewMockBroker(t)
+	var events, reconnected = make(chan client.DisconnectEvent, 1), make(chan bool, 1)
+
+	var c = connect(t, m, func(o *client.ClientOptions) {
+		o.ProtocolVersion = mqttcodec.Version5
+		o.CleanSession = false
+		o.FollowServerReference = true
+		o.OnDisconnect = func(e client.DisconnectEvent) { events <- e }
+		o.OnReconnected = func(sessionPresent bool) { reconnected <- sessionPresent }
+	})
+
+	subscribe(t, c, "a", client.SubscribeOptions{QoS: 1})
+
+	// -- a DISCONNECT with Use another server sends the client to the first reference, without AutoReconnect
+	var first = m.NextConn()
+
+	first.Send(&mqttcodec.Disconnection{
+		ReasonCode: byte(reasoncodes.UseAnotherServer),
+		Properties: &mqttcodec.Properties{ServerReference: strings.TrimPrefix(other.Address(), "tcp://") + " elsewhere:1883"},
+	})
+
+	if e := next(t, events); e.Redirect != other.Address() {
+		t.Fatalf("redirected to %q", e.Redirect)
+	}
+
+	next(t, reconnected)
+	// --
+
+	// -- the session goes along: the same client id, and the subscription for a server without it
+	if conn := other.NextConn(); conn.ClientID() != first.ClientID() {
+		t.Fatalf("redirected as %s, was %s", conn.ClientID(), first.ClientID())
+	}
+
+	if s := other.Expect(mqttcodec.SUBSCRIBE).Packet.(*mqttcodec.Subscribe); s.Subscriptions[0].Filter != "a" {
+		t.Fatalf("resubscribed to %+v", s.Subscriptions)
+	}
+	// --
+}
+
+func TestConnackRedirect(t *testing.T) {
+	var m, other = mqtttest.NewMockBroker(t), mqtttest.NewMockBroker(t)
+
+	m.Handle(mqttcodec.CONNECT, mqtttest.Redirect(reasoncodes.ServerMoved, other.Address()))
+
+	var c = connect(t, m, func(o *client.ClientOptions) {
+		o.ProtocolVersion = mqttcodec.Version5
+		o.FollowServerReference = true
+	})
+
+	if _, err := c.PublishQoS1(timeout(t), "a", nil, client.PublishOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	other.Expect(mqttcodec.PUBLISH)
+
+	// -- a broker that keeps redirecting is followed MaxRedirects times, then Connect fails with its CONNACK
+	var loop = mqtttest.NewMockBroker(t)
+
+	loop.Handle(mqttcodec.CONNECT, mqtttest.Redirect(reasoncodes.UseAnotherServer, loop.Address()))
+
+	var options = loop.Options("")
+
+	options.ProtocolVersion = mqttcodec.Version5
+	options.FollowServerReference = true
+	options.MaxRedirects = 3
+
+	var refused *client.ConnackError
+
+	if err := client.New(options).Connect(); !errors.As(err, &refused) || refused.ReturnCode != byte(reasoncodes.UseAnotherServer) {
+		t.Fatalf("connect: %v", err)
+	}
+
+	if n := len(loop.Received()); n != 4 {
+		t.Fatalf("%d CONNECTs", n)
+	}
+	// --
+
+	// -- without FollowServerReference the redirect is a refused connection
+	options = m.Options("")
+	options.ProtocolVersion = mqttcodec.Version5
+
+	if err := client.New(options).Connect(); !errors.As(err, &refused) || refused.ReturnCode != byte(reasoncodes.ServerMoved) {
+		t.Fatalf("connect: %v", err)
+	}
+	// --
+}
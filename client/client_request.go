@@ -0,0 +1,189 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/broadcast"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/packetids"
+)
+
+/*
+Request is MQTT 5 request/response: the request goes out with a Response Topic and Correlation Data, the responder
+publishes its reply to that topic with the same Correlation Data, and Request returns the reply.
+
+The first Request subscribes the client to its response topic, the broker's Response Information (when ConnectProperties
+asked for it with Request Response Information) or DefaultResponseTopicPrefix, followed by a random segment so two
+clients never share one. The subscription stays for the life of the Client and is replayed like any other. Correlation
+works like the acknowledgements of our publishes: every request takes an id from its own PacketIDs and listens for it
+on its own ResponseBroadcaster, the Correlation Data is a random per Client prefix followed by that id, and the
+response topic's handler parks the reply under the id and notifies the listener. A reply nobody waits for any more,
+after ctx ended or a duplicate, is dropped, as is anything on the response topic with Correlation Data that is not ours.
+*/
+
+var ErrRequestNeedsMQTT5 = errors.New("request/response needs an MQTT 5 connection")
+
+var DefaultResponseTopicPrefix = "responses"
+
+type RequestOptions struct {
+	// QoS is the QoS of the request, the response topic is always subscribed with QoS 1.
+	QoS            byte
+	MessageExpiry  time.Duration
+	ContentType    string
+	UserProperties []KeyValue
+}
+
+// Response is the reply to a Request, its Properties carry the responder's Content Type, User Properties...
+type Response struct {
+	Message
+}
+
+type requester struct {
+	ids         *packetids.PacketIDs
+	broadcaster *broadcast.ResponseBroadcaster
+	prefix      []byte
+
+	// topicMu is held while the response topic is subscribed, mu never is, the read loop takes it in respond.
+	topicMu   sync.Mutex
+	topic     string
+	mu        sync.Mutex
+	responses map[uint16]Message
+}
+
+func newRequester() *requester {
+	var prefix = make([]byte, 8)
+
+	if _, err := rand.Read(prefix); err != nil {
+		panic(err)
+	}
+
+	return &requester{
+		ids:         packetids.New(),
+		broadcaster: broadcast.NewResponseBroadcaster(),
+		prefix:      prefix,
+		responses:   make(map[uint16]Message),
+	}
+}
+
+// Request publishes payload to requestTopic and waits for the response or for ctx to be done.
+func (c *Client) Request(ctx context.Context, requestTopic string, payload []byte, opts RequestOptions) (Response, error) {
+	if c.version() != mqttcodec.Version5 {
+		return Response{}, ErrRequestNeedsMQTT5
+	}
+
+	topic, err := c.responseTopic(ctx)
+
+	if err != nil {
+		return Response{}, err
+	}
+
+	var r = c.requests
+	var ch = make(chan uint16, 1)
+	var id = r.ids.Reserve()
+
+	if err = r.broadcaster.AddListener(id.Value, ch); err != nil {
+		r.ids.Release(id.GetBytes())
+		return Response{}, err
+	}
+
+	defer func() {
+		r.broadcaster.RemoveAndCloseListener(id.Value, ch)
+
+		r.mu.Lock()
+		delete(r.responses, id.Value)
+		r.mu.Unlock()
+
+		r.ids.Release(id.GetBytes())
+	}()
+
+	err = c.Publish(ctx, requestTopic, payload, PublishOptions{
+		QoS:             opts.QoS,
+		MessageExpiry:   opts.MessageExpiry,
+		ContentType:     opts.ContentType,
+		ResponseTopic:   topic,
+		CorrelationData: binary.BigEndian.AppendUint16(append([]byte(nil), r.prefix...), id.Value),
+		UserProperties:  opts.UserProperties,
+	})
+
+	if err != nil {
+		return Response{}, err
+	}
+
+	select {
+	case <-ch:
+		r.mu.Lock()
+		var msg = r.responses[id.Value]
+		r.mu.Unlock()
+
+		return Response{Message: msg}, nil
+	case <-ctx.Done():
+		return Response{}, ctx.Err()
+	}
+}
+
+// responseTopic subscribes the response topic the first time it is needed, a failed subscribe is tried again next time.
+func (c *Client) responseTopic(ctx context.Context) (string, error) {
+	var r = c.requests
+
+	r.topicMu.Lock()
+	defer r.topicMu.Unlock()
+
+	if r.topic != "" {
+		return r.topic, nil
+	}
+
+	c.mu.Lock()
+	var base = c.responseInformation
+	c.mu.Unlock()
+
+	if base == "" {
+		base = DefaultResponseTopicPrefix
+	}
+
+	var topic = base + "/" + GenerateClientID()
+
+	if err := c.Subscribe(ctx, topic, SubscribeOptions{QoS: 1}, r.respond); err != nil {
+		return "", err
+	}
+
+	r.topic = topic
+
+	return topic, nil
+}
+
+// respond hands a message on the response topic to the Request waiting for its Correlation Data.
+func (r *requester) respond(msg Message) {
+	if msg.Properties == nil {
+		return
+	}
+
+	var data = msg.Properties.CorrelationData
+
+	if len(data) != len(r.prefix)+2 || !bytes.Equal(data[:len(r.prefix)], r.prefix) {
+		return
+	}
+
+	var id = binary.BigEndian.Uint16(data[len(r.prefix):])
+
+	// -- a duplicate must not replace the reply that is already parked and notified
+	r.mu.Lock()
+	if _, ok := r.responses[id]; ok {
+		r.mu.Unlock()
+		return
+	}
+	r.responses[id] = msg
+	r.mu.Unlock()
+	// --
+
+	if !r.broadcaster.Notify(id, 0) {
+		r.mu.Lock()
+		delete(r.responses, id)
+		r.mu.Unlock()
+	}
+}
@@ -0,0 +1,126 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/broker"
+	"github.com/MarcusOuelletus/demo/brokertest"
+	"github.com/MarcusOuelletus/demo/client"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/mqtttest"
+)
+
+func TestRequest(t *testing.T) {
+	var s = brokertest.Start(t, broker.Options{})
+	var version5 = func(o *client.ClientOptions) { o.ProtocolVersion = mqttcodec.Version5 }
+	var requester, responder = s.Client("requester", version5), s.Client("responder", version5)
+
+	// -- the responder answers on the Response Topic with the Correlation Data of the request
+	var answer = func(m client.Message) {
+		go responder.Client.Publish(context.Background(), m.Properties.ResponseTopic, append([]byte("re: "), m.Payload...), client.PublishOptions{
+			QoS:             1,
+			CorrelationData: m.Properties.CorrelationData,
+		})
+	}
+
+	if err := responder.Client.Subscribe(timeout(t), "service", client.SubscribeOptions{QoS: 1}, answer); err != nil {
+		t.Fatal(err)
+	}
+	// --
+
+	// -- concurrent requests each get their own reply
+	var wg sync.WaitGroup
+
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			var payload = fmt.Sprintf("request %d", i)
+
+			response, err := requester.Request(timeout(t), "service", []byte(payload), client.RequestOptions{QoS: 1})
+
+			if err != nil {
+				t.Errorf("%s: %v", payload, err)
+				return
+			}
+
+			if string(response.Payload) != "re: "+payload || !strings.HasPrefix(response.Topic, client.DefaultResponseTopicPrefix+"/") {
+				t.Errorf("%s: got %q on %s", payload, response.Payload, response.Topic)
+			}
+		}()
+	}
+
+	wg.Wait()
+	// --
+
+	// -- without a responder ctx ends the wait
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := requester.Request(ctx, "nobody", nil, client.RequestOptions{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("request without a responder: %v", err)
+	}
+	// --
+
+	// -- 3.1.1 has no Response Topic
+	if _, err := s.Client("", nil).Request(timeout(t), "service", nil, client.RequestOptions{}); !errors.Is(err, client.ErrRequestNeedsMQTT5) {
+		t.Fatalf("request on 3.1.1: %v", err)
+	}
+	// --
+}
+
+func TestRequestResponseInformation(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+
+	m.Handle(mqttcodec.CONNECT, mqtttest.Reply(&mqttcodec.Connack{Properties: &mqttcodec.Properties{ResponseInformation: "replies"}}))
+
+	var c = connect(t, m, func(o *client.ClientOptions) { o.ProtocolVersion = mqttcodec.Version5 })
+	var responses = make(chan client.Response, 1)
+
+	go func() {
+		response, err := c.Request(timeout(t), "service", []byte("ping"), client.RequestOptions{ContentType: "text/plain"})
+
+		if err != nil {
+			t.Error(err)
+		}
+
+		responses <- response
+	}()
+
+	// -- the response topic is under the broker's Response Information
+	var s = m.Expect(mqttcodec.SUBSCRIBE).Packet.(*mqttcodec.Subscribe)
+	var request = m.Expect(mqttcodec.PUBLISH).Packet.(*mqttcodec.Publish)
+
+	if topic := request.Properties.ResponseTopic; !strings.HasPrefix(topic, "replies/") || s.Subscriptions[0].Filter != topic {
+		t.Fatalf("response topic %s, subscribed %s", topic, s.Subscriptions[0].Filter)
+	}
+
+	if request.Properties.ContentType != "text/plain" {
+		t.Fatalf("content type %q", request.Properties.ContentType)
+	}
+	// --
+
+	// -- a reply with another Correlation Data is not the response
+	var reply = func(payload string, correlation []byte) *mqttcodec.Publish {
+		return &mqttcodec.Publish{
+			TopicName:  request.Properties.ResponseTopic,
+			Payload:    []byte(payload),
+			Properties: &mqttcodec.Properties{CorrelationData: correlation},
+		}
+	}
+
+	m.NextConn().Send(reply("foreign", []byte("someone else")), reply("pong", request.Properties.CorrelationData))
+
+	if response := next(t, responses); string(response.Payload) != "pong" {
+		t.Fatalf("response %q", response.Payload)
+	}
+	// --
+}
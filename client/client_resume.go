@@ -0,0 +1,307 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/MarcusOuelletus/demo/packetids"
+	"github.com/MarcusOuelletus/demo/session"
+)
+
+/*
+The first Connect picks up whatever a previous process left in the Store, and every connection picks up the flows the
+one before it lost.
+
+Before dialing, the packet ids of the stored inflight messages are taken out of PacketIDs, so no new publish can be
+handed one of them. Once connected, every inflight message is resumed with its original packet id: a QoS 1 message or
+a QoS 2 message without its PUBREC is resent with DUP, a QoS 2 message that got its PUBREC only sends the PUBREL. The
+queued messages never had a packet id and are published afresh, in the order they were accepted. Resumed flows count
+against no send quota. A flow whose connection is lost, first run or resumed, stays in the Store and is resumed on the
+next connection the same way, only its final acknowledgement (or a failure on a connection that is still open, such as
+ctx ending the wait) removes the message.
+
+The inbound QoS 2 packet ids that got their PUBREC but no PUBREL yet are kept in the Store as well, as messages without
+a topic under "received/<packet id>", so a message the server sends again after a restart is not delivered twice.
+
+A client without a ClientID keeps the Assigned Client Identifier of its MQTT 5 broker under "client-id", every
+reconnect uses it and so does a restart with the same Store, the broker finds the session under it again.
+*/
+
+const (
+	queuedPrefix   = "queued/"
+	inflightPrefix = "inflight/"
+	receivedPrefix = "received/"
+	clientIDKey    = "client-id"
+)
+
+func inflightKey(id uint16) string {
+	return fmt.Sprintf("%s%05d", inflightPrefix, id)
+}
+
+func (c *Client) queuedKey() string {
+	return fmt.Sprintf("%s%020d", queuedPrefix, atomic.AddUint64(&c.queued, 1))
+}
+
+// restore reserves the packet ids of the stored inflight messages, it only runs once per Client.
+func (c *Client) restore() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.restored {
+		return nil
+	}
+
+	inflight, err := c.store.Keys(inflightPrefix)
+
+	if err != nil {
+		return err
+	}
+
+	queued, err := c.store.Keys(queuedPrefix)
+
+	if err != nil {
+		return err
+	}
+
+	var stored = make(map[uint16]bool, len(inflight))
+	var max uint16
+
+	for _, key := range inflight {
+		id, err := strconv.ParseUint(strings.TrimPrefix(key, inflightPrefix), 10, 16)
+
+		if err != nil || id == 0 {
+			return fmt.Errorf("store has an inflight message under the malformed key %q", key)
+		}
+
+		stored[uint16(id)] = true
+
+		if uint16(id) > max {
+			max = uint16(id)
+		}
+	}
+
+	// -- nothing took an id yet, so Reserve counts up from 1 and the ids that are not stored go straight back
+	var free [][2]byte
+
+	for i := uint16(1); i <= max && i != 0; i++ {
+		var id = c.ids.Reserve()
+
+		if !stored[id.Value] {
+			free = append(free, id.GetBytes())
+		}
+	}
+
+	for i := len(free) - 1; i >= 0; i-- {
+		c.ids.Release(free[i])
+	}
+	// --
+
+	for _, key := range queued {
+		seq, err := strconv.ParseUint(strings.TrimPrefix(key, queuedPrefix), 10, 64)
+
+		if err == nil && seq > c.queued {
+			c.queued = seq
+		}
+	}
+
+	// -- a client without a ClientID goes back to the id the broker assigned to the previous process
+	if c.options.ClientID == "" {
+		assigned, err := c.store.Get(clientIDKey)
+
+		switch {
+		case err == nil:
+			c.clientID = string(assigned.Payload)
+		case !errors.Is(err, ErrMessageNotFound):
+			return err
+		}
+	}
+	// --
+
+	if c.inboundQoS2, err = session.NewQoS2Dedup(c.clientID, &receivedStore{store: c.store}); err != nil {
+		return err
+	}
+
+	c.pendingInflight, c.pendingQueued, c.restored = inflight, queued, true
+
+	return nil
+}
+
+// resume starts the flows of the restored and the postponed messages on the connection that was just opened.
+func (c *Client) resume() {
+	c.mu.Lock()
+	var inflight, queued = c.pendingInflight, c.pendingQueued
+	c.pendingInflight, c.pendingQueued = nil, nil
+	c.mu.Unlock()
+
+	// -- postponed keys are appended as flows fail, the zero padded keys sort back into publish order
+	sort.Strings(inflight)
+	sort.Strings(queued)
+	// --
+
+	for _, key := range inflight {
+		go c.resumeInflight(key)
+	}
+
+	if len(queued) > 0 {
+		go c.resumeQueued(queued)
+	}
+}
+
+func (c *Client) resumeInflight(key string) {
+	msg, err := c.store.Get(key)
+
+	if err != nil {
+		return
+	}
+
+	var ch = make(chan uint16, 1)
+	var id = packetids.NewPacketID(msg.PacketID)
+
+	if err = c.broadcaster.AddListener(msg.PacketID, ch); err != nil {
+		c.release(id, ch)
+		return
+	}
+
+	n, err := c.current()
+
+	if err == nil {
+		_, err = c.run(context.Background(), n, ch, key, msg, true)
+	}
+
+	if c.settle(n, key, err) {
+		c.broadcaster.RemoveAndCloseListener(msg.PacketID, ch)
+	} else {
+		c.release(id, ch)
+	}
+}
+
+func (c *Client) resumeQueued(keys []string) {
+	for i, key := range keys {
+		msg, err := c.store.Get(key)
+
+		if err != nil {
+			continue
+		}
+
+		n, err := c.current()
+
+		if err != nil {
+			c.postpone(nil, nil, keys[i:])
+			return
+		}
+
+		// -- publishStored moves the message from its queued key to an inflight one, it is settled from there
+		msg.PacketID, msg.Pubrel = 0, false
+		c.publishStored(context.Background(), n, key, msg)
+		// --
+	}
+}
+
+// settle ends the flow of the message stored under key and reports whether it goes on. A flow that got its final
+// acknowledgement, with a failure reason code or not, or that failed on a connection that is still open leaves the
+// Store. A flow whose connection was lost, n is nil when there was none, is postponed to the next connection and
+// keeps its packet id.
+func (c *Client) settle(n *connection, key string, err error) bool {
+	var publishErr *PublishError
+
+	if err == nil || errors.As(err, &publishErr) || (n != nil && !n.closed()) {
+		c.store.Delete(key)
+		return false
+	}
+
+	c.postpone(n, []string{key}, nil)
+
+	return true
+}
+
+// postpone hands the keys of flows that could not run on n to the next connection, right away when that one is up
+// already and its resume ran before the keys got here.
+func (c *Client) postpone(n *connection, inflight, queued []string) {
+	c.mu.Lock()
+	c.pendingInflight = append(c.pendingInflight, inflight...)
+	c.pendingQueued = append(c.pendingQueued, queued...)
+	var up = c.conn != nil && c.conn != n && !c.conn.closed()
+	c.mu.Unlock()
+
+	if up {
+		c.resume()
+	}
+}
+
+// receivedStore is the session.SessionStore of the client's QoS2Dedup, it keeps PendingQoS2 and nothing else of the
+// state in the Store.
+type receivedStore struct {
+	store Store
+}
+
+func (r *receivedStore) Save(state *session.SessionState) error {
+	return r.Update(state.ClientID, func(s *session.SessionState) { s.PendingQoS2 = state.PendingQoS2 })
+}
+
+func (r *receivedStore) Load(clientID string) (*session.SessionState, error) {
+	keys, err := r.store.Keys(receivedPrefix)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var state = &session.SessionState{ClientID: clientID}
+
+	for _, key := range keys {
+		id, err := strconv.ParseUint(strings.TrimPrefix(key, receivedPrefix), 10, 16)
+
+		if err != nil || id == 0 {
+			return nil, fmt.Errorf("store has a received packet id under the malformed key %q", key)
+		}
+
+		state.PendingQoS2 = append(state.PendingQoS2, uint16(id))
+	}
+
+	return state, nil
+}
+
+// Update writes only the packet ids that changed, the QoS2Dedup's lock keeps updates from running concurrently.
+func (r *receivedStore) Update(clientID string, fn func(state *session.SessionState)) error {
+	state, err := r.Load(clientID)
+
+	if err != nil {
+		return err
+	}
+
+	var old = make(map[uint16]bool, len(state.PendingQoS2))
+
+	for _, id := range state.PendingQoS2 {
+		old[id] = true
+	}
+
+	fn(state)
+
+	for _, id := range state.PendingQoS2 {
+		if old[id] {
+			delete(old, id)
+		} else if err = r.store.Put(receivedKey(id), &StoredMessage{QoS: 2, PacketID: id}); err != nil {
+			return err
+		}
+	}
+
+	for id := range old {
+		if err = r.store.Delete(receivedKey(id)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *receivedStore) Delete(clientID string) error {
+	return r.Update(clientID, func(state *session.SessionState) { state.PendingQoS2 = nil })
+}
+
+func receivedKey(id uint16) string {
+	return fmt.Sprintf("%s%05d", receivedPrefix, id)
+}
@@ -0,0 +1,85 @@
+package client_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/client"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/mqtttest"
+)
+
+func TestResumeStore(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+	var store = client.NewMemoryStore()
+
+	// -- what a previous process left: a QoS 1 flow, a QoS 2 flow past its PUBREC and a message waiting for quota
+	store.Put("inflight/00005", &client.StoredMessage{Topic: "five", QoS: 1, PacketID: 5})
+	store.Put("inflight/00007", &client.StoredMessage{Topic: "seven", QoS: 2, PacketID: 7, Pubrel: true})
+	store.Put("queued/00000000000000000003", &client.StoredMessage{Topic: "queued", QoS: 1})
+	// --
+
+	m.Handle(mqttcodec.CONNECT, mqtttest.Reply(&mqttcodec.Connack{SessionPresent: true}))
+
+	var c = client.New(client.ClientOptions{Broker: m.Address(), ClientID: "c", Store: store})
+
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	// -- the QoS 1 message is resent with DUP, the QoS 2 one only sends its PUBREL, the queued one gets a new packet id
+	var published = make(map[string]*mqttcodec.Publish)
+
+	for i := 0; i < 2; i++ {
+		var p = m.Expect(mqttcodec.PUBLISH).Packet.(*mqttcodec.Publish)
+		published[p.TopicName] = p
+	}
+
+	if p := published["five"]; p == nil || p.PacketID != 5 || !p.Dup {
+		t.Fatalf("resumed %+v", p)
+	}
+
+	if p := published["queued"]; p == nil || p.PacketID == 5 || p.PacketID == 7 || p.Dup {
+		t.Fatalf("published %+v", p)
+	}
+
+	if a := m.Expect(mqttcodec.PUBREL).Packet.(*mqttcodec.Ack); a.PacketID != 7 {
+		t.Fatalf("PUBREL %d", a.PacketID)
+	}
+	// --
+
+	// -- Close waits for the flows to end, their acknowledgements empty the Store
+	if err := c.Close(timeout(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	if keys, _ := store.Keys(""); len(keys) != 0 {
+		t.Fatalf("left in the store: %v", keys)
+	}
+	// --
+}
+
+func TestResumeAfterConnectionLoss(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+
+	// -- the first PUBLISH loses the connection, every CONNECT after the first resumes the session
+	m.Handle(mqttcodec.PUBLISH, mqtttest.Script(mqtttest.Hangup))
+	m.Handle(mqttcodec.CONNECT, mqtttest.Script(mqtttest.Default, mqtttest.Reply(&mqttcodec.Connack{SessionPresent: true})))
+	// --
+
+	var c = connect(t, m, func(o *client.ClientOptions) {
+		o.CleanSession = false
+		o.AutoReconnect = true
+		o.InitialReconnectDelay = 10 * time.Millisecond
+	})
+
+	if _, err := c.PublishQoS1(timeout(t), "a", []byte("x"), client.PublishOptions{}); err == nil {
+		t.Fatal("PublishQoS1 returned without error on a lost connection")
+	}
+
+	var first = m.Expect(mqttcodec.PUBLISH).Packet.(*mqttcodec.Publish)
+
+	if resumed := m.Expect(mqttcodec.PUBLISH).Packet.(*mqttcodec.Publish); !resumed.Dup || resumed.PacketID != first.PacketID {
+		t.Fatalf("resumed packet id %d with dup %v, was %d", resumed.PacketID, resumed.Dup, first.PacketID)
+	}
+}
@@ -0,0 +1,154 @@
+package client
+
+import (
+	"fmt"
+	"sync"
+)
+
+/*
+A Client is always in one of five states, State returns it and WatchState hands out every transition as it happens:
+
+	Disconnected  not connected, before the first Connect or after a lost connection that is not redialed (no
+	              AutoReconnect, or MaxReconnectAttempts used up)
+	Connecting    Connect is dialing, redirects of a CONNACK included
+	Connected     the CONNACK was accepted, also after a reconnect
+	Reconnecting  the connection was lost and AutoReconnect (or a followed Server Reference) is redialing
+	Terminated    Disconnect or Kill ended the connection or the reconnect
+
+Connect starts over from Disconnected and from Terminated alike, Terminated only says the application ended it on
+purpose. The transitions are made under the client's lock together with the change they describe, so they come out in
+the order they happened and State never contradicts the last one delivered. Every watcher has a queue of its own that
+grows as needed, a slow watcher neither loses transitions nor holds the client up, stop closes its channel.
+*/
+
+type ConnectionState int
+
+const (
+	StateDisconnected ConnectionState = iota
+	StateConnecting
+	StateConnected
+	StateReconnecting
+	StateTerminated
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case StateDisconnected:
+		return "disconnected"
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateTerminated:
+		return "terminated"
+	}
+
+	return fmt.Sprintf("ConnectionState(%d)", int(s))
+}
+
+// StateChange is one transition, Err is what ended the connection or made Connect fail, nil otherwise.
+type StateChange struct {
+	From ConnectionState
+	To   ConnectionState
+	Err  error
+}
+
+type stateWatcher struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   []StateChange
+	stopped bool
+	ch      chan StateChange
+}
+
+// State returns the state the client is in.
+func (c *Client) State() ConnectionState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.state
+}
+
+// WatchState returns a channel that gets every state transition from now on, stop ends the watch and closes it.
+func (c *Client) WatchState() (<-chan StateChange, func()) {
+	var w = &stateWatcher{ch: make(chan StateChange)}
+	w.cond = sync.NewCond(&w.mu)
+
+	c.mu.Lock()
+	c.watchers[w] = struct{}{}
+	c.mu.Unlock()
+
+	var done = make(chan struct{})
+
+	go w.run(done)
+
+	var once sync.Once
+
+	var stop = func() {
+		once.Do(func() {
+			c.mu.Lock()
+			delete(c.watchers, w)
+			c.mu.Unlock()
+
+			w.mu.Lock()
+			w.stopped = true
+			w.cond.Broadcast()
+			w.mu.Unlock()
+
+			close(done)
+		})
+	}
+
+	return w.ch, stop
+}
+
+// transition moves the client to state to, c.mu has to be held.
+func (c *Client) transition(to ConnectionState, err error) {
+	if c.state == to {
+		return
+	}
+
+	var change = StateChange{From: c.state, To: to, Err: err}
+	c.state = to
+
+	for w := range c.watchers {
+		w.push(change)
+	}
+}
+
+func (w *stateWatcher) push(change StateChange) {
+	w.mu.Lock()
+	w.queue = append(w.queue, change)
+	w.cond.Signal()
+	w.mu.Unlock()
+}
+
+// run hands the queued transitions to the channel in order until the watch is stopped.
+func (w *stateWatcher) run(done chan struct{}) {
+	defer close(w.ch)
+
+	for {
+		w.mu.Lock()
+
+		for len(w.queue) == 0 && !w.stopped {
+			w.cond.Wait()
+		}
+
+		if w.stopped {
+			w.mu.Unlock()
+			return
+		}
+
+		var change = w.queue[0]
+		w.queue = w.queue[1:]
+		w.mu.Unlock()
+
+		select {
+		case w.ch <- change:
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package client_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/client"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/mqtttest"
+	"github.com/MarcusOuelletus/demo/reasoncodes"
+)
+
+// transitions takes the next transitions of ch, it fails the test when they do not go to states in that order.
+func transitions(t *testing.T, ch <-chan client.StateChange, states ...client.ConnectionState) {
+	t.Helper()
+
+	for _, state := range states {
+		if change := next(t, ch); change.To != state {
+			t.Fatalf("went from %v to %v (%v), want %v", change.From, change.To, change.Err, state)
+		}
+	}
+}
+
+func TestState(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+	var options = m.Options("")
+
+	options.AutoReconnect = true
+	options.InitialReconnectDelay = 10 * time.Millisecond
+
+	var c = client.New(options)
+	var changes, stop = c.WatchState()
+
+	defer stop()
+
+	if c.State() != client.StateDisconnected {
+		t.Fatalf("a new client is %v", c.State())
+	}
+
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	transitions(t, changes, client.StateConnecting, client.StateConnected)
+
+	m.NextConn().Close()
+	transitions(t, changes, client.StateReconnecting, client.StateConnected)
+
+	c.Disconnect()
+	transitions(t, changes, client.StateTerminated)
+
+	if c.State() != client.StateTerminated {
+		t.Fatalf("%v after Disconnect", c.State())
+	}
+
+	// -- Connect starts over from Terminated, Kill terminates too
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	transitions(t, changes, client.StateConnecting, client.StateConnected)
+
+	c.Kill()
+	transitions(t, changes, client.StateTerminated)
+	// --
+}
+
+func TestStateLostAndRefused(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+	var c = client.New(m.Options(""))
+	var changes, stop = c.WatchState()
+
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	transitions(t, changes, client.StateConnecting, client.StateConnected)
+
+	// -- without AutoReconnect a lost connection stays Disconnected
+	m.NextConn().Close()
+	transitions(t, changes, client.StateDisconnected)
+	// --
+
+	// -- a refused Connect goes back to Disconnected with the error
+	m.Handle(mqttcodec.CONNECT, mqtttest.Refuse(reasoncodes.NotAuthorized))
+
+	if err := c.Connect(); err == nil {
+		t.Fatal("connected to a broker that refuses")
+	}
+
+	transitions(t, changes, client.StateConnecting)
+
+	if change := next(t, changes); change.To != client.StateDisconnected || change.Err == nil {
+		t.Fatalf("went from %v to %v (%v)", change.From, change.To, change.Err)
+	}
+	// --
+
+	// -- stop closes the channel, twice is fine
+	stop()
+	stop()
+
+	if _, ok := <-changes; ok {
+		t.Fatal("the channel is open after stop")
+	}
+	// --
+}
+
+func TestStateGiveUp(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+
+	var c = connect(t, m, func(o *client.ClientOptions) {
+		o.AutoReconnect = true
+		o.MaxReconnectAttempts = 2
+		o.InitialReconnectDelay = 5 * time.Millisecond
+	})
+
+	var changes, stop = c.WatchState()
+
+	defer stop()
+
+	// -- a reconnect that runs out of attempts ends Disconnected, not Terminated
+	m.Handle(mqttcodec.CONNECT, mqtttest.Hangup)
+	m.NextConn().Close()
+
+	transitions(t, changes, client.StateReconnecting, client.StateDisconnected)
+	// --
+}
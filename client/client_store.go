@@ -0,0 +1,251 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/MarcusOuelletus/demo/internal/appendlog"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+)
+
+/*
+A Store keeps the client's outbound QoS 1 and QoS 2 messages from the moment Publish accepts them until their flow has
+ended, so a process that is restarted with the same Store finishes what the previous one left open.
+
+Messages are stored under string keys, "queued/<sequence>" while they wait for send quota and "inflight/<packet id>"
+once they have a packet id, so any ordered key value store (bbolt for example) can back the interface. Keys returns the
+keys of a prefix sorted ascending, both key formats are zero padded so that is also the order they were published in.
+
+MemoryStore is the default and only survives a reconnect. FileStore is a single appendlog.Log: every Put and Delete is
+one JSON line written and synced before it returns, NewFileStore replays the log and cuts off a last line that was torn
+by a crash. The log is rewritten compactly once it holds more dead records than live ones.
+*/
+
+var ErrMessageNotFound = errors.New("message not found in store")
+
+// DefaultCompactThreshold is the number of records a FileStore log has to reach before it is compacted at all.
+var DefaultCompactThreshold = appendlog.DefaultCompactThreshold
+
+type StoredMessage struct {
+	Topic      string
+	Payload    []byte
+	QoS        byte
+	Retain     bool
+	Properties *mqttcodec.Properties `json:",omitempty"`
+	PacketID   uint16                `json:",omitempty"`
+	// Pubrel is set once a QoS 2 message got its PUBREC, after a restart only the PUBREL is sent again.
+	Pubrel bool `json:",omitempty"`
+}
+
+type Store interface {
+	Put(key string, msg *StoredMessage) error
+	Get(key string) (*StoredMessage, error)
+	Delete(key string) error
+	// Keys returns the keys starting with prefix in ascending order.
+	Keys(prefix string) ([]string, error)
+	Close() error
+}
+
+type MemoryStore struct {
+	sync.Mutex
+	messages map[string][]byte
+}
+
+type FileStore struct {
+	sync.Mutex
+	CompactThreshold int
+	log              *appendlog.Log
+	messages         map[string][]byte
+}
+
+type logRecord struct {
+	Key     string          `json:"k"`
+	Message json.RawMessage `json:"m,omitempty"`
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{messages: make(map[string][]byte)}
+}
+
+// Put keeps the encoded message rather than the pointer so callers can't mutate what was stored.
+func (m *MemoryStore) Put(key string, msg *StoredMessage) error {
+	data, err := json.Marshal(msg)
+
+	if err != nil {
+		return err
+	}
+
+	m.Lock()
+	m.messages[key] = data
+	m.Unlock()
+
+	return nil
+}
+
+func (m *MemoryStore) Get(key string) (*StoredMessage, error) {
+	m.Lock()
+	data, ok := m.messages[key]
+	m.Unlock()
+
+	if !ok {
+		return nil, ErrMessageNotFound
+	}
+
+	return decodeStoredMessage(data)
+}
+
+func (m *MemoryStore) Delete(key string) error {
+	m.Lock()
+	delete(m.messages, key)
+	m.Unlock()
+
+	return nil
+}
+
+func (m *MemoryStore) Keys(prefix string) ([]string, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	return sortedKeys(m.messages, prefix), nil
+}
+
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+// NewFileStore opens the log at path, creating it if needed, and replays it.
+func NewFileStore(path string) (*FileStore, error) {
+	var f = &FileStore{
+		CompactThreshold: DefaultCompactThreshold,
+		messages:         make(map[string][]byte),
+	}
+
+	log, err := appendlog.Open(path, f.replay)
+
+	if err != nil {
+		return nil, err
+	}
+
+	f.log = log
+
+	return f, nil
+}
+
+func (f *FileStore) Put(key string, msg *StoredMessage) error {
+	data, err := json.Marshal(msg)
+
+	if err != nil {
+		return err
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	if err = f.log.Append(logRecord{Key: key, Message: data}); err != nil {
+		return err
+	}
+
+	f.messages[key] = data
+
+	return f.compact()
+}
+
+func (f *FileStore) Get(key string) (*StoredMessage, error) {
+	f.Lock()
+	data, ok := f.messages[key]
+	f.Unlock()
+
+	if !ok {
+		return nil, ErrMessageNotFound
+	}
+
+	return decodeStoredMessage(data)
+}
+
+func (f *FileStore) Delete(key string) error {
+	f.Lock()
+	defer f.Unlock()
+
+	if _, ok := f.messages[key]; !ok {
+		return nil
+	}
+
+	if err := f.log.Append(logRecord{Key: key}); err != nil {
+		return err
+	}
+
+	delete(f.messages, key)
+
+	return f.compact()
+}
+
+func (f *FileStore) Keys(prefix string) ([]string, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	return sortedKeys(f.messages, prefix), nil
+}
+
+func (f *FileStore) Close() error {
+	f.Lock()
+	defer f.Unlock()
+
+	return f.log.Close()
+}
+
+// replay applies one record of the log.
+func (f *FileStore) replay(line []byte) error {
+	var record logRecord
+
+	if err := json.Unmarshal(line, &record); err != nil {
+		return err
+	}
+
+	if record.Message == nil {
+		delete(f.messages, record.Key)
+	} else {
+		f.messages[record.Key] = record.Message
+	}
+
+	return nil
+}
+
+// compact rewrites the log with only the live messages once most of its records are dead.
+func (f *FileStore) compact() error {
+	return f.log.Compact(f.CompactThreshold, len(f.messages), func(write func(record any) error) error {
+		for _, key := range sortedKeys(f.messages, "") {
+			if err := write(logRecord{Key: key, Message: f.messages[key]}); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func sortedKeys(messages map[string][]byte, prefix string) []string {
+	var keys []string
+
+	for key := range messages {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+func decodeStoredMessage(data []byte) (*StoredMessage, error) {
+	var msg StoredMessage
+
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, err
+	}
+
+	return &msg, nil
+}
@@ -0,0 +1,112 @@
+package client_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/MarcusOuelletus/demo/client"
+)
+
+func TestStores(t *testing.T) {
+	file, err := client.NewFileStore(filepath.Join(t.TempDir(), "store.log"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer file.Close()
+
+	var tests = []struct {
+		name  string
+		store client.Store
+	}{
+		{"memory", client.NewMemoryStore()},
+		{"file", file},
+	}
+
+	for _, test := range tests {
+		var msg = &client.StoredMessage{Topic: "a", Payload: []byte("x"), QoS: 1, PacketID: 2}
+
+		test.store.Put("inflight/00002", msg)
+		test.store.Put("inflight/00001", &client.StoredMessage{Topic: "b", QoS: 2, PacketID: 1, Pubrel: true})
+		test.store.Put("queued/00000000000000000001", &client.StoredMessage{Topic: "c", QoS: 1})
+
+		// -- what was stored is a copy, changing msg afterwards changes nothing
+		msg.Topic = "changed"
+
+		if got, err := test.store.Get("inflight/00002"); err != nil || got.Topic != "a" || string(got.Payload) != "x" {
+			t.Errorf("%s: got %+v, %v", test.name, got, err)
+		}
+		// --
+
+		if keys, _ := test.store.Keys("inflight/"); !reflect.DeepEqual(keys, []string{"inflight/00001", "inflight/00002"}) {
+			t.Errorf("%s: inflight keys %v", test.name, keys)
+		}
+
+		test.store.Delete("inflight/00002")
+		test.store.Delete("inflight/00002")
+
+		if _, err := test.store.Get("inflight/00002"); !errors.Is(err, client.ErrMessageNotFound) {
+			t.Errorf("%s: get after delete: %v", test.name, err)
+		}
+
+		if keys, _ := test.store.Keys(""); len(keys) != 2 {
+			t.Errorf("%s: keys %v", test.name, keys)
+		}
+	}
+}
+
+func TestFileStoreReplay(t *testing.T) {
+	var path = filepath.Join(t.TempDir(), "store.log")
+
+	f, err := client.NewFileStore(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// -- most records are dead by the end, the log is compacted on the way
+	f.CompactThreshold = 4
+
+	for id := 1; id <= 10; id++ {
+		var key = fmt.Sprintf("inflight/%05d", id)
+
+		f.Put(key, &client.StoredMessage{Topic: "a", QoS: 1, PacketID: uint16(id)})
+
+		if id < 9 {
+			f.Delete(key)
+		}
+	}
+
+	f.Close()
+	// --
+
+	// -- a crash in the middle of a write leaves a torn last line, it is cut off
+	torn, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	torn.WriteString(`{"k":"queued/1","m":{"To`)
+	torn.Close()
+	// --
+
+	if f, err = client.NewFileStore(path); err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	if keys, _ := f.Keys(""); !reflect.DeepEqual(keys, []string{"inflight/00009", "inflight/00010"}) {
+		t.Fatalf("replayed keys %v", keys)
+	}
+
+	if msg, err := f.Get("inflight/00009"); err != nil || msg.PacketID != 9 {
+		t.Fatalf("replayed %+v, %v", msg, err)
+	}
+}
@@ -0,0 +1,280 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/broker"
+	"github.com/MarcusOuelletus/demo/brokertest"
+	"github.com/MarcusOuelletus/demo/client"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/mqtttest"
+	"github.com/MarcusOuelletus/demo/reasoncodes"
+)
+
+// timeout is a context for one call of the client, done after mqtttest.DefaultTimeout.
+func timeout(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), mqtttest.DefaultTimeout)
+	t.Cleanup(cancel)
+
+	return ctx
+}
+
+// next takes the next value of ch, it fails the test after mqtttest.DefaultTimeout.
+func next[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+
+	var v T
+
+	select {
+	case v = <-ch:
+	case <-time.After(mqtttest.DefaultTimeout):
+		t.Fatalf("no %T within %v", v, mqtttest.DefaultTimeout)
+	}
+
+	return v
+}
+
+// connect connects a client to m with the options of m changed by modify, it is disconnected when the test ends.
+func connect(t *testing.T, m *mqtttest.MockBroker, modify func(o *client.ClientOptions)) *client.Client {
+	t.Helper()
+
+	var options = m.Options("")
+
+	if modify != nil {
+		modify(&options)
+	}
+
+	var c = client.New(options)
+
+	if err := c.Connect(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+
+	t.Cleanup(func() { c.Disconnect() })
+
+	return c
+}
+
+// subscribe subscribes c to filter and returns the channel its messages go to.
+func subscribe(t *testing.T, c *client.Client, filter string, opts client.SubscribeOptions) <-chan client.Message {
+	t.Helper()
+
+	var messages = make(chan client.Message, 64)
+
+	if err := c.Subscribe(timeout(t), filter, opts, func(m client.Message) { messages <- m }); err != nil {
+		t.Fatalf("subscribe to %s: %v", filter, err)
+	}
+
+	return messages
+}
+
+func TestPublishSubscribe(t *testing.T) {
+	var s = brokertest.Start(t, broker.Options{})
+	var c = client.New(s.Options("c"))
+
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !c.IsConnected() {
+		t.Fatal("not connected after Connect")
+	}
+
+	var messages = subscribe(t, c, "a/+", client.SubscribeOptions{QoS: 2})
+
+	// -- the client's own publishes come back at the QoS they were sent with
+	for qos := byte(0); qos <= 2; qos++ {
+		if err := c.Publish(timeout(t), "a/b", []byte("hello"), client.PublishOptions{QoS: qos}); err != nil {
+			t.Fatalf("publish with qos %d: %v", qos, err)
+		}
+
+		if m := next(t, messages); m.Topic != "a/b" || string(m.Payload) != "hello" || m.QoS != qos {
+			t.Fatalf("qos %d: got %q on %s with qos %d", qos, m.Payload, m.Topic, m.QoS)
+		}
+	}
+	// --
+
+	if err := c.Unsubscribe(timeout(t), "a/+"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Disconnect(); err != nil || c.IsConnected() {
+		t.Fatalf("disconnect: %v, connected %v", err, c.IsConnected())
+	}
+
+	if err := c.Publish(timeout(t), "a/b", nil, client.PublishOptions{}); !errors.Is(err, client.ErrNotConnected) {
+		t.Fatalf("publish after disconnect: %v", err)
+	}
+}
+
+func TestConnectRefused(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+
+	// 5 is the 3.1.1 return code Connection Refused, not authorized
+	m.Handle(mqttcodec.CONNECT, mqtttest.Refuse(5))
+
+	var err = client.New(m.Options("")).Connect()
+
+	var refused *client.ConnackError
+
+	if !errors.As(err, &refused) || refused.ReturnCode != 5 {
+		t.Fatalf("connect: %v", err)
+	}
+}
+
+func TestKeepalive(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+
+	connect(t, m, func(o *client.ClientOptions) { o.KeepAlive = 1 })
+
+	m.Expect(mqttcodec.PINGREQ)
+
+	// -- a broker that stops answering PINGREQ loses the connection
+	var lost = make(chan error, 1)
+
+	m.Handle(mqttcodec.PINGREQ, mqtttest.Drop)
+
+	connect(t, m, func(o *client.ClientOptions) {
+		o.KeepAlive = 1
+		o.OnConnectionLost = func(err error) { lost <- err }
+	})
+
+	if err := next(t, lost); !errors.Is(err, client.ErrKeepaliveTimeout) {
+		t.Fatalf("lost with %v", err)
+	}
+	// --
+}
+
+func TestInboundQoS2(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+	var store = client.NewMemoryStore()
+
+	var c = connect(t, m, func(o *client.ClientOptions) {
+		o.ProtocolVersion = mqttcodec.Version5
+		o.CleanSession = false
+		o.Store = store
+	})
+
+	var messages = subscribe(t, c, "a", client.SubscribeOptions{QoS: 2})
+	var conn = m.NextConn()
+
+	// -- a PUBREL for a packet id the client does not know still gets a PUBCOMP, with Packet Identifier not found
+	conn.Send(mqttcodec.NewPubrel(9))
+
+	if a := m.Expect(mqttcodec.PUBCOMP).Packet.(*mqttcodec.Ack); a.PacketID != 9 || a.ReasonCode != byte(reasoncodes.PacketIdentifierNotFound) {
+		t.Fatalf("PUBCOMP %d with reason code %#x", a.PacketID, a.ReasonCode)
+	}
+	// --
+
+	conn.Send(&mqttcodec.Publish{TopicName: "a", Payload: []byte("once"), QoS: 2, PacketID: 5})
+	m.Expect(mqttcodec.PUBREC)
+	next(t, messages)
+
+	// -- a restarted client with the same Store knows the packet id is delivered, the redelivery is only acknowledged
+	c.Disconnect()
+
+	m.Handle(mqttcodec.CONNECT, mqtttest.Reply(&mqttcodec.Connack{SessionPresent: true}))
+
+	var restarted = connect(t, m, func(o *client.ClientOptions) {
+		o.ProtocolVersion = mqttcodec.Version5
+		o.ClientID = c.ClientID()
+		o.CleanSession = false
+		o.Store = store
+	})
+
+	messages = subscribe(t, restarted, "a", client.SubscribeOptions{QoS: 2})
+	conn = m.NextConn()
+
+	conn.Send(&mqttcodec.Publish{TopicName: "a", Payload: []byte("once"), QoS: 2, PacketID: 5, Dup: true})
+	m.Expect(mqttcodec.PUBREC)
+
+	conn.Send(mqttcodec.NewPubrel(5))
+
+	if a := m.Expect(mqttcodec.PUBCOMP).Packet.(*mqttcodec.Ack); a.PacketID != 5 || a.ReasonCode != 0 {
+		t.Fatalf("PUBCOMP %d with reason code %#x", a.PacketID, a.ReasonCode)
+	}
+
+	select {
+	case m := <-messages:
+		t.Fatalf("redelivered %q", m.Payload)
+	default:
+	}
+	// --
+}
+
+func TestSubscribeRouting(t *testing.T) {
+	var s = brokertest.Start(t, broker.Options{})
+	var c = client.New(s.Options("c"))
+
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Disconnect()
+
+	var all = subscribe(t, c, "a/#", client.SubscribeOptions{QoS: 1})
+	var third = subscribe(t, c, "a/+/c", client.SubscribeOptions{QoS: 1})
+	var pub = s.Client("pub", nil)
+
+	// -- every filter matching the topic gets the message on its own handler, once
+	pub.Publish("a/b/c", "both", 1)
+	pub.Publish("a/b", "all", 1)
+
+	if m := next(t, third); string(m.Payload) != "both" {
+		t.Fatalf("a/+/c got %q", m.Payload)
+	}
+
+	for _, want := range []string{"both", "all"} {
+		if m := next(t, all); string(m.Payload) != want {
+			t.Fatalf("a/# got %q, want %q", m.Payload, want)
+		}
+	}
+	// --
+
+	// -- after Unsubscribe only the other filter gets the topic
+	if err := c.Unsubscribe(timeout(t), "a/#"); err != nil {
+		t.Fatal(err)
+	}
+
+	pub.Publish("a/b/c", "third", 1)
+
+	if m := next(t, third); string(m.Payload) != "third" {
+		t.Fatalf("a/+/c got %q", m.Payload)
+	}
+
+	select {
+	case m := <-all:
+		t.Fatalf("a/# got %q after Unsubscribe", m.Payload)
+	default:
+	}
+	// --
+}
+
+func TestSubscribeRefused(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+
+	m.Handle(mqttcodec.SUBSCRIBE, func(c *mqtttest.Conn, p mqttcodec.Packet) mqtttest.Response {
+		return mqtttest.Response{Packets: []mqttcodec.Packet{&mqttcodec.Suback{PacketID: p.(*mqttcodec.Subscribe).PacketID, ReturnCodes: []byte{0x80}}}}
+	})
+
+	var c = connect(t, m, nil)
+	var handled = make(chan client.Message, 1)
+
+	if err := c.Subscribe(timeout(t), "a", client.SubscribeOptions{QoS: 1}, func(m client.Message) { handled <- m }); err == nil {
+		t.Fatal("subscribed on a SUBACK with 0x80")
+	}
+
+	// -- the refused filter has no handler, a message for it is acknowledged and goes nowhere
+	m.NextConn().Send(&mqttcodec.Publish{TopicName: "a", QoS: 1, PacketID: 1})
+	m.Expect(mqttcodec.PUBACK)
+
+	select {
+	case <-handled:
+		t.Fatal("the refused filter got a message")
+	default:
+	}
+	// --
+}
@@ -0,0 +1,33 @@
+package client
+
+import "github.com/MarcusOuelletus/demo/mqttcodec"
+
+/*
+On an MQTT 5 connection the client uses topic aliases by itself once the CONNACK advertises a Topic Alias Maximum
+above 0. The connection's TopicAliasMap is set on its PacketWriter, so every PUBLISH passes it on its way out: the
+first one to a topic carries the name and a fresh alias, the following ones only the alias. When the broker's maximum
+is used up, the alias of the least recently published topic is reassigned, so the topics published most often keep
+theirs. The PUBLISH handed to write is never modified, the aliased one is a copy.
+
+The same map is set on the PacketReader, inbound aliases resolve against the Topic Alias Maximum sent in
+ConnectProperties, without one a broker sending an alias is a Topic Alias invalid error.
+*/
+
+// topicAliases returns the alias map for a connection, nil on 3.1.1.
+func topicAliases(connect *mqttcodec.Connect, connack *mqttcodec.Connack) *mqttcodec.TopicAliasMap {
+	if connack.Properties == nil || connect.ProtocolLevel != mqttcodec.ProtocolLevel5 {
+		return nil
+	}
+
+	var outbound, inbound uint16
+
+	if connack.Properties.TopicAliasMaximum != nil {
+		outbound = *connack.Properties.TopicAliasMaximum
+	}
+
+	if connect.Properties != nil && connect.Properties.TopicAliasMaximum != nil {
+		inbound = *connect.Properties.TopicAliasMaximum
+	}
+
+	return mqttcodec.NewTopicAliasMap(outbound, inbound)
+}
@@ -0,0 +1,79 @@
+package client_test
+
+import (
+	"testing"
+
+	"github.com/MarcusOuelletus/demo/client"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/mqtttest"
+	"github.com/MarcusOuelletus/demo/reasoncodes"
+)
+
+func TestTopicAliasOutbound(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+
+	m.Handle(mqttcodec.CONNECT, mqtttest.Reply(&mqttcodec.Connack{Properties: &mqttcodec.Properties{TopicAliasMaximum: mqttcodec.Uint16(2)}}))
+
+	var c = connect(t, m, func(o *client.ClientOptions) { o.ProtocolVersion = mqttcodec.Version5 })
+
+	for _, topic := range []string{"a", "a", "b", "a", "c", "c"} {
+		if err := c.Publish(timeout(t), topic, nil, client.PublishOptions{QoS: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// -- the first PUBLISH to a topic sets the alias up, the next ones only carry it
+	var names = []string{"a", "", "b", "", "c", ""}
+	var aliases = make([]uint16, len(names))
+
+	for i, name := range names {
+		var p = m.Expect(mqttcodec.PUBLISH).Packet.(*mqttcodec.Publish)
+
+		if p.TopicName != name || p.Properties == nil || p.Properties.TopicAlias == nil {
+			t.Fatalf("PUBLISH %d to %q with properties %+v, want %q with an alias", i, p.TopicName, p.Properties, name)
+		}
+
+		aliases[i] = *p.Properties.TopicAlias
+	}
+	// --
+
+	// -- with both aliases taken c gets the one of b, the topic published least recently
+	if aliases[4] != aliases[2] || aliases[4] == aliases[3] {
+		t.Fatalf("aliases %v", aliases)
+	}
+	// --
+}
+
+func TestTopicAliasInbound(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+
+	var lost = make(chan error, 1)
+
+	var c = connect(t, m, func(o *client.ClientOptions) {
+		o.ProtocolVersion = mqttcodec.Version5
+		o.ConnectProperties = &mqttcodec.Properties{TopicAliasMaximum: mqttcodec.Uint16(2)}
+		o.OnConnectionLost = func(err error) { lost <- err }
+	})
+
+	var messages = subscribe(t, c, "a", client.SubscribeOptions{})
+	var conn = m.NextConn()
+
+	conn.Send(
+		&mqttcodec.Publish{TopicName: "a", Payload: []byte("1"), Properties: &mqttcodec.Properties{TopicAlias: mqttcodec.Uint16(1)}},
+		&mqttcodec.Publish{Payload: []byte("2"), Properties: &mqttcodec.Properties{TopicAlias: mqttcodec.Uint16(1)}},
+	)
+
+	for _, payload := range []string{"1", "2"} {
+		if msg := next(t, messages); msg.Topic != "a" || string(msg.Payload) != payload {
+			t.Fatalf("got %q on %q, want %s on a", msg.Payload, msg.Topic, payload)
+		}
+	}
+
+	// -- an alias above the maximum the client advertised loses the connection with Topic Alias invalid
+	conn.Send(&mqttcodec.Publish{TopicName: "a", Properties: &mqttcodec.Properties{TopicAlias: mqttcodec.Uint16(3)}})
+
+	if err := next(t, lost); mqttcodec.ReasonCode(err) != reasoncodes.TopicAliasInvalid {
+		t.Fatalf("lost with %v", err)
+	}
+	// --
+}
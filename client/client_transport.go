@@ -0,0 +1,287 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+/*
+The broker address picks the transport: tcp://host:port (or a bare host:port) is plain TCP, tls:// and ssl:// are TLS,
+ws:// and wss:// are WebSocket over TCP or TLS with the URL path as the WebSocket path. Without a port the scheme's
+default is used. unix:///path/to.sock is a Unix domain socket on the same host. Any of the others can go through a
+SOCKS5 or HTTP CONNECT proxy, see client_proxy.go, or over what ClientOptions.Dial opens instead of TCP. Every transport hands back a net.Conn carrying the raw packet
+stream, so the packet reader and writer are the same for all of them.
+
+TLSOptions builds the tls.Config for a TLS connection on top of an optional base Config. SNI defaults to the broker's
+host name and ALPN to "mqtt", which brokers behind a shared 443 port (AWS IoT for example) need to route the connection.
+*/
+
+const (
+	DefaultTCPPort = "1883"
+	DefaultTLSPort = "8883"
+	DefaultWSPort  = "80"
+	DefaultWSSPort = "443"
+	ALPNProtocol   = "mqtt"
+)
+
+type TLSOptions struct {
+	// Config is cloned and the fields below are applied on top of it.
+	Config *tls.Config
+	// Certificates are presented to the broker for mutual TLS.
+	Certificates []tls.Certificate
+	RootCAs      *x509.CertPool
+	// ServerName overrides the SNI name and the name the certificate is verified against.
+	ServerName string
+	// NextProtos overrides the ALPN protocols, an empty slice sends none.
+	NextProtos            []string
+	InsecureSkipVerify    bool
+	VerifyPeerCertificate func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error
+	VerifyConnection      func(state tls.ConnectionState) error
+}
+
+// endpoint is a parsed broker address.
+type endpoint struct {
+	scheme   string
+	address  string
+	host     string
+	path     string
+	username string
+	password []byte
+}
+
+// transport opens the byte stream packets are framed on.
+type transport interface {
+	dial(e *endpoint, timeout time.Duration) (net.Conn, error)
+}
+
+type tcpTransport struct {
+	proxy *url.URL
+	// dialer is ClientOptions.Dial.
+	dialer func(address string, timeout time.Duration) (net.Conn, error)
+}
+
+type unixTransport struct{}
+
+type tlsTransport struct {
+	tcp     tcpTransport
+	options *TLSOptions
+}
+
+type webSocketTransport struct {
+	tcp     tcpTransport
+	tls     *tlsTransport
+	options *WebSocketOptions
+}
+
+func (o *TLSOptions) config(host string) *tls.Config {
+	var config = &tls.Config{}
+
+	if o != nil && o.Config != nil {
+		config = o.Config.Clone()
+	}
+
+	if config.ServerName == "" {
+		config.ServerName = host
+	}
+
+	if config.NextProtos == nil {
+		config.NextProtos = []string{ALPNProtocol}
+	}
+
+	if o == nil {
+		return config
+	}
+
+	if len(o.Certificates) > 0 {
+		config.Certificates = o.Certificates
+	}
+
+	if o.RootCAs != nil {
+		config.RootCAs = o.RootCAs
+	}
+
+	if o.ServerName != "" {
+		config.ServerName = o.ServerName
+	}
+
+	if o.NextProtos != nil {
+		config.NextProtos = o.NextProtos
+	}
+
+	if o.InsecureSkipVerify {
+		config.InsecureSkipVerify = true
+	}
+
+	if o.VerifyPeerCertificate != nil {
+		config.VerifyPeerCertificate = o.VerifyPeerCertificate
+	}
+
+	if o.VerifyConnection != nil {
+		config.VerifyConnection = o.VerifyConnection
+	}
+
+	return config
+}
+
+// parseBroker parses a broker address, filling in the default port of its scheme.
+func parseBroker(broker string) (*endpoint, error) {
+	var e = &endpoint{scheme: "tcp", address: broker}
+
+	if strings.Contains(broker, "://") {
+		u, err := url.Parse(broker)
+
+		if err != nil {
+			return nil, err
+		}
+
+		e.scheme, e.address, e.path = strings.ToLower(u.Scheme), u.Host, u.RequestURI()
+
+		if e.scheme == "unix" {
+			// -- unix:///run/mqtt.sock is absolute, unix://mqtt.sock relative to the working directory
+			e.address, e.path = u.Host+u.Path, ""
+
+			if e.address == "" {
+				return nil, fmt.Errorf("broker %q has no socket path", broker)
+			}
+
+			return e, nil
+		}
+
+		if u.User != nil {
+			e.username = u.User.Username()
+
+			if password, ok := u.User.Password(); ok {
+				e.password = []byte(password)
+			}
+		}
+	}
+
+	if e.address == "" {
+		return nil, fmt.Errorf("broker %q has no host", broker)
+	}
+
+	if _, _, err := net.SplitHostPort(e.address); err != nil {
+		var port = DefaultTCPPort
+
+		switch e.scheme {
+		case "tls", "ssl", "mqtts":
+			port = DefaultTLSPort
+		case "ws":
+			port = DefaultWSPort
+		case "wss":
+			port = DefaultWSSPort
+		}
+
+		e.address = net.JoinHostPort(e.address, port)
+	}
+
+	e.host, _, _ = net.SplitHostPort(e.address)
+
+	return e, nil
+}
+
+func (c *Client) transport(e *endpoint) (transport, error) {
+	if e.scheme == "unix" {
+		return unixTransport{}, nil
+	}
+
+	proxy, err := c.options.proxy(e)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var tcp = tcpTransport{proxy: proxy, dialer: c.options.Dial}
+
+	switch e.scheme {
+	case "tcp", "mqtt":
+		return tcp, nil
+	case "tls", "ssl", "mqtts":
+		return &tlsTransport{tcp: tcp, options: c.options.TLS}, nil
+	case "ws":
+		return &webSocketTransport{tcp: tcp, options: c.options.WebSocket}, nil
+	case "wss":
+		return &webSocketTransport{tcp: tcp, tls: &tlsTransport{tcp: tcp, options: c.options.TLS}, options: c.options.WebSocket}, nil
+	}
+
+	return nil, fmt.Errorf("broker scheme %q is not supported", e.scheme)
+}
+
+func (c *Client) dial(e *endpoint, timeout time.Duration) (net.Conn, error) {
+	t, err := c.transport(e)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return t.dial(e, timeout)
+}
+
+func (t tcpTransport) dial(e *endpoint, timeout time.Duration) (net.Conn, error) {
+	if t.proxy != nil {
+		return dialProxy(t.proxy, e.address, timeout)
+	}
+
+	if t.dialer != nil {
+		return t.dialer(e.address, timeout)
+	}
+
+	return net.DialTimeout("tcp", e.address, timeout)
+}
+
+func (unixTransport) dial(e *endpoint, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout("unix", e.address, timeout)
+}
+
+// dial runs the TLS handshake on the TCP connection itself so it also works through a proxy tunnel.
+func (t *tlsTransport) dial(e *endpoint, timeout time.Duration) (net.Conn, error) {
+	conn, err := t.tcp.dial(e, timeout)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var tlsConn = tls.Client(conn, t.options.config(e.host))
+
+	if timeout > 0 {
+		tlsConn.SetDeadline(time.Now().Add(timeout))
+	}
+
+	if err = tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	tlsConn.SetDeadline(time.Time{})
+
+	return tlsConn, nil
+}
+
+func (t *webSocketTransport) dial(e *endpoint, timeout time.Duration) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+
+	if t.tls != nil {
+		conn, err = t.tls.dial(e, timeout)
+	} else {
+		conn, err = t.tcp.dial(e, timeout)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	ws, err := dialWebSocket(conn, e.address, e.path, t.options, timeout)
+
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return ws, nil
+}
@@ -0,0 +1,159 @@
+package client_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/broker"
+	"github.com/MarcusOuelletus/demo/client"
+)
+
+// listen serves a broker on a listener of network with config until the test ends and returns the listener's address.
+func listen(t *testing.T, network, address string, config broker.ListenerConfig) string {
+	t.Helper()
+
+	l, err := net.Listen(network, address)
+
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	var b = broker.New(broker.Options{})
+
+	go b.ServeListener(l, config)
+
+	t.Cleanup(func() {
+		l.Close()
+		b.Close()
+	})
+
+	return l.Addr().String()
+}
+
+// certificate is a self-signed certificate for 127.0.0.1, for the server and the client alike, and the pool that
+// trusts it.
+func certificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var template = &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := x509.ParseCertificate(der)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pool = x509.NewCertPool()
+	pool.AddCert(parsed)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func TestTLS(t *testing.T) {
+	var cert, pool = certificate(t)
+
+	var address = listen(t, "tcp", "127.0.0.1:0", broker.ListenerConfig{TLSConfig: &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		NextProtos:   []string{client.ALPNProtocol},
+	}})
+
+	for _, scheme := range []string{"tls", "ssl"} {
+		var protocol string
+
+		var c = client.New(client.ClientOptions{
+			Broker:       scheme + "://" + address,
+			CleanSession: true,
+			TLS: &client.TLSOptions{
+				Certificates: []tls.Certificate{cert},
+				RootCAs:      pool,
+				VerifyConnection: func(state tls.ConnectionState) error {
+					protocol = state.NegotiatedProtocol
+					return nil
+				},
+			},
+		})
+
+		if err := c.Connect(); err != nil {
+			t.Fatalf("%s: %v", scheme, err)
+		}
+
+		if protocol != client.ALPNProtocol {
+			t.Errorf("%s: ALPN negotiated %q", scheme, protocol)
+		}
+
+		if _, err := c.PublishQoS1(timeout(t), "a", nil, client.PublishOptions{}); err != nil {
+			t.Errorf("%s: publish: %v", scheme, err)
+		}
+
+		c.Disconnect()
+	}
+
+	// -- without a client certificate the broker refuses the handshake, and so does a client that does not trust it
+	for _, options := range []*client.TLSOptions{{RootCAs: pool}, {Certificates: []tls.Certificate{cert}}} {
+		var c = client.New(client.ClientOptions{Broker: "tls://" + address, CleanSession: true, TLS: options, ConnectTimeout: time.Second})
+
+		if err := c.Connect(); err == nil {
+			c.Disconnect()
+			t.Errorf("connected with %d certificates and roots %v", len(options.Certificates), options.RootCAs != nil)
+		}
+	}
+	// --
+}
+
+func TestUnixSocket(t *testing.T) {
+	var path = listen(t, "unix", filepath.Join(t.TempDir(), "broker.sock"), broker.ListenerConfig{})
+	var c = client.New(client.ClientOptions{Broker: "unix://" + path, CleanSession: true})
+
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Disconnect()
+
+	if _, err := c.PublishQoS1(timeout(t), "a", nil, client.PublishOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// -- a Unix socket is on the same host, TLS and proxies do not apply
+	for _, options := range []client.ClientOptions{
+		{Broker: "unix://" + path, CleanSession: true, TLS: &client.TLSOptions{}},
+		{Broker: "unix://" + path, CleanSession: true, Proxy: "socks5://127.0.0.1:1080"},
+	} {
+		if err := options.Validate(); !errors.Is(err, client.ErrInvalidOptions) {
+			t.Errorf("validated %+v with %v", options, err)
+		}
+	}
+	// --
+}
@@ -0,0 +1,60 @@
+package client_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/MarcusOuelletus/demo/broker"
+	"github.com/MarcusOuelletus/demo/brokertest"
+	"github.com/MarcusOuelletus/demo/client"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/mqtttest"
+)
+
+func TestUserProperties(t *testing.T) {
+	var s = brokertest.Start(t, broker.Options{})
+	var version5 = func(o *client.ClientOptions) { o.ProtocolVersion = mqttcodec.Version5 }
+	var sub, pub = s.Client("sub", version5), s.Client("pub", version5)
+
+	sub.Subscribe("a", 2)
+
+	// -- duplicates, order and empty pairs come through the broker untouched, at every QoS
+	var properties = []client.KeyValue{{Key: "b", Value: "1"}, {Key: "a", Value: "2"}, {Key: "b", Value: "3"}, {Key: "", Value: ""}}
+
+	for qos := byte(0); qos <= 2; qos++ {
+		if err := pub.Client.Publish(timeout(t), "a", []byte("x"), client.PublishOptions{QoS: qos, UserProperties: properties}); err != nil {
+			t.Fatalf("publish with qos %d: %v", qos, err)
+		}
+
+		if m := sub.Expect("a", "x"); !reflect.DeepEqual(m.UserProperties, properties) {
+			t.Fatalf("qos %d: got %v", qos, m.UserProperties)
+		}
+	}
+	// --
+}
+
+func TestConnectSubscribeUserProperties(t *testing.T) {
+	var m = mqtttest.NewMockBroker(t)
+	var connectProperties = []client.KeyValue{{Key: "x", Value: "0"}}
+	var properties = []client.KeyValue{{Key: "trace", Value: "1"}, {Key: "trace", Value: "2"}}
+
+	var c = connect(t, m, func(o *client.ClientOptions) {
+		o.ProtocolVersion = mqttcodec.Version5
+		o.ConnectProperties = &mqttcodec.Properties{UserProperties: connectProperties[:1:1]}
+		o.UserProperties = properties
+	})
+
+	// -- UserProperties come after the ones of ConnectProperties in the CONNECT
+	var want = append(append([]client.KeyValue(nil), connectProperties...), properties...)
+
+	if p := m.Expect(mqttcodec.CONNECT).Packet.(*mqttcodec.Connect).Properties; !reflect.DeepEqual(p.UserProperties, want) {
+		t.Fatalf("CONNECT user properties %v", p.UserProperties)
+	}
+	// --
+
+	subscribe(t, c, "a", client.SubscribeOptions{QoS: 1, UserProperties: properties})
+
+	if p := m.Expect(mqttcodec.SUBSCRIBE).Packet.(*mqttcodec.Subscribe).Properties; p == nil || !reflect.DeepEqual(p.UserProperties, properties) {
+		t.Fatalf("SUBSCRIBE properties %+v", p)
+	}
+}
@@ -0,0 +1,87 @@
+package client
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/internal/websocket"
+)
+
+/*
+A minimal RFC 6455 client for ws:// and wss:// brokers, enough to carry MQTT: the handshake asks for the "mqtt"
+subprotocol, the connection is then the client side of a websocket.Conn, which masks every frame it sends.
+*/
+
+type WebSocketOptions struct {
+	// Headers are sent with the upgrade request, for Authorization or cookies.
+	Headers http.Header
+}
+
+func dialWebSocket(conn net.Conn, host, path string, options *WebSocketOptions, timeout time.Duration) (net.Conn, error) {
+	var nonce = make([]byte, 16)
+
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	var key = base64.StdEncoding.EncodeToString(nonce)
+
+	if path == "" {
+		path = "/"
+	}
+
+	req, err := http.NewRequest("GET", "http://"+host+path, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if options != nil {
+		for name, values := range options.Headers {
+			req.Header[name] = values
+		}
+	}
+
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Protocol", ALPNProtocol)
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	defer conn.SetDeadline(time.Time{})
+
+	if err = req.Write(conn); err != nil {
+		return nil, err
+	}
+
+	var r = bufio.NewReader(conn)
+
+	resp, err := http.ReadResponse(r, req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, fmt.Errorf("websocket upgrade refused with %s", resp.Status)
+	}
+
+	if resp.Header.Get("Sec-WebSocket-Accept") != websocket.Accept(key) {
+		return nil, fmt.Errorf("websocket upgrade with a wrong Sec-WebSocket-Accept")
+	}
+
+	if protocol := resp.Header.Get("Sec-WebSocket-Protocol"); protocol != "" && !strings.EqualFold(protocol, ALPNProtocol) {
+		return nil, fmt.Errorf("websocket upgrade selected subprotocol %q", protocol)
+	}
+
+	return websocket.Client(conn, r), nil
+}
@@ -0,0 +1,66 @@
+package client_test
+
+import (
+	"bytes"
+	"crypto/tls"
+	"testing"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/broker"
+	"github.com/MarcusOuelletus/demo/client"
+)
+
+func TestWebSocket(t *testing.T) {
+	var cert, pool = certificate(t)
+
+	var tests = []struct {
+		scheme string
+		config broker.ListenerConfig
+		tls    *client.TLSOptions
+	}{
+		{"ws", broker.ListenerConfig{WebSocket: true, Path: "/mqtt"}, nil},
+		{"wss", broker.ListenerConfig{WebSocket: true, Path: "/mqtt", TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}}}, &client.TLSOptions{RootCAs: pool}},
+	}
+
+	for _, test := range tests {
+		var address = listen(t, "tcp", "127.0.0.1:0", test.config)
+
+		var c = client.New(client.ClientOptions{
+			Broker:       test.scheme + "://" + address + "/mqtt",
+			CleanSession: true,
+			TLS:          test.tls,
+		})
+
+		if err := c.Connect(); err != nil {
+			t.Errorf("%s: %v", test.scheme, err)
+			continue
+		}
+
+		// -- a payload above 65535 bytes takes the 64 bit frame length both ways
+		var messages = subscribe(t, c, "a", client.SubscribeOptions{QoS: 1})
+		var payload = bytes.Repeat([]byte("x"), 70000)
+
+		if err := c.Publish(timeout(t), "a", payload, client.PublishOptions{QoS: 1}); err != nil {
+			t.Errorf("%s: publish: %v", test.scheme, err)
+		} else if m := next(t, messages); !bytes.Equal(m.Payload, payload) {
+			t.Errorf("%s: got %d bytes back", test.scheme, len(m.Payload))
+		}
+		// --
+
+		c.Disconnect()
+
+		// -- the broker serves /mqtt only, the upgrade of another path fails
+		var elsewhere = client.New(client.ClientOptions{
+			Broker:         test.scheme + "://" + address + "/elsewhere",
+			CleanSession:   true,
+			TLS:            test.tls,
+			ConnectTimeout: time.Second,
+		})
+
+		if err := elsewhere.Connect(); err == nil {
+			elsewhere.Disconnect()
+			t.Errorf("%s: connected on /elsewhere", test.scheme)
+		}
+		// --
+	}
+}
@@ -0,0 +1,76 @@
+package clock
+
+import (
+	"time"
+)
+
+/*
+clock is the time source of everything in the module that waits or measures: keepalive, session expiry and the will
+delay, message expiry in the broker queues and the retained store, retransmission, reconnect backoff, the rate limits,
+the acknowledgement latencies and the waits of Close. Each of them takes a Clock, nil is Real, so a test hands them a
+Fake and moves time on by hand instead of sleeping:
+
+	var c = clock.NewFake(time.Time{})
+	var k = session.NewKeepalive(time.Minute, c)
+
+	c.Advance(90 * time.Second) // k's tickers and timers fire here, in order, as if the time had passed
+
+Left on the wall clock are the deadlines of network connections, which the operating system enforces, and what only
+means something against a real network: the PacketWriter's FlushDelay, which only batches the writes of a busy
+connection and must not wait for a Fake, the broker's pause after a failed accept, the delays of the client's
+FaultInjector and the timestamps of a Tracer. The test harnesses and the command line tools use it too.
+*/
+
+// Clock tells the time and makes timers.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+	// AfterFunc runs f on its own goroutine once d has passed, the Timer it returns has no channel.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real is the clock of the time package.
+var Real Clock = realClock{}
+
+// Or returns c, Real when c is nil.
+func Or(c Clock) Clock {
+	if c == nil {
+		return Real
+	}
+
+	return c
+}
+
+type realClock struct{}
+
+type realTimer struct {
+	*time.Timer
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTimer(d time.Duration) Timer { return realTimer{time.NewTimer(d)} }
+
+func (realClock) NewTicker(d time.Duration) Ticker { return realTicker{time.NewTicker(d)} }
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer { return realTimer{time.AfterFunc(d, f)} }
+
+func (t realTimer) C() <-chan time.Time { return t.Timer.C }
+
+func (t realTicker) C() <-chan time.Time { return t.Ticker.C }
@@ -0,0 +1,202 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+Fake is a Clock that only moves when it is told to. Advance and Set walk the pending timers and tickers in the order
+they are due, each sees Now at its own deadline: a timer or ticker gets the time on its channel (a ticker that was not
+read yet skips the tick, like a real one), an AfterFunc function runs right there on the goroutine that moved the
+clock, so once Advance returns everything that was due has happened. Functions may create and stop timers of the Fake
+and tell the time, they must not move it.
+
+BlockUntil is the other half of a test: the code under test usually creates its timer on a goroutine of its own, the
+test waits for that before it advances, or the timer would miss the move.
+*/
+
+type Fake struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	timers  []*fakeTimer
+	created uint64
+}
+
+type fakeTimer struct {
+	clock  *Fake
+	at     time.Time
+	period time.Duration
+	ch     chan time.Time
+	fn     func()
+	// seq orders timers due at the same time by creation
+	seq uint64
+}
+
+type fakeTicker struct {
+	*fakeTimer
+}
+
+// NewFake starts a Fake at start, the zero time starts it at an arbitrary fixed date.
+func NewFake(start time.Time) *Fake {
+	if start.IsZero() {
+		start = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	var f = &Fake{now: start}
+	f.cond = sync.NewCond(&f.mu)
+
+	return f
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.now
+}
+
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	return f.add(d, 0, make(chan time.Time, 1), nil)
+}
+
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+
+	return fakeTicker{f.add(d, d, make(chan time.Time, 1), nil)}
+}
+
+func (f *Fake) AfterFunc(d time.Duration, fn func()) Timer {
+	return f.add(d, 0, nil, fn)
+}
+
+// Advance moves the clock d on, firing everything due on the way.
+func (f *Fake) Advance(d time.Duration) {
+	f.Set(f.Now().Add(d))
+}
+
+// Set moves the clock to t, firing everything due on the way, a t before Now changes nothing.
+func (f *Fake) Set(t time.Time) {
+	for {
+		f.mu.Lock()
+
+		var next = f.due(t)
+
+		if next == nil {
+			if t.After(f.now) {
+				f.now = t
+			}
+			f.mu.Unlock()
+			return
+		}
+
+		f.now = next.at
+
+		if next.period > 0 {
+			next.at = next.at.Add(next.period)
+		} else {
+			f.remove(next)
+		}
+
+		var now = f.now
+		f.mu.Unlock()
+
+		if next.fn != nil {
+			next.fn()
+			continue
+		}
+
+		select {
+		case next.ch <- now:
+		default:
+		}
+	}
+}
+
+// Pending is the number of timers and tickers that have not fired or been stopped.
+func (f *Fake) Pending() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.timers)
+}
+
+// BlockUntil waits until at least n timers and tickers are pending.
+func (f *Fake) BlockUntil(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for len(f.timers) < n {
+		f.cond.Wait()
+	}
+}
+
+func (f *Fake) add(d, period time.Duration, ch chan time.Time, fn func()) *fakeTimer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.created++
+
+	var t = &fakeTimer{clock: f, at: f.now.Add(d), period: period, ch: ch, fn: fn, seq: f.created}
+	f.timers = append(f.timers, t)
+	f.cond.Broadcast()
+
+	return t
+}
+
+// due returns the first timer due by t, f.mu has to be held.
+func (f *Fake) due(t time.Time) *fakeTimer {
+	sort.SliceStable(f.timers, func(i, j int) bool {
+		if f.timers[i].at.Equal(f.timers[j].at) {
+			return f.timers[i].seq < f.timers[j].seq
+		}
+		return f.timers[i].at.Before(f.timers[j].at)
+	})
+
+	if len(f.timers) == 0 || f.timers[0].at.After(t) {
+		return nil
+	}
+
+	return f.timers[0]
+}
+
+// remove takes t off the pending timers, it reports whether t was pending, f.mu has to be held.
+func (f *Fake) remove(t *fakeTimer) bool {
+	for i, p := range f.timers {
+		if p == t {
+			f.timers = append(f.timers[:i], f.timers[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.ch }
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	return t.clock.remove(t)
+}
+
+func (t fakeTicker) Stop() { t.fakeTimer.Stop() }
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	var pending = t.clock.remove(t)
+
+	t.clock.created++
+	t.at, t.seq = t.clock.now.Add(d), t.clock.created
+	t.clock.timers = append(t.clock.timers, t)
+	t.clock.cond.Broadcast()
+
+	return pending
+}
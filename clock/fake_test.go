@@ -0,0 +1,92 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeFiresInOrder(t *testing.T) {
+	var f = NewFake(time.Time{})
+	var start = f.Now()
+	var fired []string
+
+	var timer = f.NewTimer(3 * time.Second)
+	var ticker = f.NewTicker(2 * time.Second)
+	f.AfterFunc(time.Second, func() {
+		fired = append(fired, "func")
+
+		// -- a timer made by a function fires in the same Advance once it is due
+		f.AfterFunc(time.Second, func() { fired = append(fired, "nested at "+f.Now().Sub(start).String()) })
+	})
+	var stopped = f.AfterFunc(time.Second, func() { fired = append(fired, "stopped") })
+
+	if !stopped.Stop() || stopped.Stop() {
+		t.Fatal("Stop does not report the pending timer once")
+	}
+
+	f.Advance(5 * time.Second)
+
+	if len(fired) != 2 || fired[0] != "func" || fired[1] != "nested at 2s" {
+		t.Fatalf("fired %q", fired)
+	}
+
+	if at := <-timer.C(); at.Sub(start) != 3*time.Second {
+		t.Fatalf("timer fired at %s", at.Sub(start))
+	}
+
+	// -- the tick at 4s was dropped, the one at 2s was never read
+	if at := <-ticker.C(); at.Sub(start) != 2*time.Second {
+		t.Fatalf("ticker fired at %s", at.Sub(start))
+	}
+
+	if f.Now().Sub(start) != 5*time.Second || f.Pending() != 1 {
+		t.Fatalf("now %s, %d pending", f.Now().Sub(start), f.Pending())
+	}
+
+	ticker.Stop()
+
+	if f.Pending() != 0 {
+		t.Fatalf("%d pending after Stop", f.Pending())
+	}
+}
+
+func TestFakeReset(t *testing.T) {
+	var f = NewFake(time.Time{})
+	var timer = f.NewTimer(time.Second)
+
+	f.Advance(500 * time.Millisecond)
+
+	if !timer.Reset(time.Second) {
+		t.Fatal("Reset of a pending timer reports it was not")
+	}
+
+	f.Advance(900 * time.Millisecond)
+
+	select {
+	case <-timer.C():
+		t.Fatal("timer fired before its reset deadline")
+	default:
+	}
+
+	f.Advance(100 * time.Millisecond)
+
+	select {
+	case <-timer.C():
+	default:
+		t.Fatal("timer did not fire at its reset deadline")
+	}
+}
+
+func TestFakeBlockUntil(t *testing.T) {
+	var f = NewFake(time.Time{})
+	var done = make(chan struct{})
+
+	go func() {
+		<-f.NewTimer(time.Minute).C()
+		close(done)
+	}()
+
+	f.BlockUntil(1)
+	f.Advance(time.Minute)
+	<-done
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/broker"
+	"github.com/MarcusOuelletus/demo/client"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/topictrie"
+)
+
+/*
+mqttbench puts load on a broker through this module's client: it connects -publishers clients that publish at -rate
+messages per second between them, and -subscribers clients subscribed to -filter, then reports what arrived:
+
+	mqttbench -broker tcp://localhost:1883 -publishers 50 -subscribers 5 -rate 5000 -qos 1 -duration 30s
+
+Without -broker it starts a broker of its own on a loopback port, so the client, the packet ids and the broker's
+routing trie are measured together without anything else running.
+
+Every publisher publishes on -topic with {p} replaced by its number, so bench/{p}/data and the default filter
+bench/+/data give every subscriber every message. A payload starts with the publisher's number, its sequence number and
+the time it was sent, a subscriber takes the end to end latency from that and counts every sequence number it sees.
+What a subscriber should have received is worked out up front, by matching each publisher's topic against the filters
+in a topictrie, so lost messages are counted for any filter.
+
+Publish latency is the time a QoS 1 or 2 Publish took to be acknowledged, -inflight publishes run at once per
+publisher. Progress is printed every -interval, the report once the run ends and the subscribers had -drain to catch up.
+*/
+
+type config struct {
+	broker      string
+	publishers  int
+	subscribers int
+	rate        float64
+	qos         int
+	topic       string
+	filter      string
+	size        int
+	duration    time.Duration
+	drain       time.Duration
+	interval    time.Duration
+	inflight    int
+	version     string
+	timeout     time.Duration
+}
+
+// header is the publisher, the sequence number and the send time in unix nanoseconds.
+const header = 4 + 4 + 8
+
+func main() {
+	var c config
+
+	flag.StringVar(&c.broker, "broker", "", "broker URL, empty starts a broker on a loopback port")
+	flag.IntVar(&c.publishers, "publishers", 10, "number of publishing connections")
+	flag.IntVar(&c.subscribers, "subscribers", 1, "number of subscribing connections")
+	flag.Float64Var(&c.rate, "rate", 1000, "messages per second across all publishers, 0 publishes as fast as possible")
+	flag.IntVar(&c.qos, "qos", 0, "QoS of the publishes and the subscriptions")
+	flag.StringVar(&c.topic, "topic", "bench/{p}/data", "topic of the publishes, {p} is the publisher number")
+	flag.StringVar(&c.filter, "filter", "bench/+/data", "topic filter of the subscribers, comma separated for several")
+	flag.IntVar(&c.size, "size", 64, "payload size in bytes, at least "+strconv.Itoa(header))
+	flag.DurationVar(&c.duration, "duration", 10*time.Second, "how long to publish")
+	flag.DurationVar(&c.drain, "drain", 2*time.Second, "how long the subscribers get to catch up after the last publish")
+	flag.DurationVar(&c.interval, "interval", time.Second, "progress interval, 0 prints none")
+	flag.IntVar(&c.inflight, "inflight", 1, "publishes in flight at once per publisher")
+	flag.StringVar(&c.version, "version", "3.1.1", "MQTT version, 3.1.1 or 5")
+	flag.DurationVar(&c.timeout, "timeout", 10*time.Second, "timeout of a connect or a publish")
+	flag.Parse()
+
+	if err := run(c); err != nil {
+		fmt.Fprintln(os.Stderr, "mqttbench:", err)
+		os.Exit(1)
+	}
+}
+
+func (c config) validate() error {
+	switch {
+	case c.publishers < 0 || c.subscribers < 0 || c.publishers+c.subscribers == 0:
+		return fmt.Errorf("need at least one publisher or subscriber")
+	case c.qos < 0 || c.qos > 2:
+		return fmt.Errorf("qos %d is not 0, 1 or 2", c.qos)
+	case c.size < header:
+		return fmt.Errorf("size %d is below the %d bytes of the header", c.size, header)
+	case c.rate < 0:
+		return fmt.Errorf("rate %g is negative", c.rate)
+	case c.inflight < 1:
+		return fmt.Errorf("inflight %d is below 1", c.inflight)
+	case c.version != "3.1.1" && c.version != "5":
+		return fmt.Errorf("version %q is not 3.1.1 or 5", c.version)
+	}
+
+	return nil
+}
+
+func run(c config) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+
+	if c.broker == "" {
+		address, stop, err := startBroker()
+
+		if err != nil {
+			return err
+		}
+
+		defer stop()
+
+		c.broker = "tcp://" + address
+		fmt.Println("mqttbench: broker on", c.broker)
+	}
+
+	var s = newStats(c.publishers)
+	var filters = strings.Split(c.filter, ",")
+
+	// -- subscribers first, so the publishes of the first second have somewhere to go
+	var subs = make([]*client.Client, 0, c.subscribers)
+
+	defer func() {
+		for _, sub := range subs {
+			sub.Disconnect()
+		}
+	}()
+
+	for i := 0; i < c.subscribers; i++ {
+		var sub = newSubscriber(s, c.publishers)
+
+		cl, err := c.connect(fmt.Sprintf("mqttbench-sub-%d", i))
+
+		if err != nil {
+			return err
+		}
+
+		subs = append(subs, cl)
+
+		for _, filter := range filters {
+			var ctx, cancel = context.WithTimeout(context.Background(), c.timeout)
+			err := cl.Subscribe(ctx, filter, client.SubscribeOptions{QoS: byte(c.qos)}, sub.handle)
+			cancel()
+
+			if err != nil {
+				return fmt.Errorf("subscribe %s: %w", filter, err)
+			}
+		}
+	}
+	// --
+
+	var topics = make([]string, c.publishers)
+
+	for p := range topics {
+		topics[p] = strings.ReplaceAll(c.topic, "{p}", strconv.Itoa(p))
+	}
+
+	s.expectPerPublish = expected(topics, filters, c.subscribers)
+
+	var pubs = make([]*client.Client, 0, c.publishers)
+
+	defer func() {
+		for _, pub := range pubs {
+			pub.Disconnect()
+		}
+	}()
+
+	for p := 0; p < c.publishers; p++ {
+		cl, err := c.connect(fmt.Sprintf("mqttbench-pub-%d", p))
+
+		if err != nil {
+			return err
+		}
+
+		pubs = append(pubs, cl)
+	}
+
+	fmt.Printf("mqttbench: %d publishers, %d subscribers, qos %d, %g msg/s for %s\n", c.publishers, c.subscribers, c.qos, c.rate, c.duration)
+
+	var ctx, cancel = context.WithTimeout(context.Background(), c.duration)
+	defer cancel()
+
+	var interrupt, stopInterrupt = signal.NotifyContext(ctx, os.Interrupt)
+	defer stopInterrupt()
+
+	var wg sync.WaitGroup
+	var start = time.Now()
+
+	for p, pub := range pubs {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			c.publish(interrupt, s, pub, uint32(p), topics[p])
+		}()
+	}
+
+	var progressDone = make(chan struct{})
+	var progressStopped = make(chan struct{})
+
+	go func() {
+		defer close(progressStopped)
+		s.progress(c.interval, start, progressDone)
+	}()
+
+	wg.Wait()
+
+	var elapsed = time.Since(start)
+
+	time.Sleep(c.drain)
+	close(progressDone)
+	<-progressStopped
+
+	s.report(os.Stdout, elapsed)
+
+	return nil
+}
+
+func (c config) connect(clientID string) (*client.Client, error) {
+	var options = client.NewClientOptions(c.broker)
+
+	options.ClientID = clientID
+	options.ConnectTimeout = c.timeout
+
+	if c.version == "5" {
+		options.ProtocolVersion = mqttcodec.Version5
+	}
+
+	var cl = client.New(options)
+
+	if err := cl.Connect(); err != nil {
+		return nil, fmt.Errorf("connect %s: %w", clientID, err)
+	}
+
+	return cl, nil
+}
+
+// publish publishes on topic at the publisher's share of the rate until ctx is done. A publish that fell behind its
+// schedule goes out at once, a publisher that cannot keep up publishes as fast as it can.
+func (c config) publish(ctx context.Context, s *stats, pub *client.Client, publisher uint32, topic string) {
+	var interval time.Duration
+
+	if c.rate > 0 {
+		interval = time.Duration(float64(time.Second) * float64(c.publishers) / c.rate)
+	}
+
+	var slots = make(chan struct{}, c.inflight)
+	var wg sync.WaitGroup
+	var next = time.Now()
+
+	defer wg.Wait()
+
+	for seq := uint32(0); ; seq++ {
+		if interval > 0 {
+			if wait := time.Until(next); wait > 0 {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(wait):
+				}
+			}
+
+			next = next.Add(interval)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case slots <- struct{}{}:
+		}
+
+		var payload = make([]byte, c.size)
+
+		binary.BigEndian.PutUint32(payload, publisher)
+		binary.BigEndian.PutUint32(payload[4:], seq)
+		binary.BigEndian.PutUint64(payload[8:], uint64(time.Now().UnixNano()))
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			// -- the publish gets its own timeout, the end of the run must not cancel one already sent
+			var pctx, cancel = context.WithTimeout(context.Background(), c.timeout)
+			defer cancel()
+
+			var sent = time.Now()
+			var err = pub.Publish(pctx, topic, payload, client.PublishOptions{QoS: byte(c.qos)})
+			// --
+
+			s.published(publisher, err, time.Since(sent), c.qos > 0)
+		}()
+	}
+}
+
+// expected returns how many subscribers get a publish of each topic, every subscriber has all of filters.
+func expected(topics, filters []string, subscribers int) []int {
+	var t = topictrie.New[struct{}]()
+
+	for _, filter := range filters {
+		t.Add(filter, filter, &struct{}{})
+	}
+
+	var counts = make([]int, len(topics))
+
+	for i, topic := range topics {
+		// -- a subscriber whose filters overlap is expected to get a publish once, more copies count as duplicates
+		if len(t.Match(topic)) > 0 {
+			counts[i] = subscribers
+		}
+		// --
+	}
+
+	return counts
+}
+
+// startBroker serves a broker on a free loopback port, stop shuts it down.
+func startBroker() (address string, stop func(), err error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	var b = broker.New(broker.Options{})
+	var served = make(chan struct{})
+
+	go func() {
+		defer close(served)
+		b.Serve(l)
+	}()
+
+	return l.Addr().String(), func() {
+		b.Close()
+		<-served
+	}, nil
+}
+
+// subscriber counts what one subscribing connection receives.
+type subscriber struct {
+	s    *stats
+	mu   sync.Mutex
+	seen []bitset
+}
+
+func newSubscriber(s *stats, publishers int) *subscriber {
+	return &subscriber{s: s, seen: make([]bitset, publishers)}
+}
+
+func (sub *subscriber) handle(msg client.Message) {
+	var now = time.Now().UnixNano()
+
+	if len(msg.Payload) < header {
+		sub.s.foreign.Add(1)
+		return
+	}
+
+	var publisher = binary.BigEndian.Uint32(msg.Payload)
+	var seq = binary.BigEndian.Uint32(msg.Payload[4:])
+	var sent = int64(binary.BigEndian.Uint64(msg.Payload[8:]))
+
+	if int(publisher) >= len(sub.seen) {
+		sub.s.foreign.Add(1)
+		return
+	}
+
+	sub.mu.Lock()
+	var duplicate = !sub.seen[publisher].set(seq)
+	sub.mu.Unlock()
+
+	sub.s.received(time.Duration(now-sent), duplicate)
+}
+
+// bitset is the sequence numbers a subscriber saw from one publisher.
+type bitset []uint64
+
+// set adds i and returns false when it was there already.
+func (b *bitset) set(i uint32) bool {
+	var word, bit = int(i / 64), uint64(1) << (i % 64)
+
+	for len(*b) <= word {
+		*b = append(*b, 0)
+	}
+
+	if (*b)[word]&bit != 0 {
+		return false
+	}
+
+	(*b)[word] |= bit
+	return true
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/bits"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+stats is shared by every publisher and subscriber of a run. The counters are atomics, the latencies go into two
+histograms under one lock: end to end (publish to delivery) and, for QoS 1 and 2, publish to acknowledgement.
+
+A histogram has eight buckets per power of two of nanoseconds, so a percentile is within an eighth of the value it
+reports, in constant memory however long the run.
+*/
+
+type stats struct {
+	sent       []atomic.Int64
+	failed     atomic.Int64
+	delivered  atomic.Int64
+	duplicates atomic.Int64
+	// foreign are messages on the filters that did not come from this run.
+	foreign atomic.Int64
+	// expectPerPublish is how many subscribers get a publish of each publisher.
+	expectPerPublish []int
+
+	mu  sync.Mutex
+	e2e histogram
+	ack histogram
+}
+
+func newStats(publishers int) *stats {
+	return &stats{sent: make([]atomic.Int64, publishers)}
+}
+
+func (s *stats) published(publisher uint32, err error, took time.Duration, acked bool) {
+	if err != nil {
+		s.failed.Add(1)
+		return
+	}
+
+	s.sent[publisher].Add(1)
+
+	if acked {
+		s.mu.Lock()
+		s.ack.add(took)
+		s.mu.Unlock()
+	}
+}
+
+func (s *stats) received(latency time.Duration, duplicate bool) {
+	if duplicate {
+		s.duplicates.Add(1)
+		return
+	}
+
+	s.delivered.Add(1)
+
+	s.mu.Lock()
+	s.e2e.add(latency)
+	s.mu.Unlock()
+}
+
+func (s *stats) totals() (sent, expected int64) {
+	for p := range s.sent {
+		var n = s.sent[p].Load()
+
+		sent += n
+		expected += n * int64(s.expectPerPublish[p])
+	}
+
+	return sent, expected
+}
+
+// progress prints the rates of the last interval until done is closed.
+func (s *stats) progress(interval time.Duration, start time.Time, done chan struct{}) {
+	if interval <= 0 {
+		<-done
+		return
+	}
+
+	var ticker = time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastSent, lastDelivered int64
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		sent, _ := s.totals()
+		var delivered = s.delivered.Load()
+		var seconds = interval.Seconds()
+
+		fmt.Printf("%6.1fs  sent %8.0f/s  delivered %8.0f/s  failed %d\n", time.Since(start).Seconds(),
+			float64(sent-lastSent)/seconds, float64(delivered-lastDelivered)/seconds, s.failed.Load())
+
+		lastSent, lastDelivered = sent, delivered
+	}
+}
+
+func (s *stats) report(w io.Writer, elapsed time.Duration) {
+	sent, expected := s.totals()
+	var delivered = s.delivered.Load()
+	var lost = expected - delivered
+
+	fmt.Fprintf(w, "\npublished   %d in %s (%.0f/s), %d failed\n", sent, elapsed.Round(time.Millisecond), float64(sent)/elapsed.Seconds(), s.failed.Load())
+	fmt.Fprintf(w, "delivered   %d of %d expected (%.0f/s)\n", delivered, expected, float64(delivered)/elapsed.Seconds())
+
+	if expected > 0 {
+		fmt.Fprintf(w, "lost        %d (%.3f%%)\n", lost, 100*float64(lost)/float64(expected))
+	}
+
+	fmt.Fprintf(w, "duplicates  %d\n", s.duplicates.Load())
+
+	if n := s.foreign.Load(); n > 0 {
+		fmt.Fprintf(w, "foreign     %d\n", n)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fmt.Fprintln(w, "\nlatency       count      mean       p50       p90       p99     p99.9       max")
+	s.e2e.print(w, "end to end")
+
+	if s.ack.count > 0 {
+		s.ack.print(w, "publish ack")
+	}
+}
+
+type histogram struct {
+	buckets [64 * 8]int64
+	count   int64
+	sum     time.Duration
+	max     time.Duration
+}
+
+// bucket is the power of two of d and the next three bits below it, durations under 8ns have a bucket each.
+func bucket(d time.Duration) int {
+	var v = uint64(d)
+
+	if v < 8 {
+		return int(v)
+	}
+
+	var exp = bits.Len64(v) - 1
+
+	return exp<<3 | int(v>>(exp-3)&7)
+}
+
+// lower is the smallest duration of bucket i.
+func lower(i int) time.Duration {
+	if i < 8 {
+		return time.Duration(i)
+	}
+
+	var exp = i >> 3
+
+	return time.Duration((8 | uint64(i&7)) << (exp - 3))
+}
+
+func (h *histogram) add(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+
+	h.buckets[bucket(d)]++
+	h.count++
+	h.sum += d
+	h.max = max(h.max, d)
+}
+
+// percentile returns the lower bound of the bucket holding the q quantile.
+func (h *histogram) percentile(q float64) time.Duration {
+	var rank = int64(q * float64(h.count))
+	var seen int64
+
+	for i, n := range h.buckets {
+		seen += n
+
+		if seen > rank {
+			return lower(i)
+		}
+	}
+
+	return h.max
+}
+
+func (h *histogram) print(w io.Writer, name string) {
+	if h.count == 0 {
+		fmt.Fprintf(w, "%-11s %7d\n", name, 0)
+		return
+	}
+
+	var format = func(d time.Duration) string { return d.Round(time.Microsecond).String() }
+
+	fmt.Fprintf(w, "%-11s %7d %9s %9s %9s %9s %9s %9s\n", name, h.count, format(h.sum/time.Duration(h.count)),
+		format(h.percentile(0.5)), format(h.percentile(0.9)), format(h.percentile(0.99)),
+		format(h.percentile(0.999)), format(h.max))
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/client"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+)
+
+/*
+mqttcli publishes and subscribes from the command line with this module's client, the way mosquitto_pub and
+mosquitto_sub do:
+
+	mqttcli sub -broker tcp://localhost:1883 -t 'sensors/#' -v
+	mqttcli pub -broker tcp://localhost:1883 -t sensors/kitchen -m 21.5 -q 1 -r
+
+Both subcommands share the connection flags below, the broker URL picks the transport as ClientOptions.Broker does
+(tcp://, tls://, ws://, wss://, unix://) and the TLS and WebSocket flags only apply to the schemes that use them. The
+MQTT 5 flags need -V 5.
+
+It is also meant to be read: connect is how ClientOptions are put together for a real broker, pub.go and sub.go are the
+Publish and Subscribe calls with every option wired to a flag.
+*/
+
+const usage = `usage: mqttcli <command> [flags]
+
+commands:
+  pub   publish a message
+  sub   subscribe and print the messages
+
+mqttcli <command> -h lists the flags of a command.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+
+	switch os.Args[1] {
+	case "pub":
+		err = pub(os.Args[2:])
+	case "sub":
+		err = sub(os.Args[2:])
+	case "-h", "-help", "--help", "help":
+		fmt.Print(usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "mqttcli: unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "mqttcli:", err)
+		os.Exit(1)
+	}
+}
+
+// connection are the flags of both commands.
+type connection struct {
+	broker        string
+	clientID      string
+	username      string
+	password      string
+	version       string
+	keepAlive     uint
+	clean         bool
+	timeout       time.Duration
+	sessionExpiry time.Duration
+
+	caFile     string
+	certFile   string
+	keyFile    string
+	serverName string
+	insecure   bool
+
+	headers list
+
+	willTopic   string
+	willMessage string
+	willQoS     uint
+	willRetain  bool
+}
+
+func (c *connection) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.broker, "broker", "tcp://localhost:1883", "broker URL, tcp://, tls://, ws://, wss:// or unix://")
+	fs.StringVar(&c.clientID, "i", "", "client id, empty generates one")
+	fs.StringVar(&c.username, "u", "", "username")
+	fs.StringVar(&c.password, "P", "", "password")
+	fs.StringVar(&c.version, "V", "3.1.1", "MQTT version, 3.1.1 or 5")
+	fs.UintVar(&c.keepAlive, "k", uint(client.DefaultKeepAlive), "keep alive in seconds")
+	fs.BoolVar(&c.clean, "clean", true, "start a clean session, false keeps the session of the client id")
+	fs.DurationVar(&c.timeout, "timeout", 10*time.Second, "timeout of the connect and of every acknowledgement")
+	fs.DurationVar(&c.sessionExpiry, "session-expiry", 0, "MQTT 5 session expiry interval")
+
+	fs.StringVar(&c.caFile, "cafile", "", "PEM file of the CAs the broker's certificate is verified against")
+	fs.StringVar(&c.certFile, "cert", "", "PEM client certificate for mutual TLS")
+	fs.StringVar(&c.keyFile, "key", "", "PEM private key of -cert")
+	fs.StringVar(&c.serverName, "servername", "", "TLS server name, the broker's host by default")
+	fs.BoolVar(&c.insecure, "insecure", false, "do not verify the broker's certificate")
+
+	fs.Var(&c.headers, "header", "WebSocket upgrade header as Name: value, repeatable")
+
+	fs.StringVar(&c.willTopic, "will-topic", "", "topic of the will")
+	fs.StringVar(&c.willMessage, "will-payload", "", "payload of the will")
+	fs.UintVar(&c.willQoS, "will-qos", 0, "QoS of the will")
+	fs.BoolVar(&c.willRetain, "will-retain", false, "retain the will")
+}
+
+func (c *connection) options() (client.ClientOptions, error) {
+	var options = client.NewClientOptions(c.broker)
+
+	if c.clientID != "" {
+		options.ClientID = c.clientID
+	}
+
+	options.Username = c.username
+	options.KeepAlive = uint16(c.keepAlive)
+	options.CleanSession = c.clean
+	options.ConnectTimeout = c.timeout
+
+	if c.password != "" {
+		options.Password = []byte(c.password)
+	}
+
+	switch c.version {
+	case "3.1.1", "4":
+		options.ProtocolVersion = mqttcodec.Version311
+	case "5":
+		options.ProtocolVersion = mqttcodec.Version5
+	default:
+		return options, fmt.Errorf("-V %q is not 3.1.1 or 5", c.version)
+	}
+
+	if c.sessionExpiry > 0 {
+		options.ConnectProperties = &mqttcodec.Properties{SessionExpiryInterval: mqttcodec.Uint32(uint32(c.sessionExpiry / time.Second))}
+	}
+
+	if c.willTopic != "" {
+		options.Will = &client.WillOptions{Topic: c.willTopic, Payload: []byte(c.willMessage), QoS: byte(c.willQoS), Retain: c.willRetain}
+	}
+
+	tlsOptions, err := c.tls()
+
+	if err != nil {
+		return options, err
+	}
+
+	options.TLS = tlsOptions
+
+	if len(c.headers) > 0 {
+		var headers = make(http.Header)
+
+		for _, h := range c.headers {
+			name, value, ok := strings.Cut(h, ":")
+
+			if !ok {
+				return options, fmt.Errorf("-header %q is not Name: value", h)
+			}
+
+			headers.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+		}
+
+		options.WebSocket = &client.WebSocketOptions{Headers: headers}
+	}
+
+	return options, nil
+}
+
+// tls returns the TLS options of the flags, nil when none is set so a tls:// broker gets the defaults.
+func (c *connection) tls() (*client.TLSOptions, error) {
+	if c.caFile == "" && c.certFile == "" && c.serverName == "" && !c.insecure {
+		return nil, nil
+	}
+
+	var options = &client.TLSOptions{ServerName: c.serverName, InsecureSkipVerify: c.insecure}
+
+	if c.caFile != "" {
+		pem, err := os.ReadFile(c.caFile)
+
+		if err != nil {
+			return nil, err
+		}
+
+		options.RootCAs = x509.NewCertPool()
+
+		if !options.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("-cafile %s holds no PEM certificate", c.caFile)
+		}
+	}
+
+	if c.certFile != "" || c.keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+
+		if err != nil {
+			return nil, err
+		}
+
+		options.Certificates = []tls.Certificate{cert}
+	}
+
+	return options, nil
+}
+
+// connect connects with the connection flags, version5 says whether the connection is MQTT 5.
+func (c *connection) connect() (cl *client.Client, version5 bool, err error) {
+	options, err := c.options()
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	cl = client.New(options)
+
+	if err = cl.Connect(); err != nil {
+		return nil, false, err
+	}
+
+	return cl, options.ProtocolVersion == mqttcodec.Version5, nil
+}
+
+// list is a repeatable string flag.
+type list []string
+
+func (l *list) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *list) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// userProperties parses key=value flags into MQTT 5 user properties.
+func userProperties(l list) ([]mqttcodec.UserProperty, error) {
+	var props = make([]mqttcodec.UserProperty, 0, len(l))
+
+	for _, kv := range l {
+		key, value, ok := strings.Cut(kv, "=")
+
+		if !ok {
+			return nil, fmt.Errorf("user property %q is not key=value", kv)
+		}
+
+		props = append(props, mqttcodec.UserProperty{Key: key, Value: value})
+	}
+
+	return props, nil
+}
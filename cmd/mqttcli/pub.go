@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/client"
+)
+
+/*
+pub publishes one message, the payload from -m, -f or stdin (-s), or with -l one message per line of stdin until it
+ends. QoS 1 and 2 publishes wait for their acknowledgement, -v prints it. The MQTT 5 flags become the PUBLISH
+properties of PublishOptions.
+*/
+
+func pub(args []string) error {
+	var c connection
+	var fs = flag.NewFlagSet("mqttcli pub", flag.ContinueOnError)
+
+	c.register(fs)
+
+	var (
+		topic         = fs.String("t", "", "topic to publish on, required")
+		message       = fs.String("m", "", "payload")
+		file          = fs.String("f", "", "file whose contents are the payload")
+		stdin         = fs.Bool("s", false, "read the payload from stdin")
+		lines         = fs.Bool("l", false, "publish every line of stdin as a message")
+		null          = fs.Bool("n", false, "publish an empty payload, a retained one clears the retained message")
+		qos           = fs.Uint("q", 0, "QoS, 0, 1 or 2")
+		retain        = fs.Bool("r", false, "retain the message")
+		verbose       = fs.Bool("v", false, "print the acknowledgements")
+		expiry        = fs.Duration("expiry", 0, "MQTT 5 message expiry interval")
+		contentType   = fs.String("content-type", "", "MQTT 5 content type")
+		text          = fs.Bool("utf8", false, "MQTT 5 payload format indicator, the payload is UTF-8 text")
+		responseTopic = fs.String("response-topic", "", "MQTT 5 response topic")
+		correlation   = fs.String("correlation-data", "", "MQTT 5 correlation data")
+		user          list
+	)
+
+	fs.Var(&user, "user", "MQTT 5 user property as key=value, repeatable")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *topic == "" {
+		return errors.New("pub needs a topic, -t")
+	}
+
+	if *qos > 2 {
+		return fmt.Errorf("-q %d is not 0, 1 or 2", *qos)
+	}
+
+	// -- exactly one payload source, -m with nothing else published as it is
+	var sources = 0
+
+	for _, set := range []bool{*message != "", *file != "", *stdin, *lines, *null} {
+		if set {
+			sources++
+		}
+	}
+
+	if sources > 1 {
+		return errors.New("-m, -f, -s, -l and -n are exclusive")
+	}
+	// --
+
+	userProps, err := userProperties(user)
+
+	if err != nil {
+		return err
+	}
+
+	var opts = client.PublishOptions{
+		QoS:            byte(*qos),
+		Retain:         *retain,
+		MessageExpiry:  *expiry,
+		ContentType:    *contentType,
+		ResponseTopic:  *responseTopic,
+		UserProperties: userProps,
+	}
+
+	if *text {
+		opts.PayloadFormat = client.PayloadUTF8
+	}
+
+	if *correlation != "" {
+		opts.CorrelationData = []byte(*correlation)
+	}
+
+	cl, version5, err := c.connect()
+
+	if err != nil {
+		return err
+	}
+
+	defer cl.Disconnect()
+
+	if !version5 && (opts.MessageExpiry > 0 || opts.PayloadFormat != 0 || opts.ContentType != "" || opts.ResponseTopic != "" || opts.CorrelationData != nil || len(opts.UserProperties) > 0) {
+		fmt.Fprintln(os.Stderr, "mqttcli: MQTT 5 properties are dropped on a 3.1.1 connection, use -V 5")
+	}
+
+	var send = func(payload []byte) error {
+		return publish(cl, *topic, payload, opts, c.timeout, *verbose)
+	}
+
+	switch {
+	case *lines:
+		var scanner = bufio.NewScanner(os.Stdin)
+
+		scanner.Buffer(make([]byte, 64*1024), 256*1024*1024)
+
+		for scanner.Scan() {
+			if err := send(scanner.Bytes()); err != nil {
+				return err
+			}
+		}
+
+		return scanner.Err()
+	case *stdin:
+		payload, err := io.ReadAll(os.Stdin)
+
+		if err != nil {
+			return err
+		}
+
+		return send(payload)
+	case *file != "":
+		payload, err := os.ReadFile(*file)
+
+		if err != nil {
+			return err
+		}
+
+		return send(payload)
+	}
+
+	return send([]byte(*message))
+}
+
+func publish(cl *client.Client, topic string, payload []byte, opts client.PublishOptions, timeout time.Duration, verbose bool) error {
+	var ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var ack client.Ack
+	var err error
+
+	switch opts.QoS {
+	case 1:
+		ack, err = cl.PublishQoS1(ctx, topic, payload, opts)
+	case 2:
+		ack, err = cl.PublishQoS2(ctx, topic, payload, opts)
+	default:
+		err = cl.Publish(ctx, topic, payload, opts)
+	}
+
+	if err != nil {
+		return fmt.Errorf("publish %s: %w", topic, err)
+	}
+
+	if verbose && opts.QoS > 0 {
+		fmt.Printf("published %s, packet id %d, reason %s\n", topic, ack.PacketID, ack.ReasonCode)
+	}
+
+	return nil
+}
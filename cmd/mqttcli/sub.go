@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/MarcusOuelletus/demo/client"
+)
+
+/*
+sub subscribes to every -t filter and prints the payload of each message on a line of its own, with -v the topic in
+front of it and with -props the MQTT 5 properties the message came with. It runs until interrupted or, with -C, until
+that many messages arrived, then disconnects.
+
+The handler of every filter hands the messages to one channel, so the output is in the order they arrived and a
+message matching two filters is printed twice, as the broker delivers it twice.
+*/
+
+func sub(args []string) error {
+	var c connection
+	var fs = flag.NewFlagSet("mqttcli sub", flag.ContinueOnError)
+
+	c.register(fs)
+
+	var (
+		filters           list
+		qos               = fs.Uint("q", 0, "QoS of the subscriptions, 0, 1 or 2")
+		count             = fs.Int("C", 0, "exit after this many messages, 0 runs until interrupted")
+		verbose           = fs.Bool("v", false, "print the topic before the payload")
+		props             = fs.Bool("props", false, "print the MQTT 5 properties of every message")
+		retainedOnly      = fs.Bool("R", false, "skip the messages that are not retained")
+		noLocal           = fs.Bool("no-local", false, "MQTT 5, do not receive the client's own publishes")
+		retainAsPublished = fs.Bool("retain-as-published", false, "MQTT 5, keep the retain flag as it was published")
+		retainHandling    = fs.Uint("retain-handling", 0, "MQTT 5, 0 sends retained messages, 1 only for a new subscription, 2 never")
+	)
+
+	fs.Var(&filters, "t", "topic filter, repeatable, at least one is required")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if len(filters) == 0 {
+		return errors.New("sub needs a topic filter, -t")
+	}
+
+	if *qos > 2 || *retainHandling > 2 {
+		return errors.New("-q and -retain-handling are 0, 1 or 2")
+	}
+
+	cl, _, err := c.connect()
+
+	if err != nil {
+		return err
+	}
+
+	defer cl.Disconnect()
+
+	var messages = make(chan client.Message, 64)
+	var opts = client.SubscribeOptions{
+		QoS:               byte(*qos),
+		NoLocal:           *noLocal,
+		RetainAsPublished: *retainAsPublished,
+		RetainHandling:    byte(*retainHandling),
+	}
+
+	var ctx, stop = signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	for _, filter := range filters {
+		var subCtx, cancel = context.WithTimeout(ctx, c.timeout)
+
+		err := cl.Subscribe(subCtx, filter, opts, func(msg client.Message) {
+			select {
+			case messages <- msg:
+			case <-ctx.Done():
+			}
+		})
+
+		cancel()
+
+		if err != nil {
+			return fmt.Errorf("subscribe %s: %w", filter, err)
+		}
+	}
+
+	for n := 0; *count == 0 || n < *count; {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg := <-messages:
+			if *retainedOnly && !msg.Retain {
+				continue
+			}
+
+			printMessage(msg, *verbose, *props)
+			n++
+		}
+	}
+
+	return nil
+}
+
+func printMessage(msg client.Message, verbose, props bool) {
+	if verbose {
+		fmt.Printf("%s %s\n", msg.Topic, msg.Payload)
+	} else {
+		fmt.Printf("%s\n", msg.Payload)
+	}
+
+	if !props || msg.Properties == nil {
+		return
+	}
+
+	var p = msg.Properties
+	var fields []string
+
+	if p.ContentType != "" {
+		fields = append(fields, "content-type="+p.ContentType)
+	}
+
+	if p.ResponseTopic != "" {
+		fields = append(fields, "response-topic="+p.ResponseTopic)
+	}
+
+	if p.CorrelationData != nil {
+		fields = append(fields, fmt.Sprintf("correlation-data=%q", p.CorrelationData))
+	}
+
+	if p.MessageExpiryInterval != nil {
+		fields = append(fields, fmt.Sprintf("expiry=%ds", *p.MessageExpiryInterval))
+	}
+
+	if p.PayloadFormatIndicator != nil {
+		fields = append(fields, fmt.Sprintf("payload-format=%d", *p.PayloadFormatIndicator))
+	}
+
+	for _, id := range p.SubscriptionIdentifiers {
+		fields = append(fields, fmt.Sprintf("subscription-id=%d", id))
+	}
+
+	for _, u := range p.UserProperties {
+		fields = append(fields, fmt.Sprintf("user:%s=%s", u.Key, u.Value))
+	}
+
+	if len(fields) > 0 {
+		fmt.Printf("  %s\n", strings.Join(fields, " "))
+	}
+}
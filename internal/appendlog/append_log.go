@@ -0,0 +1,150 @@
+package appendlog
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+)
+
+/*
+A Log is an append-only file of JSON lines, the persistence behind the client's FileStore and the broker's retained
+store. Every Append is one line written and synced before it returns. Open hands every line to a replay function in
+order, the first one that does not decode was torn by a crash and is cut off together with anything after it. Compact
+rewrites the file with only the live records once it holds more dead ones than live ones.
+
+What a record means is up to the caller, the Log only counts them.
+*/
+
+// DefaultCompactThreshold is the number of records a log has to reach before it is compacted at all.
+var DefaultCompactThreshold = 1024
+
+type Log struct {
+	path    string
+	file    *os.File
+	records int
+}
+
+// Open opens the log at path, creating it if needed, and replays it.
+func Open(path string, replay func(line []byte) error) (*Log, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var l = &Log{path: path, file: file}
+
+	if err = l.replay(replay); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Append writes record as one line and syncs the file.
+func (l *Log) Append(record any) error {
+	line, err := json.Marshal(record)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err = l.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+
+	l.records++
+
+	return l.file.Sync()
+}
+
+// Compact rewrites the log with the records each writes once it reached threshold records and more than twice live.
+func (l *Log) Compact(threshold, live int, each func(write func(record any) error) error) error {
+	if l.records < threshold || l.records <= 2*live {
+		return nil
+	}
+
+	var tmp = l.path + ".tmp"
+
+	file, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
+
+	if err != nil {
+		return err
+	}
+
+	var w = bufio.NewWriter(file)
+	var records int
+
+	err = each(func(record any) error {
+		line, err := json.Marshal(record)
+
+		if err == nil {
+			_, err = w.Write(append(line, '\n'))
+		}
+
+		records++
+
+		return err
+	})
+
+	if err == nil {
+		err = w.Flush()
+	}
+
+	if err == nil {
+		err = file.Sync()
+	}
+
+	if err == nil {
+		err = os.Rename(tmp, l.path)
+	}
+
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	l.file.Close()
+	l.file = file
+	l.records = records
+
+	return nil
+}
+
+func (l *Log) Close() error {
+	return l.file.Close()
+}
+
+// replay reads the log, a record without its trailing newline or that replay refuses was torn and is truncated away.
+func (l *Log) replay(replay func(line []byte) error) error {
+	var r = bufio.NewReader(l.file)
+	var offset int64
+
+	for {
+		line, err := r.ReadBytes('\n')
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if replay(line) != nil {
+			break
+		}
+
+		l.records++
+		offset += int64(len(line))
+	}
+
+	if err := l.file.Truncate(offset); err != nil {
+		return err
+	}
+
+	_, err := l.file.Seek(offset, io.SeekStart)
+	return err
+}
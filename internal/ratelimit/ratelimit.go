@@ -0,0 +1,76 @@
+package ratelimit
+
+import (
+	"math"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/clock"
+)
+
+/*
+A Bucket is the token bucket behind the broker's PublishRate and the client's InboundRate: burst tokens, refilled at
+rate a second, it starts full. Take is for a caller that refuses what finds the bucket empty, Wait for one that holds it
+back until there is a token. A Bucket belongs to one goroutine, the connection it limits.
+*/
+
+type Bucket struct {
+	clock  clock.Clock
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// New returns a full bucket, a burst of 0 or less is rate rounded up, at least 1.
+func New(rate float64, burst int, clk clock.Clock) *Bucket {
+	var b = float64(burst)
+
+	if burst <= 0 {
+		b = math.Max(1, math.Ceil(rate))
+	}
+
+	return &Bucket{clock: clk, rate: rate, burst: b, tokens: b, last: clk.Now()}
+}
+
+// Take takes a token if there is one, it never waits.
+func (b *Bucket) Take() bool {
+	b.refill()
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+
+	return true
+}
+
+// Wait takes a token, sleeping until one is there, it returns false when done closes first.
+func (b *Bucket) Wait(done <-chan struct{}) bool {
+	b.refill()
+
+	if b.tokens < 1 {
+		var timer = b.clock.NewTimer(time.Duration((1 - b.tokens) / b.rate * float64(time.Second)))
+		defer timer.Stop()
+
+		select {
+		case <-timer.C():
+		case <-done:
+			return false
+		}
+
+		b.last = b.clock.Now()
+		b.tokens = 1
+	}
+
+	b.tokens--
+
+	return true
+}
+
+func (b *Bucket) refill() {
+	var now = b.clock.Now()
+
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
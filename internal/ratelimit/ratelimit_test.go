@@ -0,0 +1,91 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/clock"
+)
+
+func TestTakeRefills(t *testing.T) {
+	var clk = clock.NewFake(time.Unix(0, 0))
+	var b = New(2, 3, clk)
+
+	for i := 0; i < 3; i++ {
+		if !b.Take() {
+			t.Fatalf("token %d of the burst refused", i)
+		}
+	}
+
+	if b.Take() {
+		t.Fatal("a token beyond the burst")
+	}
+
+	// -- 2 a second, half a second is one token
+	clk.Advance(500 * time.Millisecond)
+
+	if !b.Take() || b.Take() {
+		t.Fatal("half a second did not refill exactly one token")
+	}
+	// --
+
+	// -- never more than the burst
+	clk.Advance(time.Hour)
+
+	for i := 0; i < 3; i++ {
+		b.Take()
+	}
+
+	if b.Take() {
+		t.Fatal("refilled beyond the burst")
+	}
+	// --
+}
+
+func TestDefaultBurst(t *testing.T) {
+	var clk = clock.NewFake(time.Unix(0, 0))
+
+	for rate, burst := range map[float64]int{0.5: 1, 1: 1, 2.5: 3} {
+		var b, taken = New(rate, 0, clk), 0
+
+		for b.Take() {
+			taken++
+		}
+
+		if taken != burst {
+			t.Errorf("rate %v has a burst of %d, want %d", rate, taken, burst)
+		}
+	}
+}
+
+func TestWait(t *testing.T) {
+	var clk = clock.NewFake(time.Unix(0, 0))
+	var b = New(4, 1, clk)
+	var done = make(chan struct{})
+
+	if !b.Wait(done) {
+		t.Fatal("the first token was not there")
+	}
+
+	// -- the second one is a quarter of a second away
+	var waited = make(chan bool)
+
+	go func() { waited <- b.Wait(done) }()
+
+	clk.BlockUntil(1)
+	clk.Advance(250 * time.Millisecond)
+
+	if !<-waited {
+		t.Fatal("Wait gave up")
+	}
+	// --
+
+	go func() { waited <- b.Wait(done) }()
+
+	clk.BlockUntil(1)
+	close(done)
+
+	if <-waited {
+		t.Fatal("Wait took a token after done closed")
+	}
+}
@@ -0,0 +1,240 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/MarcusOuelletus/demo/bytespool"
+	"github.com/MarcusOuelletus/demo/modules/helpers/bytes"
+)
+
+/*
+The RFC 6455 framing under MQTT over WebSocket, shared by the client and the broker, the handshakes stay with them. A
+Conn is the stream after the upgrade: Read returns the payload of the incoming binary frames as one continuous stream,
+so the packet reader on top does not care where frames start and end, and every Write goes out as one binary frame.
+Pings are answered, a close frame ends the stream with io.EOF.
+
+The two sides differ only in masking: a client Conn masks every frame it sends with a fresh random key, a server Conn
+sends its frames unmasked and refuses an incoming one that is not masked.
+*/
+
+// GUID is appended to the Sec-WebSocket-Key to compute Sec-WebSocket-Accept.
+const GUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	opContinuation byte = 0x0
+	opText         byte = 0x1
+	opBinary       byte = 0x2
+	opClose        byte = 0x8
+	opPing         byte = 0x9
+	opPong         byte = 0xA
+)
+
+type Conn struct {
+	net.Conn
+	client    bool
+	r         *bufio.Reader
+	writeMu   sync.Mutex
+	w         *bufio.Writer
+	remaining uint64
+	mask      [4]byte
+	masked    bool
+	maskPos   int
+}
+
+// Client is the client side of an upgraded conn, r is the reader the handshake response was read with.
+func Client(conn net.Conn, r *bufio.Reader) *Conn {
+	return &Conn{Conn: conn, client: true, r: r, w: bufio.NewWriter(conn)}
+}
+
+// Server is the server side of an upgraded conn, r is the reader the upgrade request was read with.
+func Server(conn net.Conn, r *bufio.Reader) *Conn {
+	return &Conn{Conn: conn, r: r, w: bufio.NewWriter(conn)}
+}
+
+// Accept is the Sec-WebSocket-Accept of key.
+func Accept(key string) string {
+	var sum = sha1.Sum([]byte(key + GUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// NetConn returns the connection below the framing, like tls.Conn's.
+func (c *Conn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	for c.remaining == 0 {
+		if err := c.nextFrame(); err != nil {
+			return 0, err
+		}
+	}
+
+	if uint64(len(b)) > c.remaining {
+		b = b[:c.remaining]
+	}
+
+	n, err := c.r.Read(b)
+	c.unmask(b[:n])
+	c.remaining -= uint64(n)
+
+	return n, err
+}
+
+// nextFrame reads frame headers until a data frame starts, control frames are handled on the way.
+func (c *Conn) nextFrame() error {
+	var r = bytes.NewByteReader(c.r)
+	var first = r.ReadUint8()
+	var second = r.ReadUint8()
+
+	var opcode = first & 0x0F
+	var length = uint64(second & 0x7F)
+
+	switch length {
+	case 126:
+		length = uint64(r.ReadUint16())
+	case 127:
+		length = r.ReadUint64()
+	}
+
+	c.masked = second&0x80 != 0
+	c.maskPos = 0
+
+	if c.masked {
+		c.mask = bytes.Split32BitWord(r.ReadUint32())
+	}
+
+	if err := r.Err(); err != nil {
+		if err == io.EOF && r.Count() > 0 {
+			return io.ErrUnexpectedEOF
+		}
+
+		return err
+	}
+
+	if !c.masked && !c.client {
+		return fmt.Errorf("websocket frame from the client without a mask")
+	}
+
+	switch opcode {
+	case opBinary, opContinuation:
+		c.remaining = length
+		return nil
+	case opText:
+		return fmt.Errorf("websocket text frame on an MQTT connection")
+	}
+
+	// -- control frames carry at most 125 bytes and are read whole
+	if length > 125 {
+		return fmt.Errorf("websocket control frame of %d bytes", length)
+	}
+
+	var payload = make([]byte, length)
+
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return err
+	}
+
+	c.unmask(payload)
+	// --
+
+	switch opcode {
+	case opPing:
+		return c.writeFrame(opPong, payload)
+	case opPong:
+		return nil
+	case opClose:
+		c.writeFrame(opClose, nil)
+		return io.EOF
+	}
+
+	return fmt.Errorf("websocket frame with unknown opcode %d", opcode)
+}
+
+func (c *Conn) unmask(b []byte) {
+	if !c.masked {
+		return
+	}
+
+	for i := range b {
+		b[i] ^= c.mask[c.maskPos%4]
+		c.maskPos++
+	}
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	if err := c.writeFrame(opBinary, b); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+// writeFrame sends one final frame, masked on the client side.
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	var maskBit byte
+
+	if c.client {
+		maskBit = 0x80
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	var w = bytes.NewByteWriter(c.w)
+
+	w.WriteUint8(0x80 | opcode)
+
+	switch {
+	case len(payload) < 126:
+		w.WriteUint8(maskBit | byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		w.WriteUint8(maskBit | 126)
+		w.WriteUint16(uint16(len(payload)))
+	default:
+		w.WriteUint8(maskBit | 127)
+		w.WriteUint64(uint64(len(payload)))
+	}
+
+	if !c.client {
+		w.WriteBytes(payload)
+	} else {
+		// -- a fresh key for every frame, the payload is masked in a copy
+		var mask [4]byte
+
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+
+		w.WriteBytes(mask[:])
+
+		var buf = bytespool.Get(len(payload))
+		defer bytespool.Put(buf)
+
+		var masked = (*buf)[:0]
+
+		for i, b := range payload {
+			masked = append(masked, b^mask[i%4])
+		}
+
+		w.WriteBytes(masked)
+		// --
+	}
+
+	if err := w.Err(); err != nil {
+		return err
+	}
+
+	return c.w.Flush()
+}
+
+func (c *Conn) Close() error {
+	c.writeFrame(opClose, nil)
+	return c.Conn.Close()
+}
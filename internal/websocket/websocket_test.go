@@ -0,0 +1,63 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"testing"
+)
+
+func pipe() (*Conn, *Conn) {
+	var a, b = net.Pipe()
+	return Client(a, bufio.NewReader(a)), Server(b, bufio.NewReader(b))
+}
+
+func TestConnCarriesAStream(t *testing.T) {
+	var client, server = pipe()
+	defer client.Conn.Close()
+	defer server.Conn.Close()
+
+	// -- one payload of each length encoding, in both directions
+	for _, n := range []int{5, 300, 70000} {
+		var payload = bytes.Repeat([]byte{byte(n)}, n)
+
+		for _, pair := range [][2]*Conn{{client, server}, {server, client}} {
+			go pair[0].Write(payload)
+
+			var got = make([]byte, n)
+
+			if _, err := io.ReadFull(pair[1], got); err != nil || !bytes.Equal(got, payload) {
+				t.Fatalf("%d bytes, client %v: %v", n, pair[0].client, err)
+			}
+		}
+	}
+	// --
+}
+
+func TestServerRefusesUnmaskedFrames(t *testing.T) {
+	var a, b = net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	var server = Server(b, bufio.NewReader(b))
+
+	go a.Write([]byte{0x80 | opBinary, 1, 'x'})
+
+	if _, err := server.Read(make([]byte, 1)); err == nil {
+		t.Fatal("an unmasked frame was read")
+	}
+}
+
+func TestCloseFrameEndsTheStream(t *testing.T) {
+	// -- net.Pipe is unbuffered, the close frame the server answers with fails once the client closed the pipe
+	var client, server = pipe()
+	defer server.Conn.Close()
+
+	go client.Close()
+	// --
+
+	if _, err := server.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("after a close frame: %v", err)
+	}
+}
@@ -0,0 +1,268 @@
+package bytes
+
+import "io"
+
+/*
+ByteReader and ByteWriter read and write MQTT's field types (big endian words, Variable Byte Integers, length prefixed
+strings and binary data) on a stream. Both latch the first error: every later call is a no-op that returns zero
+values, so a sequence of fields is read or written without a check after each one and Err is looked at once, at the
+end:
+
+	var r = bytes.NewByteReader(conn)
+	var id = r.ReadUint16()
+	var topic = r.ReadString()
+
+	if err := r.Err(); err != nil {
+		return err
+	}
+
+A stream that ends between two fields is io.EOF, one that ends inside a field is io.ErrUnexpectedEOF. Strings are
+checked as ValidateString does, a bad one is its *FieldError. Count is the number of bytes read or written so far, the
+offset of a bad field is Count before it was read.
+*/
+
+type ByteReader struct {
+	r     io.Reader
+	word  [8]byte
+	count int
+	err   error
+}
+
+func NewByteReader(r io.Reader) *ByteReader {
+	return &ByteReader{r: r}
+}
+
+// Err returns the first error of the reader, nil if every read so far succeeded.
+func (r *ByteReader) Err() error {
+	return r.err
+}
+
+func (r *ByteReader) Count() int {
+	return r.count
+}
+
+func (r *ByteReader) ReadUint8() byte {
+	if !r.fill(r.word[:1]) {
+		return 0
+	}
+
+	return r.word[0]
+}
+
+func (r *ByteReader) ReadUint16() uint16 {
+	if !r.fill(r.word[:2]) {
+		return 0
+	}
+
+	return CombineTwoBytes([2]byte(r.word[:2]))
+}
+
+func (r *ByteReader) ReadUint32() uint32 {
+	if !r.fill(r.word[:4]) {
+		return 0
+	}
+
+	return Combine4Bytes([4]byte(r.word[:4]))
+}
+
+func (r *ByteReader) ReadUint64() uint64 {
+	if !r.fill(r.word[:8]) {
+		return 0
+	}
+
+	return Combine8Bytes(r.word)
+}
+
+// ReadVarInt reads a Variable Byte Integer, a malformed one is ErrMalformedVarByteInt.
+func (r *ByteReader) ReadVarInt() uint32 {
+	if r.err != nil {
+		return 0
+	}
+
+	v, _, err := DecodeVarByteInt(byteSource{r})
+
+	if err != nil {
+		r.fail(err)
+		return 0
+	}
+
+	return v
+}
+
+func (r *ByteReader) ReadString() string {
+	var s = string(r.ReadBinary())
+
+	if r.err != nil {
+		return ""
+	}
+
+	if err := ValidateString(s); err != nil {
+		r.fail(err)
+		return ""
+	}
+
+	return s
+}
+
+// ReadBinary reads a length prefixed field into its own slice.
+func (r *ByteReader) ReadBinary() []byte {
+	var n = int(r.ReadUint16())
+
+	if r.err != nil {
+		return nil
+	}
+
+	var b = r.ReadBytes(n)
+
+	if r.err == io.EOF {
+		r.err = io.ErrUnexpectedEOF
+	}
+
+	return b
+}
+
+// ReadBytes reads the next n bytes into their own slice.
+func (r *ByteReader) ReadBytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+
+	var b = make([]byte, n)
+
+	if !r.fill(b) {
+		return nil
+	}
+
+	return b
+}
+
+// fill reads len(b) bytes unless an error is latched, it returns whether it did. Running out after some of them is
+// io.ErrUnexpectedEOF, io.ReadFull does that.
+func (r *ByteReader) fill(b []byte) bool {
+	if r.err != nil {
+		return false
+	}
+
+	n, err := io.ReadFull(r.r, b)
+	r.count += n
+
+	if err != nil {
+		r.fail(err)
+		return false
+	}
+
+	return true
+}
+
+func (r *ByteReader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// byteSource hands the reader to DecodeVarByteInt one byte at a time.
+type byteSource struct {
+	r *ByteReader
+}
+
+func (s byteSource) ReadByte() (byte, error) {
+	if !s.r.fill(s.r.word[:1]) {
+		return 0, s.r.err
+	}
+
+	return s.r.word[0], nil
+}
+
+type ByteWriter struct {
+	w     io.Writer
+	word  [8]byte
+	count int
+	err   error
+}
+
+func NewByteWriter(w io.Writer) *ByteWriter {
+	return &ByteWriter{w: w}
+}
+
+// Err returns the first error of the writer, nil if every write so far succeeded.
+func (w *ByteWriter) Err() error {
+	return w.err
+}
+
+func (w *ByteWriter) Count() int {
+	return w.count
+}
+
+func (w *ByteWriter) WriteUint8(v byte) {
+	w.word[0] = v
+	w.WriteBytes(w.word[:1])
+}
+
+func (w *ByteWriter) WriteUint16(v uint16) {
+	w.WriteBytes(AppendUint16(w.word[:0], v))
+}
+
+func (w *ByteWriter) WriteUint32(v uint32) {
+	w.WriteBytes(AppendUint32(w.word[:0], v))
+}
+
+func (w *ByteWriter) WriteUint64(v uint64) {
+	w.WriteBytes(AppendUint64(w.word[:0], v))
+}
+
+// WriteVarInt writes a Variable Byte Integer, a v larger than MaxVarByteInt is ErrMalformedVarByteInt.
+func (w *ByteWriter) WriteVarInt(v uint32) {
+	if v > MaxVarByteInt {
+		w.fail(ErrMalformedVarByteInt)
+		return
+	}
+
+	w.WriteBytes(AppendVarByteInt(w.word[:0], v))
+}
+
+func (w *ByteWriter) WriteString(s string) {
+	if w.err != nil {
+		return
+	}
+
+	if err := ValidateString(s); err != nil {
+		w.fail(err)
+		return
+	}
+
+	w.WriteUint16(uint16(len(s)))
+
+	if w.err == nil {
+		n, err := io.WriteString(w.w, s)
+		w.count += n
+		w.fail(err)
+	}
+}
+
+// WriteBinary writes b as a length prefixed field.
+func (w *ByteWriter) WriteBinary(b []byte) {
+	if len(b) > MaxFieldLength {
+		w.fail(&FieldError{Kind: FieldTooLong})
+		return
+	}
+
+	w.WriteUint16(uint16(len(b)))
+	w.WriteBytes(b)
+}
+
+// WriteBytes writes b as it is, unless an error is latched.
+func (w *ByteWriter) WriteBytes(b []byte) {
+	if w.err != nil {
+		return
+	}
+
+	n, err := w.w.Write(b)
+	w.count += n
+	w.fail(err)
+}
+
+func (w *ByteWriter) fail(err error) {
+	if w.err == nil {
+		w.err = err
+	}
+}
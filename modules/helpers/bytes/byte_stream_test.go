@@ -0,0 +1,140 @@
+package bytes
+
+import (
+	stdbytes "bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestByteStreamRoundTrip(t *testing.T) {
+	var buf stdbytes.Buffer
+	var w = NewByteWriter(&buf)
+
+	w.WriteUint8(0x30)
+	w.WriteVarInt(321)
+	w.WriteUint16(0xBEEF)
+	w.WriteUint32(0xDEADBEEF)
+	w.WriteUint64(1<<63 | 7)
+	w.WriteString("a/b")
+	w.WriteBinary([]byte{1, 2, 3})
+
+	if err := w.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Count() != buf.Len() || buf.Len() != 1+2+2+4+8+5+5 {
+		t.Fatalf("wrote %d bytes, counted %d", buf.Len(), w.Count())
+	}
+
+	var r = NewByteReader(&buf)
+
+	if v := r.ReadUint8(); v != 0x30 {
+		t.Errorf("ReadUint8() = 0x%X", v)
+	}
+
+	if v := r.ReadVarInt(); v != 321 {
+		t.Errorf("ReadVarInt() = %d", v)
+	}
+
+	if v := r.ReadUint16(); v != 0xBEEF {
+		t.Errorf("ReadUint16() = 0x%X", v)
+	}
+
+	if v := r.ReadUint32(); v != 0xDEADBEEF {
+		t.Errorf("ReadUint32() = 0x%X", v)
+	}
+
+	if v := r.ReadUint64(); v != 1<<63|7 {
+		t.Errorf("ReadUint64() = 0x%X", v)
+	}
+
+	if s := r.ReadString(); s != "a/b" {
+		t.Errorf("ReadString() = %q", s)
+	}
+
+	if b := r.ReadBinary(); !stdbytes.Equal(b, []byte{1, 2, 3}) {
+		t.Errorf("ReadBinary() = % X", b)
+	}
+
+	if err := r.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if r.ReadUint8(); r.Err() != io.EOF {
+		t.Errorf("reading past the end: %v, want io.EOF", r.Err())
+	}
+}
+
+func TestByteReaderLatchesFirstError(t *testing.T) {
+	var tests = []struct {
+		name  string
+		data  []byte
+		err   error
+		count int
+	}{
+		{"empty", nil, io.EOF, 0},
+		{"truncated word", []byte{0x01}, io.ErrUnexpectedEOF, 1},
+		{"truncated string", []byte{0x00, 0x05, 'a', 'b'}, io.ErrUnexpectedEOF, 4},
+		{"malformed var int", []byte{0x80, 0x80, 0x80, 0x80, 0x01}, ErrMalformedVarByteInt, 4},
+	}
+
+	for _, test := range tests {
+		var r = NewByteReader(stdbytes.NewReader(test.data))
+
+		if test.err == ErrMalformedVarByteInt {
+			r.ReadVarInt()
+		} else {
+			r.ReadString()
+		}
+
+		// -- every later read is a no-op
+		if v := r.ReadUint32(); v != 0 {
+			t.Errorf("%s: read 0x%X after the error", test.name, v)
+		}
+		// --
+
+		if !errors.Is(r.Err(), test.err) || r.Count() != test.count {
+			t.Errorf("%s: %v after %d bytes, want %v after %d", test.name, r.Err(), r.Count(), test.err, test.count)
+		}
+	}
+}
+
+func TestByteReaderInvalidString(t *testing.T) {
+	var r = NewByteReader(stdbytes.NewReader([]byte{0x00, 0x03, 'a', 0x00, 'b'}))
+	var field *FieldError
+
+	if s := r.ReadString(); s != "" || !errors.As(r.Err(), &field) || field.Kind != FieldNullCharacter {
+		t.Errorf("ReadString() = %q, %v", s, r.Err())
+	}
+}
+
+type failingWriter struct {
+	writes int
+}
+
+func (w *failingWriter) Write(b []byte) (int, error) {
+	w.writes++
+	return 0, io.ErrClosedPipe
+}
+
+func TestByteWriterLatchesFirstError(t *testing.T) {
+	var fw = &failingWriter{}
+	var w = NewByteWriter(fw)
+
+	w.WriteUint16(1)
+	w.WriteString("a")
+	w.WriteVarInt(MaxVarByteInt + 1)
+
+	if w.Err() != io.ErrClosedPipe || fw.writes != 1 {
+		t.Errorf("%v after %d writes, want %v after 1", w.Err(), fw.writes, io.ErrClosedPipe)
+	}
+
+	w = NewByteWriter(io.Discard)
+	w.WriteVarInt(MaxVarByteInt + 1)
+	w.WriteUint8(1)
+
+	if w.Err() != ErrMalformedVarByteInt || w.Count() != 0 {
+		t.Errorf("%v after %d bytes, want %v", w.Err(), w.Count(), ErrMalformedVarByteInt)
+	}
+}
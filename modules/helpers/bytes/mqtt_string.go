@@ -0,0 +1,146 @@
+package bytes
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+/*
+MQTT's length prefixed fields: a two byte big endian length followed by that many bytes. Strings additionally have to be
+well formed UTF-8 without U+0000 (Go's utf8 package already rejects encoded surrogates). Every failure is a *FieldError
+so callers can tell a truncated packet from a bad string, Offset is relative to the start of the field.
+*/
+
+const MaxFieldLength = 65535
+
+type FieldErrorKind byte
+
+const (
+	FieldTooLong FieldErrorKind = iota + 1
+	FieldTruncated
+	FieldInvalidUTF8
+	FieldNullCharacter
+)
+
+type FieldError struct {
+	Kind   FieldErrorKind
+	Offset int
+}
+
+func (e *FieldError) Error() string {
+	switch e.Kind {
+	case FieldTooLong:
+		return "field is longer than 65535 bytes"
+	case FieldTruncated:
+		return fmt.Sprintf("field is truncated at byte %d", e.Offset)
+	case FieldInvalidUTF8:
+		return fmt.Sprintf("string has invalid utf-8 at byte %d", e.Offset)
+	case FieldNullCharacter:
+		return fmt.Sprintf("string has U+0000 at byte %d", e.Offset)
+	}
+
+	return "invalid field"
+}
+
+func EncodeString(s string) ([]byte, error) {
+	return AppendString(make([]byte, 0, 2+len(s)), s)
+}
+
+func AppendString(out []byte, s string) ([]byte, error) {
+	if err := ValidateString(s); err != nil {
+		return out, err
+	}
+
+	return append(AppendUint16(out, uint16(len(s))), s...), nil
+}
+
+func EncodeBinary(b []byte) ([]byte, error) {
+	return AppendBinary(make([]byte, 0, 2+len(b)), b)
+}
+
+func AppendBinary(out []byte, b []byte) ([]byte, error) {
+	if len(b) > MaxFieldLength {
+		return out, &FieldError{Kind: FieldTooLong}
+	}
+
+	return append(AppendUint16(out, uint16(len(b))), b...), nil
+}
+
+// DecodeString decodes the string at the start of b and returns it with the number of bytes it took.
+func DecodeString(b []byte) (string, int, error) {
+	data, n, err := decodeField(b)
+
+	if err != nil {
+		return "", n, err
+	}
+
+	if err = validateStringBytes(data); err != nil {
+		err.(*FieldError).Offset += 2
+		return "", n, err
+	}
+
+	return string(data), n, nil
+}
+
+// DecodeBinary decodes the binary data at the start of b, the returned slice is a copy.
+func DecodeBinary(b []byte) ([]byte, int, error) {
+	data, n, err := decodeField(b)
+
+	if err != nil {
+		return nil, n, err
+	}
+
+	return append([]byte(nil), data...), n, nil
+}
+
+func ValidateString(s string) error {
+	if len(s) > MaxFieldLength {
+		return &FieldError{Kind: FieldTooLong}
+	}
+
+	for i, r := range s {
+		if r == utf8.RuneError {
+			if _, size := utf8.DecodeRuneInString(s[i:]); size == 1 {
+				return &FieldError{Kind: FieldInvalidUTF8, Offset: i}
+			}
+		}
+
+		if r == 0 {
+			return &FieldError{Kind: FieldNullCharacter, Offset: i}
+		}
+	}
+
+	return nil
+}
+
+func validateStringBytes(b []byte) error {
+	for i := 0; i < len(b); {
+		var r, size = utf8.DecodeRune(b[i:])
+
+		if r == utf8.RuneError && size == 1 {
+			return &FieldError{Kind: FieldInvalidUTF8, Offset: i}
+		}
+
+		if r == 0 {
+			return &FieldError{Kind: FieldNullCharacter, Offset: i}
+		}
+
+		i += size
+	}
+
+	return nil
+}
+
+func decodeField(b []byte) ([]byte, int, error) {
+	if len(b) < 2 {
+		return nil, 0, &FieldError{Kind: FieldTruncated, Offset: len(b)}
+	}
+
+	var length = int(CombineTwoBytes([2]byte{b[0], b[1]}))
+
+	if len(b) < 2+length {
+		return nil, 0, &FieldError{Kind: FieldTruncated, Offset: len(b)}
+	}
+
+	return b[2 : 2+length], 2 + length, nil
+}
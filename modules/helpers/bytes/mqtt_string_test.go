@@ -0,0 +1,120 @@
+package bytes
+
+import (
+	stdbytes "bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestStringValid(t *testing.T) {
+	for _, s := range []string{"", "a/b", "héllo", "日本語", "\U0001F600", "\uFEFF", "\uFFFF",
+		strings.Repeat("x", MaxFieldLength)} {
+		encoded, err := EncodeString(s)
+
+		if err != nil {
+			t.Errorf("EncodeString(%q): %v", s, err)
+			continue
+		}
+
+		if len(encoded) != 2+len(s) || int(encoded[0])<<8|int(encoded[1]) != len(s) {
+			t.Errorf("EncodeString(%q) has the length prefix % X", s, encoded[:2])
+		}
+
+		decoded, n, err := DecodeString(append(encoded, 0x42))
+
+		if err != nil || decoded != s || n != len(encoded) {
+			t.Errorf("DecodeString(EncodeString(%q)) = %q, %d, %v", s, decoded, n, err)
+		}
+	}
+}
+
+func TestStringInvalid(t *testing.T) {
+	var tests = []struct {
+		name   string
+		s      string
+		kind   FieldErrorKind
+		offset int
+	}{
+		{"U+0000", "a\x00b", FieldNullCharacter, 1},
+		{"leading U+0000", "\x00", FieldNullCharacter, 0},
+		{"invalid byte", "ab\xFF", FieldInvalidUTF8, 2},
+		{"lone continuation byte", "\x80", FieldInvalidUTF8, 0},
+		{"truncated sequence", "a\xE6\x97", FieldInvalidUTF8, 1},
+		{"overlong U+0000", "\xC0\x80", FieldInvalidUTF8, 0},
+		{"overlong slash", "\xE0\x80\xAF", FieldInvalidUTF8, 0},
+		{"high surrogate", "\xED\xA0\x80", FieldInvalidUTF8, 0},
+		{"low surrogate", "x\xED\xBF\xBF", FieldInvalidUTF8, 1},
+		{"beyond U+10FFFF", "\xF4\x90\x80\x80", FieldInvalidUTF8, 0},
+		{"too long", strings.Repeat("x", MaxFieldLength+1), FieldTooLong, 0},
+	}
+
+	for _, test := range tests {
+		var fieldErr *FieldError
+
+		if err := ValidateString(test.s); !errors.As(err, &fieldErr) || fieldErr.Kind != test.kind ||
+			fieldErr.Offset != test.offset {
+			t.Errorf("ValidateString %s: %v", test.name, err)
+		}
+
+		if _, err := EncodeString(test.s); !errors.As(err, &fieldErr) || fieldErr.Kind != test.kind {
+			t.Errorf("EncodeString %s: %v", test.name, err)
+		}
+
+		if test.kind == FieldTooLong {
+			continue
+		}
+
+		// -- decoding reports the offset from the start of the field, the length prefix included
+		var field = append([]byte{byte(len(test.s) >> 8), byte(len(test.s))}, test.s...)
+
+		if _, _, err := DecodeString(field); !errors.As(err, &fieldErr) || fieldErr.Kind != test.kind ||
+			fieldErr.Offset != test.offset+2 {
+			t.Errorf("DecodeString %s: %v", test.name, err)
+		}
+		// --
+	}
+}
+
+func TestFieldTruncated(t *testing.T) {
+	for _, field := range [][]byte{nil, {0x00}, {0x00, 0x03, 'a', 'b'}} {
+		var fieldErr *FieldError
+
+		if _, _, err := DecodeString(field); !errors.As(err, &fieldErr) || fieldErr.Kind != FieldTruncated ||
+			fieldErr.Offset != len(field) {
+			t.Errorf("DecodeString(% X): %v", field, err)
+		}
+
+		if _, _, err := DecodeBinary(field); !errors.As(err, &fieldErr) || fieldErr.Kind != FieldTruncated {
+			t.Errorf("DecodeBinary(% X): %v", field, err)
+		}
+	}
+}
+
+func TestBinary(t *testing.T) {
+	// binary data has no UTF-8 rules, U+0000 and invalid sequences are kept as they are
+	var data = []byte{0x00, 0xFF, 0xED, 0xA0, 0x80}
+	encoded, err := EncodeBinary(data)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, n, err := DecodeBinary(encoded)
+
+	if err != nil || !stdbytes.Equal(decoded, data) || n != len(encoded) {
+		t.Fatalf("DecodeBinary(EncodeBinary(% X)) = % X, %d, %v", data, decoded, n, err)
+	}
+
+	// -- the decoded data is a copy
+	encoded[2] = 0x01
+
+	if decoded[0] != 0x00 {
+		t.Fatal("DecodeBinary returned a slice of its input")
+	}
+	// --
+
+	if _, err = EncodeBinary(make([]byte, MaxFieldLength+1)); err == nil {
+		t.Fatal("EncodeBinary accepted more than 65535 bytes")
+	}
+}
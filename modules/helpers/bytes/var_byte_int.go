@@ -0,0 +1,94 @@
+package bytes
+
+import (
+	"errors"
+	"io"
+)
+
+/*
+MQTT's Variable Byte Integer, used for Remaining Length, property lengths and Subscription Identifiers.
+Each byte carries 7 bits of the value, least significant group first, with the high bit set when another byte follows.
+At most 4 bytes are allowed which caps the value at 268,435,455.
+*/
+
+const MaxVarByteInt = 268435455
+
+var ErrMalformedVarByteInt = errors.New("malformed variable byte integer")
+
+// EncodeVarByteInt returns nil if v is larger than MaxVarByteInt.
+func EncodeVarByteInt(v uint32) []byte {
+	if v > MaxVarByteInt {
+		return nil
+	}
+
+	return AppendVarByteInt(make([]byte, 0, VarByteIntSize(v)), v)
+}
+
+// AppendVarByteInt returns out unchanged if v is larger than MaxVarByteInt.
+func AppendVarByteInt(out []byte, v uint32) []byte {
+	if v > MaxVarByteInt {
+		return out
+	}
+
+	for {
+		var b = byte(v % 128)
+		v /= 128
+
+		if v > 0 {
+			b |= 0x80
+		}
+
+		out = append(out, b)
+
+		if v == 0 {
+			return out
+		}
+	}
+}
+
+// DecodeVarByteInt returns the value and the number of bytes it took. More than 4 bytes, or an encoding that isn't
+// the shortest possible, is ErrMalformedVarByteInt, running out of input returns the reader's error.
+func DecodeVarByteInt(r io.ByteReader) (uint32, int, error) {
+	var value uint32
+	var multiplier uint32 = 1
+
+	for i := 1; i <= 4; i++ {
+		b, err := r.ReadByte()
+
+		if err != nil {
+			if err == io.EOF && i > 1 {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, i - 1, err
+		}
+
+		value += uint32(b&0x7F) * multiplier
+
+		if b&0x80 == 0 {
+			// -- A trailing zero group means a shorter encoding existed.
+			if b == 0 && i > 1 {
+				return 0, i, ErrMalformedVarByteInt
+			}
+			// --
+
+			return value, i, nil
+		}
+
+		multiplier *= 128
+	}
+
+	return 0, 4, ErrMalformedVarByteInt
+}
+
+func VarByteIntSize(v uint32) int {
+	switch {
+	case v < 128:
+		return 1
+	case v < 16384:
+		return 2
+	case v < 2097152:
+		return 3
+	}
+
+	return 4
+}
@@ -0,0 +1,83 @@
+package bytes
+
+import (
+	stdbytes "bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestVarByteIntBoundaries(t *testing.T) {
+	var tests = []struct {
+		value   uint32
+		encoded []byte
+	}{
+		{0, []byte{0x00}},
+		{127, []byte{0x7F}},
+		{128, []byte{0x80, 0x01}},
+		{16383, []byte{0xFF, 0x7F}},
+		{16384, []byte{0x80, 0x80, 0x01}},
+		{2097151, []byte{0xFF, 0xFF, 0x7F}},
+		{2097152, []byte{0x80, 0x80, 0x80, 0x01}},
+		{268435455, []byte{0xFF, 0xFF, 0xFF, 0x7F}},
+	}
+
+	for _, test := range tests {
+		if got := EncodeVarByteInt(test.value); !stdbytes.Equal(got, test.encoded) {
+			t.Errorf("EncodeVarByteInt(%d) = % X, want % X", test.value, got, test.encoded)
+		}
+
+		if size := VarByteIntSize(test.value); size != len(test.encoded) {
+			t.Errorf("VarByteIntSize(%d) = %d, want %d", test.value, size, len(test.encoded))
+		}
+
+		if got := AppendVarByteInt([]byte{0xAA}, test.value); !stdbytes.Equal(got, append([]byte{0xAA}, test.encoded...)) {
+			t.Errorf("AppendVarByteInt(%d) = % X", test.value, got)
+		}
+
+		// a trailing byte must not be read
+		var r = stdbytes.NewReader(append(append([]byte(nil), test.encoded...), 0x42))
+		value, n, err := DecodeVarByteInt(r)
+
+		if err != nil || value != test.value || n != len(test.encoded) || r.Len() != 1 {
+			t.Errorf("DecodeVarByteInt(% X) = %d, %d, %v", test.encoded, value, n, err)
+		}
+	}
+}
+
+func TestVarByteIntOutOfRange(t *testing.T) {
+	if got := EncodeVarByteInt(MaxVarByteInt + 1); got != nil {
+		t.Errorf("EncodeVarByteInt(MaxVarByteInt+1) = % X, want nil", got)
+	}
+
+	if got := AppendVarByteInt([]byte{1}, MaxVarByteInt+1); !stdbytes.Equal(got, []byte{1}) {
+		t.Errorf("AppendVarByteInt(MaxVarByteInt+1) changed the output to % X", got)
+	}
+}
+
+func TestVarByteIntMalformed(t *testing.T) {
+	var tests = []struct {
+		name    string
+		encoded []byte
+		err     error
+		n       int
+	}{
+		{"five bytes", []byte{0xFF, 0xFF, 0xFF, 0xFF, 0x7F}, ErrMalformedVarByteInt, 4},
+		{"continuation on the fourth byte", []byte{0x80, 0x80, 0x80, 0x80}, ErrMalformedVarByteInt, 4},
+		{"overlong zero", []byte{0x80, 0x00}, ErrMalformedVarByteInt, 2},
+		{"overlong 127", []byte{0xFF, 0x00}, ErrMalformedVarByteInt, 2},
+		{"overlong three bytes", []byte{0x80, 0x80, 0x00}, ErrMalformedVarByteInt, 3},
+		{"overlong four bytes", []byte{0xFF, 0xFF, 0xFF, 0x00}, ErrMalformedVarByteInt, 4},
+		{"empty", nil, io.EOF, 0},
+		{"truncated", []byte{0x80}, io.ErrUnexpectedEOF, 1},
+		{"truncated after three bytes", []byte{0x80, 0x80, 0x80}, io.ErrUnexpectedEOF, 3},
+	}
+
+	for _, test := range tests {
+		_, n, err := DecodeVarByteInt(stdbytes.NewReader(test.encoded))
+
+		if !errors.Is(err, test.err) || n != test.n {
+			t.Errorf("%s: got %d, %v, want %d, %v", test.name, n, err, test.n, test.err)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package bytes
+
+/*
+Big endian words: MQTT's Two Byte Integer (lengths, packet ids, Receive Maximum...), its Four Byte Integer (Session
+Expiry Interval, Message Expiry Interval, Maximum Packet Size...) and, for the persistence formats, eight byte ones. The
+Append variants write into an existing slice so an encoder that builds a packet in one buffer does not allocate per
+field.
+*/
+
+func Split16BitWord(v uint16) [2]byte {
+	return [2]byte{byte(v >> 8), byte(v)}
+}
+
+func CombineTwoBytes(b [2]byte) uint16 {
+	return uint16(b[0])<<8 | uint16(b[1])
+}
+
+func Split32BitWord(v uint32) [4]byte {
+	return [4]byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}
+}
+
+func Combine4Bytes(b [4]byte) uint32 {
+	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+}
+
+func Split64BitWord(v uint64) [8]byte {
+	return [8]byte{byte(v >> 56), byte(v >> 48), byte(v >> 40), byte(v >> 32), byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}
+}
+
+func Combine8Bytes(b [8]byte) uint64 {
+	return uint64(Combine4Bytes([4]byte(b[:4])))<<32 | uint64(Combine4Bytes([4]byte(b[4:])))
+}
+
+func AppendUint16(out []byte, v uint16) []byte {
+	return append(out, byte(v>>8), byte(v))
+}
+
+func AppendUint32(out []byte, v uint32) []byte {
+	return append(out, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func AppendUint64(out []byte, v uint64) []byte {
+	return AppendUint32(AppendUint32(out, uint32(v>>32)), uint32(v))
+}
@@ -0,0 +1,65 @@
+package bytes
+
+import (
+	stdbytes "bytes"
+	"testing"
+)
+
+func TestWords(t *testing.T) {
+	var tests = []struct {
+		value   uint64
+		encoded []byte
+	}{
+		{0, []byte{0, 0, 0, 0, 0, 0, 0, 0}},
+		{0x7F, []byte{0, 0, 0, 0, 0, 0, 0, 0x7F}},
+		{0xFFFFFFFF, []byte{0, 0, 0, 0, 0xFF, 0xFF, 0xFF, 0xFF}},
+		{0x0102030405060708, []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		{0xFFFFFFFFFFFFFFFF, []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}},
+	}
+
+	for _, test := range tests {
+		if got := Split64BitWord(test.value); !stdbytes.Equal(got[:], test.encoded) {
+			t.Errorf("Split64BitWord(%#x) = % X, want % X", test.value, got, test.encoded)
+		}
+
+		if got := Combine8Bytes([8]byte(test.encoded)); got != test.value {
+			t.Errorf("Combine8Bytes(% X) = %#x", test.encoded, got)
+		}
+
+		if got := AppendUint64([]byte{0xAA}, test.value); !stdbytes.Equal(got, append([]byte{0xAA}, test.encoded...)) {
+			t.Errorf("AppendUint64(%#x) = % X", test.value, got)
+		}
+
+		var low = uint32(test.value)
+
+		if got := Split32BitWord(low); !stdbytes.Equal(got[:], test.encoded[4:]) {
+			t.Errorf("Split32BitWord(%#x) = % X, want % X", low, got, test.encoded[4:])
+		}
+
+		if got := Combine4Bytes([4]byte(test.encoded[4:])); got != low {
+			t.Errorf("Combine4Bytes(% X) = %#x", test.encoded[4:], got)
+		}
+
+		if got := AppendUint32([]byte{0xAA}, low); !stdbytes.Equal(got, append([]byte{0xAA}, test.encoded[4:]...)) {
+			t.Errorf("AppendUint32(%#x) = % X", low, got)
+		}
+
+		if got := AppendUint16([]byte{0xAA}, uint16(low)); !stdbytes.Equal(got, append([]byte{0xAA}, test.encoded[6:]...)) {
+			t.Errorf("AppendUint16(%#x) = % X", uint16(low), got)
+		}
+	}
+}
+
+func TestAppendDoesNotAllocate(t *testing.T) {
+	var buf = make([]byte, 0, 16)
+
+	var allocs = testing.AllocsPerRun(100, func() {
+		var out = AppendUint16(buf[:0], 0x0102)
+		out = AppendUint32(out, 0x03040506)
+		AppendUint64(out, 0x0708090A0B0C0D0E)
+	})
+
+	if allocs != 0 {
+		t.Errorf("appending into a slice with room allocated %v times", allocs)
+	}
+}
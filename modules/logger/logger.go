@@ -0,0 +1,91 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+)
+
+/*
+Logger is what the modules log through, so an embedder picks the logging library: logrusadapter wraps a logrus logger,
+Slog wraps a *slog.Logger and anything else (zap, zerolog...) takes the four methods below. Every module that logs has a
+Logger field or option, nil is Nop and logs nothing, so logging is off unless one is set.
+
+Messages are short and constant, what varies goes into fields, "client_id", "topic" and "error" are the keys the
+modules use for the same things. A Logger may have an Enabled(Level) bool method as well, the modules skip building the
+entries it would drop (the Slog and logrus adapters and Levels have one), see Enabled.
+*/
+
+type Fields map[string]any
+
+type Logger interface {
+	Debug(msg string, fields Fields)
+	Info(msg string, fields Fields)
+	Warn(msg string, fields Fields)
+	Error(msg string, fields Fields)
+}
+
+// Nop discards everything.
+var Nop Logger = nop{}
+
+type nop struct{}
+
+func (nop) Debug(string, Fields) {}
+func (nop) Info(string, Fields)  {}
+func (nop) Warn(string, Fields)  {}
+func (nop) Error(string, Fields) {}
+
+// Or returns l, Nop when l is nil.
+func Or(l Logger) Logger {
+	if l == nil {
+		return Nop
+	}
+
+	return l
+}
+
+// Slog logs to l, the fields become its attributes.
+func Slog(l *slog.Logger) Logger {
+	return slogLogger{l}
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func (s slogLogger) Debug(msg string, fields Fields) { s.log(slog.LevelDebug, msg, fields) }
+func (s slogLogger) Info(msg string, fields Fields)  { s.log(slog.LevelInfo, msg, fields) }
+func (s slogLogger) Warn(msg string, fields Fields)  { s.log(slog.LevelWarn, msg, fields) }
+func (s slogLogger) Error(msg string, fields Fields) { s.log(slog.LevelError, msg, fields) }
+
+func (s slogLogger) Enabled(level Level) bool {
+	var l = slog.LevelError
+
+	switch level {
+	case DebugLevel:
+		l = slog.LevelDebug
+	case InfoLevel:
+		l = slog.LevelInfo
+	case WarnLevel:
+		l = slog.LevelWarn
+	case OffLevel:
+		return false
+	}
+
+	return s.l.Enabled(context.Background(), l)
+}
+
+func (s slogLogger) log(level slog.Level, msg string, fields Fields) {
+	var ctx = context.Background()
+
+	if !s.l.Enabled(ctx, level) {
+		return
+	}
+
+	var attrs = make([]slog.Attr, 0, len(fields))
+
+	for k, v := range fields {
+		attrs = append(attrs, slog.Any(k, v))
+	}
+
+	s.l.LogAttrs(ctx, level, msg, attrs...)
+}
@@ -0,0 +1,92 @@
+package logger
+
+import "context"
+
+/*
+Correlation fields tie the entries of one client, one packet or one topic together however many modules they came
+from. With adds fields to every entry of a Logger, a connection logs through one that carries its client id. A caller
+that wants its own fields (a request id, a tenant) on the entries an API call leads to puts them in the context it
+passes, the modules log through FromContext:
+
+	var ctx = logger.NewContext(ctx, logger.Fields{"request_id": id})
+
+	c.Publish(ctx, "a/b", payload, client.PublishOptions{QoS: 1})
+
+The fields of an entry win over the ones added by With or the context, the innermost With wins over the outer ones.
+*/
+
+// The keys of the correlation fields the modules add.
+const (
+	ClientIDField  = "client_id"
+	PacketIDField  = "packet_id"
+	TopicField     = "topic"
+	SubsystemField = "subsystem"
+)
+
+type contextKey struct{}
+
+// NewContext returns ctx carrying fields on top of the ones it already has.
+func NewContext(ctx context.Context, fields Fields) context.Context {
+	return context.WithValue(ctx, contextKey{}, merge(fields, FieldsFrom(ctx)))
+}
+
+// FieldsFrom returns the fields of ctx, nil when it has none. They must not be changed.
+func FieldsFrom(ctx context.Context) Fields {
+	fields, _ := ctx.Value(contextKey{}).(Fields)
+	return fields
+}
+
+// FromContext returns l adding the fields of ctx, l itself when ctx has none.
+func FromContext(ctx context.Context, l Logger) Logger {
+	return With(l, FieldsFrom(ctx))
+}
+
+// With returns l adding fields to every entry, l itself when there are none. A nil l stays nil.
+func With(l Logger, fields Fields) Logger {
+	if l == nil || l == Nop || len(fields) == 0 {
+		return l
+	}
+
+	return &withFields{to: l, fields: fields}
+}
+
+type withFields struct {
+	to     Logger
+	fields Fields
+}
+
+func (w *withFields) For(subsystem string) Logger {
+	return With(For(w.to, subsystem), w.fields)
+}
+
+func (w *withFields) Enabled(level Level) bool {
+	return Enabled(w.to, level)
+}
+
+func (w *withFields) Debug(msg string, fields Fields) { w.to.Debug(msg, merge(fields, w.fields)) }
+func (w *withFields) Info(msg string, fields Fields)  { w.to.Info(msg, merge(fields, w.fields)) }
+func (w *withFields) Warn(msg string, fields Fields)  { w.to.Warn(msg, merge(fields, w.fields)) }
+func (w *withFields) Error(msg string, fields Fields) { w.to.Error(msg, merge(fields, w.fields)) }
+
+// merge returns the fields of both, fields wins over base for a key they share. Neither is changed.
+func merge(fields, base Fields) Fields {
+	if len(base) == 0 {
+		return fields
+	}
+
+	if len(fields) == 0 {
+		return base
+	}
+
+	var merged = make(Fields, len(fields)+len(base))
+
+	for k, v := range base {
+		merged[k] = v
+	}
+
+	for k, v := range fields {
+		merged[k] = v
+	}
+
+	return merged
+}
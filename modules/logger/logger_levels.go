@@ -0,0 +1,168 @@
+package logger
+
+import "sync"
+
+/*
+Levels filters a Logger by subsystem: every module logs through For(l, <its subsystem>), and when l came from a Levels'
+Wrap that logger drops the entries below the level the subsystem has right now, so a level can be raised for one
+module while the process runs and every entry carries the "subsystem" it came from:
+
+	var levels = logger.NewLevels(logger.InfoLevel)
+
+	options.Logger = levels.Wrap(logger.Slog(slog.Default()))
+	levels.Set(logger.Codec, logger.DebugLevel)
+
+A subsystem without a level of its own has the default one. A Logger that does not come from Levels passes For through
+unchanged, it sees every entry and decides on its own.
+
+The modules check Enabled before they build the fields of a debug entry, so a subsystem that is off costs a lookup.
+*/
+
+type Level int8
+
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+	// OffLevel drops every entry.
+	OffLevel
+)
+
+// The subsystems the modules log as.
+const (
+	Codec     = "codec"
+	Session   = "session"
+	PacketIDs = "packetids"
+	Trie      = "trie"
+	Broker    = "broker"
+	Client    = "client"
+)
+
+func (l Level) String() string {
+	switch l {
+	case DebugLevel:
+		return "debug"
+	case InfoLevel:
+		return "info"
+	case WarnLevel:
+		return "warn"
+	case ErrorLevel:
+		return "error"
+	}
+
+	return "off"
+}
+
+type Levels struct {
+	mu       sync.RWMutex
+	fallback Level
+	levels   map[string]Level
+}
+
+func NewLevels(fallback Level) *Levels {
+	return &Levels{fallback: fallback, levels: make(map[string]Level)}
+}
+
+// Set gives subsystem its own level.
+func (l *Levels) Set(subsystem string, level Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.levels[subsystem] = level
+}
+
+// Reset gives subsystem the default level again.
+func (l *Levels) Reset(subsystem string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.levels, subsystem)
+}
+
+// SetDefault sets the level of every subsystem without one of its own.
+func (l *Levels) SetDefault(level Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.fallback = level
+}
+
+func (l *Levels) Level(subsystem string) Level {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if level, ok := l.levels[subsystem]; ok {
+		return level
+	}
+
+	return l.fallback
+}
+
+// Wrap returns a Logger to to that For turns into the logger of a subsystem, filtered by its level. Used as it is, it
+// has the default level.
+func (l *Levels) Wrap(to Logger) Logger {
+	return &leveled{to: to, levels: l}
+}
+
+// For returns the logger of subsystem, l itself unless it came from a Levels. A nil l stays nil.
+func For(l Logger, subsystem string) Logger {
+	if s, ok := l.(interface{ For(subsystem string) Logger }); ok {
+		return s.For(subsystem)
+	}
+
+	return l
+}
+
+// Enabled says whether l logs entries of level, false for a nil l.
+func Enabled(l Logger, level Level) bool {
+	if l == nil {
+		return false
+	}
+
+	if e, ok := l.(interface{ Enabled(level Level) bool }); ok {
+		return e.Enabled(level)
+	}
+
+	return l != Nop
+}
+
+type leveled struct {
+	to        Logger
+	levels    *Levels
+	subsystem string
+}
+
+func (l *leveled) For(subsystem string) Logger {
+	return &leveled{to: l.to, levels: l.levels, subsystem: subsystem}
+}
+
+func (l *leveled) Enabled(level Level) bool {
+	return level >= l.levels.Level(l.subsystem) && level < OffLevel && Enabled(l.to, level)
+}
+
+func (l *leveled) Debug(msg string, fields Fields) { l.log(DebugLevel, msg, fields) }
+func (l *leveled) Info(msg string, fields Fields)  { l.log(InfoLevel, msg, fields) }
+func (l *leveled) Warn(msg string, fields Fields)  { l.log(WarnLevel, msg, fields) }
+func (l *leveled) Error(msg string, fields Fields) { l.log(ErrorLevel, msg, fields) }
+
+func (l *leveled) log(level Level, msg string, fields Fields) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	if l.subsystem != "" {
+		fields = merge(fields, Fields{SubsystemField: l.subsystem})
+	}
+
+	switch level {
+	case DebugLevel:
+		l.to.Debug(msg, fields)
+	case InfoLevel:
+		l.to.Info(msg, fields)
+	case WarnLevel:
+		l.to.Warn(msg, fields)
+	default:
+		l.to.Error(msg, fields)
+	}
+}
@@ -0,0 +1,55 @@
+package logrusadapter
+
+import (
+	"github.com/MarcusOuelletus/demo/modules/logger"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+logrusadapter logs the modules' logger.Logger calls to logrus, the fields go to WithFields as they are.
+*/
+
+// New logs to l, a *logrus.Logger or a *logrus.Entry that already carries fields of its own.
+func New(l logrus.FieldLogger) logger.Logger {
+	return adapter{l}
+}
+
+type adapter struct {
+	l logrus.FieldLogger
+}
+
+func (a adapter) Debug(msg string, fields logger.Fields) { a.entry(fields).Debug(msg) }
+func (a adapter) Info(msg string, fields logger.Fields)  { a.entry(fields).Info(msg) }
+func (a adapter) Warn(msg string, fields logger.Fields)  { a.entry(fields).Warn(msg) }
+func (a adapter) Error(msg string, fields logger.Fields) { a.entry(fields).Error(msg) }
+
+// Enabled asks the logrus logger when it is a *logrus.Logger or a *logrus.Entry, any other FieldLogger gets every entry.
+func (a adapter) Enabled(level logger.Level) bool {
+	var l *logrus.Logger
+
+	switch t := a.l.(type) {
+	case *logrus.Logger:
+		l = t
+	case *logrus.Entry:
+		l = t.Logger
+	default:
+		return level != logger.OffLevel
+	}
+
+	return level != logger.OffLevel && l.IsLevelEnabled(logrusLevels[level])
+}
+
+var logrusLevels = map[logger.Level]logrus.Level{
+	logger.DebugLevel: logrus.DebugLevel,
+	logger.InfoLevel:  logrus.InfoLevel,
+	logger.WarnLevel:  logrus.WarnLevel,
+	logger.ErrorLevel: logrus.ErrorLevel,
+}
+
+func (a adapter) entry(fields logger.Fields) logrus.FieldLogger {
+	if len(fields) == 0 {
+		return a.l
+	}
+
+	return a.l.WithFields(logrus.Fields(fields))
+}
@@ -0,0 +1,82 @@
+package mqttcodec
+
+import "fmt"
+
+/*
+AUTH only exists in MQTT 5, it carries a reason code and the Authentication Method and Data properties of an
+enhanced authentication exchange. A Remaining Length of 0 is a shorthand for Success with no properties.
+*/
+
+const (
+	AuthSuccess                byte = 0x00
+	AuthContinueAuthentication byte = 0x18
+	AuthReAuthenticate         byte = 0x19
+)
+
+type Auth struct {
+	ReasonCode byte
+	Properties *Properties
+}
+
+func (a *Auth) Type() PacketType { return AUTH }
+
+func (a *Auth) validate() error {
+	switch a.ReasonCode {
+	case AuthSuccess, AuthContinueAuthentication, AuthReAuthenticate:
+	default:
+		return fmt.Errorf("AUTH with invalid reason code 0x%02X", a.ReasonCode)
+	}
+
+	if a.ReasonCode != AuthSuccess && (a.Properties == nil || a.Properties.AuthenticationMethod == "") {
+		return protocolErrorf("AUTH with reason code 0x%02X and no authentication method", a.ReasonCode)
+	}
+
+	return nil
+}
+
+func (a *Auth) Encode() ([]byte, error) {
+	if err := a.validate(); err != nil {
+		return nil, err
+	}
+
+	if a.ReasonCode == AuthSuccess && a.Properties == nil {
+		return encodePacket(AUTH, 0, nil)
+	}
+
+	body, err := a.Properties.appendTo([]byte{a.ReasonCode}, AUTH)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return encodePacket(AUTH, 0, body)
+}
+
+func decodeAuth(d *decoder) (Packet, error) {
+	var a = &Auth{}
+	var err error
+
+	if d.remaining() == 0 {
+		return a, nil
+	}
+
+	if a.ReasonCode, err = d.readByte(); err != nil {
+		return nil, err
+	}
+
+	if d.remaining() > 0 {
+		if a.Properties, err = decodeProperties(d, AUTH); err != nil {
+			return nil, err
+		}
+	}
+
+	if d.remaining() != 0 {
+		return nil, fmt.Errorf("AUTH has %d trailing bytes", d.remaining())
+	}
+
+	if err = a.validate(); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
@@ -0,0 +1,189 @@
+package mqttcodec
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+Builders for the packets whose optional fields depend on each other. The setters only record values and the flags
+they imply (Will sets WillFlag, Password sets PasswordFlag...), Build checks the combination and returns the packet or
+the first problem found, so a packet coming out of a builder always encodes.
+*/
+
+type ConnectBuilder struct {
+	c Connect
+}
+
+type PublishBuilder struct {
+	p Publish
+}
+
+type SubscribeBuilder struct {
+	s Subscribe
+}
+
+func NewConnectBuilder() *ConnectBuilder {
+	return &ConnectBuilder{c: Connect{ProtocolName: ProtocolName, ProtocolLevel: ProtocolLevel, CleanSession: true}}
+}
+
+func (b *ConnectBuilder) ClientID(id string) *ConnectBuilder {
+	b.c.ClientID = id
+	return b
+}
+
+func (b *ConnectBuilder) CleanSession(clean bool) *ConnectBuilder {
+	b.c.CleanSession = clean
+	return b
+}
+
+// Keepalive sets the Keep Alive in seconds, 0 turns it off.
+func (b *ConnectBuilder) Keepalive(seconds uint16) *ConnectBuilder {
+	b.c.KeepAlive = seconds
+	return b
+}
+
+func (b *ConnectBuilder) Will(topic string, message []byte, qos byte, retain bool) *ConnectBuilder {
+	b.c.WillFlag = true
+	b.c.WillTopic = topic
+	b.c.WillMessage = message
+	b.c.WillQoS = qos
+	b.c.WillRetain = retain
+	return b
+}
+
+func (b *ConnectBuilder) Username(username string) *ConnectBuilder {
+	b.c.UsernameFlag = true
+	b.c.Username = username
+	return b
+}
+
+func (b *ConnectBuilder) Password(password []byte) *ConnectBuilder {
+	b.c.PasswordFlag = true
+	b.c.Password = password
+	return b
+}
+
+// Version picks the protocol level, the default is 3.1.1.
+func (b *ConnectBuilder) Version(v ProtocolVersion) *ConnectBuilder {
+	b.c.ProtocolLevel = byte(v)
+	return b
+}
+
+// Properties sets the CONNECT properties, they need Version5.
+func (b *ConnectBuilder) Properties(p *Properties) *ConnectBuilder {
+	b.c.Properties = p
+	return b
+}
+
+// WillProperties sets the properties of the will, they need Version5 and a Will.
+func (b *ConnectBuilder) WillProperties(p *Properties) *ConnectBuilder {
+	b.c.WillProperties = p
+	return b
+}
+
+func (b *ConnectBuilder) Build() (*Connect, error) {
+	var c = b.c
+
+	if c.ProtocolLevel != ProtocolLevel && c.ProtocolLevel != ProtocolLevel5 {
+		return nil, fmt.Errorf("CONNECT with unsupported protocol level %d", c.ProtocolLevel)
+	}
+
+	if c.ProtocolLevel != ProtocolLevel5 && (c.Properties != nil || c.WillProperties != nil) {
+		return nil, fmt.Errorf("CONNECT properties need protocol level %d", ProtocolLevel5)
+	}
+
+	if c.WillProperties != nil && !c.WillFlag {
+		return nil, fmt.Errorf("CONNECT with will properties but no will")
+	}
+
+	if c.WillFlag {
+		if c.WillTopic == "" {
+			return nil, fmt.Errorf("CONNECT with a will and no will topic")
+		}
+
+		if strings.ContainsAny(c.WillTopic, "+#") {
+			return nil, fmt.Errorf("CONNECT will topic %q contains wildcards", c.WillTopic)
+		}
+	}
+
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+func NewPublishBuilder(topic string) *PublishBuilder {
+	return &PublishBuilder{p: Publish{TopicName: topic}}
+}
+
+func (b *PublishBuilder) Payload(payload []byte) *PublishBuilder {
+	b.p.Payload = payload
+	return b
+}
+
+// QoS sets the QoS and, for QoS 1 and 2, the packet id that goes with it.
+func (b *PublishBuilder) QoS(qos byte, id uint16) *PublishBuilder {
+	b.p.QoS = qos
+	b.p.PacketID = id
+	return b
+}
+
+func (b *PublishBuilder) Retain(retain bool) *PublishBuilder {
+	b.p.Retain = retain
+	return b
+}
+
+func (b *PublishBuilder) Dup(dup bool) *PublishBuilder {
+	b.p.Dup = dup
+	return b
+}
+
+// Properties sets the MQTT 5 properties, a connection speaking 3.1.1 drops them.
+func (b *PublishBuilder) Properties(p *Properties) *PublishBuilder {
+	b.p.Properties = p
+	return b
+}
+
+func (b *PublishBuilder) Build() (*Publish, error) {
+	var p = b.p
+
+	if p.QoS == 0 && p.PacketID != 0 {
+		return nil, fmt.Errorf("PUBLISH with qos 0 and packet id %d", p.PacketID)
+	}
+
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+func NewSubscribeBuilder(id uint16) *SubscribeBuilder {
+	return &SubscribeBuilder{s: Subscribe{PacketID: id}}
+}
+
+func (b *SubscribeBuilder) Filter(filter string, qos byte) *SubscribeBuilder {
+	b.s.Subscriptions = append(b.s.Subscriptions, SubscribeFilter{Filter: filter, QoS: qos})
+	return b
+}
+
+func (b *SubscribeBuilder) Build() (*Subscribe, error) {
+	var s = Subscribe{PacketID: b.s.PacketID, Subscriptions: append([]SubscribeFilter(nil), b.s.Subscriptions...)}
+	var seen = make(map[string]bool, len(s.Subscriptions))
+
+	for _, sub := range s.Subscriptions {
+		if seen[sub.Filter] {
+			return nil, fmt.Errorf("SUBSCRIBE lists topic filter %q twice", sub.Filter)
+		}
+
+		seen[sub.Filter] = true
+	}
+
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
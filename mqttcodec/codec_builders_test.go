@@ -0,0 +1,115 @@
+package mqttcodec
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConnectBuilder(t *testing.T) {
+	c, err := NewConnectBuilder().
+		ClientID("c").
+		Keepalive(30).
+		Will("status/c", []byte("gone"), 1, true).
+		Username("user").
+		Password([]byte("secret")).
+		Version(Version5).
+		WillProperties(&Properties{WillDelayInterval: Uint32(10)}).
+		Build()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// -- the setters imply their flags
+	if !c.WillFlag || !c.UsernameFlag || !c.PasswordFlag || !c.CleanSession || c.ProtocolLevel != ProtocolLevel5 {
+		t.Fatalf("built %s", Dump(c))
+	}
+	// --
+
+	if _, err := c.Encode(); err != nil {
+		t.Fatalf("a built CONNECT does not encode: %v", err)
+	}
+}
+
+func TestConnectBuilderRefuses(t *testing.T) {
+	var tests = []struct {
+		name    string
+		builder *ConnectBuilder
+	}{
+		{"protocol level 3", NewConnectBuilder().ClientID("c").Version(3)},
+		{"properties on 3.1.1", NewConnectBuilder().ClientID("c").Properties(&Properties{})},
+		{"will properties without a will", NewConnectBuilder().ClientID("c").Version(Version5).WillProperties(&Properties{})},
+		{"will without a topic", NewConnectBuilder().ClientID("c").Will("", nil, 0, false)},
+		{"will topic with a wildcard", NewConnectBuilder().ClientID("c").Will("a/+", nil, 0, false)},
+		{"will qos 3", NewConnectBuilder().ClientID("c").Will("a", nil, 3, false)},
+		{"password without a username on 3.1.1", NewConnectBuilder().ClientID("c").Password([]byte("p"))},
+	}
+
+	for _, test := range tests {
+		if c, err := test.builder.Build(); err == nil {
+			t.Errorf("%s: built %s", test.name, Dump(c))
+		}
+	}
+}
+
+func TestPublishBuilder(t *testing.T) {
+	p, err := NewPublishBuilder("a/b").Payload([]byte("x")).QoS(1, 7).Retain(true).Dup(true).Build()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var want = &Publish{TopicName: "a/b", Payload: []byte("x"), QoS: 1, PacketID: 7, Retain: true, Dup: true}
+
+	if !reflect.DeepEqual(p, want) {
+		t.Fatalf("built %s, want %s", Dump(p), Dump(want))
+	}
+
+	if _, err := p.Encode(); err != nil {
+		t.Fatalf("a built PUBLISH does not encode: %v", err)
+	}
+
+	for name, b := range map[string]*PublishBuilder{
+		"qos 0 with a packet id": NewPublishBuilder("a").QoS(0, 1),
+		"qos 1 without one":      NewPublishBuilder("a").QoS(1, 0),
+		"wildcard topic":         NewPublishBuilder("a/#"),
+		"qos 3":                  NewPublishBuilder("a").QoS(3, 1),
+	} {
+		if p, err := b.Build(); err == nil {
+			t.Errorf("%s: built %s", name, Dump(p))
+		}
+	}
+}
+
+func TestSubscribeBuilder(t *testing.T) {
+	var b = NewSubscribeBuilder(3).Filter("a/+", 1).Filter("b/#", 2)
+
+	s, err := b.Build()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// -- Build copies the filters, adding to the builder afterwards leaves the packet alone
+	b.Filter("c", 0)
+
+	if len(s.Subscriptions) != 2 || s.PacketID != 3 {
+		t.Fatalf("built %s", Dump(s))
+	}
+	// --
+
+	if _, err := s.Encode(); err != nil {
+		t.Fatalf("a built SUBSCRIBE does not encode: %v", err)
+	}
+
+	for name, b := range map[string]*SubscribeBuilder{
+		"no filters":        NewSubscribeBuilder(1),
+		"a filter twice":    NewSubscribeBuilder(1).Filter("a", 0).Filter("a", 1),
+		"packet id 0":       NewSubscribeBuilder(0).Filter("a", 0),
+		"an invalid filter": NewSubscribeBuilder(1).Filter("a/#/b", 0),
+	} {
+		if s, err := b.Build(); err == nil {
+			t.Errorf("%s: built %s", name, Dump(s))
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package mqttcodec
+
+import (
+	"fmt"
+
+	"github.com/MarcusOuelletus/demo/reasoncodes"
+)
+
+const (
+	ProtocolName  = "MQTT"
+	ProtocolLevel = 4
+	// ProtocolLevel5 is the protocol level of MQTT 5, CONNECT and CONNACK carry properties at this level.
+	ProtocolLevel5 = 5
+)
+
+const (
+	ConnackAccepted              byte = 0x00
+	ConnackUnacceptableProtocol  byte = 0x01
+	ConnackIdentifierRejected    byte = 0x02
+	ConnackServerUnavailable     byte = 0x03
+	ConnackBadUsernameOrPassword byte = 0x04
+	ConnackNotAuthorized         byte = 0x05
+)
+
+const (
+	connectFlagReserved     byte = 0x01
+	connectFlagCleanSession byte = 0x02
+	connectFlagWill         byte = 0x04
+	connectFlagWillRetain   byte = 0x20
+	connectFlagPassword     byte = 0x40
+	connectFlagUsername     byte = 0x80
+	connectFlagWillQoSShift      = 3
+)
+
+type Connect struct {
+	ProtocolName  string
+	ProtocolLevel byte
+	CleanSession  bool
+	KeepAlive     uint16
+	ClientID      string
+	WillFlag      bool
+	WillQoS       byte
+	WillRetain    bool
+	WillTopic     string
+	WillMessage   []byte
+	UsernameFlag  bool
+	Username      string
+	PasswordFlag  bool
+	Password      []byte
+	// Properties and WillProperties are only encoded at ProtocolLevel5.
+	Properties     *Properties
+	WillProperties *Properties
+}
+
+// Connack carries a 3.1.1 return code, or an MQTT 5 reason code in ReturnCode on a connection using properties.
+type Connack struct {
+	SessionPresent bool
+	ReturnCode     byte
+	Properties     *Properties
+}
+
+func (c *Connect) Type() PacketType { return CONNECT }
+
+func (c *Connack) Type() PacketType { return CONNACK }
+
+func (c *Connect) Encode() ([]byte, error) {
+	var err error
+	var flags byte
+
+	if err = c.validate(); err != nil {
+		return nil, err
+	}
+
+	var name = c.ProtocolName
+	var level = c.ProtocolLevel
+
+	// -- an empty name is MQTT at the given level, 3.1.1 when that is not 5
+	if name == "" {
+		name = ProtocolName
+
+		if level != ProtocolLevel5 {
+			level = ProtocolLevel
+		}
+	}
+	// --
+
+	if c.CleanSession {
+		flags |= connectFlagCleanSession
+	}
+
+	if c.WillFlag {
+		flags |= connectFlagWill | c.WillQoS<<connectFlagWillQoSShift
+		if c.WillRetain {
+			flags |= connectFlagWillRetain
+		}
+	}
+
+	if c.UsernameFlag {
+		flags |= connectFlagUsername
+	}
+
+	if c.PasswordFlag {
+		flags |= connectFlagPassword
+	}
+
+	var body []byte
+
+	if body, err = appendString(body, name); err != nil {
+		return nil, err
+	}
+
+	body = append(body, level, flags)
+	body = appendUint16(body, c.KeepAlive)
+
+	if level == ProtocolLevel5 {
+		if body, err = c.Properties.appendTo(body, CONNECT); err != nil {
+			return nil, err
+		}
+	}
+
+	if body, err = appendString(body, c.ClientID); err != nil {
+		return nil, err
+	}
+
+	if c.WillFlag {
+		if level == ProtocolLevel5 {
+			if body, err = c.WillProperties.appendTo(body, WILL); err != nil {
+				return nil, err
+			}
+		}
+		if body, err = appendString(body, c.WillTopic); err != nil {
+			return nil, err
+		}
+		if body, err = appendBinary(body, c.WillMessage); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.UsernameFlag {
+		if body, err = appendString(body, c.Username); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.PasswordFlag {
+		if body, err = appendBinary(body, c.Password); err != nil {
+			return nil, err
+		}
+	}
+
+	return encodePacket(CONNECT, 0, body)
+}
+
+func (c *Connect) validate() error {
+	if c.WillFlag && c.WillQoS > 2 {
+		return fmt.Errorf("CONNECT with will qos %d", c.WillQoS)
+	}
+
+	if !c.WillFlag && (c.WillQoS != 0 || c.WillRetain) {
+		return fmt.Errorf("CONNECT with will qos or retain but no will")
+	}
+
+	// MQTT 5 allows a password without a username, an empty client id with Clean Start = 0 is for the server to accept
+	// or refuse
+	if c.PasswordFlag && !c.UsernameFlag && c.ProtocolLevel != ProtocolLevel5 {
+		return fmt.Errorf("CONNECT with a password but no username")
+	}
+
+	return nil
+}
+
+func decodeConnect(d *decoder) (Packet, error) {
+	var c = &Connect{}
+	var err error
+
+	if c.ProtocolName, err = d.readString(); err != nil {
+		return nil, err
+	}
+
+	if c.ProtocolLevel, err = d.readByte(); err != nil {
+		return nil, err
+	}
+
+	var supported = c.ProtocolName == ProtocolName && (c.ProtocolLevel == ProtocolLevel || c.ProtocolLevel == ProtocolLevel5)
+
+	if !supported && !(c.ProtocolName == "MQIsdp" && c.ProtocolLevel == 3) {
+		return nil, reasonErrorf(reasoncodes.UnsupportedProtocolVersion, "CONNECT with unsupported protocol %q level %d", c.ProtocolName, c.ProtocolLevel)
+	}
+
+	flags, err := d.readByte()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if flags&connectFlagReserved != 0 {
+		return nil, fmt.Errorf("CONNECT with the reserved flag set")
+	}
+
+	c.CleanSession = flags&connectFlagCleanSession != 0
+	c.WillFlag = flags&connectFlagWill != 0
+	c.WillQoS = (flags >> connectFlagWillQoSShift) & 0x03
+	c.WillRetain = flags&connectFlagWillRetain != 0
+	c.UsernameFlag = flags&connectFlagUsername != 0
+	c.PasswordFlag = flags&connectFlagPassword != 0
+
+	if c.KeepAlive, err = d.readUint16(); err != nil {
+		return nil, err
+	}
+
+	if c.ProtocolLevel == ProtocolLevel5 {
+		if c.Properties, err = decodeProperties(d, CONNECT); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.ClientID, err = d.readString(); err != nil {
+		return nil, err
+	}
+
+	// -- validate needs the client id as well as the flags
+	if err = c.validate(); err != nil {
+		return nil, err
+	}
+	// --
+
+	if c.WillFlag {
+		if c.ProtocolLevel == ProtocolLevel5 {
+			if c.WillProperties, err = decodeProperties(d, WILL); err != nil {
+				return nil, err
+			}
+		}
+		if c.WillTopic, err = d.readString(); err != nil {
+			return nil, err
+		}
+		if c.WillMessage, err = d.readBinary(); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.UsernameFlag {
+		if c.Username, err = d.readString(); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.PasswordFlag {
+		if c.Password, err = d.readBinary(); err != nil {
+			return nil, err
+		}
+	}
+
+	if d.remaining() != 0 {
+		return nil, fmt.Errorf("CONNECT has %d trailing bytes", d.remaining())
+	}
+
+	return c, nil
+}
+
+func (c *Connack) Encode() ([]byte, error) {
+	var ack byte
+
+	if c.SessionPresent {
+		ack = 0x01
+	}
+
+	if c.Properties == nil {
+		return encodePacket(CONNACK, 0, []byte{ack, c.ReturnCode})
+	}
+
+	body, err := c.Properties.appendTo([]byte{ack, c.ReturnCode}, CONNACK)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return encodePacket(CONNACK, 0, body)
+}
+
+func decodeConnack(d *decoder) (Packet, error) {
+	// -- an MQTT 5 client still has to read the 2 byte CONNACK of a 3.1.1 server refusing protocol level 5
+	if d.remaining() != 2 && !(d.v5() && d.remaining() > 2) {
+		return nil, fmt.Errorf("CONNACK body is %d bytes, expected 2", d.remaining())
+	}
+	// --
+
+	var c = &Connack{}
+	var err error
+
+	ack, _ := d.readByte()
+	c.ReturnCode, _ = d.readByte()
+	c.SessionPresent = ack == 0x01
+
+	if ack&0xFE != 0 {
+		return nil, fmt.Errorf("CONNACK with reserved acknowledge flags set")
+	}
+
+	if d.remaining() > 0 {
+		if c.Properties, err = decodeProperties(d, CONNACK); err != nil {
+			return nil, err
+		}
+
+		if d.remaining() != 0 {
+			return nil, fmt.Errorf("CONNACK has %d trailing bytes", d.remaining())
+		}
+	}
+
+	if c.ReturnCode > ConnackNotAuthorized && !(c.Properties != nil && c.ReturnCode >= 0x80) {
+		return nil, fmt.Errorf("CONNACK with unknown return code 0x%02X", c.ReturnCode)
+	}
+
+	if c.ReturnCode != ConnackAccepted && c.SessionPresent {
+		return nil, protocolErrorf("CONNACK refusing the connection with session present set")
+	}
+
+	return c, nil
+}
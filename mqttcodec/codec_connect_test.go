@@ -0,0 +1,70 @@
+package mqttcodec
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConnectRoundTrip(t *testing.T) {
+	var tests = []struct {
+		name    string
+		connect *Connect
+		want    *Connect
+	}{
+		{
+			"default name at level 5 keeps the properties",
+			&Connect{ProtocolLevel: ProtocolLevel5, ClientID: "c", CleanSession: true, Properties: &Properties{ReceiveMaximum: Uint16(5)}},
+			&Connect{ProtocolName: ProtocolName, ProtocolLevel: ProtocolLevel5, ClientID: "c", CleanSession: true, Properties: &Properties{ReceiveMaximum: Uint16(5)}},
+		},
+		{
+			"default name and level",
+			&Connect{ClientID: "c", CleanSession: true},
+			&Connect{ProtocolName: ProtocolName, ProtocolLevel: ProtocolLevel, ClientID: "c", CleanSession: true},
+		},
+		{
+			"password without a username on MQTT 5",
+			&Connect{ProtocolLevel: ProtocolLevel5, ClientID: "c", PasswordFlag: true, Password: []byte("token")},
+			&Connect{ProtocolName: ProtocolName, ProtocolLevel: ProtocolLevel5, ClientID: "c", PasswordFlag: true, Password: []byte("token"), Properties: &Properties{}},
+		},
+		{
+			"empty client id without clean session",
+			&Connect{ProtocolLevel: ProtocolLevel5},
+			&Connect{ProtocolName: ProtocolName, ProtocolLevel: ProtocolLevel5, Properties: &Properties{}},
+		},
+		{
+			"empty client id without clean session on 3.1.1",
+			&Connect{},
+			&Connect{ProtocolName: ProtocolName, ProtocolLevel: ProtocolLevel},
+		},
+	}
+
+	for _, test := range tests {
+		data, err := test.connect.Encode()
+
+		if err != nil {
+			t.Errorf("%s: encoding: %v", test.name, err)
+			continue
+		}
+
+		var d = &decoder{data: data}
+		h, _ := ReadFixedHeader(d)
+		got, err := VersionOf(test.want).Decode(h, d.rest())
+
+		if err != nil {
+			t.Errorf("%s: decoding: %v", test.name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: decoded %s, want %s", test.name, Dump(got), Dump(test.want))
+		}
+	}
+}
+
+func TestConnectPasswordWithoutUsername(t *testing.T) {
+	var c = &Connect{ClientID: "c", CleanSession: true, PasswordFlag: true, Password: []byte("secret")}
+
+	if _, err := c.Encode(); err == nil {
+		t.Fatal("3.1.1 CONNECT with a password but no username encoded")
+	}
+}
@@ -0,0 +1,80 @@
+package mqttcodec
+
+import "fmt"
+
+// Empty is PINGREQ, PINGRESP and DISCONNECT, which have no variable header or payload in 3.1.1.
+type Empty struct {
+	PacketType PacketType
+}
+
+var (
+	Pingreq    = &Empty{PacketType: PINGREQ}
+	Pingresp   = &Empty{PacketType: PINGRESP}
+	Disconnect = &Empty{PacketType: DISCONNECT}
+)
+
+func (e *Empty) Type() PacketType { return e.PacketType }
+
+func (e *Empty) Encode() ([]byte, error) {
+	switch e.PacketType {
+	case PINGREQ, PINGRESP, DISCONNECT:
+		return []byte{byte(e.PacketType) << 4, 0}, nil
+	}
+
+	return nil, fmt.Errorf("%s is not an empty packet", e.PacketType)
+}
+
+// Disconnection is the MQTT 5 DISCONNECT, a Success reason code with no properties encodes as the empty 3.1.1 form.
+type Disconnection struct {
+	ReasonCode byte
+	Properties *Properties
+}
+
+func (d *Disconnection) Type() PacketType { return DISCONNECT }
+
+func (d *Disconnection) Encode() ([]byte, error) {
+	if d.ReasonCode == 0 && d.Properties == nil {
+		return Disconnect.Encode()
+	}
+
+	var body = []byte{d.ReasonCode}
+
+	if d.Properties != nil {
+		var err error
+
+		if body, err = d.Properties.appendTo(body, DISCONNECT); err != nil {
+			return nil, err
+		}
+	}
+
+	return encodePacket(DISCONNECT, 0, body)
+}
+
+func decodeDisconnection(d *decoder) (Packet, error) {
+	var p = &Disconnection{}
+	var err error
+
+	if d.remaining() > 0 {
+		p.ReasonCode, _ = d.readByte()
+	}
+
+	if d.remaining() > 0 {
+		if p.Properties, err = decodeProperties(d, DISCONNECT); err != nil {
+			return nil, err
+		}
+
+		if d.remaining() != 0 {
+			return nil, fmt.Errorf("DISCONNECT has %d trailing bytes", d.remaining())
+		}
+	}
+
+	return p, nil
+}
+
+func decodeEmpty(t PacketType, d *decoder) (Packet, error) {
+	if d.remaining() != 0 {
+		return nil, fmt.Errorf("%s with a %d byte body", t, d.remaining())
+	}
+
+	return &Empty{PacketType: t}, nil
+}
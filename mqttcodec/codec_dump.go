@@ -0,0 +1,193 @@
+package mqttcodec
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/MarcusOuelletus/demo/modules/helpers/bytes"
+	"github.com/MarcusOuelletus/demo/modules/logger"
+)
+
+/*
+Dump renders a packet as one line of text for logs and debugging, payloads and passwords only show their length.
+
+A Tracer set on a PacketReader or PacketWriter logs every packet that goes through it to its Logger at debug level
+with the direction, type, packet id, header flags, size and a hexdump of the first MaxDump bytes of the encoded packet.
+Enable traces at info level instead, see TraceOptions.
+*/
+
+type Direction byte
+
+const (
+	Inbound Direction = iota
+	Outbound
+)
+
+var DefaultMaxDump = 64
+
+type Tracer struct {
+	Logger  logger.Logger
+	MaxDump int
+	// Rate caps the packets logged per second, Burst of them at once (0 is Rate rounded up), 0 logs every packet.
+	Rate    float64
+	Burst   int
+	limiter traceLimiter
+	enabled atomic.Pointer[tracing]
+}
+
+func NewTracer(l logger.Logger) *Tracer {
+	return &Tracer{Logger: l, MaxDump: DefaultMaxDump}
+}
+
+func (d Direction) String() string {
+	if d == Inbound {
+		return "in"
+	}
+
+	return "out"
+}
+
+// PacketID returns the packet id of p, 0 for packets that carry none.
+func PacketID(p Packet) uint16 {
+	switch p := p.(type) {
+	case *Publish:
+		return p.PacketID
+	case *Ack:
+		return p.PacketID
+	case *Subscribe:
+		return p.PacketID
+	case *Suback:
+		return p.PacketID
+	case *Unsubscribe:
+		return p.PacketID
+	case *Unsuback:
+		return p.PacketID
+	}
+
+	return 0
+}
+
+func Dump(p Packet) string {
+	var b strings.Builder
+
+	b.WriteString(p.Type().String())
+
+	switch p := p.(type) {
+	case *Connect:
+		fmt.Fprintf(&b, " client_id=%q clean_session=%t keepalive=%d", p.ClientID, p.CleanSession, p.KeepAlive)
+		if p.WillFlag {
+			fmt.Fprintf(&b, " will_topic=%q will_qos=%d will_retain=%t will_message=%dB", p.WillTopic, p.WillQoS, p.WillRetain, len(p.WillMessage))
+		}
+		if p.UsernameFlag {
+			fmt.Fprintf(&b, " username=%q", p.Username)
+		}
+		if p.PasswordFlag {
+			fmt.Fprintf(&b, " password=%dB", len(p.Password))
+		}
+	case *Connack:
+		fmt.Fprintf(&b, " session_present=%t return_code=0x%02X", p.SessionPresent, p.ReturnCode)
+	case *Publish:
+		fmt.Fprintf(&b, " topic=%q qos=%d retain=%t dup=%t", p.TopicName, p.QoS, p.Retain, p.Dup)
+		if p.QoS > 0 {
+			fmt.Fprintf(&b, " id=%d", p.PacketID)
+		}
+		if p.hasTopicAlias() {
+			fmt.Fprintf(&b, " alias=%d", *p.Properties.TopicAlias)
+		}
+		fmt.Fprintf(&b, " payload=%dB", len(p.Payload))
+	case *Ack:
+		fmt.Fprintf(&b, " id=%d", p.PacketID)
+		if p.ReasonCode != 0 {
+			fmt.Fprintf(&b, " reason_code=0x%02X", p.ReasonCode)
+		}
+	case *Unsuback:
+		fmt.Fprintf(&b, " id=%d reason_codes=% X", p.PacketID, p.ReasonCodes)
+	case *Disconnection:
+		fmt.Fprintf(&b, " reason_code=0x%02X", p.ReasonCode)
+	case *Subscribe:
+		fmt.Fprintf(&b, " id=%d", p.PacketID)
+		for _, sub := range p.Subscriptions {
+			fmt.Fprintf(&b, " %q:%d", sub.Filter, sub.QoS)
+		}
+	case *Suback:
+		fmt.Fprintf(&b, " id=%d return_codes=% X", p.PacketID, p.ReturnCodes)
+	case *Unsubscribe:
+		fmt.Fprintf(&b, " id=%d", p.PacketID)
+		for _, filter := range p.Filters {
+			fmt.Fprintf(&b, " %q", filter)
+		}
+	case *Auth:
+		fmt.Fprintf(&b, " reason_code=0x%02X", p.ReasonCode)
+		if p.Properties != nil && p.Properties.AuthenticationMethod != "" {
+			fmt.Fprintf(&b, " method=%q data=%dB", p.Properties.AuthenticationMethod, len(p.Properties.AuthenticationData))
+		}
+	}
+
+	return b.String()
+}
+
+// Trace logs packet p, raw is its encoding with the fixed header.
+func (t *Tracer) Trace(dir Direction, p Packet, raw []byte) {
+	settings, active := t.settings()
+
+	if !active {
+		return
+	}
+
+	dropped, ok := settings.limiter.allow(settings.rate, settings.burst)
+
+	if !ok {
+		return
+	}
+
+	var fields = logger.Fields{
+		"direction": dir.String(),
+		"type":      p.Type().String(),
+		"size":      len(raw),
+	}
+
+	if len(raw) > 0 {
+		fields["flags"] = fmt.Sprintf("0x%X", raw[0]&0x0F)
+	}
+
+	if id := PacketID(p); id != 0 {
+		fields[logger.PacketIDField] = id
+	}
+
+	if dropped > 0 {
+		fields["dropped"] = dropped
+	}
+
+	if settings.maxDump > 0 {
+		var dump = raw
+
+		if len(dump) > settings.maxDump {
+			dump = dump[:settings.maxDump]
+			fields["truncated"] = true
+		}
+
+		fields["hex"] = hex.EncodeToString(dump)
+	}
+
+	if settings.level == logger.InfoLevel {
+		t.Logger.Info(Dump(p), fields)
+	} else {
+		t.Logger.Debug(Dump(p), fields)
+	}
+}
+
+func (t *Tracer) traceFrame(p Packet, h FixedHeader, body []byte) {
+	if _, active := t.settings(); !active {
+		return
+	}
+
+	var raw = make([]byte, 0, 5+len(body))
+
+	raw = append(raw, byte(h.Type)<<4|h.Flags)
+	raw = bytes.AppendVarByteInt(raw, uint32(len(body)))
+	raw = append(raw, body...)
+
+	t.Trace(Inbound, p, raw)
+}
@@ -0,0 +1,94 @@
+package mqttcodec
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/MarcusOuelletus/demo/reasoncodes"
+)
+
+/*
+Every error Decode returns is a *DecodeError. It carries the type of the packet being decoded, the byte offset into the
+packet (the fixed header included) where decoding stopped, and the reason code that the DISCONNECT (or CONNACK, for
+CONNECT) closing the connection should use. Anything not explicitly classified as a Protocol Error or a more specific
+reason is a Malformed Packet.
+*/
+
+type DecodeError struct {
+	PacketType PacketType
+	Offset     int
+	ReasonCode reasoncodes.Code
+	Err        error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("decoding %s at byte %d (reason 0x%02X): %v", e.PacketType, e.Offset, e.ReasonCode, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// classifiedError tags an error with the reason code it should be reported with.
+type classifiedError struct {
+	reason reasoncodes.Code
+	err    error
+}
+
+func (e *classifiedError) Error() string { return e.err.Error() }
+
+func (e *classifiedError) Unwrap() error { return e.err }
+
+// offsetError pins an error to an absolute offset, used where a nested decoder knows better than Decode where it failed.
+type offsetError struct {
+	offset int
+	err    error
+}
+
+func (e *offsetError) Error() string { return e.err.Error() }
+
+func (e *offsetError) Unwrap() error { return e.err }
+
+func protocolErrorf(format string, args ...any) error {
+	return &classifiedError{reason: reasoncodes.ProtocolError, err: fmt.Errorf(format, args...)}
+}
+
+func reasonErrorf(reason reasoncodes.Code, format string, args ...any) error {
+	return &classifiedError{reason: reason, err: fmt.Errorf(format, args...)}
+}
+
+func newDecodeError(h FixedHeader, offset int, err error) error {
+	var decodeErr *DecodeError
+
+	if errors.As(err, &decodeErr) {
+		return err
+	}
+
+	var e = &DecodeError{PacketType: h.Type, Offset: offset, ReasonCode: reasoncodes.MalformedPacket, Err: err}
+
+	var classified *classifiedError
+	if errors.As(err, &classified) {
+		e.ReasonCode = classified.reason
+	}
+
+	// -- Nested decoders can each pin an offset, the innermost one is the most precise.
+	for inner := err; inner != nil; inner = errors.Unwrap(inner) {
+		if positioned, ok := inner.(*offsetError); ok {
+			e.Offset = positioned.offset
+		}
+	}
+	// --
+
+	return e
+}
+
+// ReasonCode returns the reason code a decode error should be answered with, 0 when err isn't a decode error.
+func ReasonCode(err error) reasoncodes.Code {
+	var decodeErr *DecodeError
+
+	if errors.As(err, &decodeErr) {
+		return decodeErr.ReasonCode
+	}
+
+	return 0
+}
@@ -0,0 +1,258 @@
+package mqttcodec
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/MarcusOuelletus/demo/modules/helpers/bytes"
+	"github.com/MarcusOuelletus/demo/reasoncodes"
+)
+
+/*
+Fuzz targets for the codec. Each one holds the codec to one property: input that is rejected is fine, input that is
+accepted has to encode again and decode back to the same value (for Variable Byte Integers and strings the encoding is
+canonical, so the bytes have to match too).
+
+The seed corpus of FuzzDecodePacket is generated from the encoder, go test -run TestSeedCorpus -update-corpus rewrites
+testdata/fuzz/FuzzDecodePacket after a packet type or field was added.
+*/
+
+var updateCorpus = flag.Bool("update-corpus", false, "rewrite the seed corpus in testdata/fuzz")
+
+func FuzzDecodePacket(f *testing.F) {
+	for _, data := range seedCorpus(f) {
+		f.Add(data)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if err := checkRoundTrip(data); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func FuzzVarByteInt(f *testing.F) {
+	for _, v := range []uint32{0, 127, 128, 16383, 16384, 2097151, 2097152, bytes.MaxVarByteInt} {
+		f.Add(bytes.EncodeVarByteInt(v))
+	}
+
+	f.Add([]byte{0x80, 0x00})
+	f.Add([]byte{0xFF, 0xFF, 0xFF, 0xFF, 0x7F})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if err := checkVarByteInt(data); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func FuzzString(f *testing.F) {
+	for _, s := range []string{"", "a/b", "日本語", "\U0001F600"} {
+		encoded, _ := bytes.EncodeString(s)
+		f.Add(encoded)
+	}
+
+	f.Add([]byte{0x00, 0x01, 0x00})
+	f.Add([]byte{0x00, 0x03, 0xED, 0xA0, 0x80})
+	f.Add([]byte{0x00, 0x05, 'a'})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if err := checkString(data); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// TestSeedCorpus checks that every seed decodes and round trips, with -update-corpus it writes the seeds to testdata.
+func TestSeedCorpus(t *testing.T) {
+	var corpus = seedCorpus(t)
+
+	for _, data := range corpus {
+		if err := checkRoundTrip(data); err != nil {
+			t.Fatal(err)
+		}
+
+		var d = &decoder{data: data}
+		h, _ := ReadFixedHeader(d)
+		var body = d.rest()
+		_, err311 := Version311.Decode(h, body)
+		_, err5 := Version5.Decode(h, body)
+
+		if err311 != nil && err5 != nil {
+			t.Fatalf("seed % X decodes in neither version: %v, %v", data, err311, err5)
+		}
+	}
+
+	if *updateCorpus {
+		if err := writeCorpus(filepath.Join("testdata", "fuzz", "FuzzDecodePacket"), corpus); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// checkRoundTrip decodes data as one complete packet of each protocol version, a packet that decodes has to round
+// trip through Encode.
+func checkRoundTrip(data []byte) error {
+	for _, v := range []ProtocolVersion{Version311, Version5} {
+		if err := checkVersionRoundTrip(data, v); err != nil {
+			return fmt.Errorf("%s: %v", v, err)
+		}
+	}
+
+	return nil
+}
+
+func checkVersionRoundTrip(data []byte, v ProtocolVersion) error {
+	var d = &decoder{data: data}
+
+	h, err := ReadFixedHeader(d)
+
+	if err != nil || d.remaining() != h.RemainingLength {
+		return nil
+	}
+
+	p, err := v.Decode(h, d.rest())
+
+	if err != nil {
+		return nil
+	}
+
+	encoded, err := p.Encode()
+
+	if err != nil {
+		return fmt.Errorf("decoded %s does not encode: %v", Dump(p), err)
+	}
+
+	var e = &decoder{data: encoded}
+
+	h2, err := ReadFixedHeader(e)
+
+	if err != nil {
+		return fmt.Errorf("encoded %s has a broken fixed header: %v", Dump(p), err)
+	}
+
+	p2, err := v.Decode(h2, e.rest())
+
+	if err != nil {
+		return fmt.Errorf("encoded %s does not decode: %v", Dump(p), err)
+	}
+
+	if !reflect.DeepEqual(p, p2) {
+		return fmt.Errorf("round trip changed %s into %s", Dump(p), Dump(p2))
+	}
+
+	return nil
+}
+
+func checkVarByteInt(data []byte) error {
+	v, n, err := bytes.DecodeVarByteInt(&decoder{data: data})
+
+	if err != nil {
+		return nil
+	}
+
+	if encoded := bytes.EncodeVarByteInt(v); string(encoded) != string(data[:n]) {
+		return fmt.Errorf("%d decoded from % X encodes as % X", v, data[:n], encoded)
+	}
+
+	return nil
+}
+
+func checkString(data []byte) error {
+	s, n, err := bytes.DecodeString(data)
+
+	if err != nil {
+		return nil
+	}
+
+	encoded, err := bytes.EncodeString(s)
+
+	if err != nil {
+		return fmt.Errorf("%q decoded from % X does not encode: %v", s, data[:n], err)
+	}
+
+	if string(encoded) != string(data[:n]) {
+		return fmt.Errorf("%q decoded from % X encodes as % X", s, data[:n], encoded)
+	}
+
+	return nil
+}
+
+// seedCorpus returns encoded packets of every type in both protocol versions, with the optional parts present and
+// absent.
+func seedCorpus(tb testing.TB) [][]byte {
+	var packets = []Packet{
+		&Connect{ClientID: "client", CleanSession: true, KeepAlive: 30},
+		&Connect{
+			ClientID: "client", KeepAlive: 60,
+			WillFlag: true, WillQoS: 1, WillRetain: true, WillTopic: "will/topic", WillMessage: []byte("gone"),
+			UsernameFlag: true, Username: "user", PasswordFlag: true, Password: []byte("secret"),
+		},
+		&Connack{SessionPresent: true, ReturnCode: ConnackAccepted},
+		&Connack{ReturnCode: ConnackNotAuthorized},
+		&Publish{TopicName: "a/b", Payload: []byte("qos0")},
+		&Publish{TopicName: "a/b", QoS: 1, PacketID: 1, Retain: true, Payload: []byte("qos1")},
+		&Publish{TopicName: "a/b/c", QoS: 2, PacketID: 65535, Dup: true},
+		NewPuback(1),
+		NewPubrec(2),
+		NewPubrel(3),
+		NewPubcomp(4),
+		&Subscribe{PacketID: 5, Subscriptions: []SubscribeFilter{{Filter: "a/+/c", QoS: 1}, {Filter: "#", QoS: 2}}},
+		&Suback{PacketID: 5, ReturnCodes: []byte{1, 2, SubackFailure}},
+		&Unsubscribe{PacketID: 6, Filters: []string{"a/+/c", "#"}},
+		NewUnsuback(6),
+		Pingreq,
+		Pingresp,
+		Disconnect,
+		&Auth{},
+		&Auth{ReasonCode: AuthContinueAuthentication, Properties: &Properties{AuthenticationMethod: "SCRAM-SHA-256", AuthenticationData: []byte("n,,n=user,r=nonce")}},
+		&Connect{
+			ProtocolLevel: ProtocolLevel5, ClientID: "client", CleanSession: true,
+			Properties: &Properties{SessionExpiryInterval: Uint32(3600), ReceiveMaximum: Uint16(10)},
+			WillFlag:   true, WillTopic: "will/topic", WillProperties: &Properties{WillDelayInterval: Uint32(5)},
+		},
+		&Connack{ReturnCode: byte(reasoncodes.Banned), Properties: &Properties{ReasonString: "banned"}},
+		&Publish{TopicName: "a/b", QoS: 1, PacketID: 7, Properties: &Properties{TopicAlias: Uint16(1), UserProperties: []UserProperty{{Key: "k", Value: "v"}}}},
+		&Ack{PacketType: PUBACK, PacketID: 7, ReasonCode: 0x10, Properties: &Properties{}},
+		&Subscribe{PacketID: 8, Properties: &Properties{SubscriptionIdentifiers: []uint32{1}}, Subscriptions: []SubscribeFilter{{Filter: "a/#", QoS: 1, NoLocal: true, RetainAsPublished: true, RetainHandling: 2}}},
+		&Suback{PacketID: 8, ReturnCodes: []byte{1, 0x97}, Properties: &Properties{}},
+		&Unsuback{PacketID: 9, ReasonCodes: []byte{0x00, 0x11}},
+		&Disconnection{ReasonCode: 0x04, Properties: &Properties{}},
+	}
+
+	var corpus = make([][]byte, 0, len(packets))
+
+	for _, p := range packets {
+		data, err := p.Encode()
+
+		if err != nil {
+			tb.Fatalf("seed %s does not encode: %v", Dump(p), err)
+		}
+
+		corpus = append(corpus, data)
+	}
+
+	return corpus
+}
+
+// writeCorpus writes every seed as a file of dir in the go test fuzz v1 format.
+func writeCorpus(dir string, corpus [][]byte) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	for i, data := range corpus {
+		var content = "go test fuzz v1\n[]byte(" + strconv.Quote(string(data)) + ")\n"
+
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("seed-%03d", i)), []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,232 @@
+package mqttcodec
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/MarcusOuelletus/demo/modules/helpers/bytes"
+)
+
+/*
+This is the wire format for the MQTT 3.1.1 control packets.
+
+Every packet is a typed struct implementing Packet, Encode returns the complete packet including the fixed header.
+ReadPacket reads one complete packet from a stream, Decode turns an already framed fixed header and body into the
+typed struct. Decoding validates everything the spec marks as malformed so callers can close the connection on error.
+*/
+
+type PacketType byte
+
+const (
+	CONNECT     PacketType = 1
+	CONNACK     PacketType = 2
+	PUBLISH     PacketType = 3
+	PUBACK      PacketType = 4
+	PUBREC      PacketType = 5
+	PUBREL      PacketType = 6
+	PUBCOMP     PacketType = 7
+	SUBSCRIBE   PacketType = 8
+	SUBACK      PacketType = 9
+	UNSUBSCRIBE PacketType = 10
+	UNSUBACK    PacketType = 11
+	PINGREQ     PacketType = 12
+	PINGRESP    PacketType = 13
+	DISCONNECT  PacketType = 14
+	AUTH        PacketType = 15
+)
+
+var packetTypeNames = map[PacketType]string{
+	CONNECT:     "CONNECT",
+	CONNACK:     "CONNACK",
+	PUBLISH:     "PUBLISH",
+	PUBACK:      "PUBACK",
+	PUBREC:      "PUBREC",
+	PUBREL:      "PUBREL",
+	PUBCOMP:     "PUBCOMP",
+	SUBSCRIBE:   "SUBSCRIBE",
+	SUBACK:      "SUBACK",
+	UNSUBSCRIBE: "UNSUBSCRIBE",
+	UNSUBACK:    "UNSUBACK",
+	PINGREQ:     "PINGREQ",
+	PINGRESP:    "PINGRESP",
+	DISCONNECT:  "DISCONNECT",
+	AUTH:        "AUTH",
+}
+
+func (t PacketType) String() string {
+	if name, ok := packetTypeNames[t]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("PacketType(%d)", byte(t))
+}
+
+type Packet interface {
+	Type() PacketType
+	Encode() ([]byte, error)
+}
+
+type FixedHeader struct {
+	Type            PacketType
+	Flags           byte
+	RemainingLength int
+}
+
+// ReadFixedHeader reads the packet type, flags and Remaining Length.
+func ReadFixedHeader(r io.ByteReader) (FixedHeader, error) {
+	var h FixedHeader
+
+	first, err := r.ReadByte()
+
+	if err != nil {
+		return h, err
+	}
+
+	h.Type = PacketType(first >> 4)
+	h.Flags = first & 0x0F
+
+	length, _, err := bytes.DecodeVarByteInt(r)
+
+	if err != nil {
+		return h, err
+	}
+
+	h.RemainingLength = int(length)
+
+	return h, nil
+}
+
+// ReadPacket reads and decodes one complete packet. It reads no further than the packet, r is not buffered, so a stream
+// that ends inside the packet is io.ErrUnexpectedEOF.
+func ReadPacket(r io.Reader) (Packet, error) {
+	var br = bytes.NewByteReader(r)
+	var h FixedHeader
+
+	var first = br.ReadUint8()
+	h.Type = PacketType(first >> 4)
+	h.Flags = first & 0x0F
+	h.RemainingLength = int(br.ReadVarInt())
+
+	var body = br.ReadBytes(h.RemainingLength)
+
+	if err := br.Err(); err != nil {
+		if err == io.EOF && br.Count() > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	return Decode(h, body)
+}
+
+func WritePacket(w io.Writer, p Packet) error {
+	data, err := p.Encode()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+// Decode turns a fixed header and its body into the typed packet, failures are returned as a *DecodeError.
+func Decode(h FixedHeader, body []byte) (Packet, error) {
+	return decode(h, body, false, Version311)
+}
+
+func decode(h FixedHeader, body []byte, noCopy bool, version ProtocolVersion) (Packet, error) {
+	if len(body) != h.RemainingLength {
+		return nil, newDecodeError(h, 0, fmt.Errorf("%s body is %d bytes, remaining length is %d", h.Type, len(body), h.RemainingLength))
+	}
+
+	if err := validateFlags(h); err != nil {
+		return nil, newDecodeError(h, 0, err)
+	}
+
+	var d = &decoder{data: body, base: 1 + bytes.VarByteIntSize(uint32(h.RemainingLength)), noCopy: noCopy, version: version}
+
+	packet, err := decodeBody(h, d)
+
+	if err != nil {
+		return nil, newDecodeError(h, d.base+d.offset, err)
+	}
+
+	return packet, nil
+}
+
+func decodeBody(h FixedHeader, d *decoder) (Packet, error) {
+	switch h.Type {
+	case CONNECT:
+		return decodeConnect(d)
+	case CONNACK:
+		return decodeConnack(d)
+	case PUBLISH:
+		return decodePublish(h, d)
+	case UNSUBACK:
+		if d.v5() {
+			return decodeUnsuback(d)
+		}
+		return decodeAck(h.Type, d)
+	case PUBACK, PUBREC, PUBREL, PUBCOMP:
+		return decodeAck(h.Type, d)
+	case SUBSCRIBE:
+		return decodeSubscribe(d)
+	case SUBACK:
+		return decodeSuback(d)
+	case UNSUBSCRIBE:
+		return decodeUnsubscribe(d)
+	case DISCONNECT:
+		if d.v5() {
+			return decodeDisconnection(d)
+		}
+		return decodeEmpty(h.Type, d)
+	case PINGREQ, PINGRESP:
+		return decodeEmpty(h.Type, d)
+	case AUTH:
+		if !d.v5() {
+			return nil, protocolErrorf("AUTH needs MQTT 5")
+		}
+		return decodeAuth(d)
+	}
+
+	return nil, fmt.Errorf("unknown packet type %d", byte(h.Type))
+}
+
+// validateFlags checks the fixed header flags, PUBREL, SUBSCRIBE and UNSUBSCRIBE have them fixed to 0010, PUBLISH
+// carries DUP, QoS and RETAIN and every other packet has them fixed to 0000.
+func validateFlags(h FixedHeader) error {
+	switch h.Type {
+	case PUBLISH:
+		if (h.Flags>>1)&0x03 == 3 {
+			return fmt.Errorf("PUBLISH with qos 3")
+		}
+		return nil
+	case PUBREL, SUBSCRIBE, UNSUBSCRIBE:
+		if h.Flags != 0x02 {
+			return fmt.Errorf("%s with invalid flags 0x%X", h.Type, h.Flags)
+		}
+		return nil
+	}
+
+	if h.Flags != 0 {
+		return fmt.Errorf("%s with invalid flags 0x%X", h.Type, h.Flags)
+	}
+
+	return nil
+}
+
+// encodePacket prepends the fixed header to body.
+func encodePacket(t PacketType, flags byte, body []byte) ([]byte, error) {
+	if len(body) > MaxRemainingLength {
+		return nil, fmt.Errorf("%s body of %d bytes exceeds the maximum remaining length", t, len(body))
+	}
+
+	var out = make([]byte, 0, 5+len(body))
+
+	out = append(out, byte(t)<<4|flags)
+	out = bytes.AppendVarByteInt(out, uint32(len(body)))
+	out = append(out, body...)
+
+	return out, nil
+}
@@ -0,0 +1,53 @@
+package mqttcodec
+
+import (
+	"fmt"
+	"io"
+)
+
+/*
+Maximum Packet Size is negotiated per direction: the peer's limit applies to what we encode, ours to what we read.
+Both sides report a violation as a *PacketTooLargeError, which matches ErrPacketTooLarge with errors.Is. Outbound
+packets are checked after encoding and before anything is written, inbound packets are checked from the fixed header
+alone (see PacketReader) so an oversized body is never buffered.
+*/
+
+type PacketTooLargeError struct {
+	PacketType PacketType
+	Size       int
+	Limit      int
+}
+
+func (e *PacketTooLargeError) Error() string {
+	return fmt.Sprintf("%s of %d bytes exceeds the maximum packet size of %d", e.PacketType, e.Size, e.Limit)
+}
+
+func (e *PacketTooLargeError) Is(target error) bool {
+	return target == ErrPacketTooLarge
+}
+
+// EncodeLimited encodes p and fails if the result is larger than maxPacketSize, 0 means no limit.
+func EncodeLimited(p Packet, maxPacketSize int) ([]byte, error) {
+	data, err := p.Encode()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if maxPacketSize > 0 && len(data) > maxPacketSize {
+		return nil, &PacketTooLargeError{PacketType: p.Type(), Size: len(data), Limit: maxPacketSize}
+	}
+
+	return data, nil
+}
+
+func WritePacketLimited(w io.Writer, p Packet, maxPacketSize int) error {
+	data, err := EncodeLimited(p, maxPacketSize)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,49 @@
+package mqttcodec
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestReadPacketStopsAtThePacket(t *testing.T) {
+	var stream bytes.Buffer
+
+	for _, p := range []Packet{NewPuback(7), Pingreq} {
+		if err := WritePacket(&stream, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// -- r is not buffered and has no ReadByte, ReadPacket must not read into the second packet
+	var r = struct{ io.Reader }{&stream}
+
+	p, err := ReadPacket(r)
+
+	if ack, ok := p.(*Ack); err != nil || !ok || ack.PacketID != 7 {
+		t.Fatalf("first packet %v, %v", Dump(p), err)
+	}
+
+	if p, err = ReadPacket(r); err != nil || p.Type() != PINGREQ {
+		t.Fatalf("second packet %v, %v", Dump(p), err)
+	}
+	// --
+
+	if _, err = ReadPacket(r); err != io.EOF {
+		t.Fatalf("at the end of the stream: %v", err)
+	}
+}
+
+func TestReadPacketTruncated(t *testing.T) {
+	data, err := NewPuback(7).Encode()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for n := 1; n < len(data); n++ {
+		if _, err := ReadPacket(bytes.NewReader(data[:n])); err != io.ErrUnexpectedEOF {
+			t.Errorf("%d of %d bytes: %v", n, len(data), err)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package mqttcodec
+
+import (
+	"io"
+
+	"github.com/MarcusOuelletus/demo/bytespool"
+)
+
+/*
+ReadPooledPacket is ReadPacket without the per message payload copy: the body is read into a bytespool buffer and a
+decoded PUBLISH keeps its Payload as a sub-slice of it. The buffer goes back to the pool when Release is called on the
+packet, after which the Payload must not be touched. Every other packet type is decoded into its own memory and the
+buffer is returned right away, calling Release on them is a no-op.
+*/
+
+type Releaser interface {
+	Release()
+}
+
+// Release calls Release on the packet if it holds a pooled buffer.
+func Release(p Packet) {
+	if r, ok := p.(Releaser); ok {
+		r.Release()
+	}
+}
+
+func (p *Publish) Release() {
+	if p.release != nil {
+		p.release()
+		p.release = nil
+		p.Payload = nil
+	}
+}
+
+func (p *PacketReader) ReadPooledPacket() (Packet, error) {
+	h, err := p.readHeader()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var buf = bytespool.Get(h.RemainingLength)
+	var body = *buf
+
+	if _, err := io.ReadFull(p.r, body); err != nil {
+		bytespool.Put(buf)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	if h.Type != PUBLISH {
+		packet, err := decode(h, body, false, p.Version)
+		if err == nil {
+			p.Trace.traceFrame(packet, h, body)
+		}
+		bytespool.Put(buf)
+		return packet, err
+	}
+
+	packet, err := decode(h, body, true, p.Version)
+
+	if err != nil {
+		bytespool.Put(buf)
+		return nil, err
+	}
+
+	p.Trace.traceFrame(packet, h, body)
+
+	if err = p.resolveAlias(h, packet); err != nil {
+		bytespool.Put(buf)
+		return nil, err
+	}
+
+	packet.(*Publish).release = func() { bytespool.Put(buf) }
+
+	return packet, nil
+}
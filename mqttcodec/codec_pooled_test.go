@@ -0,0 +1,124 @@
+package mqttcodec
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+)
+
+// loopReader reads data over and over, so a benchmark can decode any number of packets from one encoded stream.
+type loopReader struct {
+	data   []byte
+	offset int
+}
+
+func (r *loopReader) Read(b []byte) (int, error) {
+	var n = copy(b, r.data[r.offset:])
+	r.offset = (r.offset + n) % len(r.data)
+	return n, nil
+}
+
+var benchmarkPayloadSizes = []int{64, 1024, 16 * 1024}
+
+func benchmarkPublish(b *testing.B, size int) *Publish {
+	p, err := NewPublishBuilder("sensors/plant/line-1/temperature").Payload(bytes.Repeat([]byte{'x'}, size)).Build()
+
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	return p
+}
+
+func BenchmarkDecodePublish(b *testing.B) {
+	for _, size := range benchmarkPayloadSizes {
+		data, err := benchmarkPublish(b, size).Encode()
+
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.Run("plain/"+strconv.Itoa(size), func(b *testing.B) {
+			var r = NewPacketReader(&loopReader{data: data})
+
+			b.ReportAllocs()
+			b.SetBytes(int64(len(data)))
+
+			for i := 0; i < b.N; i++ {
+				if _, err := r.ReadPacket(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		b.Run("pooled/"+strconv.Itoa(size), func(b *testing.B) {
+			var r = NewPacketReader(&loopReader{data: data})
+
+			b.ReportAllocs()
+			b.SetBytes(int64(len(data)))
+
+			for i := 0; i < b.N; i++ {
+				p, err := r.ReadPooledPacket()
+
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				Release(p)
+			}
+		})
+	}
+}
+
+func BenchmarkEncodePublish(b *testing.B) {
+	for _, size := range benchmarkPayloadSizes {
+		var p = benchmarkPublish(b, size)
+
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(size))
+
+			for i := 0; i < b.N; i++ {
+				if _, err := p.Encode(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestPooledPublishMatchesPlain(t *testing.T) {
+	var stream []byte
+
+	for i, size := range []int{0, 3, 5000} {
+		p, _ := NewPublishBuilder("a/" + strconv.Itoa(i)).Payload(bytes.Repeat([]byte{byte(i)}, size)).Build()
+		data, _ := p.Encode()
+		stream = append(stream, data...)
+	}
+
+	var plain, pooled = NewPacketReader(bytes.NewReader(stream)), NewPacketReader(bytes.NewReader(stream))
+
+	for i := 0; i < 3; i++ {
+		want, err := plain.ReadPacket()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := pooled.ReadPooledPacket()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(got.(*Publish).Payload, want.(*Publish).Payload) || got.(*Publish).TopicName != want.(*Publish).TopicName {
+			t.Fatalf("packet %d: pooled %v, plain %v", i, got, want)
+		}
+
+		Release(got)
+
+		if got.(*Publish).Payload != nil {
+			t.Fatal("Release left the payload pointing into the pooled buffer")
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package mqttcodec
+
+import (
+	"errors"
+	"io"
+
+	"github.com/MarcusOuelletus/demo/modules/helpers/bytes"
+)
+
+/*
+The building blocks every packet is made of: the Variable Byte Integer used for Remaining Length, two and four byte
+integers, and the length prefixed UTF-8 strings and binary data, the encodings themselves live in the bytes module.
+*/
+
+const MaxRemainingLength = bytes.MaxVarByteInt
+
+var ErrMalformedVarByteInt = bytes.ErrMalformedVarByteInt
+
+func appendUint16(out []byte, v uint16) []byte {
+	return bytes.AppendUint16(out, v)
+}
+
+func appendString(out []byte, s string) ([]byte, error) {
+	return bytes.AppendString(out, s)
+}
+
+func appendBinary(out []byte, b []byte) ([]byte, error) {
+	return bytes.AppendBinary(out, b)
+}
+
+// decoder walks a packet body, every read fails once the body runs out. With noCopy set the payload returned by rest
+// aliases data instead of being copied, version selects between the 3.1.1 and the MQTT 5 layout of the packets.
+type decoder struct {
+	data    []byte
+	offset  int
+	base    int
+	noCopy  bool
+	version ProtocolVersion
+}
+
+func (d *decoder) v5() bool {
+	return d.version == Version5
+}
+
+func (d *decoder) remaining() int {
+	return len(d.data) - d.offset
+}
+
+func (d *decoder) readByte() (byte, error) {
+	if d.remaining() < 1 {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	var b = d.data[d.offset]
+	d.offset++
+
+	return b, nil
+}
+
+func (d *decoder) readUint16() (uint16, error) {
+	if d.remaining() < 2 {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	var v = bytes.CombineTwoBytes([2]byte{d.data[d.offset], d.data[d.offset+1]})
+	d.offset += 2
+
+	return v, nil
+}
+
+func (d *decoder) readBytes(n int) ([]byte, error) {
+	if d.remaining() < n {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	var b = d.data[d.offset : d.offset+n]
+	d.offset += n
+
+	return b, nil
+}
+
+func (d *decoder) readBinary() ([]byte, error) {
+	b, n, err := bytes.DecodeBinary(d.data[d.offset:])
+
+	if err != nil {
+		return nil, d.fieldError(err)
+	}
+
+	d.offset += n
+
+	return b, nil
+}
+
+func (d *decoder) readString() (string, error) {
+	s, n, err := bytes.DecodeString(d.data[d.offset:])
+
+	if err != nil {
+		return "", d.fieldError(err)
+	}
+
+	d.offset += n
+
+	return s, nil
+}
+
+// fieldError pins a string or binary field error to the byte inside the field that caused it.
+func (d *decoder) fieldError(err error) error {
+	var fieldErr *bytes.FieldError
+
+	if errors.As(err, &fieldErr) {
+		return &offsetError{offset: d.base + d.offset + fieldErr.Offset, err: err}
+	}
+
+	return err
+}
+
+func (d *decoder) rest() []byte {
+	if d.noCopy {
+		var b = d.data[d.offset:]
+		d.offset = len(d.data)
+		return b
+	}
+
+	var b = append([]byte(nil), d.data[d.offset:]...)
+	d.offset = len(d.data)
+
+	return b
+}
+
+func appendUint32(out []byte, v uint32) []byte {
+	return bytes.AppendUint32(out, v)
+}
+
+func (d *decoder) readUint32() (uint32, error) {
+	if d.remaining() < 4 {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	var v = bytes.Combine4Bytes([4]byte(d.data[d.offset : d.offset+4]))
+	d.offset += 4
+
+	return v, nil
+}
+
+func (d *decoder) ReadByte() (byte, error) {
+	return d.readByte()
+}
+
+func (d *decoder) readVarByteInt() (uint32, error) {
+	v, _, err := bytes.DecodeVarByteInt(d)
+	return v, err
+}
@@ -0,0 +1,501 @@
+package mqttcodec
+
+import (
+	"fmt"
+
+	"github.com/MarcusOuelletus/demo/modules/helpers/bytes"
+)
+
+/*
+Properties is the MQTT 5 property section. Numeric properties are pointers so an absent property can be told apart from
+a zero value, strings and binary data are absent when empty. User Property and, for PUBLISH, Subscription Identifier are
+the only properties that may appear more than once, their order is preserved.
+
+Encode and decode both check the property against the packet it belongs to, a property that isn't allowed on a packet
+or appears twice makes the packet malformed. The will properties in CONNECT are checked with the WILL pseudo type.
+*/
+
+// WILL is not a packet type, it selects the rules for the will properties of a CONNECT.
+const WILL PacketType = 0
+
+type PropertyID byte
+
+const (
+	PropPayloadFormatIndicator          PropertyID = 0x01
+	PropMessageExpiryInterval           PropertyID = 0x02
+	PropContentType                     PropertyID = 0x03
+	PropResponseTopic                   PropertyID = 0x08
+	PropCorrelationData                 PropertyID = 0x09
+	PropSubscriptionIdentifier          PropertyID = 0x0B
+	PropSessionExpiryInterval           PropertyID = 0x11
+	PropAssignedClientIdentifier        PropertyID = 0x12
+	PropServerKeepAlive                 PropertyID = 0x13
+	PropAuthenticationMethod            PropertyID = 0x15
+	PropAuthenticationData              PropertyID = 0x16
+	PropRequestProblemInformation       PropertyID = 0x17
+	PropWillDelayInterval               PropertyID = 0x18
+	PropRequestResponseInformation      PropertyID = 0x19
+	PropResponseInformation             PropertyID = 0x1A
+	PropServerReference                 PropertyID = 0x1C
+	PropReasonString                    PropertyID = 0x1F
+	PropReceiveMaximum                  PropertyID = 0x21
+	PropTopicAliasMaximum               PropertyID = 0x22
+	PropTopicAlias                      PropertyID = 0x23
+	PropMaximumQoS                      PropertyID = 0x24
+	PropRetainAvailable                 PropertyID = 0x25
+	PropUserProperty                    PropertyID = 0x26
+	PropMaximumPacketSize               PropertyID = 0x27
+	PropWildcardSubscriptionAvailable   PropertyID = 0x28
+	PropSubscriptionIdentifierAvailable PropertyID = 0x29
+	PropSharedSubscriptionAvailable     PropertyID = 0x2A
+)
+
+type propertyInfo struct {
+	name    string
+	packets []PacketType
+}
+
+var propertyTable = map[PropertyID]propertyInfo{
+	PropPayloadFormatIndicator:          {"Payload Format Indicator", []PacketType{PUBLISH, WILL}},
+	PropMessageExpiryInterval:           {"Message Expiry Interval", []PacketType{PUBLISH, WILL}},
+	PropContentType:                     {"Content Type", []PacketType{PUBLISH, WILL}},
+	PropResponseTopic:                   {"Response Topic", []PacketType{PUBLISH, WILL}},
+	PropCorrelationData:                 {"Correlation Data", []PacketType{PUBLISH, WILL}},
+	PropSubscriptionIdentifier:          {"Subscription Identifier", []PacketType{PUBLISH, SUBSCRIBE}},
+	PropSessionExpiryInterval:           {"Session Expiry Interval", []PacketType{CONNECT, CONNACK, DISCONNECT}},
+	PropAssignedClientIdentifier:        {"Assigned Client Identifier", []PacketType{CONNACK}},
+	PropServerKeepAlive:                 {"Server Keep Alive", []PacketType{CONNACK}},
+	PropAuthenticationMethod:            {"Authentication Method", []PacketType{CONNECT, CONNACK, AUTH}},
+	PropAuthenticationData:              {"Authentication Data", []PacketType{CONNECT, CONNACK, AUTH}},
+	PropRequestProblemInformation:       {"Request Problem Information", []PacketType{CONNECT}},
+	PropWillDelayInterval:               {"Will Delay Interval", []PacketType{WILL}},
+	PropRequestResponseInformation:      {"Request Response Information", []PacketType{CONNECT}},
+	PropResponseInformation:             {"Response Information", []PacketType{CONNACK}},
+	PropServerReference:                 {"Server Reference", []PacketType{CONNACK, DISCONNECT}},
+	PropReasonString:                    {"Reason String", []PacketType{CONNACK, PUBACK, PUBREC, PUBREL, PUBCOMP, SUBACK, UNSUBACK, DISCONNECT, AUTH}},
+	PropReceiveMaximum:                  {"Receive Maximum", []PacketType{CONNECT, CONNACK}},
+	PropTopicAliasMaximum:               {"Topic Alias Maximum", []PacketType{CONNECT, CONNACK}},
+	PropTopicAlias:                      {"Topic Alias", []PacketType{PUBLISH}},
+	PropMaximumQoS:                      {"Maximum QoS", []PacketType{CONNACK}},
+	PropRetainAvailable:                 {"Retain Available", []PacketType{CONNACK}},
+	PropUserProperty:                    {"User Property", []PacketType{CONNECT, CONNACK, PUBLISH, WILL, PUBACK, PUBREC, PUBREL, PUBCOMP, SUBSCRIBE, SUBACK, UNSUBSCRIBE, UNSUBACK, DISCONNECT, AUTH}},
+	PropMaximumPacketSize:               {"Maximum Packet Size", []PacketType{CONNECT, CONNACK}},
+	PropWildcardSubscriptionAvailable:   {"Wildcard Subscription Available", []PacketType{CONNACK}},
+	PropSubscriptionIdentifierAvailable: {"Subscription Identifier Available", []PacketType{CONNACK}},
+	PropSharedSubscriptionAvailable:     {"Shared Subscription Available", []PacketType{CONNACK}},
+}
+
+func (id PropertyID) String() string {
+	if info, ok := propertyTable[id]; ok {
+		return info.name
+	}
+
+	return fmt.Sprintf("PropertyID(0x%02X)", byte(id))
+}
+
+// ValidFor reports whether the property may appear on packet type t.
+func (id PropertyID) ValidFor(t PacketType) bool {
+	for _, packet := range propertyTable[id].packets {
+		if packet == t {
+			return true
+		}
+	}
+
+	return false
+}
+
+type UserProperty struct {
+	Key   string
+	Value string
+}
+
+type Properties struct {
+	PayloadFormatIndicator          *byte
+	MessageExpiryInterval           *uint32
+	ContentType                     string
+	ResponseTopic                   string
+	CorrelationData                 []byte
+	SubscriptionIdentifiers         []uint32
+	SessionExpiryInterval           *uint32
+	AssignedClientIdentifier        string
+	ServerKeepAlive                 *uint16
+	AuthenticationMethod            string
+	AuthenticationData              []byte
+	RequestProblemInformation       *byte
+	WillDelayInterval               *uint32
+	RequestResponseInformation      *byte
+	ResponseInformation             string
+	ServerReference                 string
+	ReasonString                    string
+	ReceiveMaximum                  *uint16
+	TopicAliasMaximum               *uint16
+	TopicAlias                      *uint16
+	MaximumQoS                      *byte
+	RetainAvailable                 *byte
+	UserProperties                  []UserProperty
+	MaximumPacketSize               *uint32
+	WildcardSubscriptionAvailable   *byte
+	SubscriptionIdentifierAvailable *byte
+	SharedSubscriptionAvailable     *byte
+}
+
+func Uint16(v uint16) *uint16 { return &v }
+
+func Uint32(v uint32) *uint32 { return &v }
+
+func Byte(v byte) *byte { return &v }
+
+// Validate checks every property present against the rules for packet type t.
+func (p *Properties) Validate(t PacketType) error {
+	if p == nil {
+		return nil
+	}
+
+	_, err := p.appendProperties(nil, t)
+	return err
+}
+
+// Encode returns the property section for packet type t, including its Variable Byte Integer length. A nil
+// Properties encodes as an empty section.
+func (p *Properties) Encode(t PacketType) ([]byte, error) {
+	return p.appendTo(nil, t)
+}
+
+func (p *Properties) appendTo(out []byte, t PacketType) ([]byte, error) {
+	if p == nil {
+		return append(out, 0), nil
+	}
+
+	props, err := p.appendProperties(nil, t)
+
+	if err != nil {
+		return nil, err
+	}
+
+	out = bytes.AppendVarByteInt(out, uint32(len(props)))
+	return append(out, props...), nil
+}
+
+func (p *Properties) appendProperties(out []byte, t PacketType) ([]byte, error) {
+	var e = &propertyEncoder{out: out, packet: t}
+
+	e.byteProp(PropPayloadFormatIndicator, p.PayloadFormatIndicator, 1)
+	e.uint32Prop(PropMessageExpiryInterval, p.MessageExpiryInterval)
+	e.stringProp(PropContentType, p.ContentType)
+	e.stringProp(PropResponseTopic, p.ResponseTopic)
+	e.binaryProp(PropCorrelationData, p.CorrelationData)
+
+	if len(p.SubscriptionIdentifiers) > 1 && t != PUBLISH {
+		e.fail(fmt.Errorf("%s with %d subscription identifiers", t, len(p.SubscriptionIdentifiers)))
+	}
+
+	for _, id := range p.SubscriptionIdentifiers {
+		if id == 0 || id > MaxRemainingLength {
+			e.fail(fmt.Errorf("subscription identifier %d is out of range", id))
+		}
+		if e.allowed(PropSubscriptionIdentifier) {
+			e.out = bytes.AppendVarByteInt(e.out, id)
+		}
+	}
+
+	e.uint32Prop(PropSessionExpiryInterval, p.SessionExpiryInterval)
+	e.stringProp(PropAssignedClientIdentifier, p.AssignedClientIdentifier)
+	e.uint16Prop(PropServerKeepAlive, p.ServerKeepAlive, false)
+	e.stringProp(PropAuthenticationMethod, p.AuthenticationMethod)
+	e.binaryProp(PropAuthenticationData, p.AuthenticationData)
+	e.byteProp(PropRequestProblemInformation, p.RequestProblemInformation, 1)
+	e.uint32Prop(PropWillDelayInterval, p.WillDelayInterval)
+	e.byteProp(PropRequestResponseInformation, p.RequestResponseInformation, 1)
+	e.stringProp(PropResponseInformation, p.ResponseInformation)
+	e.stringProp(PropServerReference, p.ServerReference)
+	e.stringProp(PropReasonString, p.ReasonString)
+	e.uint16Prop(PropReceiveMaximum, p.ReceiveMaximum, true)
+	e.uint16Prop(PropTopicAliasMaximum, p.TopicAliasMaximum, false)
+	e.uint16Prop(PropTopicAlias, p.TopicAlias, true)
+	e.byteProp(PropMaximumQoS, p.MaximumQoS, 1)
+	e.byteProp(PropRetainAvailable, p.RetainAvailable, 1)
+
+	for _, up := range p.UserProperties {
+		if e.allowed(PropUserProperty) {
+			e.string(up.Key)
+			e.string(up.Value)
+		}
+	}
+
+	if p.MaximumPacketSize != nil && *p.MaximumPacketSize == 0 {
+		e.fail(fmt.Errorf("maximum packet size of 0"))
+	}
+
+	e.uint32Prop(PropMaximumPacketSize, p.MaximumPacketSize)
+	e.byteProp(PropWildcardSubscriptionAvailable, p.WildcardSubscriptionAvailable, 1)
+	e.byteProp(PropSubscriptionIdentifierAvailable, p.SubscriptionIdentifierAvailable, 1)
+	e.byteProp(PropSharedSubscriptionAvailable, p.SharedSubscriptionAvailable, 1)
+
+	return e.out, e.err
+}
+
+// propertyEncoder latches the first error so appendProperties can stay a flat list of properties.
+type propertyEncoder struct {
+	out    []byte
+	packet PacketType
+	err    error
+}
+
+func (e *propertyEncoder) fail(err error) {
+	if e.err == nil {
+		e.err = err
+	}
+}
+
+func (e *propertyEncoder) allowed(id PropertyID) bool {
+	if !id.ValidFor(e.packet) {
+		e.fail(fmt.Errorf("%s is not allowed on %s", id, e.packetName()))
+		return false
+	}
+
+	e.out = append(e.out, byte(id))
+	return true
+}
+
+func (e *propertyEncoder) packetName() string {
+	if e.packet == WILL {
+		return "will properties"
+	}
+
+	return e.packet.String()
+}
+
+func (e *propertyEncoder) byteProp(id PropertyID, v *byte, max byte) {
+	if v == nil {
+		return
+	}
+
+	if *v > max {
+		e.fail(fmt.Errorf("%s of %d is out of range", id, *v))
+	}
+
+	if e.allowed(id) {
+		e.out = append(e.out, *v)
+	}
+}
+
+func (e *propertyEncoder) uint16Prop(id PropertyID, v *uint16, nonZero bool) {
+	if v == nil {
+		return
+	}
+
+	if nonZero && *v == 0 {
+		e.fail(fmt.Errorf("%s of 0", id))
+	}
+
+	if e.allowed(id) {
+		e.out = appendUint16(e.out, *v)
+	}
+}
+
+func (e *propertyEncoder) uint32Prop(id PropertyID, v *uint32) {
+	if v != nil && e.allowed(id) {
+		e.out = appendUint32(e.out, *v)
+	}
+}
+
+func (e *propertyEncoder) stringProp(id PropertyID, v string) {
+	if v != "" && e.allowed(id) {
+		e.string(v)
+	}
+}
+
+func (e *propertyEncoder) binaryProp(id PropertyID, v []byte) {
+	if len(v) == 0 || !e.allowed(id) {
+		return
+	}
+
+	var err error
+
+	if e.out, err = appendBinary(e.out, v); err != nil {
+		e.fail(err)
+	}
+}
+
+func (e *propertyEncoder) string(v string) {
+	var err error
+
+	if e.out, err = appendString(e.out, v); err != nil {
+		e.fail(err)
+	}
+}
+
+// decodeProperties reads a property section, including its length, for packet type t.
+func decodeProperties(d *decoder, t PacketType) (*Properties, error) {
+	length, err := d.readVarByteInt()
+
+	if err != nil {
+		return nil, err
+	}
+
+	section, err := d.readBytes(int(length))
+
+	if err != nil {
+		return nil, err
+	}
+
+	var p = &Properties{}
+	var pd = &decoder{data: section, base: d.base + d.offset - len(section)}
+	var seen = make(map[PropertyID]bool)
+
+	if err := p.decodeSection(pd, t, seen); err != nil {
+		return nil, &offsetError{offset: pd.base + pd.offset, err: err}
+	}
+
+	return p, nil
+}
+
+func (p *Properties) decodeSection(pd *decoder, t PacketType, seen map[PropertyID]bool) error {
+
+	for pd.remaining() > 0 {
+		idValue, err := pd.readVarByteInt()
+
+		if err != nil {
+			return err
+		}
+
+		var id = PropertyID(idValue)
+
+		if _, known := propertyTable[id]; !known || idValue > 0xFF {
+			return fmt.Errorf("unknown property 0x%02X on %s", idValue, t)
+		}
+
+		if !id.ValidFor(t) {
+			return fmt.Errorf("%s is not allowed on %s", id, t)
+		}
+
+		var repeatable = id == PropUserProperty || (id == PropSubscriptionIdentifier && t == PUBLISH)
+
+		if seen[id] && !repeatable {
+			return protocolErrorf("%s appears more than once on %s", id, t)
+		}
+
+		seen[id] = true
+
+		if err = p.decodeProperty(pd, id); err != nil {
+			return fmt.Errorf("%s on %s: %w", id, t, err)
+		}
+	}
+
+	return nil
+}
+
+func (p *Properties) decodeProperty(d *decoder, id PropertyID) error {
+	var err error
+
+	switch id {
+	case PropPayloadFormatIndicator:
+		p.PayloadFormatIndicator, err = decodeFlagProperty(d)
+	case PropMessageExpiryInterval:
+		p.MessageExpiryInterval, err = decodeUint32Property(d)
+	case PropContentType:
+		p.ContentType, err = d.readString()
+	case PropResponseTopic:
+		p.ResponseTopic, err = d.readString()
+	case PropCorrelationData:
+		p.CorrelationData, err = d.readBinary()
+	case PropSubscriptionIdentifier:
+		var v uint32
+
+		if v, err = d.readVarByteInt(); err == nil && v == 0 {
+			err = protocolErrorf("value of 0")
+		}
+
+		p.SubscriptionIdentifiers = append(p.SubscriptionIdentifiers, v)
+	case PropSessionExpiryInterval:
+		p.SessionExpiryInterval, err = decodeUint32Property(d)
+	case PropAssignedClientIdentifier:
+		p.AssignedClientIdentifier, err = d.readString()
+	case PropServerKeepAlive:
+		p.ServerKeepAlive, err = decodeUint16Property(d, false)
+	case PropAuthenticationMethod:
+		p.AuthenticationMethod, err = d.readString()
+	case PropAuthenticationData:
+		p.AuthenticationData, err = d.readBinary()
+	case PropRequestProblemInformation:
+		p.RequestProblemInformation, err = decodeFlagProperty(d)
+	case PropWillDelayInterval:
+		p.WillDelayInterval, err = decodeUint32Property(d)
+	case PropRequestResponseInformation:
+		p.RequestResponseInformation, err = decodeFlagProperty(d)
+	case PropResponseInformation:
+		p.ResponseInformation, err = d.readString()
+	case PropServerReference:
+		p.ServerReference, err = d.readString()
+	case PropReasonString:
+		p.ReasonString, err = d.readString()
+	case PropReceiveMaximum:
+		p.ReceiveMaximum, err = decodeUint16Property(d, true)
+	case PropTopicAliasMaximum:
+		p.TopicAliasMaximum, err = decodeUint16Property(d, false)
+	case PropTopicAlias:
+		p.TopicAlias, err = decodeUint16Property(d, true)
+	case PropMaximumQoS:
+		p.MaximumQoS, err = decodeFlagProperty(d)
+	case PropRetainAvailable:
+		p.RetainAvailable, err = decodeFlagProperty(d)
+	case PropUserProperty:
+		var up UserProperty
+
+		if up.Key, err = d.readString(); err == nil {
+			up.Value, err = d.readString()
+		}
+
+		p.UserProperties = append(p.UserProperties, up)
+	case PropMaximumPacketSize:
+		if p.MaximumPacketSize, err = decodeUint32Property(d); err == nil && *p.MaximumPacketSize == 0 {
+			err = protocolErrorf("value of 0")
+		}
+	case PropWildcardSubscriptionAvailable:
+		p.WildcardSubscriptionAvailable, err = decodeFlagProperty(d)
+	case PropSubscriptionIdentifierAvailable:
+		p.SubscriptionIdentifierAvailable, err = decodeFlagProperty(d)
+	case PropSharedSubscriptionAvailable:
+		p.SharedSubscriptionAvailable, err = decodeFlagProperty(d)
+	}
+
+	return err
+}
+
+func decodeFlagProperty(d *decoder) (*byte, error) {
+	v, err := d.readByte()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if v > 1 {
+		return nil, protocolErrorf("value of %d", v)
+	}
+
+	return &v, nil
+}
+
+func decodeUint16Property(d *decoder, nonZero bool) (*uint16, error) {
+	v, err := d.readUint16()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if nonZero && v == 0 {
+		return nil, protocolErrorf("value of 0")
+	}
+
+	return &v, nil
+}
+
+func decodeUint32Property(d *decoder) (*uint32, error) {
+	v, err := d.readUint32()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &v, nil
+}
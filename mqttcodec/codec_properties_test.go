@@ -0,0 +1,164 @@
+package mqttcodec
+
+import (
+	"reflect"
+	"testing"
+)
+
+// section prefixes props with their length, as a property section on the wire.
+func section(props ...byte) []byte {
+	return append([]byte{byte(len(props))}, props...)
+}
+
+func TestPropertiesRoundTrip(t *testing.T) {
+	var tests = []struct {
+		packet PacketType
+		props  *Properties
+	}{
+		{PUBLISH, &Properties{
+			PayloadFormatIndicator:  Byte(1),
+			MessageExpiryInterval:   Uint32(60),
+			ContentType:             "application/json",
+			ResponseTopic:           "reply/to",
+			CorrelationData:         []byte{1, 2},
+			SubscriptionIdentifiers: []uint32{3, 268435455},
+			TopicAlias:              Uint16(4),
+			UserProperties:          []UserProperty{{"b", "2"}, {"a", "1"}, {"b", "3"}},
+		}},
+		{CONNACK, &Properties{
+			SessionExpiryInterval:           Uint32(0),
+			AssignedClientIdentifier:        "auto-1",
+			ServerKeepAlive:                 Uint16(30),
+			ReceiveMaximum:                  Uint16(10),
+			TopicAliasMaximum:               Uint16(0),
+			MaximumQoS:                      Byte(1),
+			RetainAvailable:                 Byte(0),
+			MaximumPacketSize:               Uint32(1024),
+			WildcardSubscriptionAvailable:   Byte(1),
+			SubscriptionIdentifierAvailable: Byte(1),
+			SharedSubscriptionAvailable:     Byte(0),
+			ResponseInformation:             "info",
+			ServerReference:                 "other:1883",
+			ReasonString:                    "because",
+			AuthenticationMethod:            "SCRAM",
+			AuthenticationData:              []byte("data"),
+		}},
+		{WILL, &Properties{WillDelayInterval: Uint32(5), ContentType: "text/plain"}},
+	}
+
+	for _, test := range tests {
+		data, err := test.props.Encode(test.packet)
+
+		if err != nil {
+			t.Errorf("%s: encoding: %v", test.packet, err)
+			continue
+		}
+
+		got, err := decodeProperties(&decoder{data: data}, test.packet)
+
+		if err != nil {
+			t.Errorf("%s: decoding: %v", test.packet, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, test.props) {
+			t.Errorf("%s: decoded %+v, want %+v", test.packet, got, test.props)
+		}
+	}
+}
+
+func TestPropertiesEmpty(t *testing.T) {
+	var p *Properties
+
+	data, err := p.Encode(PUBLISH)
+
+	if err != nil || !reflect.DeepEqual(data, []byte{0}) {
+		t.Fatalf("nil encodes as %v, %v", data, err)
+	}
+
+	got, err := decodeProperties(&decoder{data: data}, PUBLISH)
+
+	if err != nil || !reflect.DeepEqual(got, &Properties{}) {
+		t.Fatalf("an empty section decodes as %+v, %v", got, err)
+	}
+}
+
+func TestPropertiesEncodeRefuses(t *testing.T) {
+	var tests = []struct {
+		name   string
+		packet PacketType
+		props  *Properties
+	}{
+		{"not allowed on the packet", PUBLISH, &Properties{AssignedClientIdentifier: "x"}},
+		{"will delay outside the will", CONNECT, &Properties{WillDelayInterval: Uint32(1)}},
+		{"receive maximum of 0", CONNECT, &Properties{ReceiveMaximum: Uint16(0)}},
+		{"topic alias of 0", PUBLISH, &Properties{TopicAlias: Uint16(0)}},
+		{"maximum packet size of 0", CONNACK, &Properties{MaximumPacketSize: Uint32(0)}},
+		{"payload format indicator of 2", PUBLISH, &Properties{PayloadFormatIndicator: Byte(2)}},
+		{"subscription identifier of 0", SUBSCRIBE, &Properties{SubscriptionIdentifiers: []uint32{0}}},
+		{"two subscription identifiers on SUBSCRIBE", SUBSCRIBE, &Properties{SubscriptionIdentifiers: []uint32{1, 2}}},
+	}
+
+	for _, test := range tests {
+		if _, err := test.props.Encode(test.packet); err == nil {
+			t.Errorf("%s: encoded", test.name)
+		}
+
+		if err := test.props.Validate(test.packet); err == nil {
+			t.Errorf("%s: valid", test.name)
+		}
+	}
+}
+
+func TestPropertiesDecodeRefuses(t *testing.T) {
+	var tests = []struct {
+		name   string
+		packet PacketType
+		data   []byte
+	}{
+		{"unknown property", PUBLISH, section(0x7F, 0)},
+		{"not allowed on the packet", PUBLISH, section(byte(PropServerKeepAlive), 0, 1)},
+		{"twice", PUBLISH, section(byte(PropPayloadFormatIndicator), 0, byte(PropPayloadFormatIndicator), 1)},
+		{"two subscription identifiers on SUBSCRIBE", SUBSCRIBE, section(byte(PropSubscriptionIdentifier), 1, byte(PropSubscriptionIdentifier), 2)},
+		{"subscription identifier of 0", PUBLISH, section(byte(PropSubscriptionIdentifier), 0)},
+		{"receive maximum of 0", CONNACK, section(byte(PropReceiveMaximum), 0, 0)},
+		{"flag of 2", PUBLISH, section(byte(PropPayloadFormatIndicator), 2)},
+		{"truncated value", PUBLISH, section(byte(PropMessageExpiryInterval), 0, 0)},
+		{"section longer than the packet", PUBLISH, []byte{5, byte(PropPayloadFormatIndicator), 1}},
+	}
+
+	for _, test := range tests {
+		if p, err := decodeProperties(&decoder{data: test.data}, test.packet); err == nil {
+			t.Errorf("%s: decoded %+v", test.name, p)
+		}
+	}
+}
+
+func TestPropertiesRepeatable(t *testing.T) {
+	var data = section(
+		byte(PropUserProperty), 0, 1, 'k', 0, 1, '1',
+		byte(PropSubscriptionIdentifier), 7,
+		byte(PropUserProperty), 0, 1, 'k', 0, 1, '2',
+		byte(PropSubscriptionIdentifier), 9,
+	)
+
+	p, err := decodeProperties(&decoder{data: data}, PUBLISH)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(p.UserProperties, []UserProperty{{"k", "1"}, {"k", "2"}}) || !reflect.DeepEqual(p.SubscriptionIdentifiers, []uint32{7, 9}) {
+		t.Fatalf("decoded %+v", p)
+	}
+}
+
+func TestPropertyValidFor(t *testing.T) {
+	if !PropTopicAlias.ValidFor(PUBLISH) || PropTopicAlias.ValidFor(CONNECT) || PropertyID(0x7F).ValidFor(PUBLISH) {
+		t.Fatal("ValidFor does not follow the property table")
+	}
+
+	if PropertyID(0x7F).String() != "PropertyID(0x7F)" || PropReasonString.String() != "Reason String" {
+		t.Fatal("property names")
+	}
+}
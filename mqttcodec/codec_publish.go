@@ -0,0 +1,218 @@
+package mqttcodec
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/MarcusOuelletus/demo/bytespool"
+	"github.com/MarcusOuelletus/demo/reasoncodes"
+)
+
+type Publish struct {
+	Dup       bool
+	QoS       byte
+	Retain    bool
+	TopicName string
+	PacketID  uint16
+	Payload   []byte
+	// Properties is the MQTT 5 property section, a nil Properties encodes the 3.1.1 form.
+	Properties *Properties
+	release    func()
+}
+
+// Ack is PUBACK, PUBREC, PUBREL, PUBCOMP and UNSUBACK, which all carry nothing but a packet id in 3.1.1. MQTT 5 adds
+// a reason code and properties to all but UNSUBACK (see Unsuback), both may be left out on Success.
+type Ack struct {
+	PacketType PacketType
+	PacketID   uint16
+	ReasonCode byte
+	Properties *Properties
+}
+
+func (p *Publish) Type() PacketType { return PUBLISH }
+
+func (a *Ack) Type() PacketType { return a.PacketType }
+
+func NewPuback(id uint16) *Ack { return &Ack{PacketType: PUBACK, PacketID: id} }
+
+func NewPubrec(id uint16) *Ack { return &Ack{PacketType: PUBREC, PacketID: id} }
+
+func NewPubrel(id uint16) *Ack { return &Ack{PacketType: PUBREL, PacketID: id} }
+
+func NewPubcomp(id uint16) *Ack { return &Ack{PacketType: PUBCOMP, PacketID: id} }
+
+func NewUnsuback(id uint16) *Ack { return &Ack{PacketType: UNSUBACK, PacketID: id} }
+
+func (p *Publish) flags() byte {
+	var flags = p.QoS << 1
+
+	if p.Dup {
+		flags |= 0x08
+	}
+
+	if p.Retain {
+		flags |= 0x01
+	}
+
+	return flags
+}
+
+func (p *Publish) validate() error {
+	if p.QoS > 2 {
+		return fmt.Errorf("PUBLISH with qos %d", p.QoS)
+	}
+
+	if p.QoS == 0 && p.Dup {
+		return fmt.Errorf("PUBLISH with qos 0 and dup set")
+	}
+
+	if p.QoS > 0 && p.PacketID == 0 {
+		return protocolErrorf("PUBLISH with qos %d and no packet id", p.QoS)
+	}
+
+	if p.TopicName == "" && !p.hasTopicAlias() {
+		return reasonErrorf(reasoncodes.TopicNameInvalid, "PUBLISH with an empty topic name")
+	}
+
+	if strings.ContainsAny(p.TopicName, "+#") {
+		return reasonErrorf(reasoncodes.TopicNameInvalid, "PUBLISH topic name %q contains wildcards", p.TopicName)
+	}
+
+	return nil
+}
+
+func (p *Publish) hasTopicAlias() bool {
+	return p.Properties != nil && p.Properties.TopicAlias != nil && *p.Properties.TopicAlias != 0
+}
+
+func (p *Publish) Encode() ([]byte, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+
+	// -- encodePacket copies the body, its buffer goes back as soon as it did
+	var buf = bytespool.Get(2 + len(p.TopicName) + 2 + len(p.Payload))
+	defer bytespool.Put(buf)
+
+	var body = (*buf)[:0]
+	var err error
+	// --
+
+	if body, err = appendString(body, p.TopicName); err != nil {
+		return nil, err
+	}
+
+	if p.QoS > 0 {
+		body = appendUint16(body, p.PacketID)
+	}
+
+	if p.Properties != nil {
+		if body, err = p.Properties.appendTo(body, PUBLISH); err != nil {
+			return nil, err
+		}
+	}
+
+	body = append(body, p.Payload...)
+
+	return encodePacket(PUBLISH, p.flags(), body)
+}
+
+func decodePublish(h FixedHeader, d *decoder) (Packet, error) {
+	var p = &Publish{
+		Dup:    h.Flags&0x08 != 0,
+		QoS:    (h.Flags >> 1) & 0x03,
+		Retain: h.Flags&0x01 != 0,
+	}
+	var err error
+
+	if p.TopicName, err = d.readString(); err != nil {
+		return nil, err
+	}
+
+	if p.QoS > 0 {
+		if p.PacketID, err = d.readUint16(); err != nil {
+			return nil, err
+		}
+	}
+
+	if d.v5() {
+		if p.Properties, err = decodeProperties(d, PUBLISH); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = p.validate(); err != nil {
+		return nil, err
+	}
+
+	p.Payload = d.rest()
+
+	return p, nil
+}
+
+func (a *Ack) Encode() ([]byte, error) {
+	var flags byte
+
+	switch a.PacketType {
+	case PUBACK, PUBREC, PUBCOMP, UNSUBACK:
+	case PUBREL:
+		flags = 0x02
+	default:
+		return nil, fmt.Errorf("%s is not an acknowledgement", a.PacketType)
+	}
+
+	if a.PacketID == 0 {
+		return nil, fmt.Errorf("%s with no packet id", a.PacketType)
+	}
+
+	var body = appendUint16(nil, a.PacketID)
+
+	if a.ReasonCode != 0 || a.Properties != nil {
+		if a.PacketType == UNSUBACK {
+			return nil, fmt.Errorf("UNSUBACK reason codes go in an Unsuback")
+		}
+
+		body = append(body, a.ReasonCode)
+	}
+
+	if a.Properties != nil {
+		var err error
+
+		if body, err = a.Properties.appendTo(body, a.PacketType); err != nil {
+			return nil, err
+		}
+	}
+
+	return encodePacket(a.PacketType, flags, body)
+}
+
+func decodeAck(t PacketType, d *decoder) (Packet, error) {
+	if d.remaining() != 2 && !(d.v5() && d.remaining() > 2) {
+		return nil, fmt.Errorf("%s body is %d bytes, expected 2", t, d.remaining())
+	}
+
+	var a = &Ack{PacketType: t}
+	var err error
+
+	a.PacketID, _ = d.readUint16()
+
+	if a.PacketID == 0 {
+		return nil, protocolErrorf("%s with no packet id", t)
+	}
+
+	if d.remaining() > 0 {
+		a.ReasonCode, _ = d.readByte()
+	}
+
+	if d.remaining() > 0 {
+		if a.Properties, err = decodeProperties(d, t); err != nil {
+			return nil, err
+		}
+
+		if d.remaining() != 0 {
+			return nil, fmt.Errorf("%s has %d trailing bytes", t, d.remaining())
+		}
+	}
+
+	return a, nil
+}
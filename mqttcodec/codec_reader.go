@@ -0,0 +1,124 @@
+package mqttcodec
+
+import (
+	"bufio"
+	"errors"
+	"io"
+
+	"github.com/MarcusOuelletus/demo/bytespool"
+	"github.com/MarcusOuelletus/demo/modules/helpers/bytes"
+)
+
+/*
+PacketReader frames and decodes packets from a stream such as a net.Conn, one complete packet per call. Short reads are
+retried until the whole packet arrived, a packet whose total size (fixed header included) exceeds MaxPacketSize is
+rejected with a *PacketTooLargeError from its header before any of its body is read. Version selects the packet layout,
+the zero value reads 3.1.1. With Aliases set the topic alias of every PUBLISH is resolved, a bad alias fails the read
+with a *DecodeError carrying Topic Alias invalid.
+*/
+
+var ErrPacketTooLarge = errors.New("packet exceeds the maximum packet size")
+
+type PacketReader struct {
+	MaxPacketSize int
+	Version       ProtocolVersion
+	Trace         *Tracer
+	Aliases       *TopicAliasMap
+	r             *bufio.Reader
+}
+
+func NewPacketReader(r io.Reader) *PacketReader {
+	var br, ok = r.(*bufio.Reader)
+
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+
+	return &PacketReader{r: br}
+}
+
+// ReadFrame returns the fixed header and the raw body of the next packet.
+func (p *PacketReader) ReadFrame() (FixedHeader, []byte, error) {
+	h, err := p.readHeader()
+
+	if err != nil {
+		return h, nil, err
+	}
+
+	var body = make([]byte, h.RemainingLength)
+
+	if _, err := io.ReadFull(p.r, body); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return h, nil, err
+	}
+
+	return h, body, nil
+}
+
+// ReadPacket decodes the next packet, its body is read into a bytespool buffer that goes back once it is decoded.
+func (p *PacketReader) ReadPacket() (Packet, error) {
+	h, err := p.readHeader()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var buf = bytespool.Get(h.RemainingLength)
+	defer bytespool.Put(buf)
+
+	if _, err := io.ReadFull(p.r, *buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	packet, err := p.Version.Decode(h, *buf)
+
+	if err != nil {
+		return nil, err
+	}
+
+	p.Trace.traceFrame(packet, h, *buf)
+
+	if err = p.resolveAlias(h, packet); err != nil {
+		return nil, err
+	}
+
+	return packet, nil
+}
+
+func (p *PacketReader) resolveAlias(h FixedHeader, packet Packet) error {
+	publish, ok := packet.(*Publish)
+
+	if !ok || p.Aliases == nil {
+		return nil
+	}
+
+	if err := p.Aliases.Inbound(publish); err != nil {
+		return newDecodeError(h, 0, err)
+	}
+
+	return nil
+}
+
+func (p *PacketReader) readHeader() (FixedHeader, error) {
+	h, err := ReadFixedHeader(p.r)
+
+	if err != nil {
+		if err == io.EOF && h.Type != 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return h, err
+	}
+
+	if p.MaxPacketSize > 0 {
+		if size := 1 + bytes.VarByteIntSize(uint32(h.RemainingLength)) + h.RemainingLength; size > p.MaxPacketSize {
+			return h, &PacketTooLargeError{PacketType: h.Type, Size: size, Limit: p.MaxPacketSize}
+		}
+	}
+
+	return h, nil
+}
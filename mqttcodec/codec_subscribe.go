@@ -0,0 +1,390 @@
+package mqttcodec
+
+import "fmt"
+
+const SubackFailure byte = 0x80
+
+// SubscribeFilter is one topic filter with its subscription options, everything but QoS needs MQTT 5.
+type SubscribeFilter struct {
+	Filter            string
+	QoS               byte
+	NoLocal           bool
+	RetainAsPublished bool
+	RetainHandling    byte
+}
+
+const (
+	subscribeOptionNoLocal           byte = 0x04
+	subscribeOptionRetainAsPublished byte = 0x08
+	subscribeOptionRetainHandling         = 4
+)
+
+// Subscribe, Suback and Unsubscribe use the MQTT 5 layout when Properties is set, on Suback the return codes are then
+// reason codes.
+type Subscribe struct {
+	PacketID      uint16
+	Subscriptions []SubscribeFilter
+	Properties    *Properties
+}
+
+type Suback struct {
+	PacketID    uint16
+	ReturnCodes []byte
+	Properties  *Properties
+}
+
+type Unsubscribe struct {
+	PacketID   uint16
+	Filters    []string
+	Properties *Properties
+}
+
+// Unsuback is the MQTT 5 UNSUBACK with a reason code per topic filter, the 3.1.1 one is an Ack.
+type Unsuback struct {
+	PacketID    uint16
+	ReasonCodes []byte
+	Properties  *Properties
+}
+
+func (s *Subscribe) Type() PacketType { return SUBSCRIBE }
+
+func (s *Suback) Type() PacketType { return SUBACK }
+
+func (u *Unsubscribe) Type() PacketType { return UNSUBSCRIBE }
+
+func (u *Unsuback) Type() PacketType { return UNSUBACK }
+
+func (f SubscribeFilter) options() byte {
+	var options = f.QoS | f.RetainHandling<<subscribeOptionRetainHandling
+
+	if f.NoLocal {
+		options |= subscribeOptionNoLocal
+	}
+
+	if f.RetainAsPublished {
+		options |= subscribeOptionRetainAsPublished
+	}
+
+	return options
+}
+
+func (s *Subscribe) Encode() ([]byte, error) {
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+
+	var body = appendUint16(nil, s.PacketID)
+	var err error
+
+	if s.Properties != nil {
+		if body, err = s.Properties.appendTo(body, SUBSCRIBE); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, sub := range s.Subscriptions {
+		if body, err = appendString(body, sub.Filter); err != nil {
+			return nil, err
+		}
+
+		body = append(body, sub.options())
+	}
+
+	return encodePacket(SUBSCRIBE, 0x02, body)
+}
+
+func (s *Subscribe) validate() error {
+	if s.PacketID == 0 {
+		return protocolErrorf("SUBSCRIBE with no packet id")
+	}
+
+	if len(s.Subscriptions) == 0 {
+		return protocolErrorf("SUBSCRIBE with no topic filters")
+	}
+
+	for _, sub := range s.Subscriptions {
+		if sub.QoS > 2 {
+			return fmt.Errorf("SUBSCRIBE to %q with qos %d", sub.Filter, sub.QoS)
+		}
+
+		if sub.RetainHandling > 2 {
+			return protocolErrorf("SUBSCRIBE to %q with retain handling %d", sub.Filter, sub.RetainHandling)
+		}
+
+		if s.Properties == nil && (sub.NoLocal || sub.RetainAsPublished || sub.RetainHandling != 0) {
+			return fmt.Errorf("SUBSCRIBE to %q with MQTT 5 subscription options and no properties", sub.Filter)
+		}
+
+		if err := ValidateFilter(sub.Filter); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func decodeSubscribe(d *decoder) (Packet, error) {
+	var s = &Subscribe{}
+	var err error
+
+	if s.PacketID, err = d.readUint16(); err != nil {
+		return nil, err
+	}
+
+	var reserved byte = 0xFC
+
+	if d.v5() {
+		if s.Properties, err = decodeProperties(d, SUBSCRIBE); err != nil {
+			return nil, err
+		}
+
+		reserved = 0xC0
+	}
+
+	for d.remaining() > 0 {
+		var sub SubscribeFilter
+
+		if sub.Filter, err = d.readString(); err != nil {
+			return nil, err
+		}
+
+		options, err := d.readByte()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if options&reserved != 0 {
+			return nil, fmt.Errorf("SUBSCRIBE to %q with reserved option bits set", sub.Filter)
+		}
+
+		sub.QoS = options & 0x03
+		sub.NoLocal = options&subscribeOptionNoLocal != 0
+		sub.RetainAsPublished = options&subscribeOptionRetainAsPublished != 0
+		sub.RetainHandling = options >> subscribeOptionRetainHandling & 0x03
+		s.Subscriptions = append(s.Subscriptions, sub)
+	}
+
+	if err = s.validate(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *Suback) Encode() ([]byte, error) {
+	if s.PacketID == 0 {
+		return nil, fmt.Errorf("SUBACK with no packet id")
+	}
+
+	if err := validateSubackCodes(s.ReturnCodes, s.Properties != nil); err != nil {
+		return nil, err
+	}
+
+	var body = appendUint16(nil, s.PacketID)
+
+	if s.Properties != nil {
+		var err error
+
+		if body, err = s.Properties.appendTo(body, SUBACK); err != nil {
+			return nil, err
+		}
+	}
+
+	body = append(body, s.ReturnCodes...)
+
+	return encodePacket(SUBACK, 0, body)
+}
+
+func decodeSuback(d *decoder) (Packet, error) {
+	var s = &Suback{}
+	var err error
+
+	if s.PacketID, err = d.readUint16(); err != nil {
+		return nil, err
+	}
+
+	if s.PacketID == 0 {
+		return nil, protocolErrorf("SUBACK with no packet id")
+	}
+
+	if d.v5() {
+		if s.Properties, err = decodeProperties(d, SUBACK); err != nil {
+			return nil, err
+		}
+	}
+
+	s.ReturnCodes = d.rest()
+
+	if len(s.ReturnCodes) == 0 {
+		return nil, protocolErrorf("SUBACK with no return codes")
+	}
+
+	if err = validateSubackCodes(s.ReturnCodes, d.v5()); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// validateSubackCodes checks 3.1.1 return codes, or with reasonCodes any failure reason code besides 0x80.
+func validateSubackCodes(codes []byte, reasonCodes bool) error {
+	for _, code := range codes {
+		if code > 2 && code != SubackFailure && !(reasonCodes && code > SubackFailure) {
+			return fmt.Errorf("SUBACK with invalid return code 0x%02X", code)
+		}
+	}
+
+	return nil
+}
+
+func (u *Unsubscribe) Encode() ([]byte, error) {
+	if err := u.validate(); err != nil {
+		return nil, err
+	}
+
+	var body = appendUint16(nil, u.PacketID)
+	var err error
+
+	if u.Properties != nil {
+		if body, err = u.Properties.appendTo(body, UNSUBSCRIBE); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, filter := range u.Filters {
+		if body, err = appendString(body, filter); err != nil {
+			return nil, err
+		}
+	}
+
+	return encodePacket(UNSUBSCRIBE, 0x02, body)
+}
+
+func (u *Unsubscribe) validate() error {
+	if u.PacketID == 0 {
+		return protocolErrorf("UNSUBSCRIBE with no packet id")
+	}
+
+	if len(u.Filters) == 0 {
+		return protocolErrorf("UNSUBSCRIBE with no topic filters")
+	}
+
+	for _, filter := range u.Filters {
+		if err := ValidateFilter(filter); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func decodeUnsubscribe(d *decoder) (Packet, error) {
+	var u = &Unsubscribe{}
+	var err error
+
+	if u.PacketID, err = d.readUint16(); err != nil {
+		return nil, err
+	}
+
+	if d.v5() {
+		if u.Properties, err = decodeProperties(d, UNSUBSCRIBE); err != nil {
+			return nil, err
+		}
+	}
+
+	for d.remaining() > 0 {
+		filter, err := d.readString()
+
+		if err != nil {
+			return nil, err
+		}
+
+		u.Filters = append(u.Filters, filter)
+	}
+
+	if err = u.validate(); err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+func (u *Unsuback) Encode() ([]byte, error) {
+	if u.PacketID == 0 {
+		return nil, fmt.Errorf("UNSUBACK with no packet id")
+	}
+
+	if len(u.ReasonCodes) == 0 {
+		return nil, fmt.Errorf("UNSUBACK with no reason codes")
+	}
+
+	body, err := u.Properties.appendTo(appendUint16(nil, u.PacketID), UNSUBACK)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return encodePacket(UNSUBACK, 0, append(body, u.ReasonCodes...))
+}
+
+func decodeUnsuback(d *decoder) (Packet, error) {
+	var u = &Unsuback{}
+	var err error
+
+	if u.PacketID, err = d.readUint16(); err != nil {
+		return nil, err
+	}
+
+	if u.PacketID == 0 {
+		return nil, protocolErrorf("UNSUBACK with no packet id")
+	}
+
+	if u.Properties, err = decodeProperties(d, UNSUBACK); err != nil {
+		return nil, err
+	}
+
+	if u.ReasonCodes = d.rest(); len(u.ReasonCodes) == 0 {
+		return nil, protocolErrorf("UNSUBACK with no reason codes")
+	}
+
+	return u, nil
+}
+
+// ValidateFilter checks that '#' only appears as the whole last level and '+' only as a whole level.
+func ValidateFilter(filter string) error {
+	if filter == "" {
+		return fmt.Errorf("empty topic filter")
+	}
+
+	var levelStart = 0
+
+	for i := 0; i <= len(filter); i++ {
+		if i < len(filter) && filter[i] != '/' {
+			continue
+		}
+
+		var level = filter[levelStart:i]
+
+		switch {
+		case level == "#" && i != len(filter):
+			return fmt.Errorf("topic filter %q has '#' before the last level", filter)
+		case len(level) > 1 && (containsByte(level, '#') || containsByte(level, '+')):
+			return fmt.Errorf("topic filter %q has a wildcard inside a level", filter)
+		}
+
+		levelStart = i + 1
+	}
+
+	return nil
+}
+
+func containsByte(s string, b byte) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] == b {
+			return true
+		}
+	}
+
+	return false
+}
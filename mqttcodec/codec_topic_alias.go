@@ -0,0 +1,155 @@
+package mqttcodec
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/MarcusOuelletus/demo/reasoncodes"
+)
+
+/*
+TopicAliasMap keeps the topic aliases of one connection, they only live as long as the network connection.
+
+Outbound rewrites a PUBLISH before it is encoded: the first PUBLISH to a topic carries the topic name and a newly
+assigned alias, later ones only the alias. Once the peer's Topic Alias Maximum is used up the least recently used alias
+is reassigned. Inbound resolves a decoded PUBLISH back to its topic name, an alias of 0, one above our own Topic Alias
+Maximum or one that was never set up is a Topic Alias invalid (0x94) error.
+
+A PacketWriter or PacketReader with Aliases set does both by itself, the writer assigns the alias and queues the packet
+under its lock so a PUBLISH that only carries an alias can never overtake the one setting it up.
+*/
+
+type TopicAliasMap struct {
+	sync.Mutex
+	outboundMax uint16
+	inboundMax  uint16
+	outbound    map[string]*list.Element
+	lru         *list.List
+	inbound     map[uint16]string
+}
+
+type topicAlias struct {
+	topic string
+	alias uint16
+	// announced is set once a PUBLISH carrying both topic and alias went out, until then the topic is sent along.
+	announced bool
+}
+
+// NewTopicAliasMap takes the Topic Alias Maximum the peer sent (outbound) and the one we sent (inbound), 0 disables aliases
+// in that direction.
+func NewTopicAliasMap(outboundMax, inboundMax uint16) *TopicAliasMap {
+	return &TopicAliasMap{
+		outboundMax: outboundMax,
+		inboundMax:  inboundMax,
+		outbound:    make(map[string]*list.Element),
+		lru:         list.New(),
+		inbound:     make(map[uint16]string),
+	}
+}
+
+// Outbound sets the topic alias on p and clears the topic name when the peer already knows the alias.
+func (m *TopicAliasMap) Outbound(p *Publish) {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.outboundMax == 0 || p.TopicName == "" {
+		return
+	}
+
+	var alias uint16
+	var known bool
+
+	if e, ok := m.outbound[p.TopicName]; ok {
+		var entry = e.Value.(*topicAlias)
+
+		m.lru.MoveToFront(e)
+		alias, known = entry.alias, entry.announced
+		entry.announced = true
+	} else {
+		alias = m.assign(p.TopicName)
+	}
+
+	// -- copied so a Properties shared between packets is left alone
+	var props Properties
+
+	if p.Properties != nil {
+		props = *p.Properties
+	}
+
+	props.TopicAlias = Uint16(alias)
+	p.Properties = &props
+	// --
+
+	if known {
+		p.TopicName = ""
+	}
+}
+
+func (m *TopicAliasMap) assign(topic string) uint16 {
+	var entry *topicAlias
+
+	if m.lru.Len() < int(m.outboundMax) {
+		entry = &topicAlias{alias: uint16(m.lru.Len() + 1)}
+	} else {
+		var oldest = m.lru.Back()
+		entry = m.lru.Remove(oldest).(*topicAlias)
+		delete(m.outbound, entry.topic)
+	}
+
+	entry.topic, entry.announced = topic, true
+	m.outbound[topic] = m.lru.PushFront(entry)
+
+	return entry.alias
+}
+
+// Inbound fills in the topic name of p from its topic alias, or records the alias when p carries both.
+func (m *TopicAliasMap) Inbound(p *Publish) error {
+	if p.Properties == nil || p.Properties.TopicAlias == nil {
+		return nil
+	}
+
+	var alias = *p.Properties.TopicAlias
+
+	if alias == 0 || alias > m.inboundMax {
+		return reasonErrorf(reasoncodes.TopicAliasInvalid, "topic alias %d is outside 1..%d", alias, m.inboundMax)
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	if p.TopicName != "" {
+		m.inbound[alias] = p.TopicName
+		return nil
+	}
+
+	topic, ok := m.inbound[alias]
+
+	if !ok {
+		return reasonErrorf(reasoncodes.TopicAliasInvalid, "topic alias %d was never set up", alias)
+	}
+
+	p.TopicName = topic
+
+	return nil
+}
+
+// unannounce is for a PUBLISH that went through Outbound but was never written, the next one to its topic carries the
+// topic name again.
+func (m *TopicAliasMap) unannounce(topic string) {
+	m.Lock()
+	defer m.Unlock()
+
+	if e, ok := m.outbound[topic]; ok {
+		e.Value.(*topicAlias).announced = false
+	}
+}
+
+// Reset drops every alias, for a new network connection.
+func (m *TopicAliasMap) Reset() {
+	m.Lock()
+	defer m.Unlock()
+
+	m.outbound = make(map[string]*list.Element)
+	m.lru.Init()
+	m.inbound = make(map[uint16]string)
+}
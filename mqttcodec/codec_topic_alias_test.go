@@ -0,0 +1,111 @@
+package mqttcodec
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/MarcusOuelletus/demo/reasoncodes"
+)
+
+func TestTopicAliasesThroughWriterAndReader(t *testing.T) {
+	var wire bytes.Buffer
+	var w = NewPacketWriter(&wire)
+
+	w.FlushDelay = 0
+	w.Version = Version5
+	w.Aliases = NewTopicAliasMap(2, 0)
+
+	var topics = []string{"a", "a", "b", "c", "a"}
+
+	for i, topic := range topics {
+		var p = &Publish{TopicName: topic, Payload: []byte{byte(i)}}
+
+		if err := w.WritePacket(p); err != nil {
+			t.Fatal(err)
+		}
+
+		if p.TopicName != topic || p.Properties != nil {
+			t.Fatalf("WritePacket changed the PUBLISH passed in: %+v", p)
+		}
+	}
+
+	var r = NewPacketReader(bytes.NewReader(wire.Bytes()))
+	var raw = NewPacketReader(bytes.NewReader(wire.Bytes()))
+
+	r.Version, raw.Version = Version5, Version5
+	r.Aliases = NewTopicAliasMap(0, 2)
+
+	// "c" takes the alias of "a", the least recently used one, so the last "a" is sent with its name again
+	var onWire = []string{"a", "", "b", "c", "a"}
+
+	for i, topic := range topics {
+		p, err := r.ReadPacket()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if p.(*Publish).TopicName != topic || p.(*Publish).Payload[0] != byte(i) {
+			t.Fatalf("packet %d resolved to %+v, want topic %q", i, p, topic)
+		}
+
+		if p, _ = raw.ReadPacket(); p.(*Publish).TopicName != onWire[i] {
+			t.Fatalf("packet %d went out with topic %q, want %q", i, p.(*Publish).TopicName, onWire[i])
+		}
+	}
+}
+
+func TestTopicAliasInvalid(t *testing.T) {
+	for _, test := range []struct {
+		topic string
+		alias uint16
+	}{
+		// 3 is above the maximum of 2, even with a topic name, 1 was never set up
+		{"x", 3}, {"", 1},
+	} {
+		var wire bytes.Buffer
+		var w = NewPacketWriter(&wire)
+
+		w.FlushDelay = 0
+		w.Version = Version5
+
+		if err := w.WritePacket(&Publish{TopicName: test.topic, Properties: &Properties{TopicAlias: Uint16(test.alias)}}); err != nil {
+			t.Fatal(err)
+		}
+
+		var r = NewPacketReader(&wire)
+		r.Version = Version5
+		r.Aliases = NewTopicAliasMap(0, 2)
+
+		if _, err := r.ReadPacket(); ReasonCode(err) != reasoncodes.TopicAliasInvalid {
+			t.Errorf("alias %d: %v", test.alias, err)
+		}
+	}
+}
+
+func TestTopicAliasNotAnnouncedWhenTooLarge(t *testing.T) {
+	var wire bytes.Buffer
+	var w = NewPacketWriter(&wire)
+
+	w.FlushDelay = 0
+	w.Version = Version5
+	w.MaxPacketSize = 32
+	w.Aliases = NewTopicAliasMap(1, 0)
+
+	if err := w.WritePacket(&Publish{TopicName: "t", Payload: make([]byte, 64)}); err == nil {
+		t.Fatal("a packet above the maximum packet size was written")
+	}
+
+	// -- the alias was assigned but never reached the peer, the next PUBLISH still has to carry the topic name
+	if err := w.WritePacket(&Publish{TopicName: "t"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var r = NewPacketReader(&wire)
+	r.Version = Version5
+
+	if p, err := r.ReadPacket(); err != nil || p.(*Publish).TopicName != "t" {
+		t.Fatalf("got %v, %v", p, err)
+	}
+	// --
+}
@@ -0,0 +1,119 @@
+package mqttcodec
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/modules/logger"
+)
+
+/*
+Enabling a Tracer traces one connection on purpose, for the client that misbehaves: its packets are logged at info level
+whatever level the Logger has for debug entries, with their own dump size and rate, until Disable. The broker enables
+the Tracer of every connection of a client id passed to TraceClient, a client the one of ClientOptions.Trace. Enable and
+Disable are safe while the connection is in use, the PacketReader and PacketWriter keep the same Tracer.
+
+Both ways are rate limited, a connection that floods the log with packets has the excess counted instead and the next
+entry that goes out carries how many were dropped before it.
+*/
+
+// DefaultTraceRate is the Rate of TraceOptions that leave it 0.
+var DefaultTraceRate = 100.0
+
+type TraceOptions struct {
+	// MaxDump is how many bytes of every packet are hexdumped, 0 dumps none.
+	MaxDump int
+	// Rate caps the packets logged per second, 0 is DefaultTraceRate. Burst of them go at once, 0 is Rate rounded up.
+	Rate  float64
+	Burst int
+}
+
+// tracing is what Enable sets, its limiter starts full.
+type tracing struct {
+	options TraceOptions
+	limiter traceLimiter
+}
+
+// traceSettings are what the next entry is logged with.
+type traceSettings struct {
+	level   logger.Level
+	maxDump int
+	rate    float64
+	burst   int
+	limiter *traceLimiter
+}
+
+// Enable logs every packet at info level as o says.
+func (t *Tracer) Enable(o TraceOptions) {
+	if o.Rate <= 0 {
+		o.Rate = DefaultTraceRate
+	}
+
+	t.enabled.Store(&tracing{options: o})
+}
+
+// Disable goes back to logging at debug level.
+func (t *Tracer) Disable() {
+	t.enabled.Store(nil)
+}
+
+// settings returns what the next entry is logged with, false when it is not logged at all.
+func (t *Tracer) settings() (traceSettings, bool) {
+	if t == nil || t.Logger == nil {
+		return traceSettings{}, false
+	}
+
+	if e := t.enabled.Load(); e != nil {
+		var o = e.options
+		return traceSettings{logger.InfoLevel, o.MaxDump, o.Rate, o.Burst, &e.limiter}, logger.Enabled(t.Logger, logger.InfoLevel)
+	}
+
+	return traceSettings{logger.DebugLevel, t.MaxDump, t.Rate, t.Burst, &t.limiter}, logger.Enabled(t.Logger, logger.DebugLevel)
+}
+
+// traceLimiter is a token bucket, it starts full.
+type traceLimiter struct {
+	mu      sync.Mutex
+	started bool
+	tokens  float64
+	last    time.Time
+	dropped uint64
+}
+
+// allow takes a token of a bucket filled at rate up to burst, it returns false when there is none and otherwise how
+// many entries were dropped since the last one went out. A rate of 0 lets everything through.
+func (l *traceLimiter) allow(rate float64, burst int) (uint64, bool) {
+	if rate <= 0 {
+		return 0, true
+	}
+
+	var capacity = float64(burst)
+
+	if burst <= 0 {
+		capacity = math.Ceil(rate)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var now = time.Now()
+
+	if !l.started {
+		l.started, l.tokens, l.last = true, capacity, now
+	}
+	l.tokens = math.Min(capacity, l.tokens+now.Sub(l.last).Seconds()*rate)
+	l.last = now
+
+	if l.tokens < 1 {
+		l.dropped++
+		return 0, false
+	}
+
+	l.tokens--
+
+	var dropped = l.dropped
+	l.dropped = 0
+
+	return dropped, true
+}
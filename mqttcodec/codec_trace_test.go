@@ -0,0 +1,80 @@
+package mqttcodec
+
+import (
+	"testing"
+
+	"github.com/MarcusOuelletus/demo/modules/logger"
+)
+
+// traceRecorder keeps the entries it gets, it drops debug ones unless debug is set.
+type traceRecorder struct {
+	debug   bool
+	entries []logger.Fields
+	levels  []logger.Level
+}
+
+func (r *traceRecorder) Enabled(level logger.Level) bool {
+	return r.debug || level > logger.DebugLevel
+}
+
+func (r *traceRecorder) add(level logger.Level, fields logger.Fields) {
+	r.entries = append(r.entries, fields)
+	r.levels = append(r.levels, level)
+}
+
+func (r *traceRecorder) Debug(msg string, fields logger.Fields) { r.add(logger.DebugLevel, fields) }
+func (r *traceRecorder) Info(msg string, fields logger.Fields)  { r.add(logger.InfoLevel, fields) }
+func (r *traceRecorder) Warn(msg string, fields logger.Fields)  { r.add(logger.WarnLevel, fields) }
+func (r *traceRecorder) Error(msg string, fields logger.Fields) { r.add(logger.ErrorLevel, fields) }
+
+func tracePackets(t *Tracer, n int) {
+	for i := 0; i < n; i++ {
+		t.Trace(Outbound, Pingreq, []byte{0xC0, 0x00})
+	}
+}
+
+func TestTracerRateLimit(t *testing.T) {
+	var r = &traceRecorder{debug: true}
+	var tracer = NewTracer(r)
+	tracer.Rate, tracer.Burst = 0.001, 2
+
+	tracePackets(tracer, 5)
+
+	if len(r.entries) != 2 {
+		t.Fatalf("logged %d packets, want the burst of 2", len(r.entries))
+	}
+
+	// -- the next entry that goes out carries the drops before it
+	tracer.limiter.tokens = 1
+	tracePackets(tracer, 1)
+
+	if len(r.entries) != 3 || r.entries[2]["dropped"] != uint64(3) {
+		t.Fatalf("entries %v, want a third one with 3 dropped", r.entries)
+	}
+	// --
+}
+
+func TestTracerEnable(t *testing.T) {
+	var r = &traceRecorder{}
+	var tracer = NewTracer(r)
+
+	tracePackets(tracer, 1)
+
+	if len(r.entries) != 0 {
+		t.Fatalf("logged %d packets without debug", len(r.entries))
+	}
+
+	tracer.Enable(TraceOptions{MaxDump: 1})
+	tracePackets(tracer, 1)
+
+	if len(r.entries) != 1 || r.levels[0] != logger.InfoLevel || r.entries[0]["hex"] != "c0" || r.entries[0]["truncated"] != true {
+		t.Fatalf("enabled tracer logged %v at %v", r.entries, r.levels)
+	}
+
+	tracer.Disable()
+	tracePackets(tracer, 1)
+
+	if len(r.entries) != 1 {
+		t.Fatalf("disabled tracer logged %d packets", len(r.entries)-1)
+	}
+}
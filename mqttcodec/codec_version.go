@@ -0,0 +1,215 @@
+package mqttcodec
+
+import (
+	"fmt"
+
+	"github.com/MarcusOuelletus/demo/reasoncodes"
+)
+
+/*
+Every connection speaks one protocol version, picked by the protocol level in its CONNECT. The packet types are shared
+between the versions: a packet with Properties set (CONNECT: at ProtocolLevel5) encodes in the MQTT 5 layout, one
+without in the 3.1.1 layout.
+
+ProtocolVersion.Adapt is the one place that converts a packet to what the connection can carry, so the session code
+above can build MQTT 5 packets and send them to a 3.1.1 peer:
+
+ - to 3.1.1, properties, reason codes of acknowledgements and MQTT 5 subscription options are dropped, CONNACK and
+   SUBACK reason codes are mapped to the closest return code, Unsuback and Disconnection become their 3.1.1 packets,
+   and AUTH or a PUBLISH that only has a topic alias cannot be sent at all.
+ - to 5, missing property sections are added and CONNACK return codes are mapped to reason codes.
+*/
+
+type ProtocolVersion byte
+
+const (
+	Version311 ProtocolVersion = ProtocolLevel
+	Version5   ProtocolVersion = ProtocolLevel5
+)
+
+func (v ProtocolVersion) String() string {
+	switch v {
+	case Version311:
+		return "3.1.1"
+	case Version5:
+		return "5.0"
+	case 3:
+		return "3.1"
+	}
+
+	return fmt.Sprintf("ProtocolVersion(%d)", byte(v))
+}
+
+// VersionOf returns the version a CONNECT asks for, MQIsdp (3.1) is handled as 3.1.1.
+func VersionOf(c *Connect) ProtocolVersion {
+	if c.ProtocolLevel == ProtocolLevel5 {
+		return Version5
+	}
+
+	return Version311
+}
+
+// Decode is Decode for a connection speaking v.
+func (v ProtocolVersion) Decode(h FixedHeader, body []byte) (Packet, error) {
+	return decode(h, body, false, v)
+}
+
+// Encode adapts p to v and encodes it.
+func (v ProtocolVersion) Encode(p Packet) ([]byte, error) {
+	adapted, err := v.Adapt(p)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return adapted.Encode()
+}
+
+// Adapt returns p converted for a connection speaking v, p itself is never modified.
+func (v ProtocolVersion) Adapt(p Packet) (Packet, error) {
+	if v == Version5 {
+		return upgrade(p)
+	}
+
+	return downgrade(p)
+}
+
+func downgrade(p Packet) (Packet, error) {
+	switch p := p.(type) {
+	case *Connect:
+		var c = *p
+		c.ProtocolName, c.ProtocolLevel = ProtocolName, ProtocolLevel
+		c.Properties, c.WillProperties = nil, nil
+		return &c, nil
+	case *Connack:
+		return &Connack{SessionPresent: p.SessionPresent, ReturnCode: ConnackReturnCode(p.ReturnCode)}, nil
+	case *Publish:
+		if p.TopicName == "" {
+			return nil, fmt.Errorf("PUBLISH with only a topic alias cannot be sent over MQTT 3.1.1")
+		}
+		var c = *p
+		c.Properties = nil
+		return &c, nil
+	case *Ack:
+		return &Ack{PacketType: p.PacketType, PacketID: p.PacketID}, nil
+	case *Subscribe:
+		var c = Subscribe{PacketID: p.PacketID, Subscriptions: make([]SubscribeFilter, len(p.Subscriptions))}
+		for i, sub := range p.Subscriptions {
+			c.Subscriptions[i] = SubscribeFilter{Filter: sub.Filter, QoS: sub.QoS}
+		}
+		return &c, nil
+	case *Suback:
+		var c = Suback{PacketID: p.PacketID, ReturnCodes: make([]byte, len(p.ReturnCodes))}
+		for i, code := range p.ReturnCodes {
+			if code >= SubackFailure {
+				code = SubackFailure
+			}
+			c.ReturnCodes[i] = code
+		}
+		return &c, nil
+	case *Unsubscribe:
+		return &Unsubscribe{PacketID: p.PacketID, Filters: p.Filters}, nil
+	case *Unsuback:
+		return NewUnsuback(p.PacketID), nil
+	case *Disconnection:
+		return Disconnect, nil
+	case *Auth:
+		return nil, fmt.Errorf("AUTH cannot be sent over MQTT 3.1.1")
+	}
+
+	return p, nil
+}
+
+func upgrade(p Packet) (Packet, error) {
+	switch p := p.(type) {
+	case *Connect:
+		var c = *p
+		c.ProtocolName, c.ProtocolLevel = ProtocolName, ProtocolLevel5
+		return &c, nil
+	case *Connack:
+		var c = *p
+		c.ReturnCode = ConnackReasonCode(p.ReturnCode)
+		c.Properties = orEmpty(p.Properties)
+		return &c, nil
+	case *Publish:
+		var c = *p
+		c.Properties = orEmpty(p.Properties)
+		return &c, nil
+	case *Subscribe:
+		var c = *p
+		c.Properties = orEmpty(p.Properties)
+		return &c, nil
+	case *Suback:
+		var c = *p
+		c.Properties = orEmpty(p.Properties)
+		return &c, nil
+	case *Unsubscribe:
+		var c = *p
+		c.Properties = orEmpty(p.Properties)
+		return &c, nil
+	case *Ack:
+		if p.PacketType == UNSUBACK {
+			return nil, fmt.Errorf("UNSUBACK over MQTT 5 needs an Unsuback with reason codes")
+		}
+	case *Empty:
+		if p.PacketType == DISCONNECT {
+			return &Disconnection{}, nil
+		}
+	}
+
+	return p, nil
+}
+
+func orEmpty(p *Properties) *Properties {
+	if p == nil {
+		return &Properties{}
+	}
+
+	return p
+}
+
+// ConnackReturnCode maps an MQTT 5 CONNACK reason code to the 3.1.1 return code closest to it, 3.1.1 codes are kept.
+func ConnackReturnCode(code byte) byte {
+	switch code {
+	case ConnackAccepted, ConnackUnacceptableProtocol, ConnackIdentifierRejected, ConnackServerUnavailable,
+		ConnackBadUsernameOrPassword, ConnackNotAuthorized:
+		return code
+	}
+
+	switch reasoncodes.Code(code) {
+	case reasoncodes.UnsupportedProtocolVersion:
+		return ConnackUnacceptableProtocol
+	case reasoncodes.ClientIdentifierNotValid:
+		return ConnackIdentifierRejected
+	case reasoncodes.BadUserNameOrPassword, reasoncodes.BadAuthenticationMethod:
+		return ConnackBadUsernameOrPassword
+	case reasoncodes.NotAuthorized, reasoncodes.Banned:
+		return ConnackNotAuthorized
+	}
+
+	return ConnackServerUnavailable
+}
+
+// ConnackReasonCode maps a 3.1.1 CONNACK return code to its MQTT 5 reason code, other codes are kept.
+func ConnackReasonCode(code byte) byte {
+	switch code {
+	case ConnackUnacceptableProtocol:
+		return byte(reasoncodes.UnsupportedProtocolVersion)
+	case ConnackIdentifierRejected:
+		return byte(reasoncodes.ClientIdentifierNotValid)
+	case ConnackServerUnavailable:
+		return byte(reasoncodes.ServerUnavailable)
+	case ConnackBadUsernameOrPassword:
+		return byte(reasoncodes.BadUserNameOrPassword)
+	case ConnackNotAuthorized:
+		return byte(reasoncodes.NotAuthorized)
+	}
+
+	return code
+}
+
+// ShouldDowngrade reports whether a CONNACK answering an MQTT 5 CONNECT means the server only speaks 3.1.1, the client
+// should then reconnect with Version311.
+func ShouldDowngrade(c *Connack) bool {
+	return c.ReturnCode == ConnackUnacceptableProtocol || c.ReturnCode == byte(reasoncodes.UnsupportedProtocolVersion)
+}
@@ -0,0 +1,162 @@
+package mqttcodec
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+/*
+PacketWriter coalesces encoded packets into one buffer and writes it out in a single call, either once FlushSize bytes
+are pending or FlushDelay after the first packet went into an empty buffer, whichever comes first. A FlushDelay of 0
+writes every packet straight away. Small packets like PUBACK and PINGRESP are 2 to 4 bytes, so writing each on its own
+costs a syscall per packet on a busy connection.
+
+With Aliases set every PUBLISH goes through the TopicAliasMap's Outbound on the way, the packet passed in is left alone
+and the aliased copy is what gets encoded.
+
+The first write error is kept: every later call returns it and drops the packet, the connection is gone anyway.
+*/
+
+var ErrWriterClosed = errors.New("packet writer is closed")
+
+var (
+	DefaultFlushSize  = 16 * 1024
+	DefaultFlushDelay = time.Millisecond
+)
+
+type PacketWriter struct {
+	sync.Mutex
+	MaxPacketSize int
+	FlushSize     int
+	FlushDelay    time.Duration
+	Version       ProtocolVersion
+	Trace         *Tracer
+	Aliases       *TopicAliasMap
+	w             io.Writer
+	buf           []byte
+	timer         *time.Timer
+	err           error
+	closed        bool
+}
+
+func NewPacketWriter(w io.Writer) *PacketWriter {
+	return &PacketWriter{FlushSize: DefaultFlushSize, FlushDelay: DefaultFlushDelay, w: w}
+}
+
+// WritePacket adapts packet to the writer's Version, encodes and queues it, it is on the wire after the next flush.
+func (p *PacketWriter) WritePacket(packet Packet) error {
+	packet, err := p.Version.Adapt(packet)
+
+	if err != nil {
+		return err
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	if p.closed {
+		return ErrWriterClosed
+	}
+
+	if p.err != nil {
+		return p.err
+	}
+
+	// -- the alias is assigned under the lock, the packets go out in the order their aliases were
+	var aliased *Publish
+
+	if publish, ok := packet.(*Publish); ok && p.Aliases != nil {
+		var copied = *publish
+		p.Aliases.Outbound(&copied)
+		packet, aliased = &copied, publish
+	}
+	// --
+
+	data, err := EncodeLimited(packet, p.MaxPacketSize)
+
+	if err != nil {
+		if aliased != nil {
+			p.Aliases.unannounce(aliased.TopicName)
+		}
+		return err
+	}
+
+	p.Trace.Trace(Outbound, packet, data)
+
+	p.buf = append(p.buf, data...)
+
+	if p.FlushDelay <= 0 || len(p.buf) >= p.FlushSize {
+		return p.flush()
+	}
+
+	// -- the timer is only armed for the first packet of a batch
+	if p.timer == nil {
+		p.timer = time.AfterFunc(p.FlushDelay, p.flushTimer)
+	}
+
+	return nil
+}
+
+func (p *PacketWriter) Flush() error {
+	p.Lock()
+	defer p.Unlock()
+
+	return p.flush()
+}
+
+// Buffered returns the number of bytes waiting for a flush.
+func (p *PacketWriter) Buffered() int {
+	p.Lock()
+	defer p.Unlock()
+
+	return len(p.buf)
+}
+
+// Close flushes what is pending, it does not close the underlying writer.
+func (p *PacketWriter) Close() error {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.closed {
+		return nil
+	}
+
+	var err = p.flush()
+	p.closed = true
+
+	return err
+}
+
+func (p *PacketWriter) flushTimer() {
+	p.Lock()
+	defer p.Unlock()
+
+	p.timer = nil
+	p.flush()
+}
+
+func (p *PacketWriter) flush() error {
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+
+	if p.err != nil {
+		return p.err
+	}
+
+	if len(p.buf) == 0 {
+		return nil
+	}
+
+	_, err := p.w.Write(p.buf)
+	p.buf = p.buf[:0]
+
+	if err != nil {
+		p.err = err
+	}
+
+	return err
+}
@@ -0,0 +1,98 @@
+package mqttcodec
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// benchmarkConn is a loopback TCP connection whose other end discards everything, so every Write is a real syscall.
+func benchmarkConn(b *testing.B) net.Conn {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	defer l.Close()
+
+	go func() {
+		if conn, err := l.Accept(); err == nil {
+			io.Copy(io.Discard, conn)
+			conn.Close()
+		}
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+// countingWriter counts the Write calls that reach the connection.
+type countingWriter struct {
+	w      io.Writer
+	writes int
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	c.writes++
+	return c.w.Write(b)
+}
+
+// BenchmarkWriteAcks writes PUBACKs one Write per packet with WritePacketLimited, and through PacketWriters with and
+// without coalescing.
+func BenchmarkWriteAcks(b *testing.B) {
+	var ack = &Ack{PacketType: PUBACK, PacketID: 1}
+
+	b.Run("direct", func(b *testing.B) {
+		var w = &countingWriter{w: benchmarkConn(b)}
+
+		b.ReportAllocs()
+
+		for i := 0; i < b.N; i++ {
+			if err := WritePacketLimited(w, ack, 0); err != nil {
+				b.Fatal(err)
+			}
+		}
+
+		b.ReportMetric(float64(w.writes)/float64(b.N), "writes/op")
+	})
+
+	for _, test := range []struct {
+		name  string
+		delay time.Duration
+	}{
+		{"uncoalesced", 0},
+		{"coalesced", DefaultFlushDelay},
+	} {
+		b.Run(test.name, func(b *testing.B) {
+			var w = &countingWriter{w: benchmarkConn(b)}
+			var p = NewPacketWriter(w)
+
+			p.FlushDelay = test.delay
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				if err := p.WritePacket(ack); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			if err := p.Close(); err != nil {
+				b.Fatal(err)
+			}
+
+			b.StopTimer()
+			p.Lock()
+			b.ReportMetric(float64(w.writes)/float64(b.N), "writes/op")
+			p.Unlock()
+		})
+	}
+}
@@ -0,0 +1,379 @@
+package mqtttest
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/client"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+)
+
+/*
+A MockBroker is a scripted MQTT server for testing the client and the session layers against a broker that misbehaves
+on cue. It speaks just enough of the protocol to keep a client going, and every packet type it answers can be given a
+Handler of its own that decides what goes back: nothing, a late acknowledgement, the acknowledgement twice, one with
+the wrong packet id, a refused CONNACK or a closed connection.
+
+	var m = mqtttest.NewMockBroker(t)
+
+	m.Handle(mqttcodec.PUBLISH, mqtttest.Script(
+		mqtttest.Drop,
+		mqtttest.Duplicate(mqtttest.Default),
+	))
+
+	var c = client.New(m.Options("c"))
+
+The default answers are a successful CONNACK, a SUBACK granting every requested QoS, an UNSUBACK, PUBACK or PUBREC for
+a QoS 1 or 2 PUBLISH, PUBCOMP for a PUBREL and PINGRESP, a DISCONNECT closes the connection. The mock does not route
+anything, a PUBLISH towards the client is one the test Sends on its Conn.
+
+Every packet the mock reads is recorded, Expect waits for the next one of a type and fails the test after
+DefaultTimeout, so a test asserts on what the client sent without sleeping. The mock listens on a loopback TCP port
+and everything is closed by the test's Cleanup.
+*/
+
+// DefaultTimeout bounds Expect and NextConn.
+var DefaultTimeout = 5 * time.Second
+
+type MockBroker struct {
+	t        testing.TB
+	listener net.Listener
+	clients  atomic.Int64
+
+	mu       sync.Mutex
+	handlers map[mqttcodec.PacketType]Handler
+	conns    []*Conn
+	received []Received
+	// connected are the conns whose CONNECT arrived, NextConn hands them out from accepted on.
+	connected []*Conn
+	accepted  int
+	// expected is the index in received after the last packet Expect returned, per type.
+	expected map[mqttcodec.PacketType]int
+	// changed is closed and replaced whenever a packet or a connection arrives.
+	changed chan struct{}
+}
+
+// Received is a packet the mock read and the connection it came on.
+type Received struct {
+	Conn   *Conn
+	Packet mqttcodec.Packet
+}
+
+// NewMockBroker listens on a loopback port until the test ends.
+func NewMockBroker(t testing.TB) *MockBroker {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("mqtttest: listen: %v", err)
+	}
+
+	var m = &MockBroker{
+		t:        t,
+		listener: l,
+		handlers: make(map[mqttcodec.PacketType]Handler),
+		expected: make(map[mqttcodec.PacketType]int),
+		changed:  make(chan struct{}),
+	}
+
+	go m.serve()
+
+	t.Cleanup(m.Close)
+
+	return m
+}
+
+// Address is the broker URL of the mock.
+func (m *MockBroker) Address() string {
+	return "tcp://" + m.listener.Addr().String()
+}
+
+// Options returns the options of a 3.1.1 client with a clean session that connects to the mock. An empty clientID gets
+// a unique one.
+func (m *MockBroker) Options(clientID string) client.ClientOptions {
+	if clientID == "" {
+		clientID = "mqtttest-" + strconv.FormatInt(m.clients.Add(1), 10)
+	}
+
+	return client.ClientOptions{
+		Broker:         m.Address(),
+		ClientID:       clientID,
+		CleanSession:   true,
+		ConnectTimeout: DefaultTimeout,
+	}
+}
+
+// Handle makes h answer every packet of type t from now on, a nil h brings the default answer back.
+func (m *MockBroker) Handle(t mqttcodec.PacketType, h Handler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if h == nil {
+		delete(m.handlers, t)
+		return
+	}
+
+	m.handlers[t] = h
+}
+
+func (m *MockBroker) handler(t mqttcodec.PacketType) Handler {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if h, ok := m.handlers[t]; ok {
+		return h
+	}
+
+	return Default
+}
+
+// Close stops accepting connections and closes every open one.
+func (m *MockBroker) Close() {
+	m.listener.Close()
+
+	m.mu.Lock()
+	var conns = m.conns
+	m.mu.Unlock()
+
+	for _, c := range conns {
+		c.Close()
+	}
+}
+
+// Received returns every packet read so far, in order.
+func (m *MockBroker) Received() []Received {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Received(nil), m.received...)
+}
+
+// Expect returns the next packet of type t the mock read that Expect did not return yet, waiting for it up to
+// DefaultTimeout. Packets of other types are left for their own Expect.
+func (m *MockBroker) Expect(t mqttcodec.PacketType) Received {
+	m.t.Helper()
+
+	var deadline = time.After(DefaultTimeout)
+
+	for {
+		m.mu.Lock()
+
+		for i := m.expected[t]; i < len(m.received); i++ {
+			if m.received[i].Packet.Type() == t {
+				m.expected[t] = i + 1
+				m.mu.Unlock()
+				return m.received[i]
+			}
+		}
+
+		m.expected[t] = len(m.received)
+		var changed = m.changed
+		m.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-deadline:
+			m.t.Fatalf("mqtttest: no %s within %s", t, DefaultTimeout)
+			return Received{}
+		}
+	}
+}
+
+// ExpectNone fails the test when a packet of type t arrives within wait, counting from the last one Expect returned.
+func (m *MockBroker) ExpectNone(t mqttcodec.PacketType, wait time.Duration) {
+	m.t.Helper()
+
+	var deadline = time.After(wait)
+
+	for {
+		m.mu.Lock()
+
+		for i := m.expected[t]; i < len(m.received); i++ {
+			if m.received[i].Packet.Type() == t {
+				m.mu.Unlock()
+				m.t.Fatalf("mqtttest: unexpected %s", t)
+				return
+			}
+		}
+
+		var changed = m.changed
+		m.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-deadline:
+			return
+		}
+	}
+}
+
+// NextConn returns the next connection that sent its CONNECT, in the order they were accepted, waiting for it up to
+// DefaultTimeout.
+func (m *MockBroker) NextConn() *Conn {
+	m.t.Helper()
+
+	var deadline = time.After(DefaultTimeout)
+
+	for {
+		m.mu.Lock()
+
+		if m.accepted < len(m.connected) {
+			var c = m.connected[m.accepted]
+			m.accepted++
+			m.mu.Unlock()
+			return c
+		}
+
+		var changed = m.changed
+		m.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-deadline:
+			m.t.Fatalf("mqtttest: no connection within %s", DefaultTimeout)
+			return nil
+		}
+	}
+}
+
+// notify wakes every waiting Expect and NextConn, m.mu is held.
+func (m *MockBroker) notify() {
+	close(m.changed)
+	m.changed = make(chan struct{})
+}
+
+func (m *MockBroker) serve() {
+	for {
+		conn, err := m.listener.Accept()
+
+		if err != nil {
+			return
+		}
+
+		var c = &Conn{conn: conn, reader: mqttcodec.NewPacketReader(conn), Version: mqttcodec.Version311}
+
+		m.mu.Lock()
+		m.conns = append(m.conns, c)
+		m.mu.Unlock()
+
+		go m.read(c)
+	}
+}
+
+func (m *MockBroker) read(c *Conn) {
+	defer c.Close()
+
+	for {
+		p, err := c.reader.ReadPacket()
+
+		if err != nil {
+			return
+		}
+
+		m.mu.Lock()
+
+		// -- the CONNECT picks the version the rest of the connection is read and written with
+		if connect, ok := p.(*mqttcodec.Connect); ok && c.Connect == nil {
+			c.mu.Lock()
+			c.Connect = connect
+			c.Version = mqttcodec.VersionOf(connect)
+			c.reader.Version = c.Version
+			c.mu.Unlock()
+
+			m.connected = append(m.connected, c)
+		}
+		// --
+
+		m.received = append(m.received, Received{Conn: c, Packet: p})
+		m.notify()
+		m.mu.Unlock()
+
+		var r = m.handler(p.Type())(c, p)
+
+		if r.Delay > 0 {
+			// -- a late answer must not hold up the packets read meanwhile, they are answered as they come
+			go func() {
+				time.Sleep(r.Delay)
+				c.respond(r)
+			}()
+
+			continue
+			// --
+		}
+
+		if !c.respond(r) {
+			return
+		}
+	}
+}
+
+// Conn is one client connection as the mock sees it.
+type Conn struct {
+	// Connect is the CONNECT the client sent, nil until it did.
+	Connect *mqttcodec.Connect
+	// Version is the protocol version of the CONNECT, 3.1.1 until it arrived.
+	Version mqttcodec.ProtocolVersion
+
+	conn   net.Conn
+	reader *mqttcodec.PacketReader
+	mu     sync.Mutex
+}
+
+// Send writes packets to the client in order, a PUBLISH towards it for example.
+func (c *Conn) Send(packets ...mqttcodec.Packet) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, p := range packets {
+		data, err := c.Version.Encode(p)
+
+		if err != nil {
+			return fmt.Errorf("mqtttest: encoding %s: %w", p.Type(), err)
+		}
+
+		if _, err := c.conn.Write(data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close drops the connection without a DISCONNECT.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// ClientID is the client id of the CONNECT, empty until it arrived.
+func (c *Conn) ClientID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.Connect == nil {
+		return ""
+	}
+
+	return c.Connect.ClientID
+}
+
+// respond sends the packets of r and closes the connection if it says so, it returns whether the connection is still
+// open.
+func (c *Conn) respond(r Response) bool {
+	if err := c.Send(r.Packets...); err != nil {
+		c.Close()
+		return false
+	}
+
+	if r.Close {
+		c.Close()
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,180 @@
+package mqtttest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/reasoncodes"
+)
+
+/*
+A Handler answers one packet the mock read. The ones below are the failures a client has to survive, and they wrap each
+other, Delay(time.Second, Duplicate(Default)) is a late acknowledgement that arrives twice. Script plays a sequence of
+them, one per packet, which is how a test makes only the second PUBLISH go wrong.
+*/
+
+// Response is what goes back for one packet.
+type Response struct {
+	Packets []mqttcodec.Packet
+	// Delay sends the packets that much later, the packets read meanwhile are answered without waiting for them.
+	Delay time.Duration
+	// Close closes the connection once the packets are sent, without a DISCONNECT.
+	Close bool
+}
+
+type Handler func(c *Conn, p mqttcodec.Packet) Response
+
+// Default is the answer of a broker that accepts everything.
+func Default(c *Conn, p mqttcodec.Packet) Response {
+	switch p := p.(type) {
+	case *mqttcodec.Connect:
+		return Response{Packets: []mqttcodec.Packet{&mqttcodec.Connack{}}}
+	case *mqttcodec.Subscribe:
+		var codes = make([]byte, len(p.Subscriptions))
+
+		for i, s := range p.Subscriptions {
+			codes[i] = s.QoS
+		}
+
+		return Response{Packets: []mqttcodec.Packet{&mqttcodec.Suback{PacketID: p.PacketID, ReturnCodes: codes}}}
+	case *mqttcodec.Unsubscribe:
+		if c.Version == mqttcodec.Version5 {
+			return Response{Packets: []mqttcodec.Packet{&mqttcodec.Unsuback{PacketID: p.PacketID, ReasonCodes: make([]byte, len(p.Filters))}}}
+		}
+
+		return Response{Packets: []mqttcodec.Packet{mqttcodec.NewUnsuback(p.PacketID)}}
+	case *mqttcodec.Publish:
+		switch p.QoS {
+		case 1:
+			return Response{Packets: []mqttcodec.Packet{mqttcodec.NewPuback(p.PacketID)}}
+		case 2:
+			return Response{Packets: []mqttcodec.Packet{mqttcodec.NewPubrec(p.PacketID)}}
+		}
+	case *mqttcodec.Ack:
+		if p.PacketType == mqttcodec.PUBREL {
+			return Response{Packets: []mqttcodec.Packet{mqttcodec.NewPubcomp(p.PacketID)}}
+		}
+	case *mqttcodec.Empty:
+		switch p.PacketType {
+		case mqttcodec.PINGREQ:
+			return Response{Packets: []mqttcodec.Packet{mqttcodec.Pingresp}}
+		case mqttcodec.DISCONNECT:
+			return Response{Close: true}
+		}
+	}
+
+	return Response{}
+}
+
+// Drop answers nothing, the acknowledgement is lost.
+func Drop(*Conn, mqttcodec.Packet) Response {
+	return Response{}
+}
+
+// Hangup closes the connection without an answer.
+func Hangup(*Conn, mqttcodec.Packet) Response {
+	return Response{Close: true}
+}
+
+// Reply answers with packets, whatever was read.
+func Reply(packets ...mqttcodec.Packet) Handler {
+	return func(*Conn, mqttcodec.Packet) Response {
+		return Response{Packets: packets}
+	}
+}
+
+// Refuse answers a CONNECT with a CONNACK carrying code, the MQTT 5 reason code or the 3.1.1 return code, and closes
+// the connection.
+func Refuse(code reasoncodes.Code) Handler {
+	return func(*Conn, mqttcodec.Packet) Response {
+		return Response{Packets: []mqttcodec.Packet{&mqttcodec.Connack{ReturnCode: byte(code)}}, Close: true}
+	}
+}
+
+// Redirect refuses a CONNECT like Refuse, with the Server Reference pointing the client to reference, code is
+// reasoncodes.UseAnotherServer or reasoncodes.ServerMoved.
+func Redirect(code reasoncodes.Code, reference string) Handler {
+	return func(*Conn, mqttcodec.Packet) Response {
+		var connack = &mqttcodec.Connack{ReturnCode: byte(code), Properties: &mqttcodec.Properties{ServerReference: reference}}
+		return Response{Packets: []mqttcodec.Packet{connack}, Close: true}
+	}
+}
+
+// Delay sends the answer of h d later.
+func Delay(d time.Duration, h Handler) Handler {
+	return func(c *Conn, p mqttcodec.Packet) Response {
+		var r = h(c, p)
+
+		r.Delay += d
+		return r
+	}
+}
+
+// Duplicate sends every packet of the answer of h twice.
+func Duplicate(h Handler) Handler {
+	return func(c *Conn, p mqttcodec.Packet) Response {
+		var r = h(c, p)
+		var packets = make([]mqttcodec.Packet, 0, 2*len(r.Packets))
+
+		for _, p := range r.Packets {
+			packets = append(packets, p, p)
+		}
+
+		r.Packets = packets
+		return r
+	}
+}
+
+// WrongPacketID adds delta to the packet id of every acknowledgement in the answer of h, so it acknowledges a packet
+// the client did not send or one it sent earlier.
+func WrongPacketID(delta uint16, h Handler) Handler {
+	return func(c *Conn, p mqttcodec.Packet) Response {
+		var r = h(c, p)
+		var packets = make([]mqttcodec.Packet, len(r.Packets))
+
+		// -- copies, the handler may hand out the same packet again
+		for i, p := range r.Packets {
+			switch p := p.(type) {
+			case *mqttcodec.Ack:
+				var a = *p
+				a.PacketID += delta
+				packets[i] = &a
+			case *mqttcodec.Suback:
+				var a = *p
+				a.PacketID += delta
+				packets[i] = &a
+			case *mqttcodec.Unsuback:
+				var a = *p
+				a.PacketID += delta
+				packets[i] = &a
+			default:
+				packets[i] = p
+			}
+		}
+		// --
+
+		r.Packets = packets
+		return r
+	}
+}
+
+// Script answers the first packet with the first handler, the second with the second and so on, across every
+// connection, Default answers the ones after the last.
+func Script(handlers ...Handler) Handler {
+	var mu sync.Mutex
+	var next int
+
+	return func(c *Conn, p mqttcodec.Packet) Response {
+		mu.Lock()
+		var h Handler = Default
+
+		if next < len(handlers) {
+			h = handlers[next]
+			next++
+		}
+		mu.Unlock()
+
+		return h(c, p)
+	}
+}
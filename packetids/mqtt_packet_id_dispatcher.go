@@ -1,9 +1,11 @@
 package packetids
 
 import (
-	"../modules/helpers/bytes"
 	"math"
 	"sync"
+
+	"github.com/MarcusOuelletus/demo/modules/helpers/bytes"
+	"github.com/MarcusOuelletus/demo/modules/logger"
 )
 
 // the following comment is weird to have in a demo file but for the sake of keeping this an authentic coding sample I will leave it in.
@@ -28,6 +30,8 @@ type waiterNode struct {
 }
 
 type PacketIDs struct {
+	// Logger is warned when a Reserve has to wait for a Release, nil logs nothing.
+	Logger       logger.Logger
 	mu           sync.Mutex
 	cond         *sync.Cond
 	maxIDReached uint16
@@ -93,6 +97,8 @@ func (p *PacketIDs) Reserve() *PacketID {
 	}
 	// --
 
+	logger.Or(p.Logger).Warn("packet ids: all in use, waiting for a release", logger.Fields{"waiting": p.waitListSize + 1})
+
 	// -- Create a waiter and add it to the wait queue.
 	if p.waitList == nil {
 		w = &waiterNode{}
@@ -0,0 +1,315 @@
+package packetids
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/quick"
+	"time"
+)
+
+/*
+The benchmarks reserve and release ids the way a session does: one at a time, from many goroutines sharing the
+PacketIDs, and with every id in flight so that Reserve has to wait on the wait list for a Release.
+*/
+
+func BenchmarkReserveRelease(b *testing.B) {
+	var ids = New()
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		ids.Release(ids.Reserve().GetBytes())
+	}
+}
+
+func BenchmarkReserveReleaseParallel(b *testing.B) {
+	var ids = New()
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			ids.Release(ids.Reserve().GetBytes())
+		}
+	})
+}
+
+// BenchmarkReserveReleaseHeld keeps a window of ids in flight per goroutine, the way a client with a Receive Maximum
+// does, so the stack is deep and ids come back out of order.
+func BenchmarkReserveReleaseHeld(b *testing.B) {
+	var ids = New()
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		var held = make([]*PacketID, 0, 64)
+
+		for pb.Next() {
+			if len(held) == cap(held) {
+				// -- release every other one, the rest stay in flight a while longer
+				for i := 0; i < len(held); i += 2 {
+					ids.Release(held[i].GetBytes())
+				}
+
+				var kept = held[:0]
+
+				for i := 1; i < len(held); i += 2 {
+					kept = append(kept, held[i])
+				}
+
+				held = kept
+				// --
+			}
+
+			held = append(held, ids.Reserve())
+		}
+
+		for _, id := range held {
+			ids.Release(id.GetBytes())
+		}
+	})
+}
+
+// BenchmarkReserveExhausted has more goroutines reserving than there are ids, and one goroutine releasing them, so
+// most Reserves wait on the wait list for a Release.
+func BenchmarkReserveExhausted(b *testing.B) {
+	var max = MaxSimultaneousRequest
+
+	MaxSimultaneousRequest = 8
+	b.Cleanup(func() { MaxSimultaneousRequest = max })
+
+	var ids = New()
+	var work = make(chan struct{})
+	var reserved = make(chan *PacketID)
+	var reservers, releaser sync.WaitGroup
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for g := 0; g < 32; g++ {
+		reservers.Add(1)
+
+		go func() {
+			defer reservers.Done()
+
+			for range work {
+				reserved <- ids.Reserve()
+			}
+		}()
+	}
+
+	releaser.Add(1)
+
+	go func() {
+		defer releaser.Done()
+
+		for id := range reserved {
+			ids.Release(id.GetBytes())
+		}
+	}()
+
+	for i := 0; i < b.N; i++ {
+		work <- struct{}{}
+	}
+
+	close(work)
+	reservers.Wait()
+	close(reserved)
+	releaser.Wait()
+}
+
+/*
+The property tests drive random sequences of Reserve and Release, from one goroutine against a model of the ids held
+and from several at once, and check what has to hold whatever the interleaving:
+
+ - a reserved id is between 1 and MaxSimultaneousRequest and nobody else holds it
+ - every id handed out so far is either held or on the stack, never both, so none is lost or duplicated
+ - a Reserve waiting for a Release gets an id once one is released, so a run never deadlocks
+
+MaxSimultaneousRequest is kept small so the ids are reused and Reserve waits.
+*/
+
+// script is a sequential run: every op either reserves, while fewer than max are held, or releases the held id it
+// picks.
+type script struct {
+	max uint16
+	ops []uint16
+}
+
+func (script) Generate(r *rand.Rand, size int) reflect.Value {
+	var s = script{max: uint16(1 + r.Intn(32)), ops: make([]uint16, r.Intn(4*size+1))}
+
+	for i := range s.ops {
+		s.ops[i] = uint16(r.Intn(math.MaxUint16))
+	}
+
+	return reflect.ValueOf(s)
+}
+
+func withMax(max uint16) func() {
+	var old = MaxSimultaneousRequest
+
+	MaxSimultaneousRequest = max
+	return func() { MaxSimultaneousRequest = old }
+}
+
+func TestReserveReleaseProperty(t *testing.T) {
+	var property = func(s script) bool {
+		defer withMax(s.max)()
+
+		var ids = New()
+		var held []uint16
+		var holding = make(map[uint16]bool)
+
+		for i, op := range s.ops {
+			// -- halfway through, the rest of the run continues on a restored copy
+			if i == len(s.ops)/2 {
+				ids = NewFromSnapshot(ids.Snapshot())
+			}
+			// --
+
+			if op%3 != 0 && len(held) < int(s.max) {
+				var id = ids.Reserve().Value
+
+				if id == 0 || id > s.max || holding[id] {
+					t.Logf("max %d: reserved %d while holding %v", s.max, id, held)
+					return false
+				}
+
+				held = append(held, id)
+				holding[id] = true
+			} else if len(held) > 0 {
+				var n = int(op) % len(held)
+
+				ids.Release(NewPacketID(held[n]).GetBytes())
+				delete(holding, held[n])
+				held = append(held[:n], held[n+1:]...)
+			}
+
+			if !accounted(t, ids, holding) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	if err := quick.Check(property, &quick.Config{MaxCount: 500}); err != nil {
+		t.Error(err)
+	}
+}
+
+// accounted checks that the ids handed out so far are exactly the held ones and the ones on the stack.
+func accounted(t *testing.T, ids *PacketIDs, holding map[uint16]bool) bool {
+	var s = ids.Snapshot()
+	var free = make(map[uint16]bool, len(s.Free))
+
+	for _, id := range s.Free {
+		if free[id] || holding[id] {
+			t.Logf("id %d is on the stack twice or while it is held", id)
+			return false
+		}
+
+		free[id] = true
+	}
+
+	if len(free)+len(holding) != int(s.MaxIDReached) || ids.GetStackSize() != int64(len(free)) {
+		t.Logf("%d free and %d held ids of %d handed out, stack size %d", len(free), len(holding), s.MaxIDReached, ids.GetStackSize())
+		return false
+	}
+
+	return true
+}
+
+// concurrent is a run of one goroutine per entry of ops, each holding up to hold ids at a time. max is above what
+// the goroutines can hold at once while all of them wait in Reserve, so one of them always gets an id.
+type concurrent struct {
+	max  uint16
+	hold int
+	ops  [][]uint16
+}
+
+func (concurrent) Generate(r *rand.Rand, size int) reflect.Value {
+	var c = concurrent{hold: 1 + r.Intn(4), ops: make([][]uint16, 2+r.Intn(7))}
+
+	c.max = uint16(len(c.ops)*(c.hold-1) + 1 + r.Intn(4))
+
+	for g := range c.ops {
+		c.ops[g] = make([]uint16, r.Intn(20*size+1))
+
+		for i := range c.ops[g] {
+			c.ops[g][i] = uint16(r.Intn(math.MaxUint16))
+		}
+	}
+
+	return reflect.ValueOf(c)
+}
+
+func TestReserveReleaseConcurrentProperty(t *testing.T) {
+	var property = func(c concurrent) bool {
+		defer withMax(c.max)()
+
+		var ids = New()
+		var holders [math.MaxUint16 + 1]atomic.Int32
+		var failed atomic.Bool
+		var wg sync.WaitGroup
+
+		for _, ops := range c.ops {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				var held []uint16
+
+				var release = func(n int) {
+					// -- given up before the Release, another goroutine may get it as soon as it is called
+					holders[held[n]].Store(0)
+					ids.Release(NewPacketID(held[n]).GetBytes())
+					held = append(held[:n], held[n+1:]...)
+					// --
+				}
+
+				for _, op := range ops {
+					if op%2 == 0 && len(held) < c.hold {
+						var id = ids.Reserve().Value
+
+						if id == 0 || id > c.max || !holders[id].CompareAndSwap(0, 1) {
+							t.Logf("max %d: reserved %d, which is out of range or held", c.max, id)
+							failed.Store(true)
+						}
+
+						held = append(held, id)
+					} else if len(held) > 0 {
+						release(int(op) % len(held))
+					}
+				}
+
+				for len(held) > 0 {
+					release(0)
+				}
+			}()
+		}
+
+		var done = make(chan struct{})
+
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("max %d, %d goroutines holding up to %d: deadlocked, %d waiting", c.max, len(c.ops), c.hold, ids.GetWaitListSize())
+		}
+
+		return !failed.Load() && ids.GetWaitListSize() == 0 && accounted(t, ids, nil)
+	}
+
+	if err := quick.Check(property, &quick.Config{MaxCount: 100}); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,39 @@
+package packetids
+
+/*
+Snapshot and Restore let a persistent session keep its packet id state across a process restart,
+Free is the stack of released ids in the order Reserve would hand them out.
+*/
+
+type Snapshot struct {
+	MaxIDReached uint16
+	Free         []uint16
+}
+
+func (p *PacketIDs) Snapshot() Snapshot {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var s = Snapshot{MaxIDReached: p.maxIDReached}
+
+	for n := p.stack; n != nil; n = n.next {
+		s.Free = append(s.Free, n.i)
+	}
+
+	return s
+}
+
+func NewFromSnapshot(s Snapshot) *PacketIDs {
+	var p = New()
+
+	p.maxIDReached = s.MaxIDReached
+
+	// -- Push in reverse so the first id in Free ends up on top of the stack.
+	for i := len(s.Free) - 1; i >= 0; i-- {
+		p.stack = &packetIDNode{i: s.Free[i], next: p.stack}
+		p.stackSize++
+	}
+	// --
+
+	return p
+}
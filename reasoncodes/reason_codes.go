@@ -0,0 +1,171 @@
+package reasoncodes
+
+import "fmt"
+
+/*
+All MQTT 5 reason codes. Codes below 0x80 are successes, everything from 0x80 up is a failure.
+
+A few values mean different things depending on the packet: 0x00 is Success, Normal disconnection on DISCONNECT and
+Granted QoS 0 on SUBACK, 0x01 and 0x02 only exist as granted QoS on SUBACK. String returns the general name, Name
+returns the one for a specific packet type. ValidFor answers whether a code may be sent on a packet type at all.
+
+The package imports nothing of the module, so the codec can use its codes. Packet types are the control packet type of
+the fixed header, byte(mqttcodec.PUBACK) for example.
+*/
+
+type Code byte
+
+const (
+	Success                             Code = 0x00
+	NormalDisconnection                 Code = 0x00
+	GrantedQoS0                         Code = 0x00
+	GrantedQoS1                         Code = 0x01
+	GrantedQoS2                         Code = 0x02
+	DisconnectWithWillMessage           Code = 0x04
+	NoMatchingSubscribers               Code = 0x10
+	NoSubscriptionExisted               Code = 0x11
+	ContinueAuthentication              Code = 0x18
+	ReAuthenticate                      Code = 0x19
+	UnspecifiedError                    Code = 0x80
+	MalformedPacket                     Code = 0x81
+	ProtocolError                       Code = 0x82
+	ImplementationSpecificError         Code = 0x83
+	UnsupportedProtocolVersion          Code = 0x84
+	ClientIdentifierNotValid            Code = 0x85
+	BadUserNameOrPassword               Code = 0x86
+	NotAuthorized                       Code = 0x87
+	ServerUnavailable                   Code = 0x88
+	ServerBusy                          Code = 0x89
+	Banned                              Code = 0x8A
+	ServerShuttingDown                  Code = 0x8B
+	BadAuthenticationMethod             Code = 0x8C
+	KeepAliveTimeout                    Code = 0x8D
+	SessionTakenOver                    Code = 0x8E
+	TopicFilterInvalid                  Code = 0x8F
+	TopicNameInvalid                    Code = 0x90
+	PacketIdentifierInUse               Code = 0x91
+	PacketIdentifierNotFound            Code = 0x92
+	ReceiveMaximumExceeded              Code = 0x93
+	TopicAliasInvalid                   Code = 0x94
+	PacketTooLarge                      Code = 0x95
+	MessageRateTooHigh                  Code = 0x96
+	QuotaExceeded                       Code = 0x97
+	AdministrativeAction                Code = 0x98
+	PayloadFormatInvalid                Code = 0x99
+	RetainNotSupported                  Code = 0x9A
+	QoSNotSupported                     Code = 0x9B
+	UseAnotherServer                    Code = 0x9C
+	ServerMoved                         Code = 0x9D
+	SharedSubscriptionsNotSupported     Code = 0x9E
+	ConnectionRateExceeded              Code = 0x9F
+	MaximumConnectTime                  Code = 0xA0
+	SubscriptionIdentifiersNotSupported Code = 0xA1
+	WildcardSubscriptionsNotSupported   Code = 0xA2
+)
+
+type codeInfo struct {
+	name    string
+	packets []byte
+}
+
+const (
+	connack    byte = 2
+	puback     byte = 4
+	pubrec     byte = 5
+	pubrel     byte = 6
+	pubcomp    byte = 7
+	suback     byte = 9
+	unsuback   byte = 11
+	disconnect byte = 14
+	auth       byte = 15
+)
+
+var codes = map[Code]codeInfo{
+	Success:                             {"Success", []byte{connack, puback, pubrec, pubrel, pubcomp, suback, unsuback, disconnect, auth}},
+	GrantedQoS1:                         {"Granted QoS 1", []byte{suback}},
+	GrantedQoS2:                         {"Granted QoS 2", []byte{suback}},
+	DisconnectWithWillMessage:           {"Disconnect with Will Message", []byte{disconnect}},
+	NoMatchingSubscribers:               {"No matching subscribers", []byte{puback, pubrec}},
+	NoSubscriptionExisted:               {"No subscription existed", []byte{unsuback}},
+	ContinueAuthentication:              {"Continue authentication", []byte{auth}},
+	ReAuthenticate:                      {"Re-authenticate", []byte{auth}},
+	UnspecifiedError:                    {"Unspecified error", []byte{connack, puback, pubrec, suback, unsuback, disconnect}},
+	MalformedPacket:                     {"Malformed Packet", []byte{connack, disconnect}},
+	ProtocolError:                       {"Protocol Error", []byte{connack, disconnect}},
+	ImplementationSpecificError:         {"Implementation specific error", []byte{connack, puback, pubrec, suback, unsuback, disconnect}},
+	UnsupportedProtocolVersion:          {"Unsupported Protocol Version", []byte{connack}},
+	ClientIdentifierNotValid:            {"Client Identifier not valid", []byte{connack}},
+	BadUserNameOrPassword:               {"Bad User Name or Password", []byte{connack}},
+	NotAuthorized:                       {"Not authorized", []byte{connack, puback, pubrec, suback, unsuback, disconnect}},
+	ServerUnavailable:                   {"Server unavailable", []byte{connack}},
+	ServerBusy:                          {"Server busy", []byte{connack, disconnect}},
+	Banned:                              {"Banned", []byte{connack}},
+	ServerShuttingDown:                  {"Server shutting down", []byte{disconnect}},
+	BadAuthenticationMethod:             {"Bad authentication method", []byte{connack, disconnect}},
+	KeepAliveTimeout:                    {"Keep Alive timeout", []byte{disconnect}},
+	SessionTakenOver:                    {"Session taken over", []byte{disconnect}},
+	TopicFilterInvalid:                  {"Topic Filter invalid", []byte{suback, unsuback, disconnect}},
+	TopicNameInvalid:                    {"Topic Name invalid", []byte{connack, puback, pubrec, disconnect}},
+	PacketIdentifierInUse:               {"Packet Identifier in use", []byte{puback, pubrec, suback, unsuback}},
+	PacketIdentifierNotFound:            {"Packet Identifier not found", []byte{pubrel, pubcomp}},
+	ReceiveMaximumExceeded:              {"Receive Maximum exceeded", []byte{disconnect}},
+	TopicAliasInvalid:                   {"Topic Alias invalid", []byte{disconnect}},
+	PacketTooLarge:                      {"Packet too large", []byte{connack, disconnect}},
+	MessageRateTooHigh:                  {"Message rate too high", []byte{disconnect}},
+	QuotaExceeded:                       {"Quota exceeded", []byte{connack, puback, pubrec, suback, disconnect}},
+	AdministrativeAction:                {"Administrative action", []byte{disconnect}},
+	PayloadFormatInvalid:                {"Payload format invalid", []byte{connack, puback, pubrec, disconnect}},
+	RetainNotSupported:                  {"Retain not supported", []byte{connack, disconnect}},
+	QoSNotSupported:                     {"QoS not supported", []byte{connack, disconnect}},
+	UseAnotherServer:                    {"Use another server", []byte{connack, disconnect}},
+	ServerMoved:                         {"Server moved", []byte{connack, disconnect}},
+	SharedSubscriptionsNotSupported:     {"Shared Subscriptions not supported", []byte{suback, disconnect}},
+	ConnectionRateExceeded:              {"Connection rate exceeded", []byte{connack, disconnect}},
+	MaximumConnectTime:                  {"Maximum connect time", []byte{disconnect}},
+	SubscriptionIdentifiersNotSupported: {"Subscription Identifiers not supported", []byte{suback, disconnect}},
+	WildcardSubscriptionsNotSupported:   {"Wildcard Subscriptions not supported", []byte{suback, disconnect}},
+}
+
+func (c Code) String() string {
+	if info, ok := codes[c]; ok {
+		return info.name
+	}
+
+	return fmt.Sprintf("Code(0x%02X)", byte(c))
+}
+
+// Name returns the name the code has on packet type t.
+func (c Code) Name(t byte) string {
+	switch {
+	case c == Success && t == disconnect:
+		return "Normal disconnection"
+	case c == GrantedQoS0 && t == suback:
+		return "Granted QoS 0"
+	}
+
+	return c.String()
+}
+
+func (c Code) Known() bool {
+	_, ok := codes[c]
+	return ok
+}
+
+func (c Code) IsSuccess() bool {
+	return c < 0x80
+}
+
+func (c Code) IsFailure() bool {
+	return c >= 0x80
+}
+
+// ValidFor reports whether the code may be sent on packet type t.
+func (c Code) ValidFor(t byte) bool {
+	for _, packet := range codes[c].packets {
+		if packet == t {
+			return true
+		}
+	}
+
+	return false
+}
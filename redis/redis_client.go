@@ -0,0 +1,293 @@
+package redis
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+A Client speaks the part of the Redis protocol (RESP2) the Redis session and retained stores need, over one connection
+that commands take turns on. Every command is a list of string arguments, its reply comes back as a Go value:
+
+ - a status reply as a string, an integer as an int64, a bulk string as a []byte
+ - an array as a []any of those, an error inside an array as an Error
+ - a nil bulk string or array as nil
+
+An error reply of the command itself is returned as an Error. A connection that failed is dropped and dialed again by
+the next command, with the AUTH and SELECT of the Options.
+*/
+
+var DefaultDialTimeout = 5 * time.Second
+
+var ErrClosed = errors.New("redis: client closed")
+
+// Error is an error reply from the server.
+type Error string
+
+func (e Error) Error() string {
+	return "redis: " + string(e)
+}
+
+type Options struct {
+	// Password is sent with AUTH on every connection, Username too when it is not empty.
+	Username string
+	Password string
+	// DB is selected on every connection.
+	DB int
+	// DialTimeout bounds the dial and the AUTH and SELECT after it, 0 is DefaultDialTimeout.
+	DialTimeout time.Duration
+}
+
+type Client struct {
+	sync.Mutex
+	address string
+	options Options
+	conn    net.Conn
+	reader  *bufio.Reader
+	writer  *bufio.Writer
+	closed  bool
+}
+
+// Dial connects to the server at address.
+func Dial(address string, options Options) (*Client, error) {
+	if options.DialTimeout <= 0 {
+		options.DialTimeout = DefaultDialTimeout
+	}
+
+	var c = &Client{address: address, options: options}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Do sends one command and returns its reply.
+func (c *Client) Do(args ...string) (any, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.do(args)
+}
+
+// Exclusive runs fn with no command of another goroutine in between, for WATCH, MULTI and EXEC.
+func (c *Client) Exclusive(fn func(do func(args ...string) (any, error)) error) error {
+	c.Lock()
+	defer c.Unlock()
+
+	return fn(func(args ...string) (any, error) { return c.do(args) })
+}
+
+func (c *Client) Close() error {
+	c.Lock()
+	defer c.Unlock()
+
+	c.closed = true
+
+	if c.conn == nil {
+		return nil
+	}
+
+	var err = c.conn.Close()
+	c.conn = nil
+
+	return err
+}
+
+// connect dials the server and authenticates, c is locked.
+func (c *Client) connect() error {
+	conn, err := net.DialTimeout("tcp", c.address, c.options.DialTimeout)
+
+	if err != nil {
+		return err
+	}
+
+	c.conn = conn
+	c.reader = bufio.NewReader(conn)
+	c.writer = bufio.NewWriter(conn)
+
+	conn.SetDeadline(time.Now().Add(c.options.DialTimeout))
+
+	if err = c.handshake(); err != nil {
+		conn.Close()
+		c.conn = nil
+		return err
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	return nil
+}
+
+func (c *Client) handshake() error {
+	if c.options.Password != "" {
+		var args = []string{"AUTH", c.options.Password}
+
+		if c.options.Username != "" {
+			args = []string{"AUTH", c.options.Username, c.options.Password}
+		}
+
+		if _, err := c.roundTrip(args); err != nil {
+			return err
+		}
+	}
+
+	if c.options.DB != 0 {
+		if _, err := c.roundTrip([]string{"SELECT", strconv.Itoa(c.options.DB)}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// do runs one command, dialing again if the last connection failed, c is locked.
+func (c *Client) do(args []string) (any, error) {
+	if c.closed {
+		return nil, ErrClosed
+	}
+
+	if c.conn == nil {
+		if err := c.connect(); err != nil {
+			return nil, err
+		}
+	}
+
+	reply, err := c.roundTrip(args)
+
+	if _, ok := err.(Error); err != nil && !ok {
+		c.conn.Close()
+		c.conn = nil
+	}
+
+	return reply, err
+}
+
+func (c *Client) roundTrip(args []string) (any, error) {
+	fmt.Fprintf(c.writer, "*%d\r\n", len(args))
+
+	for _, arg := range args {
+		fmt.Fprintf(c.writer, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+
+	if err := c.writer.Flush(); err != nil {
+		return nil, err
+	}
+
+	reply, err := c.read()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if e, ok := reply.(Error); ok {
+		return nil, e
+	}
+
+	return reply, nil
+}
+
+// read reads one reply, an error reply is returned as a value.
+func (c *Client) read() (any, error) {
+	line, err := c.reader.ReadString('\n')
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+
+	var kind, body = line[0], line[1 : len(line)-2]
+
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return Error(body), nil
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+
+		if err != nil || n < 0 {
+			return nil, err
+		}
+
+		var data = make([]byte, n+2)
+
+		if _, err = io.ReadFull(c.reader, data); err != nil {
+			return nil, err
+		}
+
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(body)
+
+		if err != nil || n < 0 {
+			return nil, err
+		}
+
+		var values = make([]any, n)
+
+		for i := range values {
+			if values[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+
+		return values, nil
+	}
+
+	return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+}
+
+// Scan returns every key that matches pattern, with SCAN so the server is not blocked like with KEYS. A key changed
+// during the scan may be missing or returned twice.
+func (c *Client) Scan(pattern string) ([]string, error) {
+	var keys []string
+	var cursor = "0"
+
+	for {
+		reply, err := c.Do("SCAN", cursor, "MATCH", pattern, "COUNT", "1000")
+
+		if err != nil {
+			return nil, err
+		}
+
+		values, ok := reply.([]any)
+
+		if !ok || len(values) != 2 {
+			return nil, fmt.Errorf("redis: unexpected SCAN reply %v", reply)
+		}
+
+		next, _ := values[0].([]byte)
+		found, _ := values[1].([]any)
+
+		for _, key := range found {
+			if key, ok := key.([]byte); ok {
+				keys = append(keys, string(key))
+			}
+		}
+
+		if cursor = string(next); cursor == "0" || cursor == "" {
+			return keys, nil
+		}
+	}
+}
+
+// Milliseconds formats d for PX and PEXPIRE, rounded up so a TTL below a millisecond still expires.
+func Milliseconds(d time.Duration) string {
+	return strconv.FormatInt(int64((d+time.Millisecond-1)/time.Millisecond), 10)
+}
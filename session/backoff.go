@@ -0,0 +1,69 @@
+package session
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+/*
+A Backoff decides how long to wait before trying again: the InflightStore asks it when a message is retransmitted next,
+the client before every reconnect and every resend of its QoS 1 and 2 flows. The attempt counts from 0, Reset is
+called once trying again succeeded, a Backoff that keeps state of its own starts over there. One Backoff is shared by
+every message of a store and every flow of a client, so NextDelay has to be safe to call from several goroutines at
+once.
+*/
+
+type Backoff interface {
+	// NextDelay is the time to wait before attempt, the first retry is attempt 0.
+	NextDelay(attempt int) time.Duration
+	// Reset is called once trying again succeeded.
+	Reset()
+}
+
+// ExponentialBackoff waits Initial, then Multiplier (0 is 2) times longer for every attempt up to Max (0 is no cap).
+// Jitter between 0 and 1 takes that fraction of each delay off at random, 0.5 waits between half and all of it.
+type ExponentialBackoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+	Jitter     float64
+}
+
+func (b ExponentialBackoff) NextDelay(attempt int) time.Duration {
+	var multiplier = b.Multiplier
+
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+
+	var delay = float64(b.Initial) * math.Pow(multiplier, float64(attempt))
+
+	if b.Max > 0 && delay > float64(b.Max) {
+		delay = float64(b.Max)
+	}
+
+	// -- without a Max the delay would outgrow a Duration, half of the largest one is a century and more
+	if delay > math.MaxInt64/2 {
+		delay = math.MaxInt64 / 2
+	}
+	// --
+
+	if b.Jitter > 0 {
+		var jitter = math.Min(b.Jitter, 1) * delay
+		delay -= jitter * rand.Float64()
+	}
+
+	return time.Duration(delay)
+}
+
+func (b ExponentialBackoff) Reset() {}
+
+// ConstantBackoff waits Delay before every attempt.
+type ConstantBackoff struct {
+	Delay time.Duration
+}
+
+func (b ConstantBackoff) NextDelay(int) time.Duration { return b.Delay }
+
+func (b ConstantBackoff) Reset() {}
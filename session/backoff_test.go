@@ -0,0 +1,52 @@
+package session
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestExponentialBackoff(t *testing.T) {
+	var b = ExponentialBackoff{Initial: time.Second, Max: 10 * time.Second}
+
+	for attempt, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second} {
+		if d := b.NextDelay(attempt); d != want {
+			t.Errorf("attempt %d waits %v, want %v", attempt, d, want)
+		}
+	}
+
+	b.Multiplier = 3
+
+	if d := b.NextDelay(2); d != 9*time.Second {
+		t.Errorf("a multiplier of 3 waits %v before attempt 2", d)
+	}
+}
+
+func TestExponentialBackoffWithoutMax(t *testing.T) {
+	var b = ExponentialBackoff{Initial: time.Second}
+
+	// the delay is capped before it overflows a Duration
+	if d := b.NextDelay(1000); d < math.MaxInt64/4 {
+		t.Fatalf("attempt 1000 waits %v", d)
+	}
+}
+
+func TestExponentialBackoffJitter(t *testing.T) {
+	var b = ExponentialBackoff{Initial: 4 * time.Second, Jitter: 0.5}
+
+	for i := 0; i < 100; i++ {
+		if d := b.NextDelay(0); d < 2*time.Second || d > 4*time.Second {
+			t.Fatalf("a jitter of 0.5 waits %v of 4s", d)
+		}
+	}
+}
+
+func TestConstantBackoff(t *testing.T) {
+	var b = ConstantBackoff{Delay: time.Second}
+
+	for attempt := 0; attempt < 3; attempt++ {
+		if d := b.NextDelay(attempt); d != time.Second {
+			t.Errorf("attempt %d waits %v", attempt, d)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package session
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+)
+
+/*
+EnhancedAuth drives the client side of MQTT 5 enhanced authentication for one connection.
+
+ - ConnectProperties puts the mechanism's name and initial data on the CONNECT.
+ - Every AUTH with Continue authentication (0x18) from the server is answered with the mechanism's next response.
+ - A successful CONNACK, or an AUTH with Success after a re-authentication, completes the exchange.
+ - Reauthenticate starts a new exchange on an established connection with an AUTH Re-authenticate (0x19).
+
+The mechanism itself (SCRAM, OAuth tokens, Kerberos...) is plugged in through SASLMechanism.
+*/
+
+type SASLMechanism interface {
+	// Name is sent as the Authentication Method.
+	Name() string
+	// Start returns the initial Authentication Data, nil sends none.
+	Start() ([]byte, error)
+	// Next returns the response to the server's Authentication Data.
+	Next(challenge []byte) ([]byte, error)
+}
+
+// SASLVerifier is optionally implemented by mechanisms that need to check the server's final data, SCRAM's server
+// signature for example.
+type SASLVerifier interface {
+	Finish(data []byte) error
+}
+
+type AuthState byte
+
+const (
+	AuthIdle AuthState = iota
+	AuthInProgress
+	AuthReauthenticating
+	AuthComplete
+	AuthFailed
+)
+
+type EnhancedAuth struct {
+	sync.Mutex
+	mechanism SASLMechanism
+	state     AuthState
+}
+
+func NewEnhancedAuth(mechanism SASLMechanism) *EnhancedAuth {
+	return &EnhancedAuth{mechanism: mechanism}
+}
+
+func (e *EnhancedAuth) State() AuthState {
+	e.Lock()
+	defer e.Unlock()
+
+	return e.state
+}
+
+// ConnectProperties adds the authentication properties to the CONNECT properties, props may be nil.
+func (e *EnhancedAuth) ConnectProperties(props *mqttcodec.Properties) (*mqttcodec.Properties, error) {
+	e.Lock()
+	defer e.Unlock()
+
+	data, err := e.mechanism.Start()
+
+	if err != nil {
+		e.state = AuthFailed
+		return nil, err
+	}
+
+	if props == nil {
+		props = &mqttcodec.Properties{}
+	}
+
+	props.AuthenticationMethod = e.mechanism.Name()
+	props.AuthenticationData = data
+	e.state = AuthInProgress
+
+	return props, nil
+}
+
+// HandleAuth processes an AUTH from the server and returns the AUTH to answer with, nil when the exchange is complete.
+func (e *EnhancedAuth) HandleAuth(auth *mqttcodec.Auth) (*mqttcodec.Auth, error) {
+	e.Lock()
+	defer e.Unlock()
+
+	if e.state != AuthInProgress && e.state != AuthReauthenticating {
+		return nil, e.fail("received AUTH while no authentication is in progress")
+	}
+
+	var props = auth.Properties
+
+	if props != nil && props.AuthenticationMethod != "" && props.AuthenticationMethod != e.mechanism.Name() {
+		return nil, e.fail("server switched authentication method to %q", props.AuthenticationMethod)
+	}
+
+	var data []byte
+
+	if props != nil {
+		data = props.AuthenticationData
+	}
+
+	switch auth.ReasonCode {
+	case mqttcodec.AuthContinueAuthentication:
+		response, err := e.mechanism.Next(data)
+
+		if err != nil {
+			e.state = AuthFailed
+			return nil, err
+		}
+
+		return &mqttcodec.Auth{
+			ReasonCode: mqttcodec.AuthContinueAuthentication,
+			Properties: &mqttcodec.Properties{AuthenticationMethod: e.mechanism.Name(), AuthenticationData: response},
+		}, nil
+	case mqttcodec.AuthSuccess:
+		if e.state != AuthReauthenticating {
+			return nil, e.fail("received AUTH success before CONNACK")
+		}
+
+		return nil, e.finish(data)
+	}
+
+	return nil, e.fail("received AUTH with reason code 0x%02X", auth.ReasonCode)
+}
+
+// HandleConnack completes or fails the exchange started by the CONNECT.
+func (e *EnhancedAuth) HandleConnack(reasonCode byte, props *mqttcodec.Properties) error {
+	e.Lock()
+	defer e.Unlock()
+
+	if e.state != AuthInProgress {
+		return e.fail("received CONNACK while no authentication is in progress")
+	}
+
+	if reasonCode >= 0x80 {
+		return e.fail("authentication refused with reason code 0x%02X", reasonCode)
+	}
+
+	var data []byte
+
+	if props != nil {
+		data = props.AuthenticationData
+	}
+
+	return e.finish(data)
+}
+
+// Reauthenticate returns the AUTH that starts a re-authentication of an established connection.
+func (e *EnhancedAuth) Reauthenticate() (*mqttcodec.Auth, error) {
+	e.Lock()
+	defer e.Unlock()
+
+	if e.state != AuthComplete {
+		return nil, fmt.Errorf("can only re-authenticate after authentication completed")
+	}
+
+	data, err := e.mechanism.Start()
+
+	if err != nil {
+		return nil, err
+	}
+
+	e.state = AuthReauthenticating
+
+	return &mqttcodec.Auth{
+		ReasonCode: mqttcodec.AuthReAuthenticate,
+		Properties: &mqttcodec.Properties{AuthenticationMethod: e.mechanism.Name(), AuthenticationData: data},
+	}, nil
+}
+
+func (e *EnhancedAuth) finish(data []byte) error {
+	if verifier, ok := e.mechanism.(SASLVerifier); ok {
+		if err := verifier.Finish(data); err != nil {
+			e.state = AuthFailed
+			return err
+		}
+	}
+
+	e.state = AuthComplete
+	return nil
+}
+
+func (e *EnhancedAuth) fail(format string, args ...any) error {
+	e.state = AuthFailed
+	return fmt.Errorf(format, args...)
+}
@@ -0,0 +1,173 @@
+package session
+
+import (
+	"context"
+	"sync"
+
+	"github.com/MarcusOuelletus/demo/broadcast"
+	"github.com/MarcusOuelletus/demo/modules/helpers/bytes"
+	"github.com/MarcusOuelletus/demo/packetids"
+)
+
+/*
+FlowController enforces the peer's Receive Maximum for outbound QoS 1 and QoS 2 PUBLISH packets.
+
+Acquire takes one unit of send quota, reserves a packet id from PacketIDs and registers the acknowledgement listener on
+the ResponseBroadcaster, Release undoes all three once the flow is complete (PUBACK, PUBCOMP or a failed PUBREC). Going
+through the controller is what keeps the number of unacknowledged publishes under the limit, the packet id cap is only
+the protocol's upper bound.
+*/
+
+const DefaultReceiveMaximum uint16 = 65535
+
+type FlowController struct {
+	mu             sync.Mutex
+	cond           *sync.Cond
+	receiveMaximum uint16
+	inFlight       uint16
+	ids            *packetids.PacketIDs
+	broadcaster    *broadcast.ResponseBroadcaster
+}
+
+func NewFlowController(receiveMaximum uint16, ids *packetids.PacketIDs, broadcaster *broadcast.ResponseBroadcaster) *FlowController {
+	if receiveMaximum == 0 {
+		receiveMaximum = DefaultReceiveMaximum
+	}
+
+	f := &FlowController{
+		receiveMaximum: receiveMaximum,
+		ids:            ids,
+		broadcaster:    broadcaster,
+	}
+
+	f.cond = sync.NewCond(&f.mu)
+
+	return f
+}
+
+// Acquire blocks until there is send quota, then returns a reserved packet id whose acknowledgements go to ch.
+func (f *FlowController) Acquire(ch chan uint16) (*packetids.PacketID, error) {
+	return f.AcquireContext(context.Background(), ch)
+}
+
+// AcquireContext is Acquire that gives up with the context's error once ctx is done.
+func (f *FlowController) AcquireContext(ctx context.Context, ch chan uint16) (*packetids.PacketID, error) {
+	var stop = context.AfterFunc(ctx, func() {
+		f.mu.Lock()
+		f.cond.Broadcast()
+		f.mu.Unlock()
+	})
+
+	defer stop()
+
+	f.mu.Lock()
+	for f.inFlight >= f.receiveMaximum {
+		if err := ctx.Err(); err != nil {
+			// -- pass on a Release signal this waiter may have taken
+			f.cond.Signal()
+			f.mu.Unlock()
+			return nil, err
+		}
+		f.cond.Wait()
+	}
+	f.inFlight++
+	f.mu.Unlock()
+
+	return f.reserve(ch)
+}
+
+// TryAcquire is Acquire without blocking, it returns nil when the quota is used up.
+func (f *FlowController) TryAcquire(ch chan uint16) (*packetids.PacketID, error) {
+	f.mu.Lock()
+	if f.inFlight >= f.receiveMaximum {
+		f.mu.Unlock()
+		return nil, nil
+	}
+	f.inFlight++
+	f.mu.Unlock()
+
+	return f.reserve(ch)
+}
+
+// Release gives back the quota, the listener and the packet id of a finished flow.
+func (f *FlowController) Release(id uint16, ch chan uint16) {
+	if f.broadcaster != nil {
+		f.broadcaster.RemoveAndCloseListener(id, ch)
+	}
+
+	if f.ids != nil {
+		f.ids.Release(bytes.Split16BitWord(id))
+	}
+
+	f.restoreQuota()
+}
+
+// Detach gives back the quota and the listener of a flow that is resumed on another connection, the packet id stays
+// taken until that flow releases it.
+func (f *FlowController) Detach(id uint16, ch chan uint16) {
+	if f.broadcaster != nil {
+		f.broadcaster.RemoveAndCloseListener(id, ch)
+	}
+
+	f.restoreQuota()
+}
+
+// SetReceiveMaximum applies the value from CONNACK, flows already in progress keep counting against the new limit.
+func (f *FlowController) SetReceiveMaximum(receiveMaximum uint16) {
+	if receiveMaximum == 0 {
+		receiveMaximum = DefaultReceiveMaximum
+	}
+
+	f.mu.Lock()
+	f.receiveMaximum = receiveMaximum
+	f.cond.Broadcast()
+	f.mu.Unlock()
+}
+
+func (f *FlowController) Quota() uint16 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.inFlight >= f.receiveMaximum {
+		return 0
+	}
+
+	return f.receiveMaximum - f.inFlight
+}
+
+func (f *FlowController) InFlight() uint16 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.inFlight
+}
+
+func (f *FlowController) ReceiveMaximum() uint16 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.receiveMaximum
+}
+
+func (f *FlowController) reserve(ch chan uint16) (*packetids.PacketID, error) {
+	var id = f.ids.Reserve()
+
+	if f.broadcaster != nil {
+		if err := f.broadcaster.AddListener(id.Value, ch); err != nil {
+			f.ids.Release(id.GetBytes())
+			f.restoreQuota()
+			return nil, err
+		}
+	}
+
+	return id, nil
+}
+
+func (f *FlowController) restoreQuota() {
+	f.mu.Lock()
+	if f.inFlight > 0 {
+		f.inFlight--
+	}
+	f.cond.Signal()
+	f.mu.Unlock()
+}
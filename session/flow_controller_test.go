@@ -0,0 +1,136 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/broadcast"
+	"github.com/MarcusOuelletus/demo/packetids"
+)
+
+func newTestFlow(receiveMaximum uint16) (*FlowController, *broadcast.ResponseBroadcaster) {
+	var b = broadcast.NewResponseBroadcaster()
+	return NewFlowController(receiveMaximum, packetids.New(), b), b
+}
+
+func TestFlowControllerQuota(t *testing.T) {
+	var f, b = newTestFlow(2)
+	var ch = make(chan uint16, 1)
+
+	first, err := f.Acquire(ch)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := f.Acquire(make(chan uint16, 1))
+
+	if err != nil || second.Value == first.Value {
+		t.Fatalf("second acquire: %v, packet id %d twice", err, first.Value)
+	}
+
+	if id, err := f.TryAcquire(make(chan uint16, 1)); id != nil || err != nil || f.Quota() != 0 {
+		t.Fatalf("acquired over the Receive Maximum: %v, %v", id, err)
+	}
+
+	// -- the acknowledgement of the flow reaches its listener until Release
+	if !b.Notify(first.Value, 1) || <-ch != 1 {
+		t.Fatal("the listener of the flow did not get the acknowledgement")
+	}
+
+	f.Release(first.Value, ch)
+
+	if b.Notify(first.Value, 1) || f.InFlight() != 1 || f.Quota() != 1 {
+		t.Fatalf("release left the listener or the quota, %d in flight", f.InFlight())
+	}
+	// --
+}
+
+func TestFlowControllerWaits(t *testing.T) {
+	var f, _ = newTestFlow(1)
+	var ch = make(chan uint16, 1)
+
+	id, _ := f.Acquire(ch)
+
+	var acquired = make(chan error, 1)
+
+	go func() {
+		_, err := f.Acquire(make(chan uint16, 1))
+		acquired <- err
+	}()
+
+	select {
+	case err := <-acquired:
+		t.Fatalf("acquired without quota: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	f.Release(id.Value, ch)
+
+	if err := <-acquired; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFlowControllerContext(t *testing.T) {
+	var f, _ = newTestFlow(1)
+
+	f.Acquire(make(chan uint16, 1))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if _, err := f.AcquireContext(ctx, make(chan uint16, 1)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("acquire past the context: %v", err)
+	}
+
+	if f.InFlight() != 1 {
+		t.Fatalf("the canceled acquire holds quota, %d in flight", f.InFlight())
+	}
+}
+
+func TestFlowControllerReceiveMaximum(t *testing.T) {
+	var f, _ = newTestFlow(0)
+
+	if f.ReceiveMaximum() != DefaultReceiveMaximum {
+		t.Fatalf("a Receive Maximum of 0 is %d", f.ReceiveMaximum())
+	}
+
+	f.SetReceiveMaximum(1)
+	f.Acquire(make(chan uint16, 1))
+
+	// -- raising the limit wakes a waiting acquire
+	var acquired = make(chan error, 1)
+
+	go func() {
+		_, err := f.Acquire(make(chan uint16, 1))
+		acquired <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	f.SetReceiveMaximum(2)
+
+	if err := <-acquired; err != nil || f.InFlight() != 2 {
+		t.Fatalf("acquire after raising the limit: %v, %d in flight", err, f.InFlight())
+	}
+	// --
+}
+
+func TestFlowControllerDetach(t *testing.T) {
+	var ids = packetids.New()
+	var f = NewFlowController(1, ids, nil)
+	var ch = make(chan uint16)
+
+	id, _ := f.Acquire(ch)
+	f.Detach(id.Value, ch)
+
+	// -- the quota is back, the packet id stays with the resumed flow
+	next, err := f.TryAcquire(make(chan uint16))
+
+	if err != nil || next == nil || next.Value == id.Value {
+		t.Fatalf("after detach: %v, %v", next, err)
+	}
+	// --
+}
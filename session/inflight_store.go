@@ -0,0 +1,226 @@
+package session
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/clock"
+	"github.com/MarcusOuelletus/demo/modules/helpers/bytes"
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/packetids"
+)
+
+/*
+The inflight store keeps every outbound QoS 1 and QoS 2 PUBLISH until it has been acknowledged.
+
+ - A message's packet id stays reserved in PacketIDs until Complete is called, so an id can never be reused while the
+   peer could still acknowledge the old message.
+ - Due returns the messages whose retransmission timer has elapsed, the Backoff decides when the next attempt is due,
+   by default the delay doubles from 5 seconds on every attempt up to 2 minutes.
+ - Drain returns everything with DUP set so it can be resent after a reconnect.
+
+Due, Drain and Messages return the messages in the order they were added, which is the order a reconnect has to resend
+them in. Packet ids don't keep that order, released ids are handed out again.
+
+The Backoff and the clock are passed to NewInflightStore, nil is DefaultRetransmitBackoff and clock.Real, so tests can
+drive the retransmission timers with a Fake.
+*/
+
+var DefaultRetransmitBackoff Backoff = ExponentialBackoff{Initial: 5 * time.Second, Max: 2 * time.Minute}
+
+type InflightMessage struct {
+	PacketID uint16
+	Topic    string
+	Payload  []byte
+	QoS      byte
+	Retain   bool
+	Dup      bool
+	// Properties are the MQTT 5 properties of a message the broker keeps for a session, nil otherwise.
+	Properties *mqttcodec.Properties `json:",omitempty"`
+
+	// Pubrel is set once a QoS 2 message has been acknowledged with PUBREC, from then on the PUBREL is what gets resent.
+	Pubrel bool
+
+	Attempts  int
+	SentAt    time.Time
+	NextRetry time.Time
+
+	// sequence is the order of Add.
+	sequence uint64
+}
+
+type InflightStore struct {
+	sync.Mutex
+	ids           *packetids.PacketIDs
+	messages      map[uint16]*InflightMessage
+	backoff       Backoff
+	clock         clock.Clock
+	sequence      uint64
+	retransmitted int64
+}
+
+func NewInflightStore(ids *packetids.PacketIDs, backoff Backoff, clk clock.Clock) *InflightStore {
+	if backoff == nil {
+		backoff = DefaultRetransmitBackoff
+	}
+
+	return &InflightStore{
+		ids:      ids,
+		messages: make(map[uint16]*InflightMessage),
+		backoff:  backoff,
+		clock:    clock.Or(clk),
+	}
+}
+
+// Add stores a message that has just been written for the first time, its PacketID must already be reserved.
+func (s *InflightStore) Add(msg *InflightMessage) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.messages[msg.PacketID]; ok {
+		return fmt.Errorf("packet id %d is already in flight", msg.PacketID)
+	}
+
+	var now = s.clock.Now()
+
+	s.sequence++
+	msg.sequence = s.sequence
+	msg.Attempts = 1
+	msg.SentAt = now
+	msg.NextRetry = now.Add(s.backoff.NextDelay(0))
+	s.messages[msg.PacketID] = msg
+
+	return nil
+}
+
+func (s *InflightStore) Get(id uint16) (*InflightMessage, bool) {
+	s.Lock()
+	msg, ok := s.messages[id]
+	s.Unlock()
+	return msg, ok
+}
+
+// MarkReleased records that PUBREC arrived for a QoS 2 message, the payload is no longer needed.
+func (s *InflightStore) MarkReleased(id uint16) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	msg, ok := s.messages[id]
+
+	if !ok {
+		return false
+	}
+
+	msg.Pubrel = true
+	msg.Payload = nil
+	msg.Attempts = 1
+	msg.NextRetry = s.clock.Now().Add(s.backoff.NextDelay(0))
+
+	return true
+}
+
+// Complete removes the message and releases its packet id back to PacketIDs, the Backoff is Reset when the message
+// had been retransmitted.
+func (s *InflightStore) Complete(id uint16) bool {
+	s.Lock()
+	msg, ok := s.messages[id]
+	delete(s.messages, id)
+	s.Unlock()
+
+	if ok && msg.Attempts > 1 {
+		s.backoff.Reset()
+	}
+
+	if ok && s.ids != nil {
+		s.ids.Release(bytes.Split16BitWord(id))
+	}
+
+	return ok
+}
+
+// Due returns the messages that need to be retransmitted now, marked as duplicates and rescheduled.
+func (s *InflightStore) Due() []*InflightMessage {
+	var due []*InflightMessage
+
+	s.Lock()
+	defer s.Unlock()
+
+	var now = s.clock.Now()
+
+	for _, msg := range s.messages {
+		if msg.NextRetry.After(now) {
+			continue
+		}
+
+		msg.Dup = true
+		msg.NextRetry = now.Add(s.backoff.NextDelay(msg.Attempts))
+		msg.Attempts++
+		s.retransmitted++
+		due = append(due, msg)
+	}
+
+	sortInflight(due)
+
+	return due
+}
+
+// Drain returns every message for resending after a reconnect. The messages stay in the store and their
+// retransmission timers start over.
+func (s *InflightStore) Drain() []*InflightMessage {
+	s.Lock()
+	defer s.Unlock()
+
+	var now = s.clock.Now()
+	var all = make([]*InflightMessage, 0, len(s.messages))
+
+	for _, msg := range s.messages {
+		msg.Dup = true
+		msg.Attempts = 1
+		msg.NextRetry = now.Add(s.backoff.NextDelay(0))
+		s.retransmitted++
+		all = append(all, msg)
+	}
+
+	sortInflight(all)
+
+	return all
+}
+
+// Messages returns a copy of every message, for saving the session, without touching the timers.
+func (s *InflightStore) Messages() []*InflightMessage {
+	s.Lock()
+	defer s.Unlock()
+
+	var all = make([]*InflightMessage, 0, len(s.messages))
+
+	for _, msg := range s.messages {
+		var m = *msg
+		all = append(all, &m)
+	}
+
+	sortInflight(all)
+
+	return all
+}
+
+func (s *InflightStore) Len() int {
+	s.Lock()
+	defer s.Unlock()
+
+	return len(s.messages)
+}
+
+func (s *InflightStore) Retransmissions() int64 {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.retransmitted
+}
+
+func sortInflight(messages []*InflightMessage) {
+	sort.Slice(messages, func(a, b int) bool {
+		return messages[a].sequence < messages[b].sequence
+	})
+}
@@ -0,0 +1,124 @@
+package session
+
+import (
+	"testing"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/clock"
+	"github.com/MarcusOuelletus/demo/packetids"
+)
+
+func newTestInflight() (*InflightStore, *packetids.PacketIDs, *clock.Fake) {
+	var clk = clock.NewFake(time.Unix(1000, 0))
+	var ids = packetids.New()
+	var s = NewInflightStore(ids, ExponentialBackoff{Initial: time.Second, Max: 4 * time.Second}, clk)
+
+	return s, ids, clk
+}
+
+func TestInflightRetransmissionBackoff(t *testing.T) {
+	s, ids, clk := newTestInflight()
+	var id = ids.Reserve().Value
+
+	if err := s.Add(&InflightMessage{PacketID: id, Topic: "a", QoS: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	if due := s.Due(); len(due) != 0 {
+		t.Fatalf("due before the initial backoff: %d messages", len(due))
+	}
+
+	// -- every attempt doubles the delay until Max caps it
+	var steps = []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second, 4 * time.Second}
+
+	for i, step := range steps {
+		clk.Advance(step - time.Millisecond)
+
+		if due := s.Due(); len(due) != 0 {
+			t.Fatalf("attempt %d: due a millisecond early", i+2)
+		}
+
+		clk.Advance(time.Millisecond)
+		due := s.Due()
+
+		if len(due) != 1 || !due[0].Dup || due[0].Attempts != i+2 {
+			t.Fatalf("attempt %d: got %+v", i+2, due)
+		}
+	}
+	// --
+
+	if n := s.Retransmissions(); n != int64(len(steps)) {
+		t.Fatalf("retransmissions = %d, want %d", n, len(steps))
+	}
+}
+
+func TestInflightReleasedRestartsTimer(t *testing.T) {
+	s, ids, clk := newTestInflight()
+	var id = ids.Reserve().Value
+
+	s.Add(&InflightMessage{PacketID: id, Topic: "a", Payload: []byte("x"), QoS: 2})
+	clk.Advance(time.Second)
+	s.Due()
+	clk.Advance(500 * time.Millisecond)
+
+	if !s.MarkReleased(id) {
+		t.Fatal("MarkReleased of an inflight message failed")
+	}
+
+	msg, _ := s.Get(id)
+
+	if !msg.Pubrel || msg.Payload != nil || msg.Attempts != 1 {
+		t.Fatalf("after PUBREC: %+v", msg)
+	}
+
+	clk.Advance(999 * time.Millisecond)
+
+	if due := s.Due(); len(due) != 0 {
+		t.Fatal("PUBREL due before the initial backoff")
+	}
+
+	clk.Advance(time.Millisecond)
+
+	if due := s.Due(); len(due) != 1 || !due[0].Pubrel {
+		t.Fatalf("PUBREL not due: %+v", due)
+	}
+}
+
+func TestInflightDrainAndComplete(t *testing.T) {
+	s, ids, clk := newTestInflight()
+	var first, second = ids.Reserve().Value, ids.Reserve().Value
+
+	s.Add(&InflightMessage{PacketID: second, Topic: "b", QoS: 1})
+	s.Add(&InflightMessage{PacketID: first, Topic: "a", QoS: 1})
+
+	if err := s.Add(&InflightMessage{PacketID: first, Topic: "a", QoS: 1}); err == nil {
+		t.Fatal("a packet id added twice")
+	}
+
+	clk.Advance(3 * time.Second)
+	var all = s.Drain()
+
+	// -- in the order of Add, not of the packet ids
+	if len(all) != 2 || all[0].PacketID != second || all[1].PacketID != first || !all[0].Dup {
+		t.Fatalf("drain: %+v", all)
+	}
+	// --
+
+	// -- drained messages start over, nothing is due until the initial backoff elapsed again
+	if due := s.Due(); len(due) != 0 {
+		t.Fatalf("due right after Drain: %d messages", len(due))
+	}
+	// --
+
+	if !s.Complete(first) || s.Complete(first) {
+		t.Fatal("Complete has to succeed exactly once")
+	}
+
+	if s.Len() != 1 {
+		t.Fatalf("len = %d after Complete", s.Len())
+	}
+
+	if ids.GetStackSize() != 1 {
+		t.Fatalf("released packet id not back in PacketIDs, stack size %d", ids.GetStackSize())
+	}
+}
@@ -0,0 +1,163 @@
+package session
+
+import (
+	"sync"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/clock"
+)
+
+/*
+Keepalive decides when a PINGREQ has to be sent and when the connection has to be considered dead.
+
+ - A PINGREQ is due once nothing has been written for the keepalive interval.
+ - The connection is dead once nothing has been read for 1.5x the interval, or a PINGREQ went unanswered for PingTimeout.
+ - Sent has to be called for every outbound packet and Received for every inbound one.
+
+Check is what makes the decisions, Start just calls it on a ticker of the Keepalive's clock, so with a clock.Fake a test
+moves past the interval without waiting for it.
+*/
+
+type KeepaliveAction byte
+
+const (
+	KeepaliveNone KeepaliveAction = iota
+	KeepaliveSendPing
+	KeepaliveDead
+)
+
+type Keepalive struct {
+	sync.Mutex
+	Interval     time.Duration
+	PingTimeout  time.Duration
+	SendPing     func()
+	OnDead       func()
+	clock        clock.Clock
+	lastWrite    time.Time
+	lastRead     time.Time
+	pingSentAt   time.Time
+	awaitingPong bool
+	dead         bool
+	stop         chan struct{}
+}
+
+// NewKeepalive measures the interval on clk, nil is clock.Real.
+func NewKeepalive(interval time.Duration, clk clock.Clock) *Keepalive {
+	clk = clock.Or(clk)
+
+	var t = clk.Now()
+
+	return &Keepalive{
+		Interval:    interval,
+		PingTimeout: interval / 2,
+		clock:       clk,
+		lastWrite:   t,
+		lastRead:    t,
+	}
+}
+
+func (k *Keepalive) Sent() {
+	k.Lock()
+	k.lastWrite = k.clock.Now()
+	k.Unlock()
+}
+
+func (k *Keepalive) Received() {
+	k.Lock()
+	k.lastRead = k.clock.Now()
+	k.Unlock()
+}
+
+func (k *Keepalive) PingResponse() {
+	k.Lock()
+	k.lastRead = k.clock.Now()
+	k.awaitingPong = false
+	k.Unlock()
+}
+
+// Check returns what has to happen now and runs the SendPing or OnDead hook for it, OnDead only ever runs once.
+func (k *Keepalive) Check() KeepaliveAction {
+	var action = k.check()
+
+	switch action {
+	case KeepaliveSendPing:
+		if k.SendPing != nil {
+			k.SendPing()
+		}
+	case KeepaliveDead:
+		if k.OnDead != nil {
+			k.OnDead()
+		}
+	}
+
+	return action
+}
+
+func (k *Keepalive) check() KeepaliveAction {
+	k.Lock()
+	defer k.Unlock()
+
+	if k.Interval <= 0 || k.dead {
+		return KeepaliveNone
+	}
+
+	var now = k.clock.Now()
+
+	if now.Sub(k.lastRead) >= k.Interval*3/2 || (k.awaitingPong && now.Sub(k.pingSentAt) >= k.PingTimeout) {
+		k.dead = true
+		return KeepaliveDead
+	}
+
+	if !k.awaitingPong && now.Sub(k.lastWrite) >= k.Interval {
+		k.awaitingPong = true
+		k.pingSentAt = now
+		k.lastWrite = now
+		return KeepaliveSendPing
+	}
+
+	return KeepaliveNone
+}
+
+func (k *Keepalive) Dead() bool {
+	k.Lock()
+	defer k.Unlock()
+
+	return k.dead
+}
+
+// Start checks on a ticker until Stop is called or the connection is found dead.
+func (k *Keepalive) Start(tick time.Duration) {
+	k.Lock()
+	if k.stop != nil {
+		k.Unlock()
+		return
+	}
+	k.stop = make(chan struct{})
+	var stop = k.stop
+	k.Unlock()
+
+	go func() {
+		var ticker = k.clock.NewTicker(tick)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C():
+				if k.Check() == KeepaliveDead {
+					return
+				}
+			}
+		}
+	}()
+}
+
+func (k *Keepalive) Stop() {
+	k.Lock()
+	if k.stop != nil {
+		close(k.stop)
+		k.stop = nil
+	}
+	k.Unlock()
+}
@@ -0,0 +1,126 @@
+package session
+
+import (
+	"testing"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/clock"
+)
+
+func TestKeepalivePing(t *testing.T) {
+	var clk = clock.NewFake(time.Unix(0, 0))
+	var k = NewKeepalive(time.Minute, clk)
+	var pings int
+
+	k.SendPing = func() { pings++ }
+
+	// -- writing keeps the ping away, reading alone does not
+	clk.Advance(50 * time.Second)
+	k.Sent()
+	clk.Advance(50 * time.Second)
+	k.Received()
+
+	if action := k.Check(); action != KeepaliveNone {
+		t.Fatalf("%v after writing 50s ago", action)
+	}
+
+	clk.Advance(10 * time.Second)
+
+	if action := k.Check(); action != KeepaliveSendPing || pings != 1 {
+		t.Fatalf("%v after a minute without writing, %d pings", action, pings)
+	}
+	// --
+
+	// -- one ping at a time, the PINGRESP ends the wait for it
+	if action := k.Check(); action != KeepaliveNone {
+		t.Fatalf("%v while the ping is answered", action)
+	}
+
+	clk.Advance(time.Second)
+	k.PingResponse()
+	clk.Advance(time.Minute)
+
+	if action := k.Check(); action != KeepaliveSendPing || pings != 2 {
+		t.Fatalf("%v a minute after the ping, %d pings", action, pings)
+	}
+	// --
+}
+
+func TestKeepaliveUnansweredPing(t *testing.T) {
+	var clk = clock.NewFake(time.Unix(0, 0))
+	var k = NewKeepalive(time.Minute, clk)
+	var dead int
+
+	k.OnDead = func() { dead++ }
+
+	clk.Advance(time.Minute)
+	k.Check()
+	clk.Advance(29 * time.Second)
+
+	if action := k.Check(); action != KeepaliveNone {
+		t.Fatalf("%v before PingTimeout", action)
+	}
+
+	clk.Advance(time.Second)
+
+	if action := k.Check(); action != KeepaliveDead || !k.Dead() {
+		t.Fatalf("%v after PingTimeout without a PINGRESP", action)
+	}
+
+	if action := k.Check(); action != KeepaliveNone || dead != 1 {
+		t.Fatalf("%v on a dead connection, OnDead ran %d times", action, dead)
+	}
+}
+
+func TestKeepaliveNothingRead(t *testing.T) {
+	var clk = clock.NewFake(time.Unix(0, 0))
+	var k = NewKeepalive(time.Minute, clk)
+
+	// -- writing all the time does not help when nothing comes back for 1.5 intervals
+	k.PingTimeout = time.Hour
+
+	for i := 0; i < 3; i++ {
+		clk.Advance(30 * time.Second)
+		k.Sent()
+	}
+
+	if action := k.Check(); action != KeepaliveDead {
+		t.Fatalf("%v after 90s without reading", action)
+	}
+	// --
+}
+
+func TestKeepaliveStart(t *testing.T) {
+	var clk = clock.NewFake(time.Unix(0, 0))
+	var k = NewKeepalive(time.Minute, clk)
+	var dead = make(chan struct{})
+
+	k.OnDead = func() { close(dead) }
+	k.Start(time.Second)
+	defer k.Stop()
+
+	// -- the ticker runs on clk, nothing happens until the time is moved on
+	clk.BlockUntil(1)
+
+	for i := 0; i < 90; i++ {
+		clk.Advance(time.Second)
+	}
+
+	select {
+	case <-dead:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the ticker did not find the connection dead")
+	}
+	// --
+}
+
+func TestKeepaliveDisabled(t *testing.T) {
+	var clk = clock.NewFake(time.Unix(0, 0))
+	var k = NewKeepalive(0, clk)
+
+	clk.Advance(time.Hour)
+
+	if action := k.Check(); action != KeepaliveNone {
+		t.Fatalf("%v with a keepalive of 0", action)
+	}
+}
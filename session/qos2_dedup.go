@@ -0,0 +1,115 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+/*
+QoS2Dedup is the receive side of exactly-once delivery. It keeps the inbound QoS 2 packet ids that are between PUBREC
+and PUBREL, and saves them to the SessionStore on every change, so a PUBLISH that is redelivered after a reconnect or
+a restart is acknowledged again without being handed to the application twice.
+
+The id is saved before Publish reports the message as deliverable, a crash between the two loses that message rather
+than delivering it twice.
+*/
+
+// ErrPacketIDNotFound is returned by Pubrel for a packet id that is not waiting for PUBREL, the PUBCOMP answering it
+// carries Packet Identifier not found on MQTT 5.
+var ErrPacketIDNotFound = errors.New("packet identifier not found")
+
+type QoS2Dedup struct {
+	sync.Mutex
+	clientID string
+	store    SessionStore
+	flow     *InboundQoS2Flow
+}
+
+func NewQoS2Dedup(clientID string, store SessionStore) (*QoS2Dedup, error) {
+	d := &QoS2Dedup{
+		clientID: clientID,
+		store:    store,
+		flow:     NewInboundQoS2Flow(),
+	}
+
+	if store == nil {
+		return d, nil
+	}
+
+	state, err := store.Load(clientID)
+
+	if errors.Is(err, ErrSessionNotFound) {
+		return d, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	d.flow.Restore(state.PendingQoS2)
+
+	return d, nil
+}
+
+// Publish returns whether the message with packet id should be delivered, PUBREC has to be sent either way.
+func (d *QoS2Dedup) Publish(id uint16) (bool, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	deliver, _ := d.flow.HandlePublish(id)
+
+	if !deliver {
+		return false, nil
+	}
+
+	return true, d.persist()
+}
+
+// Pubrel forgets the packet id, PUBCOMP has to be sent either way.
+func (d *QoS2Dedup) Pubrel(id uint16) error {
+	d.Lock()
+	defer d.Unlock()
+
+	if _, err := d.flow.HandlePubrel(id); err != nil {
+		return fmt.Errorf("%w: %v", ErrPacketIDNotFound, err)
+	}
+
+	return d.persist()
+}
+
+// Reset forgets every packet id, the other side started a new session and will not send PUBREL for any of them.
+func (d *QoS2Dedup) Reset() error {
+	d.Lock()
+	defer d.Unlock()
+
+	var pending = d.flow.Pending()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	for _, id := range pending {
+		d.flow.HandlePubrel(id)
+	}
+
+	return d.persist()
+}
+
+func (d *QoS2Dedup) Pending() []uint16 {
+	return d.flow.Pending()
+}
+
+func (d *QoS2Dedup) Flow() *InboundQoS2Flow {
+	return d.flow
+}
+
+func (d *QoS2Dedup) persist() error {
+	if d.store == nil {
+		return nil
+	}
+
+	return d.store.Update(d.clientID, func(state *SessionState) {
+		state.PendingQoS2 = d.flow.Pending()
+	})
+}
@@ -0,0 +1,73 @@
+package session
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestQoS2DedupSurvivesARestart(t *testing.T) {
+	var store = NewMemoryStore()
+
+	d, err := NewQoS2Dedup("a", store)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if deliver, err := d.Publish(7); !deliver || err != nil {
+		t.Fatalf("first PUBLISH: deliver %v, %v", deliver, err)
+	}
+
+	// -- a new dedup on the same store knows the packet id is waiting for PUBREL
+	d, err = NewQoS2Dedup("a", store)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if deliver, err := d.Publish(7); deliver || err != nil {
+		t.Fatalf("redelivered PUBLISH after a restart: deliver %v, %v", deliver, err)
+	}
+	// --
+
+	if err := d.Pubrel(7); err != nil {
+		t.Fatal(err)
+	}
+
+	if state, _ := store.Load("a"); len(state.PendingQoS2) != 0 {
+		t.Fatalf("%v still pending after PUBREL", state.PendingQoS2)
+	}
+}
+
+func TestQoS2DedupUnknownPubrel(t *testing.T) {
+	d, _ := NewQoS2Dedup("a", nil)
+
+	if err := d.Pubrel(1); !errors.Is(err, ErrPacketIDNotFound) {
+		t.Fatalf("PUBREL for an unknown packet id: %v", err)
+	}
+}
+
+func TestQoS2DedupReset(t *testing.T) {
+	var store = NewMemoryStore()
+
+	d, _ := NewQoS2Dedup("a", store)
+	d.Publish(3)
+	d.Publish(1)
+
+	if pending := d.Pending(); !reflect.DeepEqual(pending, []uint16{1, 3}) {
+		t.Fatalf("pending %v", pending)
+	}
+
+	if err := d.Reset(); err != nil || len(d.Pending()) != 0 {
+		t.Fatalf("reset: %v, %v pending", err, d.Pending())
+	}
+
+	if state, _ := store.Load("a"); len(state.PendingQoS2) != 0 {
+		t.Fatalf("reset left %v in the store", state.PendingQoS2)
+	}
+
+	if deliver, _ := d.Publish(3); !deliver {
+		t.Fatal("a packet id of the old session was not delivered after reset")
+	}
+}
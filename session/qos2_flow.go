@@ -0,0 +1,235 @@
+package session
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+/*
+These track the exactly-once handshake for QoS 2 messages per packet id.
+
+Outbound (we are the sender):  PUBLISH -> (PUBREC) -> PUBREL -> (PUBCOMP)
+Inbound  (we are the receiver): (PUBLISH) -> PUBREC -> (PUBREL) -> PUBCOMP
+
+Each handler validates the received control packet against the current state of that packet id
+and returns the packet that should be sent next, the caller is responsible for the wire format.
+*/
+
+type QoS2Packet byte
+
+const (
+	QoS2None QoS2Packet = iota
+	QoS2Publish
+	QoS2Pubrec
+	QoS2Pubrel
+	QoS2Pubcomp
+)
+
+func (p QoS2Packet) String() string {
+	switch p {
+	case QoS2Publish:
+		return "PUBLISH"
+	case QoS2Pubrec:
+		return "PUBREC"
+	case QoS2Pubrel:
+		return "PUBREL"
+	case QoS2Pubcomp:
+		return "PUBCOMP"
+	}
+
+	return "NONE"
+}
+
+type QoS2State byte
+
+const (
+	QoS2Idle QoS2State = iota
+	QoS2AwaitingPubrec
+	QoS2AwaitingPubcomp
+	QoS2AwaitingPubrel
+)
+
+func (s QoS2State) String() string {
+	switch s {
+	case QoS2AwaitingPubrec:
+		return "awaiting PUBREC"
+	case QoS2AwaitingPubcomp:
+		return "awaiting PUBCOMP"
+	case QoS2AwaitingPubrel:
+		return "awaiting PUBREL"
+	}
+
+	return "idle"
+}
+
+type OutboundQoS2Flow struct {
+	sync.Mutex
+	states map[uint16]QoS2State
+}
+
+type InboundQoS2Flow struct {
+	sync.Mutex
+	states map[uint16]QoS2State
+}
+
+func NewOutboundQoS2Flow() *OutboundQoS2Flow {
+	return &OutboundQoS2Flow{states: make(map[uint16]QoS2State)}
+}
+
+func NewInboundQoS2Flow() *InboundQoS2Flow {
+	return &InboundQoS2Flow{states: make(map[uint16]QoS2State)}
+}
+
+// Start registers a PUBLISH that is about to be sent for the first time.
+func (o *OutboundQoS2Flow) Start(id uint16) error {
+	o.Lock()
+	defer o.Unlock()
+
+	if state, ok := o.states[id]; ok {
+		return fmt.Errorf("packet id %d already has a qos 2 flow %s", id, state)
+	}
+
+	o.states[id] = QoS2AwaitingPubrec
+	return nil
+}
+
+// HandlePubrec moves the flow on to PUBREL. A duplicate PUBREC, which happens when our PUBREL was lost,
+// is answered with the PUBREL again.
+func (o *OutboundQoS2Flow) HandlePubrec(id uint16) (QoS2Packet, error) {
+	o.Lock()
+	defer o.Unlock()
+
+	switch o.states[id] {
+	case QoS2AwaitingPubrec, QoS2AwaitingPubcomp:
+		o.states[id] = QoS2AwaitingPubcomp
+		return QoS2Pubrel, nil
+	}
+
+	return QoS2None, fmt.Errorf("received PUBREC for packet id %d with no qos 2 flow", id)
+}
+
+// Abort drops the flow without completing it, used when the PUBREC carries a failure reason code.
+func (o *OutboundQoS2Flow) Abort(id uint16) bool {
+	o.Lock()
+	defer o.Unlock()
+
+	if _, ok := o.states[id]; !ok {
+		return false
+	}
+
+	delete(o.states, id)
+	return true
+}
+
+// HandlePubcomp completes the flow, the packet id can be released once this returns nil.
+func (o *OutboundQoS2Flow) HandlePubcomp(id uint16) error {
+	o.Lock()
+	defer o.Unlock()
+
+	var state = o.states[id]
+
+	if state != QoS2AwaitingPubcomp {
+		return fmt.Errorf("received PUBCOMP for packet id %d while %s", id, state)
+	}
+
+	delete(o.states, id)
+	return nil
+}
+
+// Next returns the packet that has to be retransmitted for the packet id, PUBLISH (with DUP) before
+// PUBREC has arrived and PUBREL after.
+func (o *OutboundQoS2Flow) Next(id uint16) QoS2Packet {
+	o.Lock()
+	defer o.Unlock()
+
+	switch o.states[id] {
+	case QoS2AwaitingPubrec:
+		return QoS2Publish
+	case QoS2AwaitingPubcomp:
+		return QoS2Pubrel
+	}
+
+	return QoS2None
+}
+
+func (o *OutboundQoS2Flow) State(id uint16) QoS2State {
+	o.Lock()
+	defer o.Unlock()
+
+	return o.states[id]
+}
+
+func (o *OutboundQoS2Flow) Len() int {
+	o.Lock()
+	defer o.Unlock()
+
+	return len(o.states)
+}
+
+// HandlePublish returns whether the message should be handed to the application. A PUBLISH for a packet id
+// that is still awaiting PUBREL is a redelivery and is only acknowledged again.
+func (i *InboundQoS2Flow) HandlePublish(id uint16) (bool, QoS2Packet) {
+	i.Lock()
+	defer i.Unlock()
+
+	if i.states[id] == QoS2AwaitingPubrel {
+		return false, QoS2Pubrec
+	}
+
+	i.states[id] = QoS2AwaitingPubrel
+	return true, QoS2Pubrec
+}
+
+// HandlePubrel always answers with PUBCOMP, the error reports a PUBREL for an unknown packet id so the caller
+// can set the Packet Identifier not found reason code.
+func (i *InboundQoS2Flow) HandlePubrel(id uint16) (QoS2Packet, error) {
+	i.Lock()
+	defer i.Unlock()
+
+	if i.states[id] != QoS2AwaitingPubrel {
+		return QoS2Pubcomp, fmt.Errorf("received PUBREL for packet id %d with no qos 2 flow", id)
+	}
+
+	delete(i.states, id)
+	return QoS2Pubcomp, nil
+}
+
+func (i *InboundQoS2Flow) State(id uint16) QoS2State {
+	i.Lock()
+	defer i.Unlock()
+
+	return i.states[id]
+}
+
+func (i *InboundQoS2Flow) Len() int {
+	i.Lock()
+	defer i.Unlock()
+
+	return len(i.states)
+}
+
+// Pending returns the packet ids that have been acknowledged with PUBREC and are waiting for PUBREL.
+func (i *InboundQoS2Flow) Pending() []uint16 {
+	i.Lock()
+	defer i.Unlock()
+
+	var ids = make([]uint16, 0, len(i.states))
+
+	for id := range i.states {
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(a, b int) bool { return ids[a] < ids[b] })
+
+	return ids
+}
+
+func (i *InboundQoS2Flow) Restore(ids []uint16) {
+	i.Lock()
+	defer i.Unlock()
+
+	for _, id := range ids {
+		i.states[id] = QoS2AwaitingPubrel
+	}
+}
@@ -0,0 +1,103 @@
+package session
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOutboundQoS2Flow(t *testing.T) {
+	var o = NewOutboundQoS2Flow()
+
+	if err := o.Start(1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := o.Start(1); err == nil {
+		t.Fatal("a second flow on the same packet id")
+	}
+
+	if next := o.Next(1); next != QoS2Publish {
+		t.Fatalf("before PUBREC %s is resent", next)
+	}
+
+	if err := o.HandlePubcomp(1); err == nil {
+		t.Fatal("PUBCOMP before PUBREC completed the flow")
+	}
+
+	// -- a duplicate PUBREC, our PUBREL was lost, is answered with the PUBREL again
+	for i := 0; i < 2; i++ {
+		if next, err := o.HandlePubrec(1); err != nil || next != QoS2Pubrel {
+			t.Fatalf("PUBREC %d: %s, %v", i, next, err)
+		}
+	}
+	// --
+
+	if next := o.Next(1); next != QoS2Pubrel || o.State(1) != QoS2AwaitingPubcomp {
+		t.Fatalf("after PUBREC %s is resent while %s", next, o.State(1))
+	}
+
+	if err := o.HandlePubcomp(1); err != nil || o.Len() != 0 || o.Next(1) != QoS2None {
+		t.Fatalf("PUBCOMP: %v, %d flows left", err, o.Len())
+	}
+
+	if _, err := o.HandlePubrec(2); err == nil {
+		t.Fatal("PUBREC for a packet id without a flow")
+	}
+}
+
+func TestOutboundQoS2FlowAbort(t *testing.T) {
+	var o = NewOutboundQoS2Flow()
+
+	o.Start(1)
+
+	if !o.Abort(1) || o.Abort(1) {
+		t.Fatal("abort does not match the flows")
+	}
+
+	if err := o.Start(1); err != nil {
+		t.Fatalf("the packet id is still taken after abort: %v", err)
+	}
+}
+
+func TestInboundQoS2Flow(t *testing.T) {
+	var i = NewInboundQoS2Flow()
+
+	if deliver, next := i.HandlePublish(1); !deliver || next != QoS2Pubrec {
+		t.Fatalf("first PUBLISH: deliver %v, %s", deliver, next)
+	}
+
+	// -- a redelivery before PUBREL is acknowledged again, not delivered again
+	if deliver, next := i.HandlePublish(1); deliver || next != QoS2Pubrec {
+		t.Fatalf("redelivered PUBLISH: deliver %v, %s", deliver, next)
+	}
+	// --
+
+	if next, err := i.HandlePubrel(1); err != nil || next != QoS2Pubcomp || i.Len() != 0 {
+		t.Fatalf("PUBREL: %s, %v", next, err)
+	}
+
+	// -- a PUBREL resent after a lost PUBCOMP still gets a PUBCOMP
+	if next, err := i.HandlePubrel(1); err == nil || next != QoS2Pubcomp {
+		t.Fatalf("unknown PUBREL: %s, %v", next, err)
+	}
+	// --
+
+	if deliver, _ := i.HandlePublish(1); !deliver {
+		t.Fatal("a new message on a completed packet id was not delivered")
+	}
+}
+
+func TestInboundQoS2FlowRestore(t *testing.T) {
+	var i = NewInboundQoS2Flow()
+
+	i.Restore([]uint16{9, 3})
+	i.HandlePublish(5)
+
+	if pending := i.Pending(); !reflect.DeepEqual(pending, []uint16{3, 5, 9}) {
+		t.Fatalf("pending %v", pending)
+	}
+
+	if deliver, _ := i.HandlePublish(3); deliver || i.State(3) != QoS2AwaitingPubrel {
+		t.Fatal("a restored packet id was delivered again")
+	}
+}
@@ -0,0 +1,78 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+/*
+ReceiveQuota is the receive side of the FlowController: it enforces the Receive Maximum we advertised on the QoS 1 and
+QoS 2 PUBLISH packets the peer sends. Acquire takes the packet id of an arriving PUBLISH, Release gives it back once
+its flow is complete on our side, with the PUBACK or the PUBCOMP sent. A peer that has more than Receive Maximum
+messages unacknowledged at once broke the protocol, Acquire then fails with ErrReceiveMaximumExceeded and the
+connection is closed with DISCONNECT Receive Maximum exceeded.
+
+The quota is taken per packet id, a PUBLISH sent again under an id that already holds quota (with DUP) does not count
+twice. The peer starts its send quota over with every network connection, so a ReceiveQuota belongs to one connection,
+a flow left over from the last one (a PUBREL for a QoS 2 message received before the reconnect) holds no quota.
+*/
+
+var ErrReceiveMaximumExceeded = errors.New("receive maximum exceeded")
+
+type ReceiveQuota struct {
+	mu             sync.Mutex
+	receiveMaximum uint16
+	held           map[uint16]struct{}
+}
+
+func NewReceiveQuota(receiveMaximum uint16) *ReceiveQuota {
+	if receiveMaximum == 0 {
+		receiveMaximum = DefaultReceiveMaximum
+	}
+
+	return &ReceiveQuota{receiveMaximum: receiveMaximum, held: make(map[uint16]struct{})}
+}
+
+// Acquire takes one unit of quota for the PUBLISH with packet id, it fails once Receive Maximum of them are held.
+func (q *ReceiveQuota) Acquire(id uint16) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.held[id]; ok {
+		return nil
+	}
+
+	if len(q.held) >= int(q.receiveMaximum) {
+		return fmt.Errorf("%w: more than %d QoS 1 and 2 messages unacknowledged", ErrReceiveMaximumExceeded, q.receiveMaximum)
+	}
+
+	q.held[id] = struct{}{}
+
+	return nil
+}
+
+// Release gives the quota of packet id back, it reports whether the id held any.
+func (q *ReceiveQuota) Release(id uint16) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.held[id]; !ok {
+		return false
+	}
+
+	delete(q.held, id)
+
+	return true
+}
+
+func (q *ReceiveQuota) InFlight() uint16 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return uint16(len(q.held))
+}
+
+func (q *ReceiveQuota) ReceiveMaximum() uint16 {
+	return q.receiveMaximum
+}
@@ -0,0 +1,29 @@
+package session
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestReceiveQuota(t *testing.T) {
+	var q = NewReceiveQuota(2)
+
+	for _, id := range []uint16{1, 2, 2} {
+		if err := q.Acquire(id); err != nil {
+			t.Fatalf("acquire %d: %v", id, err)
+		}
+	}
+
+	if err := q.Acquire(3); !errors.Is(err, ErrReceiveMaximumExceeded) {
+		t.Fatalf("acquire over the quota: %v", err)
+	}
+
+	// -- an id left over from another connection holds nothing
+	if q.Release(7) || !q.Release(1) || q.Release(1) {
+		t.Fatal("release does not match the held ids")
+	}
+
+	if err := q.Acquire(3); err != nil || q.InFlight() != 2 {
+		t.Fatalf("acquire after release: %v, %d in flight", err, q.InFlight())
+	}
+}
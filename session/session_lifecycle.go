@@ -0,0 +1,163 @@
+package session
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/clock"
+)
+
+/*
+SessionLifecycle runs the two MQTT 5 timers that start when a connection goes away.
+
+ - Session Expiry Interval: once it elapses the session state can be discarded, 0 ends the session on disconnect
+   and SessionNeverExpires keeps it forever.
+ - Will Delay Interval: the will is published once it elapses, or when the session ends if that happens first.
+   Reconnecting before either cancels the will.
+
+Callbacks run on the timer goroutine (or the one advancing a clock.Fake), OnWill always runs before OnExpire when both
+fire together.
+*/
+
+const SessionNeverExpires uint32 = math.MaxUint32
+
+type SessionLifecycle struct {
+	sync.Mutex
+	SessionExpiry uint32
+	WillDelay     uint32
+	OnExpire      func()
+	OnWill        func()
+	clock         clock.Clock
+	expiryTimer   clock.Timer
+	willTimer     clock.Timer
+	willArmed     bool
+	expired       bool
+	epoch         uint64
+}
+
+// NewSessionLifecycle runs the timers on clk, nil is clock.Real.
+func NewSessionLifecycle(sessionExpiry, willDelay uint32, clk clock.Clock) *SessionLifecycle {
+	return &SessionLifecycle{
+		SessionExpiry: sessionExpiry,
+		WillDelay:     willDelay,
+		clock:         clock.Or(clk),
+	}
+}
+
+// Disconnected starts the timers, withWill is false when the client sent a normal DISCONNECT which deletes the will.
+func (l *SessionLifecycle) Disconnected(withWill bool) {
+	l.Lock()
+
+	l.stopTimers()
+	l.epoch++
+	l.willArmed = withWill
+
+	var epoch = l.epoch
+
+	// -- A zero expiry ends the session right away which also publishes any will.
+	if l.SessionExpiry == 0 {
+		l.Unlock()
+		l.end(epoch)
+		return
+	}
+	// --
+
+	if withWill && l.WillDelay < l.SessionExpiry {
+		l.willTimer = l.clock.AfterFunc(seconds(l.WillDelay), func() { l.fireWill(epoch) })
+	}
+
+	if l.SessionExpiry != SessionNeverExpires {
+		l.expiryTimer = l.clock.AfterFunc(seconds(l.SessionExpiry), func() { l.end(epoch) })
+	}
+
+	l.Unlock()
+}
+
+// Reconnected cancels the timers and the will, it returns false when the session already expired.
+func (l *SessionLifecycle) Reconnected() bool {
+	l.Lock()
+	defer l.Unlock()
+
+	l.stopTimers()
+	l.epoch++
+	l.willArmed = false
+
+	if l.expired {
+		l.expired = false
+		return false
+	}
+
+	return true
+}
+
+// Stop cancels the timers and the will without ending the session, for a process that leaves the session to a
+// SessionStore as it shuts down.
+func (l *SessionLifecycle) Stop() {
+	l.Lock()
+	defer l.Unlock()
+
+	l.stopTimers()
+	l.epoch++
+	l.willArmed = false
+}
+
+func (l *SessionLifecycle) Expired() bool {
+	l.Lock()
+	defer l.Unlock()
+
+	return l.expired
+}
+
+func (l *SessionLifecycle) fireWill(epoch uint64) {
+	l.Lock()
+
+	if epoch != l.epoch || !l.willArmed {
+		l.Unlock()
+		return
+	}
+
+	l.willArmed = false
+	var onWill = l.OnWill
+	l.Unlock()
+
+	if onWill != nil {
+		onWill()
+	}
+}
+
+func (l *SessionLifecycle) end(epoch uint64) {
+	l.fireWill(epoch)
+
+	l.Lock()
+
+	if epoch != l.epoch || l.expired {
+		l.Unlock()
+		return
+	}
+
+	l.expired = true
+	l.stopTimers()
+	var onExpire = l.OnExpire
+	l.Unlock()
+
+	if onExpire != nil {
+		onExpire()
+	}
+}
+
+func (l *SessionLifecycle) stopTimers() {
+	if l.willTimer != nil {
+		l.willTimer.Stop()
+		l.willTimer = nil
+	}
+
+	if l.expiryTimer != nil {
+		l.expiryTimer.Stop()
+		l.expiryTimer = nil
+	}
+}
+
+func seconds(s uint32) time.Duration {
+	return time.Duration(s) * time.Second
+}
@@ -0,0 +1,56 @@
+package session
+
+import (
+	"testing"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/clock"
+)
+
+func TestSessionLifecycleFakeClock(t *testing.T) {
+	var c = clock.NewFake(time.Time{})
+	var l = NewSessionLifecycle(60, 10, c)
+	var events []string
+
+	l.OnWill = func() { events = append(events, "will") }
+	l.OnExpire = func() { events = append(events, "expire") }
+
+	l.Disconnected(true)
+	c.Advance(9 * time.Second)
+
+	if len(events) != 0 {
+		t.Fatalf("fired before the will delay: %v", events)
+	}
+
+	c.Advance(time.Second)
+
+	if len(events) != 1 || events[0] != "will" {
+		t.Fatalf("will delay: %v", events)
+	}
+
+	c.Advance(50 * time.Second)
+
+	if len(events) != 2 || events[1] != "expire" || !l.Expired() {
+		t.Fatalf("session expiry: %v", events)
+	}
+}
+
+func TestSessionLifecycleReconnected(t *testing.T) {
+	var c = clock.NewFake(time.Time{})
+	var l = NewSessionLifecycle(60, 0, c)
+
+	l.OnExpire = func() { t.Fatal("expired after the reconnect") }
+
+	l.Disconnected(false)
+	c.Advance(30 * time.Second)
+
+	if !l.Reconnected() {
+		t.Fatal("session gone before its expiry")
+	}
+
+	c.Advance(time.Hour)
+
+	if c.Pending() != 0 {
+		t.Fatalf("%d timers left after the reconnect", c.Pending())
+	}
+}
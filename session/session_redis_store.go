@@ -0,0 +1,154 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/redis"
+)
+
+/*
+A RedisStore keeps every SessionState as JSON under <prefix>session:<client id>, so several processes (broker instances
+behind one address, for example) share the sessions and one of them can resume what another one held. Save and Update
+keep the TTL of the key, Update is a WATCH/MULTI/EXEC transaction that is retried while another process changed the
+state in between, so fn may run more than once.
+
+It is an ExpiringStore: Expire maps a Session Expiry Interval onto the TTL of the key, Redis drops the state once it
+elapsed without the process holding the session having to be around, Persist takes the TTL off again.
+*/
+
+// ExpiringStore is a SessionStore that can drop a state by itself once it was not used for a while.
+type ExpiringStore interface {
+	SessionStore
+	// Expire drops the state of clientID after d unless Persist comes first.
+	Expire(clientID string, d time.Duration) error
+	// Persist keeps the state of clientID until it is deleted.
+	Persist(clientID string) error
+}
+
+var errTxAborted = errors.New("redis transaction aborted")
+
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisStore keeps the states in the Redis of client under keys that start with prefix.
+func NewRedisStore(client *redis.Client, prefix string) *RedisStore {
+	return &RedisStore{client: client, prefix: prefix}
+}
+
+func (r *RedisStore) Save(state *SessionState) error {
+	data, err := json.Marshal(state)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = r.client.Do("SET", r.key(state.ClientID), string(data), "KEEPTTL")
+
+	return err
+}
+
+func (r *RedisStore) Load(clientID string) (*SessionState, error) {
+	reply, err := r.client.Do("GET", r.key(clientID))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if reply == nil {
+		return nil, ErrSessionNotFound
+	}
+
+	data, _ := reply.([]byte)
+
+	return decodeSessionState(data)
+}
+
+func (r *RedisStore) Update(clientID string, fn func(state *SessionState)) error {
+	for {
+		var err = r.client.Exclusive(func(do func(args ...string) (any, error)) error {
+			return r.update(do, clientID, fn)
+		})
+
+		if !errors.Is(err, errTxAborted) {
+			return err
+		}
+	}
+}
+
+// update runs one try of Update on the connection of do.
+func (r *RedisStore) update(do func(args ...string) (any, error), clientID string, fn func(state *SessionState)) error {
+	var key = r.key(clientID)
+
+	if _, err := do("WATCH", key); err != nil {
+		return err
+	}
+
+	reply, err := do("GET", key)
+
+	if err != nil {
+		return err
+	}
+
+	var state = &SessionState{ClientID: clientID}
+
+	if data, ok := reply.([]byte); ok {
+		if state, err = decodeSessionState(data); err != nil {
+			do("UNWATCH")
+			return err
+		}
+	}
+
+	fn(state)
+
+	data, err := json.Marshal(state)
+
+	if err != nil {
+		do("UNWATCH")
+		return err
+	}
+
+	if _, err = do("MULTI"); err != nil {
+		return err
+	}
+
+	if _, err = do("SET", key, string(data), "KEEPTTL"); err != nil {
+		do("DISCARD")
+		return err
+	}
+
+	if reply, err = do("EXEC"); err != nil {
+		return err
+	}
+
+	if reply == nil {
+		return errTxAborted
+	}
+
+	return nil
+}
+
+func (r *RedisStore) Delete(clientID string) error {
+	_, err := r.client.Do("DEL", r.key(clientID))
+
+	return err
+}
+
+func (r *RedisStore) Expire(clientID string, d time.Duration) error {
+	_, err := r.client.Do("PEXPIRE", r.key(clientID), redis.Milliseconds(d))
+
+	return err
+}
+
+func (r *RedisStore) Persist(clientID string) error {
+	_, err := r.client.Do("PERSIST", r.key(clientID))
+
+	return err
+}
+
+func (r *RedisStore) key(clientID string) string {
+	return r.prefix + "session:" + clientID
+}
@@ -0,0 +1,89 @@
+package session
+
+import (
+	"sync"
+
+	"github.com/MarcusOuelletus/demo/reasoncodes"
+)
+
+/*
+The registry makes sure there is only ever one live session per client id.
+
+When a second connection registers with a client id that is already live, the old session is told to
+disconnect with Session taken over (0x8E) and, unless the new connection asked for a clean start, its
+state is handed to the new session. The swap happens under the registry lock so no other connection can
+observe two live sessions or a session without its state.
+*/
+
+const ReasonSessionTakenOver = byte(reasoncodes.SessionTakenOver)
+
+type RegisteredSession struct {
+	ClientID   string
+	State      *SessionState
+	Disconnect func(reason byte)
+}
+
+type SessionRegistry struct {
+	sync.Mutex
+	sessions map[string]*RegisteredSession
+}
+
+func NewSessionRegistry() *SessionRegistry {
+	return &SessionRegistry{sessions: make(map[string]*RegisteredSession)}
+}
+
+// Register makes s the live session for its client id and returns whether state from a previous session was
+// carried over (Session Present).
+func (r *SessionRegistry) Register(s *RegisteredSession, cleanStart bool) bool {
+	var present bool
+
+	r.Lock()
+	var old = r.sessions[s.ClientID]
+	r.sessions[s.ClientID] = s
+
+	if old != nil && !cleanStart && old.State != nil {
+		s.State = old.State
+		s.State.ClientID = s.ClientID
+		present = true
+	}
+
+	if old != nil {
+		old.State = nil
+	}
+	r.Unlock()
+
+	// -- The old session's disconnect runs outside the lock since it will usually call Remove.
+	if old != nil && old.Disconnect != nil {
+		old.Disconnect(ReasonSessionTakenOver)
+	}
+	// --
+
+	return present
+}
+
+// Remove only removes s if it is still the live session, so a session that was taken over can't remove its successor.
+func (r *SessionRegistry) Remove(s *RegisteredSession) bool {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.sessions[s.ClientID] != s {
+		return false
+	}
+
+	delete(r.sessions, s.ClientID)
+	return true
+}
+
+func (r *SessionRegistry) Get(clientID string) (*RegisteredSession, bool) {
+	r.Lock()
+	s, ok := r.sessions[clientID]
+	r.Unlock()
+	return s, ok
+}
+
+func (r *SessionRegistry) Len() int {
+	r.Lock()
+	defer r.Unlock()
+
+	return len(r.sessions)
+}
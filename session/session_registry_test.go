@@ -0,0 +1,56 @@
+package session
+
+import "testing"
+
+func TestSessionRegistryTakeover(t *testing.T) {
+	var r = NewSessionRegistry()
+	var reasons []byte
+
+	var old = &RegisteredSession{
+		ClientID:   "a",
+		State:      &SessionState{ClientID: "a"},
+		Disconnect: func(reason byte) { reasons = append(reasons, reason) },
+	}
+
+	if r.Register(old, false) {
+		t.Fatal("the first session found state to carry over")
+	}
+
+	// -- the new connection takes the state over, the old one is told why it goes
+	var next = &RegisteredSession{ClientID: "a"}
+
+	if !r.Register(next, false) || next.State == nil || old.State != nil {
+		t.Fatal("the state was not handed over")
+	}
+
+	if len(reasons) != 1 || reasons[0] != ReasonSessionTakenOver {
+		t.Fatalf("the old session was disconnected with %v", reasons)
+	}
+	// --
+
+	// -- the old session going away late does not remove its successor
+	if r.Remove(old) {
+		t.Fatal("the old session removed the live one")
+	}
+
+	if s, ok := r.Get("a"); !ok || s != next || r.Len() != 1 {
+		t.Fatal("the new session is not the live one")
+	}
+	// --
+
+	if !r.Remove(next) || r.Len() != 0 {
+		t.Fatal("the live session was not removed")
+	}
+}
+
+func TestSessionRegistryCleanStart(t *testing.T) {
+	var r = NewSessionRegistry()
+
+	r.Register(&RegisteredSession{ClientID: "a", State: &SessionState{ClientID: "a"}}, false)
+
+	var next = &RegisteredSession{ClientID: "a"}
+
+	if r.Register(next, true) || next.State != nil {
+		t.Fatal("a clean start carried the old state over")
+	}
+}
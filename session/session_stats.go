@@ -0,0 +1,135 @@
+package session
+
+import (
+	"sync"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/broadcast"
+	"github.com/MarcusOuelletus/demo/clock"
+	"github.com/MarcusOuelletus/demo/packetids"
+)
+
+/*
+Session groups the components that make up one client session so they can be inspected together, every component is
+optional. The connection code calls PacketSent and PacketReceived for every packet it writes or reads, SessionStats then
+combines those counters with what each component reports into one snapshot.
+*/
+
+type Session struct {
+	ClientID      string
+	PacketIDs     *packetids.PacketIDs
+	Broadcaster   *broadcast.ResponseBroadcaster
+	Inflight      *InflightStore
+	Flow          *FlowController
+	Keepalive     *Keepalive
+	Subscriptions *SubscriptionManager
+	OutboundQoS2  *OutboundQoS2Flow
+	InboundQoS2   *InboundQoS2Flow
+	// Clock stamps LastSent and LastReceived, nil is clock.Real.
+	Clock clock.Clock
+
+	mu               sync.Mutex
+	bytesSent        int64
+	bytesReceived    int64
+	messagesSent     int64
+	messagesReceived int64
+	lastSent         time.Time
+	lastReceived     time.Time
+}
+
+type SessionStats struct {
+	ClientID         string
+	BytesSent        int64
+	BytesReceived    int64
+	MessagesSent     int64
+	MessagesReceived int64
+	Inflight         int
+	Retransmissions  int64
+	SendQuota        uint16
+	PendingQoS2Out   int
+	PendingQoS2In    int
+	PacketIDsWaiting int64
+	Subscriptions    int
+	LastSent         time.Time
+	LastReceived     time.Time
+	KeepaliveDead    bool
+}
+
+// PacketSent records a written packet, isPublish counts it as a message as well.
+func (s *Session) PacketSent(size int, isPublish bool) {
+	s.mu.Lock()
+	s.bytesSent += int64(size)
+	if isPublish {
+		s.messagesSent++
+	}
+	s.lastSent = clock.Or(s.Clock).Now()
+	s.mu.Unlock()
+
+	if s.Keepalive != nil {
+		s.Keepalive.Sent()
+	}
+}
+
+func (s *Session) PacketReceived(size int, isPublish bool) {
+	s.mu.Lock()
+	s.bytesReceived += int64(size)
+	if isPublish {
+		s.messagesReceived++
+	}
+	s.lastReceived = clock.Or(s.Clock).Now()
+	s.mu.Unlock()
+
+	if s.Keepalive != nil {
+		s.Keepalive.Received()
+	}
+}
+
+func (s *Session) SessionStats() SessionStats {
+	s.mu.Lock()
+	var stats = SessionStats{
+		ClientID:         s.ClientID,
+		BytesSent:        s.bytesSent,
+		BytesReceived:    s.bytesReceived,
+		MessagesSent:     s.messagesSent,
+		MessagesReceived: s.messagesReceived,
+		LastSent:         s.lastSent,
+		LastReceived:     s.lastReceived,
+	}
+	s.mu.Unlock()
+
+	if s.Inflight != nil {
+		stats.Inflight = s.Inflight.Len()
+		stats.Retransmissions = s.Inflight.Retransmissions()
+	}
+
+	if s.Flow != nil {
+		stats.SendQuota = s.Flow.Quota()
+	}
+
+	if s.OutboundQoS2 != nil {
+		stats.PendingQoS2Out = s.OutboundQoS2.Len()
+	}
+
+	if s.InboundQoS2 != nil {
+		stats.PendingQoS2In = s.InboundQoS2.Len()
+	}
+
+	if s.PacketIDs != nil {
+		stats.PacketIDsWaiting = s.PacketIDs.GetWaitListSize()
+	}
+
+	if s.Subscriptions != nil {
+		stats.Subscriptions = len(s.Subscriptions.Subscriptions())
+	}
+
+	if s.Keepalive != nil {
+		stats.KeepaliveDead = s.Keepalive.Dead()
+	}
+
+	return stats
+}
+
+// Healthy is true while the connection is alive and no publish is stuck waiting for a packet id.
+func (s SessionStats) Healthy() bool {
+	return !s.KeepaliveDead && s.PacketIDsWaiting == 0
+}
@@ -0,0 +1,223 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/MarcusOuelletus/demo/packetids"
+)
+
+/*
+A SessionStore persists everything needed to resume a session that was opened with Clean Start = 0
+after the process restarts: the subscriptions, the unacknowledged outbound messages, the packet id
+allocator and the inbound QoS 2 ids that are still waiting for PUBREL.
+
+The parts of a state are kept by different components, the SubscriptionManager and QoS2Dedup for example, each of them
+changes its part with Update so a change to one part never overwrites a concurrent change to another.
+*/
+
+var ErrSessionNotFound = errors.New("session not found")
+
+type Subscription struct {
+	Filter            string
+	QoS               byte
+	GrantedQoS        byte
+	NoLocal           bool
+	RetainAsPublished bool
+	RetainHandling    byte
+}
+
+type SessionState struct {
+	ClientID      string
+	Subscriptions []Subscription
+	Inflight      []*InflightMessage
+	PacketIDs     packetids.Snapshot
+	PendingQoS2   []uint16
+}
+
+type SessionStore interface {
+	Save(state *SessionState) error
+	Load(clientID string) (*SessionState, error)
+	// Update hands the state of clientID (an empty one when none was saved) to fn and saves it, no other Save or
+	// Update of the store runs in between.
+	Update(clientID string, fn func(state *SessionState)) error
+	Delete(clientID string) error
+}
+
+type MemoryStore struct {
+	sync.Mutex
+	sessions map[string][]byte
+}
+
+type FileStore struct {
+	sync.Mutex
+	dir string
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{sessions: make(map[string][]byte)}
+}
+
+// Save keeps the encoded state rather than the pointers so callers can't mutate what was saved.
+func (m *MemoryStore) Save(state *SessionState) error {
+	data, err := json.Marshal(state)
+
+	if err != nil {
+		return err
+	}
+
+	m.Lock()
+	m.sessions[state.ClientID] = data
+	m.Unlock()
+	return nil
+}
+
+func (m *MemoryStore) Update(clientID string, fn func(state *SessionState)) error {
+	m.Lock()
+	defer m.Unlock()
+
+	var state = &SessionState{ClientID: clientID}
+
+	if data, ok := m.sessions[clientID]; ok {
+		var err error
+
+		if state, err = decodeSessionState(data); err != nil {
+			return err
+		}
+	}
+
+	fn(state)
+
+	data, err := json.Marshal(state)
+
+	if err != nil {
+		return err
+	}
+
+	m.sessions[clientID] = data
+	return nil
+}
+
+func (m *MemoryStore) Load(clientID string) (*SessionState, error) {
+	m.Lock()
+	data, ok := m.sessions[clientID]
+	m.Unlock()
+
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+
+	return decodeSessionState(data)
+}
+
+func (m *MemoryStore) Delete(clientID string) error {
+	m.Lock()
+	delete(m.sessions, clientID)
+	m.Unlock()
+	return nil
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	return &FileStore{dir: dir}, nil
+}
+
+// Save writes to a temporary file and renames it over the old one so a crash never leaves a half written session.
+func (f *FileStore) Save(state *SessionState) error {
+	data, err := json.Marshal(state)
+
+	if err != nil {
+		return err
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	return f.write(state.ClientID, data)
+}
+
+func (f *FileStore) Load(clientID string) (*SessionState, error) {
+	f.Lock()
+	data, err := os.ReadFile(f.path(clientID))
+	f.Unlock()
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrSessionNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeSessionState(data)
+}
+
+func (f *FileStore) Update(clientID string, fn func(state *SessionState)) error {
+	f.Lock()
+	defer f.Unlock()
+
+	var state = &SessionState{ClientID: clientID}
+	data, err := os.ReadFile(f.path(clientID))
+
+	switch {
+	case err == nil:
+		if state, err = decodeSessionState(data); err != nil {
+			return err
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return err
+	}
+
+	fn(state)
+
+	if data, err = json.Marshal(state); err != nil {
+		return err
+	}
+
+	return f.write(clientID, data)
+}
+
+func (f *FileStore) Delete(clientID string) error {
+	f.Lock()
+	defer f.Unlock()
+
+	if err := os.Remove(f.path(clientID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// write replaces the file of clientID, f is locked.
+func (f *FileStore) write(clientID string, data []byte) error {
+	var path = f.path(clientID)
+	var tmp = path + ".tmp"
+
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func (f *FileStore) path(clientID string) string {
+	return filepath.Join(f.dir, url.PathEscape(clientID)+".json")
+}
+
+func decodeSessionState(data []byte) (*SessionState, error) {
+	var state SessionState
+
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("decoding session state: %w", err)
+	}
+
+	return &state, nil
+}
@@ -0,0 +1,199 @@
+package session
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/MarcusOuelletus/demo/packetids"
+)
+
+func testSessionState(clientID string) *SessionState {
+	var ids = packetids.New()
+
+	ids.Reserve()
+	ids.Reserve()
+	ids.Release(ids.Reserve().GetBytes())
+
+	return &SessionState{
+		ClientID: clientID,
+		Subscriptions: []Subscription{
+			{Filter: "a/+", QoS: 1, GrantedQoS: 1},
+			{Filter: "b/#", QoS: 2, GrantedQoS: 1, NoLocal: true, RetainAsPublished: true, RetainHandling: 2},
+		},
+		Inflight: []*InflightMessage{
+			{PacketID: 1, Topic: "a/1", Payload: []byte("x"), QoS: 1, Attempts: 2, SentAt: time.Unix(10, 0).UTC()},
+			{PacketID: 2, Topic: "b/2", QoS: 2, Pubrel: true, Attempts: 1, NextRetry: time.Unix(20, 0).UTC()},
+		},
+		PacketIDs:   ids.Snapshot(),
+		PendingQoS2: []uint16{7, 9},
+	}
+}
+
+func testSessionStores(t *testing.T) map[string]SessionStore {
+	files, err := NewFileStore(filepath.Join(t.TempDir(), "sessions"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string]SessionStore{"memory": NewMemoryStore(), "file": files}
+}
+
+func TestSessionStoreRoundTrip(t *testing.T) {
+	for name, store := range testSessionStores(t) {
+		t.Run(name, func(t *testing.T) {
+			// a client id with a path separator must not escape the store's directory
+			var want = testSessionState("plant/line 1")
+
+			if err := store.Save(want); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := store.Load(want.ClientID)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("loaded %+v, saved %+v", got, want)
+			}
+
+			// -- the packet id allocator resumes where it was saved
+			var ids = packetids.NewFromSnapshot(got.PacketIDs)
+
+			if id := ids.Reserve().Value; id != 3 {
+				t.Fatalf("first id after restore = %d, want the released 3", id)
+			}
+			// --
+
+			// -- what was saved can't be changed through the state passed to Save
+			want.Subscriptions[0].Filter = "changed"
+
+			if got, _ = store.Load(want.ClientID); got.Subscriptions[0].Filter != "a/+" {
+				t.Fatal("saved state changed with the caller's copy")
+			}
+			// --
+
+			if err = store.Delete(want.ClientID); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err = store.Load(want.ClientID); !errors.Is(err, ErrSessionNotFound) {
+				t.Fatalf("load after delete: %v", err)
+			}
+
+			if err = store.Delete(want.ClientID); err != nil {
+				t.Fatalf("deleting a missing session: %v", err)
+			}
+		})
+	}
+}
+
+func TestSessionStoreNotFound(t *testing.T) {
+	for name, store := range testSessionStores(t) {
+		if _, err := store.Load("missing"); !errors.Is(err, ErrSessionNotFound) {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestFileStoreCorruption(t *testing.T) {
+	var dir = t.TempDir()
+	store, err := NewFileStore(dir)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = store.Save(testSessionState("c")); err != nil {
+		t.Fatal(err)
+	}
+
+	if matches, _ := filepath.Glob(filepath.Join(dir, "*.tmp")); len(matches) != 0 {
+		t.Fatalf("temporary files left behind: %v", matches)
+	}
+
+	for _, content := range []string{"", "{", `{"ClientID": 5}`, "not json"} {
+		if err = os.WriteFile(store.path("c"), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = store.Load("c")
+
+		if err == nil || errors.Is(err, ErrSessionNotFound) {
+			t.Fatalf("loading %q: %v", content, err)
+		}
+	}
+
+	// -- a save over a corrupt file replaces it
+	if err = store.Save(testSessionState("c")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = store.Load("c"); err != nil {
+		t.Fatalf("load after overwriting a corrupt file: %v", err)
+	}
+	// --
+}
+
+func TestSessionStoreConcurrentParts(t *testing.T) {
+	for name, store := range testSessionStores(t) {
+		t.Run(name, func(t *testing.T) {
+			var subs = NewSubscriptionManager("c", store)
+			dedup, err := NewQoS2Dedup("c", store)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var done = make(chan error, 2)
+
+			go func() {
+				for i := 0; i < 50; i++ {
+					var filter = "f/" + strconv.Itoa(i)
+
+					subs.Requested(Subscription{Filter: filter, QoS: 1}, nil)
+
+					if err := subs.Granted(filter, 1); err != nil {
+						done <- err
+						return
+					}
+				}
+				done <- nil
+			}()
+
+			go func() {
+				for i := uint16(1); i <= 50; i++ {
+					if _, err := dedup.Publish(i); err != nil {
+						done <- err
+						return
+					}
+				}
+				done <- nil
+			}()
+
+			for i := 0; i < 2; i++ {
+				if err := <-done; err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			state, err := store.Load("c")
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(state.Subscriptions) != 50 || len(state.PendingQoS2) != 50 {
+				t.Fatalf("%d subscriptions and %d pending QoS 2 ids saved, want 50 of each", len(state.Subscriptions),
+					len(state.PendingQoS2))
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+	"github.com/MarcusOuelletus/demo/topictrie"
+)
+
+/*
+SubscriptionManager is the session's record of what it has subscribed to.
+
+ - Requested is called before SUBSCRIBE is sent, Granted with the matching SUBACK return code.
+ - Subscriptions are persisted through the SessionStore so a restarted process knows what to resubscribe to.
+ - Replay returns the subscriptions that have to be sent again after a CONNACK with Session Present = false.
+ - Route dispatches an inbound PUBLISH to the handlers of every matching filter using the topic trie. A shared
+   subscription ($share/{group}/{filter}) is routed by its filter.
+*/
+
+type InboundMessage struct {
+	Topic    string
+	Payload  []byte
+	QoS      byte
+	Retain   bool
+	Dup      bool
+	PacketID uint16
+	// Properties are the PUBLISH properties, nil on MQTT 3.1.1.
+	Properties *mqttcodec.Properties
+}
+
+type MessageHandler func(msg *InboundMessage)
+
+type ManagedSubscription struct {
+	Subscription
+	Granted bool
+	Handler MessageHandler
+}
+
+type SubscriptionManager struct {
+	sync.Mutex
+	clientID      string
+	store         SessionStore
+	subscriptions map[string]*ManagedSubscription
+	routes        *topictrie.Trie[ManagedSubscription]
+}
+
+func NewSubscriptionManager(clientID string, store SessionStore) *SubscriptionManager {
+	return &SubscriptionManager{
+		clientID:      clientID,
+		store:         store,
+		subscriptions: make(map[string]*ManagedSubscription),
+		routes:        topictrie.New[ManagedSubscription](),
+	}
+}
+
+// Load restores the persisted subscriptions, their handlers have to be attached again with SetHandler.
+func (m *SubscriptionManager) Load() error {
+	if m.store == nil {
+		return nil
+	}
+
+	state, err := m.store.Load(m.clientID)
+
+	if errors.Is(err, ErrSessionNotFound) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	for _, sub := range state.Subscriptions {
+		var managed = &ManagedSubscription{Subscription: sub, Granted: true}
+		m.subscriptions[sub.Filter] = managed
+		m.routes.Add(routeFilter(sub.Filter), sub.Filter, managed)
+	}
+
+	return nil
+}
+
+func (m *SubscriptionManager) Requested(sub Subscription, handler MessageHandler) {
+	m.Lock()
+	defer m.Unlock()
+
+	var managed = &ManagedSubscription{Subscription: sub, Handler: handler}
+
+	m.subscriptions[sub.Filter] = managed
+	m.routes.Add(routeFilter(sub.Filter), sub.Filter, managed)
+}
+
+// Granted applies a SUBACK return code, codes of 0x80 and above mean the subscription was refused and is dropped.
+func (m *SubscriptionManager) Granted(filter string, returnCode byte) error {
+	m.Lock()
+
+	var managed, ok = m.subscriptions[filter]
+
+	if !ok {
+		m.Unlock()
+		return fmt.Errorf("no subscription requested for filter %q", filter)
+	}
+
+	if returnCode >= 0x80 {
+		delete(m.subscriptions, filter)
+		m.routes.Remove(routeFilter(filter), filter)
+		m.Unlock()
+		return fmt.Errorf("subscription to %q refused with reason code 0x%02X", filter, returnCode)
+	}
+
+	managed.Granted = true
+	managed.GrantedQoS = returnCode
+	m.Unlock()
+
+	return m.persist()
+}
+
+func (m *SubscriptionManager) SetHandler(filter string, handler MessageHandler) bool {
+	m.Lock()
+	defer m.Unlock()
+
+	var managed, ok = m.subscriptions[filter]
+
+	if ok {
+		managed.Handler = handler
+	}
+
+	return ok
+}
+
+func (m *SubscriptionManager) Remove(filter string) error {
+	m.Lock()
+	delete(m.subscriptions, filter)
+	m.routes.Remove(routeFilter(filter), filter)
+	m.Unlock()
+
+	return m.persist()
+}
+
+func (m *SubscriptionManager) Get(filter string) (ManagedSubscription, bool) {
+	m.Lock()
+	defer m.Unlock()
+
+	var managed, ok = m.subscriptions[filter]
+
+	if !ok {
+		return ManagedSubscription{}, false
+	}
+
+	return *managed, true
+}
+
+// Replay returns every subscription sorted by filter, the caller sends them again as SUBSCRIBE packets and
+// reports the results through Granted.
+func (m *SubscriptionManager) Replay() []Subscription {
+	m.Lock()
+	defer m.Unlock()
+
+	var subs = m.list()
+
+	for _, managed := range m.subscriptions {
+		managed.Granted = false
+	}
+
+	return subs
+}
+
+// Route calls the handler of every subscription matching the message's topic and returns how many there were.
+func (m *SubscriptionManager) Route(msg *InboundMessage) int {
+	var handlers []MessageHandler
+
+	m.Lock()
+	m.routes.MatchEach(msg.Topic, func(_ string, managed *ManagedSubscription) {
+		if managed.Handler != nil {
+			handlers = append(handlers, managed.Handler)
+		}
+	})
+	m.Unlock()
+
+	for _, handler := range handlers {
+		handler(msg)
+	}
+
+	return len(handlers)
+}
+
+func (m *SubscriptionManager) Subscriptions() []Subscription {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.list()
+}
+
+func (m *SubscriptionManager) list() []Subscription {
+	var subs = make([]Subscription, 0, len(m.subscriptions))
+
+	for _, managed := range m.subscriptions {
+		subs = append(subs, managed.Subscription)
+	}
+
+	sort.Slice(subs, func(a, b int) bool { return subs[a].Filter < subs[b].Filter })
+
+	return subs
+}
+
+func (m *SubscriptionManager) persist() error {
+	if m.store == nil {
+		return nil
+	}
+
+	return m.store.Update(m.clientID, func(state *SessionState) {
+		m.Lock()
+		defer m.Unlock()
+
+		state.Subscriptions = nil
+
+		for _, sub := range m.list() {
+			if m.subscriptions[sub.Filter].Granted {
+				state.Subscriptions = append(state.Subscriptions, sub)
+			}
+		}
+	})
+}
+
+// routeFilter is the filter the messages of a subscription match, the one after $share/{group}/ for a shared one.
+func routeFilter(filter string) string {
+	if !strings.HasPrefix(filter, "$share/") {
+		return filter
+	}
+
+	if _, after, ok := strings.Cut(filter[len("$share/"):], "/"); ok {
+		return after
+	}
+
+	return filter
+}
@@ -0,0 +1,102 @@
+package session
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+)
+
+/*
+WillConfig is the will message from CONNECT, WillOf takes it from the packet. Attach hooks it into the session's
+lifecycle so it is published through the given function after the Will Delay Interval when the connection is lost,
+unless the client reconnects first. Publish is the PUBLISH that goes out, with the will properties but the Will Delay
+Interval, which only means something to the server.
+
+A normal DISCONNECT cancels the will, a DISCONNECT with reason code 0x04 (Disconnect with Will Message) keeps it, the
+connection code tells the lifecycle which one it got.
+*/
+
+type WillConfig struct {
+	Topic   string
+	Payload []byte
+	QoS     byte
+	Retain  bool
+	// Properties are the MQTT 5 will properties, nil on 3.1.1.
+	Properties *mqttcodec.Properties
+}
+
+// WillOf returns the will of connect, nil when it has none.
+func WillOf(connect *mqttcodec.Connect) *WillConfig {
+	if !connect.WillFlag {
+		return nil
+	}
+
+	return &WillConfig{
+		Topic:      connect.WillTopic,
+		Payload:    connect.WillMessage,
+		QoS:        connect.WillQoS,
+		Retain:     connect.WillRetain,
+		Properties: connect.WillProperties,
+	}
+}
+
+func (w *WillConfig) Validate() error {
+	if w.Topic == "" {
+		return fmt.Errorf("will topic is empty")
+	}
+
+	if strings.ContainsAny(w.Topic, "+#") {
+		return fmt.Errorf("will topic %q contains wildcards", w.Topic)
+	}
+
+	if w.QoS > 2 {
+		return fmt.Errorf("will qos %d is invalid", w.QoS)
+	}
+
+	if p := w.Properties; p != nil && p.PayloadFormatIndicator != nil && *p.PayloadFormatIndicator > 1 {
+		return fmt.Errorf("will payload format indicator %d is invalid", *p.PayloadFormatIndicator)
+	}
+
+	return nil
+}
+
+// Delay is the Will Delay Interval in seconds, 0 without one.
+func (w *WillConfig) Delay() uint32 {
+	if w.Properties == nil || w.Properties.WillDelayInterval == nil {
+		return 0
+	}
+
+	return *w.Properties.WillDelayInterval
+}
+
+// Publish builds the will PUBLISH.
+func (w *WillConfig) Publish() *mqttcodec.Publish {
+	var will = &mqttcodec.Publish{
+		TopicName: w.Topic,
+		Payload:   w.Payload,
+		QoS:       w.QoS,
+		Retain:    w.Retain,
+	}
+
+	if p := w.Properties; p != nil {
+		will.Properties = &mqttcodec.Properties{
+			PayloadFormatIndicator: p.PayloadFormatIndicator,
+			MessageExpiryInterval:  p.MessageExpiryInterval,
+			ContentType:            p.ContentType,
+			ResponseTopic:          p.ResponseTopic,
+			CorrelationData:        p.CorrelationData,
+			UserProperties:         p.UserProperties,
+		}
+	}
+
+	return will
+}
+
+// Attach makes l publish the will, the Will Delay Interval is taken from the will properties.
+func (w *WillConfig) Attach(l *SessionLifecycle, publish func(will *WillConfig)) {
+	l.Lock()
+	l.WillDelay = w.Delay()
+	l.OnWill = func() { publish(w) }
+	l.Unlock()
+}
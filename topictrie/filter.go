@@ -0,0 +1,89 @@
+package topictrie
+
+import (
+	"strings"
+
+	"github.com/MarcusOuelletus/demo/mqttcodec"
+)
+
+/*
+A Filter matches topic names against one topic filter without a trie, for code that has a handful of filters and
+checks them in turn. CompileFilter validates the filter as mqttcodec.ValidateFilter does for SUBSCRIBE and splits it
+into its levels once, Matches then walks the topic level by level without allocating, with the same rules as
+MatchEach:
+
+	f, err := topictrie.CompileFilter("sensors/+/temperature")
+
+	if err != nil {
+		return err
+	}
+
+	f.Matches("sensors/kitchen/temperature") // true
+
+A '+' or '#' first level does not match a topic starting with '$'. A shared subscription's $share/<group>/ prefix is
+part of the filter like any other level, strip it before compiling.
+*/
+
+type Filter struct {
+	filter string
+	// levels are the levels before a trailing '#', a '+' stays a "+" level.
+	levels []string
+	// multi is set for a filter ending in '#', it matches any number of levels after levels, none included.
+	multi bool
+}
+
+func CompileFilter(filter string) (*Filter, error) {
+	if err := mqttcodec.ValidateFilter(filter); err != nil {
+		return nil, err
+	}
+
+	var f = &Filter{filter: filter, levels: strings.Split(filter, "/")}
+
+	if f.levels[len(f.levels)-1] == "#" {
+		f.levels = f.levels[:len(f.levels)-1]
+		f.multi = true
+	}
+
+	return f, nil
+}
+
+// String returns the filter as it was compiled.
+func (f *Filter) String() string {
+	return f.filter
+}
+
+func (f *Filter) Matches(topic string) bool {
+	if topic == "" {
+		return false
+	}
+
+	if topic[0] == '$' && (f.filter[0] == '+' || f.filter[0] == '#') {
+		return false
+	}
+
+	// -- start is where the next level of topic begins, past the end once the last one was consumed
+	var start = 0
+
+	for _, level := range f.levels {
+		if start > len(topic) {
+			return false
+		}
+
+		var end = strings.IndexByte(topic[start:], '/')
+
+		if end < 0 {
+			end = len(topic)
+		} else {
+			end += start
+		}
+
+		if level != "+" && level != topic[start:end] {
+			return false
+		}
+
+		start = end + 1
+	}
+	// --
+
+	return f.multi || start > len(topic)
+}
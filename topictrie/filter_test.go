@@ -0,0 +1,117 @@
+package topictrie
+
+import (
+	"math/rand"
+	"testing"
+	"testing/quick"
+)
+
+func TestCompileFilter(t *testing.T) {
+	var tests = []struct {
+		filter string
+		valid  bool
+	}{
+		{"a/b", true},
+		{"#", true},
+		{"+", true},
+		{"a/+/c/#", true},
+		{"/", true},
+		{"", false},
+		{"a/#/c", false},
+		{"a#", false},
+		{"a/b+", false},
+		{"+a/b", false},
+	}
+
+	for _, test := range tests {
+		f, err := CompileFilter(test.filter)
+
+		if (err == nil) != test.valid {
+			t.Errorf("CompileFilter(%q) returned %v", test.filter, err)
+			continue
+		}
+
+		if err == nil && f.String() != test.filter {
+			t.Errorf("CompileFilter(%q).String() is %q", test.filter, f.String())
+		}
+	}
+}
+
+func TestFilterMatches(t *testing.T) {
+	var tests = []struct {
+		filter string
+		topic  string
+		want   bool
+	}{
+		{"a/b", "a/b", true},
+		{"a/b", "a/b/c", false},
+		{"a/b", "a", false},
+		{"a/+", "a/b", true},
+		{"a/+", "a/", true},
+		{"a/+", "a", false},
+		{"a/+", "a/b/c", false},
+		{"+/+", "/", true},
+		{"a/#", "a", true},
+		{"a/#", "a/b/c", true},
+		{"a/#", "ab", false},
+		{"#", "a/b", true},
+		{"#", "$SYS/a", false},
+		{"+/a", "$SYS/a", false},
+		{"$SYS/#", "$SYS/a", true},
+		{"a//b", "a//b", true},
+		{"a/+/b", "a//b", true},
+		{"a", "", false},
+	}
+
+	for _, test := range tests {
+		f, err := CompileFilter(test.filter)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := f.Matches(test.topic); got != test.want {
+			t.Errorf("%q matching %q is %t, want %t", test.filter, test.topic, got, test.want)
+		}
+	}
+}
+
+// TestFilterMatchesProperty checks Matches against matches, the level by level rule the trie is tested with.
+func TestFilterMatchesProperty(t *testing.T) {
+	var property = func(seed int64) bool {
+		var r = rand.New(rand.NewSource(seed))
+		var filter, topic = randomName(r, filterLevels, true), randomName(r, topicLevels, false)
+
+		f, err := CompileFilter(filter)
+
+		if err != nil {
+			t.Logf("CompileFilter(%q): %v", filter, err)
+			return false
+		}
+
+		if f.Matches(topic) != matches(filter, topic) {
+			t.Logf("%q matching %q is %t", filter, topic, f.Matches(topic))
+			return false
+		}
+
+		return true
+	}
+
+	if err := quick.Check(property, &quick.Config{MaxCount: 5000}); err != nil {
+		t.Error(err)
+	}
+}
+
+func BenchmarkFilterMatches(b *testing.B) {
+	f, err := CompileFilter("site/+/building/+/floor/#")
+
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		f.Matches("site/3/building/2/floor/4/device/7/temperature")
+	}
+}
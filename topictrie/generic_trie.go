@@ -1,10 +1,6 @@
-package trie
+package topictrie
 
-import (
-	"fmt"
-	"../modules/logger"
-	"github.com/sirupsen/logrus"
-)
+import "github.com/MarcusOuelletus/demo/modules/logger"
 
 /*
 This is a trie which maps topic subscriptions with subscribed users.
@@ -23,7 +19,9 @@ type nodeChild[T any] struct {
 }
 
 type Trie[T any] struct {
-	root *node[T]
+	// Logger gets the adds and removes at debug level, nil logs nothing.
+	Logger logger.Logger
+	root   *node[T]
 }
 
 func New[T any]() *Trie[T] {
@@ -50,6 +48,10 @@ func (t *Trie[T]) Remove(name, userID string) *T {
 	delete(current.UserIDs, userID)
 	t.cleanTopicPath(current)
 
+	if logger.Enabled(t.Logger, logger.DebugLevel) {
+		t.Logger.Debug("trie: removed", logger.Fields{logger.TopicField: name, "user_id": userID})
+	}
+
 	return subscription
 }
 
@@ -68,6 +70,10 @@ func (t *Trie[T]) Add(name, userID string, data *T) {
 	}
 
 	current.UserIDs[userID] = data
+
+	if logger.Enabled(t.Logger, logger.DebugLevel) {
+		t.Logger.Debug("trie: added", logger.Fields{logger.TopicField: name, "user_id": userID})
+	}
 }
 
 func (t *Trie[T]) Get(name string) map[string]*T {
@@ -0,0 +1,186 @@
+package topictrie
+
+import "unicode/utf8"
+
+/*
+MatchEach walks every subscription whose filter matches a topic name, following the MQTT wildcard rules:
+
+ - '+' matches exactly one topic level, '#' matches the parent level and any number of levels below it.
+ - Wildcards in the first level never match topic names starting with '$' ($SYS and friends).
+
+Filters are stored letter by letter like any other name, so a wildcard is just a child node keyed by '+' or '#'
+that sits at the start of a level.
+*/
+
+func (t *Trie[T]) MatchEach(topic string, fn func(userID string, data *T)) {
+	if topic == "" {
+		return
+	}
+
+	t.match(t.root, topic, 0, true, fn)
+}
+
+func (t *Trie[T]) Match(topic string) map[string]*T {
+	var matches = make(map[string]*T)
+
+	t.MatchEach(topic, func(userID string, data *T) {
+		matches[userID] = data
+	})
+
+	return matches
+}
+
+// MatchEachExcluding is MatchEach without the subscriptions of publisherID that asked not to get their own client's
+// messages, MQTT 5's No Local: noLocal says whether one did, a nil noLocal leaves out every subscription of
+// publisherID.
+func (t *Trie[T]) MatchEachExcluding(topic, publisherID string, noLocal func(data *T) bool, fn func(userID string, data *T)) {
+	t.MatchEach(topic, func(userID string, data *T) {
+		if userID == publisherID && (noLocal == nil || noLocal(data)) {
+			return
+		}
+
+		fn(userID, data)
+	})
+}
+
+// MatchExcluding is Match with the subscriptions MatchEachExcluding leaves out left out.
+func (t *Trie[T]) MatchExcluding(topic, publisherID string, noLocal func(data *T) bool) map[string]*T {
+	var matches = make(map[string]*T)
+
+	t.MatchEachExcluding(topic, publisherID, noLocal, func(userID string, data *T) {
+		matches[userID] = data
+	})
+
+	return matches
+}
+
+func (t *Trie[T]) match(n *node[T], topic string, i int, levelStart bool, fn func(string, *T)) {
+	var wildcardsAllowed = i != 0 || topic[0] != '$'
+
+	if levelStart && wildcardsAllowed {
+		// -- '#' swallows the rest of the topic, including an empty remainder.
+		if hash := n.Children['#']; hash != nil {
+			emitUsers(hash, fn)
+		}
+		// --
+
+		// -- '+' swallows this level, matching resumes at the '/' that ends it, or the end of the topic.
+		if plus := n.Children['+']; plus != nil {
+			var end = i
+
+			for end < len(topic) && topic[end] != '/' {
+				end++
+			}
+
+			t.matchAfterLevel(plus, topic, end, fn)
+		}
+		// --
+	}
+
+	if i == len(topic) {
+		t.matchAfterLevel(n, topic, i, fn)
+		return
+	}
+
+	var letter, size = utf8.DecodeRuneInString(topic[i:])
+
+	if child := n.Children[letter]; child != nil {
+		t.match(child, topic, i+size, letter == '/', fn)
+	}
+}
+
+// matchAfterLevel continues from n with the topic consumed up to a level boundary at i.
+func (t *Trie[T]) matchAfterLevel(n *node[T], topic string, i int, fn func(string, *T)) {
+	if i < len(topic) {
+		if slash := n.Children['/']; slash != nil {
+			t.match(slash, topic, i+1, true, fn)
+		}
+		return
+	}
+
+	emitUsers(n, fn)
+
+	// -- "sport/#" also matches "sport".
+	if slash := n.Children['/']; slash != nil {
+		if hash := slash.Children['#']; hash != nil {
+			emitUsers(hash, fn)
+		}
+	}
+	// --
+}
+
+func emitUsers[T any](n *node[T], fn func(string, *T)) {
+	for userID, data := range n.UserIDs {
+		fn(userID, data)
+	}
+}
+
+// MatchFilterEach is MatchEach the other way round, the trie holds topic names and filter selects them with the same
+// wildcard rules. The broker's retained messages are looked up this way when a subscription is made.
+func (t *Trie[T]) MatchFilterEach(filter string, fn func(userID string, data *T)) {
+	if filter == "" {
+		return
+	}
+
+	t.matchFilter(t.root, filter, 0, true, fn)
+}
+
+func (t *Trie[T]) matchFilter(n *node[T], filter string, i int, levelStart bool, fn func(string, *T)) {
+	if levelStart && i < len(filter) {
+		switch filter[i] {
+		case '#':
+			emitSubtree(n, i == 0, fn)
+			return
+		case '+':
+			// -- '+' also matches an empty level, so the level may end right at n.
+			t.matchFilter(n, filter, i+1, false, fn)
+
+			for letter, child := range n.Children {
+				if letter != '/' && (i != 0 || letter != '$') {
+					t.matchFilterLevel(child, filter, i+1, fn)
+				}
+			}
+			// --
+			return
+		}
+	}
+
+	if i == len(filter) {
+		emitUsers(n, fn)
+		return
+	}
+
+	// -- "sport/#" also matches "sport".
+	if filter[i:] == "/#" {
+		emitUsers(n, fn)
+	}
+	// --
+
+	var letter, size = utf8.DecodeRuneInString(filter[i:])
+
+	if child := n.Children[letter]; child != nil {
+		t.matchFilter(child, filter, i+size, letter == '/', fn)
+	}
+}
+
+// matchFilterLevel lets a '+' consume the rest of a level from n on, matching resumes at every point the level can end.
+func (t *Trie[T]) matchFilterLevel(n *node[T], filter string, i int, fn func(string, *T)) {
+	t.matchFilter(n, filter, i, false, fn)
+
+	for letter, child := range n.Children {
+		if letter != '/' {
+			t.matchFilterLevel(child, filter, i, fn)
+		}
+	}
+}
+
+// emitSubtree emits n and everything below it, at the root names starting with '$' are left out.
+func emitSubtree[T any](n *node[T], root bool, fn func(string, *T)) {
+	emitUsers(n, fn)
+
+	for letter, child := range n.Children {
+		if !root || letter != '$' {
+			emitSubtree(child, false, fn)
+		}
+	}
+}
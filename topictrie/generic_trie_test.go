@@ -0,0 +1,409 @@
+package topictrie
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"testing/quick"
+)
+
+/*
+The benchmarks run on two corpora shaped like real deployments:
+
+ - devices is a deep device tree, site/<s>/building/<b>/floor/<f>/device/<d>/<metric>, every device subscribed to
+   its own topics by name and a few dashboards subscribed with wildcards.
+ - wildcards is a tree where most filters have a '+' or a '#', at every depth, so a Match follows many branches.
+
+Every filter has its own subscriber. The topics matched are the published ones of the same tree, so they share the
+prefixes of the filters.
+*/
+
+type corpus struct {
+	name    string
+	filters []string
+	topics  []string
+}
+
+var metrics = []string{"temperature", "humidity", "power", "status"}
+
+func devices() corpus {
+	var c = corpus{name: "devices"}
+
+	for s := 0; s < 4; s++ {
+		for bl := 0; bl < 5; bl++ {
+			for f := 0; f < 5; f++ {
+				for d := 0; d < 10; d++ {
+					for _, m := range metrics {
+						var topic = fmt.Sprintf("site/%d/building/%d/floor/%d/device/%d/%s", s, bl, f, d, m)
+
+						c.filters = append(c.filters, topic)
+						c.topics = append(c.topics, topic)
+					}
+				}
+
+				c.filters = append(c.filters, fmt.Sprintf("site/%d/building/%d/floor/%d/#", s, bl, f))
+			}
+		}
+
+		c.filters = append(c.filters, fmt.Sprintf("site/%d/+/+/+/+/device/+/status", s))
+	}
+
+	return c
+}
+
+func wildcards() corpus {
+	var c = corpus{name: "wildcards"}
+
+	for s := 0; s < 8; s++ {
+		for d := 0; d < 50; d++ {
+			for _, m := range metrics {
+				c.topics = append(c.topics, fmt.Sprintf("site/%d/device/%d/%s", s, d, m))
+			}
+
+			c.filters = append(c.filters,
+				fmt.Sprintf("site/%d/device/%d/#", s, d),
+				fmt.Sprintf("site/+/device/%d/+", d),
+				fmt.Sprintf("+/%d/+/%d/status", s, d),
+			)
+		}
+
+		for _, m := range metrics {
+			c.filters = append(c.filters, fmt.Sprintf("site/%d/device/+/%s", s, m), fmt.Sprintf("+/+/+/+/%s", m))
+		}
+
+		c.filters = append(c.filters, fmt.Sprintf("site/%d/#", s))
+	}
+
+	c.filters = append(c.filters, "#", "site/#", "+/+/+/+/+")
+
+	return c
+}
+
+func (c corpus) trie() *Trie[int] {
+	var t = New[int]()
+
+	for i, filter := range c.filters {
+		t.Add(filter, fmt.Sprint("user-", i), &i)
+	}
+
+	return t
+}
+
+func BenchmarkAdd(b *testing.B) {
+	for _, c := range []corpus{devices(), wildcards()} {
+		b.Run(c.name, func(b *testing.B) {
+			var data = 1
+
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				var t = New[int]()
+
+				for _, filter := range c.filters {
+					t.Add(filter, "user", &data)
+				}
+			}
+
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*len(c.filters)), "ns/filter")
+		})
+	}
+}
+
+func BenchmarkMatch(b *testing.B) {
+	for _, c := range []corpus{devices(), wildcards()} {
+		var t = c.trie()
+
+		b.Run(c.name, func(b *testing.B) {
+			var matched int
+
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				t.MatchEach(c.topics[i%len(c.topics)], func(string, *int) { matched++ })
+			}
+
+			b.ReportMetric(float64(matched)/float64(b.N), "subscribers/op")
+		})
+	}
+}
+
+// BenchmarkMatchMap is Match, which collects the subscribers into a map instead of calling back.
+func BenchmarkMatchMap(b *testing.B) {
+	for _, c := range []corpus{devices(), wildcards()} {
+		var t = c.trie()
+
+		b.Run(c.name, func(b *testing.B) {
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				t.Match(c.topics[i%len(c.topics)])
+			}
+		})
+	}
+}
+
+// BenchmarkAddRemove is the churn of clients subscribing and unsubscribing over a populated trie.
+func BenchmarkAddRemove(b *testing.B) {
+	for _, c := range []corpus{devices(), wildcards()} {
+		var t = c.trie()
+		var data = 1
+
+		b.Run(c.name, func(b *testing.B) {
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				var filter = c.filters[i%len(c.filters)]
+
+				t.Add(filter, "churn", &data)
+				t.Remove(filter, "churn")
+			}
+		})
+	}
+}
+
+/*
+The property tests check the trie against a brute force model: a map from every filter to its subscribers, and
+matches, which applies the MQTT wildcard rules level by level. Random runs of Add and Remove over a small alphabet (so
+filters share prefixes, levels are empty, '+' and '#' sit at every depth and names start with '$') are followed, after
+every step, by a MatchEach of every topic of the run compared with a scan of the model. Removing everything has to leave
+an empty root, a removed subscription must not keep its path alive.
+*/
+
+var (
+	filterLevels = []string{"a", "b", "ab", "", "+", "$s"}
+	topicLevels  = []string{"a", "b", "ab", "", "$s", "c"}
+	users        = []string{"u1", "u2", "u3"}
+)
+
+// matches is the MQTT rule for filter and topic, written out level by level.
+func matches(filter, topic string) bool {
+	var f, n = strings.Split(filter, "/"), strings.Split(topic, "/")
+
+	if strings.HasPrefix(topic, "$") && (f[0] == "+" || f[0] == "#") {
+		return false
+	}
+
+	for i, level := range f {
+		if level == "#" {
+			return true
+		}
+
+		if i >= len(n) || level != "+" && level != n[i] {
+			return false
+		}
+	}
+
+	return len(f) == len(n)
+}
+
+func randomName(r *rand.Rand, levels []string, wildcard bool) string {
+	var parts = make([]string, 1+r.Intn(4))
+
+	for i := range parts {
+		parts[i] = levels[r.Intn(len(levels))]
+	}
+
+	if wildcard && r.Intn(4) == 0 {
+		parts = append(parts, "#")
+	}
+
+	var name = strings.Join(parts, "/")
+
+	if name == "" {
+		return "a"
+	}
+
+	return name
+}
+
+type trieOp struct {
+	remove bool
+	filter string
+	user   string
+}
+
+type trieRun struct {
+	ops    []trieOp
+	topics []string
+}
+
+func (trieRun) Generate(r *rand.Rand, size int) reflect.Value {
+	var run trieRun
+	var added []string
+
+	for i := 0; i < 1+r.Intn(4*size+1); i++ {
+		var op = trieOp{user: users[r.Intn(len(users))], filter: randomName(r, filterLevels, true)}
+
+		// -- removes mostly hit a filter that was added, so paths get cleaned up
+		if len(added) > 0 && r.Intn(3) == 0 {
+			op.remove = true
+
+			if r.Intn(4) != 0 {
+				op.filter = added[r.Intn(len(added))]
+			}
+		}
+		// --
+
+		if !op.remove {
+			added = append(added, op.filter)
+		}
+
+		run.ops = append(run.ops, op)
+	}
+
+	for i := 0; i < 1+r.Intn(size+1); i++ {
+		run.topics = append(run.topics, randomName(r, topicLevels, false))
+	}
+
+	// -- the topics of the filters themselves, wildcards read as plain levels
+	for _, filter := range added {
+		if topic := strings.NewReplacer("+", "a", "#", "b").Replace(filter); !strings.ContainsAny(topic, "+#") {
+			run.topics = append(run.topics, topic)
+		}
+	}
+	// --
+
+	return reflect.ValueOf(run)
+}
+
+// matched is a sorted list of user, data pairs, what MatchEach calls back with, data is unique per Add.
+func matched(each func(fn func(string, *int))) []string {
+	var got []string
+
+	each(func(user string, data *int) {
+		got = append(got, fmt.Sprint(user, "=", *data))
+	})
+
+	sort.Strings(got)
+	return got
+}
+
+func TestMatchProperty(t *testing.T) {
+	var property = func(run trieRun) bool {
+		var tr = New[int]()
+		var model = make(map[string]map[string]*int)
+
+		for i, op := range run.ops {
+			if op.remove {
+				tr.Remove(op.filter, op.user)
+				delete(model[op.filter], op.user)
+			} else {
+				var data = i
+
+				tr.Add(op.filter, op.user, &data)
+
+				if model[op.filter] == nil {
+					model[op.filter] = make(map[string]*int)
+				}
+
+				model[op.filter][op.user] = &data
+			}
+
+			for _, topic := range run.topics {
+				var got = matched(func(fn func(string, *int)) { tr.MatchEach(topic, fn) })
+
+				var want = matched(func(fn func(string, *int)) {
+					for filter, subscribers := range model {
+						if matches(filter, topic) {
+							for user, data := range subscribers {
+								fn(user, data)
+							}
+						}
+					}
+				})
+
+				if !slices.Equal(got, want) {
+					t.Logf("after %+v: %q matched %v, want %v", run.ops[:i+1], topic, got, want)
+					return false
+				}
+			}
+		}
+
+		// -- everything removed leaves nothing behind
+		for filter, subscribers := range model {
+			for user := range subscribers {
+				tr.Remove(filter, user)
+			}
+		}
+
+		if len(tr.root.Children) != 0 {
+			t.Logf("after %+v and removing every subscription the root has %d children", run.ops, len(tr.root.Children))
+			return false
+		}
+		// --
+
+		return true
+	}
+
+	if err := quick.Check(property, &quick.Config{MaxCount: 500}); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestMatchFilterProperty is the same for MatchFilterEach, the trie holds topic names and the filters select them.
+func TestMatchFilterProperty(t *testing.T) {
+	var property = func(run trieRun) bool {
+		var tr = New[int]()
+		var names = make(map[string]*int)
+
+		for i, topic := range run.topics {
+			var data = i
+
+			tr.Add(topic, "retained", &data)
+			names[topic] = &data
+		}
+
+		for _, op := range run.ops {
+			var got = matched(func(fn func(string, *int)) { tr.MatchFilterEach(op.filter, fn) })
+
+			var want = matched(func(fn func(string, *int)) {
+				for name, data := range names {
+					if matches(op.filter, name) {
+						fn("retained", data)
+					}
+				}
+			})
+
+			if !slices.Equal(got, want) {
+				t.Logf("names %q: %q matched %v, want %v", run.topics, op.filter, got, want)
+				return false
+			}
+		}
+
+		return true
+	}
+
+	if err := quick.Check(property, &quick.Config{MaxCount: 500}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMatchExcluding(t *testing.T) {
+	type sub struct{ noLocal bool }
+
+	var tr = New[sub]()
+
+	tr.Add("a/+", "publisher", &sub{noLocal: true})
+	tr.Add("a/#", "other", &sub{noLocal: true})
+	tr.Add("a/b", "local", &sub{})
+
+	var got = tr.MatchExcluding("a/b", "publisher", func(s *sub) bool { return s.noLocal })
+
+	if len(got) != 2 || got["publisher"] != nil {
+		t.Errorf("publisher's No Local subscription matched: %v", got)
+	}
+
+	// -- "local" did not ask for No Local, it gets its own messages
+	if got = tr.MatchExcluding("a/b", "local", func(s *sub) bool { return s.noLocal }); len(got) != 3 {
+		t.Errorf("%d subscriptions matched, want 3", len(got))
+	}
+	// --
+
+	if got = tr.MatchExcluding("a/b", "local", nil); len(got) != 2 || got["local"] != nil {
+		t.Errorf("nil noLocal kept the publisher: %v", got)
+	}
+}